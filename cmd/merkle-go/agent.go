@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"merkle-go/internal/compare"
+	"merkle-go/internal/config"
+	"merkle-go/internal/fleet"
+	"merkle-go/internal/notify"
+	"merkle-go/internal/schedule"
+	"merkle-go/internal/systemd"
+	"merkle-go/internal/tree"
+)
+
+// runAgent scans a directory and reports it to a fleet controller, once,
+// every interval or on a cron schedule
+func runAgent(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	flags := addCommonFlags(fs)
+	controller := fs.String("controller", "", "Address of the controller, e.g. controller.example.com:7443")
+	host := fs.String("host", "", "Name this host reports as (default: the hostname)")
+	caFile := fs.String("ca", "", "CA certificate to verify the controller's TLS certificate")
+	certFile := fs.String("cert", "", "Client certificate naming this host, issued by the controller's --client-ca")
+	keyFile := fs.String("key", "", "Client certificate key file")
+	insecureMode := fs.Bool("insecure", false, "Connect without a client certificate, and in plaintext without --ca; only for trusted networks")
+	enroll := fs.Bool("enroll", false, "Enroll the current state as the expected manifest if the controller has none for this host")
+	interval := fs.Duration("interval", 0, "Scan and report again after this long, e.g. 1h (0 = once)")
+	scheduleExpr := fs.String("schedule", "", "Scan and report on this cron schedule, e.g. \"0 3 * * *\" (default: schedule from the config)")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go agent [options] --controller <address> <directory>\n\n")
+		fmt.Fprintf(os.Stderr, "Scan a directory, compare it with the manifest the controller expects for this\n")
+		fmt.Fprintf(os.Stderr, "host and report the root hash and differences. The connection uses mutual TLS:\n")
+		fmt.Fprintf(os.Stderr, "--ca verifies the controller and --cert/--key, a certificate naming the host,\n")
+		fmt.Fprintf(os.Stderr, "identify the agent, unless --insecure is given.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *controller == "" || (*certFile == "") != (*keyFile == "") ||
+		(*certFile != "" && *caFile == "") || (!*insecureMode && *certFile == "") {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	closeLog, err := flags.setupLogging()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	if *host == "" {
+		if *host, err = os.Hostname(); err != nil {
+			return fmt.Errorf("failed to get hostname: %w", err)
+		}
+	}
+	absDirectory, err := absPath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	cfg, err := flags.loadConfig(absDirectory)
+	if err != nil {
+		return err
+	}
+	if *scheduleExpr == "" && !flags.isSet("interval") {
+		*scheduleExpr = cfg.Schedule
+	}
+	if *scheduleExpr != "" && *interval > 0 {
+		return fmt.Errorf("--schedule and --interval cannot be combined")
+	}
+	var sched *schedule.Schedule
+	if *scheduleExpr != "" {
+		if sched, err = schedule.Parse(*scheduleExpr); err != nil {
+			return err
+		}
+	}
+
+	creds := insecure.NewCredentials()
+	switch {
+	case *certFile != "":
+		tlsConfig, err := fleet.ClientTLSConfig(*caFile, *certFile, *keyFile)
+		if err != nil {
+			return err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	case *caFile != "":
+		if creds, err = credentials.NewClientTLSFromFile(*caFile, ""); err != nil {
+			return fmt.Errorf("failed to load CA certificate: %w", err)
+		}
+	}
+	conn, err := grpc.NewClient(*controller, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return fmt.Errorf("failed to connect to controller: %w", err)
+	}
+	defer conn.Close()
+	client := fleet.NewClient(conn)
+
+	// A repeating agent is a daemon; tell systemd once it is set up
+	if *interval > 0 || sched != nil {
+		stopNotify := systemd.Start(ctx, "Reporting "+absDirectory+" to "+*controller)
+		defer stopNotify()
+	}
+
+	// With a schedule, the first run waits for the first scheduled time
+	if sched != nil {
+		if err := waitForSchedule(ctx, sched); err != nil {
+			return err
+		}
+	}
+	for {
+		err := agentRun(ctx, client, *host, absDirectory, cfg, flags, *enroll)
+		if *interval <= 0 && sched == nil {
+			return err
+		}
+		var exitErr *exitError
+		switch {
+		case err == nil:
+			systemd.SetStatus("Last report %s: host matches", time.Now().Format(time.RFC3339))
+		case errors.As(err, &exitErr) && exitErr.code == 1:
+			systemd.SetStatus("Last report %s: host differs", time.Now().Format(time.RFC3339))
+		case errors.As(err, &exitErr):
+			systemd.SetStatus("Last report %s: scan errors", time.Now().Format(time.RFC3339))
+		default:
+			slog.Error("Agent run failed", "error", err)
+			systemd.SetStatus("Last run %s failed: %v", time.Now().Format(time.RFC3339), err)
+		}
+
+		if sched != nil {
+			if err := waitForSchedule(ctx, sched); err != nil {
+				return err
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// agentRun performs one scan and reports it. The exit code follows compare:
+// 1 if the host differs from its expected manifest, 2 on scan errors.
+func agentRun(ctx context.Context, client *fleet.Client, host, absDirectory string, cfg *config.Config,
+	flags *commonFlags, enroll bool) error {
+	resp, err := client.Expected(ctx, &fleet.ExpectedRequest{Host: host})
+	if status.Code(err) == codes.NotFound && enroll {
+		return agentEnroll(ctx, client, host, absDirectory, cfg, flags)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch expected manifest: %w", err)
+	}
+	expected, err := tree.Decode(resp.Manifest)
+	if err != nil {
+		return fmt.Errorf("invalid expected manifest: %w", err)
+	}
+
+	// Scan the same way the expected tree was built
+	scanCfg := *cfg
+	scanCfg.Portable = expected.Portable
+	scanCfg.BytewiseOrder = expected.BytewiseOrder
+	scanCfg.HashAlgorithm = expected.HashAlgorithm
+	scanCfg.HashAlgorithms = nil
+	scanCfg.EmptyDirs = expected.EmptyDirs
+	scanCfg.SegmentSize = expected.SegmentSize
+	scanCfg.Fingerprint = expected.Fingerprint
+	scan, err := scanDirectory(ctx, absDirectory, &scanCfg, flags)
+	if err != nil {
+		return err
+	}
+
+	aligned, err := compare.AlignRoots(expected, scan.Tree)
+	if err != nil && !errors.Is(err, compare.ErrRootMismatch) {
+		return err
+	}
+	foldCase, err := compare.ParseCaseSensitivity(cfg.CaseSensitivity)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	result := compare.CompareWithOptions(aligned, scan.Tree, compare.Options{CaseInsensitive: foldCase})
+	report := fleet.NewReport(host, scan.Tree, result, len(scan.Hash.Errors))
+
+	ack, err := client.Report(ctx, report)
+	if err != nil {
+		return fmt.Errorf("failed to report: %w", err)
+	}
+	reportErrors(scan.Hash.Errors)
+
+	// With alert rules, unusual change volumes are notified like compare
+	// notifies them
+	if cfg.AlertThreshold > 0 || cfg.AlertPercentile > 0 {
+		if alert, reason := changeAlert(cfg, absDirectory, result.Count()); alert {
+			event := notify.Event{
+				Title:    "merkle-go agent " + host,
+				RootPath: absDirectory,
+				Time:     time.Now(),
+				Added:    len(result.Added),
+				Modified: len(result.Modified),
+				Deleted:  len(result.Deleted),
+				Renamed:  len(result.Renamed),
+				Errors:   report.Errors,
+				Report:   notifyReport(result),
+				Alert:    reason,
+
+				MetadataOnly: len(result.MetadataOnly),
+				CaseRenamed:  len(result.CaseRenamed),
+			}
+			if err := sendNotifications(cfg, event); err != nil {
+				slog.Warn("Failed to send notifications", "error", err)
+			}
+		}
+	}
+	if ack.Match {
+		slog.Info("Host matches its expected manifest", "host", host, "root", report.Root)
+	} else {
+		slog.Warn("Host differs from its expected manifest", "host", host, "root", report.Root,
+			"expected", ack.ExpectedRoot, "added", len(report.Added), "modified", len(report.Modified),
+			"deleted", len(report.Deleted), "renamed", len(report.Renamed))
+	}
+
+	if report.Errors > 0 {
+		return &exitError{code: 2}
+	}
+	if !ack.Match {
+		return &exitError{code: 1}
+	}
+	return nil
+}
+
+// agentEnroll uploads the current state of the directory as the expected
+// manifest of host
+func agentEnroll(ctx context.Context, client *fleet.Client, host, absDirectory string, cfg *config.Config, flags *commonFlags) error {
+	scan, err := scanDirectory(ctx, absDirectory, cfg, flags)
+	if err != nil {
+		return err
+	}
+	manifest, err := tree.Encode(scan.Tree)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Enroll(ctx, &fleet.EnrollRequest{Host: host, Manifest: manifest})
+	if err != nil {
+		return fmt.Errorf("failed to enroll: %w", err)
+	}
+	reportErrors(scan.Hash.Errors)
+	slog.Info("Enrolled host", "host", host, "root", resp.Root, "files", len(scan.Tree.Files))
+	return nil
+}
+
+// waitForSchedule sleeps until the next time sched fires
+func waitForSchedule(ctx context.Context, sched *schedule.Schedule) error {
+	next := sched.Next(time.Now())
+	if next.IsZero() {
+		return fmt.Errorf("schedule %q never fires", sched)
+	}
+	slog.Info("Waiting for next scheduled scan", "schedule", sched.String(), "next", next.Format(time.RFC3339))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Until(next)):
+		return nil
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"merkle-go/internal/archive"
+	"merkle-go/internal/config"
+	"merkle-go/internal/tree"
+	"merkle-go/internal/walker"
+)
+
+// splitArchives separates the archives of the given kinds, which are
+// descended into, from the files that are hashed whole
+func splitArchives(files []walker.FileInfo, kinds []string) (plain, archives []walker.FileInfo) {
+	if len(kinds) == 0 {
+		return files, nil
+	}
+	plain = make([]walker.FileInfo, 0, len(files))
+	for _, fileInfo := range files {
+		if archive.Kind(fileInfo.Path, kinds) != "" {
+			archives = append(archives, fileInfo)
+		} else {
+			plain = append(plain, fileInfo)
+		}
+	}
+	return plain, archives
+}
+
+// hashArchives hashes the members of each archive, keyed by their leaf
+// paths. An archive that cannot be read is reported as an error and
+// contributes no leaves.
+func hashArchives(ctx context.Context, archives []walker.FileInfo, cfg *config.Config) (map[string]tree.FileData, []error) {
+	files := make(map[string]tree.FileData)
+	var errs []error
+	for _, fileInfo := range archives {
+		if ctx.Err() != nil {
+			errs = append(errs, &walker.FileError{Path: fileInfo.Path, Err: ctx.Err()})
+			continue
+		}
+		members, err := archive.HashMembers(fileInfo.Path, archive.Kind(fileInfo.Path, cfg.DescendArchives), cfg.HashAlgorithm)
+		if err != nil {
+			errs = append(errs, &walker.FileError{Path: fileInfo.Path, Err: err})
+			continue
+		}
+		slog.Debug("Hashed archive members", "path", fileInfo.Path, "members", len(members))
+		for _, member := range members {
+			files[archive.MemberPath(fileInfo.Path, member.Name)] = tree.FileData{
+				Hash:    member.Hash,
+				Size:    member.Size,
+				ModTime: member.ModTime,
+			}
+		}
+	}
+	return files, errs
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"merkle-go/internal/config"
+	"merkle-go/internal/hash"
+	"merkle-go/internal/tree"
+	"merkle-go/internal/walker"
+)
+
+// parseSize parses a byte count with an optional K, M or G suffix (powers
+// of 1024), e.g. 64K
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1024
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// parseList splits a comma-separated list and parses each item
+func parseList(s string, parse func(string) (int64, error)) ([]int64, error) {
+	var values []int64
+	for _, item := range strings.Split(s, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		value, err := parse(item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("empty list %q", s)
+	}
+	return values, nil
+}
+
+// defaultWorkerCounts returns powers of two up to four workers per CPU
+func defaultWorkerCounts() string {
+	var counts []string
+	for n := 1; n <= runtime.NumCPU()*4; n *= 2 {
+		counts = append(counts, strconv.Itoa(n))
+	}
+	return strings.Join(counts, ",")
+}
+
+// benchResult is the throughput of one worker count and buffer size
+type benchResult struct {
+	workers    int
+	bufferSize int
+	elapsed    time.Duration
+	throughput float64 // bytes per second
+}
+
+// benchmark times hashing files with the given settings
+func benchmark(ctx context.Context, files []walker.FileInfo, totalBytes int64, cfg *config.Config, workers, bufferSize int) (benchResult, error) {
+	hashFunc, err := hash.FileHasher(cfg.HashAlgorithm, hash.ReadOptions{Strategy: cfg.ReadStrategy, BufferSize: bufferSize, Key: cfg.HashKeyBytes()})
+	if err != nil {
+		return benchResult{}, err
+	}
+
+	start := time.Now()
+	if _, err := walker.HashFilesWithOptions(ctx, files, walker.HashOptions{Workers: workers, HashFunc: hashFunc}); err != nil {
+		return benchResult{}, err
+	}
+	elapsed := time.Since(start)
+
+	return benchResult{
+		workers:    workers,
+		bufferSize: bufferSize,
+		elapsed:    elapsed,
+		throughput: float64(totalBytes) / elapsed.Seconds(),
+	}, nil
+}
+
+func benchHashing(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	flags := addCommonFlags(fs)
+	workerList := fs.String("try-workers", defaultWorkerCounts(), "Comma-separated worker counts to try")
+	bufferList := fs.String("try-buffers", "32K,128K,1M", "Comma-separated read buffer sizes to try")
+	sampleSize := fs.String("sample", "1G", "Hash at most this much data per run")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go bench [options] <directory>\n\n")
+		fmt.Fprintf(os.Stderr, "Measure hashing throughput with different worker counts and buffer sizes and\n")
+		fmt.Fprintf(os.Stderr, "suggest settings for the config file.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	closeLog, err := flags.setupLogging()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	workerCounts, err := parseList(*workerList, func(s string) (int64, error) {
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid worker count %q", s)
+		}
+		return n, nil
+	})
+	if err != nil {
+		return err
+	}
+	bufferSizes, err := parseList(*bufferList, parseSize)
+	if err != nil {
+		return err
+	}
+	maxBytes, err := parseSize(*sampleSize)
+	if err != nil {
+		return err
+	}
+
+	absDirectory, err := absPath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	cfg, err := flags.loadConfig(absDirectory)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Scanning directory", "path", absDirectory)
+	walkResult, err := walker.WalkWithOptions(ctx, absDirectory, walkOptions(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to walk directory: %w", err)
+	}
+
+	// Use the same sample of files for every run
+	var sample []walker.FileInfo
+	var totalBytes int64
+	for _, fileInfo := range walkResult.Files {
+		if totalBytes+fileInfo.Size > maxBytes && len(sample) > 0 {
+			continue
+		}
+		sample = append(sample, fileInfo)
+		totalBytes += fileInfo.Size
+	}
+	if totalBytes == 0 {
+		return fmt.Errorf("no data to hash in %s", absDirectory)
+	}
+
+	// A first pass brings every run to the same page cache state
+	slog.Info("Warming up", "files", len(sample), "size", tree.FormatSize(totalBytes), "read_strategy", cfg.ReadStrategy)
+	if _, err := benchmark(ctx, sample, totalBytes, cfg, runtime.NumCPU(), 0); err != nil {
+		return err
+	}
+
+	fmt.Printf("%8s  %8s  %10s  %12s\n", "WORKERS", "BUFFER", "TIME", "THROUGHPUT")
+	var results []benchResult
+	for _, workers := range workerCounts {
+		for _, bufferSize := range bufferSizes {
+			result, err := benchmark(ctx, sample, totalBytes, cfg, int(workers), int(bufferSize))
+			if err != nil {
+				return err
+			}
+			results = append(results, result)
+			fmt.Printf("%8d  %8s  %10s  %10s/s\n", result.workers, tree.FormatSize(int64(result.bufferSize)),
+				result.elapsed.Round(time.Millisecond), tree.FormatSize(int64(result.throughput)))
+		}
+	}
+
+	// Prefer the fewest workers and smallest buffer within 5% of the best
+	best := results[0]
+	for _, result := range results {
+		if result.throughput > best.throughput {
+			best = result
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].workers != results[j].workers {
+			return results[i].workers < results[j].workers
+		}
+		return results[i].bufferSize < results[j].bufferSize
+	})
+	suggested := best
+	for _, result := range results {
+		if result.throughput >= best.throughput*0.95 {
+			suggested = result
+			break
+		}
+	}
+
+	fmt.Printf("\nSuggested settings (%s/s) for config.toml:\n\n", tree.FormatSize(int64(suggested.throughput)))
+	fmt.Printf("workers = %d\n", suggested.workers)
+	fmt.Printf("buffer_size = %d\n", suggested.bufferSize)
+	if cfg.ReadStrategy == "" || cfg.ReadStrategy == hash.ReadBuffered {
+		fmt.Printf("\nFiles were read from the page cache after the warm-up; with read_strategy = \"direct\"\n")
+		fmt.Printf("every run reads from disk instead.\n")
+	}
+	return nil
+}
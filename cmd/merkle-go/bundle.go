@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"merkle-go/internal/attest"
+	"merkle-go/internal/bundle"
+	"merkle-go/internal/compare"
+	"merkle-go/internal/config"
+	"merkle-go/internal/logging"
+	"merkle-go/internal/tree"
+	"merkle-go/internal/version"
+)
+
+// bundleCommand packs a manifest with the config it was generated with and
+// its attestation into one archive that verify-bundle can check on its own
+func bundleCommand(args []string) error {
+	fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+	outputPath := fs.String("o", "bundle.tar.zst", "Output bundle; .tar.zst, .tar.gz or .tar")
+	configPath := fs.String("config", "config.toml", "Config file the tree was generated with; left out if the default does not exist")
+	fs.StringVar(configPath, "c", "config.toml", "Config file (shorthand)")
+	attestationPath := fs.String("attestation", "", "In-toto statement written by --attest to include, with its .sigstore.json signature if present")
+	var logOpts logging.Options
+	fs.BoolVar(&logOpts.Quiet, "quiet", false, "Only log warnings and errors")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go bundle [options] <tree.json> [-o bundle.tar.zst]\n\n")
+		fmt.Fprintf(os.Stderr, "Package a manifest with the config it was generated with, the tool version,\n")
+		fmt.Fprintf(os.Stderr, "its hash algorithm and optionally a signed attestation into one archive.\n")
+		fmt.Fprintf(os.Stderr, "Check a directory against it with verify-bundle.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	// Allow options after the manifest, e.g. "bundle tree.json -o out.tar.zst"
+	var inputs []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		inputs = append(inputs, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(inputs) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	_, closer, err := logging.Setup(logOpts)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	treePath := inputs[0]
+	manifest, err := os.ReadFile(treePath)
+	if err != nil {
+		return fmt.Errorf("failed to read tree: %w", err)
+	}
+	merkleTree, err := tree.Decode(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+	algorithm := merkleTree.HashAlgorithm
+	if algorithm == "" {
+		algorithm = "xxh64"
+	}
+
+	b := &bundle.Bundle{
+		Metadata: bundle.Metadata{
+			Version:       version.String(),
+			Created:       time.Now().UTC(),
+			Root:          merkleTree.Root.Hash,
+			HashAlgorithm: algorithm,
+			Files:         len(merkleTree.Files),
+		},
+		Manifest: manifest,
+	}
+
+	configSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" || f.Name == "c" {
+			configSet = true
+		}
+	})
+	b.Config, err = os.ReadFile(*configPath)
+	switch {
+	case os.IsNotExist(err) && !configSet:
+		slog.Info("No config file; verify-bundle will use the default config", "path", *configPath)
+	case err != nil:
+		return fmt.Errorf("failed to read config: %w", err)
+	default:
+		if _, err := config.Parse(b.Config); err != nil {
+			return fmt.Errorf("invalid config %s: %w", *configPath, err)
+		}
+	}
+
+	if *attestationPath != "" {
+		if b.Attestation, err = os.ReadFile(*attestationPath); err != nil {
+			return fmt.Errorf("failed to read attestation: %w", err)
+		}
+		statement, err := attest.Parse(b.Attestation)
+		if err != nil {
+			return err
+		}
+		if err := statement.Covers(merkleTree.Root.Hash, bundle.ManifestDigest(manifest)); err != nil {
+			return fmt.Errorf("attestation does not match %s: %w", treePath, err)
+		}
+		b.Signature, err = os.ReadFile(*attestationPath + attest.BundleSuffix)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read attestation signature: %w", err)
+		}
+	}
+
+	if err := bundle.Write(*outputPath, b); err != nil {
+		return err
+	}
+	slog.Info("Wrote bundle", "path", *outputPath, "root", b.Metadata.Root, "members", b.Metadata.Members)
+	return nil
+}
+
+// verifyBundle checks a directory against a bundle: the attestation must
+// vouch for the bundled manifest, and the directory, scanned with the
+// bundled config, must match the manifest
+func verifyBundle(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("verify-bundle", flag.ExitOnError)
+	flags := addCommonFlags(fs)
+	exitFlags := addExitFlags(fs)
+	reportFlags := addReportFlags(fs)
+	identity := fs.String("certificate-identity", "", "Verify the attestation signature, requiring a certificate issued to this identity")
+	issuer := fs.String("certificate-oidc-issuer", "", "OIDC issuer the signing certificate must come from")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go verify-bundle [options] <bundle> <directory>\n\n")
+		fmt.Fprintf(os.Stderr, "Check a directory against a bundle written by merkle-go bundle. The directory\n")
+		fmt.Fprintf(os.Stderr, "is scanned with the bundled config (--config is only used if the bundle has\n")
+		fmt.Fprintf(os.Stderr, "none) and every file is rehashed. A bundled attestation must cover the\n")
+		fmt.Fprintf(os.Stderr, "manifest; its signature is checked with cosign when --certificate-identity\n")
+		fmt.Fprintf(os.Stderr, "and --certificate-oidc-issuer are given.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if (*identity == "") != (*issuer == "") {
+		return fmt.Errorf("--certificate-identity and --certificate-oidc-issuer must be given together")
+	}
+
+	closeLog, err := flags.setupLogging()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	bundlePath := fs.Arg(0)
+	b, err := bundle.Read(bundlePath)
+	if err != nil {
+		return err
+	}
+	slog.Info("Loaded bundle", "path", bundlePath, "version", b.Metadata.Version, "created", b.Metadata.Created, "members", b.Metadata.Members)
+	if b.Metadata.Version != version.String() {
+		slog.Warn("Bundle was written by a different merkle-go version", "bundle", b.Metadata.Version, "current", version.String())
+	}
+
+	manifest, err := tree.Decode(b.Manifest)
+	if err != nil {
+		return fmt.Errorf("failed to load bundled tree: %w", err)
+	}
+	if manifest.Root.Hash != b.Metadata.Root {
+		return fmt.Errorf("bundled tree has root %s, bundle metadata records %s", manifest.Root.Hash, b.Metadata.Root)
+	}
+
+	if err := verifyBundleAttestation(ctx, b, *identity, *issuer); err != nil {
+		return err
+	}
+
+	absDirectory, err := absPath(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	// The bundled config decides which files belong to the tree
+	var cfg *config.Config
+	if b.Config != nil {
+		if cfg, err = config.Parse(b.Config); err != nil {
+			return fmt.Errorf("invalid bundled config: %w", err)
+		}
+		flags.chooseWorkers(cfg, absDirectory)
+	} else if cfg, err = flags.loadConfig(""); err != nil {
+		return err
+	}
+	policy, err := exitFlags.policy(cfg, flags)
+	if err != nil {
+		return err
+	}
+
+	// Hash the directory the same way the bundled tree was built, rehashing
+	// every file rather than trusting the cache
+	cfg.Portable = manifest.Portable
+	cfg.BytewiseOrder = manifest.BytewiseOrder
+	cfg.HashAlgorithm = manifest.HashAlgorithm
+	cfg.EmptyDirs = manifest.EmptyDirs
+	cfg.DescendArchives = manifest.Archives
+	cfg.SegmentSize = manifest.SegmentSize
+	cfg.Fingerprint = manifest.Fingerprint
+	cfg.HashCache = cacheOff
+	cfg.CIDs = false         // CIDs play no part in the comparison
+	cfg.HashAlgorithms = nil // nor do other digests
+
+	scan, err := scanDirectory(ctx, absDirectory, cfg, flags)
+	if err != nil {
+		return err
+	}
+
+	if filepath.Clean(manifest.RootPath) != absDirectory {
+		if manifest, err = compare.AlignRoots(manifest, scan.Tree); err != nil {
+			return err
+		}
+	}
+
+	result := compare.Compare(manifest, scan.Tree)
+	fmt.Println(compare.FormatReportWithOptions(result, reportFlags.options(absDirectory)))
+
+	reportErrors(scan.Hash.Errors)
+
+	if !result.HasChanges() && len(scan.Hash.Errors) == 0 {
+		slog.Info("Directory matches bundle", "root", manifest.Root.Hash)
+	}
+	return policy.exit(result.Count(), len(scan.Hash.Errors))
+}
+
+// verifyBundleAttestation checks that the bundled attestation, if any,
+// covers the bundled manifest and, when an identity is given, that its
+// signature is valid
+func verifyBundleAttestation(ctx context.Context, b *bundle.Bundle, identity, issuer string) error {
+	if b.Attestation == nil {
+		if identity != "" {
+			return fmt.Errorf("bundle has no attestation to verify")
+		}
+		return nil
+	}
+
+	statement, err := attest.Parse(b.Attestation)
+	if err != nil {
+		return fmt.Errorf("invalid bundled attestation: %w", err)
+	}
+	if err := statement.Covers(b.Metadata.Root, b.Metadata.ManifestSHA256); err != nil {
+		return fmt.Errorf("bundled attestation does not match the manifest: %w", err)
+	}
+	slog.Info("Attestation covers the bundled manifest", "created", statement.Predicate.Created)
+
+	if identity == "" {
+		if b.Signature != nil {
+			slog.Warn("Attestation signature not verified; pass --certificate-identity and --certificate-oidc-issuer")
+		}
+		return nil
+	}
+	if b.Signature == nil {
+		return fmt.Errorf("bundled attestation is not signed")
+	}
+
+	// cosign reads the statement and its signature from files
+	dir, err := os.MkdirTemp("", "merkle-go-bundle-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	statementPath := filepath.Join(dir, bundle.AttestationName)
+	signaturePath := filepath.Join(dir, bundle.SignatureName)
+	if err := os.WriteFile(statementPath, b.Attestation, 0644); err != nil {
+		return fmt.Errorf("failed to write attestation: %w", err)
+	}
+	if err := os.WriteFile(signaturePath, b.Signature, 0644); err != nil {
+		return fmt.Errorf("failed to write attestation signature: %w", err)
+	}
+	if err := attest.Verify(ctx, statementPath, signaturePath, identity, issuer); err != nil {
+		return err
+	}
+	slog.Info("Verified attestation signature", "identity", identity, "issuer", issuer)
+	return nil
+}
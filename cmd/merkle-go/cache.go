@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"merkle-go/internal/config"
+	"merkle-go/internal/hash"
+	"merkle-go/internal/hashcache"
+	"merkle-go/internal/tree"
+)
+
+// cacheOff is the hash_cache value that disables the cache
+const cacheOff = "off"
+
+// cachePath returns the hash cache database selected by cfg, or "" if the
+// cache is disabled
+func cachePath(cfg *config.Config) (string, error) {
+	switch cfg.HashCache {
+	case cacheOff:
+		return "", nil
+	case "":
+		return hashcache.DefaultPath()
+	default:
+		return cfg.HashCache, nil
+	}
+}
+
+// cachedHasher puts the hash cache selected by cfg in front of hashFunc.
+// The returned function closes the cache and logs its hit rate. A cache
+// that cannot be opened, e.g. because another scan holds it, is skipped
+// with a warning rather than failing the scan.
+func cachedHasher(cfg *config.Config, hashFunc func(path string) (string, error)) (func(path string) (string, error), func()) {
+	path, err := cachePath(cfg)
+	if err != nil || path == "" {
+		if err != nil {
+			slog.Warn("Hashing without cache", "error", err)
+		}
+		return hashFunc, func() {}
+	}
+	cache, err := hashcache.Open(path)
+	if err != nil {
+		slog.Warn("Hashing without cache", "error", err)
+		return hashFunc, func() {}
+	}
+	// Segmented digests differ from plain ones, so they are cached apart
+	algorithm := cfg.HashAlgorithm
+	if cfg.SegmentSize > 0 {
+		algorithm = fmt.Sprintf("%s/segments=%d", algorithm, cfg.SegmentSize)
+	}
+	// Keyed digests are only valid for their key
+	if key := cfg.HashKeyBytes(); key != nil && strings.EqualFold(cfg.HashAlgorithm, hash.HMACSHA256) {
+		algorithm = fmt.Sprintf("%s/key=%s", algorithm, hash.KeyID(key))
+	}
+	return cache.Wrap(hashFunc, algorithm), func() {
+		slog.Debug("Hash cache", "path", path, "hits", cache.Hits(), "misses", cache.Misses())
+		if err := cache.Close(); err != nil {
+			slog.Warn("Failed to close hash cache", "error", err)
+		}
+	}
+}
+
+// cacheCommand inspects and invalidates the hash cache
+func cacheCommand(args []string) error {
+	fs := flag.NewFlagSet("cache", flag.ExitOnError)
+	configPath := fs.String("config", "config.toml", "Config file path")
+	fs.StringVar(configPath, "c", "config.toml", "Config file path (shorthand)")
+	olderThan := fs.Duration("older-than", 30*24*time.Hour, "With prune, remove entries not used for this long")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go cache [options] stats|path|clear|prune|forget <path>...\n\n")
+		fmt.Fprintf(os.Stderr, "Inspect or invalidate the cache of file hashes that generate and compare consult\n")
+		fmt.Fprintf(os.Stderr, "to skip reading unchanged files.\n\n")
+		fmt.Fprintf(os.Stderr, "  stats   Print the number of entries and the size of the cache\n")
+		fmt.Fprintf(os.Stderr, "  path    Print the location of the cache database\n")
+		fmt.Fprintf(os.Stderr, "  clear   Remove every entry\n")
+		fmt.Fprintf(os.Stderr, "  prune   Remove entries not used for --older-than\n")
+		fmt.Fprintf(os.Stderr, "  forget  Remove the entries of files at or under the given paths\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	path, err := cachePath(cfg)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return fmt.Errorf("the hash cache is disabled (hash_cache = %q)", cacheOff)
+	}
+
+	action := fs.Arg(0)
+	if action == "path" {
+		fmt.Println(path)
+		return nil
+	}
+	if (action == "forget") != (fs.NArg() > 1) {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	cache, err := hashcache.Open(path)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	var removed int
+	switch action {
+	case "stats":
+		stats, err := cache.Stats()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Path:    %s\n", path)
+		fmt.Printf("Entries: %d\n", stats.Entries)
+		fmt.Printf("Size:    %s\n", tree.FormatSize(stats.Size))
+		if !stats.Oldest.IsZero() {
+			fmt.Printf("Oldest:  %s\n", stats.Oldest.Format(time.RFC3339))
+		}
+		return nil
+	case "clear":
+		removed, err = cache.Clear()
+	case "prune":
+		removed, err = cache.Prune(time.Now().Add(-*olderThan))
+	case "forget":
+		for _, arg := range fs.Args()[1:] {
+			abs, absErr := absPath(arg)
+			if absErr != nil {
+				return absErr
+			}
+			n, forgetErr := cache.Forget(abs)
+			if forgetErr != nil {
+				return forgetErr
+			}
+			removed += n
+		}
+	default:
+		return fmt.Errorf("unknown cache action %q (want stats, path, clear, prune or forget)", action)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d entries\n", removed)
+	return nil
+}
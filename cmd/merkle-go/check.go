@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"merkle-go/internal/archive"
+	"merkle-go/internal/compare"
+	"merkle-go/internal/config"
+	"merkle-go/internal/hash"
+	"merkle-go/internal/tree"
+	"merkle-go/internal/walker"
+)
+
+// checkTree rehashes exactly the files listed in a manifest, ignoring files
+// added since, and reports the ones that are corrupted or missing
+func checkTree(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	flags := addCommonFlags(fs)
+	exitFlags := addExitFlags(fs)
+	sample := fs.String("sample", "", "Only rehash a random sample of this share of the files, e.g. 5%")
+	seed := fs.Uint64("seed", 0, "Seed of the --sample selection, to repeat a run (default: random, logged)")
+	var prefixMaps stringList
+	fs.Var(&prefixMaps, "map-prefix", "Read the files recorded under one path from another, e.g. /data=/mnt/snapshot/data (repeatable)")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go check [options] <tree.json> [directory]\n\n")
+		fmt.Fprintf(os.Stderr, "Rehash the files listed in a saved tree and report corrupted or missing files.\n")
+		fmt.Fprintf(os.Stderr, "New files are ignored. If directory is given, the files are looked up relative\n")
+		fmt.Fprintf(os.Stderr, "to it instead of the root path recorded in the tree.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	var fraction float64
+	if *sample != "" {
+		var err error
+		if fraction, err = parsePercent(*sample); err != nil {
+			return fmt.Errorf("invalid --sample: %w", err)
+		}
+	}
+
+	closeLog, err := flags.setupLogging()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	treePath := fs.Arg(0)
+	manifest, err := flags.loadTree(ctx, treePath)
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+	slog.Info("Loaded saved tree", "path", treePath, "root", manifest.Root.Hash, "files", len(manifest.Files))
+	if manifest.MetadataOnly {
+		return fmt.Errorf("%s records no content hashes to check; use compare", treePath)
+	}
+	if manifest.Redacted {
+		return fmt.Errorf("%s is redacted; its paths match no files", treePath)
+	}
+
+	if len(prefixMaps) > 0 {
+		if fs.NArg() == 2 {
+			return fmt.Errorf("--map-prefix cannot be combined with a directory")
+		}
+		if manifest, err = mapPrefixes(manifest, prefixMaps); err != nil {
+			return err
+		}
+	}
+	if fs.NArg() == 2 {
+		absDirectory, err := absPath(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		manifest, err = compare.AlignRoots(manifest, &tree.MerkleTree{RootPath: absDirectory})
+		if err != nil {
+			return err
+		}
+	}
+
+	cfg, err := flags.loadConfig(manifest.RootPath)
+	if err != nil {
+		return err
+	}
+	policy, err := exitFlags.policy(cfg, flags)
+	if err != nil {
+		return err
+	}
+	cfg.HashAlgorithm = manifest.HashAlgorithm
+	cfg.DescendArchives = manifest.Archives
+	cfg.SegmentSize = manifest.SegmentSize
+
+	// Stat the listed files; anything that no longer exists is missing
+	paths := make([]string, 0, len(manifest.Files))
+	for path := range manifest.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	if *sample != "" {
+		if *seed == 0 {
+			*seed = rand.Uint64()
+		}
+		total := len(paths)
+		paths = compare.Sample(paths, fraction, *seed)
+		slog.Info("Checking a random sample", "sample", *sample, "files", len(paths), "of", total, "seed", *seed)
+	}
+
+	var missing, presentDirs []string
+	var statErrors []error
+	var virtual int
+	files := make([]walker.FileInfo, 0, len(paths))
+	archiveMembers := make(map[string][]string)
+	for _, path := range paths {
+		// Virtual files were piped in and have no file on disk to rehash
+		if manifest.Files[path].Virtual {
+			virtual++
+			continue
+		}
+		// Archive members are rehashed together, one pass per archive
+		if len(manifest.Archives) > 0 {
+			if archivePath, _, ok := archive.SplitMemberPath(path); ok {
+				archiveMembers[archivePath] = append(archiveMembers[archivePath], path)
+				continue
+			}
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				missing = append(missing, path)
+			} else {
+				statErrors = append(statErrors, err)
+			}
+			continue
+		}
+		// Empty directory markers have no content to rehash
+		if manifest.Files[path].Dir && info.IsDir() {
+			presentDirs = append(presentDirs, path)
+			continue
+		}
+		files = append(files, walker.FileInfo{Path: path, Size: info.Size(), ModTime: info.ModTime()})
+	}
+
+	slog.Info("Hashing files", "files", len(files), "workers", flags.workers)
+	bar := flags.newProgressBar(len(files))
+	hashFunc, err := fileHasher(cfg, bar, nil)
+	if err != nil {
+		return err
+	}
+	// Sampled leaves are checked against a sample of the same size
+	hashFunc = hash.SampledHasher(hashFunc, cfg.HashAlgorithm, cfg.HashKeyBytes(), func(path string) int64 {
+		return manifest.Files[path].Sampled
+	}, nil)
+	hashResult, err := walker.HashFilesWithOptions(ctx, files, walker.HashOptions{
+		Workers:     flags.workers,
+		Progress:    bar,
+		FileTimeout: flags.fileTimeout,
+		HashFunc:    hashFunc,
+		Retry:       retryPolicy(cfg),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to hash files: %w", err)
+	}
+	if bar != nil {
+		bar.Finish()
+	}
+	for _, path := range hashResult.Poisoned {
+		slog.Warn("Isolated poisoned file", "path", path)
+	}
+	warnRetriesExhausted(hashResult.RetriesExhausted)
+
+	current := make(map[string]tree.FileData, len(hashResult.Hashes))
+	for _, fileInfo := range files {
+		if digest, ok := hashResult.Hashes[fileInfo.Path]; ok {
+			current[fileInfo.Path] = tree.FileData{Hash: digest, Size: fileInfo.Size, ModTime: fileInfo.ModTime}
+		}
+	}
+	for _, path := range presentDirs {
+		current[path] = manifest.Files[path]
+	}
+	archiveFiles, archiveMissing, archiveErrors := checkArchives(ctx, archiveMembers, cfg)
+	for path, data := range archiveFiles {
+		current[path] = data
+	}
+	missing = append(missing, archiveMissing...)
+	statErrors = append(statErrors, archiveErrors...)
+
+	result := compare.Check(manifest, current, missing)
+	if *sample != "" {
+		fmt.Printf("Checked a %s sample: %d of %d files (seed %d).\n", *sample, len(paths), len(manifest.Files), *seed)
+	}
+	if virtual > 0 {
+		fmt.Printf("Skipped %d virtual files, which have no file on disk to check.\n", virtual)
+	}
+	fmt.Println(compare.FormatCheckReport(result))
+
+	errs := append(statErrors, hashResult.Errors...)
+	reportErrors(errs)
+
+	return policy.exit(result.Count(), len(errs))
+}
+
+// checkArchives rehashes the archives whose members a manifest lists, given
+// as archive path -> member leaf paths. Members of archives that no longer
+// exist, or that an archive no longer holds, are returned as missing.
+func checkArchives(ctx context.Context, members map[string][]string, cfg *config.Config) (map[string]tree.FileData, []string, []error) {
+	var missing []string
+	var errs []error
+	var archives []walker.FileInfo
+	for archivePath, paths := range members {
+		info, err := os.Stat(archivePath)
+		switch {
+		case os.IsNotExist(err):
+			missing = append(missing, paths...)
+		case err != nil:
+			errs = append(errs, err)
+		default:
+			archives = append(archives, walker.FileInfo{Path: archivePath, Size: info.Size(), ModTime: info.ModTime()})
+		}
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].Path < archives[j].Path })
+
+	files, hashErrs := hashArchives(ctx, archives, cfg)
+	errs = append(errs, hashErrs...)
+	unreadable := make(map[string]bool, len(hashErrs))
+	for _, err := range hashErrs {
+		var fileErr *walker.FileError
+		if errors.As(err, &fileErr) {
+			unreadable[fileErr.Path] = true
+		}
+	}
+	for _, fileInfo := range archives {
+		if unreadable[fileInfo.Path] {
+			continue
+		}
+		for _, path := range members[fileInfo.Path] {
+			if _, ok := files[path]; !ok {
+				missing = append(missing, path)
+			}
+		}
+	}
+	sort.Strings(missing)
+	return files, missing, errs
+}
+
+// parsePercent parses a share such as "5%" or "0.5%" as a fraction of one
+func parsePercent(s string) (float64, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		return 0, fmt.Errorf("%q is not a percentage between 0 and 100", s)
+	}
+	return percent / 100, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"merkle-go/internal/chunk"
+	"merkle-go/internal/tree"
+)
+
+// chunkRecorder records the content-defined chunks of the files a hash
+// function reads. The chunker is fed each file as it is hashed (see tee);
+// files the hash function does not read whole, such as cached or
+// segmented ones, are read again.
+type chunkRecorder struct {
+	opts chunk.Options
+
+	mu      sync.Mutex
+	pending map[string]*chunk.Writer
+	chunks  map[string][]tree.Chunk
+}
+
+func newChunkRecorder(avgSize int) *chunkRecorder {
+	return &chunkRecorder{
+		opts:    chunk.ForAverage(avgSize),
+		pending: make(map[string]*chunk.Writer),
+		chunks:  make(map[string][]tree.Chunk),
+	}
+}
+
+// tee returns the writer that chunks the content of path as it is hashed,
+// for hash.ReadOptions.Tee
+func (r *chunkRecorder) tee(path string) io.Writer {
+	w, err := chunk.NewWriter(r.opts)
+	if err != nil {
+		return nil // Reported when the file is chunked on its own
+	}
+	r.mu.Lock()
+	earlier := r.pending[path]
+	r.pending[path] = w
+	r.mu.Unlock()
+	if earlier != nil {
+		earlier.Abort() // Left by a failed read
+	}
+	return w
+}
+
+// wrap returns hashFunc extended to record the chunks of every file it
+// hashes successfully
+func (r *chunkRecorder) wrap(hashFunc func(path string) (string, error)) func(path string) (string, error) {
+	return func(path string) (string, error) {
+		digest, err := hashFunc(path)
+		r.mu.Lock()
+		w := r.pending[path]
+		delete(r.pending, path)
+		r.mu.Unlock()
+		if err != nil {
+			if w != nil {
+				w.Abort()
+			}
+			return "", err
+		}
+
+		var chunks []tree.Chunk
+		if w != nil {
+			chunks, err = w.Chunks()
+		} else {
+			chunks, err = chunk.ListFile(path, r.opts)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to chunk file: %w", err)
+		}
+		r.mu.Lock()
+		r.chunks[path] = chunks
+		r.mu.Unlock()
+		return digest, nil
+	}
+}
+
+// get returns the chunks recorded for path; a nil recorder has none
+func (r *chunkRecorder) get(path string) []tree.Chunk {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.chunks[path]
+}
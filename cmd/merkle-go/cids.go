@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"merkle-go/internal/unixfs"
+)
+
+// cidRecorder records the IPFS CIDs of the files a hash function reads.
+// Each file's CID is computed from the content as it is hashed (see tee);
+// files the hash function does not read whole, such as cached or segmented
+// ones, are read again.
+type cidRecorder struct {
+	mu      sync.Mutex
+	pending map[string]*unixfs.Writer
+	cids    map[string]string
+}
+
+func newCIDRecorder() *cidRecorder {
+	return &cidRecorder{pending: make(map[string]*unixfs.Writer), cids: make(map[string]string)}
+}
+
+// tee returns the writer that computes the CID of path as it is hashed,
+// for hash.ReadOptions.Tee. A writer left by a failed read is replaced.
+func (r *cidRecorder) tee(path string) io.Writer {
+	w := unixfs.NewWriter()
+	r.mu.Lock()
+	r.pending[path] = w
+	r.mu.Unlock()
+	return w
+}
+
+// wrap returns hashFunc extended to record the CID of every file it hashes
+// successfully
+func (r *cidRecorder) wrap(hashFunc func(path string) (string, error)) func(path string) (string, error) {
+	return func(path string) (string, error) {
+		digest, err := hashFunc(path)
+		r.mu.Lock()
+		w := r.pending[path]
+		delete(r.pending, path)
+		r.mu.Unlock()
+		if err != nil {
+			return "", err
+		}
+
+		var cid string
+		if w != nil {
+			cid = w.Sum()
+		} else if cid, err = unixfs.FileCID(path); err != nil {
+			return "", fmt.Errorf("failed to compute CID: %w", err)
+		}
+		r.mu.Lock()
+		r.cids[path] = cid
+		r.mu.Unlock()
+		return digest, nil
+	}
+}
+
+// get returns the CID recorded for path; a nil recorder has none
+func (r *cidRecorder) get(path string) string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cids[path]
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"merkle-go/internal/compare"
+)
+
+// reportFlags are the flags of commands that print a change report
+type reportFlags struct {
+	noColor    bool
+	relative   bool
+	top        int
+	maxEntries int
+}
+
+func addReportFlags(fs *flag.FlagSet) *reportFlags {
+	r := &reportFlags{}
+	fs.BoolVar(&r.noColor, "no-color", false, "Do not color the report, even on a terminal")
+	fs.BoolVar(&r.relative, "relative", false, "Show paths relative to the directory instead of absolute")
+	fs.IntVar(&r.top, "top", 0, "Add a section with the byte totals and the N added or modified files that grew or shrank the most")
+	fs.IntVar(&r.maxEntries, "max-entries", 0, "List at most N changes of each kind in the text report and note how many more there are (0 = all)")
+	return r
+}
+
+// options returns how to render a report about the directory root
+func (r *reportFlags) options(root string) compare.ReportOptions {
+	opts := compare.ReportOptions{Color: !r.noColor && colorTerminal(), Top: r.top, MaxEntries: r.maxEntries}
+	if r.relative {
+		opts.RelativeTo = root
+	}
+	return opts
+}
+
+// colorTerminal reports whether stdout is a terminal that should get color,
+// honoring the NO_COLOR convention and TERM=dumb
+func colorTerminal() bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
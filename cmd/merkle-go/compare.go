@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"merkle-go/internal/anomaly"
+	"merkle-go/internal/compare"
+	"merkle-go/internal/config"
+	"merkle-go/internal/fsinfo"
+	"merkle-go/internal/notify"
+	"merkle-go/internal/progress"
+	"merkle-go/internal/tree"
+	"merkle-go/internal/version"
+	"merkle-go/internal/walker"
+)
+
+// notifyReportEntries is how many changes of each kind the report sent with
+// notifications lists; chat messages and mails cannot take more anyway
+const notifyReportEntries = 100
+
+// notifyReport is the text report sent with notifications about result
+func notifyReport(result *compare.CompareResult) string {
+	return compare.FormatReportWithOptions(result, compare.ReportOptions{MaxEntries: notifyReportEntries})
+}
+
+// sendNotifications delivers the event to every notifier configured in cfg
+func sendNotifications(cfg *config.Config, event notify.Event) error {
+	notifiers, err := notify.FromConfig(cfg.Notify)
+	if err != nil {
+		return err
+	}
+	if len(notifiers) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return notifiers.Notify(ctx, event)
+}
+
+// changeAlert records the change count of a scan of root in the alert
+// history and reports whether the changes should be notified, and why.
+// Without alert rules in cfg every change is, no reason is given and no
+// history is kept.
+func changeAlert(cfg *config.Config, root string, changes int) (bool, string) {
+	rule := anomaly.Rule{Threshold: cfg.AlertThreshold, Percentile: cfg.AlertPercentile, MinHistory: cfg.AlertMinHistory}
+	if !rule.Enabled() {
+		alert, _ := rule.Check(changes, nil)
+		return alert, ""
+	}
+	path := cfg.AlertHistory
+	if path == "" {
+		var err error
+		if path, err = anomaly.DefaultPath(); err != nil {
+			slog.Warn("Alerting on every change without a change history", "error", err)
+			return changes > 0, ""
+		}
+	}
+
+	history, err := anomaly.Load(path, root)
+	if err != nil {
+		slog.Warn("Ignoring change history", "error", err)
+	}
+	alert, reason := rule.Check(changes, history)
+	if err := anomaly.Append(path, anomaly.Record{Time: time.Now().UTC(), Root: root, Changes: changes}); err != nil {
+		slog.Warn("Failed to record change count", "error", err)
+	}
+	if alert {
+		slog.Warn("Unusual change volume", "root", root, "reason", reason)
+	} else if changes > 0 {
+		slog.Info("Usual change volume; not alerting", "root", root, "changes", changes, "earlier_scans", len(history))
+	}
+	return alert, reason
+}
+
+// warnSettingsMismatch warns if the saved tree was scanned with other
+// settings than the rescan will use, since files it skipped or included
+// differently would show up as changes
+func warnSettingsMismatch(saved *tree.MerkleTree, cfg *config.Config) {
+	if saved.GeneratorVersion != "" && saved.GeneratorVersion != version.String() {
+		slog.Info("Saved tree was written by a different merkle-go version", "saved", saved.GeneratorVersion, "current", version.String())
+	}
+	if saved.Scan == nil || saved.Scan.ConfigHash == "" {
+		return
+	}
+	if !slices.Equal(slices.Sorted(slices.Values(saved.Scan.Skip)), slices.Sorted(slices.Values(cfg.Skip))) {
+		slog.Warn("Saved tree was scanned with different skip patterns",
+			"saved", strings.Join(saved.Scan.Skip, ","), "current", strings.Join(cfg.Skip, ","))
+	} else if current := cfg.ScanHash(); saved.Scan.ConfigHash != current {
+		slog.Warn("Saved tree was scanned with different settings (e.g. filter_cmd or one_file_system)",
+			"saved", saved.Scan.ConfigHash, "current", current)
+	}
+}
+
+func compareTree(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	flags := addCommonFlags(fs)
+	exitFlags := addExitFlags(fs)
+	reportFlags := addReportFlags(fs)
+	strict := fs.Bool("strict", false, "Also report files whose modification time changed although their content did not")
+	noRenames := fs.Bool("no-renames", false, "Report moved files as deleted and added instead of renamed")
+	caseInsensitive := fs.Bool("case-insensitive", false, "Match paths that only differ in letter case, like case_sensitivity = \"insensitive\"")
+	stream := fs.Bool("stream", false, "Print changes as they are found instead of one report at the end")
+	showStats := fs.Bool("stats", false, "Print a breakdown of walk, hash and build times at the end")
+	useGit := fs.Bool("git", false, "Scan only the files git tracks in the directory, like generate --git")
+	full := fs.Bool("full", false, "Rehash every file, even those whose size and modification time are unchanged (implies --no-cache)")
+	oneFileSystem := fs.Bool("one-file-system", false, "Do not descend into mount points below the directory, like generate --one-file-system")
+	excludeCaches := fs.Bool("exclude-caches", false, "Leave out directories tagged with CACHEDIR.TAG, like generate --exclude-caches")
+	var excludeIfPresent stringList
+	fs.Var(&excludeIfPresent, "exclude-if-present", "Leave out directories containing a file with this name, like generate --exclude-if-present (repeatable)")
+	noCache := fs.Bool("no-cache", false, "Read every file instead of reusing hashes from the hash cache")
+	forceRootMismatch := fs.Bool("force-root-mismatch", false, "Compare even if the saved tree was generated from an unrelated directory")
+	format := fs.String("format", compare.FormatText, "Report format: text, html or markdown")
+	output := fs.String("output", "", "Write the report to this file instead of stdout")
+	templatePath := fs.String("template", "", "Render the html or markdown report with this Go template instead of the built-in one")
+	only := fs.String("only", "", "Only report these change types (comma-separated: added, modified, deleted, renamed, case-renamed, metadata)")
+	var pathFilters, excludePaths stringList
+	fs.Var(&pathFilters, "path-filter", "Only report changes under paths matching this glob, relative to the directory (repeatable)")
+	fs.Var(&excludePaths, "exclude-path", "Do not report changes under paths matching this glob, relative to the directory (repeatable)")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go compare [options] <tree.json> <directory>\n\n")
+		fmt.Fprintf(os.Stderr, "Compare saved merkle tree against current directory.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	onlyTypes, err := compare.ParseChangeTypes(*only)
+	if err != nil {
+		return err
+	}
+	reportFormat, err := compare.ValidateFormat(*format)
+	if err != nil {
+		return err
+	}
+	if *templatePath != "" && reportFormat == compare.FormatText {
+		return fmt.Errorf("--template needs --format html or markdown")
+	}
+	if *stream && reportFormat != compare.FormatText {
+		return fmt.Errorf("--stream only prints text reports")
+	}
+	if reportFlags.maxEntries < 0 {
+		return fmt.Errorf("--max-entries must not be negative")
+	}
+	if reportFlags.maxEntries > 0 && (*stream || reportFormat != compare.FormatText) {
+		return fmt.Errorf("--max-entries only shortens text reports printed at the end, not --stream, html or markdown reports")
+	}
+	filter := compare.Filter{Only: onlyTypes, Include: pathFilters, Exclude: excludePaths}
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+
+	closeLog, err := flags.setupLogging()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	treePath := fs.Arg(0)
+	directory := fs.Arg(1)
+
+	// Convert to absolute path
+	absDirectory, err := absPath(directory)
+	if err != nil {
+		return err
+	}
+
+	// Load saved tree
+	oldTree, err := flags.loadTree(ctx, treePath)
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+
+	slog.Info("Loaded saved tree", "path", treePath, "root", oldTree.Root.Hash)
+	if oldTree.MetadataOnly && *full {
+		return fmt.Errorf("%s records no content hashes, only sizes and modification times; compare it without --full", treePath)
+	}
+	if oldTree.Redacted {
+		return fmt.Errorf("%s is redacted; its paths match no files", treePath)
+	}
+
+	// Load config
+	cfg, err := flags.loadConfig(absDirectory)
+	if err != nil {
+		return err
+	}
+	policy, err := exitFlags.policy(cfg, flags)
+	if err != nil {
+		return err
+	}
+	foldCase, err := compare.ParseCaseSensitivity(cfg.CaseSensitivity)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	// Hash the directory the same way the saved tree was built
+	cfg.Portable = oldTree.Portable
+	cfg.BytewiseOrder = oldTree.BytewiseOrder
+	cfg.HashAlgorithm = oldTree.HashAlgorithm
+	cfg.EmptyDirs = oldTree.EmptyDirs
+	cfg.DescendArchives = oldTree.Archives
+	cfg.SegmentSize = oldTree.SegmentSize
+	cfg.Fingerprint = oldTree.Fingerprint
+	cfg.ChunkSize = oldTree.ChunkSize
+	cfg.CIDs = false         // CIDs play no part in the comparison
+	cfg.HashAlgorithms = nil // nor do other digests
+	if *oneFileSystem {
+		cfg.OneFileSystem = true
+	}
+	if *excludeCaches {
+		cfg.ExcludeCaches = true
+	}
+	cfg.ExcludeIfPresent = append(cfg.ExcludeIfPresent, excludeIfPresent...)
+	warnSettingsMismatch(oldTree, cfg)
+	if *noCache || *full {
+		cfg.HashCache = cacheOff
+	}
+	if *stream && len(cfg.DescendArchives) > 0 {
+		return fmt.Errorf("--stream cannot be used with trees that descend into archives")
+	}
+
+	slog.Info("Scanning directory", "path", absDirectory)
+
+	// Progress is shown in two phases: the walk stats every file, then
+	// only the files that may have changed are hashed. Streamed results
+	// would be garbled by it.
+	var counter *progress.Counter
+	if !*stream {
+		counter = flags.newCounter("Scanning (1/2)")
+		flags.hashPhase = "Hashing (2/2)"
+	}
+
+	// Walk directory
+	start := time.Now()
+	var walkResult *walker.WalkResult
+	if *useGit {
+		walkResult, err = walker.GitFiles(ctx, absDirectory)
+		if err == nil && counter != nil {
+			for _, fileInfo := range walkResult.Files {
+				counter.Add(fileInfo.Size)
+			}
+		}
+	} else {
+		walkOpts := walkOptions(cfg)
+		if counter != nil {
+			walkOpts.OnFile = func(fileInfo walker.FileInfo) { counter.Add(fileInfo.Size) }
+		}
+		walkResult, err = walker.WalkWithOptions(ctx, absDirectory, walkOpts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to walk directory: %w", err)
+	}
+	if counter != nil {
+		counter.Finish()
+	}
+	walkTime := time.Since(start)
+	walkedFiles := make(map[string]tree.FileData, len(walkResult.Files))
+	walkedPaths := make([]string, 0, len(walkResult.Files))
+	for _, fileInfo := range walkResult.Files {
+		walkedFiles[fileInfo.Path] = tree.FileData{Size: fileInfo.Size, ModTime: fileInfo.ModTime}
+		walkedPaths = append(walkedPaths, fileInfo.Path)
+	}
+	if cfg.EmptyDirs {
+		for _, dir := range walkResult.EmptyDirs {
+			walkedFiles[dir.Path] = emptyDirData(dir)
+			walkedPaths = append(walkedPaths, dir.Path)
+		}
+	}
+
+	// Warn if the directory is on a different volume than the one scanned originally
+	volume, err := fsinfo.Lookup(absDirectory)
+	if err == nil && !fsinfo.SameVolume(oldTree.Volume, volume) {
+		slog.Warn("Saved tree was generated on a different volume",
+			"saved", oldTree.Volume.String(), "current", volume.String())
+	}
+
+	// Align trees generated from different root paths by relative path
+	if filepath.Clean(oldTree.RootPath) != filepath.Clean(absDirectory) {
+		walkedTree := &tree.MerkleTree{RootPath: absDirectory, Files: walkedFiles}
+		alignedTree, err := compare.AlignRoots(oldTree, walkedTree)
+		if err != nil {
+			if !errors.Is(err, compare.ErrRootMismatch) || !*forceRootMismatch {
+				return fmt.Errorf("%w (use --force-root-mismatch to compare anyway)", err)
+			}
+			slog.Warn("Comparing trees from unrelated roots", "error", err)
+		} else {
+			slog.Info("Saved tree root differs; matching files by relative path",
+				"saved", oldTree.RootPath, "current", absDirectory)
+		}
+		oldTree = alignedTree
+	}
+
+	// Virtual files were piped in, not read from the directory, so they are
+	// carried over rather than reported as deleted
+	virtual := make(map[string]tree.FileData)
+	for path, data := range oldTree.Files {
+		if _, onDisk := walkedFiles[path]; data.Virtual && !onDisk {
+			virtual[path] = data
+			walkedPaths = append(walkedPaths, path)
+		}
+	}
+	if len(virtual) > 0 {
+		slog.Info("Keeping virtual files of the saved tree", "count", len(virtual))
+	}
+
+	// Only hash files that are new or whose size or modification time changed
+	hashWalk := walkResult
+	var plan *compare.Plan
+	if !*full {
+		plan = compare.NewPlan(oldTree, walkedFiles)
+		hashWalk = &walker.WalkResult{
+			Files:     make([]walker.FileInfo, 0, len(plan.Hash)),
+			Errors:    walkResult.Errors,
+			EmptyDirs: walkResult.EmptyDirs,
+		}
+		var hashBytes int64
+		for _, fileInfo := range walkResult.Files {
+			if _, reused := plan.Reuse[fileInfo.Path]; !reused {
+				hashWalk.Files = append(hashWalk.Files, fileInfo)
+				hashBytes += fileInfo.Size
+			}
+		}
+		slog.Info("Reusing saved hashes of unchanged files", "reused", len(plan.Reuse), "to_hash", len(plan.Hash),
+			"to_hash_size", tree.FormatSize(hashBytes))
+	}
+
+	// Renames, case-only ones included, can only be paired once everything
+	// is hashed, so streamed reports show them as a deletion and an addition
+	opts := compare.Options{
+		Strict:          *strict,
+		NoRenames:       *noRenames || *stream,
+		CaseInsensitive: (foldCase || *caseInsensitive) && !*stream,
+	}
+	reportOpts := reportFlags.options(absDirectory)
+
+	// The report goes to stdout or, uncolored, to --output
+	var out io.Writer = os.Stdout
+	var reportFile *os.File
+	if *output != "" {
+		if reportFile, err = os.Create(*output); err != nil {
+			return fmt.Errorf("failed to create report: %w", err)
+		}
+		defer reportFile.Close()
+		out = reportFile
+		reportOpts.Color = false
+	}
+
+	// With --stream, print each change as soon as it is known
+	var onResult func(path, hash string, err error)
+	if *stream {
+		fmt.Fprintln(out, "Changes (streaming):")
+		streamer := compare.NewStreamer(oldTree, opts, func(change compare.Change) {
+			if filter.Allows(change, absDirectory) {
+				fmt.Fprint(out, compare.FormatChangeWithOptions(change, reportOpts))
+			}
+		})
+		streamer.Walked(walkedPaths, scanErrors(walkResult.Errors))
+		if cfg.EmptyDirs {
+			for _, dir := range walkResult.EmptyDirs {
+				streamer.Hashed(dir.Path, emptyDirData(dir))
+			}
+		}
+		onResult = func(path, hash string, err error) {
+			if err != nil {
+				streamer.Failed(path, err)
+				return
+			}
+			fileData := walkedFiles[path]
+			fileData.Hash = hash
+			streamer.Hashed(path, fileData)
+		}
+	}
+
+	scan, err := hashAndBuild(ctx, absDirectory, hashWalk, cfg, flags, onResult)
+	if err != nil {
+		return err
+	}
+	known := virtual
+	if plan != nil {
+		maps.Copy(known, plan.Reuse)
+	}
+	if err := rebuildWith(scan, known, absDirectory, cfg); err != nil {
+		return err
+	}
+	scan.Stats.Walk = walkTime
+	newTree := scan.Tree
+	newTree.Volume = volume
+	hashResult := scan.Hash
+
+	// Compare trees
+	result := filter.Apply(compare.CompareWithOptions(oldTree, newTree, opts), absDirectory)
+
+	// Print report; when streaming, the changes have already been printed
+	switch {
+	case *stream:
+		fmt.Fprintf(out, "\n%s\n", compare.FormatSummary(result))
+	case reportFormat != compare.FormatText:
+		data := compare.NewReportData(result, absDirectory, treePath)
+		if err := compare.WriteReport(out, reportFormat, *templatePath, data); err != nil {
+			return err
+		}
+	default:
+		if err := compare.WriteTextReport(out, result, reportOpts); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+		fmt.Fprintln(out)
+	}
+	if reportFile != nil {
+		if err := reportFile.Close(); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+		slog.Info("Wrote report", "path", *output)
+	}
+
+	event := notify.Event{
+		Title:    "merkle-go compare",
+		RootPath: absDirectory,
+		Time:     time.Now(),
+		Added:    len(result.Added),
+		Modified: len(result.Modified),
+		Deleted:  len(result.Deleted),
+		Renamed:  len(result.Renamed),
+		Errors:   len(hashResult.Errors),
+		Report:   notifyReport(result),
+
+		MetadataOnly: len(result.MetadataOnly),
+		CaseRenamed:  len(result.CaseRenamed),
+	}
+	alert, reason := changeAlert(cfg, absDirectory, result.Count())
+	event.Alert = reason
+	if alert || event.Errors > 0 {
+		if err := sendNotifications(cfg, event); err != nil {
+			slog.Warn("Failed to send notifications", "error", err)
+		}
+	}
+
+	if *showStats {
+		printStats(&scan.Stats)
+	}
+	reportErrors(hashResult.Errors)
+
+	return policy.exit(result.Count(), len(hashResult.Errors))
+}
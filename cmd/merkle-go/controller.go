@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"merkle-go/internal/fleet"
+	"merkle-go/internal/systemd"
+)
+
+// runController serves the fleet controller until interrupted
+func runController(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("controller", flag.ExitOnError)
+	flags := addCommonFlags(fs)
+	listen := fs.String("listen", ":7443", "Address to listen on")
+	storeDir := fs.String("store", "fleet", "Directory holding the expected manifests and latest reports of the hosts")
+	certFile := fs.String("cert", "", "TLS certificate file")
+	keyFile := fs.String("key", "", "TLS key file")
+	clientCA := fs.String("client-ca", "", "CA certificate that issues the agents' client certificates, each naming its host")
+	insecureMode := fs.Bool("insecure", false, "Accept agents without client certificates, and serve plaintext without --cert; only for trusted networks")
+	allowReenroll := fs.Bool("allow-reenroll", false, "Let agents replace the expected manifest of a host that is already enrolled")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go controller [options]\n\n")
+		fmt.Fprintf(os.Stderr, "Serve the fleet controller: agents enroll the expected manifest of their host\n")
+		fmt.Fprintf(os.Stderr, "and report the result of every scan, which is checked against it. Agents must\n")
+		fmt.Fprintf(os.Stderr, "present a client certificate issued by --client-ca that names their host, unless\n")
+		fmt.Fprintf(os.Stderr, "--insecure is given. Reported root hashes are taken at the agent's word.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || (*certFile == "") != (*keyFile == "") || (!*insecureMode && (*certFile == "" || *clientCA == "")) {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	closeLog, err := flags.setupLogging()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	store, err := fleet.NewStore(*storeDir)
+	if err != nil {
+		return err
+	}
+	store.AllowReenroll = *allowReenroll
+
+	var opts []grpc.ServerOption
+	switch {
+	case !*insecureMode:
+		tlsConfig, err := fleet.ServerTLSConfig(*certFile, *keyFile, *clientCA)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		store.RequireClientCert = true
+	case *certFile != "":
+		creds, err := credentials.NewServerTLSFromFile(*certFile, *keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+		slog.Warn("Accepting agents without client certificates; any agent can report for any host")
+	default:
+		slog.Warn("Serving without TLS or client certificates; only use --insecure on trusted networks")
+	}
+	server := grpc.NewServer(opts...)
+	fleet.RegisterControllerServer(server, store)
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+	slog.Info("Controller listening", "address", listener.Addr().String(), "store", *storeDir)
+	stopNotify := systemd.Start(ctx, "Listening on "+listener.Addr().String())
+	defer stopNotify()
+	if err := server.Serve(listener); err != nil {
+		return fmt.Errorf("controller stopped: %w", err)
+	}
+	return ctx.Err()
+}
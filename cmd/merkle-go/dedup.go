@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"merkle-go/internal/chunk"
+	"merkle-go/internal/tree"
+	"merkle-go/internal/walker"
+)
+
+// printDedupReport writes the chunk-level and whole-file duplicate summary
+func printDedupReport(report *chunk.Report, rootPath string, top int) {
+	percent := func(part int64) float64 {
+		if report.TotalBytes == 0 {
+			return 0
+		}
+		return float64(part) / float64(report.TotalBytes) * 100
+	}
+
+	fmt.Printf("Files:             %d\n", report.Files)
+	fmt.Printf("Total data:        %s\n", tree.FormatSize(report.TotalBytes))
+	fmt.Printf("Chunks:            %d (%d unique)\n", report.TotalChunks, report.UniqueChunks)
+	fmt.Printf("Unique data:       %s\n", tree.FormatSize(report.UniqueBytes))
+	fmt.Printf("Dedupable (chunk): %s (%.1f%%)\n", tree.FormatSize(report.DedupableBytes()), percent(report.DedupableBytes()))
+	fmt.Printf("Duplicate files:   %s (%.1f%%) in %d groups\n",
+		tree.FormatSize(report.DuplicateFileBytes()), percent(report.DuplicateFileBytes()), len(report.DuplicateGroups))
+
+	if len(report.DuplicateGroups) == 0 || top == 0 {
+		return
+	}
+
+	fmt.Printf("\nLargest duplicate groups:\n")
+	for i, group := range report.DuplicateGroups {
+		if i == top {
+			fmt.Printf("  ... %d more groups\n", len(report.DuplicateGroups)-top)
+			break
+		}
+		fmt.Printf("  %s wasted, %d copies of %s (hash: %s)\n",
+			tree.FormatSize(group.WastedBytes()), len(group.Paths), tree.FormatSize(group.Size), group.Hash)
+		for _, path := range group.Paths {
+			if relPath, err := filepath.Rel(rootPath, path); err == nil {
+				path = relPath
+			}
+			fmt.Printf("    %s\n", path)
+		}
+	}
+}
+
+func dedupStats(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dedup", flag.ExitOnError)
+	flags := addCommonFlags(fs)
+	avgChunk := fs.Int("avg-chunk", chunk.DefaultAvgSize, "Average chunk size in bytes (power of two); min is avg/4, max is avg*8")
+	top := fs.Int("top", 10, "Number of duplicate file groups to list")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go dedup [options] <directory>\n\n")
+		fmt.Fprintf(os.Stderr, "Report duplicate data using content-defined chunking (FastCDC).\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	closeLog, err := flags.setupLogging()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	absDirectory, err := absPath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	cfg, err := flags.loadConfig(absDirectory)
+	if err != nil {
+		return err
+	}
+
+	analyzer, err := chunk.NewAnalyzer(chunk.ForAverage(*avgChunk))
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Scanning directory", "path", absDirectory)
+	walkResult, err := walker.WalkWithOptions(ctx, absDirectory, walkOptions(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to walk directory: %w", err)
+	}
+
+	slog.Info("Chunking files", "files", len(walkResult.Files), "workers", flags.workers)
+	bar := flags.newProgressBar(len(walkResult.Files))
+
+	jobs := make(chan walker.FileInfo)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	for i := 0; i < max(flags.workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fileInfo := range jobs {
+				if err := analyzer.AddFile(fileInfo.Path); err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("%s: %w", fileInfo.Path, err))
+					mu.Unlock()
+				}
+				if bar != nil {
+					bar.Increment()
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, fileInfo := range walkResult.Files {
+		select {
+		case jobs <- fileInfo:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if bar != nil {
+		bar.Finish()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	printDedupReport(analyzer.Report(), absDirectory, *top)
+	reportErrors(errs)
+	return nil
+}
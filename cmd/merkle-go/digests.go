@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"merkle-go/internal/config"
+	"merkle-go/internal/hash"
+	"merkle-go/internal/progress"
+	"merkle-go/internal/tree"
+)
+
+// digestRecorder hashes files with every algorithm of hash_algorithms in a
+// single read: its hash function returns the leaf digest and records the
+// others
+type digestRecorder struct {
+	algorithms []string // of the recorded digests, after the leaf's
+	hashFiles  func(path string) ([]string, error)
+
+	mu      sync.Mutex
+	digests map[string]map[string]string
+}
+
+// newDigestRecorder returns a recorder of the digests cfg.DigestAlgorithms
+// selects. If bar is not nil, it counts the bytes read; tee, if not nil, is
+// fed the content read (see hash.ReadOptions).
+func newDigestRecorder(cfg *config.Config, bar *progress.Bar, tee func(path string) io.Writer) (*digestRecorder, error) {
+	algorithms := cfg.DigestAlgorithms()
+	opts := hash.ReadOptions{Strategy: cfg.ReadStrategy, BufferSize: cfg.BufferSize, DigestWorkers: cfg.CPUWorkers, Tee: tee}
+	if bar != nil {
+		opts.OnRead = func(n int) { bar.AddBytes(int64(n)) }
+	}
+	hashFiles, err := hash.MultiFileHasher(append([]string{cfg.HashAlgorithm}, algorithms...), opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return &digestRecorder{algorithms: algorithms, hashFiles: hashFiles, digests: make(map[string]map[string]string)}, nil
+}
+
+// hash returns the leaf digest of the file at path and records the others
+func (r *digestRecorder) hash(path string) (string, error) {
+	digests, err := r.hashFiles(path)
+	if err != nil {
+		return "", err
+	}
+	recorded := make(map[string]string, len(r.algorithms))
+	for i, algorithm := range r.algorithms {
+		recorded[algorithm] = digests[i+1]
+	}
+	r.mu.Lock()
+	r.digests[path] = recorded
+	r.mu.Unlock()
+	return digests[0], nil
+}
+
+// get returns the digests recorded for path; a nil recorder has none
+func (r *digestRecorder) get(path string) map[string]string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.digests[path]
+}
+
+// hashAlgorithmsOf returns the hash_algorithms that rescan t the way it
+// was built, or nil if it records no digests besides its leaf hashes
+func hashAlgorithmsOf(t *tree.MerkleTree) []string {
+	if len(t.DigestAlgorithms) == 0 {
+		return nil
+	}
+	return t.Algorithms()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"merkle-go/internal/config"
+)
+
+// exitPolicy decides the exit code of commands that report changes and
+// errors: 2 when errors count, 1 when changes count, 0 otherwise
+type exitPolicy struct {
+	onChanges  bool
+	onErrors   bool
+	maxChanges int // changes tolerated before failing
+	maxErrors  int // errors tolerated before failing
+}
+
+// exitFlags are the flags that override the exit policy of the config
+type exitFlags struct {
+	failOn     string
+	maxChanges int
+	maxErrors  int
+}
+
+func addExitFlags(fs *flag.FlagSet) *exitFlags {
+	e := &exitFlags{}
+	fs.StringVar(&e.failOn, "fail-on", "", "What makes the exit code non-zero: changes, errors, changes,errors (default) or none")
+	fs.IntVar(&e.maxChanges, "max-changes", 0, "Only fail on changes if there are more than this many")
+	fs.IntVar(&e.maxErrors, "max-errors", 0, "Only fail on errors if there are more than this many")
+	return e
+}
+
+// policy combines the config's exit policy with the flags given on the
+// command line
+func (e *exitFlags) policy(cfg *config.Config, c *commonFlags) (exitPolicy, error) {
+	failOn := cfg.FailOn
+	if c.isSet("fail-on") {
+		failOn = e.failOn
+	}
+	policy, err := parseFailOn(failOn)
+	if err != nil {
+		return exitPolicy{}, err
+	}
+
+	policy.maxChanges = cfg.MaxChanges
+	if c.isSet("max-changes") {
+		policy.maxChanges = e.maxChanges
+	}
+	policy.maxErrors = cfg.MaxErrors
+	if c.isSet("max-errors") {
+		policy.maxErrors = e.maxErrors
+	}
+	if policy.maxChanges < 0 || policy.maxErrors < 0 {
+		return exitPolicy{}, fmt.Errorf("max-changes and max-errors must not be negative")
+	}
+	return policy, nil
+}
+
+// parseFailOn parses a comma-separated list of changes and errors, or none
+func parseFailOn(list string) (exitPolicy, error) {
+	if strings.TrimSpace(list) == "" {
+		return exitPolicy{onChanges: true, onErrors: true}, nil
+	}
+
+	var policy exitPolicy
+	for _, item := range strings.Split(list, ",") {
+		switch strings.ToLower(strings.TrimSpace(item)) {
+		case "changes":
+			policy.onChanges = true
+		case "errors":
+			policy.onErrors = true
+		case "none":
+		default:
+			return exitPolicy{}, fmt.Errorf("invalid fail-on value %q (want changes, errors or none)", item)
+		}
+	}
+	return policy, nil
+}
+
+// exit returns the exitError for the given number of changes and errors,
+// or nil if the command should succeed
+func (p exitPolicy) exit(changes, errs int) error {
+	if p.onErrors && errs > p.maxErrors {
+		return &exitError{code: 2} // Errors occurred
+	}
+	if p.onChanges && changes > p.maxChanges {
+		return &exitError{code: 1} // Changes detected
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"merkle-go/internal/compare"
+	"merkle-go/internal/hash"
+	"merkle-go/internal/pkgmanifest"
+	"merkle-go/internal/progress"
+	"merkle-go/internal/tree"
+	"merkle-go/internal/walker"
+)
+
+// exportTree writes a saved tree as a standard checksum manifest. Trees
+// usually store xxh64 digests, so every listed file is read again to compute
+// its SHA-256; the tree's own hash is checked in the same pass so the export
+// only vouches for content that still matches the tree.
+func exportTree(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	flags := addCommonFlags(fs)
+	format := fs.String("format", pkgmanifest.FormatSHA256Sums, "Output format: sha256sums, mtree or bagit")
+	output := fs.String("o", "", "Output file for sha256sums and mtree (default stdout), or bag directory for bagit (required)")
+	var prefixMaps stringList
+	fs.Var(&prefixMaps, "map-prefix", "Read the files recorded under one path from another, e.g. /data=/mnt/snapshot/data (repeatable)")
+	redactPaths := fs.Bool("redact-paths", false, "Write the tree itself with every path segment replaced by its keyed hash, keeping the root hash, instead of checksums")
+	redactKey := fs.String("redact-key", "", "Key file for --redact-paths, so the owner can later show which name a hash stands for (default: a random key that is thrown away)")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go export [options] <tree.json> [directory]\n\n")
+		fmt.Fprintf(os.Stderr, "Write the files of a saved tree as a SHA256SUMS file, an mtree specification or\n")
+		fmt.Fprintf(os.Stderr, "BagIt tag files, so they can be verified without merkle-go. The files are reread\n")
+		fmt.Fprintf(os.Stderr, "to compute SHA-256 digests; if directory is given they are looked up relative to it.\n")
+		fmt.Fprintf(os.Stderr, "With --redact-paths, the tree is written as a manifest whose file and directory\n")
+		fmt.Fprintf(os.Stderr, "names are hashed, to share as proof of integrity without revealing them.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *redactPaths {
+		if fs.NArg() == 2 || len(prefixMaps) > 0 || flags.isSet("format") {
+			return fmt.Errorf("--redact-paths writes the tree itself; it takes no directory, --map-prefix or --format")
+		}
+	} else if *redactKey != "" {
+		return fmt.Errorf("--redact-key needs --redact-paths")
+	}
+	switch *format {
+	case pkgmanifest.FormatSHA256Sums, pkgmanifest.FormatMtree:
+	case pkgmanifest.FormatBagIt:
+		if *output == "" {
+			return fmt.Errorf("--format bagit needs -o <bag directory>")
+		}
+	default:
+		return fmt.Errorf("unknown export format %q (want sha256sums, mtree or bagit)", *format)
+	}
+
+	closeLog, err := flags.setupLogging()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+	if *redactPaths {
+		return exportRedacted(fs.Arg(0), *output, *redactKey)
+	}
+
+	treePath := fs.Arg(0)
+	manifest, err := flags.loadTree(ctx, treePath)
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+	slog.Info("Loaded saved tree", "path", treePath, "root", manifest.Root.Hash, "files", len(manifest.Files))
+
+	if len(prefixMaps) > 0 {
+		if fs.NArg() == 2 {
+			return fmt.Errorf("--map-prefix cannot be combined with a directory")
+		}
+		if manifest, err = mapPrefixes(manifest, prefixMaps); err != nil {
+			return err
+		}
+	}
+	if fs.NArg() == 2 {
+		absDirectory, err := absPath(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		manifest, err = compare.AlignRoots(manifest, &tree.MerkleTree{RootPath: absDirectory})
+		if err != nil {
+			return err
+		}
+	}
+
+	paths := make([]string, 0, len(manifest.Files))
+	for path, data := range manifest.Files {
+		// Checksum manifests list files only
+		if !data.Dir {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	files := make([]walker.FileInfo, 0, len(paths))
+	for _, path := range paths {
+		files = append(files, walker.FileInfo{Path: path, Size: manifest.Files[path].Size})
+	}
+
+	key, err := flags.manifestKey(manifest)
+	if err != nil {
+		return err
+	}
+	flags.chooseWorkers(nil, manifest.RootPath)
+	slog.Info("Hashing files", "files", len(files), "algorithm", hash.SHA256, "workers", flags.workers)
+	// The progress bar draws on stdout, where the checksums may be going
+	var bar *progress.Bar
+	if *output != "" {
+		bar = flags.newProgressBar(len(files))
+	}
+	// Files hashed in segments need a separate read to check them
+	segmentHash, err := hash.FileHasher(manifest.LeafAlgorithm(), hash.ReadOptions{SegmentSize: manifest.SegmentSize, Key: key})
+	if err != nil {
+		return err
+	}
+	hashResult, err := walker.HashFilesWithOptions(ctx, files, walker.HashOptions{
+		Workers:     flags.workers,
+		Progress:    bar,
+		FileTimeout: flags.fileTimeout,
+		HashFunc: func(path string) (string, error) {
+			// Sampled files are checked against their sample, then read whole
+			if sample := manifest.Files[path].Sampled; sample > 0 {
+				leaf, _, err := hash.HashFileSample(path, manifest.LeafAlgorithm(), sample, key)
+				if err != nil {
+					return "", err
+				}
+				if leaf != manifest.Files[path].Hash {
+					return "", fmt.Errorf("content no longer matches the tree")
+				}
+				return hash.HashFileWith(path, hash.SHA256)
+			}
+			if manifest.SegmentSize > 0 && manifest.Files[path].Size > manifest.SegmentSize {
+				leaf, err := segmentHash(path)
+				if err != nil {
+					return "", err
+				}
+				if leaf != manifest.Files[path].Hash {
+					return "", fmt.Errorf("content no longer matches the tree")
+				}
+				return hash.HashFileWith(path, hash.SHA256)
+			}
+			digests, err := hash.HashFileMultiKeyed(path, key, manifest.LeafAlgorithm(), hash.SHA256)
+			if err != nil {
+				return "", err
+			}
+			if digests[0] != manifest.Files[path].Hash {
+				return "", fmt.Errorf("content no longer matches the tree")
+			}
+			return digests[1], nil
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to hash files: %w", err)
+	}
+	if bar != nil {
+		bar.Finish()
+	}
+
+	// A checksum manifest with files silently missing would be misleading
+	if len(hashResult.Errors) > 0 {
+		for _, err := range hashResult.Errors {
+			slog.Error("Cannot export file", "error", err)
+		}
+		return fmt.Errorf("%d files could not be exported; nothing was written", len(hashResult.Errors))
+	}
+
+	entries := make([]pkgmanifest.Entry, 0, len(paths))
+	for _, path := range paths {
+		entry := pkgmanifest.Entry{
+			Path:      relSlash(manifest.RootPath, path),
+			Algorithm: hash.SHA256,
+			Digest:    hashResult.Hashes[path],
+			Size:      manifest.Files[path].Size,
+			ModTime:   manifest.Files[path].ModTime,
+		}
+		// Trees do not record permissions; mtree lists the current ones
+		if *format == pkgmanifest.FormatMtree {
+			if info, err := os.Lstat(path); err == nil {
+				entry.Mode = info.Mode()
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	if *format == pkgmanifest.FormatBagIt {
+		if err := pkgmanifest.WriteBag(*output, entries, hash.SHA256, time.Now()); err != nil {
+			return err
+		}
+		slog.Info("Wrote bag tag files", "path", *output, "files", len(entries),
+			"payload", filepath.Join(*output, "data"))
+		return nil
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if *format == pkgmanifest.FormatMtree {
+		err = pkgmanifest.WriteMtree(out, entries)
+	} else {
+		err = pkgmanifest.WriteChecksums(out, entries)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write checksums: %w", err)
+	}
+	if *output != "" {
+		slog.Info("Wrote checksums", "path", *output, "files", len(entries))
+	}
+	return nil
+}
+
+// exportRedacted writes the tree at treePath with every path segment
+// replaced by its HMAC-SHA256 with the key in keyPath, or with a random key
+// if keyPath is empty, to output or stdout. The root hash stays the same,
+// so the redacted manifest vouches for the same content.
+func exportRedacted(treePath, output, keyPath string) error {
+	manifest, err := tree.Load(treePath)
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+
+	var key []byte
+	if keyPath != "" {
+		if key, err = os.ReadFile(keyPath); err != nil {
+			return fmt.Errorf("failed to read redaction key: %w", err)
+		}
+		if len(key) == 0 {
+			return fmt.Errorf("redaction key file %s is empty", keyPath)
+		}
+	} else {
+		// Without a key to keep, names cannot be revealed later, not even
+		// by guessing them
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return fmt.Errorf("failed to generate redaction key: %w", err)
+		}
+	}
+	redacted := tree.Redact(manifest, func(segment string) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(segment))
+		return hex.EncodeToString(mac.Sum(nil)[:16])
+	})
+
+	if output == "" {
+		w := bufio.NewWriter(os.Stdout)
+		if err := tree.EncodeTo(w, redacted, false); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+	if err := tree.Save(redacted, output); err != nil {
+		return fmt.Errorf("failed to save redacted tree: %w", err)
+	}
+	slog.Info("Wrote redacted tree", "path", output, "root", redacted.Root.Hash, "files", len(redacted.Files))
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"merkle-go/internal/tree"
+)
+
+// findFiles lists the files of saved trees matching a content hash or path
+// globs, e.g. to tell which snapshots contained a given file content
+func findFiles(args []string) error {
+	fs := flag.NewFlagSet("find", flag.ExitOnError)
+	hashFlag := fs.String("hash", "", "Only list files with this content hash (or IPFS CID); see merkle-go hash to compute one")
+	var pathGlobs stringList
+	fs.Var(&pathGlobs, "path-glob", "Only list files whose path relative to the root matches this glob, e.g. '**/*.conf' (repeatable)")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go find [options] <tree.json>... [--hash <hash>] [--path-glob <glob>]\n\n")
+		fmt.Fprintf(os.Stderr, "List the files of saved trees matching every given criterion with their size,\n")
+		fmt.Fprintf(os.Stderr, "modification time and path relative to the root; with several trees, each line\n")
+		fmt.Fprintf(os.Stderr, "starts with the tree it was found in. Exits with 1 if nothing matches.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	// Allow options after the trees, e.g. "find tree.json --hash <hash>"
+	var manifests []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		manifests = append(manifests, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(manifests) == 0 || (*hashFlag == "" && len(pathGlobs) == 0) {
+		fs.Usage()
+		os.Exit(1)
+	}
+	query := tree.Query{Hash: *hashFlag, PathGlobs: pathGlobs}
+	if err := query.Validate(); err != nil {
+		return err
+	}
+
+	found := 0
+	for _, manifest := range manifests {
+		t, err := tree.Load(manifest)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", manifest, err)
+		}
+		if query.Hash != "" && t.MetadataOnly {
+			fmt.Fprintf(os.Stderr, "Skipping %s: it records no content hashes\n", manifest)
+			continue
+		}
+		for _, match := range tree.Find(t, query) {
+			if len(manifests) > 1 {
+				fmt.Printf("%s  ", manifest)
+			}
+			modTime := "-"
+			if !match.Data.ModTime.IsZero() {
+				modTime = match.Data.ModTime.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%12d  %-19s  %s\n", match.Data.Size, modTime, match.Path)
+			found++
+		}
+	}
+	if found == 0 {
+		return &exitError{code: 1}
+	}
+	return nil
+}
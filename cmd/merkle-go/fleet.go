@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"merkle-go/internal/fleet"
+)
+
+// fleetCheck scans many hosts over SSH and compares each with one manifest
+func fleetCheck(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("fleet", flag.ExitOnError)
+	flags := addCommonFlags(fs)
+	hostsPath := fs.String("hosts", "", "File listing the hosts to scan, one per line, optionally followed by the directory to scan there")
+	manifestPath := fs.String("manifest", "", "Saved tree every host is compared with")
+	directory := fs.String("directory", "", "Directory to scan on hosts that do not name one (default: the root path of the manifest)")
+	sshCommand := fs.String("ssh", "ssh -o BatchMode=yes", "ssh command and options; the host and remote command are appended")
+	remoteCommand := fs.String("remote-command", "merkle-go", "merkle-go program on the remote hosts")
+	parallel := fs.Int("parallel", 8, "Number of hosts scanned at once")
+	remoteKey := fs.String("remote-hash-key", "", "Hash key file on the remote hosts, for hmac-sha256 manifests (default: the config's hash_key)")
+	format := fs.String("format", "text", "Output format: text or json")
+	output := fs.String("o", "", "Write the report to this file instead of stdout")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go fleet [options] --hosts <hosts.txt> --manifest <tree.json>\n\n")
+		fmt.Fprintf(os.Stderr, "Scan every listed host over SSH with the merkle-go installed there, compare\n")
+		fmt.Fprintf(os.Stderr, "it with the manifest and report per host whether its root hash matches and\n")
+		fmt.Fprintf(os.Stderr, "which files differ. Remote scans hash files the way the manifest was built.\n")
+		fmt.Fprintf(os.Stderr, "Exits with 1 if a host differs and 2 if a host could not be scanned.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *hostsPath == "" || *manifestPath == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q (want text or json)", *format)
+	}
+	sshArgs := strings.Fields(*sshCommand)
+	if len(sshArgs) == 0 {
+		return fmt.Errorf("--ssh must name a command")
+	}
+
+	closeLog, err := flags.setupLogging()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	cfg, err := flags.loadConfig("")
+	if err != nil {
+		return err
+	}
+	expected, err := flags.loadTree(ctx, *manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+	if *directory == "" {
+		*directory = expected.RootPath
+	}
+	if *remoteKey == "" {
+		*remoteKey = cfg.HashKey
+	}
+
+	f, err := os.Open(*hostsPath)
+	if err != nil {
+		return fmt.Errorf("failed to open hosts file: %w", err)
+	}
+	hosts, err := fleet.ParseHosts(f, *directory)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("invalid hosts file %s: %w", *hostsPath, err)
+	}
+
+	slog.Info("Scanning hosts over SSH", "hosts", len(hosts), "parallel", *parallel)
+	results := fleet.CheckHosts(ctx, hosts, expected, fleet.SSHOptions{
+		SSH:       sshArgs,
+		Command:   *remoteCommand,
+		Skip:      cfg.Skip,
+		SkipRegex: cfg.SkipRegex,
+		Parallel:  *parallel,
+		HashKey:   *remoteKey,
+	})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		out, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer out.Close()
+		w = out
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(results)
+	} else {
+		err = fleet.WriteHostsReport(w, expected.Root.Hash, results)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+
+	code := 0
+	for _, result := range results {
+		switch {
+		case result.Error != "":
+			code = 2
+		case !result.Match && code == 0:
+			code = 1
+		}
+	}
+	if code != 0 {
+		return &exitError{code: code}
+	}
+	return nil
+}
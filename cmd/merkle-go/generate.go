@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"merkle-go/internal/archive"
+	"merkle-go/internal/attest"
+	"merkle-go/internal/chunk"
+	"merkle-go/internal/config"
+	"merkle-go/internal/fsinfo"
+	"merkle-go/internal/hash"
+	"merkle-go/internal/objstore"
+	"merkle-go/internal/tree"
+	"merkle-go/internal/walker"
+)
+
+// printDryRun lists the files a scan would hash, relative to the scanned
+// directory, followed by the total number of bytes that would be read
+func printDryRun(walkResult *walker.WalkResult, rootPath string) {
+	var totalSize int64
+	for _, fileInfo := range walkResult.Files {
+		relPath, err := filepath.Rel(rootPath, fileInfo.Path)
+		if err != nil {
+			relPath = fileInfo.Path
+		}
+		fmt.Printf("%12d  %s\n", fileInfo.Size, relPath)
+		totalSize += fileInfo.Size
+	}
+
+	fmt.Printf("\nDry run: %d files, %s to hash\n", len(walkResult.Files), tree.FormatSize(totalSize))
+	if len(walkResult.Errors) > 0 {
+		slog.Warn("Some paths could not be read during the walk", "count", len(walkResult.Errors))
+	}
+}
+
+// sourceDateEpoch returns the time set by the SOURCE_DATE_EPOCH environment
+// variable of reproducible builds, or the Unix epoch if it is unset
+func sourceDateEpoch() (time.Time, error) {
+	value := os.Getenv("SOURCE_DATE_EPOCH")
+	if value == "" {
+		return time.Unix(0, 0), nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q (want seconds since 1970)", value)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// saveCheckpoint writes the partial tree of an interrupted scan
+func saveCheckpoint(partial *tree.MerkleTree, path string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		slog.Error("Failed to create checkpoint directory", "error", err)
+		return
+	}
+	if err := tree.Save(partial, path); err != nil {
+		slog.Error("Failed to save checkpoint", "error", err)
+		return
+	}
+	slog.Warn("Saved partial tree", "path", path, "files", len(partial.Files))
+}
+
+func generateTree(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("merkle-go", flag.ExitOnError)
+	flags := addCommonFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "List the files that would be hashed without hashing them")
+	portable := fs.Bool("portable", false, "Build a platform-independent tree: NFC paths, byte-wise order, no mtimes")
+	bytewiseOrder := fs.Bool("bytewise-order", false, "Order leaves byte-wise by forward-slash path on every platform (recorded in the manifest)")
+	emptyDirs := fs.Bool("empty-dirs", false, "Record empty directories so adding or removing one is detected")
+	oneFileSystem := fs.Bool("one-file-system", false, "Do not descend into mount points below the directory")
+	excludeCaches := fs.Bool("exclude-caches", false, "Leave out directories tagged as caches with a CACHEDIR.TAG file")
+	var excludeIfPresent stringList
+	fs.Var(&excludeIfPresent, "exclude-if-present", "Leave out directories containing a file with this name, e.g. .nobackup (repeatable)")
+	parallelLarge := fs.Bool("parallel-large-files", false, "Hash files over 64 MiB as a Merkle tree of segments read on all cores (recorded in the manifest)")
+	chunks := fs.Bool("chunks", false, "Record the content-defined chunks of every file (8 KiB average) so compare can tell how much of a modified file changed")
+	cids := fs.Bool("cids", false, "Record the IPFS CIDv1 of every file, as ipfs add --cid-version 1 computes it")
+	noCache := fs.Bool("no-cache", false, "Read every file instead of reusing hashes from the hash cache")
+	rootOnly := fs.Bool("root-only", false, "Print only the root hash on stdout and write no manifest")
+	showStats := fs.Bool("stats", false, "Print a breakdown of walk, hash, build and save times at the end")
+	embedStats := fs.Bool("embed-stats", false, "Record the scan statistics in the manifest")
+	reproducible := fs.Bool("reproducible", false, "Write the same manifest bytes for the same content: record no volume, workers or duration and take the created time from SOURCE_DATE_EPOCH (default 0)")
+	retryPath := fs.String("retry-errors", "", "Rehash only the paths recorded as errors in this saved tree and keep its other files")
+	var saveOpts tree.SaveOptions
+	fs.BoolVar(&saveOpts.NoOverwrite, "no-overwrite", false, "Fail instead of replacing an existing output file")
+	fs.BoolVar(&saveOpts.Backup, "backup", false, "Keep an existing output file as <name>"+tree.BackupSuffix+" before replacing it")
+	fs.BoolVar(&saveOpts.Compact, "compact", false, "Write the manifest without indentation (--low-memory always does)")
+	attestPath := fs.String("attest", "", "Also write an in-toto statement about the directory and manifest to this file")
+	attestSign := fs.Bool("attest-sign", false, "Sign the --attest statement with Sigstore keyless signing (runs cosign sign-blob)")
+	checkpointPath := fs.String("checkpoint", "", "If interrupted, save a partial tree of the files hashed so far to this path")
+	filesFrom := fs.String("files-from", "", "Hash the files listed in this file (- for stdin), NUL- or newline-separated, instead of walking")
+	useGit := fs.Bool("git", false, "Hash only the files git tracks in the directory, honoring .gitignore")
+	descendArchives := fs.String("descend-archives", "", "Hash the members of these archive formats (comma-separated: tar, zip) as archive.tar!/member leaves")
+	var extraDirs stringList
+	fs.Var(&extraDirs, "dir", "Also scan this directory into the same tree (repeatable); the tree is rooted at the deepest directory containing them all")
+	keepGoing := fs.Bool("keep-going", false, "With --dir, record a directory that cannot be walked as a scan error and scan the others; exits with 2 if one was skipped")
+	lowMemory := fs.Bool("low-memory", false, "Build the tree through spill files on disk instead of in memory, for very large trees")
+	spillDir := fs.String("spill-dir", "", "Directory for the spill files of --low-memory (default: the system temporary directory)")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go [options] <directory> [output-json-filename]\n\n")
+		fmt.Fprintf(os.Stderr, "Generate a merkle tree from a directory tree and save it to a JSON file.\n")
+		fmt.Fprintf(os.Stderr, "--dir adds further directories to the same tree.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if *reproducible && *embedStats {
+		return fmt.Errorf("--embed-stats records timings, which differ between scans; drop it or --reproducible")
+	}
+
+	closeLog, err := flags.setupLogging()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	directory := fs.Arg(0)
+	var outputPath string
+	if fs.NArg() == 2 {
+		if *rootOnly {
+			return fmt.Errorf("--root-only writes no manifest; drop the output filename")
+		}
+		outputPath = fs.Arg(1)
+	}
+	// Only the root hash may reach stdout, which is usually captured
+	flags.noProgress = *rootOnly
+
+	// Convert to absolute path
+	absDirectory, err := absPath(directory)
+	if err != nil {
+		return err
+	}
+
+	// Several directories are scanned into one tree, rooted where merge
+	// would root their trees
+	roots := []string{absDirectory}
+	for _, dir := range extraDirs {
+		absDir, err := absPath(dir)
+		if err != nil {
+			return err
+		}
+		for _, root := range roots {
+			if within(absDir, root) || within(root, absDir) {
+				return fmt.Errorf("directories %s and %s overlap", root, absDir)
+			}
+		}
+		roots = append(roots, absDir)
+	}
+	if len(roots) > 1 {
+		absDirectory = tree.CommonRoot(roots)
+	} else if *keepGoing {
+		return fmt.Errorf("--keep-going needs several directories (--dir)")
+	}
+
+	// Load config
+	cfg, err := flags.loadConfig(absDirectory)
+	if err != nil {
+		return err
+	}
+
+	if *portable {
+		cfg.Portable = true
+	}
+	if *bytewiseOrder {
+		cfg.BytewiseOrder = true
+	}
+	if *emptyDirs {
+		cfg.EmptyDirs = true
+	}
+	if *noCache {
+		cfg.HashCache = cacheOff
+	}
+	if *parallelLarge && cfg.SegmentSize == 0 {
+		cfg.SegmentSize = hash.DefaultSegmentSize
+	}
+	if cfg.SegmentSize < 0 {
+		return fmt.Errorf("invalid config: segment_size must not be negative")
+	}
+	if *chunks && cfg.ChunkSize == 0 {
+		cfg.ChunkSize = chunk.DefaultAvgSize
+	}
+	if cfg.ChunkSize != 0 {
+		if err := chunk.ForAverage(cfg.ChunkSize).Validate(); err != nil {
+			return fmt.Errorf("invalid config: chunk_size: %w", err)
+		}
+	}
+	if *cids {
+		cfg.CIDs = true
+	}
+	if *oneFileSystem {
+		cfg.OneFileSystem = true
+	}
+	if *excludeCaches {
+		cfg.ExcludeCaches = true
+	}
+	cfg.ExcludeIfPresent = append(cfg.ExcludeIfPresent, excludeIfPresent...)
+	if *descendArchives == "" {
+		*descendArchives = strings.Join(cfg.DescendArchives, ",")
+	}
+	if cfg.DescendArchives, err = archive.ParseKinds(*descendArchives); err != nil {
+		return err
+	}
+
+	listFiles := fileSource(absDirectory, *filesFrom, *useGit)
+	if *filesFrom != "" && *useGit {
+		return fmt.Errorf("--files-from and --git cannot be combined")
+	}
+	if *lowMemory && (*retryPath != "" || *checkpointPath != "" || listFiles != nil) {
+		return fmt.Errorf("--low-memory cannot be combined with --retry-errors, --checkpoint, --files-from or --git")
+	}
+	if *lowMemory && len(cfg.DescendArchives) > 0 {
+		return fmt.Errorf("--low-memory cannot be combined with --descend-archives")
+	}
+	if *lowMemory && cfg.ChunkSize > 0 {
+		return fmt.Errorf("--low-memory cannot record chunks (--chunks or chunk_size)")
+	}
+	if *lowMemory && cfg.CIDs {
+		return fmt.Errorf("--low-memory cannot record CIDs (--cids or cids)")
+	}
+	if *lowMemory && len(cfg.DigestAlgorithms()) > 0 {
+		return fmt.Errorf("--low-memory records a single digest per file; drop hash_algorithms")
+	}
+	if listFiles != nil && *retryPath != "" {
+		return fmt.Errorf("--files-from and --git cannot be combined with --retry-errors")
+	}
+	if *attestSign && *attestPath == "" {
+		return fmt.Errorf("--attest-sign needs --attest")
+	}
+	var skippedRoots []string
+	if len(roots) > 1 {
+		if listFiles != nil || *lowMemory || *retryPath != "" {
+			return fmt.Errorf("--dir cannot be combined with --files-from, --git, --low-memory or --retry-errors")
+		}
+		listFiles = func(ctx context.Context) (*walker.WalkResult, error) {
+			walkResult, skipped, err := walkRoots(ctx, roots, cfg, *keepGoing)
+			skippedRoots = skipped
+			return walkResult, err
+		}
+	}
+
+	// Set output path - from args, config, or default
+	if outputPath == "" {
+		outputPath = cfg.OutputFile
+	}
+
+	// Fail before the scan rather than after it
+	remote := objstore.IsURL(outputPath)
+	if remote && (saveOpts.NoOverwrite || saveOpts.Backup) {
+		return fmt.Errorf("--no-overwrite and --backup only apply to local output files")
+	}
+	if remote {
+		if _, _, err := objstore.Open(outputPath); err != nil {
+			return err
+		}
+	}
+	if saveOpts.NoOverwrite && outputPath != "" {
+		if _, err := os.Lstat(outputPath); err == nil {
+			return fmt.Errorf("%w: %s", tree.ErrExists, outputPath)
+		}
+	}
+
+	if *dryRun {
+		var walkResult *walker.WalkResult
+		if listFiles != nil {
+			walkResult, err = listFiles(ctx)
+		} else {
+			walkResult, err = walker.WalkWithOptions(ctx, absDirectory, walkOptions(cfg))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to walk directory: %w", err)
+		}
+		printDryRun(walkResult, absDirectory)
+		return skippedRootsExit(skippedRoots)
+	}
+
+	var scan *scanResult
+	var builder *tree.StreamBuilder
+	if *lowMemory {
+		scan, builder, err = scanLowMemory(ctx, absDirectory, *spillDir, cfg, flags)
+		if err != nil {
+			return err
+		}
+		defer builder.Close()
+	} else if *retryPath != "" {
+		prev, err := flags.loadTree(ctx, *retryPath)
+		if err != nil {
+			return fmt.Errorf("failed to load tree: %w", err)
+		}
+		slog.Info("Loaded saved tree", "path", *retryPath, "root", prev.Root.Hash, "errors", len(prev.Errors))
+		scan, err = retryErrors(ctx, prev, absDirectory, cfg, flags)
+	} else if len(roots) > 1 {
+		slog.Info("Scanning directories", "paths", strings.Join(roots, ","), "root", absDirectory)
+		scan, err = scanWith(ctx, absDirectory, listFiles, cfg, flags)
+	} else if listFiles != nil {
+		slog.Info("Scanning listed files", "path", absDirectory)
+		scan, err = scanWith(ctx, absDirectory, listFiles, cfg, flags)
+	} else {
+		scan, err = scanDirectory(ctx, absDirectory, cfg, flags)
+	}
+	if err != nil {
+		if scan != nil && *checkpointPath != "" {
+			saveCheckpoint(scan.Tree, *checkpointPath)
+		}
+		return err
+	}
+	merkleTree := scan.Tree
+
+	if *rootOnly {
+		fmt.Println(merkleTree.Root.Hash)
+		if *attestPath != "" {
+			files := int64(len(merkleTree.Files))
+			if builder != nil {
+				files = builder.Count()
+			}
+			if err := writeAttestation(ctx, *attestPath, *attestSign, merkleTree, files, ""); err != nil {
+				return err
+			}
+		}
+		if *showStats {
+			printStats(&scan.Stats)
+		}
+		reportErrors(scan.Hash.Errors)
+		return skippedRootsExit(skippedRoots)
+	}
+
+	// Record which filesystem was scanned so compare can catch the wrong disk,
+	// and the settings so it can point out rescans with other ones;
+	// reproducible manifests leave out everything that is not the content
+	merkleTree.Scan = &tree.ScanParams{ConfigHash: cfg.ScanHash(), Skip: cfg.Skip, SkipRegex: cfg.SkipRegex}
+	if *reproducible {
+		created, err := sourceDateEpoch()
+		if err != nil {
+			return err
+		}
+		merkleTree.Created = created
+	} else {
+		if volume, err := fsinfo.Lookup(absDirectory); err == nil {
+			merkleTree.Volume = volume
+		}
+		merkleTree.Scan.Workers = flags.workers
+		merkleTree.Scan.Duration = scan.Stats.Walk + scan.Stats.Hash + scan.Stats.Build
+	}
+
+	// If no output path specified, use root hash as filename in ./output/
+	if outputPath == "" {
+		outputPath = filepath.Join("output", merkleTree.Root.Hash+".json")
+	}
+
+	// Ensure output directory exists. A manifest for object storage is
+	// saved to a temporary file and uploaded from there.
+	savePath := outputPath
+	if remote {
+		dir, err := os.MkdirTemp("", "merkle-go-upload-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		savePath = filepath.Join(dir, path.Base(outputPath))
+	} else if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// Save to file
+	if *embedStats {
+		embedded := scan.Stats
+		merkleTree.Stats = &embedded
+	}
+	start := time.Now()
+	files := int64(len(merkleTree.Files))
+	if builder != nil {
+		err = builder.Save(merkleTree, savePath, saveOpts)
+		files = builder.Count()
+	} else {
+		saveOpts.Workers = flags.ioWorkers
+		err = tree.SaveWithOptions(merkleTree, savePath, saveOpts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save tree: %w", err)
+	}
+	if remote {
+		if err := upload(ctx, savePath, outputPath); err != nil {
+			return err
+		}
+	}
+
+	scan.Stats.Save = time.Since(start)
+
+	slog.Info("Saved merkle tree", "path", outputPath, "root", merkleTree.Root.Hash, "files", files)
+	if *attestPath != "" {
+		if err := writeAttestation(ctx, *attestPath, *attestSign, merkleTree, files, savePath); err != nil {
+			return err
+		}
+	}
+	if *showStats {
+		printStats(&scan.Stats)
+	}
+
+	reportErrors(scan.Hash.Errors)
+
+	return skippedRootsExit(skippedRoots)
+}
+
+// walkRoots walks every root and combines what they hold. A root that
+// cannot be walked fails the walk or, with keepGoing, is recorded as a scan
+// error and returned among the skipped roots.
+func walkRoots(ctx context.Context, roots []string, cfg *config.Config, keepGoing bool) (*walker.WalkResult, []string, error) {
+	combined := &walker.WalkResult{
+		Files:     make([]walker.FileInfo, 0),
+		Errors:    make([]error, 0),
+		EmptyDirs: make([]walker.FileInfo, 0),
+
+		RetriesExhausted: make([]string, 0),
+	}
+	var skipped []string
+	for _, root := range roots {
+		walkResult, err := walker.WalkWithOptions(ctx, root, walkOptions(cfg))
+		if err != nil {
+			if !keepGoing || ctx.Err() != nil {
+				return nil, skipped, fmt.Errorf("failed to walk %s: %w", root, err)
+			}
+			slog.Error("Skipping directory that cannot be walked", "path", root, "error", err)
+			combined.Errors = append(combined.Errors, &walker.FileError{Path: root, Err: err})
+			skipped = append(skipped, root)
+			continue
+		}
+		combined.Files = append(combined.Files, walkResult.Files...)
+		combined.Errors = append(combined.Errors, walkResult.Errors...)
+		combined.EmptyDirs = append(combined.EmptyDirs, walkResult.EmptyDirs...)
+		combined.RetriesExhausted = append(combined.RetriesExhausted, walkResult.RetriesExhausted...)
+	}
+	if len(skipped) == len(roots) {
+		return nil, skipped, fmt.Errorf("none of the %d directories could be walked", len(roots))
+	}
+	return combined, skipped, nil
+}
+
+// skippedRootsExit returns the exit error of a scan that skipped roots with
+// --keep-going: the tree was saved, but it is incomplete
+func skippedRootsExit(skipped []string) error {
+	if len(skipped) == 0 {
+		return nil
+	}
+	slog.Warn("Tree is missing directories that could not be walked", "count", len(skipped), "paths", strings.Join(skipped, ","))
+	return &exitError{code: 2}
+}
+
+// within reports whether path is dir or lies under it
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// writeAttestation writes an in-toto statement about a scan and, if sign is
+// set, signs it with cosign
+func writeAttestation(ctx context.Context, path string, sign bool, merkleTree *tree.MerkleTree, files int64, manifestPath string) error {
+	statement, err := attest.New(merkleTree, files, manifestPath)
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create attestation directory: %w", err)
+		}
+	}
+	if err := attest.Write(statement, path); err != nil {
+		return err
+	}
+	slog.Info("Wrote attestation", "path", path, "subjects", len(statement.Subject))
+	if !sign {
+		return nil
+	}
+	bundle, err := attest.Sign(ctx, path)
+	if err != nil {
+		return err
+	}
+	slog.Info("Signed attestation", "bundle", bundle)
+	return nil
+}
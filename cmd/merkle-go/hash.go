@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"merkle-go/internal/config"
+	"merkle-go/internal/hash"
+	"merkle-go/internal/tree"
+)
+
+// hashCommand hashes stdin, named pipes or files as streams and prints their
+// digests, or with --into records one of them in a manifest as a virtual file
+func hashCommand(args []string) error {
+	fs := flag.NewFlagSet("hash", flag.ExitOnError)
+	algorithm := fs.String("algorithm", "", "Hash algorithm: xxh64, sha256, sha1 or md5 (default xxh64, or the manifest's with --into)")
+	into := fs.String("into", "", "Add the input to this saved tree as a virtual file instead of only printing its digest")
+	as := fs.String("as", "", "Path relative to the tree's root to record the input at (required with --into)")
+	keyPath := fs.String("hash-key", "", "File holding the key of hmac-sha256, e.g. to add to a keyed tree")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go hash [options] <-|file>...\n")
+		fmt.Fprintf(os.Stderr, "       merkle-go hash [options] --into <tree.json> --as <path> <-|file>\n\n")
+		fmt.Fprintf(os.Stderr, "Hash stdin (-), named pipes or files as streams and print their digests.\n")
+		fmt.Fprintf(os.Stderr, "With --into, record the content in the tree under the given logical path as\n")
+		fmt.Fprintf(os.Stderr, "a virtual file, e.g. a database dump generated on the fly:\n\n")
+		fmt.Fprintf(os.Stderr, "  pg_dump app | merkle-go hash --into tree.json --as dumps/app.sql -\n\n")
+		fmt.Fprintf(os.Stderr, "Virtual files are not on disk, so compare keeps them and check skips them.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	// Allow options after the inputs, e.g. "hash - --into tree.json --as dump.sql"
+	var inputs []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		inputs = append(inputs, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(inputs) == 0 || (*into != "") != (*as != "") {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *into != "" && len(inputs) != 1 {
+		return fmt.Errorf("--into records a single input, got %d", len(inputs))
+	}
+
+	var manifest *tree.MerkleTree
+	if *into != "" {
+		var err error
+		if manifest, err = tree.Load(*into); err != nil {
+			return fmt.Errorf("failed to load tree: %w", err)
+		}
+		if *algorithm == "" {
+			*algorithm = manifest.LeafAlgorithm()
+		} else if *algorithm != manifest.LeafAlgorithm() {
+			return fmt.Errorf("%s is hashed with %s, not %s", *into, manifest.LeafAlgorithm(), *algorithm)
+		}
+	}
+
+	var key []byte
+	if *keyPath != "" {
+		var err error
+		if key, err = config.ReadHashKey(*keyPath); err != nil {
+			return err
+		}
+	}
+
+	// A tree recording other digests needs them of every file
+	algorithms := []string{*algorithm}
+	if manifest != nil {
+		algorithms = manifest.Algorithms()
+	}
+	for _, input := range inputs {
+		digests, size, modTime, err := hashInput(input, algorithms, key)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s  %s\n", digests[0], input)
+
+		if manifest == nil {
+			continue
+		}
+		data := tree.FileData{
+			Hash:    digests[0],
+			Size:    size,
+			ModTime: modTime,
+			Virtual: true,
+		}
+		for i, algorithm := range manifest.DigestAlgorithms {
+			if data.Digests == nil {
+				data.Digests = make(map[string]string)
+			}
+			data.Digests[algorithm] = digests[i+1]
+		}
+		added, err := tree.AddFile(manifest, *as, data)
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %w", *as, err)
+		}
+		if err := tree.Save(added, *into); err != nil {
+			return fmt.Errorf("failed to save tree: %w", err)
+		}
+		slog.Info("Added virtual file", "path", *as, "size", size, "root", added.Root.Hash)
+	}
+	return nil
+}
+
+// hashInput hashes stdin if input is -, else the file or named pipe at
+// input, with each of algorithms, and returns its digests, size and
+// modification time. Streams have no modification time of their own, so
+// theirs is now. Keyed algorithms are keyed with key.
+func hashInput(input string, algorithms []string, key []byte) ([]string, int64, time.Time, error) {
+	var r io.Reader = os.Stdin
+	modTime := time.Now()
+	if input != "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			return nil, 0, time.Time{}, fmt.Errorf("failed to open input: %w", err)
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return nil, 0, time.Time{}, fmt.Errorf("failed to stat input: %w", err)
+		}
+		if info.IsDir() {
+			return nil, 0, time.Time{}, fmt.Errorf("%s is a directory", input)
+		}
+		if info.Mode().IsRegular() {
+			modTime = info.ModTime()
+		}
+		r = f
+	}
+	digests, size, err := hash.HashReaderMultiKeyed(r, key, algorithms...)
+	if err != nil {
+		return nil, 0, time.Time{}, fmt.Errorf("failed to hash %s: %w", input, err)
+	}
+	return digests, size, modTime, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"merkle-go/internal/tree"
+)
+
+// loadSnapshots loads the saved trees and patches named on the command
+// line, descending into directories to find every *.json manifest and
+// *.mpatch patch, and applies the patches to the trees they were made from.
+// Files found in directories that are not manifests are skipped with a
+// warning; named files must load.
+func loadSnapshots(paths []string) ([]tree.Snapshot, error) {
+	var snapshots []tree.Snapshot
+	patches := make(map[string]*tree.Patch)
+	load := func(path string, named bool) error {
+		var err error
+		if strings.EqualFold(filepath.Ext(path), ".mpatch") {
+			var patch *tree.Patch
+			if patch, err = tree.LoadPatch(path); err == nil {
+				patches[path] = patch
+			}
+		} else {
+			var t *tree.MerkleTree
+			if t, err = tree.Load(path); err == nil {
+				snapshots = append(snapshots, tree.Snapshot{Name: path, Tree: t})
+			}
+		}
+		if err != nil && named {
+			return fmt.Errorf("failed to load %s: %w", path, err)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", path, err)
+		}
+		return nil
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			if err := load(path, true); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			ext := strings.ToLower(filepath.Ext(p))
+			if d.IsDir() || (ext != ".json" && ext != ".mpatch") {
+				return nil
+			}
+			return load(p, false)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", path, err)
+		}
+	}
+
+	snapshots, unresolved, err := tree.ResolvePatches(snapshots, patches)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range unresolved {
+		fmt.Fprintf(os.Stderr, "Skipping %s: no snapshot has its base root hash %s\n", name, patches[name].Base)
+	}
+	tree.SortSnapshots(snapshots)
+	return snapshots, nil
+}
+
+// fileHistory shows when a file changed across a series of snapshots, kept
+// as full manifests or as one manifest followed by patches
+func fileHistory(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	all := fs.Bool("all", false, "List every snapshot, including those where the file is unchanged or absent")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go history [options] <path> <tree.json|changes.mpatch|directory>...\n\n")
+		fmt.Fprintf(os.Stderr, "Show when a file was added, modified or deleted across snapshots, oldest first,\n")
+		fmt.Fprintf(os.Stderr, "with each snapshot's creation time and root hash and the file's size and hash.\n")
+		fmt.Fprintf(os.Stderr, "The path is relative to the snapshots' root, or absolute under the newest one.\n")
+		fmt.Fprintf(os.Stderr, "Directories are searched recursively for *.json manifests and *.mpatch patches;\n")
+		fmt.Fprintf(os.Stderr, "patches are applied to the snapshot with their base root hash.\n")
+		fmt.Fprintf(os.Stderr, "Exits with 1 if the file is in none of the snapshots.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	// Allow options after the arguments, e.g. "history app.conf snapshots/ --all"
+	var inputs []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		inputs = append(inputs, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(inputs) < 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	path := inputs[0]
+
+	snapshots, err := loadSnapshots(inputs[1:])
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		return fmt.Errorf("no snapshots found")
+	}
+
+	if filepath.IsAbs(path) {
+		root := snapshots[len(snapshots)-1].Tree.RootPath
+		rel, err := filepath.Rel(root, path)
+		if err != nil || !filepath.IsLocal(rel) {
+			return fmt.Errorf("%s is not under %s, the root of the newest snapshot", path, root)
+		}
+		path = rel
+	}
+
+	entries := tree.FileHistory(snapshots, filepath.ToSlash(path), *all)
+	found := false
+	for _, entry := range entries {
+		created := "-"
+		if !entry.Created.IsZero() {
+			created = entry.Created.Local().Format("2006-01-02 15:04:05")
+		}
+		size, hash := "-", "-"
+		if entry.Present() {
+			size, hash = fmt.Sprint(entry.Data.Size), entry.Data.Hash
+			found = true
+		}
+		fmt.Printf("%-19s  %s  %-9s  %12s  %-16s  %s\n", created, entry.RootHash, entry.Status, size, hash, entry.Snapshot)
+	}
+	if !found {
+		return &exitError{code: 1}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"merkle-go/internal/compare"
+	"merkle-go/internal/image"
+	"merkle-go/internal/objstore"
+	"merkle-go/internal/tree"
+)
+
+// imageRoot is the root path recorded in trees of image filesystems
+const imageRoot = "/"
+
+// imageTree fetches or reads an image, flattens its layers and compares the
+// resulting filesystem against a saved tree or saves it as one
+func imageTree(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("image", flag.ExitOnError)
+	flags := addCommonFlags(fs)
+	exitFlags := addExitFlags(fs)
+	reportFlags := addReportFlags(fs)
+	output := fs.String("o", "", "Save the tree of the image filesystem to this file")
+	comparePath := fs.String("compare", "", "Compare the image filesystem against this saved tree")
+	platformFlag := fs.String("platform", image.DefaultPlatform().String(), "Platform to pick from multi-platform images (os/arch[/variant])")
+	insecure := fs.Bool("insecure", false, "Talk plain HTTP to the registry, for local test registries")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go image [options] <image-ref|oci-layout-dir>\n\n")
+		fmt.Fprintf(os.Stderr, "Build a merkle tree of the filesystem of a container image by unpacking its\n")
+		fmt.Fprintf(os.Stderr, "layers in order, and save it (-o) or compare it against a saved tree (--compare).\n")
+		fmt.Fprintf(os.Stderr, "The image is read from an OCI image layout directory if one exists at the given\n")
+		fmt.Fprintf(os.Stderr, "path, and pulled anonymously from its registry otherwise.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	platform, err := image.ParsePlatform(*platformFlag)
+	if err != nil {
+		return err
+	}
+
+	closeLog, err := flags.setupLogging()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	cfg, err := flags.loadConfig("")
+	if err != nil {
+		return err
+	}
+	policy, err := exitFlags.policy(cfg, flags)
+	if err != nil {
+		return err
+	}
+
+	var oldTree *tree.MerkleTree
+	if *comparePath != "" {
+		oldTree, err = flags.loadTree(ctx, *comparePath)
+		if err != nil {
+			return fmt.Errorf("failed to load tree: %w", err)
+		}
+		slog.Info("Loaded saved tree", "path", *comparePath, "root", oldTree.Root.Hash)
+		cfg.HashAlgorithm = oldTree.HashAlgorithm
+		cfg.Portable = oldTree.Portable
+		cfg.BytewiseOrder = oldTree.BytewiseOrder
+	}
+
+	name := fs.Arg(0)
+	var img *image.Image
+	if image.IsLayout(name) {
+		img, err = image.OpenLayout(ctx, name, platform)
+	} else {
+		var ref image.Reference
+		ref, err = image.ParseReference(name)
+		if err != nil {
+			return err
+		}
+		registry := &image.Registry{Insecure: *insecure}
+		img, err = registry.Open(ctx, ref, platform)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open image: %w", err)
+	}
+	slog.Info("Resolved image", "image", img.Name, "digest", img.Digest, "platform", img.Platform.String(), "layers", len(img.Layers))
+
+	files, err := image.Flatten(ctx, img, cfg.HashAlgorithm, func(index int, layer image.Layer) {
+		slog.Info("Unpacking layer", "layer", index, "digest", layer.Digest, "size", tree.FormatSize(layer.Size))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read image layers: %w", err)
+	}
+
+	leaves := make(map[string]tree.FileData, len(files))
+	for name, file := range files {
+		leaves[filepath.Join(imageRoot, filepath.FromSlash(name))] = tree.FileData{Hash: file.Hash, Size: file.Size, ModTime: file.ModTime}
+	}
+	imageTree, err := tree.BuildWithOptions(leaves, imageRoot, tree.BuildOptions{
+		Portable:      cfg.Portable,
+		BytewiseOrder: cfg.BytewiseOrder,
+		HashAlgorithm: cfg.HashAlgorithm,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build merkle tree: %w", err)
+	}
+	slog.Info("Built image tree", "root", imageTree.Root.Hash, "files", len(imageTree.Files))
+
+	if *output != "" {
+		if !objstore.IsURL(*output) {
+			if err := os.MkdirAll(filepath.Dir(*output), 0755); err != nil {
+				return fmt.Errorf("failed to create output directory: %w", err)
+			}
+		}
+		if err := flags.saveTree(ctx, imageTree, *output); err != nil {
+			return fmt.Errorf("failed to save tree: %w", err)
+		}
+		slog.Info("Saved merkle tree", "path", *output, "root", imageTree.Root.Hash)
+	}
+
+	if oldTree == nil {
+		if *output == "" {
+			fmt.Println(imageTree.Root.Hash)
+		}
+		return nil
+	}
+
+	// A tree saved from an unpacked copy of the image has a different root
+	// path; files are matched by their path relative to it
+	aligned, err := compare.AlignRoots(oldTree, imageTree)
+	if err != nil {
+		return err
+	}
+	result := compare.Compare(aligned, imageTree)
+	fmt.Println(compare.FormatReportWithOptions(result, reportFlags.options(imageTree.RootPath)))
+	return policy.exit(result.Count(), 0)
+}
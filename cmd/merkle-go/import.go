@@ -0,0 +1,173 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"merkle-go/internal/logging"
+	"merkle-go/internal/pkgmanifest"
+	"merkle-go/internal/tree"
+)
+
+// importChecksums builds a tree from an existing checksum manifest, mtree
+// specification or backup listing without rehashing. The tree keeps the manifest's algorithm for its leaf hashes, so
+// later compare and check runs hash the files the same way. Listings without
+// digests give a MetadataOnly tree.
+func importChecksums(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	rootPath := fs.String("root", "", "Directory the listed paths are relative to (default: the checksum file's directory); for backup listings, the backed-up directory to import (default: the snapshot's)")
+	outputPath := fs.String("o", "", "Output file for the tree (default: output/<root-hash>.json)")
+	format := fs.String("format", "auto", "Input format: checksums, mtree, restic, borg, or auto to detect checksums or mtree from the file name")
+	var logOpts logging.Options
+	fs.BoolVar(&logOpts.Verbose, "verbose", false, "Log debug details")
+	fs.BoolVar(&logOpts.Quiet, "quiet", false, "Only log warnings and errors")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go import [options] <checksums> [--root directory] [-o tree.json]\n\n")
+		fmt.Fprintf(os.Stderr, "Build a merkle tree from a SHA256SUMS, SHA1SUMS or MD5SUMS file (plain or --tag\n")
+		fmt.Fprintf(os.Stderr, "format) or an mtree specification without rehashing. File sizes not given by the\n")
+		fmt.Fprintf(os.Stderr, "manifest are read from the root directory if present.\n\n")
+		fmt.Fprintf(os.Stderr, "Backup listings from `restic ls --json <snapshot>` or `borg list --json-lines\n")
+		fmt.Fprintf(os.Stderr, "<repo>::<archive>` import the files under --root as stored in the backup, to\n")
+		fmt.Fprintf(os.Stderr, "compare with the live directory. restic lists no content hashes, so such trees\n")
+		fmt.Fprintf(os.Stderr, "record sizes and modification times only; add {xxh64} to borg's --format to\n")
+		fmt.Fprintf(os.Stderr, "record content hashes.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	// Allow options after the checksum file, e.g. "import SUMS --root /data"
+	var inputs []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		inputs = append(inputs, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(inputs) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	_, closer, err := logging.Setup(logOpts)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	checksumPath := inputs[0]
+	if *format == "auto" {
+		*format = pkgmanifest.FormatChecksums
+		if detected, err := pkgmanifest.DetectFormat(checksumPath); err == nil && detected == pkgmanifest.FormatMtree {
+			*format = detected
+		}
+	}
+	switch *format {
+	case pkgmanifest.FormatChecksums, pkgmanifest.FormatMtree, pkgmanifest.FormatRestic, pkgmanifest.FormatBorg:
+	default:
+		return fmt.Errorf("unknown import format %q (want checksums, mtree, restic or borg)", *format)
+	}
+	manifest, err := pkgmanifest.Load(checksumPath, *format)
+	if err != nil {
+		return err
+	}
+
+	// Backup listings hold absolute paths, of which those under the root
+	// are imported
+	backup := *format == pkgmanifest.FormatRestic || *format == pkgmanifest.FormatBorg
+	if *rootPath == "" {
+		*rootPath = filepath.Dir(checksumPath)
+		if backup {
+			if manifest.Source == "" {
+				return fmt.Errorf("%s does not name one backed-up directory; use --root", checksumPath)
+			}
+			*rootPath = manifest.Source
+		}
+	}
+	absRoot, err := absPath(*rootPath)
+	if err != nil {
+		return err
+	}
+	if backup {
+		prefix := strings.TrimSuffix(filepath.ToSlash(absRoot), "/") + "/"
+		entries := manifest.Entries[:0]
+		for _, entry := range manifest.Entries {
+			if relPath, ok := strings.CutPrefix("/"+entry.Path, prefix); ok {
+				entry.Path = relPath
+				entries = append(entries, entry)
+			}
+		}
+		slog.Debug("Selected backed-up files under the root", "root", absRoot, "files", len(entries), "listed", len(manifest.Entries))
+		manifest.Entries = entries
+	}
+	if len(manifest.Entries) == 0 {
+		return fmt.Errorf("%s lists no files under %s", checksumPath, absRoot)
+	}
+
+	// Leaf hashes of one tree must all come from the same algorithm
+	algorithm := manifest.Entries[0].Algorithm
+	files := make(map[string]tree.FileData, len(manifest.Entries))
+	var missing int
+	for _, entry := range manifest.Entries {
+		if entry.Digest == "" && !backup {
+			return fmt.Errorf("%s lists no supported digest for %s", checksumPath, entry.Path)
+		}
+		if entry.Algorithm != algorithm {
+			return fmt.Errorf("%s mixes %s and %s digests", checksumPath, algorithm, entry.Algorithm)
+		}
+		path := filepath.Join(absRoot, filepath.FromSlash(entry.Path))
+		if _, exists := files[path]; exists {
+			return fmt.Errorf("%s is listed more than once", entry.Path)
+		}
+
+		fileData := tree.FileData{Hash: entry.Digest, ModTime: entry.ModTime}
+		if entry.Size >= 0 {
+			fileData.Size = entry.Size
+		} else if info, err := os.Stat(path); err == nil {
+			fileData.Size = info.Size()
+		} else {
+			missing++
+			slog.Debug("Listed file not found; size unknown", "path", path)
+		}
+		if entry.Digest == "" {
+			fileData.Hash = tree.MetadataHash(fileData.Size, fileData.ModTime)
+		}
+		files[path] = fileData
+	}
+	if missing > 0 {
+		slog.Warn("Some listed files were not found under the root; their sizes are recorded as 0",
+			"count", missing, "root", absRoot)
+	}
+
+	merkleTree, err := tree.BuildWithOptions(files, absRoot, tree.BuildOptions{HashAlgorithm: algorithm})
+	if err != nil {
+		return fmt.Errorf("failed to build merkle tree: %w", err)
+	}
+	if algorithm == "" {
+		merkleTree.MetadataOnly = true
+		slog.Warn("Listing has no content hashes; compare will only detect files whose size or modification time differ")
+	}
+
+	if *outputPath == "" {
+		*outputPath = filepath.Join("output", merkleTree.Root.Hash+".json")
+	}
+	if err := os.MkdirAll(filepath.Dir(*outputPath), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if err := tree.Save(merkleTree, *outputPath); err != nil {
+		return fmt.Errorf("failed to save tree: %w", err)
+	}
+
+	slog.Info("Imported checksums", "path", *outputPath, "root", merkleTree.Root.Hash,
+		"files", len(merkleTree.Files), "algorithm", algorithm)
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"merkle-go/internal/config"
+	"merkle-go/internal/tree"
+	"merkle-go/internal/walker"
+)
+
+// scanLowMemory is scanDirectory for trees too large to build in memory:
+// hashes go straight into a tree.StreamBuilder spilling to spillDir instead
+// of being collected in a map. The returned scan's tree only carries the
+// root hash; save it with the builder, then close the builder.
+func scanLowMemory(ctx context.Context, absDirectory, spillDir string, cfg *config.Config, flags *commonFlags) (*scanResult, *tree.StreamBuilder, error) {
+	slog.Info("Scanning directory", "path", absDirectory)
+	start := time.Now()
+	walkResult, err := walker.WalkWithOptions(ctx, absDirectory, walkOptions(cfg))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to walk directory: %w", err)
+	}
+	stats := tree.ScanStats{Walk: time.Since(start), Workers: flags.workers}
+
+	builder, err := tree.NewStreamBuilder(absDirectory, spillDir, tree.BuildOptions{
+		Portable:      cfg.Portable,
+		BytewiseOrder: cfg.BytewiseOrder,
+		HashAlgorithm: cfg.HashAlgorithm,
+		EmptyDirs:     cfg.EmptyDirs,
+		SegmentSize:   cfg.SegmentSize,
+		Fingerprint:   cfg.Fingerprint,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slog.Info("Hashing files", "files", len(walkResult.Files), "workers", flags.workers, "spill_dir", spillDir)
+	bar := flags.newProgressBar(len(walkResult.Files))
+	hashFunc, err := fileHasher(cfg, bar, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashFunc, closeCache := cachedHasher(cfg, hashFunc)
+	defer closeCache()
+	var samples *sampleRecorder
+	if len(cfg.Fingerprint) > 0 {
+		samples = newSampleRecorder(cfg, absDirectory)
+		hashFunc = samples.wrap(hashFunc)
+	}
+	var addErr error
+	start = time.Now()
+	hashResult, err := walker.HashFilesWithOptions(ctx, walkResult.Files, walker.HashOptions{
+		Workers:     flags.workers,
+		Progress:    bar,
+		FileTimeout: flags.fileTimeout,
+		HashFunc:    hashFunc,
+		Retry:       retryPolicy(cfg),
+		OnHashed: func(info walker.FileInfo, digest string) {
+			if addErr == nil {
+				addErr = builder.Add(info.Path, tree.FileData{
+					Hash: digest, Size: info.Size, ModTime: info.ModTime, Sparse: info.Sparse, Allocated: info.Allocated,
+					Tags: fileTags(cfg, absDirectory, info.Path), Sampled: samples.get(info.Path),
+				})
+			}
+			stats.Files++
+			stats.Bytes += info.Size
+		},
+		DiscardHashes: true,
+	})
+	if bar != nil {
+		bar.Finish()
+	}
+	if err == nil {
+		err = addErr
+	}
+	if err != nil {
+		builder.Close()
+		return nil, nil, fmt.Errorf("failed to hash files: %w", err)
+	}
+	stats.Hash = time.Since(start)
+	for _, path := range hashResult.Poisoned {
+		slog.Warn("Isolated poisoned file", "path", path)
+	}
+	warnRetriesExhausted(walkResult.RetriesExhausted)
+	warnRetriesExhausted(hashResult.RetriesExhausted)
+
+	start = time.Now()
+	if cfg.EmptyDirs {
+		for _, dir := range walkResult.EmptyDirs {
+			data := emptyDirData(dir)
+			data.Tags = fileTags(cfg, absDirectory, dir.Path)
+			if err := builder.Add(dir.Path, data); err != nil {
+				builder.Close()
+				return nil, nil, fmt.Errorf("failed to build merkle tree: %w", err)
+			}
+		}
+	}
+	if err := builder.Finish(); err != nil {
+		builder.Close()
+		return nil, nil, fmt.Errorf("failed to build merkle tree: %w", err)
+	}
+	merkleTree := builder.Tree()
+	merkleTree.Errors = scanErrors(append(walkResult.Errors, hashResult.Errors...))
+	stats.Build = time.Since(start)
+
+	return &scanResult{Walk: walkResult, Hash: hashResult, Tree: merkleTree, Stats: stats}, builder, nil
+}
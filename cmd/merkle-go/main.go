@@ -1,302 +1,915 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"syscall"
 	"time"
 
-	"merkle-go/internal/compare"
 	"merkle-go/internal/config"
+	"merkle-go/internal/fsinfo"
+	"merkle-go/internal/hash"
+	"merkle-go/internal/logging"
+	"merkle-go/internal/objstore"
 	"merkle-go/internal/progress"
 	"merkle-go/internal/tree"
+	"merkle-go/internal/version"
 	"merkle-go/internal/walker"
 )
 
-func writeErrorLog(errors []error) (string, error) {
-	if len(errors) == 0 {
-		return "", nil
-	}
+// exitError carries a process exit code out of a command without printing
+// an error message, so deferred cleanup still runs before exiting
+type exitError struct {
+	code int
+}
 
-	logPath := "log.txt"
-	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		return "", fmt.Errorf("failed to open log file: %w", err)
-	}
-	defer f.Close()
+func (e *exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
 
-	// Write timestamp header
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	fmt.Fprintf(f, "\n=== Errors at %s ===\n", timestamp)
+// stringList is a flag.Value collecting every occurrence of a repeatable flag
+type stringList []string
 
-	// Write each error
-	for _, err := range errors {
-		fmt.Fprintf(f, "%s\n", err.Error())
-	}
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
 
-	return logPath, nil
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
 }
 
-func generateTree(args []string) error {
-	fs := flag.NewFlagSet("merkle-go", flag.ExitOnError)
-	configPath := fs.String("config", "config.toml", "Config file path")
-	configPathShort := fs.String("c", "config.toml", "Config file path (shorthand)")
-	workers := fs.Int("workers", runtime.NumCPU()*2, "Number of worker goroutines")
-	workersShort := fs.Int("w", runtime.NumCPU()*2, "Number of worker goroutines (shorthand)")
+// commonFlags are the options shared by the commands that scan a directory
+type commonFlags struct {
+	fs          *flag.FlagSet
+	configPath  string
+	profile     string
+	target      string
+	workers     int
+	ioWorkers   int
+	cpuWorkers  int
+	bufferSize  string
+	fileTimeout time.Duration
+	log         logging.Options
+
+	// hashPhase labels the hashing progress bar of commands that scan in
+	// several phases, such as compare
+	hashPhase string
+
+	// noProgress suppresses progress bars and counters, for commands whose
+	// output is read by scripts, such as generate --root-only
+	noProgress bool
+}
 
-	fs.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: merkle-go [options] <directory> [output-json-filename]\n\n")
-		fmt.Fprintf(os.Stderr, "Generate a merkle tree from a directory tree and save it to a JSON file.\n\n")
-		fmt.Fprintf(os.Stderr, "Options:\n")
-		fs.PrintDefaults()
-	}
+func addCommonFlags(fs *flag.FlagSet) *commonFlags {
+	c := &commonFlags{fs: fs}
+	fs.StringVar(&c.configPath, "config", "config.toml", "Config file path")
+	fs.StringVar(&c.configPath, "c", "config.toml", "Config file path (shorthand)")
+	fs.StringVar(&c.profile, "profile", "", "Config profile to apply, e.g. media for [profiles.media]")
+	fs.StringVar(&c.target, "target", "", "Config target whose skip list and output to apply, e.g. photos for the [[targets]] named photos")
+	fs.IntVar(&c.workers, "workers", 0, "Number of worker goroutines (default: chosen for the storage type)")
+	fs.IntVar(&c.workers, "w", 0, "Number of worker goroutines (shorthand)")
+	fs.IntVar(&c.ioWorkers, "io-workers", 0, "Number of files read, and of manifest subtrees encoded or decoded, at once; overrides --workers")
+	fs.StringVar(&c.bufferSize, "buffer-size", "", "Read buffer size, e.g. 1M for spinning disks (default: buffer_size from the config, else 32K)")
+	fs.IntVar(&c.cpuWorkers, "cpu-workers", 0, "Digest read-ahead blocks in a separate stage, this many at once (default: read and digest in the same worker)")
+	fs.DurationVar(&c.fileTimeout, "file-timeout", 0, "Give up on a file that takes longer than this to hash, e.g. 10m (0 = no limit)")
+	fs.BoolVar(&c.log.Verbose, "verbose", false, "Log debug details")
+	fs.BoolVar(&c.log.Quiet, "quiet", false, "Only log warnings and errors")
+	fs.StringVar(&c.log.Format, "log-format", "text", "Log format: text, json or journald")
+	fs.StringVar(&c.log.File, "log-file", "", "Append log records to this file instead of stderr")
+	return c
+}
 
-	if err := fs.Parse(args); err != nil {
-		return err
+// loadConfig loads the config file and the target selected with --target,
+// then the .merkle-go.toml in the root of dir if there is one, then the
+// profile selected with --profile
+func (c *commonFlags) loadConfig(dir string) (*config.Config, error) {
+	cfg, err := config.LoadConfig(c.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if c.target != "" {
+		if err := cfg.ApplyTarget(c.target); err != nil {
+			return nil, err
+		}
+	}
+	if dir != "" {
+		path, err := cfg.ApplyDirConfig(dir)
+		if err != nil {
+			return nil, err
+		}
+		if path != "" {
+			slog.Info("Using directory config", "path", path)
+		}
+	}
+	if c.profile != "" {
+		if err := cfg.ApplyProfile(c.profile); err != nil {
+			return nil, err
+		}
 	}
 
-	// Merge short and long flag values
-	if *configPathShort != "config.toml" {
-		*configPath = *configPathShort
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	if len(cfg.HashAlgorithms) > 0 {
+		cfg.HashAlgorithm = strings.ToLower(cfg.HashAlgorithms[0])
 	}
-	if *workersShort != runtime.NumCPU()*2 {
-		*workers = *workersShort
+	if err := cfg.LoadHashKey(); err != nil {
+		return nil, err
 	}
 
-	if fs.NArg() < 1 || fs.NArg() > 2 {
-		fs.Usage()
-		os.Exit(1)
+	if c.cpuWorkers > 0 {
+		cfg.CPUWorkers = c.cpuWorkers
 	}
+	if c.bufferSize != "" {
+		size, err := parseSize(c.bufferSize)
+		if err != nil || size > math.MaxInt32 {
+			return nil, fmt.Errorf("invalid --buffer-size %q", c.bufferSize)
+		}
+		cfg.BufferSize = int(size)
+	}
+	c.chooseWorkers(cfg, dir)
+	return cfg, nil
+}
 
-	directory := fs.Arg(0)
-	var outputPath string
-	if fs.NArg() == 2 {
-		outputPath = fs.Arg(1)
+// chooseWorkers settles the worker count: --io-workers or -w if given, else
+// the config's workers, else a default for the storage dir is on. Spinning
+// disks thrash with many concurrent readers, so the default depends on the
+// device.
+func (c *commonFlags) chooseWorkers(cfg *config.Config, dir string) {
+	if c.ioWorkers > 0 {
+		c.workers = c.ioWorkers
+		return
+	}
+	if c.workers > 0 && c.isSet("workers", "w") {
+		return
+	}
+	if cfg != nil && cfg.Workers > 0 {
+		c.workers = cfg.Workers
+		return
+	}
+
+	storage := fsinfo.StorageUnknown
+	var volume *fsinfo.Volume
+	if dir != "" {
+		var err error
+		if storage, volume, err = fsinfo.DetectStorage(dir); err != nil {
+			slog.Debug("Failed to detect storage type", "path", dir, "error", err)
+		}
+	}
+	c.workers = storage.Workers(runtime.NumCPU())
+	if volume != nil {
+		slog.Info("Detected storage", "type", storage, "device", volume.Device, "fs_type", volume.FSType, "workers", c.workers)
 	}
+}
 
-	// Load config
-	cfg, err := config.LoadConfig(*configPath)
+// loadTree loads the manifest at path, which may be an object storage URL,
+// decoding --io-workers subtrees at once
+func (c *commonFlags) loadTree(ctx context.Context, path string) (*tree.MerkleTree, error) {
+	opts := tree.LoadOptions{Workers: c.ioWorkers}
+	if !objstore.IsURL(path) {
+		return tree.LoadWithOptions(path, opts)
+	}
+	data, err := objstore.Get(ctx, path)
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return nil, err
 	}
+	return tree.DecodeWithOptions(data, opts)
+}
 
-	// Set output path - from args, config, or default
-	if outputPath == "" {
-		outputPath = cfg.OutputFile
+// saveTree saves t to path, which may be an object storage URL, encoding
+// --io-workers subtrees at once
+func (c *commonFlags) saveTree(ctx context.Context, t *tree.MerkleTree, path string) error {
+	if !objstore.IsURL(path) {
+		return tree.SaveWithOptions(t, path, tree.SaveOptions{Workers: c.ioWorkers})
 	}
-
-	// Convert to absolute path
-	absDirectory, err := filepath.Abs(directory)
+	dir, err := os.MkdirTemp("", "merkle-go-upload-")
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path: %w", err)
+		return err
 	}
+	defer os.RemoveAll(dir)
+	localPath := filepath.Join(dir, "tree.json")
+	if err := tree.SaveWithOptions(t, localPath, tree.SaveOptions{Workers: c.ioWorkers}); err != nil {
+		return err
+	}
+	return upload(ctx, localPath, path)
+}
 
-	fmt.Printf("Scanning directory: %s\n", absDirectory)
-
-	// Walk directory
-	walkResult, err := walker.Walk(absDirectory, cfg.Skip)
+// upload copies the file at localPath to the object storage URL target
+func upload(ctx context.Context, localPath, target string) error {
+	f, err := os.Open(localPath)
 	if err != nil {
-		return fmt.Errorf("failed to walk directory: %w", err)
+		return err
 	}
-
-	fmt.Printf("Found %d files\n", len(walkResult.Files))
-	fmt.Println("Hashing files...")
-
-	// Create progress bar
-	bar := progress.New(int64(len(walkResult.Files)))
-
-	// Hash files concurrently
-	hashResult, err := walker.HashFiles(walkResult.Files, *workers, bar)
+	defer f.Close()
+	info, err := f.Stat()
 	if err != nil {
-		return fmt.Errorf("failed to hash files: %w", err)
+		return err
 	}
+	if err := objstore.Put(ctx, target, f, info.Size()); err != nil {
+		return fmt.Errorf("failed to upload manifest: %w", err)
+	}
+	return nil
+}
 
-	bar.Finish()
-
-	// Build file data map
-	fileDataMap := make(map[string]tree.FileData)
-	for _, fileInfo := range walkResult.Files {
-		if hash, ok := hashResult.Hashes[fileInfo.Path]; ok {
-			fileDataMap[fileInfo.Path] = tree.FileData{
-				Hash:    hash,
-				Size:    fileInfo.Size,
-				ModTime: fileInfo.ModTime,
+// isSet reports whether any of the named flags was given on the command line
+func (c *commonFlags) isSet(names ...string) bool {
+	set := false
+	c.fs.Visit(func(f *flag.Flag) {
+		for _, name := range names {
+			if f.Name == name {
+				set = true
 			}
 		}
-	}
+	})
+	return set
+}
 
-	// Build merkle tree
-	merkleTree, err := tree.Build(fileDataMap, absDirectory)
+// fileHasher returns the file hash function selected by the config. If bar
+// is not nil, it counts the bytes read, so large files show progress; tee,
+// if not nil, is fed the content read (see hash.ReadOptions).
+func fileHasher(cfg *config.Config, bar *progress.Bar, tee func(path string) io.Writer) (func(path string) (string, error), error) {
+	opts := hash.ReadOptions{Strategy: cfg.ReadStrategy, BufferSize: cfg.BufferSize, SegmentSize: cfg.SegmentSize, Key: cfg.HashKeyBytes(), DigestWorkers: cfg.CPUWorkers, Tee: tee}
+	if bar != nil {
+		opts.OnRead = func(n int) { bar.AddBytes(int64(n)) }
+	}
+	hashFunc, err := hash.FileHasher(cfg.HashAlgorithm, opts)
 	if err != nil {
-		return fmt.Errorf("failed to build merkle tree: %w", err)
+		return nil, fmt.Errorf("invalid config: %w", err)
 	}
+	return hashFunc, nil
+}
 
-	// If no output path specified, use root hash as filename in ./output/
-	if outputPath == "" {
-		outputPath = filepath.Join("output", merkleTree.Root.Hash+".json")
+// teeAll returns a hash.ReadOptions.Tee feeding the content read to each
+// of tees, or nil if there are none
+func teeAll(tees []func(path string) io.Writer) func(path string) io.Writer {
+	switch len(tees) {
+	case 0:
+		return nil
+	case 1:
+		return tees[0]
+	}
+	return func(path string) io.Writer {
+		var writers multiTee
+		for _, tee := range tees {
+			if w := tee(path); w != nil {
+				writers = append(writers, w)
+			}
+		}
+		return writers
 	}
+}
 
-	// Ensure output directory exists
-	outputDir := filepath.Dir(outputPath)
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
-	}
+// multiTee writes to every writer; unlike io.MultiWriter, one failing does
+// not starve the others, as each reports its own errors
+type multiTee []io.Writer
 
-	// Save to file
-	if err := tree.Save(merkleTree, outputPath); err != nil {
-		return fmt.Errorf("failed to save tree: %w", err)
+func (m multiTee) Write(p []byte) (int, error) {
+	for _, w := range m {
+		w.Write(p)
 	}
+	return len(p), nil
+}
 
-	fmt.Printf("\nSuccess\n")
-	fmt.Printf("Results in: %s\n", outputPath)
+// manifestKey returns the config's hash_key for a manifest hashed with a
+// keyed algorithm, and nil for other manifests
+func (c *commonFlags) manifestKey(manifest *tree.MerkleTree) ([]byte, error) {
+	if !strings.EqualFold(manifest.LeafAlgorithm(), hash.HMACSHA256) {
+		return nil, nil
+	}
+	cfg, err := c.loadConfig("")
+	if err != nil {
+		return nil, err
+	}
+	if cfg.HashKeyBytes() == nil {
+		return nil, fmt.Errorf("the tree is hashed with %s; set hash_key to its key", hash.HMACSHA256)
+	}
+	return cfg.HashKeyBytes(), nil
+}
 
-	if len(hashResult.Errors) > 0 {
-		fmt.Printf("\n⚠ Skipped %d files due to errors\n", len(hashResult.Errors))
-		if logPath, err := writeErrorLog(hashResult.Errors); err == nil && logPath != "" {
-			fmt.Printf("  Error details written to: %s\n", logPath)
+// walkOptions returns the walk settings of cfg
+func walkOptions(cfg *config.Config) walker.WalkOptions {
+	opts := walker.WalkOptions{
+		Exclusions:       cfg.Skip,
+		SkipRegex:        cfg.SkipRegex,
+		OneFileSystem:    cfg.OneFileSystem,
+		ExcludeCaches:    cfg.ExcludeCaches,
+		ExcludeIfPresent: cfg.ExcludeIfPresent,
+		FilterCommand:    cfg.FilterCmd,
+		Limits:           walker.Limits{MaxFiles: cfg.MaxFiles, MaxDepth: cfg.MaxDepth, MaxTotalBytes: cfg.MaxTotalBytes},
+		Retry:            retryPolicy(cfg),
+	}
+	if cfg.OnLimit == "warn" {
+		opts.OnLimit = func(err *walker.LimitError) {
+			slog.Warn("Scan exceeds a configured limit, continuing", "limit", err.Limit, "max", err.Max, "path", err.Path)
 		}
 	}
-
-	return nil
+	return opts
 }
 
-func compareTree(args []string) error {
-	fs := flag.NewFlagSet("compare", flag.ExitOnError)
-	configPath := fs.String("config", "config.toml", "Config file path")
-	configPathShort := fs.String("c", "config.toml", "Config file path (shorthand)")
-	workers := fs.Int("workers", runtime.NumCPU()*2, "Number of worker goroutines")
-	workersShort := fs.Int("w", runtime.NumCPU()*2, "Number of worker goroutines (shorthand)")
+// retryPolicy returns the retries of transient read errors set by cfg,
+// which loadConfig has validated
+func retryPolicy(cfg *config.Config) walker.RetryPolicy {
+	delay, _ := cfg.RetryDelayDuration()
+	return walker.RetryPolicy{Retries: cfg.IORetries, Delay: delay}
+}
 
-	fs.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: merkle-go compare [options] <tree.json> <directory>\n\n")
-		fmt.Fprintf(os.Stderr, "Compare saved merkle tree against current directory.\n\n")
-		fmt.Fprintf(os.Stderr, "Options:\n")
-		fs.PrintDefaults()
+// warnRetriesExhausted logs the paths that kept failing with transient
+// errors after every retry
+func warnRetriesExhausted(paths []string) {
+	for _, path := range paths {
+		slog.Warn("Gave up retrying after transient errors", "path", path)
 	}
+}
 
-	if err := fs.Parse(args); err != nil {
-		return err
+// setupLogging installs the logger selected by the flags; the returned
+// function closes the log file
+func (c *commonFlags) setupLogging() (func(), error) {
+	_, closer, err := logging.Setup(c.log)
+	if err != nil {
+		return nil, err
 	}
+	return func() { closer.Close() }, nil
+}
 
-	// Merge short and long flag values
-	if *configPathShort != "config.toml" {
-		*configPath = *configPathShort
-	}
-	if *workersShort != runtime.NumCPU()*2 {
-		*workers = *workersShort
+// newProgressBar returns a progress bar for total items, or nil when output
+// is not meant for an interactive terminal. The bar draws nothing unless
+// stderr is a terminal.
+func (c *commonFlags) newProgressBar(total int) *progress.Bar {
+	if c.noProgress || !c.log.Interactive() {
+		return nil
 	}
+	return progress.New(int64(total))
+}
 
-	if fs.NArg() != 2 {
-		fs.Usage()
-		os.Exit(1)
+// newCounter returns a progress counter shown as label, or nil when output
+// is not meant for an interactive terminal
+func (c *commonFlags) newCounter(label string) *progress.Counter {
+	if c.noProgress || !c.log.Interactive() {
+		return nil
 	}
+	return progress.NewCounter(label)
+}
 
-	treePath := fs.Arg(0)
-	directory := fs.Arg(1)
+func writeErrorLog(errors []error) (string, error) {
+	if len(errors) == 0 {
+		return "", nil
+	}
 
-	// Convert to absolute path
-	absDirectory, err := filepath.Abs(directory)
+	logPath := "log.txt"
+	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path: %w", err)
+		return "", fmt.Errorf("failed to open log file: %w", err)
 	}
+	defer f.Close()
 
-	// Load saved tree
-	oldTree, err := tree.Load(treePath)
-	if err != nil {
-		return fmt.Errorf("failed to load tree: %w", err)
+	// Write timestamp header
+	timestamp := time.Now().Format("2006-01-02 15:04:05")
+	fmt.Fprintf(f, "\n=== Errors at %s ===\n", timestamp)
+
+	// Write each error
+	for _, err := range errors {
+		fmt.Fprintf(f, "%s\n", err.Error())
 	}
 
-	fmt.Printf("Loaded saved tree (root: %s)\n", oldTree.Root.Hash[:16]+"...")
+	return logPath, nil
+}
 
-	// Load config
-	cfg, err := config.LoadConfig(*configPath)
-	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+// reportErrors logs the number of files skipped because of errors and
+// where the details were written
+func reportErrors(errs []error) {
+	if len(errs) == 0 {
+		return
+	}
+	for _, err := range errs {
+		slog.Debug("Skipped file", "error", err)
+	}
+	if logPath, err := writeErrorLog(errs); err == nil && logPath != "" {
+		slog.Warn("Skipped files due to errors", "count", len(errs), "details", logPath)
+	} else {
+		slog.Warn("Skipped files due to errors", "count", len(errs))
+	}
+}
+
+// scanErrors converts walk and hash errors to the entries recorded in a
+// manifest. Errors that are not tied to a path are left out.
+func scanErrors(errs []error) []tree.ScanError {
+	var scanErrs []tree.ScanError
+	for _, err := range errs {
+		var fileErr *walker.FileError
+		var pathErr *fs.PathError
+		switch {
+		case errors.As(err, &fileErr):
+			scanErrs = append(scanErrs, tree.ScanError{Path: fileErr.Path, Error: fileErr.Err.Error()})
+		case errors.As(err, &pathErr):
+			scanErrs = append(scanErrs, tree.ScanError{Path: pathErr.Path, Error: pathErr.Err.Error()})
+		}
 	}
+	return scanErrs
+}
+
+// scanResult holds the outcome of walking and hashing a directory
+type scanResult struct {
+	Walk  *walker.WalkResult
+	Hash  *walker.HashResult
+	Tree  *tree.MerkleTree
+	Stats tree.ScanStats
+}
 
-	fmt.Printf("Scanning directory: %s\n", absDirectory)
+// scanDirectory walks absDirectory, hashes every file that is not skipped by
+// cfg and builds a merkle tree from the results
+func scanDirectory(ctx context.Context, absDirectory string, cfg *config.Config, flags *commonFlags) (*scanResult, error) {
+	slog.Info("Scanning directory", "path", absDirectory)
+	return scanWith(ctx, absDirectory, func(ctx context.Context) (*walker.WalkResult, error) {
+		walkResult, err := walker.WalkWithOptions(ctx, absDirectory, walkOptions(cfg))
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk directory: %w", err)
+		}
+		return walkResult, nil
+	}, cfg, flags)
+}
 
-	// Walk directory
-	walkResult, err := walker.Walk(absDirectory, cfg.Skip)
+// scanWith is scanDirectory with the files to hash enumerated by walk
+// instead of a directory walk
+func scanWith(ctx context.Context, absDirectory string, walk func(context.Context) (*walker.WalkResult, error),
+	cfg *config.Config, flags *commonFlags) (*scanResult, error) {
+	start := time.Now()
+	walkResult, err := walk(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to walk directory: %w", err)
+		return nil, err
 	}
+	walkTime := time.Since(start)
 
-	fmt.Printf("Found %d files\n", len(walkResult.Files))
-	fmt.Println("Hashing files...")
+	scan, err := hashAndBuild(ctx, absDirectory, walkResult, cfg, flags, nil)
+	if scan != nil {
+		scan.Stats.Walk = walkTime
+	}
+	return scan, err
+}
 
-	// Create progress bar
-	bar := progress.New(int64(len(walkResult.Files)))
+// walkFileList reads the files named in a list, from listPath or from
+// stdin if it is "-", instead of walking absDirectory
+func walkFileList(ctx context.Context, absDirectory, listPath string) (*walker.WalkResult, error) {
+	list := os.Stdin
+	if listPath != "-" {
+		f, err := os.Open(listPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file list: %w", err)
+		}
+		defer f.Close()
+		list = f
+	}
+	return walker.FromList(ctx, absDirectory, list)
+}
 
-	// Hash files
-	hashResult, err := walker.HashFiles(walkResult.Files, *workers, bar)
-	if err != nil {
-		return fmt.Errorf("failed to hash files: %w", err)
+// fileSource returns the function that enumerates the files to hash when
+// they come from a list (listPath not empty) or from git (useGit), or nil
+// to walk the directory
+func fileSource(absDirectory, listPath string, useGit bool) func(context.Context) (*walker.WalkResult, error) {
+	switch {
+	case listPath != "":
+		return func(ctx context.Context) (*walker.WalkResult, error) {
+			return walkFileList(ctx, absDirectory, listPath)
+		}
+	case useGit:
+		return func(ctx context.Context) (*walker.WalkResult, error) {
+			return walker.GitFiles(ctx, absDirectory)
+		}
+	}
+	return nil
+}
+
+// hashAndBuild hashes the files found by a walk and builds a merkle tree.
+// onResult, if not nil, is called as each file is hashed. If hashing is
+// interrupted, the tree of the files hashed so far is returned together with
+// the context error so callers can checkpoint it.
+func hashAndBuild(ctx context.Context, absDirectory string, walkResult *walker.WalkResult, cfg *config.Config,
+	flags *commonFlags, onResult func(path, hash string, err error)) (*scanResult, error) {
+	// Archives are expanded into their members instead of hashed whole
+	plain, archives := splitArchives(walkResult.Files, cfg.DescendArchives)
+
+	// Streamed results would be garbled by the progress bar
+	var bar *progress.Bar
+	if onResult == nil {
+		bar = flags.newProgressBar(len(plain))
+	}
+	if bar != nil {
+		var size int64
+		for _, fileInfo := range plain {
+			size += fileInfo.Size
+		}
+		bar.SetTotalBytes(size)
+		bar.SetLabel(flags.hashPhase)
+	}
+	// Chunks and CIDs are computed from the same read as the leaf digest
+	var chunks *chunkRecorder
+	var cids *cidRecorder
+	var tees []func(path string) io.Writer
+	if cfg.ChunkSize > 0 {
+		chunks = newChunkRecorder(cfg.ChunkSize)
+		tees = append(tees, chunks.tee)
+	}
+	if cfg.CIDs {
+		cids = newCIDRecorder()
+		tees = append(tees, cids.tee)
+	}
+	tee := teeAll(tees)
+	// Several digests are computed in one read. The cache only holds leaf
+	// digests, so it is skipped.
+	var digests *digestRecorder
+	var hashFunc func(path string) (string, error)
+	var err error
+	closeCache := func() {}
+	if len(cfg.DigestAlgorithms()) > 0 {
+		if digests, err = newDigestRecorder(cfg, bar, tee); err != nil {
+			return nil, err
+		}
+		hashFunc = digests.hash
+	} else {
+		if hashFunc, err = fileHasher(cfg, bar, tee); err != nil {
+			return nil, err
+		}
+		hashFunc, closeCache = cachedHasher(cfg, hashFunc)
 	}
+	defer closeCache()
+	if chunks != nil {
+		hashFunc = chunks.wrap(hashFunc)
+	}
+	if cids != nil {
+		hashFunc = cids.wrap(hashFunc)
+	}
+	// Sampled files are neither cached, chunked nor given a CID
+	var samples *sampleRecorder
+	if len(cfg.Fingerprint) > 0 {
+		samples = newSampleRecorder(cfg, absDirectory)
+		hashFunc = samples.wrap(hashFunc)
+	}
+	slog.Info("Hashing files", "files", len(plain), "archives", len(archives), "workers", flags.workers)
 
-	bar.Finish()
+	// Hash files concurrently
+	start := time.Now()
+	hashResult, hashErr := walker.HashFilesWithOptions(ctx, plain, walker.HashOptions{
+		Workers:     flags.workers,
+		Progress:    bar,
+		FileTimeout: flags.fileTimeout,
+		HashFunc:    hashFunc,
+		OnResult:    onResult,
+		Retry:       retryPolicy(cfg),
+	})
+	if hashResult == nil {
+		return nil, fmt.Errorf("failed to hash files: %w", hashErr)
+	}
+	if bar != nil {
+		bar.Finish()
+	}
+	for _, path := range hashResult.Poisoned {
+		slog.Warn("Isolated poisoned file", "path", path)
+	}
+	warnRetriesExhausted(walkResult.RetriesExhausted)
+	warnRetriesExhausted(hashResult.RetriesExhausted)
+	if n := samples.count(); n > 0 {
+		slog.Info("Fingerprinted files from samples", "files", n)
+	}
+	// Each archive is read once, hashing all of its members
+	fileDataMap := make(map[string]tree.FileData)
+	if hashErr == nil {
+		members, errs := hashArchives(ctx, archives, cfg)
+		for path, data := range members {
+			fileDataMap[path] = data
+		}
+		hashResult.Errors = append(hashResult.Errors, errs...)
+	}
+	stats := tree.ScanStats{Hash: time.Since(start), Workers: flags.workers}
+	for _, data := range fileDataMap {
+		stats.Files++
+		stats.Bytes += data.Size
+	}
 
 	// Build file data map
-	fileDataMap := make(map[string]tree.FileData)
-	for _, fileInfo := range walkResult.Files {
-		if hash, ok := hashResult.Hashes[fileInfo.Path]; ok {
+	start = time.Now()
+	for _, fileInfo := range plain {
+		if digest, ok := hashResult.Hashes[fileInfo.Path]; ok {
 			fileDataMap[fileInfo.Path] = tree.FileData{
-				Hash:    hash,
-				Size:    fileInfo.Size,
-				ModTime: fileInfo.ModTime,
+				Hash:      digest,
+				Size:      fileInfo.Size,
+				ModTime:   fileInfo.ModTime,
+				Sparse:    fileInfo.Sparse,
+				Allocated: fileInfo.Allocated,
+				Chunks:    chunks.get(fileInfo.Path),
+				CID:       cids.get(fileInfo.Path),
+				Digests:   digests.get(fileInfo.Path),
+				Sampled:   samples.get(fileInfo.Path),
 			}
+			stats.Files++
+			stats.Bytes += fileInfo.Size
+		}
+	}
+	if cfg.EmptyDirs {
+		for _, dir := range walkResult.EmptyDirs {
+			fileDataMap[dir.Path] = emptyDirData(dir)
+		}
+	}
+	if len(cfg.Tags) > 0 {
+		for path, data := range fileDataMap {
+			data.Tags = fileTags(cfg, absDirectory, path)
+			fileDataMap[path] = data
 		}
 	}
 
-	// Build new tree
-	newTree, err := tree.Build(fileDataMap, absDirectory)
+	// Build merkle tree
+	merkleTree, err := tree.BuildWithOptions(fileDataMap, absDirectory, tree.BuildOptions{
+		Portable:      cfg.Portable,
+		BytewiseOrder: cfg.BytewiseOrder,
+		HashAlgorithm: cfg.HashAlgorithm,
+		EmptyDirs:     cfg.EmptyDirs,
+		Archives:      cfg.DescendArchives,
+		SegmentSize:   cfg.SegmentSize,
+		ChunkSize:     cfg.ChunkSize,
+		CIDs:          cfg.CIDs,
+		Fingerprint:   cfg.Fingerprint,
+
+		DigestAlgorithms: cfg.DigestAlgorithms(),
+	})
 	if err != nil {
-		return fmt.Errorf("failed to build merkle tree: %w", err)
+		return nil, fmt.Errorf("failed to build merkle tree: %w", err)
 	}
 
-	// Compare trees
-	result := compare.Compare(oldTree, newTree)
+	merkleTree.Errors = scanErrors(append(walkResult.Errors, hashResult.Errors...))
+	stats.Build = time.Since(start)
 
-	// Print report
-	fmt.Println(compare.FormatReport(result))
+	scan := &scanResult{Walk: walkResult, Hash: hashResult, Tree: merkleTree, Stats: stats}
+	if hashErr != nil {
+		return scan, fmt.Errorf("hashing interrupted: %w", hashErr)
+	}
+	return scan, nil
+}
 
-	if len(hashResult.Errors) > 0 {
-		fmt.Printf("Skipped: %d files\n", len(hashResult.Errors))
-		if logPath, err := writeErrorLog(hashResult.Errors); err == nil && logPath != "" {
-			fmt.Printf("Error details written to: %s\n", logPath)
+// emptyDirData is the leaf data recorded for an empty directory
+func emptyDirData(dir walker.FileInfo) tree.FileData {
+	return tree.FileData{Hash: tree.EmptyDirHash, ModTime: dir.ModTime, Dir: true}
+}
+
+// rebuildWith adds known files, whose hashes did not need computing, to the
+// tree of scan and rebuilds it. The scan errors are kept.
+func rebuildWith(scan *scanResult, known map[string]tree.FileData, absDirectory string, cfg *config.Config) error {
+	if len(known) == 0 {
+		return nil
+	}
+	start := time.Now()
+	files := make(map[string]tree.FileData, len(known)+len(scan.Tree.Files))
+	digestAlgorithms := cfg.DigestAlgorithms()
+	for path, data := range known {
+		// Reused files get the tags of the current rules
+		data.Tags = fileTags(cfg, absDirectory, path)
+		if len(digestAlgorithms) == 0 {
+			data.Digests = nil
 		}
+		files[path] = data
+	}
+	for path, data := range scan.Tree.Files {
+		files[path] = data
+	}
+
+	merkleTree, err := tree.BuildWithOptions(files, absDirectory, tree.BuildOptions{
+		Portable:      cfg.Portable,
+		BytewiseOrder: cfg.BytewiseOrder,
+		HashAlgorithm: cfg.HashAlgorithm,
+		EmptyDirs:     cfg.EmptyDirs,
+		Archives:      cfg.DescendArchives,
+		SegmentSize:   cfg.SegmentSize,
+		ChunkSize:     cfg.ChunkSize,
+		CIDs:          cfg.CIDs,
+		Fingerprint:   cfg.Fingerprint,
+
+		DigestAlgorithms: digestAlgorithms,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build merkle tree: %w", err)
 	}
+	merkleTree.Errors = scan.Tree.Errors
+	scan.Tree = merkleTree
+	scan.Stats.Build += time.Since(start)
+	return nil
+}
 
-	// Exit with appropriate code
-	if len(hashResult.Errors) > 0 {
-		os.Exit(2) // Errors occurred
+// fileTags returns the tags the rules of cfg give the file at path, under
+// absDirectory
+func fileTags(cfg *config.Config, absDirectory, path string) map[string]string {
+	if len(cfg.Tags) == 0 {
+		return nil
 	}
-	if result.HasChanges() {
-		os.Exit(1) // Changes detected
+	relPath, err := filepath.Rel(absDirectory, path)
+	if err != nil {
+		return nil
 	}
-	os.Exit(0) // No changes
+	return cfg.TagsFor(relPath)
+}
 
-	return nil
+// printStats writes the timing breakdown of a scan to stderr, next to the logs
+func printStats(stats *tree.ScanStats) {
+	total := stats.Walk + stats.Hash + stats.Build + stats.Save
+	fmt.Fprintf(os.Stderr, "\nScan statistics:\n")
+	fmt.Fprintf(os.Stderr, "  Walk:     %s\n", stats.Walk.Round(time.Millisecond))
+	fmt.Fprintf(os.Stderr, "  Hash:     %s\n", stats.Hash.Round(time.Millisecond))
+	fmt.Fprintf(os.Stderr, "  Build:    %s\n", stats.Build.Round(time.Millisecond))
+	if stats.Save > 0 {
+		fmt.Fprintf(os.Stderr, "  Save:     %s\n", stats.Save.Round(time.Millisecond))
+	}
+	fmt.Fprintf(os.Stderr, "  Total:    %s\n", total.Round(time.Millisecond))
+	fmt.Fprintf(os.Stderr, "  Files:    %d (%.0f files/s)\n", stats.Files, stats.FilesPerSecond())
+	fmt.Fprintf(os.Stderr, "  Bytes:    %s (%s/s, %s/s per worker with %d workers)\n",
+		tree.FormatSize(stats.Bytes), tree.FormatSize(int64(stats.BytesPerSecond())),
+		tree.FormatSize(int64(stats.BytesPerSecondPerWorker())), stats.Workers)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: merkle-go [options] <directory> [output-json-filename]\n")
+	fmt.Fprintf(w, "       merkle-go run [options] [-- generate options]\n")
+	fmt.Fprintf(w, "       merkle-go compare [options] <tree.json> <directory>\n")
+	fmt.Fprintf(w, "       merkle-go check [options] <tree.json> [directory]\n")
+	fmt.Fprintf(w, "       merkle-go compare-package [options] <manifest> <install-root>\n")
+	fmt.Fprintf(w, "       merkle-go compare-many [options] <a.json> <b.json> [c.json]...\n")
+	fmt.Fprintf(w, "       merkle-go merge [options] <tree.json[=prefix]>... -o merged.json\n")
+	fmt.Fprintf(w, "       merkle-go migrate [options] <tree.json|directory>...\n")
+	fmt.Fprintf(w, "       merkle-go proof <tree.json> <path>\n")
+	fmt.Fprintf(w, "       merkle-go prove --paths <paths.txt> <tree.json> [-o proofs.json]\n")
+	fmt.Fprintf(w, "       merkle-go verify-proof [options] <proof.json|proofs.json> [file|directory]\n")
+	fmt.Fprintf(w, "       merkle-go verify-order [options] <tree.json>...\n")
+	fmt.Fprintf(w, "       merkle-go validate <tree.json>...\n")
+	fmt.Fprintf(w, "       merkle-go root <tree.json> [subpath]\n")
+	fmt.Fprintf(w, "       merkle-go pin [options] <tree.json>\n")
+	fmt.Fprintf(w, "       merkle-go check-pin [options] <directory>\n")
+	fmt.Fprintf(w, "       merkle-go hash [options] <-|file>... [--into tree.json --as path]\n")
+	fmt.Fprintf(w, "       merkle-go show [options] <tree.json>\n")
+	fmt.Fprintf(w, "       merkle-go find <tree.json>... [--hash hash] [--path-glob glob]\n")
+	fmt.Fprintf(w, "       merkle-go tui [options] <tree.json> [new.json]\n")
+	fmt.Fprintf(w, "       merkle-go simulate [options] <tree.json>\n")
+	fmt.Fprintf(w, "       merkle-go dedup [options] <directory>\n")
+	fmt.Fprintf(w, "       merkle-go bench [options] <directory>\n")
+	fmt.Fprintf(w, "       merkle-go export [options] <tree.json> [directory]\n")
+	fmt.Fprintf(w, "       merkle-go import [options] <checksums> [--root directory] [-o tree.json]\n")
+	fmt.Fprintf(w, "       merkle-go bundle [options] <tree.json> [-o bundle.tar.zst]\n")
+	fmt.Fprintf(w, "       merkle-go verify-bundle [options] <bundle> <directory>\n")
+	fmt.Fprintf(w, "       merkle-go sync-plan [options] <old.json> <new.json>\n")
+	fmt.Fprintf(w, "       merkle-go diff [options] <old.json> <new.json>\n")
+	fmt.Fprintf(w, "       merkle-go patch [options] <old.json> <changes.mpatch> [-o new.json]\n")
+	fmt.Fprintf(w, "       merkle-go history [options] <path> <tree.json|changes.mpatch|directory>...\n")
+	fmt.Fprintf(w, "       merkle-go restore [options] --from <dir|url> --manifest <tree.json> <target-dir>\n")
+	fmt.Fprintf(w, "       merkle-go image [options] <image-ref|oci-layout-dir>\n")
+	fmt.Fprintf(w, "       merkle-go cache [options] stats|path|clear|prune|forget <path>...\n")
+	fmt.Fprintf(w, "       merkle-go watch [options] <directory> <tree.json>\n")
+	fmt.Fprintf(w, "       merkle-go agent [options] --controller <address> <directory>\n")
+	fmt.Fprintf(w, "       merkle-go controller [options]\n")
+	fmt.Fprintf(w, "       merkle-go fleet [options] --hosts <hosts.txt> --manifest <tree.json>\n")
+	fmt.Fprintf(w, "       merkle-go version\n")
 }
 
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: merkle-go [options] <directory> [output-json-filename]\n")
-		fmt.Fprintf(os.Stderr, "       merkle-go compare [options] <tree.json> <directory>\n")
+		usage(os.Stderr)
 		os.Exit(1)
 	}
 
+	// Cancel in-flight work on Ctrl-C or SIGTERM so workers stop cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	var err error
-	if os.Args[1] == "compare" {
-		err = compareTree(os.Args[2:])
-	} else {
-		err = generateTree(os.Args[1:])
+	switch os.Args[1] {
+	case "run":
+		err = runTargets(ctx, os.Args[2:])
+	case "compare":
+		err = compareTree(ctx, os.Args[2:])
+	case "check":
+		err = checkTree(ctx, os.Args[2:])
+	case "compare-package":
+		err = comparePackage(ctx, os.Args[2:])
+	case "compare-many":
+		err = compareMany(os.Args[2:])
+	case "merge":
+		err = mergeTrees(os.Args[2:])
+	case "migrate":
+		err = migrateTrees(ctx, os.Args[2:])
+	case "proof":
+		err = printProof(os.Args[2:])
+	case "prove":
+		err = proveFiles(os.Args[2:])
+	case "verify-proof":
+		err = verifyProof(os.Args[2:])
+	case "verify-order":
+		err = verifyOrder(os.Args[2:])
+	case "validate":
+		err = validateTrees(os.Args[2:])
+	case "root":
+		err = rootHash(os.Args[2:])
+	case "pin":
+		err = pinTree(os.Args[2:])
+	case "check-pin":
+		err = checkPin(os.Args[2:])
+	case "hash":
+		err = hashCommand(os.Args[2:])
+	case "show":
+		err = showTree(os.Args[2:])
+	case "find":
+		err = findFiles(os.Args[2:])
+	case "tui":
+		err = browseTree(os.Args[2:])
+	case "simulate":
+		err = simulateTree(ctx, os.Args[2:])
+	case "dedup":
+		err = dedupStats(ctx, os.Args[2:])
+	case "bench":
+		err = benchHashing(ctx, os.Args[2:])
+	case "export":
+		err = exportTree(ctx, os.Args[2:])
+	case "import":
+		err = importChecksums(os.Args[2:])
+	case "bundle":
+		err = bundleCommand(os.Args[2:])
+	case "verify-bundle":
+		err = verifyBundle(ctx, os.Args[2:])
+	case "sync-plan":
+		err = syncPlan(os.Args[2:])
+	case "diff":
+		err = diffTrees(os.Args[2:])
+	case "patch":
+		err = patchTree(os.Args[2:])
+	case "history":
+		err = fileHistory(os.Args[2:])
+	case "restore":
+		err = restoreTree(ctx, os.Args[2:])
+	case "image":
+		err = imageTree(ctx, os.Args[2:])
+	case "cache":
+		err = cacheCommand(os.Args[2:])
+	case "watch":
+		err = watchDirectory(ctx, os.Args[2:])
+	case "agent":
+		err = runAgent(ctx, os.Args[2:])
+	case "controller":
+		err = runController(ctx, os.Args[2:])
+	case "fleet":
+		err = fleetCheck(ctx, os.Args[2:])
+	case "version", "--version":
+		fmt.Printf("merkle-go %s (manifest schema %d)\n", version.String(), tree.SchemaVersion)
+	default:
+		err = generateTree(ctx, os.Args[1:])
+	}
+	stop()
+
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.code)
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "Interrupted: %v\n", err)
+		os.Exit(130)
 	}
-
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 }
+
+// absPath converts a command-line path to an absolute, cleaned path
+func absPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	return abs, nil
+}
+
+// mapPrefixes rewrites the paths of manifest with the --map-prefix
+// mappings, given as from=to; the target of each mapping is resolved
+// against the working directory
+func mapPrefixes(manifest *tree.MerkleTree, specs []string) (*tree.MerkleTree, error) {
+	mappings := make([]tree.PrefixMap, 0, len(specs))
+	for _, spec := range specs {
+		mapping, err := tree.ParsePrefixMap(spec)
+		if err != nil {
+			return nil, err
+		}
+		if mapping.To, err = absPath(mapping.To); err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, mapping)
+	}
+	mapped, err := tree.MapPrefixes(manifest, mappings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map prefixes: %w", err)
+	}
+	slog.Info("Mapped manifest paths", "root", mapped.RootPath, "mappings", len(mappings))
+	return mapped, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"merkle-go/internal/compare"
+	"merkle-go/internal/tree"
+)
+
+// compareMany compares several saved trees, such as replicas of one dataset
+// on different hosts, and reports which files differ and which trees agree
+func compareMany(args []string) error {
+	fs := flag.NewFlagSet("compare-many", flag.ExitOnError)
+	format := fs.String("format", "text", "Output format: text or json")
+	output := fs.String("o", "", "Write the report to this file instead of stdout")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go compare-many [options] <a.json> <b.json> [c.json]...\n\n")
+		fmt.Fprintf(os.Stderr, "Compare several saved trees file by file, e.g. scans of the replicas of one\n")
+		fmt.Fprintf(os.Stderr, "dataset, and report the files that are not the same everywhere and the trees\n")
+		fmt.Fprintf(os.Stderr, "that agree. Files are matched by their path relative to each tree's root.\n")
+		fmt.Fprintf(os.Stderr, "Exits with 1 if the trees differ.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	// Allow options after the trees
+	var paths []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		paths = append(paths, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(paths) < 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q (want text or json)", *format)
+	}
+
+	trees := make([]*tree.MerkleTree, len(paths))
+	for i, path := range paths {
+		t, err := tree.Load(path)
+		if err != nil {
+			return fmt.Errorf("failed to load tree %s: %w", path, err)
+		}
+		trees[i] = t
+	}
+
+	result, err := compare.CompareMany(paths, trees)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(result)
+	} else {
+		err = compare.WriteManyReport(w, result)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	if len(result.Groups) > 1 {
+		return &exitError{code: 1}
+	}
+	return nil
+}
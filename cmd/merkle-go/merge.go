@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"merkle-go/internal/logging"
+	"merkle-go/internal/tree"
+)
+
+// mergeTrees combines several saved trees into one manifest
+func mergeTrees(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	outputPath := fs.String("o", "", "Output file for the merged tree (required)")
+	rootPath := fs.String("root", "", "Root path of the merged tree (default: deepest directory containing every input root)")
+	var logOpts logging.Options
+	fs.BoolVar(&logOpts.Quiet, "quiet", false, "Only print the merged root hash")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go merge [options] <tree.json[=prefix]>... -o merged.json\n\n")
+		fmt.Fprintf(os.Stderr, "Combine trees scanned from different directories or hosts into one manifest.\n")
+		fmt.Fprintf(os.Stderr, "Appending =prefix to an input moves its files under prefix, so trees with the\n")
+		fmt.Fprintf(os.Stderr, "same root (e.g. /data on two hosts) can be merged side by side.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	// Allow options after the input manifests, e.g. "merge a.json b.json -o out.json"
+	var inputs []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		inputs = append(inputs, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(inputs) < 2 || *outputPath == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	_, closer, err := logging.Setup(logOpts)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	trees := make([]*tree.MerkleTree, 0, len(inputs))
+	for _, input := range inputs {
+		path, prefix, remap := strings.Cut(input, "=")
+		t, err := tree.Load(path)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", path, err)
+		}
+		if remap {
+			absPrefix, err := absPath(prefix)
+			if err != nil {
+				return err
+			}
+			if t, err = tree.Relocate(t, absPrefix); err != nil {
+				return err
+			}
+		}
+		slog.Info("Loaded tree", "path", path, "root", t.RootPath, "files", len(t.Files))
+		trees = append(trees, t)
+	}
+
+	if *rootPath != "" {
+		if *rootPath, err = absPath(*rootPath); err != nil {
+			return err
+		}
+	}
+
+	merged, err := tree.Merge(trees, *rootPath)
+	if err != nil {
+		return fmt.Errorf("failed to merge trees: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*outputPath), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if err := tree.Save(merged, *outputPath); err != nil {
+		return fmt.Errorf("failed to save tree: %w", err)
+	}
+	slog.Info("Saved merged tree", "path", *outputPath, "root", merged.RootPath,
+		"files", len(merged.Files), "size", tree.FormatSize(merged.TotalSize))
+
+	fmt.Println(merged.Root.Hash)
+	return nil
+}
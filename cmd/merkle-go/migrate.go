@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"merkle-go/internal/logging"
+	"merkle-go/internal/tree"
+)
+
+// collectManifests expands the command-line paths into manifest files,
+// descending into directories to find every *.json file
+func collectManifests(paths []string) ([]string, error) {
+	var manifests []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			manifests = append(manifests, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && strings.EqualFold(filepath.Ext(p), ".json") {
+				manifests = append(manifests, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", path, err)
+		}
+	}
+	return manifests, nil
+}
+
+func migrateTrees(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Report which manifests would be upgraded without rewriting them")
+	keepBackup := fs.Bool("backup", false, "Keep each original manifest as <name>"+tree.BackupSuffix)
+	var logOpts logging.Options
+	fs.BoolVar(&logOpts.Quiet, "quiet", false, "Only log warnings and errors")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go migrate [options] <tree.json|directory>...\n\n")
+		fmt.Fprintf(os.Stderr, "Upgrade saved trees to the current manifest schema (version %d).\n", tree.SchemaVersion)
+		fmt.Fprintf(os.Stderr, "Directories are searched recursively for *.json manifests.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	_, closer, err := logging.Setup(logOpts)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	manifests, err := collectManifests(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	var upgraded, current, failed int
+	for _, path := range manifests {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		t, err := tree.Load(path)
+		if err != nil {
+			slog.Error("Cannot migrate manifest", "path", path, "error", err)
+			failed++
+			continue
+		}
+
+		if t.SchemaVersion >= tree.SchemaVersion {
+			slog.Debug("Manifest is current", "path", path)
+			current++
+			continue
+		}
+
+		if *dryRun {
+			slog.Info("Would upgrade manifest", "path", path, "from", t.SchemaVersion, "to", tree.SchemaVersion)
+			upgraded++
+			continue
+		}
+
+		if err := tree.SaveWithOptions(t, path, tree.SaveOptions{Backup: *keepBackup}); err != nil {
+			slog.Error("Failed to rewrite manifest", "path", path, "error", err)
+			failed++
+			continue
+		}
+		slog.Info("Upgraded manifest", "path", path, "from", t.SchemaVersion, "to", tree.SchemaVersion)
+		upgraded++
+	}
+
+	slog.Info("Migration complete", "upgraded", upgraded, "current", current, "failed", failed)
+	if failed > 0 {
+		return &exitError{code: 2}
+	}
+	return nil
+}
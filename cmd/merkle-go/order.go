@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"merkle-go/internal/tree"
+)
+
+// verifyOrder checks that the leaves of saved trees are in canonical order
+func verifyOrder(args []string) error {
+	fs := flag.NewFlagSet("verify-order", flag.ExitOnError)
+	bytewise := fs.Bool("bytewise", false, "Require byte-wise order by forward-slash path even for trees built in native order")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go verify-order [options] <tree.json>...\n\n")
+		fmt.Fprintf(os.Stderr, "Check that the leaves of each tree are in canonical order: byte-wise by\n")
+		fmt.Fprintf(os.Stderr, "forward-slash path for portable trees and trees built with bytewise_order,\n")
+		fmt.Fprintf(os.Stderr, "by native path otherwise. Tools that sort paths by locale or collation\n")
+		fmt.Fprintf(os.Stderr, "can produce manifests whose root hash no scan reproduces.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	failed := 0
+	for _, path := range fs.Args() {
+		t, err := tree.Load(path)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", path, err)
+		}
+		// Redacted leaves keep the order of the paths they replace
+		if t.Redacted {
+			fmt.Printf("SKIP: %s is redacted; its order cannot be checked\n", path)
+			continue
+		}
+		order := "native"
+		if *bytewise || t.Portable || t.BytewiseOrder {
+			order = "byte-wise"
+		}
+		if err := tree.CheckOrder(t, *bytewise); err != nil {
+			var orderErr *tree.OrderError
+			if !errors.As(err, &orderErr) {
+				return fmt.Errorf("failed to check %s: %w", path, err)
+			}
+			fmt.Printf("FAIL: %s is not in %s order: %v\n", path, order, err)
+			failed++
+			continue
+		}
+		fmt.Printf("OK: %s has %d leaves in %s order\n", path, len(t.Files), order)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d trees are not in canonical order", failed, fs.NArg())
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"merkle-go/internal/compare"
+	"merkle-go/internal/hash"
+	"merkle-go/internal/pkgmanifest"
+	"merkle-go/internal/tree"
+	"merkle-go/internal/walker"
+)
+
+// sizeOnlyHash stands in for the content hash of manifest entries that only
+// record a file size
+func sizeOnlyHash(size int64) string {
+	return fmt.Sprintf("size:%d", size)
+}
+
+// manifestTree converts package manifest entries into a tree rooted at root.
+// Entries that record no size take that of the installed file, or 0 if it
+// is missing, so the tree's sizes are never negative.
+func manifestTree(manifest *pkgmanifest.Manifest, root string) (*tree.MerkleTree, error) {
+	files := make(map[string]tree.FileData, len(manifest.Entries))
+	for _, entry := range manifest.Entries {
+		path := filepath.Join(root, filepath.FromSlash(entry.Path))
+		fileData := tree.FileData{Hash: entry.Digest, Size: entry.Size}
+		if entry.Size < 0 {
+			fileData.Size = 0
+			if info, err := os.Stat(path); err == nil {
+				fileData.Size = info.Size()
+			}
+		}
+		if entry.Digest == "" {
+			fileData.Hash = sizeOnlyHash(fileData.Size)
+		}
+		files[path] = fileData
+	}
+	return tree.Build(files, root)
+}
+
+// installedTree hashes the files a package manifest lists, using each
+// entry's algorithm, and builds a tree of what is actually installed
+func installedTree(ctx context.Context, manifest *pkgmanifest.Manifest, root string, flags *commonFlags) (*tree.MerkleTree, *walker.HashResult, error) {
+	files := make(map[string]tree.FileData)
+	byAlgorithm := make(map[string][]walker.FileInfo)
+	result := &walker.HashResult{Hashes: make(map[string]string)}
+
+	for _, entry := range manifest.Entries {
+		path := filepath.Join(root, filepath.FromSlash(entry.Path))
+		info, err := os.Stat(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				result.Errors = append(result.Errors, err)
+			}
+			continue // Missing files show up as deleted
+		}
+
+		if entry.Digest == "" {
+			files[path] = tree.FileData{Hash: sizeOnlyHash(info.Size()), Size: info.Size(), ModTime: info.ModTime()}
+			continue
+		}
+		byAlgorithm[entry.Algorithm] = append(byAlgorithm[entry.Algorithm], walker.FileInfo{
+			Path:    path,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+
+	for algorithm, fileInfos := range byAlgorithm {
+		if _, err := hash.New(algorithm); err != nil {
+			return nil, nil, err
+		}
+		slog.Info("Hashing files", "files", len(fileInfos), "algorithm", algorithm, "workers", flags.workers)
+
+		hashResult, err := walker.HashFilesWithOptions(ctx, fileInfos, walker.HashOptions{
+			Workers:     flags.workers,
+			FileTimeout: flags.fileTimeout,
+			HashFunc: func(path string) (string, error) {
+				return hash.HashFileWith(path, algorithm)
+			},
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to hash files: %w", err)
+		}
+
+		result.Errors = append(result.Errors, hashResult.Errors...)
+		for _, fileInfo := range fileInfos {
+			if digest, ok := hashResult.Hashes[fileInfo.Path]; ok {
+				result.Hashes[fileInfo.Path] = digest
+				files[fileInfo.Path] = tree.FileData{Hash: digest, Size: fileInfo.Size, ModTime: fileInfo.ModTime}
+			}
+		}
+	}
+
+	installed, err := tree.Build(files, root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build merkle tree: %w", err)
+	}
+	return installed, result, nil
+}
+
+func comparePackage(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("compare-package", flag.ExitOnError)
+	flags := addCommonFlags(fs)
+	reportFlags := addReportFlags(fs)
+	format := fs.String("format", "auto", "Manifest format: auto, record (Python wheel RECORD), md5sums (Debian), npm (npm pack --json)")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go compare-package [options] <manifest> <install-root>\n\n")
+		fmt.Fprintf(os.Stderr, "Verify installed files against a package's distribution manifest.\n")
+		fmt.Fprintf(os.Stderr, "Only files listed in the manifest are checked.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	closeLog, err := flags.setupLogging()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	manifest, err := pkgmanifest.Load(fs.Arg(0), *format)
+	if err != nil {
+		return err
+	}
+
+	root, err := absPath(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Loaded package manifest", "path", fs.Arg(0), "format", manifest.Format, "files", len(manifest.Entries))
+
+	expected, err := manifestTree(manifest, root)
+	if err != nil {
+		return fmt.Errorf("failed to build manifest tree: %w", err)
+	}
+
+	flags.chooseWorkers(nil, root)
+	installed, hashResult, err := installedTree(ctx, manifest, root, flags)
+	if err != nil {
+		return err
+	}
+
+	result := compare.Compare(expected, installed)
+	fmt.Println(compare.FormatReportWithOptions(result, reportFlags.options(root)))
+
+	reportErrors(hashResult.Errors)
+
+	if len(hashResult.Errors) > 0 {
+		return &exitError{code: 2}
+	}
+	if result.HasChanges() {
+		return &exitError{code: 1}
+	}
+	return nil
+}
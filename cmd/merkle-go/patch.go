@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"merkle-go/internal/tree"
+)
+
+// diffTrees lists the leaves that differ between two saved trees, or with
+// --patch writes them as a patch that patchTree can apply
+func diffTrees(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	asPatch := fs.Bool("patch", false, "Write a patch that turns old.json into new.json (see merkle-go patch)")
+	output := fs.String("o", "", "Write to this file instead of stdout")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go diff [options] <old.json> <new.json>\n\n")
+		fmt.Fprintf(os.Stderr, "List the files added (A), modified (M) and deleted (D) between two saved trees,\n")
+		fmt.Fprintf(os.Stderr, "matched by their path relative to each tree's root. With --patch, write the\n")
+		fmt.Fprintf(os.Stderr, "differences as a patch from which merkle-go patch rebuilds new.json.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	oldTree, err := tree.Load(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+	newTree, err := tree.Load(fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+	patch := tree.Diff(oldTree, newTree)
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *asPatch {
+		data, err := tree.EncodePatch(patch)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			return fmt.Errorf("failed to write patch: %w", err)
+		}
+		return nil
+	}
+
+	oldPaths := make(map[string]bool)
+	for path := range oldTree.Files {
+		oldPaths[relSlash(oldTree.RootPath, path)] = true
+	}
+	for _, change := range patch.Changes {
+		status := "D"
+		if change.Op == tree.PatchSet {
+			status = "A"
+			if oldPaths[change.Path] {
+				status = "M"
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\n", status, change.Path); err != nil {
+			return fmt.Errorf("failed to write differences: %w", err)
+		}
+	}
+	return nil
+}
+
+// patchTree applies a patch written by diff --patch to the tree it was made
+// from and writes the resulting tree
+func patchTree(args []string) error {
+	fs := flag.NewFlagSet("patch", flag.ExitOnError)
+	output := fs.String("o", "", "Save the patched tree to this file (default stdout)")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go patch [options] <old.json> <changes.mpatch>\n\n")
+		fmt.Fprintf(os.Stderr, "Rebuild the tree a patch was made from old.json to. The patch must have been\n")
+		fmt.Fprintf(os.Stderr, "made against this exact tree, and the result must match the patch's target\n")
+		fmt.Fprintf(os.Stderr, "root hash.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	// Allow options after the inputs, e.g. "patch old.json changes.mpatch -o new.json"
+	var inputs []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		inputs = append(inputs, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(inputs) != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	base, err := tree.Load(inputs[0])
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+	patch, err := tree.LoadPatch(inputs[1])
+	if err != nil {
+		return err
+	}
+	patched, err := patch.Apply(base)
+	if err != nil {
+		return fmt.Errorf("failed to apply %s: %w", inputs[1], err)
+	}
+
+	if *output == "" {
+		data, err := tree.Encode(patched)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(append(data, '\n'))
+		return err
+	}
+	if err := tree.Save(patched, *output); err != nil {
+		return fmt.Errorf("failed to save tree: %w", err)
+	}
+	slog.Info("Wrote patched tree", "path", *output, "root", patched.Root.Hash, "files", len(patched.Files))
+	return nil
+}
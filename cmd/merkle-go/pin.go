@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"merkle-go/internal/tree"
+)
+
+// pinTree writes the root hash of a saved tree, with the settings needed to
+// compute it again, to a small pin file in or beside the scanned directory
+func pinTree(args []string) error {
+	fs := flag.NewFlagSet("pin", flag.ExitOnError)
+	output := fs.String("o", "", "Write the pin to this file (default: "+tree.PinFile+" in the tree's root directory)")
+	beside := fs.Bool("beside", false, "Write the pin next to the directory, as <directory>"+tree.PinFile+", leaving the directory untouched")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go pin [options] <tree.json>\n\n")
+		fmt.Fprintf(os.Stderr, "Pin the root hash of a saved tree, e.g. at release time, in a small %s\n", tree.PinFile)
+		fmt.Fprintf(os.Stderr, "file. check-pin later rescans the directory and reports whether anything\n")
+		fmt.Fprintf(os.Stderr, "changed, without needing the manifest.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *output != "" && *beside {
+		return fmt.Errorf("-o cannot be combined with --beside")
+	}
+
+	merkleTree, err := tree.Load(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+	pin, err := tree.NewPin(merkleTree)
+	if err != nil {
+		return fmt.Errorf("cannot pin %s: %w", fs.Arg(0), err)
+	}
+
+	pinPath := *output
+	switch {
+	case *beside:
+		pinPath = besidePinPath(merkleTree.RootPath)
+	case pinPath == "":
+		pinPath = filepath.Join(merkleTree.RootPath, tree.PinFile)
+	}
+	if err := tree.SavePin(pin, pinPath); err != nil {
+		return fmt.Errorf("failed to write pin: %w", err)
+	}
+	fmt.Printf("Pinned %s at root %s to %s\n", merkleTree.RootPath, pin.Root, pinPath)
+	return nil
+}
+
+// checkPin rescans a directory and compares its root hash to the pinned one,
+// exiting with status 1 if it drifted
+func checkPin(args []string) error {
+	fs := flag.NewFlagSet("check-pin", flag.ExitOnError)
+	flags := addCommonFlags(fs)
+	pinFlag := fs.String("pin", "", "Pin file to check against (default: "+tree.PinFile+" in the directory, else <directory>"+tree.PinFile+")")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go check-pin [options] <directory>\n\n")
+		fmt.Fprintf(os.Stderr, "Rescan the directory with the settings recorded by pin and compare its root\n")
+		fmt.Fprintf(os.Stderr, "hash to the pinned one. Exits with status 1 if anything changed; use compare\n")
+		fmt.Fprintf(os.Stderr, "with the manifest to find out what.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	closeLog, err := flags.setupLogging()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	absDirectory, err := absPath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	pinPath := *pinFlag
+	if pinPath == "" {
+		if pinPath, err = findPin(absDirectory); err != nil {
+			return err
+		}
+	}
+	pin, err := tree.LoadPin(pinPath)
+	if err != nil {
+		return err
+	}
+	slog.Info("Loaded pin", "path", pinPath, "root", pin.Root, "created", pin.Created.Format(time.RFC3339))
+
+	cfg, err := flags.loadConfig(absDirectory)
+	if err != nil {
+		return err
+	}
+	pin.Apply(cfg)
+	cfg.Workers = flags.workers
+
+	current, err := tree.RootHash(absDirectory, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", absDirectory, err)
+	}
+	if current != pin.Root {
+		fmt.Printf("DRIFT: %s has root %s, pinned %s on %s\n", absDirectory, current, pin.Root, pin.Created.Format(time.RFC3339))
+		return &exitError{code: 1}
+	}
+	fmt.Printf("OK: %s matches the root %s pinned on %s\n", absDirectory, pin.Root, pin.Created.Format(time.RFC3339))
+	return nil
+}
+
+// findPin returns the pin of dir: the pin file in it, else the one beside it
+func findPin(dir string) (string, error) {
+	for _, path := range []string{filepath.Join(dir, tree.PinFile), besidePinPath(dir)} {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no %s in or beside %s; pass --pin", tree.PinFile, dir)
+}
+
+// besidePinPath is the pin file next to dir, e.g. /srv/release.merkle-root
+func besidePinPath(dir string) string {
+	return filepath.Clean(dir) + tree.PinFile
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"merkle-go/internal/config"
+	"merkle-go/internal/hash"
+	"merkle-go/internal/tree"
+)
+
+// proofCaveat is shown in the help of the proof commands
+const proofCaveat = `Proofs are integrity hints, not tamper-evidence: they catch accidental
+corruption, but the tree uses xxh64 parents with no domain separation, so
+someone who can choose file contents can forge a proof. They are not
+RFC 6962 (certificate transparency) audit paths.`
+
+// printProof writes the inclusion proof of one file in a saved tree as JSON
+func printProof(args []string) error {
+	fs := flag.NewFlagSet("proof", flag.ExitOnError)
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go proof <tree.json> <path>\n\n")
+		fmt.Fprintf(os.Stderr, "Print a JSON inclusion proof linking the file at path (relative to the tree\n")
+		fmt.Fprintf(os.Stderr, "root, or absolute) to the tree's root hash.\n\n")
+		fmt.Fprintf(os.Stderr, "%s\n", proofCaveat)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	merkleTree, err := tree.Load(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+
+	relPath, err := treeRelPath(merkleTree, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	proof, err := tree.GenerateProof(merkleTree, relPath)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(proof, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal proof: %w", err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// proveFiles writes the inclusion proofs of a list of files in a saved tree
+// as one JSON proof set
+func proveFiles(args []string) error {
+	fs := flag.NewFlagSet("prove", flag.ExitOnError)
+	pathsFile := fs.String("paths", "", "File listing the paths to prove (- for stdin), NUL- or newline-separated (required)")
+	output := fs.String("o", "", "Output file (default stdout)")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go prove --paths <paths.txt> <tree.json> [-o proofs.json]\n\n")
+		fmt.Fprintf(os.Stderr, "Write the inclusion proofs of the listed files (relative to the tree root, or\n")
+		fmt.Fprintf(os.Stderr, "absolute) as one JSON proof set, checkable with verify-proof.\n\n")
+		fmt.Fprintf(os.Stderr, "%s\n\n", proofCaveat)
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	// Allow options after the tree, e.g. "prove --paths list.txt tree.json -o proofs.json"
+	var inputs []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		inputs = append(inputs, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(inputs) != 1 || *pathsFile == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	merkleTree, err := tree.Load(inputs[0])
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+
+	entries, err := readPathList(*pathsFile)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no paths listed in %s", *pathsFile)
+	}
+	relPaths := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		relPath, err := treeRelPath(merkleTree, entry)
+		if err != nil {
+			return err
+		}
+		relPaths = append(relPaths, relPath)
+	}
+
+	set, err := tree.GenerateProofs(merkleTree, relPaths)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal proofs: %w", err)
+	}
+	data = append(data, '\n')
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write proofs: %w", err)
+	}
+	slog.Info("Wrote proofs", "path", *output, "files", len(set.Proofs))
+	return nil
+}
+
+// readPathList reads a list of paths from a file, or stdin for "-".
+// Entries are separated by NUL bytes if the list contains any, by newlines
+// otherwise; empty entries are skipped.
+func readPathList(name string) ([]string, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read path list: %w", err)
+	}
+
+	sep := "\n"
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = "\x00"
+	}
+	var entries []string
+	for _, entry := range strings.Split(string(data), sep) {
+		if entry = strings.TrimSuffix(entry, "\r"); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// verifyProof checks a proof written by printProof, optionally against the
+// current contents of the file it covers
+func verifyProof(args []string) error {
+	fs := flag.NewFlagSet("verify-proof", flag.ExitOnError)
+	rootHash := fs.String("root", "", "Trusted root hash to verify against (default: the root hash in the proof)")
+	keyPath := fs.String("hash-key", "", "File holding the key of hmac-sha256 proofs, to check file hashes")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go verify-proof [options] <proof.json> [file]\n")
+		fmt.Fprintf(os.Stderr, "       merkle-go verify-proof [options] <proofs.json> [directory]\n\n")
+		fmt.Fprintf(os.Stderr, "Verify an inclusion proof, or every proof of a set written by prove. If file\n")
+		fmt.Fprintf(os.Stderr, "is given, its current hash must match the proof's leaf hash; for a set, the\n")
+		fmt.Fprintf(os.Stderr, "files are looked up under directory.\n\n")
+		fmt.Fprintf(os.Stderr, "%s\n\n", proofCaveat)
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to read proof: %w", err)
+	}
+	var proof tree.Proof
+	if err := json.Unmarshal(data, &proof); err != nil {
+		return fmt.Errorf("failed to parse proof: %w", err)
+	}
+	var key []byte
+	if *keyPath != "" {
+		if key, err = config.ReadHashKey(*keyPath); err != nil {
+			return err
+		}
+	}
+	if proof.Format == tree.ProofSetFormat {
+		var set tree.ProofSet
+		if err := json.Unmarshal(data, &set); err != nil {
+			return fmt.Errorf("failed to parse proof set: %w", err)
+		}
+		return verifyProofSet(&set, *rootHash, fs.Arg(1), key)
+	}
+
+	if fs.NArg() == 2 {
+		if err := checkLeafHash(&proof, fs.Arg(1), key); err != nil {
+			return err
+		}
+	}
+
+	root := *rootHash
+	if root == "" {
+		root = proof.RootHash
+	}
+	if err := tree.VerifyProof(&proof, root); err != nil {
+		return err
+	}
+
+	fmt.Printf("OK: %s is leaf %d of %d under root %s\n", proof.Path, proof.LeafIndex, proof.TreeSize, root)
+	return nil
+}
+
+// verifyProofSet checks every proof of a set written by proveFiles. If
+// directory is not empty, the files are looked up under it and their
+// current hashes must match the proofs' leaf hashes.
+func verifyProofSet(set *tree.ProofSet, rootHash, directory string, key []byte) error {
+	if rootHash == "" {
+		rootHash = set.RootHash
+	}
+	if err := tree.VerifyProofSet(set, rootHash); err != nil {
+		return err
+	}
+	if directory != "" {
+		for i := range set.Proofs {
+			path := filepath.Join(directory, filepath.FromSlash(set.Proofs[i].Path))
+			if err := checkLeafHash(&set.Proofs[i], path, key); err != nil {
+				return err
+			}
+		}
+	}
+
+	fmt.Printf("OK: %d files under root %s\n", len(set.Proofs), rootHash)
+	return nil
+}
+
+// checkLeafHash hashes the file at path the way the proof's tree did and
+// compares the result with the proof's leaf hash. Keyed proofs need key.
+func checkLeafHash(proof *tree.Proof, path string, key []byte) error {
+	hashFunc, err := hash.FileHasher(proof.HashAlgorithm, hash.ReadOptions{SegmentSize: proof.SegmentSize, Key: key})
+	if err != nil {
+		return err
+	}
+	hashFunc = hash.SampledHasher(hashFunc, proof.HashAlgorithm, key, func(string) int64 { return proof.Sampled }, nil)
+	digest, err := hashFunc(path)
+	if err != nil {
+		return err
+	}
+	if digest != proof.LeafHash {
+		return fmt.Errorf("%s has hash %s, proof is for %s", path, digest, proof.LeafHash)
+	}
+	return nil
+}
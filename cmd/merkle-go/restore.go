@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"merkle-go/internal/compare"
+	"merkle-go/internal/restore"
+	"merkle-go/internal/tree"
+)
+
+// restoreTree copies, overwrites and deletes files in a directory until it
+// matches a manifest
+func restoreTree(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	flags := addCommonFlags(fs)
+	from := fs.String("from", "", "Directory or http(s) URL to copy the files from")
+	manifestPath := fs.String("manifest", "", "Manifest the target directory should match")
+	dryRun := fs.Bool("dry-run", false, "Print the plan without changing anything")
+	keepExtra := fs.Bool("keep-extra", false, "Do not delete files that are not in the manifest")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go restore [options] --from <dir|url> --manifest <tree.json> <target-dir>\n\n")
+		fmt.Fprintf(os.Stderr, "Reconcile target-dir with the manifest: missing and changed files are copied from\n")
+		fmt.Fprintf(os.Stderr, "the source and verified against the manifest hash before they replace anything,\n")
+		fmt.Fprintf(os.Stderr, "and files the manifest does not list are deleted.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *manifestPath == "" || (*from == "" && !*dryRun) {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	closeLog, err := flags.setupLogging()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	manifest, err := flags.loadTree(ctx, *manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+	var source restore.Source
+	if *from != "" {
+		if source, err = restore.NewSource(*from); err != nil {
+			return err
+		}
+	}
+
+	absTarget, err := absPath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(absTarget, 0755); err != nil {
+		return fmt.Errorf("failed to create target directory: %w", err)
+	}
+
+	// Scan the target the same way the manifest was built
+	cfg, err := flags.loadConfig(absTarget)
+	if err != nil {
+		return err
+	}
+	cfg.Portable = manifest.Portable
+	cfg.BytewiseOrder = manifest.BytewiseOrder
+	cfg.HashAlgorithm = manifest.HashAlgorithm
+	cfg.HashAlgorithms = nil
+	cfg.EmptyDirs = manifest.EmptyDirs
+	cfg.DescendArchives = manifest.Archives
+	cfg.SegmentSize = manifest.SegmentSize
+	cfg.Fingerprint = manifest.Fingerprint
+	scan, err := scanDirectory(ctx, absTarget, cfg, flags)
+	if err != nil {
+		return err
+	}
+	reportErrors(scan.Hash.Errors)
+
+	plan, err := compare.NewSyncPlan(scan.Tree, manifest)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		return compare.WriteSyncPlan(os.Stdout, plan, false)
+	}
+
+	slog.Info("Restoring", "target", absTarget, "copy", plan.CopyFiles, "bytes", plan.CopyBytes, "delete", plan.DeleteFiles)
+	result, err := restore.Apply(ctx, plan, absTarget, source, restore.Options{
+		Algorithm:   manifest.LeafAlgorithm(),
+		Key:         cfg.HashKeyBytes(),
+		SegmentSize: manifest.SegmentSize,
+		KeepExtra:   *keepExtra,
+		Archives:    manifest.Archives,
+		OnStep: func(step compare.SyncStep, err error) {
+			if err != nil {
+				slog.Warn("Failed to restore", "path", step.Path, "error", err)
+				return
+			}
+			slog.Debug("Restored", "action", step.Action, "path", step.Path)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Restore finished", "copied", result.Copied, "bytes", tree.FormatSize(result.CopiedBytes),
+		"deleted", result.Deleted, "failed", len(result.Errors))
+	if len(result.Errors) > 0 || len(scan.Hash.Errors) > 0 {
+		return &exitError{code: 2}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"merkle-go/internal/config"
+	"merkle-go/internal/tree"
+	"merkle-go/internal/walker"
+)
+
+// retryErrors rehashes only the paths a previous scan recorded as errors and
+// builds a tree from those results together with the files the previous scan
+// did hash. Errored directories are walked again; paths that no longer exist
+// are dropped. Paths that still fail are recorded again.
+func retryErrors(ctx context.Context, prev *tree.MerkleTree, absDirectory string, cfg *config.Config, flags *commonFlags) (*scanResult, error) {
+	if filepath.Clean(prev.RootPath) != filepath.Clean(absDirectory) {
+		relocated, err := tree.Relocate(prev, absDirectory)
+		if err != nil {
+			return nil, err
+		}
+		prev = relocated
+	}
+	cfg.Portable = prev.Portable
+	cfg.BytewiseOrder = prev.BytewiseOrder
+	cfg.HashAlgorithm = prev.HashAlgorithm
+	cfg.HashAlgorithms = hashAlgorithmsOf(prev)
+	cfg.EmptyDirs = prev.EmptyDirs
+	cfg.DescendArchives = prev.Archives
+	cfg.SegmentSize = prev.SegmentSize
+	cfg.Fingerprint = prev.Fingerprint
+	cfg.ChunkSize = prev.ChunkSize
+	cfg.CIDs = prev.CIDs
+
+	walkResult := &walker.WalkResult{Files: make([]walker.FileInfo, 0), Errors: make([]error, 0)}
+	for _, scanErr := range prev.Errors {
+		info, err := os.Stat(scanErr.Path)
+		switch {
+		case os.IsNotExist(err):
+			slog.Info("Errored path no longer exists", "path", scanErr.Path)
+		case err != nil:
+			walkResult.Errors = append(walkResult.Errors, err)
+		case info.IsDir():
+			sub, err := walker.WalkWithOptions(ctx, scanErr.Path, walkOptions(cfg))
+			if err != nil {
+				walkResult.Errors = append(walkResult.Errors, err)
+				continue
+			}
+			walkResult.Files = append(walkResult.Files, sub.Files...)
+			walkResult.Errors = append(walkResult.Errors, sub.Errors...)
+		default:
+			walkResult.Files = append(walkResult.Files, walker.FileInfo{Path: scanErr.Path, Size: info.Size(), ModTime: info.ModTime()})
+		}
+	}
+	slog.Info("Retrying errored paths", "paths", len(prev.Errors), "files", len(walkResult.Files))
+
+	scan, err := hashAndBuild(ctx, absDirectory, walkResult, cfg, flags, nil)
+	if err != nil {
+		return scan, err
+	}
+
+	if err := rebuildWith(scan, prev.Files, absDirectory, cfg); err != nil {
+		return nil, err
+	}
+	return scan, nil
+}
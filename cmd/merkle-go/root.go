@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"merkle-go/internal/tree"
+)
+
+// rootHash prints the subtree hash of a directory recorded in a saved tree
+func rootHash(args []string) error {
+	fs := flag.NewFlagSet("root", flag.ExitOnError)
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go root <tree.json> [subpath]\n\n")
+		fmt.Fprintf(os.Stderr, "Print the hash of the subtree under subpath, relative to the tree root or\n")
+		fmt.Fprintf(os.Stderr, "absolute. The hash only changes when a file under subpath changes. Without\n")
+		fmt.Fprintf(os.Stderr, "subpath, the root hash of the whole tree is printed.\n")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	merkleTree, err := tree.Load(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+
+	subpath, err := treeRelPath(merkleTree, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	hash, ok := merkleTree.SubtreeHash(subpath)
+	if !ok {
+		return fmt.Errorf("no files under %s in %s", fs.Arg(1), fs.Arg(0))
+	}
+	fmt.Println(hash)
+	return nil
+}
+
+// treeRelPath converts a path given on the command line, either absolute or
+// relative to the tree root, to the slash-separated form used by leaves
+func treeRelPath(t *tree.MerkleTree, p string) (string, error) {
+	if !filepath.IsAbs(p) {
+		return filepath.ToSlash(p), nil
+	}
+	rel, err := filepath.Rel(t.RootPath, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not under the tree root %s", p, t.RootPath)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// relSlash returns path relative to root with forward slashes, or path
+// itself if it is not under root
+func relSlash(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
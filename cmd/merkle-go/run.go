@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+
+	"merkle-go/internal/config"
+)
+
+// runTargets scans every [[targets]] entry of the config into its own
+// manifest, so one invocation, e.g. from a single systemd unit, covers them
+// all
+func runTargets(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	flags := addCommonFlags(fs)
+	var only stringList
+	fs.Var(&only, "only", "Scan only the target with this name (repeatable)")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go run [options] [-- generate options]\n\n")
+		fmt.Fprintf(os.Stderr, "Scan each [[targets]] entry of the config into its own manifest, in config\n")
+		fmt.Fprintf(os.Stderr, "order. A target that fails does not stop the others. Options after -- are\n")
+		fmt.Fprintf(os.Stderr, "passed to every scan, e.g. -- --backup --empty-dirs.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if flags.target != "" {
+		return fmt.Errorf("--target selects the target of a single scan; use --only with run")
+	}
+
+	cfg, err := config.LoadConfig(flags.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if len(cfg.Targets) == 0 {
+		return fmt.Errorf("%s defines no [[targets]]", flags.configPath)
+	}
+	for _, name := range only {
+		if !slices.Contains(cfg.TargetNames(), name) {
+			return fmt.Errorf("unknown target %q", name)
+		}
+	}
+
+	// Every scan gets the options given to run
+	var common []string
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "only" {
+			common = append(common, "--"+f.Name+"="+f.Value.String())
+		}
+	})
+	common = append(common, fs.Args()...)
+
+	var failures []error
+	scanned := 0
+	for _, target := range cfg.Targets {
+		if len(only) > 0 && !slices.Contains(only, target.Name) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		scanned++
+		scanArgs := append(slices.Clone(common), "--target", target.Name, target.Path)
+		if err := generateTree(ctx, scanArgs); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
+			failures = append(failures, fmt.Errorf("target %s: %w", target.Name, err))
+		}
+	}
+
+	// Each scan sets up and closes its own log
+	closeLog, err := flags.setupLogging()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+	for _, err := range failures {
+		slog.Warn("Target failed", "error", err)
+	}
+	slog.Info("Targets scanned", "targets", scanned, "failed", len(failures))
+	if len(failures) > 0 {
+		return &exitError{code: 2}
+	}
+	return nil
+}
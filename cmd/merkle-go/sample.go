@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+
+	"merkle-go/internal/config"
+	"merkle-go/internal/hash"
+)
+
+// sampleRecorder fingerprints the files matching the fingerprint rules of a
+// config from a sample of their content and records which files it sampled,
+// so their leaves can be marked
+type sampleRecorder struct {
+	cfg          *config.Config
+	absDirectory string
+
+	mu      sync.Mutex
+	sampled map[string]int64
+}
+
+func newSampleRecorder(cfg *config.Config, absDirectory string) *sampleRecorder {
+	return &sampleRecorder{cfg: cfg, absDirectory: absDirectory, sampled: make(map[string]int64)}
+}
+
+// wrap returns hashFunc extended to sample the files the rules select
+// instead of hashing them with hashFunc
+func (r *sampleRecorder) wrap(hashFunc func(path string) (string, error)) func(path string) (string, error) {
+	return hash.SampledHasher(hashFunc, r.cfg.HashAlgorithm, r.cfg.HashKeyBytes(), func(path string) int64 {
+		relPath, err := filepath.Rel(r.absDirectory, path)
+		if err != nil {
+			return 0
+		}
+		return r.cfg.FingerprintFor(relPath)
+	}, func(path string, sample int64) {
+		r.mu.Lock()
+		r.sampled[path] = sample
+		r.mu.Unlock()
+	})
+}
+
+// get returns the bytes sampled from each end of the file at path, or 0 if
+// it was hashed whole; a nil recorder samples nothing
+func (r *sampleRecorder) get(path string) int64 {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sampled[path]
+}
+
+// count returns how many files were sampled
+func (r *sampleRecorder) count() int {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.sampled)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"merkle-go/internal/tree"
+)
+
+// leafInfo is a file of a saved tree, by its path relative to the root
+type leafInfo struct {
+	path string
+	data tree.FileData
+}
+
+// sortedLeaves returns the files of t with slash-separated relative paths,
+// sorted by path
+func sortedLeaves(t *tree.MerkleTree) []leafInfo {
+	leaves := make([]leafInfo, 0, len(t.Files))
+	for absolute, data := range t.Files {
+		leaves = append(leaves, leafInfo{path: relSlash(t.RootPath, absolute), data: data})
+	}
+	sort.Slice(leaves, func(i, j int) bool { return leaves[i].path < leaves[j].path })
+	return leaves
+}
+
+// printTree renders the directory hierarchy of a tree as ASCII art, with the
+// subtree hash of every directory and the hash and size of every file
+func printTree(t *tree.MerkleTree, leaves []leafInfo) {
+	type dirNode struct {
+		dirs  map[string]*dirNode
+		files []leafInfo
+	}
+	newDir := func() *dirNode { return &dirNode{dirs: make(map[string]*dirNode)} }
+
+	root := newDir()
+	for _, leaf := range leaves {
+		node := root
+		parts := strings.Split(leaf.path, "/")
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := node.dirs[part]
+			if !ok {
+				child = newDir()
+				node.dirs[part] = child
+			}
+			node = child
+		}
+		node.files = append(node.files, leaf)
+	}
+
+	var render func(node *dirNode, dirPath, indent string)
+	render = func(node *dirNode, dirPath, indent string) {
+		names := make([]string, 0, len(node.dirs))
+		for name := range node.dirs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		total := len(names) + len(node.files)
+		i := 0
+		branch := func() (string, string) {
+			i++
+			if i == total {
+				return indent + "└── ", indent + "    "
+			}
+			return indent + "├── ", indent + "│   "
+		}
+
+		for _, name := range names {
+			childPath := path.Join(dirPath, name)
+			prefix, childIndent := branch()
+			fmt.Printf("%s%s/  %s\n", prefix, name, t.Directories[childPath])
+			render(node.dirs[name], childPath, childIndent)
+		}
+		for _, leaf := range node.files {
+			prefix, _ := branch()
+			fmt.Printf("%s%s  %s  %s\n", prefix, path.Base(leaf.path), leaf.data.Hash, tree.FormatSize(leaf.data.Size))
+		}
+	}
+
+	fmt.Printf("%s  %s\n", t.RootPath, t.Root.Hash)
+	render(root, "", "")
+}
+
+// showTree prints a human-readable summary of a saved tree
+func showTree(args []string) error {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	top := fs.Int("top", 10, "Number of largest files and deepest paths to list")
+	renderTree := fs.Bool("tree", false, "Also render the full directory tree")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go show [options] <tree.json>\n\n")
+		fmt.Fprintf(os.Stderr, "Print a human-readable summary of a saved tree.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	t, err := tree.Load(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+	leaves := sortedLeaves(t)
+
+	fmt.Printf("Root hash:   %s\n", t.Root.Hash)
+	fmt.Printf("Root path:   %s\n", t.RootPath)
+	fmt.Printf("Files:       %d in %d directories\n", len(leaves), len(t.Directories))
+	fmt.Printf("Total size:  %s (%d bytes)\n", tree.FormatSize(t.TotalSize), t.TotalSize)
+	fmt.Printf("Created:     %s\n", t.Created.Format("2006-01-02 15:04:05 MST"))
+	if t.MetadataOnly {
+		fmt.Printf("Hash:        none (sizes and modification times only)\n")
+	} else {
+		fmt.Printf("Hash:        %s\n", t.LeafAlgorithm())
+	}
+	if len(t.DigestAlgorithms) > 0 {
+		fmt.Printf("Digests:     %s\n", strings.Join(t.DigestAlgorithms, ", "))
+	}
+	generator := t.GeneratorVersion
+	if generator == "" {
+		generator = "unknown"
+	}
+	fmt.Printf("Written by:  merkle-go %s (schema %d)\n", generator, t.SchemaVersion)
+	if t.Portable {
+		fmt.Printf("Portable:    yes\n")
+	} else if t.BytewiseOrder {
+		fmt.Printf("Order:       byte-wise\n")
+	}
+	if t.Volume != nil {
+		fmt.Printf("Volume:      %s\n", t.Volume.String())
+	}
+	if t.Scan != nil {
+		fmt.Printf("Settings:    %s\n", t.Scan.ConfigHash)
+		if len(t.Scan.Skip) > 0 {
+			fmt.Printf("Skip:        %s\n", strings.Join(t.Scan.Skip, " "))
+		}
+		if len(t.Scan.SkipRegex) > 0 {
+			fmt.Printf("Skip regex:  %s\n", strings.Join(t.Scan.SkipRegex, " "))
+		}
+		if t.Scan.Workers > 0 {
+			fmt.Printf("Scan:        %s with %d workers\n", t.Scan.Duration.Round(time.Millisecond), t.Scan.Workers)
+		}
+	}
+
+	if *top > 0 && len(leaves) > 0 {
+		bySize := append([]leafInfo(nil), leaves...)
+		sort.SliceStable(bySize, func(i, j int) bool { return bySize[i].data.Size > bySize[j].data.Size })
+		fmt.Printf("\nLargest files:\n")
+		for _, leaf := range bySize[:min(*top, len(bySize))] {
+			fmt.Printf("  %10s  %s\n", tree.FormatSize(leaf.data.Size), leaf.path)
+		}
+
+		byDepth := append([]leafInfo(nil), leaves...)
+		sort.SliceStable(byDepth, func(i, j int) bool {
+			return strings.Count(byDepth[i].path, "/") > strings.Count(byDepth[j].path, "/")
+		})
+		fmt.Printf("\nDeepest paths:\n")
+		for _, leaf := range byDepth[:min(*top, len(byDepth))] {
+			fmt.Printf("  %3d  %s\n", strings.Count(leaf.path, "/")+1, leaf.path)
+		}
+	}
+
+	if *renderTree {
+		fmt.Println()
+		printTree(t, leaves)
+	}
+	return nil
+}
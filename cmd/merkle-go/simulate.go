@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"merkle-go/internal/logging"
+	"merkle-go/internal/pathmatch"
+	"merkle-go/internal/tree"
+)
+
+// readAddManifest reads a CSV of files to add to a tree hashed with
+// algorithm; see tree.ReadAdditions
+func readAddManifest(path, algorithm string) (map[string]tree.FileData, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	return tree.ReadAdditions(f, path, algorithm)
+}
+
+func simulateTree(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	var deletePatterns, addManifests stringList
+	fs.Var(&deletePatterns, "delete", "Glob of paths to remove, relative to the tree root (repeatable, supports **)")
+	fs.Var(&addManifests, "add", "CSV of files to add or replace: path,hash,size[,mtime] (repeatable)")
+	outputPath := fs.String("o", "", "Also save the simulated tree to this file")
+	var logOpts logging.Options
+	fs.BoolVar(&logOpts.Quiet, "quiet", false, "Only print the resulting root hash")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go simulate [options] <tree.json>\n\n")
+		fmt.Fprintf(os.Stderr, "Compute the root hash a saved tree would have after deleting and adding files,\n")
+		fmt.Fprintf(os.Stderr, "without touching the directory.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	_, closer, err := logging.Setup(logOpts)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	for _, pattern := range deletePatterns {
+		if err := pathmatch.Validate(pattern); err != nil {
+			return fmt.Errorf("invalid --delete pattern %q: %w", pattern, err)
+		}
+	}
+
+	original, err := tree.Load(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+
+	files := make(map[string]tree.FileData, len(original.Files))
+	deleted := 0
+	for path, fileData := range original.Files {
+		relPath, err := filepath.Rel(original.RootPath, path)
+		if err != nil {
+			relPath = path
+		}
+		if pathmatch.MatchAny(deletePatterns, relPath) {
+			deleted++
+			continue
+		}
+		files[path] = fileData
+	}
+
+	added, replaced := 0, 0
+	for _, manifest := range addManifests {
+		additions, err := readAddManifest(manifest, original.HashAlgorithm)
+		if err != nil {
+			return err
+		}
+		for relPath, fileData := range additions {
+			path := filepath.Join(original.RootPath, relPath)
+			if _, exists := files[path]; exists {
+				replaced++
+			} else {
+				added++
+			}
+			files[path] = fileData
+		}
+	}
+
+	simulated, err := tree.BuildWithOptions(files, original.RootPath, tree.BuildOptions{
+		Portable:      original.Portable,
+		BytewiseOrder: original.BytewiseOrder,
+		HashAlgorithm: original.HashAlgorithm,
+		EmptyDirs:     original.EmptyDirs,
+		Archives:      original.Archives,
+		SegmentSize:   original.SegmentSize,
+		ChunkSize:     original.ChunkSize,
+		CIDs:          original.CIDs,
+		Fingerprint:   original.Fingerprint,
+
+		DigestAlgorithms: original.DigestAlgorithms,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build simulated tree: %w", err)
+	}
+
+	slog.Info("Simulated changes", "deleted", deleted, "added", added, "replaced", replaced,
+		"files", len(files), "original_root", original.Root.Hash)
+
+	if *outputPath != "" {
+		simulated.Volume = original.Volume
+		if err := tree.Save(simulated, *outputPath); err != nil {
+			return fmt.Errorf("failed to save tree: %w", err)
+		}
+		slog.Info("Saved simulated tree", "path", *outputPath)
+	}
+
+	fmt.Println(simulated.Root.Hash)
+	return nil
+}
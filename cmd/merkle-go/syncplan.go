@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"merkle-go/internal/compare"
+	"merkle-go/internal/tree"
+)
+
+// syncPlan prints the actions that turn the state of old.json into that of
+// new.json, for backup tools that replicate from the newer side
+func syncPlan(args []string) error {
+	fs := flag.NewFlagSet("sync-plan", flag.ExitOnError)
+	format := fs.String("format", "text", "Output format: text or json")
+	all := fs.Bool("all", false, "Also list unchanged files in text output")
+	output := fs.String("o", "", "Write the plan to this file instead of stdout")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go sync-plan [options] <old.json> <new.json>\n\n")
+		fmt.Fprintf(os.Stderr, "Print the copy and delete actions that bring a replica matching old.json up to\n")
+		fmt.Fprintf(os.Stderr, "date with new.json, and the total bytes to transfer. Files are matched by their\n")
+		fmt.Fprintf(os.Stderr, "path relative to each tree's root.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q (want text or json)", *format)
+	}
+
+	oldTree, err := tree.Load(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+	newTree, err := tree.Load(fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+
+	plan, err := compare.NewSyncPlan(oldTree, newTree)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(plan)
+	} else {
+		err = compare.WriteSyncPlan(w, plan, *all)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+	return nil
+}
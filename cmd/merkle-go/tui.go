@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"merkle-go/internal/compare"
+	"merkle-go/internal/tree"
+	"merkle-go/internal/tui"
+)
+
+// browseTree opens the interactive browser on a saved tree, or on the
+// differences between two saved trees
+func browseTree(args []string) error {
+	fs := flag.NewFlagSet("tui", flag.ExitOnError)
+	strict := fs.Bool("strict", false, "Also mark files whose modification time changed although their content did not")
+	noRenames := fs.Bool("no-renames", false, "Mark moved files as deleted and added instead of renamed")
+	caseInsensitive := fs.Bool("case-insensitive", false, "Match paths that only differ in letter case, marking such files as case-renamed")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go tui [options] <tree.json> [new.json]\n\n")
+		fmt.Fprintf(os.Stderr, "Browse a saved tree interactively. Given a second tree, browse that one with\n")
+		fmt.Fprintf(os.Stderr, "the changes since the first marked. Keys: arrows or hjkl to move, enter to\n")
+		fmt.Fprintf(os.Stderr, "open, / to search by path or hash prefix, c to show only changes, q to quit.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	merkleTree, err := tree.Load(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+	if fs.NArg() == 1 {
+		return tui.Run(tui.New(merkleTree, nil))
+	}
+
+	newTree, err := tree.Load(fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+	oldTree, err := compare.AlignRoots(merkleTree, newTree)
+	if err != nil {
+		return err
+	}
+	if _, err := compare.SharedAlgorithm(oldTree, newTree); err != nil {
+		return err
+	}
+	result := compare.CompareWithOptions(oldTree, newTree, compare.Options{Strict: *strict, NoRenames: *noRenames, CaseInsensitive: *caseInsensitive})
+	return tui.Run(tui.New(newTree, result))
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"merkle-go/internal/compare"
+	"merkle-go/internal/config"
+	"merkle-go/internal/objstore"
+	"merkle-go/internal/pathmatch"
+	"merkle-go/internal/systemd"
+	"merkle-go/internal/tree"
+	"merkle-go/internal/walker"
+	"merkle-go/internal/watch"
+)
+
+// watchDirectory keeps a saved tree of a directory up to date, rescanning
+// after each burst of changes and printing what changed
+func watchDirectory(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	debounce := fs.Duration("debounce", 0, "Rescan once changes stop for this long (default: watch_debounce from the config, or 2s)")
+	batch := fs.Int("batch", 0, "Rescan after this many change events even if they keep coming (default: watch_batch from the config, or 1000)")
+	flags := addCommonFlags(fs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: merkle-go watch [options] <directory> <tree.json>\n\n")
+		fmt.Fprintf(os.Stderr, "Keep the tree of a directory up to date as files change, printing the changes\n")
+		fmt.Fprintf(os.Stderr, "of every rescan. An existing tree is the starting point and decides how files\n")
+		fmt.Fprintf(os.Stderr, "are hashed; otherwise the directory is scanned first. Change events are\n")
+		fmt.Fprintf(os.Stderr, "coalesced: a rescan starts once they stop for the debounce window, or after\n")
+		fmt.Fprintf(os.Stderr, "a batch of them, and only rehashes files whose size or modification time\n")
+		fmt.Fprintf(os.Stderr, "changed. Linux only.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	closeLog, err := flags.setupLogging()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	absDirectory, err := absPath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	treePath := fs.Arg(1)
+	if !objstore.IsURL(treePath) {
+		if treePath, err = absPath(treePath); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := flags.loadConfig(absDirectory)
+	if err != nil {
+		return err
+	}
+	opts := watch.Options{Debounce: *debounce, MaxBatch: *batch}
+	if !flags.isSet("debounce") {
+		// Validated with the config
+		opts.Debounce, _ = cfg.WatchDebounceDuration()
+	}
+	if !flags.isSet("batch") {
+		opts.MaxBatch = cfg.WatchBatch
+	}
+	if opts.Debounce < 0 || opts.MaxBatch < 0 {
+		return fmt.Errorf("--debounce and --batch must not be negative")
+	}
+	if opts.Debounce == 0 {
+		opts.Debounce = watch.DefaultDebounce
+	}
+	if opts.MaxBatch == 0 {
+		opts.MaxBatch = watch.DefaultMaxBatch
+	}
+
+	// Start watching before the first scan so no change slips in between
+	regexps, err := pathmatch.CompileRegexps(cfg.SkipRegex)
+	if err != nil {
+		return err
+	}
+	events, err := watch.Watch(ctx, absDirectory, func(path string) bool {
+		return watchIgnored(path, absDirectory, treePath, cfg.Skip, regexps)
+	})
+	if err != nil {
+		return err
+	}
+
+	current, err := flags.loadTree(ctx, treePath)
+	switch {
+	case err == nil:
+		if filepath.Clean(current.RootPath) != absDirectory {
+			return fmt.Errorf("%s is a tree of %s, not %s", fs.Arg(1), current.RootPath, absDirectory)
+		}
+		if current.MetadataOnly {
+			return fmt.Errorf("%s records no content hashes; watch a generated tree", fs.Arg(1))
+		}
+		slog.Info("Loaded saved tree", "path", treePath, "root", current.Root.Hash)
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, objstore.ErrNotFound):
+		slog.Info("Scanning directory", "path", absDirectory)
+		scan, err := scanWith(ctx, absDirectory, func(ctx context.Context) (*walker.WalkResult, error) {
+			walkResult, err := walker.WalkWithOptions(ctx, absDirectory, watchWalkOptions(cfg, treePath))
+			if err != nil {
+				return nil, fmt.Errorf("failed to walk directory: %w", err)
+			}
+			return walkResult, nil
+		}, cfg, flags)
+		if err != nil {
+			return err
+		}
+		reportErrors(scan.Hash.Errors)
+		current = scan.Tree
+		current.Errors = scanErrors(scan.Hash.Errors)
+		if err := flags.saveTree(ctx, current, treePath); err != nil {
+			return fmt.Errorf("failed to save tree: %w", err)
+		}
+		slog.Info("Saved initial tree", "path", treePath, "root", current.Root.Hash)
+	default:
+		return fmt.Errorf("failed to load tree: %w", err)
+	}
+
+	// Hash changed files the way the tree was built
+	cfg.Portable = current.Portable
+	cfg.BytewiseOrder = current.BytewiseOrder
+	cfg.HashAlgorithm = current.HashAlgorithm
+	cfg.HashAlgorithms = hashAlgorithmsOf(current)
+	cfg.EmptyDirs = current.EmptyDirs
+	cfg.DescendArchives = current.Archives
+	cfg.SegmentSize = current.SegmentSize
+	cfg.Fingerprint = current.Fingerprint
+	cfg.ChunkSize = current.ChunkSize
+	cfg.CIDs = current.CIDs
+
+	slog.Info("Watching directory", "path", absDirectory, "debounce", opts.Debounce, "batch", opts.MaxBatch)
+	stopNotify := systemd.Start(ctx, fmt.Sprintf("Watching %s, root %s", absDirectory, current.Root.Hash))
+	defer stopNotify()
+	err = watch.Batch(ctx, events, opts, func(paths []string) error {
+		slog.Info("Rescanning after changes", "paths", len(paths))
+		next, err := rescanChanged(ctx, current, absDirectory, treePath, cfg, flags)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			slog.Error("Rescan failed; waiting for further changes", "error", err)
+			systemd.SetStatus("Rescan failed: %v", err)
+			return nil
+		}
+		result := compare.Compare(current, next)
+		if result.Count() == 0 && len(next.Errors) == len(current.Errors) {
+			slog.Info("No changes")
+			return nil
+		}
+		if result.Count() > 0 {
+			fmt.Println(compare.FormatReport(result))
+		}
+		if err := flags.saveTree(ctx, next, treePath); err != nil {
+			return fmt.Errorf("failed to save tree: %w", err)
+		}
+		slog.Info("Saved tree", "path", treePath, "root", next.Root.Hash, "changes", result.Count())
+		systemd.SetStatus("Watching %s, root %s", absDirectory, next.Root.Hash)
+		current = next
+		return nil
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// rescanChanged walks absDirectory and rebuilds its tree, hashing only the
+// files that are new or whose size or modification time differ from
+// current. Virtual files of current are kept.
+func rescanChanged(ctx context.Context, current *tree.MerkleTree, absDirectory, treePath string, cfg *config.Config,
+	flags *commonFlags) (*tree.MerkleTree, error) {
+	walkResult, err := walker.WalkWithOptions(ctx, absDirectory, watchWalkOptions(cfg, treePath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory: %w", err)
+	}
+	walked := make(map[string]tree.FileData, len(walkResult.Files))
+	for _, fileInfo := range walkResult.Files {
+		walked[fileInfo.Path] = tree.FileData{Size: fileInfo.Size, ModTime: fileInfo.ModTime}
+	}
+	if cfg.EmptyDirs {
+		for _, dir := range walkResult.EmptyDirs {
+			walked[dir.Path] = emptyDirData(dir)
+		}
+	}
+
+	plan := compare.NewPlan(current, walked)
+	hashWalk := &walker.WalkResult{
+		Files:     make([]walker.FileInfo, 0, len(plan.Hash)),
+		Errors:    walkResult.Errors,
+		EmptyDirs: walkResult.EmptyDirs,
+	}
+	for _, fileInfo := range walkResult.Files {
+		if _, reused := plan.Reuse[fileInfo.Path]; !reused {
+			hashWalk.Files = append(hashWalk.Files, fileInfo)
+		}
+	}
+	known := plan.Reuse
+	for path, data := range current.Files {
+		if _, onDisk := walked[path]; data.Virtual && !onDisk {
+			known[path] = data
+		}
+	}
+
+	scan, err := hashAndBuild(ctx, absDirectory, hashWalk, cfg, flags, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := rebuildWith(scan, known, absDirectory, cfg); err != nil {
+		return nil, err
+	}
+	next := scan.Tree
+	next.Volume = current.Volume
+	next.Errors = scanErrors(scan.Hash.Errors)
+	return next, nil
+}
+
+// watchWalkOptions are the walk options of cfg, leaving out the tree file
+// in case it is kept in the watched directory
+func watchWalkOptions(cfg *config.Config, treePath string) walker.WalkOptions {
+	opts := walkOptions(cfg)
+	opts.Filter = func(path string, _ fs.FileInfo) bool {
+		return !isTreeFile(path, treePath)
+	}
+	return opts
+}
+
+// isTreeFile reports whether path is the tree file or one of the temporary
+// files it is saved through
+func isTreeFile(path, treePath string) bool {
+	return path == treePath || (filepath.Dir(path) == filepath.Dir(treePath) &&
+		strings.HasPrefix(filepath.Base(path), filepath.Base(treePath)+"."))
+}
+
+// watchIgnored reports whether a change at path cannot affect the tree:
+// changes of the tree file, and of paths the skip patterns and skip_regex
+// rules leave out
+func watchIgnored(path, absDirectory, treePath string, skip []string, regexps *pathmatch.Regexps) bool {
+	if isTreeFile(path, treePath) {
+		return true
+	}
+	rel, err := filepath.Rel(absDirectory, path)
+	if err != nil || rel == "." {
+		return false
+	}
+	return walker.Excluded(rel, skip, regexps)
+}
@@ -0,0 +1,155 @@
+// Package anomaly keeps the change counts of past scans and decides whether
+// the count of a new scan is unusual enough to alert on, so that scheduled
+// scans of busy directories only notify when something out of the ordinary
+// happened ("more than 500 files changed overnight").
+package anomaly
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// DefaultMinHistory is the number of earlier scans a percentile rule needs
+// before it applies
+const DefaultMinHistory = 10
+
+// Record is the change count of one scan of a directory
+type Record struct {
+	Time    time.Time `json:"time"`
+	Root    string    `json:"root"`
+	Changes int       `json:"changes"`
+}
+
+// DefaultPath is history.jsonl in the merkle-go directory of the user's
+// cache directory
+func DefaultPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find cache directory: %w", err)
+	}
+	return filepath.Join(dir, "merkle-go", "history.jsonl"), nil
+}
+
+// Load returns the change counts recorded for root in the history file at
+// path, oldest first. A missing file has no history.
+func Load(path, root string) ([]int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+
+	var counts []int
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("invalid history %s line %d: %w", path, line, err)
+		}
+		if record.Root == root {
+			counts = append(counts, record.Changes)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return counts, nil
+}
+
+// Append adds record to the history file at path, creating it if needed
+func Append(path string, record Record) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode history record: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	_, err = f.Write(append(data, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return nil
+}
+
+// Percentile returns the p-th percentile (0-100) of counts, interpolating
+// linearly between the closest ranks
+func Percentile(counts []int, p float64) float64 {
+	if len(counts) == 0 {
+		return 0
+	}
+	sorted := slices.Sorted(slices.Values(counts))
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return float64(sorted[lower]) + (rank-float64(lower))*float64(sorted[upper]-sorted[lower])
+}
+
+// Rule decides which change counts are unusual. A zero Threshold or
+// Percentile disables that check.
+type Rule struct {
+	// Threshold alerts on scans with more changes than this
+	Threshold int
+
+	// Percentile alerts on scans with more changes than this percentile
+	// of the earlier scans, once MinHistory of them are recorded (0 means
+	// DefaultMinHistory)
+	Percentile float64
+	MinHistory int
+}
+
+// Enabled reports whether r limits alerts at all; without a rule every
+// change is alerted on
+func (r Rule) Enabled() bool {
+	return r.Threshold > 0 || r.Percentile > 0
+}
+
+// Check reports whether a scan with changes changed files is unusual given
+// the counts of earlier scans, and if so why. Scans without changes never
+// are. While a percentile rule lacks history and no threshold is set, every
+// change is alerted on.
+func (r Rule) Check(changes int, history []int) (bool, string) {
+	if changes == 0 {
+		return false, ""
+	}
+	if !r.Enabled() {
+		return true, "changes detected"
+	}
+	if r.Threshold > 0 && changes > r.Threshold {
+		return true, fmt.Sprintf("%d changes exceed the threshold of %d", changes, r.Threshold)
+	}
+	if r.Percentile <= 0 {
+		return false, ""
+	}
+	minHistory := r.MinHistory
+	if minHistory <= 0 {
+		minHistory = DefaultMinHistory
+	}
+	if len(history) < minHistory {
+		if r.Threshold > 0 {
+			return false, ""
+		}
+		return true, fmt.Sprintf("%d changes; only %d of %d scans recorded to learn from", changes, len(history), minHistory)
+	}
+	if limit := Percentile(history, r.Percentile); float64(changes) > limit {
+		return true, fmt.Sprintf("%d changes exceed the %gth percentile of %d earlier scans (%g)", changes, r.Percentile, len(history), limit)
+	}
+	return false, ""
+}
@@ -0,0 +1,84 @@
+package anomaly
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	counts := []int{40, 10, 30, 20, 50}
+	for _, tc := range []struct {
+		p    float64
+		want float64
+	}{{0, 10}, {50, 30}, {90, 46}, {100, 50}} {
+		if got := Percentile(counts, tc.p); got != tc.want {
+			t.Errorf("Percentile(%g) = %g, want %g", tc.p, got, tc.want)
+		}
+	}
+	if got := Percentile(nil, 95); got != 0 {
+		t.Errorf("Expected 0 for no counts, got %g", got)
+	}
+}
+
+func TestRuleCheck(t *testing.T) {
+	history := []int{3, 5, 4, 6, 2, 5, 4, 3, 6, 5}
+
+	if alert, _ := (Rule{}).Check(1, nil); !alert {
+		t.Error("Expected every change to alert without rules")
+	}
+	if alert, _ := (Rule{Threshold: 1}).Check(0, nil); alert {
+		t.Error("Expected no alert without changes")
+	}
+
+	threshold := Rule{Threshold: 500}
+	if alert, _ := threshold.Check(500, nil); alert {
+		t.Error("Expected 500 changes not to exceed a threshold of 500")
+	}
+	if alert, reason := threshold.Check(501, nil); !alert || !strings.Contains(reason, "threshold of 500") {
+		t.Errorf("Expected 501 changes to exceed the threshold, got %v %q", alert, reason)
+	}
+
+	percentile := Rule{Percentile: 90}
+	if alert, _ := percentile.Check(6, history); alert {
+		t.Error("Expected a usual change count not to alert")
+	}
+	if alert, reason := percentile.Check(40, history); !alert || !strings.Contains(reason, "90th percentile of 10 earlier scans") {
+		t.Errorf("Expected an unusual change count to alert, got %v %q", alert, reason)
+	}
+
+	// Until enough scans are recorded every change alerts, unless a
+	// threshold decides instead
+	if alert, _ := percentile.Check(1, history[:3]); !alert {
+		t.Error("Expected changes to alert while learning")
+	}
+	if alert, _ := (Rule{Percentile: 90, Threshold: 100}).Check(40, history[:3]); alert {
+		t.Error("Expected the threshold to decide while learning")
+	}
+	if alert, _ := (Rule{Percentile: 90, MinHistory: 3}).Check(6, history[:3]); !alert {
+		t.Error("Expected the percentile to apply once MinHistory scans are recorded")
+	}
+}
+
+func TestLoadAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "merkle-go", "history.jsonl")
+	if counts, err := Load(path, "/data"); err != nil || counts != nil {
+		t.Fatalf("Expected no history before the first scan, got %v (%v)", counts, err)
+	}
+
+	now := time.Now()
+	for _, record := range []Record{{now, "/data", 3}, {now, "/other", 100}, {now, "/data", 0}} {
+		if err := Append(path, record); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	counts, err := Load(path, "/data")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !slices.Equal(counts, []int{3, 0}) {
+		t.Errorf("Expected the counts of /data in order, got %v", counts)
+	}
+}
@@ -0,0 +1,246 @@
+// Package archive hashes the members of tar and zip files so that an
+// archive can be recorded in a tree as a virtual directory, one leaf per
+// member, instead of a single opaque file.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"merkle-go/internal/hash"
+	"merkle-go/internal/longpath"
+)
+
+// Archive formats that can be descended into
+const (
+	Tar = "tar" // .tar, .tar.gz and .tgz
+	Zip = "zip" // .zip
+)
+
+// Separator ends the archive part of a member's leaf path: the member
+// docs/a.txt of backup.zip is recorded as backup.zip!/docs/a.txt
+const Separator = "!"
+
+// Member is a regular file inside an archive
+type Member struct {
+	Name    string // slash-separated path inside the archive
+	Size    int64
+	ModTime time.Time
+	Hash    string
+}
+
+// ParseKinds parses a comma-separated list of archive formats
+func ParseKinds(list string) ([]string, error) {
+	var kinds []string
+	for _, kind := range strings.Split(list, ",") {
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		switch kind {
+		case "":
+			continue
+		case Tar, Zip:
+			kinds = append(kinds, kind)
+		default:
+			return nil, fmt.Errorf("unknown archive format %q (want tar or zip)", kind)
+		}
+	}
+	return kinds, nil
+}
+
+// Kind returns the format of the archive at path, judged by its file name,
+// if it is one of kinds, or "" otherwise
+func Kind(path string, kinds []string) string {
+	name := strings.ToLower(filepath.Base(path))
+	var kind string
+	switch {
+	case strings.HasSuffix(name, ".tar"), strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		kind = Tar
+	case strings.HasSuffix(name, ".zip"):
+		kind = Zip
+	default:
+		return ""
+	}
+	for _, k := range kinds {
+		if k == kind {
+			return kind
+		}
+	}
+	return ""
+}
+
+// MemberPath returns the leaf path of a member of the archive at
+// archivePath, e.g. /data/backup.zip!/docs/a.txt
+func MemberPath(archivePath, member string) string {
+	return archivePath + Separator + string(filepath.Separator) + filepath.FromSlash(member)
+}
+
+// SplitMemberPath splits a leaf path built by MemberPath into the archive
+// path and the slash-separated member name. ok is false for ordinary paths.
+func SplitMemberPath(leafPath string) (archivePath, member string, ok bool) {
+	i := strings.Index(leafPath, Separator+string(filepath.Separator))
+	if i < 0 {
+		return "", "", false
+	}
+	return leafPath[:i], filepath.ToSlash(leafPath[i+len(Separator)+1:]), true
+}
+
+// HashMembers hashes every regular file in the archive at path with the
+// named algorithm, reading the archive once. Members are returned sorted
+// by name; if a tar archive holds a name more than once, the last copy
+// wins, as it would on extraction.
+func HashMembers(path, kind, algorithm string) ([]Member, error) {
+	file, err := longpath.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer file.Close()
+	return HashMembersOf(file, kind, algorithm)
+}
+
+// HashMembersOf is HashMembers for an archive already open as file, such as
+// one opened through an os.Root
+func HashMembersOf(file *os.File, kind, algorithm string) ([]Member, error) {
+	if _, err := hash.New(algorithm); err != nil {
+		return nil, err
+	}
+	var members map[string]Member
+	var err error
+	switch kind {
+	case Tar:
+		members, err = hashTar(file, algorithm)
+	case Zip:
+		members, err = hashZip(file, algorithm)
+	default:
+		return nil, fmt.Errorf("unknown archive format %q", kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	sorted := make([]Member, 0, len(members))
+	for _, member := range members {
+		sorted = append(sorted, member)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted, nil
+}
+
+func hashTar(r io.Reader, algorithm string) (map[string]Member, error) {
+	members := make(map[string]Member)
+	err := ReadTar(r, func(header *tar.Header, content io.Reader) error {
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
+		name, ok := CleanName(header.Name)
+		if !ok {
+			return nil
+		}
+		digest, err := HashReader(content, algorithm)
+		if err != nil {
+			return fmt.Errorf("%s: %w", header.Name, err)
+		}
+		members[name] = Member{Name: name, Size: header.Size, ModTime: header.ModTime, Hash: digest}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// ReadTar calls fn with each entry of the tar stream r, which may be gzip
+// compressed; compression is detected from the content, not a file name.
+// content is only valid until fn returns.
+func ReadTar(r io.Reader, fn func(header *tar.Header, content io.Reader) error) error {
+	br := bufio.NewReader(r)
+	var tarStream io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		tarStream = gz
+	}
+
+	tr := tar.NewReader(tarStream)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func hashZip(file *os.File, algorithm string) (map[string]Member, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string]Member, len(zr.File))
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		name, ok := CleanName(f.Name)
+		if !ok {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		digest, err := HashReader(rc, algorithm)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		members[name] = Member{Name: name, Size: int64(f.UncompressedSize64), ModTime: f.Modified, Hash: digest}
+	}
+	return members, nil
+}
+
+// CleanName cleans a member name into a relative slash path. ok is false
+// for names that refer to the archive root itself.
+func CleanName(name string) (string, bool) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" || name == "." {
+		return "", false
+	}
+	return name, true
+}
+
+// HashReader returns the hex digest of the content of r with the named
+// algorithm
+func HashReader(r io.Reader, algorithm string) (string, error) {
+	h, err := hash.New(algorithm)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
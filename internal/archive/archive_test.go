@@ -0,0 +1,150 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"merkle-go/internal/hash"
+)
+
+func writeTar(t *testing.T, path string, gz bool, files map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"./dir/a.txt", "b.txt", "dir/a.txt"} {
+		content, ok := files[name]
+		if !ok {
+			continue
+		}
+		hdr := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content)), ModTime: time.Unix(1700000000, 0)}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+
+	data := buf.Bytes()
+	if gz {
+		var zbuf bytes.Buffer
+		zw := gzip.NewWriter(&zbuf)
+		zw.Write(data)
+		zw.Close()
+		data = zbuf.Bytes()
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func digest(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "f")
+	os.WriteFile(path, []byte(content), 0644)
+	d, err := hash.HashFileWith(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestHashMembersTar(t *testing.T) {
+	for _, gz := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "layer.tar")
+		writeTar(t, path, gz, map[string]string{"./dir/a.txt": "old", "b.txt": "bee", "dir/a.txt": "new"})
+
+		members, err := HashMembers(path, Tar, "")
+		if err != nil {
+			t.Fatalf("gzip=%v: HashMembers() error = %v", gz, err)
+		}
+		if len(members) != 2 {
+			t.Fatalf("gzip=%v: got %d members, want 2: %+v", gz, len(members), members)
+		}
+		// A repeated name keeps the last copy, as extraction would
+		if members[0].Name != "b.txt" || members[1].Name != "dir/a.txt" {
+			t.Errorf("gzip=%v: names = %s, %s", gz, members[0].Name, members[1].Name)
+		}
+		if members[1].Hash != digest(t, "new") || members[1].Size != 3 {
+			t.Errorf("gzip=%v: dir/a.txt = %+v, want hash of extracted content", gz, members[1])
+		}
+	}
+}
+
+func TestHashMembersZip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.zip")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(file)
+	zw.Create("docs/")
+	w, _ := zw.Create("docs/readme.md")
+	w.Write([]byte("hello"))
+	w, _ = zw.Create("../escape.txt")
+	w.Write([]byte("x"))
+	zw.Close()
+	file.Close()
+
+	members, err := HashMembers(path, Zip, hash.SHA256)
+	if err != nil {
+		t.Fatalf("HashMembers() error = %v", err)
+	}
+	if len(members) != 2 || members[0].Name != "docs/readme.md" || members[1].Name != "escape.txt" {
+		t.Fatalf("members = %+v", members)
+	}
+	if members[0].Size != 5 || len(members[0].Hash) != 64 {
+		t.Errorf("docs/readme.md = %+v", members[0])
+	}
+}
+
+func TestHashMembersCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.zip")
+	os.WriteFile(path, []byte("not a zip"), 0644)
+	if _, err := HashMembers(path, Zip, ""); err == nil {
+		t.Error("HashMembers() of a corrupt archive should fail")
+	}
+}
+
+func TestKindAndMemberPath(t *testing.T) {
+	kinds := []string{Tar}
+	for path, want := range map[string]string{
+		"a/layer.tar":    Tar,
+		"a/LAYER.TGZ":    Tar,
+		"a/x.tar.gz":     Tar,
+		"a/backup.zip":   "",
+		"a/notes.txt":    "",
+		"a/tarball.txtz": "",
+	} {
+		if got := Kind(path, kinds); got != want {
+			t.Errorf("Kind(%q) = %q, want %q", path, got, want)
+		}
+	}
+
+	leaf := MemberPath(filepath.Join("root", "x.tar"), "dir/a.txt")
+	if filepath.ToSlash(leaf) != "root/x.tar!/dir/a.txt" {
+		t.Errorf("MemberPath() = %q", leaf)
+	}
+	archivePath, member, ok := SplitMemberPath(leaf)
+	if !ok || archivePath != filepath.Join("root", "x.tar") || member != "dir/a.txt" {
+		t.Errorf("SplitMemberPath() = %q, %q, %v", archivePath, member, ok)
+	}
+	if _, _, ok := SplitMemberPath(filepath.Join("root", "plain.txt")); ok {
+		t.Error("SplitMemberPath() of an ordinary path should not split")
+	}
+
+	if _, err := ParseKinds("tar, zip"); err != nil {
+		t.Errorf("ParseKinds() error = %v", err)
+	}
+	if _, err := ParseKinds("rar"); err == nil {
+		t.Error("ParseKinds(rar) should fail")
+	}
+}
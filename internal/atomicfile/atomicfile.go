@@ -0,0 +1,62 @@
+// Package atomicfile replaces files through a synced temporary file next to
+// them and a rename, so readers see the old content or the new one, never a
+// partly written file.
+package atomicfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile replaces path with data
+func WriteFile(path string, data []byte) error {
+	tmpPath, err := CreateTemp(path, func(f *os.File) error {
+		_, err := f.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	SyncDir(filepath.Dir(path))
+	return nil
+}
+
+// CreateTemp creates a temporary file next to path, fills it with write and
+// syncs it. The caller renames it over path, or removes it.
+func CreateTemp(path string, write func(f *os.File) error) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpPath := f.Name()
+
+	err = write(f)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	return tmpPath, nil
+}
+
+// SyncDir flushes a directory entry change to disk. Not every platform
+// supports syncing directories, so failures are ignored.
+func SyncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,208 @@
+// Package attest describes a scan as an in-toto statement, so that the root
+// hash and manifest of a directory can be fed to supply-chain tooling and
+// signed like any other attestation.
+package attest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"merkle-go/internal/tree"
+	"merkle-go/internal/version"
+)
+
+const (
+	// StatementType is the in-toto statement version written
+	StatementType = "https://in-toto.io/Statement/v1"
+
+	// PredicateType identifies the merkle-go tree predicate
+	PredicateType = "https://github.com/gittycat/merkle-go/attestation/tree/v1"
+
+	// RootDigest is the digest set key of the directory subject: the
+	// merkle root, whose internal nodes are always xxh64
+	RootDigest = "merkle-go-xxh64"
+
+	// BundleSuffix is appended to the statement path for the Sigstore
+	// bundle written by Sign
+	BundleSuffix = ".sigstore.json"
+)
+
+// Statement is an in-toto v1 statement about a scanned directory
+type Statement struct {
+	Type          string    `json:"_type"`
+	Subject       []Subject `json:"subject"`
+	PredicateType string    `json:"predicateType"`
+	Predicate     Predicate `json:"predicate"`
+}
+
+// Subject is an artifact the statement is about
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Predicate summarizes the scan
+type Predicate struct {
+	Generator     string    `json:"generator"`
+	Version       string    `json:"version"`
+	Created       time.Time `json:"created"`
+	RootPath      string    `json:"root_path"`
+	Root          string    `json:"root"`
+	HashAlgorithm string    `json:"hash_algorithm"`
+	Files         int64     `json:"files"`
+	TotalSize     int64     `json:"total_size"`
+	Errors        int       `json:"errors"`
+	Portable      bool      `json:"portable,omitempty"`
+	Manifest      string    `json:"manifest,omitempty"` // file name of the manifest subject
+}
+
+// New returns a statement about the directory t was scanned from, holding
+// files files. If manifestPath is set, the saved manifest is a second
+// subject, identified by its SHA-256, so the statement vouches for the
+// whole file list and not just the root hash.
+func New(t *tree.MerkleTree, files int64, manifestPath string) (*Statement, error) {
+	algorithm := t.HashAlgorithm
+	if algorithm == "" {
+		algorithm = "xxh64"
+	}
+	statement := &Statement{
+		Type: StatementType,
+		Subject: []Subject{{
+			Name:   t.RootPath,
+			Digest: map[string]string{RootDigest: t.Root.Hash},
+		}},
+		PredicateType: PredicateType,
+		Predicate: Predicate{
+			Generator:     "merkle-go",
+			Version:       version.String(),
+			Created:       time.Now().UTC(),
+			RootPath:      t.RootPath,
+			Root:          t.Root.Hash,
+			HashAlgorithm: algorithm,
+			Files:         files,
+			TotalSize:     t.TotalSize,
+			Errors:        len(t.Errors),
+			Portable:      t.Portable,
+		},
+	}
+
+	if manifestPath != "" {
+		digest, err := sha256File(manifestPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash manifest: %w", err)
+		}
+		name := filepath.Base(manifestPath)
+		statement.Subject = append(statement.Subject, Subject{Name: name, Digest: map[string]string{"sha256": digest}})
+		statement.Predicate.Manifest = name
+	}
+	return statement, nil
+}
+
+// Write saves the statement as indented JSON
+func Write(statement *Statement, path string) error {
+	data, err := json.MarshalIndent(statement, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode statement: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write statement: %w", err)
+	}
+	return nil
+}
+
+// Sign signs the statement file at path with Sigstore keyless signing by
+// running cosign sign-blob, which obtains a certificate for the caller's
+// OIDC identity (interactively, or from the CI environment) and records
+// the signature in the transparency log. The bundle is written next to
+// the statement and its path returned; verify it with cosign verify-blob.
+func Sign(ctx context.Context, path string) (string, error) {
+	bundle := path + BundleSuffix
+	cmd := exec.CommandContext(ctx, "cosign", "sign-blob", "--yes", "--bundle", bundle, path)
+	var stderr bytes.Buffer
+	cmd.Stdout = io.Discard
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("cosign sign-blob failed: %s", strings.TrimSpace(stderr.String()))
+		}
+		return "", fmt.Errorf("failed to run cosign: %w", err)
+	}
+	return bundle, nil
+}
+
+// Parse decodes a statement written by Write
+func Parse(data []byte) (*Statement, error) {
+	var statement Statement
+	if err := json.Unmarshal(data, &statement); err != nil {
+		return nil, fmt.Errorf("failed to decode statement: %w", err)
+	}
+	if statement.Type != StatementType || statement.PredicateType != PredicateType {
+		return nil, fmt.Errorf("not a merkle-go statement: %s %s", statement.Type, statement.PredicateType)
+	}
+	return &statement, nil
+}
+
+// Covers checks that the statement vouches for the given merkle root and,
+// if manifestSHA256 is set, for the manifest with that digest
+func (s *Statement) Covers(root, manifestSHA256 string) error {
+	var rootFound, manifestFound bool
+	for _, subject := range s.Subject {
+		if subject.Digest[RootDigest] == root {
+			rootFound = true
+		}
+		if manifestSHA256 != "" && subject.Digest["sha256"] == manifestSHA256 {
+			manifestFound = true
+		}
+	}
+	if !rootFound {
+		return fmt.Errorf("statement does not cover root %s", root)
+	}
+	if manifestSHA256 != "" && !manifestFound {
+		return fmt.Errorf("statement does not cover manifest with SHA-256 %s", manifestSHA256)
+	}
+	return nil
+}
+
+// Verify checks the Sigstore bundle written by Sign for the statement at
+// path by running cosign verify-blob. The signing certificate must have
+// been issued to identity by the OIDC issuer.
+func Verify(ctx context.Context, path, bundle, identity, issuer string) error {
+	cmd := exec.CommandContext(ctx, "cosign", "verify-blob", "--bundle", bundle,
+		"--certificate-identity", identity, "--certificate-oidc-issuer", issuer, path)
+	var stderr bytes.Buffer
+	cmd.Stdout = io.Discard
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("cosign verify-blob failed: %s", strings.TrimSpace(stderr.String()))
+		}
+		return fmt.Errorf("failed to run cosign: %w", err)
+	}
+	return nil
+}
+
+func sha256File(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,116 @@
+package attest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"merkle-go/internal/tree"
+)
+
+func TestNewAndWrite(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]tree.FileData{
+		filepath.Join(dir, "a.txt"): {Hash: "aaaaaaaaaaaaaaaa", Size: 3},
+		filepath.Join(dir, "b.txt"): {Hash: "bbbbbbbbbbbbbbbb", Size: 4},
+	}
+	merkleTree, err := tree.Build(files, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestPath := filepath.Join(t.TempDir(), "tree.json")
+	if err := tree.Save(merkleTree, manifestPath); err != nil {
+		t.Fatal(err)
+	}
+
+	statement, err := New(merkleTree, int64(len(files)), manifestPath)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "statement.json")
+	if err := Write(statement, path); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	var decoded map[string]any
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["_type"] != StatementType || decoded["predicateType"] != PredicateType {
+		t.Errorf("unexpected statement header: %v", decoded)
+	}
+
+	if len(statement.Subject) != 2 {
+		t.Fatalf("got %d subjects, want directory and manifest", len(statement.Subject))
+	}
+	if got := statement.Subject[0].Digest[RootDigest]; got != merkleTree.Root.Hash {
+		t.Errorf("directory digest = %s, want root %s", got, merkleTree.Root.Hash)
+	}
+	manifest, _ := os.ReadFile(manifestPath)
+	sum := sha256.Sum256(manifest)
+	if got := statement.Subject[1].Digest["sha256"]; got != hex.EncodeToString(sum[:]) {
+		t.Errorf("manifest digest = %s", got)
+	}
+
+	predicate := statement.Predicate
+	if predicate.Files != 2 || predicate.TotalSize != 7 || predicate.HashAlgorithm != "xxh64" || predicate.Manifest != "tree.json" {
+		t.Errorf("unexpected predicate: %+v", predicate)
+	}
+}
+
+func TestNewWithoutManifest(t *testing.T) {
+	merkleTree, err := tree.Build(map[string]tree.FileData{"/d/a": {Hash: "aaaaaaaaaaaaaaaa", Size: 1}}, "/d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	statement, err := New(merkleTree, 1, "")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if len(statement.Subject) != 1 || statement.Predicate.Manifest != "" {
+		t.Errorf("expected only the directory subject, got %+v", statement.Subject)
+	}
+
+	if _, err := New(merkleTree, 1, filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("New() with a missing manifest should fail")
+	}
+}
+
+func TestParseAndCovers(t *testing.T) {
+	merkleTree, err := tree.Build(map[string]tree.FileData{"/d/a": {Hash: "aaaaaaaaaaaaaaaa", Size: 1}}, "/d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestPath := filepath.Join(t.TempDir(), "tree.json")
+	if err := tree.Save(merkleTree, manifestPath); err != nil {
+		t.Fatal(err)
+	}
+	statement, err := New(merkleTree, 1, manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(statement)
+
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	manifestDigest := statement.Subject[1].Digest["sha256"]
+	if err := parsed.Covers(merkleTree.Root.Hash, manifestDigest); err != nil {
+		t.Errorf("Covers() error = %v", err)
+	}
+	if err := parsed.Covers("0000000000000000", manifestDigest); err == nil {
+		t.Error("Covers() should reject a different root")
+	}
+	if err := parsed.Covers(merkleTree.Root.Hash, "ffff"); err == nil {
+		t.Error("Covers() should reject a different manifest")
+	}
+
+	if _, err := Parse([]byte(`{"_type": "https://in-toto.io/Statement/v1", "predicateType": "other"}`)); err == nil {
+		t.Error("Parse() should reject other predicates")
+	}
+}
@@ -0,0 +1,297 @@
+// Package bundle packs a manifest together with everything needed to verify
+// it later (the config it was generated with, the tool version and an
+// optional signed attestation) into a single tar archive.
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Format identifies the bundle layout in the metadata
+const Format = "merkle-go-bundle/v1"
+
+// Names of the bundle members
+const (
+	MetadataName    = "bundle.json"
+	ManifestName    = "manifest.json"
+	ConfigName      = "config.toml"
+	AttestationName = "attestation.json"
+	SignatureName   = "attestation.json.sigstore.json"
+)
+
+// maxMemberSize bounds what Read loads into memory per member
+const maxMemberSize = 4 << 30
+
+// Metadata describes the bundle and the tree it holds
+type Metadata struct {
+	Format         string    `json:"format"`
+	Version        string    `json:"version"` // merkle-go version that wrote the bundle
+	Created        time.Time `json:"created"`
+	Root           string    `json:"root"`
+	HashAlgorithm  string    `json:"hash_algorithm"`
+	Files          int       `json:"files"`
+	ManifestSHA256 string    `json:"manifest_sha256"`
+	Members        []string  `json:"members"`
+}
+
+// Bundle is the content of a bundle archive. Config, Attestation and
+// Signature are empty if they were not included.
+type Bundle struct {
+	Metadata    Metadata
+	Manifest    []byte
+	Config      []byte
+	Attestation []byte
+	Signature   []byte
+}
+
+// members returns the bundle's files in archive order, metadata excluded
+func (b *Bundle) members() []struct {
+	name string
+	data []byte
+} {
+	all := []struct {
+		name string
+		data []byte
+	}{
+		{ManifestName, b.Manifest},
+		{ConfigName, b.Config},
+		{AttestationName, b.Attestation},
+		{SignatureName, b.Signature},
+	}
+	var present []struct {
+		name string
+		data []byte
+	}
+	for _, member := range all {
+		if len(member.data) > 0 {
+			present = append(present, member)
+		}
+	}
+	return present
+}
+
+// ManifestDigest returns the hex SHA-256 of a manifest
+func ManifestDigest(manifest []byte) string {
+	sum := sha256.Sum256(manifest)
+	return hex.EncodeToString(sum[:])
+}
+
+// Write saves b to path, compressed according to the extension: .tar.zst
+// (or .tzst) with zstd, .tar.gz (or .tgz) with gzip, anything else as a
+// plain tar. Metadata.Format, ManifestSHA256 and Members are filled in.
+func Write(path string, b *Bundle) error {
+	if len(b.Manifest) == 0 {
+		return errors.New("bundle has no manifest")
+	}
+	if len(b.Signature) > 0 && len(b.Attestation) == 0 {
+		return errors.New("bundle has a signature but no attestation")
+	}
+	b.Metadata.Format = Format
+	b.Metadata.ManifestSHA256 = ManifestDigest(b.Manifest)
+	b.Metadata.Members = nil
+	for _, member := range b.members() {
+		b.Metadata.Members = append(b.Metadata.Members, member.name)
+	}
+	metadata, err := json.MarshalIndent(b.Metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle metadata: %w", err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer f.Close()
+
+	var compressed io.WriteCloser
+	switch compression(path) {
+	case "zstd":
+		compressed, err = zstd.NewWriter(f)
+		if err != nil {
+			return err
+		}
+	case "gzip":
+		compressed = gzip.NewWriter(f)
+	default:
+		compressed = nopWriteCloser{f}
+	}
+
+	tw := tar.NewWriter(compressed)
+	modTime := b.Metadata.Created
+	write := func(name string, data []byte) error {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := write(MetadataName, append(metadata, '\n')); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	for _, member := range b.members() {
+		if err := write(member.name, member.data); err != nil {
+			return fmt.Errorf("failed to write bundle: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := compressed.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return nil
+}
+
+// Read loads a bundle written by Write, detecting the compression from its
+// content, and checks that it is complete and that the manifest matches the
+// digest in the metadata
+func Read(path string) (*Bundle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer f.Close()
+
+	r, err := decompress(f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	b := &Bundle{}
+	var metadata []byte
+	targets := map[string]*[]byte{
+		MetadataName:    &metadata,
+		ManifestName:    &b.Manifest,
+		ConfigName:      &b.Config,
+		AttestationName: &b.Attestation,
+		SignatureName:   &b.Signature,
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		target, ok := targets[header.Name]
+		if !ok || header.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("unexpected bundle member %q", header.Name)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxMemberSize))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		*target = data
+	}
+
+	if metadata == nil {
+		return nil, fmt.Errorf("not a merkle-go bundle: no %s", MetadataName)
+	}
+	if err := json.Unmarshal(metadata, &b.Metadata); err != nil {
+		return nil, fmt.Errorf("invalid bundle metadata: %w", err)
+	}
+	if b.Metadata.Format != Format {
+		return nil, fmt.Errorf("unsupported bundle format %q", b.Metadata.Format)
+	}
+	for _, name := range b.Metadata.Members {
+		if data, ok := targets[name]; !ok || len(*data) == 0 {
+			return nil, fmt.Errorf("bundle is missing %s", name)
+		}
+	}
+	if digest := ManifestDigest(b.Manifest); digest != b.Metadata.ManifestSHA256 {
+		return nil, fmt.Errorf("bundle manifest has SHA-256 %s, metadata records %s", digest, b.Metadata.ManifestSHA256)
+	}
+	return b, nil
+}
+
+// compression picks the compression of a bundle from its file name
+func compression(path string) string {
+	name := strings.ToLower(filepath.Base(path))
+	switch {
+	case strings.HasSuffix(name, ".zst"), strings.HasSuffix(name, ".tzst"):
+		return "zstd"
+	case strings.HasSuffix(name, ".gz"), strings.HasSuffix(name, ".tgz"):
+		return "gzip"
+	}
+	return ""
+}
+
+// Magic numbers of the supported compressions
+var (
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// decompress wraps r in the decompressor its first bytes call for
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	buffered := &peekReader{r: r}
+	head, err := buffered.peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	switch {
+	case bytes.HasPrefix(head, zstdMagic):
+		zr, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+	case bytes.HasPrefix(head, gzipMagic):
+		gr, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		return gr, nil
+	}
+	return io.NopCloser(buffered), nil
+}
+
+// peekReader lets decompress look at the first bytes of a stream
+type peekReader struct {
+	r    io.Reader
+	head []byte
+}
+
+func (p *peekReader) peek(n int) ([]byte, error) {
+	p.head = make([]byte, n)
+	read, err := io.ReadFull(p.r, p.head)
+	p.head = p.head[:read]
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+	}
+	return p.head, err
+}
+
+func (p *peekReader) Read(buf []byte) (int, error) {
+	if len(p.head) > 0 {
+		n := copy(buf, p.head)
+		p.head = p.head[n:]
+		return n, nil
+	}
+	return p.r.Read(buf)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,75 @@
+package bundle
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteRead(t *testing.T) {
+	for _, name := range []string{"bundle.tar.zst", "bundle.tar.gz", "bundle.tar"} {
+		t.Run(name, func(t *testing.T) {
+			original := &Bundle{
+				Metadata: Metadata{Version: "v1.2.3", Created: time.Unix(1700000000, 0).UTC(), Root: "aaaaaaaaaaaaaaaa", HashAlgorithm: "xxh64", Files: 2},
+				Manifest: []byte(`{"generator": "merkle-go"}`),
+				Config:   []byte("skip = [\"*.tmp\"]\n"),
+			}
+			path := filepath.Join(t.TempDir(), name)
+			if err := Write(path, original); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+
+			loaded, err := Read(path)
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if string(loaded.Manifest) != string(original.Manifest) || string(loaded.Config) != string(original.Config) {
+				t.Errorf("members differ: %+v", loaded)
+			}
+			if loaded.Attestation != nil || loaded.Signature != nil {
+				t.Errorf("expected no attestation, got %+v", loaded)
+			}
+			if loaded.Metadata.Format != Format || loaded.Metadata.Root != "aaaaaaaaaaaaaaaa" || loaded.Metadata.ManifestSHA256 != ManifestDigest(original.Manifest) {
+				t.Errorf("unexpected metadata: %+v", loaded.Metadata)
+			}
+			if got := strings.Join(loaded.Metadata.Members, ","); got != "manifest.json,config.toml" {
+				t.Errorf("members = %s", got)
+			}
+		})
+	}
+}
+
+func TestWrite_Validation(t *testing.T) {
+	dir := t.TempDir()
+	if err := Write(filepath.Join(dir, "a.tar"), &Bundle{}); err == nil {
+		t.Error("Write() without a manifest should fail")
+	}
+	if err := Write(filepath.Join(dir, "b.tar"), &Bundle{Manifest: []byte("{}"), Signature: []byte("{}")}); err == nil {
+		t.Error("Write() with a signature but no attestation should fail")
+	}
+}
+
+func TestRead_Rejects(t *testing.T) {
+	dir := t.TempDir()
+
+	notTar := filepath.Join(dir, "manifest.json")
+	os.WriteFile(notTar, []byte(`{"generator": "merkle-go"}`), 0644)
+	if _, err := Read(notTar); err == nil {
+		t.Error("Read() should reject a file that is not a bundle")
+	}
+
+	// A manifest that does not match the recorded digest is tampered with
+	path := filepath.Join(dir, "bundle.tar")
+	b := &Bundle{Manifest: []byte(`{"generator": "merkle-go"}`)}
+	if err := Write(path, b); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	tampered := strings.Replace(string(data), `"generator": "merkle-go"`, `"generator": "merkle-gx"`, 1)
+	os.WriteFile(path, []byte(tampered), 0644)
+	if _, err := Read(path); err == nil || !strings.Contains(err.Error(), "SHA-256") {
+		t.Errorf("Read() should detect a modified manifest, got %v", err)
+	}
+}
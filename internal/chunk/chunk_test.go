@@ -0,0 +1,217 @@
+package chunk
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func randomData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func chunkAll(t *testing.T, data []byte, opts Options) [][]byte {
+	t.Helper()
+	chunker, err := NewChunker(bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatalf("NewChunker failed: %v", err)
+	}
+
+	var chunks [][]byte
+	for {
+		c, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		chunks = append(chunks, append([]byte(nil), c.Data...))
+	}
+	return chunks
+}
+
+func TestChunker_ReassemblesInput(t *testing.T) {
+	data := randomData(1, 1024*1024)
+	opts := DefaultOptions()
+
+	chunks := chunkAll(t, data, opts)
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("Chunks should concatenate to the original input")
+	}
+
+	for i, c := range chunks {
+		if len(c) > opts.MaxSize {
+			t.Errorf("Chunk %d is %d bytes, larger than max %d", i, len(c), opts.MaxSize)
+		}
+		if i < len(chunks)-1 && len(c) < opts.MinSize {
+			t.Errorf("Chunk %d is %d bytes, smaller than min %d", i, len(c), opts.MinSize)
+		}
+	}
+
+	avg := len(data) / len(chunks)
+	if avg < opts.AvgSize/2 || avg > opts.AvgSize*2 {
+		t.Errorf("Average chunk size %d is far from target %d", avg, opts.AvgSize)
+	}
+}
+
+func TestChunker_ShiftResistant(t *testing.T) {
+	data := randomData(2, 512*1024)
+	shifted := append([]byte("inserted prefix"), data...)
+
+	original := make(map[string]bool)
+	for _, c := range chunkAll(t, data, DefaultOptions()) {
+		original[string(c)] = true
+	}
+
+	shared := 0
+	chunks := chunkAll(t, shifted, DefaultOptions())
+	for _, c := range chunks {
+		if original[string(c)] {
+			shared++
+		}
+	}
+
+	// Only the chunks around the insertion should differ
+	if shared < len(chunks)-3 {
+		t.Errorf("Expected boundaries to resynchronise after an insertion, only %d/%d chunks shared", shared, len(chunks))
+	}
+}
+
+func TestOptions_Validate(t *testing.T) {
+	if err := (Options{MinSize: 1024, AvgSize: 3000, MaxSize: 8192}).Validate(); err == nil {
+		t.Error("Non power of two average should be rejected")
+	}
+	if err := (Options{MinSize: 8192, AvgSize: 4096, MaxSize: 16384}).Validate(); err == nil {
+		t.Error("Min larger than average should be rejected")
+	}
+}
+
+func TestAnalyzer_Report(t *testing.T) {
+	analyzer, err := NewAnalyzer(DefaultOptions())
+	if err != nil {
+		t.Fatalf("NewAnalyzer failed: %v", err)
+	}
+
+	shared := randomData(3, 256*1024)
+	unique := randomData(4, 64*1024)
+
+	inputs := map[string][]byte{
+		"a.bin": shared,
+		"b.bin": shared,
+		"c.bin": append(append([]byte(nil), shared...), unique...),
+	}
+	for path, data := range inputs {
+		if err := analyzer.AddReader(path, bytes.NewReader(data)); err != nil {
+			t.Fatalf("AddReader failed: %v", err)
+		}
+	}
+
+	report := analyzer.Report()
+	if report.Files != 3 {
+		t.Errorf("Expected 3 files, got %d", report.Files)
+	}
+	if report.TotalBytes != int64(3*len(shared)+len(unique)) {
+		t.Errorf("Unexpected total bytes %d", report.TotalBytes)
+	}
+
+	// Only roughly one copy of shared plus unique should remain
+	maxUnique := int64(len(shared)+len(unique)) + 2*DefaultMaxSize
+	if report.UniqueBytes > maxUnique {
+		t.Errorf("Expected at most %d unique bytes, got %d", maxUnique, report.UniqueBytes)
+	}
+
+	if len(report.DuplicateGroups) != 1 {
+		t.Fatalf("Expected 1 duplicate group, got %d", len(report.DuplicateGroups))
+	}
+	group := report.DuplicateGroups[0]
+	if len(group.Paths) != 2 || group.Paths[0] != "a.bin" || group.Paths[1] != "b.bin" {
+		t.Errorf("Unexpected duplicate group %v", group.Paths)
+	}
+	if report.DuplicateFileBytes() != int64(len(shared)) {
+		t.Errorf("Expected %d duplicate file bytes, got %d", len(shared), report.DuplicateFileBytes())
+	}
+}
+
+func TestList(t *testing.T) {
+	data := randomData(3, 256*1024)
+	opts := ForAverage(4096)
+
+	chunks, err := List(bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	var total int64
+	for _, c := range chunks {
+		total += c.Size
+		if len(c.Hash) != 16 {
+			t.Errorf("Expected a 16 digit hex hash, got %q", c.Hash)
+		}
+	}
+	if total != int64(len(data)) || len(chunks) != len(chunkAll(t, data, opts)) {
+		t.Errorf("Expected chunks covering %d bytes, got %d chunks covering %d", len(data), len(chunks), total)
+	}
+
+	// Editing the middle only changes the chunks around the edit
+	edited := append([]byte(nil), data...)
+	copy(edited[128*1024:], "edited")
+	editedChunks, err := List(bytes.NewReader(edited), opts)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	same := 0
+	for i := range min(len(chunks), len(editedChunks)) {
+		if chunks[i] == editedChunks[i] {
+			same++
+		}
+	}
+	if same < len(chunks)-2 {
+		t.Errorf("Expected all but the edited chunk to match, %d/%d do", same, len(chunks))
+	}
+}
+
+func TestWriter(t *testing.T) {
+	data := randomData(4, 256*1024+17)
+	opts := ForAverage(4096)
+	want, err := List(bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+
+	w, err := NewWriter(opts)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), 10007)
+		if _, err := w.Write(rest[:n]); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		rest = rest[n:]
+	}
+	got, err := w.Chunks()
+	if err != nil {
+		t.Fatalf("Chunks failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d chunks, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Chunk %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+
+	aborted, err := NewWriter(opts)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	aborted.Write(data[:1000])
+	aborted.Abort()
+	if _, err := aborted.Write(data); err == nil {
+		t.Error("Expected writes to an aborted writer to fail")
+	}
+}
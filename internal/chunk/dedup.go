@@ -0,0 +1,173 @@
+package chunk
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// chunkKey identifies chunk content; the length is included to make
+// collisions between differently sized chunks impossible
+type chunkKey struct {
+	hash uint64
+	size uint32
+}
+
+// Analyzer accumulates chunk-level and whole-file duplicate statistics
+// across files. It is safe for concurrent use.
+type Analyzer struct {
+	opts Options
+
+	mu          sync.Mutex
+	chunks      map[chunkKey]struct{}
+	files       map[string][]string // file hash -> paths
+	fileSizes   map[string]int64    // file hash -> size
+	fileCount   int
+	totalBytes  int64
+	uniqueBytes int64
+	totalChunks int
+}
+
+// NewAnalyzer returns an analyzer that chunks files with opts
+func NewAnalyzer(opts Options) (*Analyzer, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return &Analyzer{
+		opts:      opts,
+		chunks:    make(map[chunkKey]struct{}),
+		files:     make(map[string][]string),
+		fileSizes: make(map[string]int64),
+	}, nil
+}
+
+// AddFile chunks the file at path and records its statistics
+func (a *Analyzer) AddFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	return a.AddReader(path, f)
+}
+
+// AddReader chunks the content of r and records it under path
+func (a *Analyzer) AddReader(path string, r io.Reader) error {
+	chunker, err := NewChunker(r, a.opts)
+	if err != nil {
+		return err
+	}
+
+	fileHash := xxhash.New()
+	var keys []chunkKey
+	var size int64
+	for {
+		c, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+		fileHash.Write(c.Data)
+		keys = append(keys, chunkKey{hash: xxhash.Sum64(c.Data), size: uint32(len(c.Data))})
+		size += int64(len(c.Data))
+	}
+	digest := hex.EncodeToString(fileHash.Sum(nil))
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.fileCount++
+	a.totalBytes += size
+	a.totalChunks += len(keys)
+	for _, key := range keys {
+		if _, seen := a.chunks[key]; !seen {
+			a.chunks[key] = struct{}{}
+			a.uniqueBytes += int64(key.size)
+		}
+	}
+	a.files[digest] = append(a.files[digest], path)
+	a.fileSizes[digest] = size
+	return nil
+}
+
+// DuplicateGroup is a set of files with identical content
+type DuplicateGroup struct {
+	Hash  string
+	Size  int64
+	Paths []string
+}
+
+// WastedBytes is the space taken by all but one copy of the content
+func (g DuplicateGroup) WastedBytes() int64 {
+	return g.Size * int64(len(g.Paths)-1)
+}
+
+// Report summarizes the data added to an analyzer
+type Report struct {
+	Files        int
+	TotalBytes   int64
+	UniqueBytes  int64 // Bytes left after chunk-level deduplication
+	TotalChunks  int
+	UniqueChunks int
+
+	// Groups of identical files, largest waste first
+	DuplicateGroups []DuplicateGroup
+}
+
+// DedupableBytes is the number of bytes chunk-level deduplication would save
+func (r *Report) DedupableBytes() int64 {
+	return r.TotalBytes - r.UniqueBytes
+}
+
+// DuplicateFileBytes is the number of bytes taken by duplicate whole files
+func (r *Report) DuplicateFileBytes() int64 {
+	var wasted int64
+	for _, group := range r.DuplicateGroups {
+		wasted += group.WastedBytes()
+	}
+	return wasted
+}
+
+// Report returns the statistics collected so far
+func (a *Analyzer) Report() *Report {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	report := &Report{
+		Files:        a.fileCount,
+		TotalBytes:   a.totalBytes,
+		UniqueBytes:  a.uniqueBytes,
+		TotalChunks:  a.totalChunks,
+		UniqueChunks: len(a.chunks),
+	}
+
+	for digest, paths := range a.files {
+		if len(paths) < 2 {
+			continue
+		}
+		sorted := append([]string(nil), paths...)
+		sort.Strings(sorted)
+		report.DuplicateGroups = append(report.DuplicateGroups, DuplicateGroup{
+			Hash:  digest,
+			Size:  a.fileSizes[digest],
+			Paths: sorted,
+		})
+	}
+
+	sort.Slice(report.DuplicateGroups, func(i, j int) bool {
+		gi, gj := report.DuplicateGroups[i], report.DuplicateGroups[j]
+		if gi.WastedBytes() != gj.WastedBytes() {
+			return gi.WastedBytes() > gj.WastedBytes()
+		}
+		return gi.Paths[0] < gj.Paths[0]
+	})
+
+	return report
+}
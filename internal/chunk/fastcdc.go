@@ -0,0 +1,184 @@
+package chunk
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Default chunk sizes, following the FastCDC paper's 8KB average
+const (
+	DefaultMinSize = 2 * 1024
+	DefaultAvgSize = 8 * 1024
+	DefaultMaxSize = 64 * 1024
+)
+
+// Options configures the chunk size bounds. AvgSize must be a power of two.
+type Options struct {
+	MinSize int
+	AvgSize int
+	MaxSize int
+}
+
+// DefaultOptions returns the default chunk size bounds
+func DefaultOptions() Options {
+	return Options{MinSize: DefaultMinSize, AvgSize: DefaultAvgSize, MaxSize: DefaultMaxSize}
+}
+
+// ForAverage returns bounds around an average chunk size of avg bytes: a
+// quarter of it at least and eight times it at most
+func ForAverage(avg int) Options {
+	return Options{MinSize: avg / 4, AvgSize: avg, MaxSize: avg * 8}
+}
+
+// Validate checks that the bounds are usable
+func (o Options) Validate() error {
+	if o.AvgSize <= 0 || o.AvgSize&(o.AvgSize-1) != 0 {
+		return fmt.Errorf("average chunk size %d must be a power of two", o.AvgSize)
+	}
+	if o.MinSize <= 0 || o.MinSize > o.AvgSize || o.AvgSize > o.MaxSize {
+		return fmt.Errorf("chunk sizes must satisfy 0 < min (%d) <= avg (%d) <= max (%d)",
+			o.MinSize, o.AvgSize, o.MaxSize)
+	}
+	return nil
+}
+
+// gear is the table of random values mixed in per input byte. It is derived
+// from a fixed seed so chunk boundaries are stable across runs and builds.
+var gear [256]uint64
+
+func init() {
+	state := uint64(0x6d65726b6c65676f) // "merklego"
+	for i := range gear {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// Chunker splits a stream into content-defined chunks using FastCDC with
+// normalized chunking: a stricter mask before the average size and a looser
+// one after it, which keeps chunk sizes close to the average.
+type Chunker struct {
+	reader io.Reader
+	opts   Options
+	maskS  uint64
+	maskL  uint64
+
+	buf    []byte
+	start  int // start of unconsumed data in buf
+	end    int // end of valid data in buf
+	eof    bool
+	offset int64
+}
+
+// Chunk is a span of the input. Data is only valid until the next call to
+// Next.
+type Chunk struct {
+	Offset int64
+	Data   []byte
+}
+
+// NewChunker returns a chunker reading from r
+func NewChunker(r io.Reader, opts Options) (*Chunker, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	avgBits := bits.TrailingZeros(uint(opts.AvgSize))
+	return &Chunker{
+		reader: r,
+		opts:   opts,
+		maskS:  topBits(avgBits + 1),
+		maskL:  topBits(avgBits - 1),
+		buf:    make([]byte, opts.MaxSize*2),
+	}, nil
+}
+
+// topBits returns a mask of the n most significant bits. The gear hash
+// shifts left, so its high bits depend on the widest window of input.
+func topBits(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	return ^uint64(0) << (64 - n)
+}
+
+// Next returns the next chunk, or io.EOF when the input is exhausted
+func (c *Chunker) Next() (Chunk, error) {
+	if err := c.fill(); err != nil {
+		return Chunk{}, err
+	}
+	if c.start == c.end {
+		return Chunk{}, io.EOF
+	}
+
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := Chunk{Offset: c.offset, Data: c.buf[c.start : c.start+n]}
+	c.start += n
+	c.offset += int64(n)
+	return chunk, nil
+}
+
+// fill tops up the buffer so that at least MaxSize bytes are available, or
+// everything up to EOF
+func (c *Chunker) fill() error {
+	if c.eof || c.end-c.start >= c.opts.MaxSize {
+		return nil
+	}
+
+	// Move the remaining data to the front of the buffer
+	copy(c.buf, c.buf[c.start:c.end])
+	c.end -= c.start
+	c.start = 0
+
+	for c.end < len(c.buf) {
+		n, err := c.reader.Read(c.buf[c.end:])
+		c.end += n
+		if errors.Is(err, io.EOF) {
+			c.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if c.end-c.start >= c.opts.MaxSize {
+			return nil
+		}
+	}
+	return nil
+}
+
+// cut returns the length of the chunk at the start of data
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.opts.MinSize {
+		return n
+	}
+	if n > c.opts.MaxSize {
+		n = c.opts.MaxSize
+	}
+	normal := c.opts.AvgSize
+	if n < normal {
+		normal = n
+	}
+
+	var fp uint64
+	i := c.opts.MinSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,92 @@
+package chunk
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cespare/xxhash/v2"
+
+	"merkle-go/internal/tree"
+)
+
+// List splits the content of r into chunks and returns the xxh64 hash and
+// length of each, as recorded in trees
+func List(r io.Reader, opts Options) ([]tree.Chunk, error) {
+	chunker, err := NewChunker(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	var chunks []tree.Chunk
+	var sum [8]byte
+	for {
+		c, err := chunker.Next()
+		if err == io.EOF {
+			return chunks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint64(sum[:], xxhash.Sum64(c.Data))
+		chunks = append(chunks, tree.Chunk{Hash: hex.EncodeToString(sum[:]), Size: int64(len(c.Data))})
+	}
+}
+
+// Writer lists the chunks of the content written to it, for callers that
+// push content, such as a hash reading a file, rather than hand out a
+// reader. The chunker runs in a goroutine of its own, fed through a pipe.
+type Writer struct {
+	pw     *io.PipeWriter
+	done   chan struct{}
+	chunks []tree.Chunk
+	err    error
+}
+
+// errAborted ends the content of an aborted Writer
+var errAborted = errors.New("chunking aborted")
+
+// NewWriter returns a Writer chunking with opts
+func NewWriter(opts Options) (*Writer, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	w := &Writer{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		w.chunks, w.err = List(pr, opts)
+		// Writes after a failure return at once instead of blocking
+		pr.CloseWithError(w.err)
+	}()
+	return w, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Chunks ends the content and returns its chunks
+func (w *Writer) Chunks() ([]tree.Chunk, error) {
+	w.pw.Close()
+	<-w.done
+	return w.chunks, w.err
+}
+
+// Abort discards the content and stops the chunker
+func (w *Writer) Abort() {
+	w.pw.CloseWithError(errAborted)
+	<-w.done
+}
+
+// ListFile is List for the file at path
+func ListFile(path string, opts Options) ([]tree.Chunk, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	return List(f, opts)
+}
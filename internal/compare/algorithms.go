@@ -0,0 +1,50 @@
+package compare
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"merkle-go/internal/tree"
+)
+
+// SharedAlgorithm returns the first of a's hash algorithms (its leaf
+// algorithm, then its other digests) that b records digests of too, so
+// that trees hashed with different leaf algorithms, one of them recording
+// the other's digests, can still be compared file by file
+func SharedAlgorithm(a, b *tree.MerkleTree) (string, error) {
+	for _, algorithm := range a.Algorithms() {
+		if records(b, algorithm) {
+			return algorithm, nil
+		}
+	}
+	return "", fmt.Errorf("the trees share no hash algorithm (%s vs %s)",
+		strings.Join(a.Algorithms(), ", "), strings.Join(b.Algorithms(), ", "))
+}
+
+// records reports whether t records file digests of algorithm
+func records(t *tree.MerkleTree, algorithm string) bool {
+	return slices.ContainsFunc(t.Algorithms(), func(other string) bool {
+		return strings.EqualFold(algorithm, other)
+	})
+}
+
+// onAlgorithm returns t with the hash of each file replaced by its digest
+// with algorithm, or t itself if that is its leaf algorithm. A file
+// without such a digest keeps its hash.
+func onAlgorithm(t *tree.MerkleTree, algorithm string) *tree.MerkleTree {
+	if strings.EqualFold(algorithm, t.LeafAlgorithm()) {
+		return t
+	}
+	files := make(map[string]tree.FileData, len(t.Files))
+	for path, data := range t.Files {
+		if digest, ok := t.Digest(data, algorithm); ok {
+			data.Hash = digest
+		}
+		files[path] = data
+	}
+	projected := *t
+	projected.Files = files
+	projected.HashAlgorithm = algorithm
+	return &projected
+}
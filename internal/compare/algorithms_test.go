@@ -0,0 +1,40 @@
+package compare
+
+import (
+	"testing"
+
+	"merkle-go/internal/tree"
+)
+
+func TestCompare_SharedAlgorithm(t *testing.T) {
+	// An xxh64 tree recording sha256 digests, and a sha256 tree
+	fast, _ := tree.BuildWithOptions(map[string]tree.FileData{
+		"/data/same":    {Hash: "1111", Digests: map[string]string{"sha256": "s-same"}},
+		"/data/changed": {Hash: "2222", Digests: map[string]string{"sha256": "s-old"}},
+	}, "/data", tree.BuildOptions{DigestAlgorithms: []string{"sha256"}})
+	audit, _ := tree.BuildWithOptions(map[string]tree.FileData{
+		"/data/same":    {Hash: "s-same"},
+		"/data/changed": {Hash: "s-new"},
+	}, "/data", tree.BuildOptions{HashAlgorithm: "sha256"})
+
+	if algorithm, err := SharedAlgorithm(fast, audit); err != nil || algorithm != "sha256" {
+		t.Fatalf("Expected sha256 to be shared, got %q (%v)", algorithm, err)
+	}
+	result := Compare(fast, audit)
+	if len(result.Modified) != 1 || result.Modified[0].Path != "/data/changed" || result.Modified[0].OldData.Hash != "s-old" {
+		t.Errorf("Expected only changed to be modified, by its sha256 digests, got %+v", result.Modified)
+	}
+
+	many, err := CompareMany([]string{"fast", "audit"}, []*tree.MerkleTree{fast, audit})
+	if err != nil || many.Identical != 1 || len(many.Differing) != 1 {
+		t.Errorf("Expected the trees to differ in one file, got %+v (%v)", many, err)
+	}
+
+	md5Tree, _ := tree.BuildWithOptions(nil, "/data", tree.BuildOptions{HashAlgorithm: "md5"})
+	if _, err := SharedAlgorithm(fast, md5Tree); err == nil {
+		t.Error("Expected trees without a common algorithm to be refused")
+	}
+	if _, err := CompareMany([]string{"fast", "md5"}, []*tree.MerkleTree{fast, md5Tree}); err == nil {
+		t.Error("Expected CompareMany to refuse trees without a common algorithm")
+	}
+}
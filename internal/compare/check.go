@@ -0,0 +1,130 @@
+package compare
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sort"
+
+	"merkle-go/internal/tree"
+)
+
+// CheckResult is the outcome of rechecking the files listed in a manifest
+type CheckResult struct {
+	Checked   int
+	Corrupted []Change // content changed but size and modification time did not
+	Modified  []Change // content changed along with size or modification time
+	Missing   []Change
+}
+
+func (r *CheckResult) HasProblems() bool {
+	return len(r.Corrupted) > 0 || len(r.Modified) > 0 || len(r.Missing) > 0
+}
+
+// Count returns the total number of problem files
+func (r *CheckResult) Count() int {
+	return len(r.Corrupted) + len(r.Modified) + len(r.Missing)
+}
+
+// Check compares the rehashed files in current against the manifest. Files
+// whose hash changed while their size and modification time stayed the same
+// are reported as corrupted, since a normal edit would have touched the
+// metadata. Paths in missing are reported as missing; manifest files that
+// are in neither (for example because they could not be read) are skipped.
+func Check(manifest *tree.MerkleTree, current map[string]tree.FileData, missing []string) *CheckResult {
+	result := &CheckResult{
+		Corrupted: make([]Change, 0),
+		Modified:  make([]Change, 0),
+		Missing:   make([]Change, 0),
+	}
+
+	for _, path := range sortedPaths(current) {
+		oldData, exists := manifest.Files[path]
+		if !exists {
+			continue
+		}
+		result.Checked++
+		newData := current[path]
+		if oldData.Hash == newData.Hash {
+			continue
+		}
+
+		change := Change{Type: Modified, Path: path, OldData: &oldData, NewData: &newData}
+		// Manifests store modification times with one-second precision
+		if oldData.Size == newData.Size && oldData.ModTime.Unix() == newData.ModTime.Unix() {
+			result.Corrupted = append(result.Corrupted, change)
+		} else {
+			result.Modified = append(result.Modified, change)
+		}
+	}
+
+	sort.Strings(missing)
+	for _, path := range missing {
+		oldData, exists := manifest.Files[path]
+		if !exists {
+			continue
+		}
+		result.Checked++
+		result.Missing = append(result.Missing, Change{Type: Deleted, Path: path, OldData: &oldData})
+	}
+
+	return result
+}
+
+// Sample returns a random selection of fraction of paths, rounded up so a
+// positive fraction picks at least one, in sorted order. The same seed picks
+// the same paths from the same list, so a sampled check can be repeated.
+func Sample(paths []string, fraction float64, seed uint64) []string {
+	n := min(int(math.Ceil(fraction*float64(len(paths)))), len(paths))
+	if n <= 0 {
+		return []string{}
+	}
+	shuffled := slices.Clone(paths)
+	rng := rand.New(rand.NewPCG(seed, 0))
+	// A partial Fisher-Yates shuffle: only the first n positions are drawn
+	for i := range n {
+		j := i + rng.IntN(len(shuffled)-i)
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	}
+	sample := shuffled[:n]
+	sort.Strings(sample)
+	return sample
+}
+
+func FormatCheckReport(result *CheckResult) string {
+	if !result.HasProblems() {
+		return fmt.Sprintf("All %d files match the manifest.", result.Checked)
+	}
+
+	report := "Problems found:\n\n"
+
+	if len(result.Corrupted) > 0 {
+		report += fmt.Sprintf("CORRUPTED (%d files):\n", len(result.Corrupted))
+		for _, change := range result.Corrupted {
+			report += FormatChange(change)
+		}
+		report += "\n"
+	}
+
+	if len(result.Modified) > 0 {
+		report += fmt.Sprintf("MODIFIED (%d files):\n", len(result.Modified))
+		for _, change := range result.Modified {
+			report += FormatChange(change)
+		}
+		report += "\n"
+	}
+
+	if len(result.Missing) > 0 {
+		report += fmt.Sprintf("MISSING (%d files):\n", len(result.Missing))
+		for _, change := range result.Missing {
+			report += FormatChange(change)
+		}
+		report += "\n"
+	}
+
+	report += fmt.Sprintf("Summary: %d checked, %d corrupted, %d modified, %d missing\n",
+		result.Checked, len(result.Corrupted), len(result.Modified), len(result.Missing))
+
+	return report
+}
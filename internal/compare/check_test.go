@@ -0,0 +1,84 @@
+package compare
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"merkle-go/internal/tree"
+)
+
+func TestCheck(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	manifest := &tree.MerkleTree{
+		RootPath: "/data",
+		Files: map[string]tree.FileData{
+			"/data/ok.txt":         {Hash: "h1", Size: 1, ModTime: mtime},
+			"/data/rotted.txt":     {Hash: "h2", Size: 2, ModTime: mtime},
+			"/data/edited.txt":     {Hash: "h3", Size: 3, ModTime: mtime},
+			"/data/gone.txt":       {Hash: "h4", Size: 4, ModTime: mtime},
+			"/data/unreadable.txt": {Hash: "h5", Size: 5, ModTime: mtime},
+		},
+	}
+	current := map[string]tree.FileData{
+		"/data/ok.txt":     {Hash: "h1", Size: 1, ModTime: mtime},
+		"/data/rotted.txt": {Hash: "bad", Size: 2, ModTime: mtime.Add(500 * time.Millisecond)},
+		"/data/edited.txt": {Hash: "new", Size: 3, ModTime: mtime.Add(time.Hour)},
+	}
+
+	result := Check(manifest, current, []string{"/data/gone.txt"})
+
+	if result.Checked != 4 {
+		t.Errorf("Expected 4 checked files, got %d", result.Checked)
+	}
+	if len(result.Corrupted) != 1 || result.Corrupted[0].Path != "/data/rotted.txt" {
+		t.Errorf("Expected rotted.txt to be corrupted, got %v", result.Corrupted)
+	}
+	if len(result.Modified) != 1 || result.Modified[0].Path != "/data/edited.txt" {
+		t.Errorf("Expected edited.txt to be modified, got %v", result.Modified)
+	}
+	if len(result.Missing) != 1 || result.Missing[0].Path != "/data/gone.txt" {
+		t.Errorf("Expected gone.txt to be missing, got %v", result.Missing)
+	}
+}
+
+func TestCheck_AllMatch(t *testing.T) {
+	manifest := &tree.MerkleTree{
+		Files: map[string]tree.FileData{"/data/ok.txt": {Hash: "h1", Size: 1}},
+	}
+	result := Check(manifest, map[string]tree.FileData{"/data/ok.txt": {Hash: "h1", Size: 1}}, nil)
+	if result.HasProblems() {
+		t.Errorf("Expected no problems, got %+v", result)
+	}
+	if report := FormatCheckReport(result); report != "All 1 files match the manifest." {
+		t.Errorf("Unexpected report: %q", report)
+	}
+}
+
+func TestSample(t *testing.T) {
+	paths := make([]string, 200)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("/data/%03d", i)
+	}
+
+	sample := Sample(paths, 0.05, 42)
+	if len(sample) != 10 || !slices.IsSorted(sample) {
+		t.Fatalf("Expected 10 sorted paths, got %v", sample)
+	}
+	if again := Sample(paths, 0.05, 42); !slices.Equal(again, sample) {
+		t.Errorf("Same seed should pick the same paths, got %v and %v", sample, again)
+	}
+	if other := Sample(paths, 0.05, 43); slices.Equal(other, sample) {
+		t.Errorf("Different seeds picked the same paths %v", sample)
+	}
+	if !slices.Equal(paths, Sample(paths, 1, 7)) {
+		t.Error("A full sample should return every path")
+	}
+	if got := Sample(paths[:3], 0.01, 1); len(got) != 1 {
+		t.Errorf("A positive fraction should pick at least one path, got %v", got)
+	}
+	if got := Sample(paths, 0, 1); len(got) != 0 {
+		t.Errorf("A zero fraction should pick nothing, got %v", got)
+	}
+}
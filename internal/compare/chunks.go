@@ -0,0 +1,42 @@
+package compare
+
+import (
+	"fmt"
+
+	"merkle-go/internal/tree"
+)
+
+// ChunkDelta tells how much of a modified file changed, from the chunk
+// hashes recorded for both versions: how many of the new version's chunks
+// do not occur in the old one, and their total size, which is roughly what
+// a chunk-based backup or transfer tool would have to send
+type ChunkDelta struct {
+	Chunks  int   // chunks in the new version
+	Changed int   // of those, chunks not found in the old version
+	Bytes   int64 // size of the changed chunks
+}
+
+// NewChunkDelta compares the chunks of two versions of a file. It returns
+// nil unless both have chunks recorded.
+func NewChunkDelta(oldChunks, newChunks []tree.Chunk) *ChunkDelta {
+	if len(oldChunks) == 0 || len(newChunks) == 0 {
+		return nil
+	}
+	known := make(map[tree.Chunk]bool, len(oldChunks))
+	for _, c := range oldChunks {
+		known[c] = true
+	}
+	delta := &ChunkDelta{Chunks: len(newChunks)}
+	for _, c := range newChunks {
+		if !known[c] {
+			delta.Changed++
+			delta.Bytes += c.Size
+		}
+	}
+	return delta
+}
+
+// String renders the delta as it appears in reports
+func (d *ChunkDelta) String() string {
+	return fmt.Sprintf("%d of %d chunks changed, ~%s", d.Changed, d.Chunks, tree.FormatSize(d.Bytes))
+}
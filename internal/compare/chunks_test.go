@@ -0,0 +1,42 @@
+package compare
+
+import (
+	"strings"
+	"testing"
+
+	"merkle-go/internal/tree"
+)
+
+func TestCompare_ChunkDelta(t *testing.T) {
+	oldChunks := []tree.Chunk{{Hash: "a", Size: 100}, {Hash: "b", Size: 200}, {Hash: "c", Size: 300}}
+	newChunks := []tree.Chunk{{Hash: "a", Size: 100}, {Hash: "x", Size: 250}, {Hash: "c", Size: 300}}
+	oldTree := &tree.MerkleTree{RootPath: "/d", ChunkSize: 8192, Files: map[string]tree.FileData{
+		"/d/big":   {Hash: "h1", Size: 600, Chunks: oldChunks},
+		"/d/plain": {Hash: "h2", Size: 1},
+	}}
+	newTree := &tree.MerkleTree{RootPath: "/d", ChunkSize: 8192, Files: map[string]tree.FileData{
+		"/d/big":   {Hash: "h1-new", Size: 650, Chunks: newChunks},
+		"/d/plain": {Hash: "h2-new", Size: 1},
+	}}
+
+	result := Compare(oldTree, newTree)
+	if len(result.Modified) != 2 {
+		t.Fatalf("Expected 2 modified files, got %v", result.Modified)
+	}
+	delta := result.Modified[0].Delta
+	if delta == nil || *delta != (ChunkDelta{Chunks: 3, Changed: 1, Bytes: 250}) {
+		t.Errorf("Unexpected delta for big: %+v", delta)
+	}
+	if result.Modified[1].Delta != nil {
+		t.Errorf("Expected no delta without chunks, got %+v", result.Modified[1].Delta)
+	}
+	if got := FormatChange(result.Modified[0]); !strings.Contains(got, "    Delta: 1 of 3 chunks changed, ~250 B\n") {
+		t.Errorf("Expected the delta in the report, got:\n%s", got)
+	}
+
+	// Chunks cut with a different average size do not line up
+	newTree.ChunkSize = 4096
+	if delta := Compare(oldTree, newTree).Modified[0].Delta; delta != nil {
+		t.Errorf("Expected no delta across chunk sizes, got %+v", delta)
+	}
+}
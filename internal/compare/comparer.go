@@ -1,8 +1,16 @@
 package compare
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"path/filepath"
 	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"merkle-go/internal/tree"
 )
@@ -13,32 +21,113 @@ const (
 	Added    ChangeType = "ADDED"
 	Modified ChangeType = "MODIFIED"
 	Deleted  ChangeType = "DELETED"
+
+	// Renamed marks a file that disappeared from one path and appeared at
+	// another with the same content
+	Renamed ChangeType = "RENAMED"
+
+	// CaseRenamed marks a file whose path only changed in letter case,
+	// with the same content; only reported with Options.CaseInsensitive
+	CaseRenamed ChangeType = "CASE_RENAMED"
+
+	// MetadataOnly marks a file whose content is unchanged but whose
+	// modification time differs; only reported in strict mode
+	MetadataOnly ChangeType = "METADATA_ONLY"
+
+	// Unreadable marks a file of the old tree that the new scan found but
+	// could not read, directly or because its directory could not be read,
+	// as opposed to a file that is gone
+	Unreadable ChangeType = "UNREADABLE"
 )
 
 type Change struct {
 	Type    ChangeType
 	Path    string
+	OldPath string // Renamed, CaseRenamed, or Modified under a new case: where the file was before
 	OldData *tree.FileData
 	NewData *tree.FileData
+
+	// Delta is set for modified files whose chunks both trees recorded
+	Delta *ChunkDelta
+
+	// Error is why an Unreadable file could not be read
+	Error string
 }
 
 type CompareResult struct {
-	Added    []Change
-	Modified []Change
-	Deleted  []Change
+	Added        []Change
+	Modified     []Change
+	Deleted      []Change
+	Renamed      []Change
+	CaseRenamed  []Change // Case-insensitive mode only
+	MetadataOnly []Change // Strict mode only
+
+	// Unreadable files are not changes: whether they changed is unknown
+	Unreadable []Change
 }
 
 func (r *CompareResult) HasChanges() bool {
-	return len(r.Added) > 0 || len(r.Modified) > 0 || len(r.Deleted) > 0
+	return r.Count() > 0
+}
+
+// Count returns the total number of changes
+func (r *CompareResult) Count() int {
+	return len(r.Added) + len(r.Modified) + len(r.Deleted) + len(r.Renamed) + len(r.CaseRenamed) + len(r.MetadataOnly)
+}
+
+// Options tunes what Compare reports
+type Options struct {
+	// Strict also reports files whose hash is unchanged but whose
+	// modification time differs, which can indicate timestomping. By default
+	// such metadata-only differences are ignored.
+	Strict bool
+
+	// NoRenames reports a file that moved as a deletion and an addition
+	// instead of a rename
+	NoRenames bool
+
+	// CaseInsensitive matches paths that only differ in letter case, as
+	// case-insensitive filesystems (macOS, Windows) see them: a file whose
+	// path changed case is reported as CaseRenamed, or as Modified if its
+	// content changed too, instead of as a deletion and an addition
+	CaseInsensitive bool
+}
+
+// metadataChanged reports whether the modification time of a file with
+// unchanged content differs. Times are compared with the one-second
+// precision manifests store, and only when both sides recorded one.
+func metadataChanged(oldData, newData tree.FileData) bool {
+	if oldData.ModTime.IsZero() || newData.ModTime.IsZero() {
+		return false
+	}
+	return oldData.ModTime.Unix() != newData.ModTime.Unix()
 }
 
 func Compare(oldTree, newTree *tree.MerkleTree) *CompareResult {
+	return CompareWithOptions(oldTree, newTree, Options{})
+}
+
+// CompareWithOptions is Compare with options. Trees hashed with different
+// leaf algorithms are compared with the first one they share (see
+// SharedAlgorithm), and the changes carry those digests.
+func CompareWithOptions(oldTree, newTree *tree.MerkleTree, opts Options) *CompareResult {
+	if algorithm, err := SharedAlgorithm(oldTree, newTree); err == nil {
+		oldTree, newTree = onAlgorithm(oldTree, algorithm), onAlgorithm(newTree, algorithm)
+	}
+
 	result := &CompareResult{
-		Added:    make([]Change, 0),
-		Modified: make([]Change, 0),
-		Deleted:  make([]Change, 0),
+		Added:        make([]Change, 0),
+		Modified:     make([]Change, 0),
+		Deleted:      make([]Change, 0),
+		Renamed:      make([]Change, 0),
+		CaseRenamed:  make([]Change, 0),
+		MetadataOnly: make([]Change, 0),
+		Unreadable:   make([]Change, 0),
 	}
 
+	// Chunks can only be matched if both trees cut them the same way
+	chunked := oldTree.ChunkSize > 0 && oldTree.ChunkSize == newTree.ChunkSize
+
 	// Check for added and modified files
 	for path, newData := range newTree.Files {
 		if oldData, exists := oldTree.Files[path]; exists {
@@ -46,11 +135,24 @@ func Compare(oldTree, newTree *tree.MerkleTree) *CompareResult {
 			if oldData.Hash != newData.Hash {
 				oldDataCopy := oldData
 				newDataCopy := newData
-				result.Modified = append(result.Modified, Change{
+				change := Change{
 					Type:    Modified,
 					Path:    path,
 					OldData: &oldDataCopy,
 					NewData: &newDataCopy,
+				}
+				if chunked {
+					change.Delta = NewChunkDelta(oldData.Chunks, newData.Chunks)
+				}
+				result.Modified = append(result.Modified, change)
+			} else if opts.Strict && metadataChanged(oldData, newData) {
+				oldDataCopy := oldData
+				newDataCopy := newData
+				result.MetadataOnly = append(result.MetadataOnly, Change{
+					Type:    MetadataOnly,
+					Path:    path,
+					OldData: &oldDataCopy,
+					NewData: &newDataCopy,
 				})
 			}
 		} else {
@@ -64,10 +166,21 @@ func Compare(oldTree, newTree *tree.MerkleTree) *CompareResult {
 		}
 	}
 
-	// Check for deleted files
+	// Check for deleted files, telling apart those the new scan could not
+	// read
+	readErr := scanErrorLookup(newTree.Errors)
 	for path, oldData := range oldTree.Files {
 		if _, exists := newTree.Files[path]; !exists {
 			oldDataCopy := oldData
+			if reason, ok := readErr(path); ok {
+				result.Unreadable = append(result.Unreadable, Change{
+					Type:    Unreadable,
+					Path:    path,
+					OldData: &oldDataCopy,
+					Error:   reason,
+				})
+				continue
+			}
 			result.Deleted = append(result.Deleted, Change{
 				Type:    Deleted,
 				Path:    path,
@@ -86,49 +199,492 @@ func Compare(oldTree, newTree *tree.MerkleTree) *CompareResult {
 	sort.Slice(result.Deleted, func(i, j int) bool {
 		return result.Deleted[i].Path < result.Deleted[j].Path
 	})
+	sort.Slice(result.MetadataOnly, func(i, j int) bool {
+		return result.MetadataOnly[i].Path < result.MetadataOnly[j].Path
+	})
+	sort.Slice(result.Unreadable, func(i, j int) bool {
+		return result.Unreadable[i].Path < result.Unreadable[j].Path
+	})
 
+	if opts.CaseInsensitive {
+		matchCase(result, chunked)
+	}
+	if !opts.NoRenames {
+		detectRenames(result)
+	}
 	return result
 }
 
-func FormatReport(result *CompareResult) string {
-	if !result.HasChanges() {
-		return "No changes detected."
+// scanErrorLookup returns a function finding the scan error, among errs,
+// that kept the file at path out of a tree: one for the file itself or for
+// the nearest directory above it
+func scanErrorLookup(errs []tree.ScanError) func(path string) (string, bool) {
+	byPath := make(map[string]string, len(errs))
+	for _, scanErr := range errs {
+		byPath[filepath.Clean(scanErr.Path)] = scanErr.Error
+	}
+	return func(path string) (string, bool) {
+		if len(byPath) == 0 {
+			return "", false
+		}
+		for p := filepath.Clean(path); ; {
+			if reason, ok := byPath[p]; ok {
+				return reason, true
+			}
+			parent := filepath.Dir(p)
+			if parent == p {
+				return "", false
+			}
+			p = parent
+		}
+	}
+}
+
+// matchCase pairs each deleted file with an added file whose path only
+// differs in letter case, turning them into a CaseRenamed change if the
+// content is the same and into a Modified change otherwise. Paths are
+// compared with simple Unicode case folding, one letter for one, as
+// case-insensitive filesystems compare names. Added and Deleted must be sorted and
+// stay sorted, as does Modified.
+func matchCase(result *CompareResult, chunked bool) {
+	deleted := make(map[string][]int)
+	for i, change := range result.Deleted {
+		key := foldCase(change.Path)
+		deleted[key] = append(deleted[key], i)
 	}
 
-	report := "Changes detected:\n\n"
+	matched := make(map[int]bool)
+	added := make([]Change, 0, len(result.Added))
+	for _, change := range result.Added {
+		key := foldCase(change.Path)
+		candidates := deleted[key]
+		if len(candidates) == 0 {
+			added = append(added, change)
+			continue
+		}
+		old := result.Deleted[candidates[0]]
+		deleted[key] = candidates[1:]
+		matched[candidates[0]] = true
+
+		paired := Change{Type: CaseRenamed, Path: change.Path, OldPath: old.Path, OldData: old.OldData, NewData: change.NewData}
+		if old.OldData.Hash != change.NewData.Hash {
+			paired.Type = Modified
+			if chunked {
+				paired.Delta = NewChunkDelta(old.OldData.Chunks, change.NewData.Chunks)
+			}
+			result.Modified = append(result.Modified, paired)
+		} else {
+			result.CaseRenamed = append(result.CaseRenamed, paired)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+
+	remaining := make([]Change, 0, len(result.Deleted)-len(matched))
+	for i, change := range result.Deleted {
+		if !matched[i] {
+			remaining = append(remaining, change)
+		}
+	}
+	result.Added = added
+	result.Deleted = remaining
+	sort.Slice(result.Modified, func(i, j int) bool {
+		return result.Modified[i].Path < result.Modified[j].Path
+	})
+}
+
+// renameKey identifies file content for rename detection
+type renameKey struct {
+	hash string
+	size int64
+}
 
-	if len(result.Added) > 0 {
-		report += fmt.Sprintf("ADDED (%d files):\n", len(result.Added))
-		for _, change := range result.Added {
-			report += fmt.Sprintf("  + %s (hash: %s, size: %d bytes)\n",
-				change.Path, change.NewData.Hash, change.NewData.Size)
+// detectRenames turns each deleted file whose hash and size reappear in an
+// added file into a rename. Empty files and directories are left alone, as
+// they all share one hash and a match says nothing about where they went.
+// Files with the same content are paired in path order. Added and Deleted
+// must be sorted; Renamed comes out sorted by new path.
+func detectRenames(result *CompareResult) {
+	deleted := make(map[renameKey][]int)
+	for i, change := range result.Deleted {
+		if change.OldData.Dir || change.OldData.Size == 0 {
+			continue
 		}
-		report += "\n"
+		key := renameKey{change.OldData.Hash, change.OldData.Size}
+		deleted[key] = append(deleted[key], i)
+	}
+	if len(deleted) == 0 {
+		return
 	}
 
-	if len(result.Modified) > 0 {
-		report += fmt.Sprintf("MODIFIED (%d files):\n", len(result.Modified))
-		for _, change := range result.Modified {
-			report += fmt.Sprintf("  ~ %s\n", change.Path)
-			report += fmt.Sprintf("    Old: hash=%s, size=%d bytes, modified=%s\n",
-				change.OldData.Hash, change.OldData.Size, change.OldData.ModTime.Format("2006-01-02"))
-			report += fmt.Sprintf("    New: hash=%s, size=%d bytes, modified=%s\n",
-				change.NewData.Hash, change.NewData.Size, change.NewData.ModTime.Format("2006-01-02"))
+	renamedFrom := make(map[int]bool)
+	added := make([]Change, 0, len(result.Added))
+	for _, change := range result.Added {
+		key := renameKey{change.NewData.Hash, change.NewData.Size}
+		candidates := deleted[key]
+		if change.NewData.Dir || len(candidates) == 0 {
+			added = append(added, change)
+			continue
 		}
-		report += "\n"
+		old := result.Deleted[candidates[0]]
+		deleted[key] = candidates[1:]
+		renamedFrom[candidates[0]] = true
+		result.Renamed = append(result.Renamed, Change{
+			Type:    Renamed,
+			Path:    change.Path,
+			OldPath: old.Path,
+			OldData: old.OldData,
+			NewData: change.NewData,
+		})
+	}
+	if len(renamedFrom) == 0 {
+		return
 	}
 
-	if len(result.Deleted) > 0 {
-		report += fmt.Sprintf("DELETED (%d files):\n", len(result.Deleted))
-		for _, change := range result.Deleted {
-			report += fmt.Sprintf("  - %s (hash: %s, size: %d bytes)\n",
-				change.Path, change.OldData.Hash, change.OldData.Size)
+	remaining := make([]Change, 0, len(result.Deleted)-len(renamedFrom))
+	for i, change := range result.Deleted {
+		if !renamedFrom[i] {
+			remaining = append(remaining, change)
 		}
-		report += "\n"
+	}
+	result.Added = added
+	result.Deleted = remaining
+}
+
+// sortedPaths returns the keys of files in sorted order
+func sortedPaths(files map[string]tree.FileData) []string {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// ErrRootMismatch is returned by AlignRoots when two trees were generated from
+// different directories and none of their files line up by relative path.
+var ErrRootMismatch = errors.New("trees were generated from different root paths")
+
+// AlignRoots returns a copy of oldTree rebased onto newTree's root path, so that
+// files are matched by their path relative to the scanned directory rather than
+// by absolute path. If the roots are the same, oldTree is returned unchanged.
+// If the roots differ and no relative path is shared between the two trees, the
+// rebased tree is still returned together with an error wrapping ErrRootMismatch.
+func AlignRoots(oldTree, newTree *tree.MerkleTree) (*tree.MerkleTree, error) {
+	oldRoot := filepath.Clean(oldTree.RootPath)
+	newRoot := filepath.Clean(newTree.RootPath)
+	if oldRoot == newRoot {
+		return oldTree, nil
+	}
+
+	files := make(map[string]tree.FileData, len(oldTree.Files))
+	overlap := 0
+	for path, data := range oldTree.Files {
+		relPath, err := filepath.Rel(oldRoot, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to relativize %s: %w", path, err)
+		}
+		newPath := filepath.Join(newRoot, relPath)
+		files[newPath] = data
+		if _, exists := newTree.Files[newPath]; exists {
+			overlap++
+		}
+	}
+
+	aligned := &tree.MerkleTree{
+		Root:        oldTree.Root,
+		RootPath:    newTree.RootPath,
+		TotalSize:   oldTree.TotalSize,
+		Files:       files,
+		Volume:      oldTree.Volume,
+		Directories: oldTree.Directories,
+		Portable:    oldTree.Portable,
+		EmptyDirs:   oldTree.EmptyDirs,
+		Archives:    oldTree.Archives,
+		SegmentSize: oldTree.SegmentSize,
+		ChunkSize:   oldTree.ChunkSize,
+		CIDs:        oldTree.CIDs,
+		Fingerprint: oldTree.Fingerprint,
+
+		HashAlgorithm: oldTree.HashAlgorithm,
+		BytewiseOrder: oldTree.BytewiseOrder,
+		MetadataOnly:  oldTree.MetadataOnly,
+
+		DigestAlgorithms: oldTree.DigestAlgorithms,
 	}
 
-	report += fmt.Sprintf("Summary: %d added, %d modified, %d deleted\n",
+	if overlap == 0 && len(oldTree.Files) > 0 && len(newTree.Files) > 0 {
+		return aligned, fmt.Errorf("%w: %s vs %s", ErrRootMismatch, oldTree.RootPath, newTree.RootPath)
+	}
+
+	return aligned, nil
+}
+
+// formatModTime renders a modification date, or "unknown" for sources such
+// as package manifests that do not record one
+func formatModTime(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Format("2006-01-02")
+}
+
+// ReportOptions controls how FormatReportWithOptions renders changes
+type ReportOptions struct {
+	// Color highlights additions in green, modifications in yellow and
+	// deletions in red with ANSI escape sequences
+	Color bool
+
+	// RelativeTo, if set, shortens paths under it to their relative form
+	RelativeTo string
+
+	// Top, if positive, adds a section with the byte totals of the changes
+	// and the Top added or modified files with the largest size delta
+	Top int
+
+	// MaxEntries, if positive, lists at most this many changes in each
+	// section, followed by a note of how many were left out. Headings and
+	// the summary still count every change.
+	MaxEntries int
+}
+
+// ANSI escape sequences used when ReportOptions.Color is set
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiBlue   = "\x1b[34m"
+	ansiCyan   = "\x1b[36m"
+)
+
+// maxPathColumn caps the width paths are padded to, so one deep path does
+// not push every other line of its section far to the right
+const maxPathColumn = 60
+
+// changeColors maps change types to their report color
+var changeColors = map[ChangeType]string{
+	Added:        ansiGreen,
+	Modified:     ansiYellow,
+	Deleted:      ansiRed,
+	Renamed:      ansiBlue,
+	CaseRenamed:  ansiBlue,
+	MetadataOnly: ansiCyan,
+	Unreadable:   ansiRed,
+}
+
+// paint wraps s in the escape sequence code if color is enabled
+func (o ReportOptions) paint(s, code string) string {
+	if !o.Color || code == "" {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// displayPath returns the path of change as it appears in the report,
+// with a trailing separator for empty directories
+func (o ReportOptions) displayPath(change Change) string {
+	path := change.Path
+	if o.RelativeTo != "" {
+		if rel, err := filepath.Rel(o.RelativeTo, path); err == nil && filepath.IsLocal(rel) {
+			path = rel
+		}
+	}
+	if (change.NewData != nil && change.NewData.Dir) || (change.NewData == nil && change.OldData != nil && change.OldData.Dir) {
+		path += string(filepath.Separator)
+	}
+	return path
+}
+
+// label returns what identifies change in the report: its path, or for a
+// file that moved the old and new paths
+func (o ReportOptions) label(change Change) string {
+	if change.OldPath != "" {
+		return o.displayPath(Change{Path: change.OldPath, OldData: change.OldData}) + " → " + o.displayPath(change)
+	}
+	return o.displayPath(change)
+}
+
+// FormatChange renders a single change as it appears in the report
+func FormatChange(change Change) string {
+	return FormatChangeWithOptions(change, ReportOptions{})
+}
+
+// FormatChangeWithOptions is FormatChange with options
+func FormatChangeWithOptions(change Change, opts ReportOptions) string {
+	return formatChange(change, opts, 0)
+}
+
+// formatChange renders change with its path padded to width columns,
+// followed by the tags of the file, if any
+func formatChange(change Change, opts ReportOptions, width int) string {
+	return formatChangeLine(change, opts, width) + formatTagsLine(change)
+}
+
+// formatChangeLine renders change itself
+func formatChangeLine(change Change, opts ReportOptions, width int) string {
+	path := opts.label(change)
+	padding := ""
+	if n := utf8.RuneCountInString(path); n < width {
+		padding = strings.Repeat(" ", width-n)
+	}
+	marker := func(m string) string {
+		return opts.paint(m+" "+path, changeColors[change.Type]) + padding
+	}
+
+	switch change.Type {
+	case Added:
+		if change.NewData.Dir {
+			return fmt.Sprintf("  %s (empty directory)\n", marker("+"))
+		}
+		return fmt.Sprintf("  %s (hash: %s, size: %d bytes)\n",
+			marker("+"), change.NewData.Hash, change.NewData.Size)
+	case Modified:
+		sizeWidth := len(fmt.Sprint(max(change.OldData.Size, change.NewData.Size)))
+		return fmt.Sprintf("  %s\n", opts.paint("~ "+path, changeColors[Modified])) +
+			fmt.Sprintf("    Old: hash=%s, size=%*d bytes, modified=%s\n",
+				change.OldData.Hash, sizeWidth, change.OldData.Size, formatModTime(change.OldData.ModTime)) +
+			fmt.Sprintf("    New: hash=%s, size=%*d bytes, modified=%s\n",
+				change.NewData.Hash, sizeWidth, change.NewData.Size, formatModTime(change.NewData.ModTime)) +
+			formatDeltaLine(change.Delta)
+	case Deleted:
+		if change.OldData.Dir {
+			return fmt.Sprintf("  %s (empty directory)\n", marker("-"))
+		}
+		return fmt.Sprintf("  %s (hash: %s, size: %d bytes)\n",
+			marker("-"), change.OldData.Hash, change.OldData.Size)
+	case Renamed, CaseRenamed:
+		return fmt.Sprintf("  %s (hash: %s, size: %d bytes)\n",
+			marker(">"), change.NewData.Hash, change.NewData.Size)
+	case MetadataOnly:
+		return fmt.Sprintf("  %s (hash: %s, modified: %s -> %s)\n",
+			marker("*"), change.NewData.Hash,
+			change.OldData.ModTime.Format(time.RFC3339), change.NewData.ModTime.Format(time.RFC3339))
+	case Unreadable:
+		return fmt.Sprintf("  %s (hash: %s, size: %d bytes)\n    Error: %s\n",
+			marker("!"), change.OldData.Hash, change.OldData.Size, change.Error)
+	}
+	return fmt.Sprintf("  ? %s\n", path)
+}
+
+// formatTagsLine renders the tags of the file a change concerns, as it is
+// now or, if deleted, as it was
+func formatTagsLine(change Change) string {
+	data := change.NewData
+	if data == nil {
+		data = change.OldData
+	}
+	if data == nil || len(data.Tags) == 0 {
+		return ""
+	}
+	tags := make([]string, 0, len(data.Tags))
+	for key, value := range data.Tags {
+		tags = append(tags, key+"="+value)
+	}
+	sort.Strings(tags)
+	return fmt.Sprintf("    Tags: %s\n", strings.Join(tags, ", "))
+}
+
+// formatDeltaLine renders the chunk delta of a modified file, if known
+func formatDeltaLine(delta *ChunkDelta) string {
+	if delta == nil {
+		return ""
+	}
+	return fmt.Sprintf("    Delta: %s\n", delta)
+}
+
+// writeSection writes a report section with the paths of its changes
+// aligned in one column
+func writeSection(w *bufio.Writer, heading string, changes []Change, opts ReportOptions) {
+	if len(changes) == 0 {
+		return
+	}
+	shown := changes
+	if opts.MaxEntries > 0 && len(shown) > opts.MaxEntries {
+		shown = shown[:opts.MaxEntries]
+	}
+	width := 0
+	for _, change := range shown {
+		width = max(width, utf8.RuneCountInString(opts.label(change)))
+	}
+	width = min(width, maxPathColumn)
+
+	w.WriteString(opts.paint(heading, ansiBold+changeColors[changes[0].Type]) + "\n")
+	for _, change := range shown {
+		w.WriteString(formatChange(change, opts, width))
+	}
+	if hidden := len(changes) - len(shown); hidden > 0 {
+		fmt.Fprintf(w, "  ... %d more not shown\n", hidden)
+	}
+	w.WriteString("\n")
+}
+
+func FormatReport(result *CompareResult) string {
+	return FormatReportWithOptions(result, ReportOptions{})
+}
+
+// FormatReportWithOptions is FormatReport with options
+func FormatReportWithOptions(result *CompareResult, opts ReportOptions) string {
+	var report strings.Builder
+	// Writing to a strings.Builder cannot fail
+	WriteTextReport(&report, result, opts)
+	return report.String()
+}
+
+// WriteTextReport writes the report of FormatReportWithOptions to w as it
+// renders it, so that the report of a huge number of changes is never held
+// in memory whole
+func WriteTextReport(w io.Writer, result *CompareResult, opts ReportOptions) error {
+	bw := bufio.NewWriter(w)
+	if !result.HasChanges() && len(result.Unreadable) == 0 {
+		bw.WriteString("No changes detected.")
+		return bw.Flush()
+	}
+
+	bw.WriteString("Changes detected:\n\n")
+	writeSection(bw, fmt.Sprintf("ADDED (%d files):", len(result.Added)), result.Added, opts)
+	writeSection(bw, fmt.Sprintf("MODIFIED (%d files):", len(result.Modified)), result.Modified, opts)
+	writeSection(bw, fmt.Sprintf("DELETED (%d files):", len(result.Deleted)), result.Deleted, opts)
+	writeSection(bw, fmt.Sprintf("RENAMED (%d files):", len(result.Renamed)), result.Renamed, opts)
+	writeSection(bw, fmt.Sprintf("CASE RENAMED (%d files, case only):", len(result.CaseRenamed)), result.CaseRenamed, opts)
+	writeSection(bw, fmt.Sprintf("METADATA ONLY (%d files, content unchanged):", len(result.MetadataOnly)), result.MetadataOnly, opts)
+	writeSection(bw, fmt.Sprintf("UNREADABLE (%d files, present but could not be read):", len(result.Unreadable)), result.Unreadable, opts)
+	if opts.Top > 0 {
+		bw.WriteString(formatLargest(result, opts))
+	}
+	bw.WriteString(FormatSummary(result) + "\n")
+	return bw.Flush()
+}
+
+// FormatSummary returns the one-line summary that ends the report
+func FormatSummary(result *CompareResult) string {
+	summary := fmt.Sprintf("Summary: %d added, %d modified, %d deleted",
 		len(result.Added), len(result.Modified), len(result.Deleted))
+	if len(result.Renamed) > 0 {
+		summary += fmt.Sprintf(", %d renamed", len(result.Renamed))
+	}
+	if len(result.CaseRenamed) > 0 {
+		summary += fmt.Sprintf(", %d case-renamed", len(result.CaseRenamed))
+	}
+	if len(result.MetadataOnly) > 0 {
+		summary += fmt.Sprintf(", %d metadata-only", len(result.MetadataOnly))
+	}
+	if len(result.Unreadable) > 0 {
+		summary += fmt.Sprintf(", %d unreadable", len(result.Unreadable))
+	}
+	return summary
+}
 
-	return report
+// foldCase maps every letter of path to the same member of its simple case
+// folding orbit, so paths that only differ in case map to the same string
+func foldCase(path string) string {
+	return strings.Map(func(r rune) rune {
+		folded := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			folded = min(folded, f)
+		}
+		return folded
+	}, path)
 }
@@ -1,8 +1,378 @@
 package compare
 
-import "testing"
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"merkle-go/internal/tree"
+)
 
 func TestPlaceholder(t *testing.T) {
 	// Placeholder test
 	t.Skip("Not implemented yet")
 }
+
+func TestCompare_StrictMetadataOnly(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	oldTree := &tree.MerkleTree{
+		RootPath: "/data",
+		Files: map[string]tree.FileData{
+			"/data/stomped.txt": {Hash: "h1", Size: 1, ModTime: mtime},
+			"/data/edited.txt":  {Hash: "h2", Size: 2, ModTime: mtime},
+			"/data/subsec.txt":  {Hash: "h3", Size: 3, ModTime: mtime},
+		},
+	}
+	newTree := &tree.MerkleTree{
+		RootPath: "/data",
+		Files: map[string]tree.FileData{
+			"/data/stomped.txt": {Hash: "h1", Size: 1, ModTime: mtime.Add(-24 * time.Hour)},
+			"/data/edited.txt":  {Hash: "h2-new", Size: 2, ModTime: mtime.Add(time.Hour)},
+			"/data/subsec.txt":  {Hash: "h3", Size: 3, ModTime: mtime.Add(300 * time.Millisecond)},
+		},
+	}
+
+	result := Compare(oldTree, newTree)
+	if len(result.MetadataOnly) != 0 || len(result.Modified) != 1 {
+		t.Errorf("Default mode should ignore metadata-only changes, got %+v", result)
+	}
+
+	result = CompareWithOptions(oldTree, newTree, Options{Strict: true})
+	if len(result.Modified) != 1 || result.Modified[0].Path != "/data/edited.txt" {
+		t.Errorf("Expected edited.txt to be modified, got %v", result.Modified)
+	}
+	if len(result.MetadataOnly) != 1 || result.MetadataOnly[0].Path != "/data/stomped.txt" {
+		t.Errorf("Expected stomped.txt to be metadata-only, got %v", result.MetadataOnly)
+	}
+}
+
+func TestCompare_Renames(t *testing.T) {
+	oldTree := &tree.MerkleTree{
+		RootPath: "/data",
+		Files: map[string]tree.FileData{
+			"/data/a/report.pdf": {Hash: "h1", Size: 10},
+			"/data/copy1":        {Hash: "h2", Size: 20},
+			"/data/copy2":        {Hash: "h2", Size: 20},
+			"/data/gone.txt":     {Hash: "h3", Size: 30},
+			"/data/empty":        {Hash: "e", Size: 0},
+			"/data/resized":      {Hash: "h4", Size: 40},
+		},
+	}
+	newTree := &tree.MerkleTree{
+		RootPath: "/data",
+		Files: map[string]tree.FileData{
+			"/data/b/report.pdf": {Hash: "h1", Size: 10},
+			"/data/moved/copy1":  {Hash: "h2", Size: 20},
+			"/data/moved/copy2":  {Hash: "h2", Size: 20},
+			"/data/new.txt":      {Hash: "h5", Size: 30},
+			"/data/empty2":       {Hash: "e", Size: 0},
+			"/data/resized2":     {Hash: "h4", Size: 41},
+		},
+	}
+
+	result := Compare(oldTree, newTree)
+	var renames []string
+	for _, change := range result.Renamed {
+		renames = append(renames, change.OldPath+" > "+change.Path)
+	}
+	want := "/data/a/report.pdf > /data/b/report.pdf,/data/copy1 > /data/moved/copy1,/data/copy2 > /data/moved/copy2"
+	if got := strings.Join(renames, ","); got != want {
+		t.Errorf("Renamed = %s, want %s", got, want)
+	}
+	// Different content, empty files and a different size are not renames
+	if len(result.Added) != 3 || len(result.Deleted) != 3 {
+		t.Errorf("Expected 3 added and 3 deleted, got %v and %v", result.Added, result.Deleted)
+	}
+	if result.Count() != 9 {
+		t.Errorf("Count() = %d, want 9", result.Count())
+	}
+
+	result = CompareWithOptions(oldTree, newTree, Options{NoRenames: true})
+	if len(result.Renamed) != 0 || len(result.Added) != 6 || len(result.Deleted) != 6 {
+		t.Errorf("NoRenames should report additions and deletions, got %+v", result)
+	}
+
+	report := FormatReportWithOptions(Compare(oldTree, newTree), ReportOptions{RelativeTo: "/data"})
+	for _, line := range []string{
+		"RENAMED (3 files):\n",
+		"  > a/report.pdf → b/report.pdf (hash: h1, size: 10 bytes)\n",
+		"Summary: 3 added, 0 modified, 3 deleted, 3 renamed\n",
+	} {
+		if !strings.Contains(report, filepath.FromSlash(line)) {
+			t.Errorf("Expected report to contain %q, got:\n%s", line, report)
+		}
+	}
+}
+
+func TestCompare_CaseInsensitive(t *testing.T) {
+	oldTree := &tree.MerkleTree{
+		RootPath: "/data",
+		Files: map[string]tree.FileData{
+			"/data/Readme.md":    {Hash: "h1", Size: 10},
+			"/data/docs/Guide":   {Hash: "h2", Size: 20},
+			"/data/STRASSE.txt":  {Hash: "h3", Size: 30},
+			"/data/elsewhere.go": {Hash: "h4", Size: 40},
+		},
+	}
+	newTree := &tree.MerkleTree{
+		RootPath: "/data",
+		Files: map[string]tree.FileData{
+			"/data/README.md":     {Hash: "h1", Size: 10},
+			"/data/Docs/guide":    {Hash: "h2-new", Size: 21},
+			"/data/straße.txt":    {Hash: "h3", Size: 30},
+			"/data/moved/else.go": {Hash: "h4", Size: 40},
+		},
+	}
+
+	result := CompareWithOptions(oldTree, newTree, Options{CaseInsensitive: true})
+	var caseRenames []string
+	for _, change := range result.CaseRenamed {
+		caseRenames = append(caseRenames, change.OldPath+" > "+change.Path)
+	}
+	want := "/data/Readme.md > /data/README.md"
+	if got := strings.Join(caseRenames, ","); got != want {
+		t.Errorf("CaseRenamed = %s, want %s", got, want)
+	}
+	// A case-only rename with new content is a modification
+	if len(result.Modified) != 1 || result.Modified[0].OldPath != "/data/docs/Guide" || result.Modified[0].Path != "/data/Docs/guide" {
+		t.Errorf("Expected docs/Guide to be modified as Docs/guide, got %v", result.Modified)
+	}
+	// Other moves are still detected as renames, and ß does not fold to ss
+	if len(result.Renamed) != 2 {
+		t.Errorf("Expected two renames, got %v", result.Renamed)
+	}
+	if result.Count() != 4 {
+		t.Errorf("Count() = %d, want 4", result.Count())
+	}
+
+	result = Compare(oldTree, newTree)
+	if len(result.CaseRenamed) != 0 || len(result.Renamed) != 3 || len(result.Added) != 1 || len(result.Deleted) != 1 {
+		t.Errorf("Case-sensitive compare should not match case-only changes, got %+v", result)
+	}
+
+	report := FormatReportWithOptions(CompareWithOptions(oldTree, newTree, Options{CaseInsensitive: true}), ReportOptions{RelativeTo: "/data"})
+	for _, line := range []string{
+		"CASE RENAMED (1 files, case only):\n",
+		"Summary: 0 added, 1 modified, 0 deleted, 2 renamed, 1 case-renamed\n",
+	} {
+		if !strings.Contains(report, line) {
+			t.Errorf("Expected report to contain %q, got:\n%s", line, report)
+		}
+	}
+}
+
+func TestAlignRoots_SameRoot(t *testing.T) {
+	oldTree := &tree.MerkleTree{
+		RootPath: "/data",
+		Files:    map[string]tree.FileData{"/data/a.txt": {Hash: "h1"}},
+	}
+	newTree := &tree.MerkleTree{
+		RootPath: "/data/",
+		Files:    map[string]tree.FileData{"/data/a.txt": {Hash: "h1"}},
+	}
+
+	aligned, err := AlignRoots(oldTree, newTree)
+	if err != nil {
+		t.Fatalf("AlignRoots failed: %v", err)
+	}
+	if aligned != oldTree {
+		t.Error("Trees with the same root should not be rebased")
+	}
+}
+
+func TestAlignRoots_RelocatedRoot(t *testing.T) {
+	oldTree := &tree.MerkleTree{
+		RootPath: "/old/data",
+		Files: map[string]tree.FileData{
+			"/old/data/a.txt":     {Hash: "h1"},
+			"/old/data/sub/b.txt": {Hash: "h2"},
+		},
+	}
+	newTree := &tree.MerkleTree{
+		RootPath: "/new/data",
+		Files: map[string]tree.FileData{
+			"/new/data/a.txt":     {Hash: "h1"},
+			"/new/data/sub/b.txt": {Hash: "changed"},
+		},
+	}
+
+	aligned, err := AlignRoots(oldTree, newTree)
+	if err != nil {
+		t.Fatalf("AlignRoots failed: %v", err)
+	}
+
+	result := Compare(aligned, newTree)
+	if len(result.Added) != 0 || len(result.Deleted) != 0 {
+		t.Errorf("Expected no added/deleted files, got %d added, %d deleted",
+			len(result.Added), len(result.Deleted))
+	}
+	if len(result.Modified) != 1 || result.Modified[0].Path != "/new/data/sub/b.txt" {
+		t.Errorf("Expected sub/b.txt to be modified, got %+v", result.Modified)
+	}
+}
+
+func TestAlignRoots_Unrelated(t *testing.T) {
+	oldTree := &tree.MerkleTree{
+		RootPath: "/photos",
+		Files:    map[string]tree.FileData{"/photos/img.jpg": {Hash: "h1"}},
+	}
+	newTree := &tree.MerkleTree{
+		RootPath: "/src",
+		Files:    map[string]tree.FileData{"/src/main.go": {Hash: "h2"}},
+	}
+
+	aligned, err := AlignRoots(oldTree, newTree)
+	if !errors.Is(err, ErrRootMismatch) {
+		t.Fatalf("Expected ErrRootMismatch, got %v", err)
+	}
+	if aligned == nil {
+		t.Fatal("Aligned tree should still be returned on mismatch")
+	}
+	if _, ok := aligned.Files["/src/img.jpg"]; !ok {
+		t.Error("Old files should be rebased onto the new root")
+	}
+}
+
+func TestAlignRoots_CrossPlatform(t *testing.T) {
+	windowsRoot := `C:\data`
+	oldTree := &tree.MerkleTree{
+		RootPath: windowsRoot,
+		Files: map[string]tree.FileData{
+			filepath.Join(windowsRoot, "sub", "file.txt"): {Hash: "h1"},
+		},
+	}
+	newRoot := filepath.FromSlash("/data")
+	newTree := &tree.MerkleTree{
+		RootPath: newRoot,
+		Files: map[string]tree.FileData{
+			filepath.Join(newRoot, "sub", "file.txt"): {Hash: "h1"},
+		},
+	}
+
+	aligned, err := AlignRoots(oldTree, newTree)
+	if err != nil {
+		t.Fatalf("AlignRoots failed: %v", err)
+	}
+	if result := Compare(aligned, newTree); result.HasChanges() {
+		t.Errorf("Expected no changes across platforms, got %+v", result)
+	}
+}
+
+func TestFormatReportWithOptions(t *testing.T) {
+	data := &tree.FileData{Hash: "aaaa", Size: 1}
+	result := &CompareResult{
+		Added:   []Change{{Type: Added, Path: "/root/a", NewData: data}, {Type: Added, Path: "/root/src/main.go", NewData: data}},
+		Deleted: []Change{{Type: Deleted, Path: "/elsewhere/old", OldData: data}},
+	}
+
+	plain := FormatReportWithOptions(result, ReportOptions{RelativeTo: "/root"})
+	for _, line := range []string{
+		"  + a           (hash: aaaa, size: 1 bytes)\n",
+		"  + src/main.go (hash: aaaa, size: 1 bytes)\n",
+		"  - /elsewhere/old (hash: aaaa, size: 1 bytes)\n",
+	} {
+		if !strings.Contains(plain, filepath.FromSlash(line)) {
+			t.Errorf("Expected report to contain %q, got:\n%s", line, plain)
+		}
+	}
+	if strings.Contains(plain, "\x1b[") {
+		t.Error("Expected no escape sequences without Color")
+	}
+
+	colored := FormatReportWithOptions(result, ReportOptions{Color: true})
+	if !strings.Contains(colored, ansiGreen+"+ /root/a"+ansiReset) || !strings.Contains(colored, ansiRed+"- /elsewhere/old"+ansiReset) {
+		t.Errorf("Expected colored markers, got %q", colored)
+	}
+}
+
+func TestWriteTextReport_MaxEntries(t *testing.T) {
+	data := &tree.FileData{Hash: "aaaa", Size: 1}
+	result := &CompareResult{Deleted: []Change{{Type: Deleted, Path: "/root/old", OldData: data}}}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		result.Added = append(result.Added, Change{Type: Added, Path: "/root/" + name, NewData: data})
+	}
+
+	var report strings.Builder
+	if err := WriteTextReport(&report, result, ReportOptions{RelativeTo: "/root", MaxEntries: 2}); err != nil {
+		t.Fatalf("WriteTextReport failed: %v", err)
+	}
+	got := report.String()
+	for _, want := range []string{"ADDED (5 files):\n", "+ b", "  ... 3 more not shown\n", "- old", "Summary: 5 added, 0 modified, 1 deleted"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected report to contain %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "+ c") {
+		t.Errorf("Expected changes past MaxEntries to be left out, got:\n%s", got)
+	}
+	if strings.Count(got, "more not shown") != 1 {
+		t.Errorf("Expected a note only for the truncated section, got:\n%s", got)
+	}
+
+	if full := FormatReportWithOptions(result, ReportOptions{}); !strings.Contains(full, "+ /root/e") || strings.Contains(full, "not shown") {
+		t.Errorf("Expected every change without MaxEntries, got:\n%s", full)
+	}
+}
+
+func TestFormatChange_Tags(t *testing.T) {
+	tags := map[string]string{"owner": "finance", "classification": "confidential"}
+	change := Change{Type: Deleted, Path: "/data/ledger.xlsx", OldData: &tree.FileData{Hash: "h1", Size: 10, Tags: tags}}
+	want := "  - /data/ledger.xlsx (hash: h1, size: 10 bytes)\n    Tags: classification=confidential, owner=finance\n"
+	if got := FormatChange(change); got != filepath.FromSlash(want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestCompare_Unreadable(t *testing.T) {
+	oldTree := &tree.MerkleTree{
+		RootPath: "/data",
+		Files: map[string]tree.FileData{
+			"/data/gone.txt":        {Hash: "h1", Size: 1},
+			"/data/busy.db":         {Hash: "h2", Size: 2},
+			"/data/locked/deep/a":   {Hash: "h3", Size: 3},
+			"/data/locked-not/same": {Hash: "h4", Size: 4},
+		},
+	}
+	newTree := &tree.MerkleTree{
+		RootPath: "/data",
+		Files: map[string]tree.FileData{
+			"/data/moved/busy.db": {Hash: "h2", Size: 2},
+		},
+		Errors: []tree.ScanError{
+			{Path: "/data/busy.db", Error: "resource busy"},
+			{Path: "/data/locked", Error: "permission denied"},
+		},
+	}
+
+	result := Compare(oldTree, newTree)
+	if len(result.Unreadable) != 2 || result.Unreadable[0].Path != "/data/busy.db" || result.Unreadable[1].Path != "/data/locked/deep/a" {
+		t.Fatalf("Expected busy.db and the file under locked to be unreadable, got %v", result.Unreadable)
+	}
+	if result.Unreadable[1].Error != "permission denied" {
+		t.Errorf("Expected the directory's error, got %q", result.Unreadable[1].Error)
+	}
+	// An unreadable file is not paired with a copy elsewhere as a rename
+	if len(result.Renamed) != 0 || len(result.Added) != 1 {
+		t.Errorf("Expected no rename and one addition, got %v and %v", result.Renamed, result.Added)
+	}
+	if len(result.Deleted) != 2 {
+		t.Errorf("Expected gone.txt and locked-not/same to be deleted, got %v", result.Deleted)
+	}
+	if result.Count() != 3 {
+		t.Errorf("Expected unreadable files not to count as changes, got %d", result.Count())
+	}
+
+	report := FormatReportWithOptions(result, ReportOptions{RelativeTo: "/data"})
+	for _, want := range []string{"UNREADABLE (2 files, present but could not be read):\n", "    Error: resource busy\n", ", 2 unreadable"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("Expected report to contain %q, got:\n%s", want, report)
+		}
+	}
+
+	onlyUnreadable := &CompareResult{Unreadable: result.Unreadable}
+	if report := FormatReportWithOptions(onlyUnreadable, ReportOptions{}); strings.Contains(report, "No changes detected") {
+		t.Errorf("Expected unreadable files to be listed, got:\n%s", report)
+	}
+}
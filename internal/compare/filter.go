@@ -0,0 +1,124 @@
+package compare
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"merkle-go/internal/pathmatch"
+)
+
+// Filter narrows a comparison to the changes of interest. The zero Filter
+// keeps everything.
+type Filter struct {
+	Only    []ChangeType // Change types to keep; empty keeps all
+	Include []string     // Globs of paths to keep, relative to the root; empty keeps all
+	Exclude []string     // Globs of paths to drop, relative to the root
+}
+
+// changeTypeNames maps the names accepted by ParseChangeTypes to types
+var changeTypeNames = map[string]ChangeType{
+	"added":    Added,
+	"modified": Modified,
+	"deleted":  Deleted,
+	"renamed":  Renamed,
+	"metadata": MetadataOnly,
+
+	"unreadable": Unreadable,
+
+	"case-renamed": CaseRenamed,
+}
+
+// ParseChangeTypes parses a comma-separated list of change types:
+// added, modified, deleted, renamed, case-renamed, metadata and unreadable
+func ParseChangeTypes(list string) ([]ChangeType, error) {
+	var types []ChangeType
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		changeType, ok := changeTypeNames[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown change type %q (want added, modified, deleted, renamed, case-renamed, metadata or unreadable)", name)
+		}
+		types = append(types, changeType)
+	}
+	return types, nil
+}
+
+// ParseCaseSensitivity parses the case_sensitivity setting, "sensitive"
+// (or empty) or "insensitive", and reports whether paths should be matched
+// case-insensitively
+func ParseCaseSensitivity(setting string) (bool, error) {
+	switch strings.ToLower(setting) {
+	case "", "sensitive":
+		return false, nil
+	case "insensitive":
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown case sensitivity %q (want sensitive or insensitive)", setting)
+}
+
+// Validate returns an error if any of the path patterns is malformed
+func (f Filter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if err := pathmatch.Validate(pattern); err != nil {
+			return fmt.Errorf("invalid path pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Allows reports whether change passes the filter. Paths are matched
+// relative to rootPath; a rename passes if its old or new path does.
+func (f Filter) Allows(change Change, rootPath string) bool {
+	if len(f.Only) > 0 {
+		wanted := false
+		for _, changeType := range f.Only {
+			wanted = wanted || changeType == change.Type
+		}
+		if !wanted {
+			return false
+		}
+	}
+
+	if change.OldPath != "" && f.allowsPath(change.OldPath, rootPath) {
+		return true
+	}
+	return f.allowsPath(change.Path, rootPath)
+}
+
+// allowsPath reports whether path passes the include and exclude globs
+func (f Filter) allowsPath(path, rootPath string) bool {
+	relPath, err := filepath.Rel(rootPath, path)
+	if err != nil {
+		relPath = path
+	}
+	if len(f.Include) > 0 && !pathmatch.MatchAny(f.Include, relPath) {
+		return false
+	}
+	return !pathmatch.MatchAny(f.Exclude, relPath)
+}
+
+// Apply returns the changes of result that pass the filter
+func (f Filter) Apply(result *CompareResult, rootPath string) *CompareResult {
+	keep := func(changes []Change) []Change {
+		kept := make([]Change, 0, len(changes))
+		for _, change := range changes {
+			if f.Allows(change, rootPath) {
+				kept = append(kept, change)
+			}
+		}
+		return kept
+	}
+	return &CompareResult{
+		Added:        keep(result.Added),
+		Modified:     keep(result.Modified),
+		Deleted:      keep(result.Deleted),
+		Renamed:      keep(result.Renamed),
+		CaseRenamed:  keep(result.CaseRenamed),
+		MetadataOnly: keep(result.MetadataOnly),
+		Unreadable:   keep(result.Unreadable),
+	}
+}
@@ -0,0 +1,46 @@
+package compare
+
+import (
+	"testing"
+
+	"merkle-go/internal/tree"
+)
+
+func TestFilter_Apply(t *testing.T) {
+	data := &tree.FileData{Hash: "aaaa"}
+	result := &CompareResult{
+		Added:    []Change{{Type: Added, Path: "/root/src/new.go", NewData: data}},
+		Modified: []Change{{Type: Modified, Path: "/root/src/main.go", OldData: data, NewData: data}, {Type: Modified, Path: "/root/README.md", OldData: data, NewData: data}},
+		Deleted:  []Change{{Type: Deleted, Path: "/root/src/logs/old.log", OldData: data}},
+	}
+
+	only, err := ParseChangeTypes("modified, deleted")
+	if err != nil {
+		t.Fatalf("ParseChangeTypes failed: %v", err)
+	}
+	filter := Filter{Only: only, Include: []string{"src/**"}, Exclude: []string{"src/logs/**"}}
+	if err := filter.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	filtered := filter.Apply(result, "/root")
+	if len(filtered.Added) != 0 {
+		t.Errorf("Expected added files to be dropped, got %v", filtered.Added)
+	}
+	if len(filtered.Modified) != 1 || filtered.Modified[0].Path != "/root/src/main.go" {
+		t.Errorf("Expected only src/main.go modified, got %v", filtered.Modified)
+	}
+	if len(filtered.Deleted) != 0 {
+		t.Errorf("Expected excluded logs to be dropped, got %v", filtered.Deleted)
+	}
+
+	if kept := (Filter{}).Apply(result, "/root"); len(kept.Modified) != 2 || len(kept.Added) != 1 {
+		t.Error("Expected the zero filter to keep every change")
+	}
+}
+
+func TestParseChangeTypes_Unknown(t *testing.T) {
+	if _, err := ParseChangeTypes("added,copied"); err == nil {
+		t.Error("Expected an error for an unknown change type")
+	}
+}
@@ -0,0 +1,168 @@
+package compare
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"merkle-go/internal/tree"
+)
+
+// Variant is one version of a file among several trees, and the trees that
+// hold it
+type Variant struct {
+	Hash  string   `json:"hash"` // empty for the trees the file is missing from
+	Size  int64    `json:"size"`
+	Trees []string `json:"trees"`
+}
+
+// FileVariants is a file that is not the same in every tree. Path is
+// relative to the tree roots, with forward slashes.
+type FileVariants struct {
+	Path     string    `json:"path"`
+	Variants []Variant `json:"variants"` // most common first
+}
+
+// ManyResult compares the files of several trees, such as replicas of one
+// dataset on different hosts
+type ManyResult struct {
+	Trees []string `json:"trees"`
+
+	// Groups are the sets of trees that hold exactly the same files,
+	// largest first; all trees agree if there is only one
+	Groups [][]string `json:"groups"`
+
+	Files     int            `json:"files"`     // distinct paths across the trees
+	Identical int            `json:"identical"` // files that are the same in every tree
+	Differing []FileVariants `json:"differing"` // sorted by path
+}
+
+// CompareMany compares trees, named by names, file by file. Files are
+// matched by their path relative to each tree's root and by content hash,
+// so the trees must all record digests of one hash algorithm; the first
+// of the first tree's algorithms that every tree records is used.
+func CompareMany(names []string, trees []*tree.MerkleTree) (*ManyResult, error) {
+	if len(trees) < 2 || len(names) != len(trees) {
+		return nil, fmt.Errorf("need at least two named trees to compare")
+	}
+	algorithms := trees[0].Algorithms()
+	for i, t := range trees[1:] {
+		shared := slices.DeleteFunc(slices.Clone(algorithms), func(algorithm string) bool {
+			return !records(t, algorithm)
+		})
+		if len(shared) == 0 {
+			return nil, fmt.Errorf("cannot compare trees hashed with %s and %s (%s, %s)",
+				strings.Join(trees[0].Algorithms(), ", "), strings.Join(t.Algorithms(), ", "), names[0], names[i+1])
+		}
+		algorithms = shared
+	}
+
+	files := make([]map[string]tree.FileData, len(trees))
+	paths := make(map[string]bool)
+	for i, t := range trees {
+		relFiles, err := relativeFiles(onAlgorithm(t, algorithms[0]))
+		if err != nil {
+			return nil, err
+		}
+		files[i] = relFiles
+		for relPath := range relFiles {
+			paths[relPath] = true
+		}
+	}
+
+	result := &ManyResult{Trees: names, Files: len(paths), Differing: make([]FileVariants, 0)}
+	// signatures[i] lists the variant tree i has of every differing file,
+	// so trees with equal signatures agree on everything
+	signatures := make([][]string, len(trees))
+	for _, relPath := range sortedKeys(paths) {
+		var variants []Variant
+		index := make(map[string]int)
+		keys := make([]string, len(trees))
+		for i := range trees {
+			variant := Variant{}
+			if data, ok := files[i][relPath]; ok {
+				// Empty directory markers differ from files of any content
+				keys[i] = data.Hash
+				if data.Dir {
+					keys[i] += "/"
+				}
+				variant = Variant{Hash: data.Hash, Size: data.Size}
+			}
+			n, seen := index[keys[i]]
+			if !seen {
+				n = len(variants)
+				index[keys[i]] = n
+				variants = append(variants, variant)
+			}
+			variants[n].Trees = append(variants[n].Trees, names[i])
+		}
+		if len(variants) == 1 {
+			result.Identical++
+			continue
+		}
+		for i, key := range keys {
+			signatures[i] = append(signatures[i], key)
+		}
+		sort.SliceStable(variants, func(a, b int) bool { return len(variants[a].Trees) > len(variants[b].Trees) })
+		result.Differing = append(result.Differing, FileVariants{Path: relPath, Variants: variants})
+	}
+
+	groupOf := make(map[string]int)
+	for i, signature := range signatures {
+		key := strings.Join(signature, "\x00")
+		n, seen := groupOf[key]
+		if !seen {
+			n = len(result.Groups)
+			groupOf[key] = n
+			result.Groups = append(result.Groups, nil)
+		}
+		result.Groups[n] = append(result.Groups[n], names[i])
+	}
+	sort.SliceStable(result.Groups, func(a, b int) bool { return len(result.Groups[a]) > len(result.Groups[b]) })
+	return result, nil
+}
+
+// sortedKeys returns the keys of set in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteManyReport writes result as the groups of agreeing trees followed by
+// the versions of every differing file
+func WriteManyReport(w io.Writer, result *ManyResult) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Compared %d trees: %d files, %d identical in all, %d differing\n\n",
+		len(result.Trees), result.Files, result.Identical, len(result.Differing))
+
+	if len(result.Groups) == 1 {
+		b.WriteString("All trees agree.\n")
+	} else {
+		b.WriteString("Trees grouped by agreement:\n")
+		for _, group := range result.Groups {
+			fmt.Fprintf(&b, "  %s\n", strings.Join(group, ", "))
+		}
+	}
+
+	if len(result.Differing) > 0 {
+		fmt.Fprintf(&b, "\nDIFFERING (%d files):\n", len(result.Differing))
+		for _, file := range result.Differing {
+			fmt.Fprintf(&b, "  %s\n", file.Path)
+			for _, variant := range file.Variants {
+				version := "missing"
+				if variant.Hash != "" {
+					version = fmt.Sprintf("%s (%s)", variant.Hash, tree.FormatSize(variant.Size))
+				}
+				fmt.Fprintf(&b, "    %s: %s\n", version, strings.Join(variant.Trees, ", "))
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,59 @@
+package compare
+
+import (
+	"strings"
+	"testing"
+
+	"merkle-go/internal/tree"
+)
+
+func TestCompareMany(t *testing.T) {
+	build := func(root string, files map[string]tree.FileData) *tree.MerkleTree {
+		abs := make(map[string]tree.FileData, len(files))
+		for relPath, data := range files {
+			abs[root+"/"+relPath] = data
+		}
+		built, err := tree.Build(abs, root)
+		if err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+		return built
+	}
+	a := build("/srv/a", map[string]tree.FileData{"same": {Hash: "aaaa", Size: 1}, "drift": {Hash: "bbbb", Size: 2}})
+	b := build("/srv/b", map[string]tree.FileData{"same": {Hash: "aaaa", Size: 1}, "drift": {Hash: "bbbb", Size: 2}})
+	c := build("/mnt/c", map[string]tree.FileData{"same": {Hash: "aaaa", Size: 1}, "drift": {Hash: "cccc", Size: 3}, "extra": {Hash: "dddd", Size: 4}})
+
+	result, err := CompareMany([]string{"a", "b", "c"}, []*tree.MerkleTree{a, b, c})
+	if err != nil {
+		t.Fatalf("CompareMany failed: %v", err)
+	}
+	if result.Files != 3 || result.Identical != 1 || len(result.Differing) != 2 {
+		t.Fatalf("Expected 3 files, 1 identical and 2 differing, got %+v", result)
+	}
+	drift := result.Differing[0]
+	if drift.Path != "drift" || len(drift.Variants) != 2 || drift.Variants[0].Hash != "bbbb" ||
+		strings.Join(drift.Variants[0].Trees, ",") != "a,b" || strings.Join(drift.Variants[1].Trees, ",") != "c" {
+		t.Errorf("Unexpected variants of drift: %+v", drift)
+	}
+	if extra := result.Differing[1]; extra.Variants[0].Hash != "" || strings.Join(extra.Variants[0].Trees, ",") != "a,b" {
+		t.Errorf("Expected extra to be missing from a and b, got %+v", extra)
+	}
+	if len(result.Groups) != 2 || strings.Join(result.Groups[0], ",") != "a,b" || strings.Join(result.Groups[1], ",") != "c" {
+		t.Errorf("Expected groups [a b] [c], got %v", result.Groups)
+	}
+
+	var out strings.Builder
+	if err := WriteManyReport(&out, result); err != nil {
+		t.Fatalf("WriteManyReport failed: %v", err)
+	}
+	for _, line := range []string{"Compared 3 trees: 3 files, 1 identical in all, 2 differing\n", "  a, b\n", "    missing: a, b\n"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Expected report to contain %q, got:\n%s", line, out.String())
+		}
+	}
+
+	result, err = CompareMany([]string{"a", "b"}, []*tree.MerkleTree{a, b})
+	if err != nil || len(result.Groups) != 1 || len(result.Differing) != 0 {
+		t.Errorf("Expected identical trees to agree, got %+v, %v", result, err)
+	}
+}
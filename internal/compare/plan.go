@@ -0,0 +1,40 @@
+package compare
+
+import (
+	"merkle-go/internal/tree"
+)
+
+// Plan splits the files found by a walk into the ones that must be hashed
+// and the ones whose saved hash can be reused
+type Plan struct {
+	Hash  []string                 // Paths to hash, sorted
+	Reuse map[string]tree.FileData // Unchanged files with their saved hash
+}
+
+// NewPlan decides which walked files need hashing when comparing against
+// oldTree. walked holds the size and modification time of every file found;
+// hashes are ignored. A file is reused when the saved tree has the same
+// size and modification time (to the second) for it, and hashed otherwise:
+// new files, files whose metadata changed, and files of trees that record
+// no modification times. Content changes that leave both untouched, such as
+// bit rot, are not seen; use Check or a full rehash for those.
+func NewPlan(oldTree *tree.MerkleTree, walked map[string]tree.FileData) *Plan {
+	plan := &Plan{
+		Hash:  make([]string, 0),
+		Reuse: make(map[string]tree.FileData),
+	}
+
+	for _, path := range sortedPaths(walked) {
+		newData := walked[path]
+		oldData, exists := oldTree.Files[path]
+		if exists && oldData.Dir == newData.Dir && !oldData.ModTime.IsZero() && !newData.ModTime.IsZero() &&
+			oldData.Size == newData.Size && oldData.ModTime.Unix() == newData.ModTime.Unix() {
+			newData.Hash = oldData.Hash
+			plan.Reuse[path] = newData
+			continue
+		}
+		plan.Hash = append(plan.Hash, path)
+	}
+
+	return plan
+}
@@ -0,0 +1,48 @@
+package compare
+
+import (
+	"testing"
+	"time"
+
+	"merkle-go/internal/tree"
+)
+
+func TestNewPlan(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	oldTree := &tree.MerkleTree{
+		Files: map[string]tree.FileData{
+			"/root/same.txt":    {Hash: "aaaa", Size: 10, ModTime: mtime},
+			"/root/touched.txt": {Hash: "bbbb", Size: 10, ModTime: mtime},
+			"/root/resized.txt": {Hash: "cccc", Size: 10, ModTime: mtime},
+			"/root/no-mtime":    {Hash: "dddd", Size: 10},
+			"/root/deleted.txt": {Hash: "eeee", Size: 10, ModTime: mtime},
+		},
+	}
+	walked := map[string]tree.FileData{
+		"/root/same.txt":    {Size: 10, ModTime: mtime.Add(500 * time.Millisecond)},
+		"/root/touched.txt": {Size: 10, ModTime: mtime.Add(time.Hour)},
+		"/root/resized.txt": {Size: 11, ModTime: mtime},
+		"/root/no-mtime":    {Size: 10, ModTime: mtime},
+		"/root/new.txt":     {Size: 1, ModTime: mtime},
+	}
+
+	plan := NewPlan(oldTree, walked)
+
+	expected := []string{"/root/new.txt", "/root/no-mtime", "/root/resized.txt", "/root/touched.txt"}
+	if len(plan.Hash) != len(expected) {
+		t.Fatalf("Expected %v to be hashed, got %v", expected, plan.Hash)
+	}
+	for i, path := range expected {
+		if plan.Hash[i] != path {
+			t.Errorf("Expected %s at %d, got %s", path, i, plan.Hash[i])
+		}
+	}
+
+	if len(plan.Reuse) != 1 {
+		t.Fatalf("Expected 1 reused file, got %d", len(plan.Reuse))
+	}
+	reused := plan.Reuse["/root/same.txt"]
+	if reused.Hash != "aaaa" || !reused.ModTime.Equal(walked["/root/same.txt"].ModTime) {
+		t.Errorf("Expected the saved hash with the current mtime, got %+v", reused)
+	}
+}
@@ -0,0 +1,101 @@
+package compare
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"merkle-go/internal/tree"
+)
+
+// ByteTotals sums the sizes involved in a change set: the size of added
+// and deleted files, and the new size of modified ones
+type ByteTotals struct {
+	Added   int64
+	Removed int64
+	Changed int64
+}
+
+// Totals returns the byte totals of result
+func Totals(result *CompareResult) ByteTotals {
+	var totals ByteTotals
+	for _, change := range result.Added {
+		totals.Added += change.NewData.Size
+	}
+	for _, change := range result.Deleted {
+		totals.Removed += change.OldData.Size
+	}
+	for _, change := range result.Modified {
+		totals.Changed += change.NewData.Size
+	}
+	return totals
+}
+
+// SizeDelta returns how much a change grew its file: the size of an added
+// file, the negative size of a deleted one and the difference for the rest
+func SizeDelta(change Change) int64 {
+	var delta int64
+	if change.NewData != nil {
+		delta += change.NewData.Size
+	}
+	if change.OldData != nil {
+		delta -= change.OldData.Size
+	}
+	return delta
+}
+
+// Largest returns up to n added or modified files with the largest size
+// delta in either direction, largest first; ties are ordered by path
+func Largest(result *CompareResult, n int) []Change {
+	changes := make([]Change, 0, len(result.Added)+len(result.Modified))
+	changes = append(changes, result.Added...)
+	changes = append(changes, result.Modified...)
+	sort.SliceStable(changes, func(i, j int) bool {
+		di, dj := abs(SizeDelta(changes[i])), abs(SizeDelta(changes[j]))
+		if di != dj {
+			return di > dj
+		}
+		return changes[i].Path < changes[j].Path
+	})
+	return changes[:min(n, len(changes))]
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// formatDelta renders a size delta with its sign
+func formatDelta(delta int64) string {
+	if delta < 0 {
+		return "-" + tree.FormatSize(-delta)
+	}
+	return "+" + tree.FormatSize(delta)
+}
+
+// formatLargest renders the byte totals and the top largest changes of
+// result as a report section
+func formatLargest(result *CompareResult, opts ReportOptions) string {
+	totals := Totals(result)
+	section := opts.paint("LARGEST CHANGES:", ansiBold) + "\n"
+	section += fmt.Sprintf("  Bytes added: %s, removed: %s, in modified files: %s\n",
+		tree.FormatSize(totals.Added), tree.FormatSize(totals.Removed), tree.FormatSize(totals.Changed))
+
+	largest := Largest(result, opts.Top)
+	width := 0
+	for _, change := range largest {
+		width = max(width, len(formatDelta(SizeDelta(change))))
+	}
+	for _, change := range largest {
+		delta := formatDelta(SizeDelta(change))
+		marker := "+"
+		if change.Type == Modified {
+			marker = "~"
+		}
+		section += fmt.Sprintf("  %s%s  %s\n", strings.Repeat(" ", width-len(delta)), delta,
+			opts.paint(marker+" "+opts.displayPath(change), changeColors[change.Type]))
+	}
+	return section + "\n"
+}
@@ -0,0 +1,41 @@
+package compare
+
+import (
+	"strings"
+	"testing"
+
+	"merkle-go/internal/tree"
+)
+
+func TestLargest(t *testing.T) {
+	result := &CompareResult{
+		Added: []Change{
+			{Type: Added, Path: "/root/small", NewData: &tree.FileData{Size: 10}},
+			{Type: Added, Path: "/root/video.mp4", NewData: &tree.FileData{Size: 5000}},
+		},
+		Modified: []Change{
+			{Type: Modified, Path: "/root/db", OldData: &tree.FileData{Size: 9000}, NewData: &tree.FileData{Size: 1000}},
+			{Type: Modified, Path: "/root/log", OldData: &tree.FileData{Size: 100}, NewData: &tree.FileData{Size: 150}},
+		},
+		Deleted: []Change{{Type: Deleted, Path: "/root/old", OldData: &tree.FileData{Size: 70000}}},
+	}
+
+	largest := Largest(result, 3)
+	var paths []string
+	for _, change := range largest {
+		paths = append(paths, change.Path)
+	}
+	if strings.Join(paths, ",") != "/root/db,/root/video.mp4,/root/log" {
+		t.Errorf("Expected the largest deltas in order, got %v", paths)
+	}
+
+	totals := Totals(result)
+	if totals != (ByteTotals{Added: 5010, Removed: 70000, Changed: 1150}) {
+		t.Errorf("Unexpected totals %+v", totals)
+	}
+
+	report := FormatReportWithOptions(result, ReportOptions{Top: 1, RelativeTo: "/root"})
+	if !strings.Contains(report, "LARGEST CHANGES:\n  Bytes added: 4.89 KB, removed: 68.36 KB, in modified files: 1.12 KB\n  -7.81 KB  ~ db\n\n") {
+		t.Errorf("Expected only the largest change in the section, got:\n%s", report)
+	}
+}
@@ -0,0 +1,91 @@
+package compare
+
+import (
+	"sync"
+
+	"merkle-go/internal/tree"
+)
+
+// Streamer reports changes against a saved tree as soon as each one can be
+// determined, instead of after the whole directory has been hashed.
+// Deletions are known once the walk finishes; additions and modifications
+// as each file's hash arrives. The changes emitted over a full run are the
+// same ones CompareWithOptions reports for the resulting tree, except that
+// renames are never detected: a moved file is emitted as a deletion and an
+// addition, as with Options.NoRenames.
+type Streamer struct {
+	oldTree *tree.MerkleTree
+	opts    Options
+	emit    func(Change)
+
+	mu     sync.Mutex
+	walked map[string]bool
+}
+
+// NewStreamer returns a streamer comparing against oldTree with opts that
+// calls emit for every change found. emit is never called concurrently.
+func NewStreamer(oldTree *tree.MerkleTree, opts Options, emit func(Change)) *Streamer {
+	return &Streamer{oldTree: oldTree, opts: opts, emit: emit, walked: make(map[string]bool)}
+}
+
+// Walked records the paths found by the directory walk and emits every file
+// of the saved tree that is no longer present, as unreadable if one of the
+// walk errors errs covers it
+func (s *Streamer) Walked(paths []string, errs []tree.ScanError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, path := range paths {
+		s.walked[path] = true
+	}
+	readErr := scanErrorLookup(errs)
+	for _, path := range sortedPaths(s.oldTree.Files) {
+		if s.walked[path] {
+			continue
+		}
+		if reason, ok := readErr(path); ok {
+			s.emitUnreadable(path, reason)
+			continue
+		}
+		s.emitDeleted(path)
+	}
+}
+
+// Hashed emits the change, if any, for a file whose hash has been computed
+func (s *Streamer) Hashed(path string, newData tree.FileData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldData, exists := s.oldTree.Files[path]
+	switch {
+	case !exists:
+		s.emit(Change{Type: Added, Path: path, NewData: &newData})
+	case oldData.Hash != newData.Hash:
+		s.emit(Change{Type: Modified, Path: path, OldData: &oldData, NewData: &newData})
+	case s.opts.Strict && metadataChanged(oldData, newData):
+		s.emit(Change{Type: MetadataOnly, Path: path, OldData: &oldData, NewData: &newData})
+	}
+}
+
+// Failed records a file that could not be hashed. Like Compare, a file of
+// the saved tree is reported as unreadable.
+func (s *Streamer) Failed(path string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.oldTree.Files[path]; exists {
+		s.emitUnreadable(path, err.Error())
+	}
+}
+
+// emitDeleted must be called with mu held
+func (s *Streamer) emitDeleted(path string) {
+	oldData := s.oldTree.Files[path]
+	s.emit(Change{Type: Deleted, Path: path, OldData: &oldData})
+}
+
+// emitUnreadable must be called with mu held
+func (s *Streamer) emitUnreadable(path, reason string) {
+	oldData := s.oldTree.Files[path]
+	s.emit(Change{Type: Unreadable, Path: path, OldData: &oldData, Error: reason})
+}
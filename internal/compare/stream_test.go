@@ -0,0 +1,67 @@
+package compare
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"merkle-go/internal/tree"
+)
+
+func TestStreamer_MatchesCompare(t *testing.T) {
+	oldTree := &tree.MerkleTree{
+		RootPath: "/data",
+		Files: map[string]tree.FileData{
+			"/data/same.txt":       {Hash: "h1", Size: 1},
+			"/data/changed.txt":    {Hash: "h2", Size: 2},
+			"/data/removed.txt":    {Hash: "h3", Size: 3},
+			"/data/unreadable.txt": {Hash: "h4", Size: 4},
+			"/data/locked/a.txt":   {Hash: "h5", Size: 5},
+		},
+	}
+	newFiles := map[string]tree.FileData{
+		"/data/same.txt":    {Hash: "h1", Size: 1},
+		"/data/changed.txt": {Hash: "h2-new", Size: 5},
+		"/data/new.txt":     {Hash: "h6", Size: 6},
+	}
+
+	var streamed []string
+	streamer := NewStreamer(oldTree, Options{}, func(change Change) {
+		streamed = append(streamed, string(change.Type)+" "+change.Path)
+	})
+
+	walkErrs := []tree.ScanError{{Path: "/data/locked", Error: "permission denied"}}
+	streamer.Walked([]string{"/data/same.txt", "/data/changed.txt", "/data/new.txt", "/data/unreadable.txt"}, walkErrs)
+	for path, data := range newFiles {
+		streamer.Hashed(path, data)
+	}
+	streamer.Failed("/data/unreadable.txt", errors.New("input/output error"))
+
+	newTree := &tree.MerkleTree{
+		RootPath: "/data",
+		Files:    newFiles,
+		Errors:   append(walkErrs, tree.ScanError{Path: "/data/unreadable.txt", Error: "input/output error"}),
+	}
+	result := Compare(oldTree, newTree)
+	if len(result.Unreadable) != 2 || len(result.Deleted) != 1 {
+		t.Fatalf("Expected 2 unreadable files and 1 deleted, got %v and %v", result.Unreadable, result.Deleted)
+	}
+	var expected []string
+	for _, changes := range [][]Change{result.Added, result.Modified, result.Deleted, result.Unreadable} {
+		for _, change := range changes {
+			expected = append(expected, string(change.Type)+" "+change.Path)
+		}
+	}
+
+	sort.Strings(streamed)
+	sort.Strings(expected)
+	if len(streamed) != len(expected) {
+		t.Fatalf("Expected %v, streamed %v", expected, streamed)
+	}
+	for i := range expected {
+		if streamed[i] != expected[i] {
+			t.Errorf("Expected %v, streamed %v", expected, streamed)
+			break
+		}
+	}
+}
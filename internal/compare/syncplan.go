@@ -0,0 +1,149 @@
+package compare
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"merkle-go/internal/tree"
+)
+
+// SyncAction is what a replication tool has to do with one path
+type SyncAction string
+
+const (
+	SyncCopy      SyncAction = "copy"      // new or changed at the source
+	SyncDelete    SyncAction = "delete"    // gone from the source
+	SyncUnchanged SyncAction = "unchanged" // identical content
+)
+
+// SyncStep is one entry of a SyncPlan. Path is relative to the tree roots,
+// with forward slashes; empty directory markers end in a slash.
+type SyncStep struct {
+	Action SyncAction `json:"action"`
+	Path   string     `json:"path"`
+	Size   int64      `json:"size"`
+	Hash   string     `json:"hash"`
+	MTime  int64      `json:"mtime,omitempty"` // Unix seconds at the source, if recorded
+
+	// Sampled is set for files whose Hash fingerprints a sample of this
+	// many bytes at each end (see tree.FileData.Sampled)
+	Sampled int64 `json:"sampled,omitempty"`
+}
+
+// SyncPlan lists the steps that turn the destination tree into the source
+// tree, sorted by path, with totals per action
+type SyncPlan struct {
+	Steps []SyncStep `json:"steps"`
+
+	CopyFiles      int   `json:"copy_files"`
+	CopyBytes      int64 `json:"copy_bytes"` // bytes to transfer
+	DeleteFiles    int   `json:"delete_files"`
+	UnchangedFiles int   `json:"unchanged_files"`
+	UnchangedBytes int64 `json:"unchanged_bytes"`
+
+	// VirtualFiles counts the piped-in files of either tree, which have no
+	// file to copy or delete and are left out of the steps
+	VirtualFiles int `json:"virtual_files,omitempty"`
+}
+
+// NewSyncPlan plans the replication of source onto dest. Files are matched
+// by their path relative to each tree's root and by content hash, so both
+// trees must use the same hash algorithm. Virtual leaves are skipped.
+func NewSyncPlan(dest, source *tree.MerkleTree) (*SyncPlan, error) {
+	if dest.LeafAlgorithm() != source.LeafAlgorithm() {
+		return nil, fmt.Errorf("cannot plan a sync between %s and %s trees", dest.LeafAlgorithm(), source.LeafAlgorithm())
+	}
+	destFiles, err := relativeFiles(dest)
+	if err != nil {
+		return nil, err
+	}
+	sourceFiles, err := relativeFiles(source)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &SyncPlan{Steps: make([]SyncStep, 0, len(sourceFiles))}
+	plan.VirtualFiles = dropVirtual(destFiles) + dropVirtual(sourceFiles)
+	for relPath, data := range sourceFiles {
+		step := SyncStep{Action: SyncCopy, Path: syncPath(relPath, data), Size: data.Size, Hash: data.Hash, Sampled: data.Sampled}
+		if !data.ModTime.IsZero() {
+			step.MTime = data.ModTime.Unix()
+		}
+		if old, exists := destFiles[relPath]; exists && old.Hash == data.Hash && old.Dir == data.Dir {
+			step.Action = SyncUnchanged
+			plan.UnchangedFiles++
+			plan.UnchangedBytes += data.Size
+		} else {
+			plan.CopyFiles++
+			plan.CopyBytes += data.Size
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+	for relPath, data := range destFiles {
+		if _, exists := sourceFiles[relPath]; !exists {
+			plan.Steps = append(plan.Steps, SyncStep{Action: SyncDelete, Path: syncPath(relPath, data), Size: data.Size, Hash: data.Hash})
+			plan.DeleteFiles++
+		}
+	}
+
+	sort.Slice(plan.Steps, func(i, j int) bool {
+		return plan.Steps[i].Path < plan.Steps[j].Path
+	})
+	return plan, nil
+}
+
+// relativeFiles keys the files of t by their slash path relative to its root
+func relativeFiles(t *tree.MerkleTree) (map[string]tree.FileData, error) {
+	files := make(map[string]tree.FileData, len(t.Files))
+	for path, data := range t.Files {
+		relPath, err := filepath.Rel(t.RootPath, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to relativize %s: %w", path, err)
+		}
+		files[filepath.ToSlash(relPath)] = data
+	}
+	return files, nil
+}
+
+// dropVirtual removes the virtual leaves from files and returns how many
+// there were
+func dropVirtual(files map[string]tree.FileData) int {
+	count := 0
+	for relPath, data := range files {
+		if data.Virtual {
+			delete(files, relPath)
+			count++
+		}
+	}
+	return count
+}
+
+func syncPath(relPath string, data tree.FileData) string {
+	if data.Dir {
+		return relPath + "/"
+	}
+	return relPath
+}
+
+// WriteSyncPlan writes plan as one "<action> <path>" line per step, like an
+// rsync itemized list, followed by a summary. Unchanged files are only
+// listed if withUnchanged is set.
+func WriteSyncPlan(w io.Writer, plan *SyncPlan, withUnchanged bool) error {
+	for _, step := range plan.Steps {
+		if step.Action == SyncUnchanged && !withUnchanged {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%-9s %s\n", step.Action, step.Path); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "\nPlan: %d to copy (%s), %d to delete, %d unchanged (%s)\n",
+		plan.CopyFiles, tree.FormatSize(plan.CopyBytes), plan.DeleteFiles,
+		plan.UnchangedFiles, tree.FormatSize(plan.UnchangedBytes))
+	if err == nil && plan.VirtualFiles > 0 {
+		_, err = fmt.Fprintf(w, "%d piped-in files skipped\n", plan.VirtualFiles)
+	}
+	return err
+}
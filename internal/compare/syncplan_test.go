@@ -0,0 +1,86 @@
+package compare
+
+import (
+	"strings"
+	"testing"
+
+	"merkle-go/internal/tree"
+)
+
+func TestNewSyncPlan(t *testing.T) {
+	dest, err := tree.Build(map[string]tree.FileData{
+		"/backup/same.txt":    {Hash: "aaaa", Size: 10},
+		"/backup/changed.txt": {Hash: "bbbb", Size: 20},
+		"/backup/gone.txt":    {Hash: "cccc", Size: 30},
+	}, "/backup")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	source, err := tree.Build(map[string]tree.FileData{
+		"/data/same.txt":    {Hash: "aaaa", Size: 10},
+		"/data/changed.txt": {Hash: "dddd", Size: 25},
+		"/data/new/file":    {Hash: "eeee", Size: 40},
+	}, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	plan, err := NewSyncPlan(dest, source)
+	if err != nil {
+		t.Fatalf("NewSyncPlan failed: %v", err)
+	}
+
+	expected := []SyncStep{
+		{Action: SyncCopy, Path: "changed.txt", Size: 25, Hash: "dddd"},
+		{Action: SyncDelete, Path: "gone.txt", Size: 30, Hash: "cccc"},
+		{Action: SyncCopy, Path: "new/file", Size: 40, Hash: "eeee"},
+		{Action: SyncUnchanged, Path: "same.txt", Size: 10, Hash: "aaaa"},
+	}
+	if len(plan.Steps) != len(expected) {
+		t.Fatalf("Expected %d steps, got %v", len(expected), plan.Steps)
+	}
+	for i, step := range expected {
+		if plan.Steps[i] != step {
+			t.Errorf("Step %d: expected %+v, got %+v", i, step, plan.Steps[i])
+		}
+	}
+	if plan.CopyFiles != 2 || plan.CopyBytes != 65 || plan.DeleteFiles != 1 || plan.UnchangedBytes != 10 {
+		t.Errorf("Unexpected totals: %+v", plan)
+	}
+
+	var out strings.Builder
+	if err := WriteSyncPlan(&out, plan, false); err != nil {
+		t.Fatalf("WriteSyncPlan failed: %v", err)
+	}
+	if strings.Contains(out.String(), "same.txt") || !strings.Contains(out.String(), "delete    gone.txt") {
+		t.Errorf("Unexpected plan output:\n%s", out.String())
+	}
+}
+
+func TestNewSyncPlan_SkipsVirtual(t *testing.T) {
+	dest, err := tree.Build(map[string]tree.FileData{
+		"/backup/a.txt":    {Hash: "aaaa", Size: 10},
+		"/backup/dump.sql": {Hash: "bbbb", Size: 20, Virtual: true},
+	}, "/backup")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	source, err := tree.Build(map[string]tree.FileData{
+		"/data/a.txt":    {Hash: "aaaa", Size: 10},
+		"/data/logs.tar": {Hash: "cccc", Size: 30, Virtual: true},
+	}, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	plan, err := NewSyncPlan(dest, source)
+	if err != nil {
+		t.Fatalf("NewSyncPlan failed: %v", err)
+	}
+	if len(plan.Steps) != 1 || plan.Steps[0].Path != "a.txt" || plan.CopyFiles != 0 || plan.DeleteFiles != 0 {
+		t.Errorf("Expected only a.txt to be planned, got %+v", plan)
+	}
+	if plan.VirtualFiles != 2 {
+		t.Errorf("Expected 2 virtual files to be counted, got %d", plan.VirtualFiles)
+	}
+}
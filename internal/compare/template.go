@@ -0,0 +1,202 @@
+package compare
+
+import (
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"merkle-go/internal/tree"
+)
+
+// Report formats besides the plain text of FormatReport
+const (
+	FormatText     = "text"
+	FormatHTML     = "html"
+	FormatMarkdown = "markdown"
+)
+
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// ReportData is what report templates are executed with
+type ReportData struct {
+	Title      string
+	RootPath   string // directory that was scanned
+	Baseline   string // saved tree it was compared with, if known
+	Generated  time.Time
+	Summary    string // the line FormatSummary returns
+	HasChanges bool
+	Sections   []ReportSection // only the change types that occurred
+}
+
+// ReportSection lists the changes of one type
+type ReportSection struct {
+	Title   string
+	Class   string // added, modified, deleted, renamed, metadata or unreadable
+	Changes []ReportChange
+}
+
+// ReportChange is a Change flattened for templates. Path, and OldPath for
+// renames, are relative to the scanned directory, with forward slashes.
+// Hash and Size describe the file as it is now, or as it was for deletions.
+// Delta is set for modified files whose chunks were recorded, and Error for
+// unreadable files.
+type ReportChange struct {
+	Type       ChangeType
+	Path       string
+	OldPath    string
+	Dir        bool
+	Hash       string
+	Size       int64
+	OldHash    string
+	NewHash    string
+	OldSize    int64
+	NewSize    int64
+	OldModTime time.Time
+	NewModTime time.Time
+	Delta      *ChunkDelta
+	Error      string
+}
+
+// NewReportData prepares result for rendering with a template
+func NewReportData(result *CompareResult, rootPath, baseline string) ReportData {
+	data := ReportData{
+		Title:      "merkle-go compare",
+		RootPath:   rootPath,
+		Baseline:   baseline,
+		Generated:  time.Now(),
+		Summary:    FormatSummary(result),
+		HasChanges: result.HasChanges(),
+	}
+	for _, section := range []struct {
+		title, class string
+		changes      []Change
+	}{
+		{"Added", "added", result.Added},
+		{"Modified", "modified", result.Modified},
+		{"Deleted", "deleted", result.Deleted},
+		{"Renamed", "renamed", result.Renamed},
+		{"Case renamed", "renamed", result.CaseRenamed},
+		{"Metadata only", "metadata", result.MetadataOnly},
+		{"Unreadable", "unreadable", result.Unreadable},
+	} {
+		if len(section.changes) == 0 {
+			continue
+		}
+		reportSection := ReportSection{Title: section.title, Class: section.class}
+		for _, change := range section.changes {
+			reportSection.Changes = append(reportSection.Changes, newReportChange(change, rootPath))
+		}
+		data.Sections = append(data.Sections, reportSection)
+	}
+	return data
+}
+
+func newReportChange(change Change, rootPath string) ReportChange {
+	relative := func(path string) string {
+		if rel, err := filepath.Rel(rootPath, path); err == nil && filepath.IsLocal(rel) {
+			path = rel
+		}
+		return filepath.ToSlash(path)
+	}
+	rc := ReportChange{Type: change.Type, Path: relative(change.Path), Delta: change.Delta, Error: change.Error}
+	if change.OldPath != "" {
+		rc.OldPath = relative(change.OldPath)
+	}
+	if change.OldData != nil {
+		rc.OldHash, rc.OldSize, rc.OldModTime = change.OldData.Hash, change.OldData.Size, change.OldData.ModTime
+		rc.Hash, rc.Size, rc.Dir = change.OldData.Hash, change.OldData.Size, change.OldData.Dir
+	}
+	if change.NewData != nil {
+		rc.NewHash, rc.NewSize, rc.NewModTime = change.NewData.Hash, change.NewData.Size, change.NewData.ModTime
+		rc.Hash, rc.Size, rc.Dir = change.NewData.Hash, change.NewData.Size, change.NewData.Dir
+	}
+	return rc
+}
+
+// templateFuncs are available to report templates: size formats a byte
+// count, date and datetime a modification time, and code wraps text in a
+// Markdown code span
+var templateFuncs = map[string]any{
+	"size":     tree.FormatSize,
+	"date":     formatModTime,
+	"datetime": func(t time.Time) string { return t.Format(time.RFC3339) },
+	"code":     markdownCode,
+}
+
+// ValidateFormat checks a report format name; md is accepted for markdown
+func ValidateFormat(format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		return FormatText, nil
+	case FormatHTML:
+		return FormatHTML, nil
+	case FormatMarkdown, "md":
+		return FormatMarkdown, nil
+	}
+	return "", fmt.Errorf("unknown report format %q (want text, html or markdown)", format)
+}
+
+// WriteReport renders data as html or markdown to w, with the template in
+// templatePath if set and the built-in one otherwise. HTML templates are
+// executed with html/template, so paths are escaped.
+func WriteReport(w io.Writer, format, templatePath string, data ReportData) error {
+	var source []byte
+	var err error
+	if templatePath != "" {
+		source, err = os.ReadFile(templatePath)
+	} else {
+		name := "templates/report.md.tmpl"
+		if format == FormatHTML {
+			name = "templates/report.html.tmpl"
+		}
+		source, err = defaultTemplates.ReadFile(name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read report template: %w", err)
+	}
+
+	switch format {
+	case FormatHTML:
+		tmpl, err := htmltemplate.New("report").Funcs(templateFuncs).Parse(string(source))
+		if err != nil {
+			return fmt.Errorf("invalid report template: %w", err)
+		}
+		err = tmpl.Execute(w, data)
+		if err != nil {
+			return fmt.Errorf("failed to render report: %w", err)
+		}
+	case FormatMarkdown:
+		tmpl, err := texttemplate.New("report").Funcs(templateFuncs).Parse(string(source))
+		if err != nil {
+			return fmt.Errorf("invalid report template: %w", err)
+		}
+		err = tmpl.Execute(w, data)
+		if err != nil {
+			return fmt.Errorf("failed to render report: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+	return nil
+}
+
+// markdownCode renders s as a Markdown code span that survives backticks
+// and table pipes in file names
+func markdownCode(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		return fence + " " + s + " " + fence
+	}
+	return fence + s + fence
+}
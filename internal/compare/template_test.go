@@ -0,0 +1,63 @@
+package compare
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"merkle-go/internal/tree"
+)
+
+func TestWriteReport(t *testing.T) {
+	data := &tree.FileData{Hash: "aaaa", Size: 2048}
+	result := &CompareResult{
+		Added:    []Change{{Type: Added, Path: "/root/src/<new>.go", NewData: data}},
+		Modified: []Change{{Type: Modified, Path: "/root/a|b.txt", OldData: data, NewData: &tree.FileData{Hash: "bbbb"}}},
+	}
+	report := NewReportData(result, "/root", "tree.json")
+	if len(report.Sections) != 2 || report.Sections[0].Changes[0].Path != "src/<new>.go" {
+		t.Fatalf("Unexpected sections %+v", report.Sections)
+	}
+
+	var html strings.Builder
+	if err := WriteReport(&html, FormatHTML, "", report); err != nil {
+		t.Fatalf("WriteReport html failed: %v", err)
+	}
+	if !strings.Contains(html.String(), "src/&lt;new&gt;.go") || !strings.Contains(html.String(), "<details") {
+		t.Errorf("Expected an escaped path in collapsible sections, got:\n%s", html.String())
+	}
+
+	var markdown strings.Builder
+	if err := WriteReport(&markdown, FormatMarkdown, "", report); err != nil {
+		t.Fatalf("WriteReport markdown failed: %v", err)
+	}
+	if !strings.Contains(markdown.String(), "| `a\\|b.txt` | `aaaa` | `bbbb` | 2.00 KB | 0 B |") {
+		t.Errorf("Expected a modified row with an escaped pipe, got:\n%s", markdown.String())
+	}
+}
+
+func TestWriteReport_CustomTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.tmpl")
+	if err := os.WriteFile(path, []byte("{{range .Sections}}{{.Title}}={{len .Changes}} {{end}}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result := &CompareResult{Deleted: []Change{{Type: Deleted, Path: "/root/old", OldData: &tree.FileData{Hash: "aaaa"}}}}
+
+	var out strings.Builder
+	if err := WriteReport(&out, FormatMarkdown, path, NewReportData(result, "/root", "")); err != nil {
+		t.Fatalf("WriteReport failed: %v", err)
+	}
+	if out.String() != "Deleted=1 " {
+		t.Errorf("Expected the custom template output, got %q", out.String())
+	}
+}
+
+func TestValidateFormat(t *testing.T) {
+	if format, err := ValidateFormat("md"); err != nil || format != FormatMarkdown {
+		t.Errorf("Expected md to mean markdown, got %q, %v", format, err)
+	}
+	if _, err := ValidateFormat("pdf"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
@@ -1,15 +1,601 @@
 package config
 
 import (
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/pelletier/go-toml/v2"
+
+	"merkle-go/internal/hash"
+	"merkle-go/internal/pathmatch"
 )
 
 type Config struct {
 	Skip       []string `toml:"skip"`
 	OutputFile string   `toml:"output_file"`
+
+	// SkipRegex leaves out the entries whose relative path matches these
+	// regular expressions, for rules globs cannot express; a rule starting
+	// with "!" keeps what earlier rules left out. See pathmatch.Regexps.
+	SkipRegex []string `toml:"skip_regex"`
+
+	// ReadStrategy selects how files are read while hashing: buffered
+	// (default), mmap, dontneed or direct. See hash.ValidateReadStrategy.
+	ReadStrategy string `toml:"read_strategy"`
+
+	// Workers and BufferSize tune hashing; zero means the default (a worker
+	// count chosen for the storage type, 32 KB buffers). The -w and
+	// --io-workers flags override Workers.
+	Workers    int `toml:"workers"`
+	BufferSize int `toml:"buffer_size"`
+
+	// CPUWorkers, if positive, splits hashing into a read stage of Workers
+	// goroutines and a digest stage digesting at most CPUWorkers blocks at
+	// once; see hash.ReadOptions.DigestWorkers. The --cpu-workers flag
+	// overrides it.
+	CPUWorkers int `toml:"cpu_workers"`
+
+	// Portable builds trees that hash identically on every platform; see
+	// tree.BuildOptions
+	Portable bool `toml:"portable"`
+
+	// BytewiseOrder orders leaves byte-wise by their forward-slash path
+	// regardless of platform; see tree.BuildOptions
+	BytewiseOrder bool `toml:"bytewise_order"`
+
+	// Schedule is the cron expression, e.g. "0 3 * * *", on which the fleet
+	// agent rescans when neither --schedule nor --interval is given
+	Schedule string `toml:"schedule"`
+
+	// WatchDebounce is how long changes must stop before the watch command
+	// rescans, e.g. "2s" (default), and WatchBatch the number of change
+	// events after which it rescans even if they keep coming (default
+	// 1000), so that bursts of writes cause one rescan and not hundreds.
+	// The --debounce and --batch flags override them.
+	WatchDebounce string `toml:"watch_debounce"`
+	WatchBatch    int    `toml:"watch_batch"`
+
+	// OneFileSystem keeps scans on the filesystem of the scanned directory,
+	// leaving out mount points below it (like tar --one-file-system)
+	OneFileSystem bool `toml:"one_file_system"`
+
+	// ExcludeCaches leaves out directories tagged as caches with a
+	// CACHEDIR.TAG file, and ExcludeIfPresent directories holding an entry
+	// with one of its names, such as ".nobackup", the markers backup tools
+	// respect
+	ExcludeCaches    bool     `toml:"exclude_caches"`
+	ExcludeIfPresent []string `toml:"exclude_if_present"`
+
+	// FilterCmd is a shell command that decides which entries a directory
+	// walk keeps, for policies beyond skip patterns. It is started once per
+	// walk, reads one path per line relative to the scanned directory
+	// (directories end in a slash) and answers each with "keep" or "skip".
+	FilterCmd string `toml:"filter_cmd"`
+
+	// MaxFiles, MaxDepth and MaxTotalBytes stop a directory walk that finds
+	// more files, deeper directories or more bytes than expected, such as
+	// an accidental scan of / or a recursive mount; 0 means no limit.
+	// OnLimit is what happens then: "abort" (default) or "warn", which
+	// logs a warning and scans everything anyway.
+	MaxFiles      int    `toml:"max_files"`
+	MaxDepth      int    `toml:"max_depth"`
+	MaxTotalBytes int64  `toml:"max_total_bytes"`
+	OnLimit       string `toml:"on_limit"`
+
+	// IORetries is how many times a directory read or file hash that fails
+	// with a transient error, as NFS and SMB mounts do when they briefly
+	// lose their server, is retried. RetryDelay is the wait before the
+	// first retry, e.g. "500ms" (default); it doubles for each further one.
+	IORetries  int    `toml:"io_retries"`
+	RetryDelay string `toml:"retry_delay"`
+
+	// EmptyDirs records empty directories in the tree so that adding or
+	// removing one counts as a change
+	EmptyDirs bool `toml:"empty_dirs"`
+
+	// DescendArchives lists archive formats (tar, zip) whose members are
+	// hashed as leaves named archive.tar!/member/path instead of hashing
+	// the archive as one file
+	DescendArchives []string `toml:"descend_archives"`
+
+	// HashAlgorithm is the file content hash: xxh64 (default), sha256, sha1
+	// or md5. Commands that rescan a saved tree use the tree's algorithm.
+	HashAlgorithm string `toml:"hash_algorithm"`
+
+	// HashAlgorithms, if set, records a digest of every file with each of
+	// these algorithms, computed in the same read: the first is the leaf
+	// hash, as hash_algorithm would set it, and the others are recorded
+	// beside it (e.g. ["xxh64", "sha256"] for fast compares and audit
+	// digests), so the manifest can be compared with trees hashed with any
+	// of them. Commands that rescan a saved tree to update it use the
+	// tree's algorithms.
+	HashAlgorithms []string `toml:"hash_algorithms"`
+
+	// SegmentSize, if positive, hashes files larger than this many bytes
+	// as a Merkle tree of segments read on several cores, so one huge file
+	// does not hold up a scan. It is recorded in the manifest; commands
+	// that rescan a saved tree use the tree's segment size.
+	// --parallel-large-files sets it to 64 MiB.
+	SegmentSize int64 `toml:"segment_size"`
+
+	// ChunkSize, if positive, records the content-defined chunks of every
+	// hashed file, cut around this average size in bytes (a power of two),
+	// so that compare can tell how much of a modified file changed.
+	// Commands that rescan a saved tree use the tree's chunk size. --chunks
+	// sets it to 8 KiB.
+	ChunkSize int `toml:"chunk_size"`
+
+	// CIDs records the IPFS CIDv1 of every hashed file, as
+	// `ipfs add --cid-version 1` computes it, so manifests can
+	// cross-reference content stored on IPFS. Files are read a second
+	// time. --cids sets it.
+	CIDs bool `toml:"cids"`
+
+	// HashKey is a file holding a secret key. When set, file contents are
+	// hashed with HMAC-SHA256 keyed with the file's bytes (hash_algorithm
+	// must be sha256 or unset), so that someone able to change files and
+	// manifests cannot forge matching hashes without the key. Keep the
+	// key file outside the scanned directories. See LoadHashKey.
+	HashKey string `toml:"hash_key"`
+	hashKey []byte
+
+	// CaseSensitivity is how compare matches paths between the two trees:
+	// "sensitive" (default) or "insensitive", for trees scanned on
+	// case-insensitive filesystems such as those of macOS and Windows,
+	// where a file renamed only in case is reported as case-renamed
+	CaseSensitivity string `toml:"case_sensitivity"`
+
+	// Fingerprint lists the files, typically enormous media files, that are
+	// fingerprinted from their first and last bytes and their size instead
+	// of being read whole (see hash.HashFileSample). Scans are much faster
+	// but a change in the middle of such a file goes unnoticed, so sampled
+	// leaves are marked in the manifest. When several rules match a file,
+	// the last one wins. Commands that rescan a saved tree use its rules.
+	Fingerprint []FingerprintRule `toml:"fingerprint"`
+
+	// Tags label the files matching each rule, e.g. with an owner team or
+	// a data classification, so the manifest doubles as an inventory. When
+	// several rules set the same key, the last one wins. Tags are recorded
+	// in the leaves but not hashed.
+	Tags []TagRule `toml:"tags"`
+
+	// HashCache is the hash cache database consulted by generate and
+	// compare (see package hashcache); empty means the default location in
+	// the user cache directory and "off" disables the cache. The --no-cache
+	// flag also disables it.
+	HashCache string `toml:"hash_cache"`
+
+	// FailOn lists what makes compare and check exit non-zero: changes,
+	// errors, both (the default) or none. MaxChanges and MaxErrors are the
+	// counts that are tolerated before failing. The --fail-on,
+	// --max-changes and --max-errors flags override them.
+	FailOn     string `toml:"fail_on"`
+	MaxChanges int    `toml:"max_changes"`
+	MaxErrors  int    `toml:"max_errors"`
+
+	// Notify holds one table per notifier kind, e.g. [notify.webhook]
+	Notify map[string]map[string]any `toml:"notify"`
+
+	// AlertThreshold and AlertPercentile limit the notifications of compare
+	// and of scheduled agent scans to unusual change volumes: more changed
+	// files than AlertThreshold, or than the AlertPercentile-th percentile
+	// of the earlier scans of the same directory once AlertMinHistory of
+	// them (default 10) are recorded. 0 disables a rule; without either,
+	// every change is notified. The change count of every scan is kept in
+	// AlertHistory, which defaults to merkle-go/history.jsonl in the user
+	// cache directory.
+	AlertThreshold  int     `toml:"alert_threshold"`
+	AlertPercentile float64 `toml:"alert_percentile"`
+	AlertMinHistory int     `toml:"alert_min_history"`
+	AlertHistory    string  `toml:"alert_history"`
+
+	// Profiles are named sets of overrides selected with --profile, e.g.
+	// [profiles.media]
+	Profiles map[string]Profile `toml:"profiles"`
+
+	// Targets are the directories merkle-go run scans, each into its own
+	// manifest, e.g. [[targets]]
+	Targets []Target `toml:"targets"`
+}
+
+// TagRule sets the tags in Set on the files matching any of Paths, which
+// use the syntax of skip patterns
+type TagRule struct {
+	Paths []string          `toml:"paths"`
+	Set   map[string]string `toml:"set"`
+}
+
+// FingerprintRule samples Bytes from each end of the files matching any of
+// Paths, which use the syntax of skip patterns
+type FingerprintRule struct {
+	Paths []string `toml:"paths" json:"paths"`
+	Bytes int64    `toml:"bytes" json:"bytes"`
+}
+
+// Profile overrides the settings it sets when selected
+type Profile struct {
+	Skip         []string `toml:"skip"`
+	OutputFile   string   `toml:"output_file"`
+	ReadStrategy string   `toml:"read_strategy"`
+}
+
+// Target is a directory scanned by merkle-go run. Its skip list, if set,
+// replaces the skip patterns of the config, like a profile's.
+type Target struct {
+	Name   string   `toml:"name"`
+	Path   string   `toml:"path"`
+	Skip   []string `toml:"skip"`
+	Output string   `toml:"output"`
+}
+
+// DirConfigName is the per-directory config file discovered in the root of
+// a scanned directory
+const DirConfigName = ".merkle-go.toml"
+
+// apply overrides the settings of c that p sets. An empty skip list set
+// explicitly (skip = []) clears the skip patterns.
+func (c *Config) apply(p Profile) {
+	if p.Skip != nil {
+		c.Skip = p.Skip
+	}
+	if p.OutputFile != "" {
+		c.OutputFile = p.OutputFile
+	}
+	if p.ReadStrategy != "" {
+		c.ReadStrategy = p.ReadStrategy
+	}
+}
+
+// ApplyProfile overrides the settings of c with the named profile
+func (c *Config) ApplyProfile(name string) error {
+	profile, ok := c.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(c.Profiles))
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return fmt.Errorf("unknown profile %q: no profiles are configured", name)
+		}
+		return fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	c.apply(profile)
+	return nil
+}
+
+// ApplyTarget overrides the settings of c with those of the named target
+func (c *Config) ApplyTarget(name string) error {
+	for _, target := range c.Targets {
+		if target.Name == name {
+			c.apply(Profile{Skip: target.Skip, OutputFile: target.Output})
+			return nil
+		}
+	}
+	if len(c.Targets) == 0 {
+		return fmt.Errorf("unknown target %q: no targets are configured", name)
+	}
+	return fmt.Errorf("unknown target %q (available: %s)", name, strings.Join(c.TargetNames(), ", "))
+}
+
+// TargetNames returns the names of the targets in config order
+func (c *Config) TargetNames() []string {
+	names := make([]string, len(c.Targets))
+	for i, target := range c.Targets {
+		names[i] = target.Name
+	}
+	return names
+}
+
+// ApplyDirConfig looks for DirConfigName in dir and, if present, lets it
+// override the settings of c. Notifiers and profiles it defines are added to
+// those of c. It returns the path of the file that was applied, or "".
+func (c *Config) ApplyDirConfig(dir string) (string, error) {
+	path := filepath.Join(dir, DirConfigName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var dirCfg Config
+	if err := toml.Unmarshal(data, &dirCfg); err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	c.apply(Profile{Skip: dirCfg.Skip, OutputFile: dirCfg.OutputFile, ReadStrategy: dirCfg.ReadStrategy})
+	for kind, options := range dirCfg.Notify {
+		if c.Notify == nil {
+			c.Notify = make(map[string]map[string]any)
+		}
+		c.Notify[kind] = options
+	}
+	for name, profile := range dirCfg.Profiles {
+		if c.Profiles == nil {
+			c.Profiles = make(map[string]Profile)
+		}
+		c.Profiles[name] = profile
+	}
+	return path, nil
+}
+
+// Validate returns an error if the walk limits, retry settings, alert rules,
+// tag rules or targets are malformed
+func (c *Config) Validate() error {
+	if c.MaxFiles < 0 || c.MaxDepth < 0 || c.MaxTotalBytes < 0 {
+		return fmt.Errorf("max_files, max_depth and max_total_bytes must not be negative")
+	}
+	if c.IORetries < 0 {
+		return fmt.Errorf("io_retries must not be negative")
+	}
+	if _, err := c.RetryDelayDuration(); err != nil {
+		return err
+	}
+	if _, err := c.WatchDebounceDuration(); err != nil {
+		return err
+	}
+	if c.CPUWorkers < 0 {
+		return fmt.Errorf("cpu_workers must not be negative")
+	}
+	if c.WatchBatch < 0 {
+		return fmt.Errorf("watch_batch must not be negative")
+	}
+	if c.AlertThreshold < 0 || c.AlertMinHistory < 0 {
+		return fmt.Errorf("alert_threshold and alert_min_history must not be negative")
+	}
+	if c.AlertPercentile < 0 || c.AlertPercentile >= 100 {
+		return fmt.Errorf("alert_percentile must be between 0 and 100, got %g", c.AlertPercentile)
+	}
+	names := make(map[string]bool)
+	for i, target := range c.Targets {
+		switch {
+		case target.Name == "":
+			return fmt.Errorf("target %d has no name", i+1)
+		case names[target.Name]:
+			return fmt.Errorf("duplicate target %q", target.Name)
+		case target.Path == "" || target.Output == "":
+			return fmt.Errorf("target %q needs a path and an output", target.Name)
+		}
+		names[target.Name] = true
+	}
+	for _, name := range c.ExcludeIfPresent {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("exclude_if_present: %q is not a file name", name)
+		}
+	}
+	if _, err := pathmatch.CompileRegexps(c.SkipRegex); err != nil {
+		return fmt.Errorf("skip_regex: %w", err)
+	}
+	switch c.OnLimit {
+	case "", "abort", "warn":
+	default:
+		return fmt.Errorf("unknown on_limit %q (want abort or warn)", c.OnLimit)
+	}
+	if err := c.validateHashAlgorithms(); err != nil {
+		return err
+	}
+	if c.HashKey != "" {
+		switch strings.ToLower(c.HashAlgorithm) {
+		case "", hash.SHA256, hash.HMACSHA256:
+		default:
+			return fmt.Errorf("hash_key hashes with %s; drop hash_algorithm %q", hash.HMACSHA256, c.HashAlgorithm)
+		}
+		if len(c.DescendArchives) > 0 {
+			return fmt.Errorf("hash_key cannot be combined with descend_archives")
+		}
+	}
+	for _, rule := range c.Fingerprint {
+		if len(rule.Paths) == 0 || rule.Bytes <= 0 {
+			return fmt.Errorf("fingerprint rules need paths and a positive bytes, got %v", rule)
+		}
+		for _, pattern := range rule.Paths {
+			if err := pathmatch.Validate(pattern); err != nil {
+				return fmt.Errorf("invalid fingerprint path %q: %w", pattern, err)
+			}
+		}
+	}
+	for _, rule := range c.Tags {
+		if len(rule.Paths) == 0 {
+			return fmt.Errorf("tag rule %v has no paths", rule.Set)
+		}
+		for _, pattern := range rule.Paths {
+			if err := pathmatch.Validate(pattern); err != nil {
+				return fmt.Errorf("invalid tag path %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateHashAlgorithms checks that HashAlgorithms names distinct
+// unkeyed algorithms that agree with HashAlgorithm, and that the settings
+// that hash files some other way are not combined with several of them
+func (c *Config) validateHashAlgorithms() error {
+	if len(c.HashAlgorithms) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, algorithm := range c.HashAlgorithms {
+		algorithm = strings.ToLower(algorithm)
+		if _, err := hash.New(algorithm); err != nil || algorithm == "" {
+			return fmt.Errorf("hash_algorithms: unsupported hash algorithm %q (want xxh64, sha256, sha1 or md5)", algorithm)
+		}
+		if seen[algorithm] {
+			return fmt.Errorf("hash_algorithms lists %s twice", algorithm)
+		}
+		seen[algorithm] = true
+	}
+	if c.HashAlgorithm != "" && !strings.EqualFold(c.HashAlgorithm, c.HashAlgorithms[0]) {
+		return fmt.Errorf("hash_algorithm %q differs from the first of hash_algorithms, %q", c.HashAlgorithm, c.HashAlgorithms[0])
+	}
+	if len(c.HashAlgorithms) > 1 {
+		switch {
+		case c.HashKey != "":
+			return fmt.Errorf("hash_algorithms cannot be combined with hash_key")
+		case c.SegmentSize > 0:
+			return fmt.Errorf("hash_algorithms cannot be combined with segment_size")
+		case len(c.DescendArchives) > 0:
+			return fmt.Errorf("hash_algorithms cannot be combined with descend_archives")
+		case len(c.Fingerprint) > 0:
+			return fmt.Errorf("hash_algorithms cannot be combined with fingerprint rules")
+		}
+	}
+	return nil
+}
+
+// DigestAlgorithms returns the algorithms of HashAlgorithms after the
+// first, in lower case: those of the digests recorded beside the leaf hash
+func (c *Config) DigestAlgorithms() []string {
+	var others []string
+	for i, algorithm := range c.HashAlgorithms {
+		if i > 0 {
+			others = append(others, strings.ToLower(algorithm))
+		}
+	}
+	return others
+}
+
+// ScanHash returns a hash of the settings that decide which files a scan
+// includes and how their content is hashed, so that trees scanned with
+// different settings can be told apart. The order of skip patterns does not
+// matter.
+func (c *Config) ScanHash() string {
+	settings := struct {
+		Skip            []string          `json:"skip"`
+		SkipRegex       []string          `json:"skip_regex,omitempty"`
+		OneFileSystem   bool              `json:"one_file_system"`
+		ExcludeCaches   bool              `json:"exclude_caches,omitempty"`
+		ExcludeMarkers  []string          `json:"exclude_if_present,omitempty"`
+		FilterCmd       string            `json:"filter_cmd"`
+		Portable        bool              `json:"portable"`
+		BytewiseOrder   bool              `json:"bytewise_order,omitempty"`
+		EmptyDirs       bool              `json:"empty_dirs"`
+		DescendArchives []string          `json:"descend_archives"`
+		HashAlgorithm   string            `json:"hash_algorithm"`
+		SegmentSize     int64             `json:"segment_size"`
+		ChunkSize       int               `json:"chunk_size"`
+		Fingerprint     []FingerprintRule `json:"fingerprint,omitempty"`
+		HashKeyID       string            `json:"hash_key_id,omitempty"`
+	}{
+		Skip:            slices.Sorted(slices.Values(c.Skip)),
+		SkipRegex:       c.SkipRegex,
+		OneFileSystem:   c.OneFileSystem,
+		ExcludeCaches:   c.ExcludeCaches,
+		ExcludeMarkers:  slices.Sorted(slices.Values(c.ExcludeIfPresent)),
+		FilterCmd:       c.FilterCmd,
+		Portable:        c.Portable,
+		BytewiseOrder:   c.BytewiseOrder,
+		EmptyDirs:       c.EmptyDirs,
+		DescendArchives: slices.Sorted(slices.Values(c.DescendArchives)),
+		HashAlgorithm:   c.HashAlgorithm,
+		SegmentSize:     c.SegmentSize,
+		ChunkSize:       c.ChunkSize,
+		Fingerprint:     c.Fingerprint,
+	}
+	if strings.EqualFold(c.HashAlgorithm, hash.HMACSHA256) && c.hashKey != nil {
+		settings.HashKeyID = hash.KeyID(c.hashKey)
+	}
+	data, _ := json.Marshal(settings)
+	digest, _ := hash.XXHashFunc(data)
+	return hex.EncodeToString(digest)
+}
+
+// LoadHashKey reads the HashKey file, if set, and switches HashAlgorithm to
+// HMAC-SHA256. Commands call it once the config is complete; HashKeyBytes
+// then returns the key.
+func (c *Config) LoadHashKey() error {
+	if c.HashKey == "" {
+		return nil
+	}
+	key, err := ReadHashKey(c.HashKey)
+	if err != nil {
+		return err
+	}
+	c.hashKey = key
+	c.HashAlgorithm = hash.HMACSHA256
+	return nil
+}
+
+// ReadHashKey reads a hash key file, refusing empty ones
+func ReadHashKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hash_key: %w", err)
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("hash_key file %s is empty", path)
+	}
+	return key, nil
+}
+
+// HashKeyBytes returns the key read by LoadHashKey, or nil
+func (c *Config) HashKeyBytes() []byte {
+	return c.hashKey
+}
+
+// RetryDelayDuration returns the parsed retry_delay, 500ms if it is unset
+func (c *Config) RetryDelayDuration() (time.Duration, error) {
+	if c.RetryDelay == "" {
+		return 500 * time.Millisecond, nil
+	}
+	delay, err := time.ParseDuration(c.RetryDelay)
+	if err != nil || delay < 0 {
+		return 0, fmt.Errorf("invalid retry_delay %q (want a duration like 500ms)", c.RetryDelay)
+	}
+	return delay, nil
+}
+
+// WatchDebounceDuration returns the parsed watch_debounce, 0 (the watch
+// default) if it is unset
+func (c *Config) WatchDebounceDuration() (time.Duration, error) {
+	if c.WatchDebounce == "" {
+		return 0, nil
+	}
+	debounce, err := time.ParseDuration(c.WatchDebounce)
+	if err != nil || debounce < 0 {
+		return 0, fmt.Errorf("invalid watch_debounce %q (want a duration like 2s)", c.WatchDebounce)
+	}
+	return debounce, nil
+}
+
+// FingerprintFor returns the bytes the fingerprint rules sample from each end
+// of the file at relPath, relative to the scanned directory, or 0 to hash it
+// whole
+func (c *Config) FingerprintFor(relPath string) int64 {
+	var sample int64
+	for _, rule := range c.Fingerprint {
+		if pathmatch.MatchAny(rule.Paths, relPath) {
+			sample = rule.Bytes
+		}
+	}
+	return sample
+}
+
+// TagsFor returns the tags the tag rules give the file at relPath, relative
+// to the scanned directory, or nil if none apply
+func (c *Config) TagsFor(relPath string) map[string]string {
+	var tags map[string]string
+	for _, rule := range c.Tags {
+		if !pathmatch.MatchAny(rule.Paths, relPath) {
+			continue
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		for key, value := range rule.Set {
+			tags[key] = value
+		}
+	}
+	return tags
 }
 
 func DefaultConfig() *Config {
@@ -44,6 +630,11 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
+	return Parse(data)
+}
+
+// Parse decodes a config file's content, e.g. one stored in a bundle
+func Parse(data []byte) (*Config, error) {
 	var cfg Config
 	if err := toml.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse config TOML: %w", err)
@@ -1,9 +1,12 @@
 package config
 
 import (
+	"maps"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadConfig_ValidConfig(t *testing.T) {
@@ -133,3 +136,342 @@ func TestDefaultConfig(t *testing.T) {
 		t.Errorf("Expected default output_file to be empty, got %q", cfg.OutputFile)
 	}
 }
+
+func TestApplyProfile(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.toml")
+
+	configContent := `skip = [".git/"]
+
+[profiles.media]
+skip = ["*.thumbnails/", "*.xmp"]
+read_strategy = "dontneed"
+
+[profiles.code]
+skip = ["node_modules/", "vendor/", "*.o"]
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if err := cfg.ApplyProfile("media"); err != nil {
+		t.Fatalf("ApplyProfile failed: %v", err)
+	}
+	if len(cfg.Skip) != 2 || cfg.Skip[1] != "*.xmp" {
+		t.Errorf("Expected media skip list, got %v", cfg.Skip)
+	}
+	if cfg.ReadStrategy != "dontneed" {
+		t.Errorf("Expected read strategy dontneed, got %q", cfg.ReadStrategy)
+	}
+
+	if err := cfg.ApplyProfile("photos"); err == nil {
+		t.Error("Expected an error for an unknown profile")
+	}
+}
+
+func TestApplyTarget(t *testing.T) {
+	cfg := &Config{Skip: []string{".git/"}, Targets: []Target{
+		{Name: "photos", Path: "/srv/photos", Skip: []string{"*.xmp"}, Output: "photos.json"},
+		{Name: "home", Path: "/home", Output: "home.json"},
+	}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid targets, got %v", err)
+	}
+	if err := cfg.ApplyTarget("home"); err != nil {
+		t.Fatalf("ApplyTarget failed: %v", err)
+	}
+	if len(cfg.Skip) != 1 || cfg.Skip[0] != ".git/" || cfg.OutputFile != "home.json" {
+		t.Errorf("Expected the top-level skip list and home.json, got %v and %q", cfg.Skip, cfg.OutputFile)
+	}
+	if err := cfg.ApplyTarget("photos"); err != nil || cfg.Skip[0] != "*.xmp" {
+		t.Errorf("Expected the photos skip list, got %v (%v)", cfg.Skip, err)
+	}
+	if err := cfg.ApplyTarget("music"); err == nil {
+		t.Error("Expected an error for an unknown target")
+	}
+
+	for _, targets := range [][]Target{
+		{{Path: "/srv", Output: "a.json"}},
+		{{Name: "a", Path: "/srv"}},
+		{{Name: "a", Path: "/srv", Output: "a.json"}, {Name: "a", Path: "/home", Output: "b.json"}},
+	} {
+		if err := (&Config{Targets: targets}).Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", targets)
+		}
+	}
+}
+
+func TestApplyDirConfig(t *testing.T) {
+	cfg := DefaultConfig()
+
+	tmpDir := t.TempDir()
+	path, err := cfg.ApplyDirConfig(tmpDir)
+	if err != nil || path != "" {
+		t.Fatalf("Expected no directory config, got %q, %v", path, err)
+	}
+
+	dirConfig := `skip = ["cache/"]
+
+[profiles.fast]
+read_strategy = "mmap"
+`
+	if err := os.WriteFile(filepath.Join(tmpDir, DirConfigName), []byte(dirConfig), 0644); err != nil {
+		t.Fatalf("Failed to write directory config: %v", err)
+	}
+
+	path, err = cfg.ApplyDirConfig(tmpDir)
+	if err != nil {
+		t.Fatalf("ApplyDirConfig failed: %v", err)
+	}
+	if path != filepath.Join(tmpDir, DirConfigName) {
+		t.Errorf("Expected applied path to be reported, got %q", path)
+	}
+	if len(cfg.Skip) != 1 || cfg.Skip[0] != "cache/" {
+		t.Errorf("Expected directory skip list to replace the default, got %v", cfg.Skip)
+	}
+	if err := cfg.ApplyProfile("fast"); err != nil {
+		t.Errorf("Expected profile from directory config to be available: %v", err)
+	}
+}
+
+func TestTagsFor(t *testing.T) {
+	cfg, err := Parse([]byte(`
+[[tags]]
+paths = ["finance/"]
+set = { owner = "finance", classification = "internal" }
+
+[[tags]]
+paths = ["*.xlsx", "hr/**/*.pdf"]
+set = { classification = "confidential" }
+`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want map[string]string
+	}{
+		{"finance/q3/report.txt", map[string]string{"owner": "finance", "classification": "internal"}},
+		{"finance/ledger.xlsx", map[string]string{"owner": "finance", "classification": "confidential"}},
+		{"hr/2026/review.pdf", map[string]string{"classification": "confidential"}},
+		{"docs/readme.md", nil},
+	}
+	for _, tt := range tests {
+		if got := cfg.TagsFor(tt.path); !maps.Equal(got, tt.want) {
+			t.Errorf("TagsFor(%s): expected %v, got %v", tt.path, tt.want, got)
+		}
+	}
+
+	cfg.Tags = append(cfg.Tags, TagRule{Set: map[string]string{"owner": "nobody"}})
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected an error for a tag rule without paths")
+	}
+}
+
+func TestFingerprintFor(t *testing.T) {
+	cfg, err := Parse([]byte(`
+[[fingerprint]]
+paths = ["*.mkv", "*.mp4"]
+bytes = 4194304
+
+[[fingerprint]]
+paths = ["raw/"]
+bytes = 1048576
+`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	for path, want := range map[string]int64{"films/a.mkv": 4194304, "raw/b.mp4": 1048576, "docs/c.txt": 0} {
+		if got := cfg.FingerprintFor(path); got != want {
+			t.Errorf("FingerprintFor(%s): expected %d, got %d", path, want, got)
+		}
+	}
+
+	unsampled := (&Config{}).ScanHash()
+	if cfg.ScanHash() == unsampled {
+		t.Error("Expected the fingerprint rules to change the scan hash")
+	}
+	cfg.Fingerprint = append(cfg.Fingerprint, FingerprintRule{Paths: []string{"*.iso"}})
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected an error for a fingerprint rule without bytes")
+	}
+}
+
+func TestRetryDelayDuration(t *testing.T) {
+	cfg := &Config{}
+	if delay, err := cfg.RetryDelayDuration(); err != nil || delay != 500*time.Millisecond {
+		t.Errorf("Expected default delay of 500ms, got %v (%v)", delay, err)
+	}
+
+	cfg.RetryDelay = "2s"
+	if delay, err := cfg.RetryDelayDuration(); err != nil || delay != 2*time.Second {
+		t.Errorf("Expected delay of 2s, got %v (%v)", delay, err)
+	}
+
+	for _, bad := range []string{"soon", "-1s"} {
+		cfg.RetryDelay = bad
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected retry_delay %q to be rejected", bad)
+		}
+	}
+
+	cfg = &Config{IORetries: -1}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected negative io_retries to be rejected")
+	}
+}
+
+func TestValidate_AlertRules(t *testing.T) {
+	if err := (&Config{AlertThreshold: 500, AlertPercentile: 95, AlertMinHistory: 5}).Validate(); err != nil {
+		t.Errorf("Expected valid alert rules, got %v", err)
+	}
+	for _, cfg := range []*Config{{AlertThreshold: -1}, {AlertPercentile: 100}, {AlertPercentile: -5}, {AlertMinHistory: -1}} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", *cfg)
+		}
+	}
+}
+
+func TestValidate_Watch(t *testing.T) {
+	cfg := &Config{WatchDebounce: "500ms", WatchBatch: 200}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid watch settings, got %v", err)
+	}
+	if debounce, _ := cfg.WatchDebounceDuration(); debounce != 500*time.Millisecond {
+		t.Errorf("Expected 500ms debounce, got %v", debounce)
+	}
+	for _, cfg := range []*Config{{WatchDebounce: "soon"}, {WatchDebounce: "-1s"}, {WatchBatch: -1}} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", *cfg)
+		}
+	}
+}
+
+func TestValidate_ExcludeIfPresent(t *testing.T) {
+	if err := (&Config{ExcludeIfPresent: []string{".nobackup", "CACHEDIR.TAG"}}).Validate(); err != nil {
+		t.Errorf("Expected valid marker names, got %v", err)
+	}
+	for _, name := range []string{"", "..", "sub/.nobackup"} {
+		if err := (&Config{ExcludeIfPresent: []string{name}}).Validate(); err == nil {
+			t.Errorf("Expected marker %q to be rejected", name)
+		}
+	}
+}
+
+func TestValidate_SkipRegex(t *testing.T) {
+	if err := (&Config{SkipRegex: []string{"/cache/", "!^/important/"}}).Validate(); err != nil {
+		t.Errorf("Expected valid skip_regex rules, got %v", err)
+	}
+	err := (&Config{SkipRegex: []string{"/cache/", "(unclosed"}}).Validate()
+	if err == nil || !strings.Contains(err.Error(), "(unclosed") {
+		t.Errorf("Expected the malformed rule to be named, got %v", err)
+	}
+}
+
+func TestValidate_HashAlgorithms(t *testing.T) {
+	cfg := &Config{HashAlgorithms: []string{"xxh64", "SHA256"}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid hash algorithms, got %v", err)
+	}
+	if others := cfg.DigestAlgorithms(); len(others) != 1 || others[0] != "sha256" {
+		t.Errorf("Expected sha256 to be recorded beside the leaf hash, got %v", others)
+	}
+	if err := (&Config{HashAlgorithm: "xxh64", HashAlgorithms: []string{"xxh64"}}).Validate(); err != nil {
+		t.Errorf("Expected a matching hash_algorithm to be accepted, got %v", err)
+	}
+	for _, cfg := range []*Config{
+		{HashAlgorithms: []string{"xxh64", "crc7"}},
+		{HashAlgorithms: []string{"sha256", "sha256"}},
+		{HashAlgorithms: []string{"hmac-sha256"}},
+		{HashAlgorithm: "md5", HashAlgorithms: []string{"xxh64", "md5"}},
+		{HashAlgorithms: []string{"xxh64", "sha256"}, SegmentSize: 1 << 20},
+		{HashAlgorithms: []string{"xxh64", "sha256"}, DescendArchives: []string{"tar"}},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", *cfg)
+		}
+	}
+}
+
+func TestLoadHashKey(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyPath, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{HashKey: keyPath, HashAlgorithm: "sha256"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected a valid keyed config, got %v", err)
+	}
+	unkeyed := cfg.ScanHash()
+	if err := cfg.LoadHashKey(); err != nil {
+		t.Fatalf("LoadHashKey failed: %v", err)
+	}
+	if cfg.HashAlgorithm != "hmac-sha256" || string(cfg.HashKeyBytes()) != "secret" {
+		t.Errorf("Expected hmac-sha256 keyed with the file, got %s and %q", cfg.HashAlgorithm, cfg.HashKeyBytes())
+	}
+
+	// Another key is another scan setting
+	otherPath := filepath.Join(t.TempDir(), "other")
+	if err := os.WriteFile(otherPath, []byte("other"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	other := &Config{HashKey: otherPath}
+	if err := other.LoadHashKey(); err != nil {
+		t.Fatalf("LoadHashKey failed: %v", err)
+	}
+	if cfg.ScanHash() == other.ScanHash() || cfg.ScanHash() == unkeyed {
+		t.Error("Expected the key to change the scan hash")
+	}
+
+	for _, bad := range []*Config{
+		{HashKey: keyPath, HashAlgorithm: "md5"},
+		{HashKey: keyPath, DescendArchives: []string{"tar"}},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", *bad)
+		}
+	}
+	emptyPath := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(emptyPath, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{emptyPath, filepath.Join(t.TempDir(), "missing")} {
+		if err := (&Config{HashKey: path}).LoadHashKey(); err == nil {
+			t.Errorf("Expected key file %s to be rejected", path)
+		}
+	}
+}
+
+func TestScanHash(t *testing.T) {
+	cfg := &Config{Skip: []string{"*.log", "tmp/"}}
+	reordered := &Config{Skip: []string{"tmp/", "*.log"}, Workers: 8, HashCache: "off"}
+	if cfg.ScanHash() != reordered.ScanHash() {
+		t.Error("Expected skip order and settings that do not affect the scan to leave the hash unchanged")
+	}
+
+	for name, changed := range map[string]*Config{
+		"skip":               {Skip: []string{"*.log"}},
+		"one_file_system":    {Skip: cfg.Skip, OneFileSystem: true},
+		"filter_cmd":         {Skip: cfg.Skip, FilterCmd: "./filter"},
+		"hash_algorithm":     {Skip: cfg.Skip, HashAlgorithm: "sha256"},
+		"bytewise_order":     {Skip: cfg.Skip, BytewiseOrder: true},
+		"exclude_caches":     {Skip: cfg.Skip, ExcludeCaches: true},
+		"exclude_if_present": {Skip: cfg.Skip, ExcludeIfPresent: []string{".nobackup"}},
+	} {
+		if changed.ScanHash() == cfg.ScanHash() {
+			t.Errorf("Expected a different %s to change the hash", name)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Package fleet is the agent/controller protocol for verifying the files of
+// many hosts. Agents fetch the manifest a controller expects for their host,
+// scan, and report the root hash and differences back. The protocol runs
+// over gRPC with JSON-encoded messages, so no code generation is needed;
+// proto/fleet.proto documents the service.
+package fleet
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+
+	"merkle-go/internal/compare"
+	"merkle-go/internal/tree"
+)
+
+// ServiceName is the full gRPC name of the controller service
+const ServiceName = "merklego.fleet.v1.Controller"
+
+type ExpectedRequest struct {
+	Host string `json:"host"`
+}
+
+type ExpectedResponse struct {
+	Manifest []byte `json:"manifest"` // merkle-go JSON manifest
+}
+
+type EnrollRequest struct {
+	Host     string `json:"host"`
+	Manifest []byte `json:"manifest"`
+}
+
+type EnrollResponse struct {
+	Root string `json:"root"`
+}
+
+// ReportRequest is the result of one scan by an agent. Paths are relative
+// to RootPath and use forward slashes.
+type ReportRequest struct {
+	Host     string    `json:"host"`
+	RootPath string    `json:"root_path"`
+	Root     string    `json:"root"`
+	Time     time.Time `json:"time"`
+	Added    []string  `json:"added,omitempty"`
+	Modified []string  `json:"modified,omitempty"`
+	Deleted  []string  `json:"deleted,omitempty"`
+	Renamed  []string  `json:"renamed,omitempty"` // "old/path -> new/path"
+	Errors   int       `json:"errors"`
+}
+
+// HasChanges reports whether the scan found any difference
+func (r *ReportRequest) HasChanges() bool {
+	return len(r.Added) > 0 || len(r.Modified) > 0 || len(r.Deleted) > 0 || len(r.Renamed) > 0
+}
+
+type ReportResponse struct {
+	Match        bool   `json:"match"` // the reported root equals the expected root
+	ExpectedRoot string `json:"expected_root"`
+}
+
+// ControllerServer is implemented by controllers; see Store
+type ControllerServer interface {
+	// Expected returns the manifest expected for a host, or a NotFound
+	// status if the host has not been enrolled
+	Expected(ctx context.Context, req *ExpectedRequest) (*ExpectedResponse, error)
+
+	// Enroll stores a manifest as the expected state of a host, or an
+	// AlreadyExists status if the host already has one
+	Enroll(ctx context.Context, req *EnrollRequest) (*EnrollResponse, error)
+
+	// Report records the result of a scan. The root hash is the one the
+	// agent computed; the controller cannot check it against the files.
+	Report(ctx context.Context, req *ReportRequest) (*ReportResponse, error)
+}
+
+// jsonCodec encodes messages as JSON instead of protobuf
+type jsonCodec struct{}
+
+// codecName is the gRPC content subtype of jsonCodec
+const codecName = "json"
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return codecName }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// unaryHandler adapts a ControllerServer method to a gRPC method handler
+func unaryHandler[Req, Resp any](method string, call func(ControllerServer, context.Context, *Req) (*Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(ControllerServer), ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/" + method}
+			return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+				return call(srv.(ControllerServer), ctx, req.(*Req))
+			})
+		},
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*ControllerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryHandler("Expected", ControllerServer.Expected),
+		unaryHandler("Enroll", ControllerServer.Enroll),
+		unaryHandler("Report", ControllerServer.Report),
+	},
+	Metadata: "proto/fleet.proto",
+}
+
+// RegisterControllerServer serves srv on s
+func RegisterControllerServer(s grpc.ServiceRegistrar, srv ControllerServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+// Client calls a controller
+type Client struct {
+	conn grpc.ClientConnInterface
+}
+
+// NewClient returns a client using conn
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{conn: conn}
+}
+
+func (c *Client) invoke(ctx context.Context, method string, req, resp any) error {
+	return c.conn.Invoke(ctx, "/"+ServiceName+"/"+method, req, resp, grpc.CallContentSubtype(codecName))
+}
+
+func (c *Client) Expected(ctx context.Context, req *ExpectedRequest) (*ExpectedResponse, error) {
+	resp := new(ExpectedResponse)
+	if err := c.invoke(ctx, "Expected", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) Enroll(ctx context.Context, req *EnrollRequest) (*EnrollResponse, error) {
+	resp := new(EnrollResponse)
+	if err := c.invoke(ctx, "Enroll", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) Report(ctx context.Context, req *ReportRequest) (*ReportResponse, error) {
+	resp := new(ReportResponse)
+	if err := c.invoke(ctx, "Report", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// NewReport describes the differences between the expected tree of host
+// and the current one, found by comparing them
+func NewReport(host string, current *tree.MerkleTree, result *compare.CompareResult, errs int) *ReportRequest {
+	relPath := func(path string) string {
+		relPath, err := filepath.Rel(current.RootPath, path)
+		if err != nil {
+			relPath = path
+		}
+		return filepath.ToSlash(relPath)
+	}
+	relPaths := func(changes []compare.Change) []string {
+		paths := make([]string, 0, len(changes))
+		for _, change := range changes {
+			if change.OldPath != "" {
+				paths = append(paths, relPath(change.OldPath)+" -> "+relPath(change.Path))
+				continue
+			}
+			paths = append(paths, relPath(change.Path))
+		}
+		return paths
+	}
+	return &ReportRequest{
+		Host:     host,
+		RootPath: current.RootPath,
+		Root:     current.Root.Hash,
+		Time:     time.Now().UTC(),
+		Added:    relPaths(result.Added),
+		Modified: relPaths(slices.Concat(result.Modified, result.MetadataOnly)),
+		Deleted:  relPaths(result.Deleted),
+		Renamed:  relPaths(slices.Concat(result.Renamed, result.CaseRenamed)),
+		Errors:   errs,
+	}
+}
@@ -0,0 +1,259 @@
+package fleet
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"merkle-go/internal/compare"
+	"merkle-go/internal/tree"
+)
+
+// startController serves a store over an in-memory connection
+func startController(t *testing.T) (*Client, *Store) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	listener := serve(t, store)
+	return dial(t, listener, insecure.NewCredentials()), store
+}
+
+func serve(t *testing.T, store *Store, opts ...grpc.ServerOption) *bufconn.Listener {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(opts...)
+	RegisterControllerServer(server, store)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener
+}
+
+func dial(t *testing.T, listener *bufconn.Listener, creds credentials.TransportCredentials) *Client {
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(creds))
+	if err != nil {
+		t.Fatalf("Failed to dial controller: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewClient(conn)
+}
+
+func buildTree(t *testing.T, files map[string]tree.FileData) *tree.MerkleTree {
+	merkleTree, err := tree.Build(files, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	return merkleTree
+}
+
+func TestController_EnrollAndReport(t *testing.T) {
+	client, store := startController(t)
+	ctx := context.Background()
+
+	_, err := client.Expected(ctx, &ExpectedRequest{Host: "web1"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected NotFound for an unknown host, got %v", err)
+	}
+
+	expected := buildTree(t, map[string]tree.FileData{
+		"/data/a.txt": {Hash: "0000000000000001", Size: 1},
+		"/data/b.txt": {Hash: "0000000000000002", Size: 2},
+	})
+	manifest, err := tree.Encode(expected)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	enrolled, err := client.Enroll(ctx, &EnrollRequest{Host: "web1", Manifest: manifest})
+	if err != nil {
+		t.Fatalf("Enroll failed: %v", err)
+	}
+	if enrolled.Root != expected.Root.Hash {
+		t.Errorf("Expected enrolled root %s, got %s", expected.Root.Hash, enrolled.Root)
+	}
+
+	resp, err := client.Expected(ctx, &ExpectedRequest{Host: "web1"})
+	if err != nil {
+		t.Fatalf("Expected failed: %v", err)
+	}
+	fetched, err := tree.Decode(resp.Manifest)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	current := buildTree(t, map[string]tree.FileData{
+		"/data/a.txt": {Hash: "0000000000000001", Size: 1},
+		"/data/b.txt": {Hash: "00000000000000ff", Size: 2},
+	})
+	report := NewReport("web1", current, compare.Compare(fetched, current), 0)
+	if len(report.Modified) != 1 || report.Modified[0] != "b.txt" {
+		t.Errorf("Expected b.txt to be reported as modified, got %v", report.Modified)
+	}
+
+	ack, err := client.Report(ctx, report)
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	if ack.Match || ack.ExpectedRoot != expected.Root.Hash {
+		t.Errorf("Expected a mismatch against %s, got %+v", expected.Root.Hash, ack)
+	}
+
+	stored, err := store.LoadReport("web1")
+	if err != nil {
+		t.Fatalf("LoadReport failed: %v", err)
+	}
+	if stored.Root != current.Root.Hash {
+		t.Errorf("Expected stored report root %s, got %s", current.Root.Hash, stored.Root)
+	}
+}
+
+func TestNewReport_LeavesResultIntact(t *testing.T) {
+	current, err := tree.Build(map[string]tree.FileData{"/data/a": {Hash: "0011223344556677"}}, "/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Spare capacity after Modified must not receive MetadataOnly
+	modified := make([]compare.Change, 1, 2)
+	modified[0] = compare.Change{Path: "/data/a"}
+	result := &compare.CompareResult{
+		Modified:     modified,
+		MetadataOnly: []compare.Change{{Path: "/data/b"}},
+	}
+	report := NewReport("web1", current, result, 0)
+	if len(report.Modified) != 2 {
+		t.Errorf("Expected modified and metadata-only files in the report, got %v", report.Modified)
+	}
+	if extra := modified[:2][1]; extra.Path != "" {
+		t.Errorf("Expected the result's Modified array to be left alone, got %q after it", extra.Path)
+	}
+}
+
+func TestController_RejectsBadHost(t *testing.T) {
+	client, _ := startController(t)
+
+	_, err := client.Expected(context.Background(), &ExpectedRequest{Host: "../etc"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for a path-like host, got %v", err)
+	}
+}
+
+func TestController_RefusesReenroll(t *testing.T) {
+	client, store := startController(t)
+	ctx := context.Background()
+
+	manifest, err := tree.Encode(buildTree(t, map[string]tree.FileData{"/data/a.txt": {Hash: "0000000000000001", Size: 1}}))
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if _, err := client.Enroll(ctx, &EnrollRequest{Host: "web1", Manifest: manifest}); err != nil {
+		t.Fatalf("Enroll failed: %v", err)
+	}
+	tampered, err := tree.Encode(buildTree(t, map[string]tree.FileData{"/data/a.txt": {Hash: "00000000000000ff", Size: 1}}))
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if _, err := client.Enroll(ctx, &EnrollRequest{Host: "web1", Manifest: tampered}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Expected AlreadyExists when enrolling twice, got %v", err)
+	}
+	if expected, err := store.LoadExpected("web1"); err != nil || expected.Files["/data/a.txt"].Hash != "0000000000000001" {
+		t.Errorf("Expected the first manifest to be kept, got %v (%v)", expected, err)
+	}
+
+	store.AllowReenroll = true
+	if _, err := client.Enroll(ctx, &EnrollRequest{Host: "web1", Manifest: tampered}); err != nil {
+		t.Errorf("Expected re-enrollment to be allowed, got %v", err)
+	}
+}
+
+// issue returns a certificate for name signed by parent, or a self-signed
+// CA certificate if parent is nil
+func issue(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, any(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestController_ClientCertificates(t *testing.T) {
+	ca := issue(t, "fleet CA", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	store.RequireClientCert = true
+	listener := serve(t, store, grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{issue(t, "controller", &ca)},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})))
+
+	clientCert := issue(t, "web1", &ca)
+	client := dial(t, listener, credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      pool,
+		ServerName:   "controller",
+	}))
+	ctx := context.Background()
+	if _, err := client.Expected(ctx, &ExpectedRequest{Host: "web1"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected web1 to be served, got %v", err)
+	}
+	if _, err := client.Expected(ctx, &ExpectedRequest{Host: "web2"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected the web1 certificate to be refused for web2, got %v", err)
+	}
+	report := &ReportRequest{Host: "web2", Root: "0000000000000001"}
+	if _, err := client.Report(ctx, report); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected a report for web2 to be refused, got %v", err)
+	}
+
+	anonymous := dial(t, listener, credentials.NewTLS(&tls.Config{RootCAs: pool, ServerName: "controller"}))
+	if _, err := anonymous.Expected(ctx, &ExpectedRequest{Host: "web1"}); err == nil {
+		t.Error("Expected a client without a certificate to be refused")
+	}
+}
@@ -0,0 +1,287 @@
+package fleet
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pelletier/go-toml/v2"
+
+	"merkle-go/internal/compare"
+	"merkle-go/internal/config"
+	"merkle-go/internal/hash"
+	"merkle-go/internal/tree"
+)
+
+// Host is a host to scan over SSH: a destination ssh accepts, such as
+// user@web1, and the directory to scan there
+type Host struct {
+	Name      string `json:"host"`
+	Directory string `json:"directory"`
+}
+
+// ParseHosts reads a hosts file: one host per line, optionally followed by
+// the directory to scan on it, which defaults to directory. Blank lines and
+// lines starting with # are ignored.
+func ParseHosts(r io.Reader, directory string) ([]Host, error) {
+	var hosts []Host
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		host := Host{Directory: directory}
+		name, dir, hasDir := strings.Cut(text, " ")
+		host.Name = name
+		if hasDir {
+			host.Directory = strings.TrimSpace(dir)
+		}
+		if seen[host.Name] {
+			return nil, fmt.Errorf("line %d: host %s is listed twice", line, host.Name)
+		}
+		seen[host.Name] = true
+		hosts = append(hosts, host)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read hosts: %w", err)
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("no hosts listed")
+	}
+	return hosts, nil
+}
+
+// SSHOptions configures scans over SSH
+type SSHOptions struct {
+	// SSH is the ssh program and the options to run it with; the host and
+	// remote command are appended. Defaults to ssh -o BatchMode=yes, so a
+	// host asking for a password fails instead of waiting.
+	SSH []string
+
+	// Command is the merkle-go program on the remote hosts; defaults to
+	// merkle-go
+	Command string
+
+	// Skip and SkipRegex are the skip patterns and skip_regex rules of the
+	// remote scans if the expected manifest does not record its own
+	Skip      []string
+	SkipRegex []string
+
+	// Parallel is the number of hosts scanned at once; 0 means 8
+	Parallel int
+
+	// HashKey is the path of the hash key file on the hosts, needed for
+	// manifests hashed with hash.HMACSHA256. The key itself never leaves
+	// the hosts.
+	HashKey string
+}
+
+// remoteConfig is the config sent to remote scans, so they hash files the
+// way the expected manifest was built whatever the remote config says
+type remoteConfig struct {
+	Skip            []string                 `toml:"skip"`
+	SkipRegex       []string                 `toml:"skip_regex,omitempty"`
+	Portable        bool                     `toml:"portable"`
+	BytewiseOrder   bool                     `toml:"bytewise_order,omitempty"`
+	EmptyDirs       bool                     `toml:"empty_dirs"`
+	DescendArchives []string                 `toml:"descend_archives"`
+	HashAlgorithm   string                   `toml:"hash_algorithm"`
+	SegmentSize     int64                    `toml:"segment_size"`
+	Fingerprint     []config.FingerprintRule `toml:"fingerprint,omitempty"`
+	HashKey         string                   `toml:"hash_key,omitempty"`
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// remoteScript is the shell command run on a host: it scans directory with
+// the config read from stdin into a temporary manifest and prints it
+func remoteScript(command, directory string) string {
+	return fmt.Sprintf(`f=$(mktemp) && %s -c /dev/stdin --quiet %s "$f" && cat "$f"; rc=$?; rm -f "$f"; exit $rc`,
+		shellQuote(command), shellQuote(directory))
+}
+
+// ScanOverSSH runs merkle-go on host over SSH, scanning the host's directory
+// the way expected was built, and returns the scanned tree
+func ScanOverSSH(ctx context.Context, host Host, expected *tree.MerkleTree, opts SSHOptions) (*tree.MerkleTree, error) {
+	ssh := opts.SSH
+	if len(ssh) == 0 {
+		ssh = []string{"ssh", "-o", "BatchMode=yes"}
+	}
+	command := opts.Command
+	if command == "" {
+		command = "merkle-go"
+	}
+	cfg := remoteConfig{
+		Skip:            opts.Skip,
+		SkipRegex:       opts.SkipRegex,
+		Portable:        expected.Portable,
+		BytewiseOrder:   expected.BytewiseOrder,
+		EmptyDirs:       expected.EmptyDirs,
+		DescendArchives: expected.Archives,
+		HashAlgorithm:   expected.HashAlgorithm,
+		SegmentSize:     expected.SegmentSize,
+		Fingerprint:     expected.Fingerprint,
+	}
+	if strings.EqualFold(expected.HashAlgorithm, hash.HMACSHA256) {
+		if opts.HashKey == "" {
+			return nil, fmt.Errorf("the manifest is hashed with %s; the hosts need a hash key", hash.HMACSHA256)
+		}
+		cfg.HashKey = opts.HashKey
+	}
+	if expected.Scan != nil {
+		cfg.Skip = expected.Scan.Skip
+		cfg.SkipRegex = expected.Scan.SkipRegex
+	}
+	cfgData, err := toml.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode remote config: %w", err)
+	}
+
+	args := append(ssh[1:len(ssh):len(ssh)], host.Name, remoteScript(command, host.Directory))
+	cmd := exec.CommandContext(ctx, ssh[0], args...)
+	cmd.Stdin = bytes.NewReader(cfgData)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := lastLine(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("remote scan failed: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("remote scan failed: %w", err)
+	}
+	scanned, err := tree.Decode(stdout.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest from remote scan: %w", err)
+	}
+	return scanned, nil
+}
+
+// lastLine returns the last non-empty line of s, which is where ssh and
+// merkle-go put the reason they failed
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// HostResult is the outcome of scanning one host against the expected
+// manifest
+type HostResult struct {
+	Host      string         `json:"host"`
+	Directory string         `json:"directory"`
+	Root      string         `json:"root,omitempty"`
+	Match     bool           `json:"match"`
+	Error     string         `json:"error,omitempty"` // the scan failed
+	Report    *ReportRequest `json:"report,omitempty"`
+}
+
+// CheckHosts scans every host over SSH, several at a time, and compares it
+// with expected. Results are in the order of hosts.
+func CheckHosts(ctx context.Context, hosts []Host, expected *tree.MerkleTree, opts SSHOptions) []HostResult {
+	parallel := opts.Parallel
+	if parallel <= 0 {
+		parallel = 8
+	}
+	results := make([]HostResult, len(hosts))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = scanHost(ctx, host, expected, opts)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// scanHost scans one host and compares it with expected
+func scanHost(ctx context.Context, host Host, expected *tree.MerkleTree, opts SSHOptions) HostResult {
+	result := HostResult{Host: host.Name, Directory: host.Directory}
+	scanned, err := ScanOverSSH(ctx, host, expected, opts)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Root = scanned.Root.Hash
+	result.Match = scanned.Root.Hash == expected.Root.Hash
+
+	aligned, err := compare.AlignRoots(expected, scanned)
+	if err != nil && !errors.Is(err, compare.ErrRootMismatch) {
+		result.Error = err.Error()
+		return result
+	}
+	result.Report = NewReport(host.Name, scanned, compare.Compare(aligned, scanned), len(scanned.Errors))
+	return result
+}
+
+// WriteHostsReport writes one line per host, whether it matches the
+// expected root, followed by the differences of the hosts that do not
+func WriteHostsReport(w io.Writer, expectedRoot string, results []HostResult) error {
+	var b strings.Builder
+	matching, differing, failed := 0, 0, 0
+	width := 0
+	for _, result := range results {
+		width = max(width, len(result.Host))
+		switch {
+		case result.Error != "":
+			failed++
+		case result.Match:
+			matching++
+		default:
+			differing++
+		}
+	}
+	fmt.Fprintf(&b, "Checked %d hosts against root %s: %d match, %d differ, %d failed\n\n",
+		len(results), expectedRoot, matching, differing, failed)
+
+	for _, result := range results {
+		switch {
+		case result.Error != "":
+			fmt.Fprintf(&b, "  %-*s  FAILED   %s\n", width, result.Host, result.Error)
+		case result.Match:
+			fmt.Fprintf(&b, "  %-*s  match    %s\n", width, result.Host, result.Root)
+		default:
+			r := result.Report
+			fmt.Fprintf(&b, "  %-*s  DIFFERS  %s  (%d added, %d modified, %d deleted, %d renamed)\n", width, result.Host, result.Root,
+				len(r.Added), len(r.Modified), len(r.Deleted), len(r.Renamed))
+		}
+	}
+
+	for _, result := range results {
+		if result.Error != "" || result.Match || result.Report == nil {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s (%s):\n", result.Host, result.Directory)
+		r := result.Report
+		for _, section := range []struct {
+			name  string
+			paths []string
+		}{{"added", r.Added}, {"modified", r.Modified}, {"deleted", r.Deleted}, {"renamed", r.Renamed}} {
+			paths := append([]string(nil), section.paths...)
+			sort.Strings(paths)
+			for _, path := range paths {
+				fmt.Fprintf(&b, "  %-8s  %s\n", section.name, path)
+			}
+		}
+		if r.Errors > 0 {
+			fmt.Fprintf(&b, "  %d files could not be read\n", r.Errors)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
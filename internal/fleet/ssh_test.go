@@ -0,0 +1,137 @@
+package fleet
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"merkle-go/internal/tree"
+)
+
+func TestParseHosts(t *testing.T) {
+	hosts, err := ParseHosts(strings.NewReader("web1\n\n# staging\nadmin@web2   /srv/data\n"), "/data")
+	if err != nil {
+		t.Fatalf("ParseHosts failed: %v", err)
+	}
+	expected := []Host{{Name: "web1", Directory: "/data"}, {Name: "admin@web2", Directory: "/srv/data"}}
+	if len(hosts) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, hosts)
+	}
+	for i := range expected {
+		if hosts[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], hosts[i])
+		}
+	}
+
+	if _, err := ParseHosts(strings.NewReader("web1\nweb1\n"), "/data"); err == nil {
+		t.Error("Expected an error for a host listed twice")
+	}
+	if _, err := ParseHosts(strings.NewReader("# nothing\n"), "/data"); err == nil {
+		t.Error("Expected an error for a hosts file without hosts")
+	}
+}
+
+func TestCheckHosts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ssh and remote merkle-go are shell scripts")
+	}
+	dir := t.TempDir()
+	expected := buildTree(t, map[string]tree.FileData{
+		"/data/a.txt": {Hash: "0000000000000001", Size: 1},
+		"/data/b.txt": {Hash: "0000000000000002", Size: 2},
+	})
+	changed := buildTree(t, map[string]tree.FileData{
+		"/data/a.txt": {Hash: "0000000000000001", Size: 1},
+		"/data/b.txt": {Hash: "00000000000000ff", Size: 2},
+	})
+	for name, manifest := range map[string]*tree.MerkleTree{"same": expected, "changed": changed} {
+		if err := tree.Save(manifest, filepath.Join(dir, name+".json")); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	// The fake ssh runs the remote command locally, with the host name in
+	// $HOST; the fake merkle-go copies the manifest named by the directory
+	// to the output path
+	writeScript := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"+content), 0755); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		return path
+	}
+	ssh := writeScript("ssh", `HOST=$1; shift
+if [ "$HOST" = down ]; then echo "ssh: connect to host down port 22: Connection refused" >&2; exit 255; fi
+exec sh -c "$1"
+`)
+	remote := writeScript("merkle-go", `cat > /dev/null
+for arg; do dir=$out; out=$arg; done
+cp "`+dir+`/$(basename "$dir").json" "$out"
+`)
+
+	hosts := []Host{{Name: "web1", Directory: "/srv/same"}, {Name: "web2", Directory: "/srv/changed"}, {Name: "down", Directory: "/data"}}
+	results := CheckHosts(context.Background(), hosts, expected, SSHOptions{SSH: []string{ssh}, Command: remote, Parallel: 2})
+
+	if !results[0].Match || results[0].Error != "" {
+		t.Errorf("Expected web1 to match, got %+v", results[0])
+	}
+	if results[1].Match || results[1].Report == nil || len(results[1].Report.Modified) != 1 || results[1].Report.Modified[0] != "b.txt" {
+		t.Errorf("Expected web2 to differ in b.txt, got %+v", results[1])
+	}
+	if !strings.Contains(results[2].Error, "Connection refused") {
+		t.Errorf("Expected the ssh error for the unreachable host, got %+v", results[2])
+	}
+
+	var report strings.Builder
+	if err := WriteHostsReport(&report, expected.Root.Hash, results); err != nil {
+		t.Fatalf("WriteHostsReport failed: %v", err)
+	}
+	for _, want := range []string{"1 match, 1 differ, 1 failed", "web2  DIFFERS", "modified  b.txt"} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("Expected report to contain %q, got\n%s", want, report.String())
+		}
+	}
+}
+
+func TestScanOverSSH_HashKey(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ssh and remote merkle-go are shell scripts")
+	}
+	dir := t.TempDir()
+	expected, err := tree.BuildWithOptions(map[string]tree.FileData{
+		"/data/a.txt": {Hash: "0000000000000000000000000000000000000000000000000000000000000001", Size: 1},
+	}, "/data", tree.BuildOptions{HashAlgorithm: "hmac-sha256"})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if err := tree.Save(expected, filepath.Join(dir, "data.json")); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// The fake merkle-go keeps the config it was sent
+	ssh := filepath.Join(dir, "ssh")
+	remote := filepath.Join(dir, "merkle-go")
+	if err := os.WriteFile(ssh, []byte("#!/bin/sh\nshift\nexec sh -c \"$1\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\ncat > " + dir + "/config.toml\nfor arg; do out=$arg; done\ncp " + dir + "/data.json \"$out\"\n"
+	if err := os.WriteFile(remote, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := SSHOptions{SSH: []string{ssh}, Command: remote}
+	if _, err := ScanOverSSH(context.Background(), Host{Name: "web1", Directory: "/data"}, expected, opts); err == nil {
+		t.Error("Expected a keyed manifest without a remote key to be rejected")
+	}
+	opts.HashKey = "/etc/merkle-go/hash.key"
+	if _, err := ScanOverSSH(context.Background(), Host{Name: "web1", Directory: "/data"}, expected, opts); err != nil {
+		t.Fatalf("ScanOverSSH failed: %v", err)
+	}
+	sent, _ := os.ReadFile(filepath.Join(dir, "config.toml"))
+	if !strings.Contains(string(sent), `hash_key = '/etc/merkle-go/hash.key'`) {
+		t.Errorf("Expected the remote config to name the key file, got\n%s", sent)
+	}
+}
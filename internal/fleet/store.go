@@ -0,0 +1,179 @@
+package fleet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"merkle-go/internal/atomicfile"
+	"merkle-go/internal/tree"
+)
+
+// hostPattern restricts host names to safe file names
+var hostPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Store is a ControllerServer keeping the expected manifest and the latest
+// report of every host in a directory:
+//
+//	<dir>/manifests/<host>.json
+//	<dir>/reports/<host>.json
+//
+// Reports are taken at their word: the controller has no copy of the host's
+// files, so a match only means the agent reported the expected root. A
+// compromised host can report whatever root it likes; client certificates
+// only keep one host from reporting for another.
+type Store struct {
+	dir string
+	mu  sync.Mutex // Serializes writes
+
+	// AllowReenroll lets Enroll replace the expected manifest of a host
+	// that is already enrolled. Without it, a baseline can only be
+	// replaced by an operator removing it from the store.
+	AllowReenroll bool
+
+	// RequireClientCert only serves callers whose verified TLS client
+	// certificate names the host they ask about (see authorize)
+	RequireClientCert bool
+}
+
+// NewStore returns a store in dir, creating it if needed
+func NewStore(dir string) (*Store, error) {
+	for _, sub := range []string{"manifests", "reports"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create store: %w", err)
+		}
+	}
+	return &Store{dir: dir}, nil
+}
+
+func checkHost(host string) error {
+	if !hostPattern.MatchString(host) {
+		return status.Errorf(codes.InvalidArgument, "invalid host name %q", host)
+	}
+	return nil
+}
+
+func (s *Store) manifestPath(host string) string {
+	return filepath.Join(s.dir, "manifests", host+".json")
+}
+
+func (s *Store) reportPath(host string) string {
+	return filepath.Join(s.dir, "reports", host+".json")
+}
+
+// LoadExpected returns the expected tree of host, or an error wrapping
+// os.ErrNotExist if the host has not been enrolled
+func (s *Store) LoadExpected(host string) (*tree.MerkleTree, error) {
+	if err := checkHost(host); err != nil {
+		return nil, err
+	}
+	return tree.Load(s.manifestPath(host))
+}
+
+// LoadReport returns the latest report of host
+func (s *Store) LoadReport(host string) (*ReportRequest, error) {
+	if err := checkHost(host); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(s.reportPath(host))
+	if err != nil {
+		return nil, err
+	}
+	var report ReportRequest
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse report: %w", err)
+	}
+	return &report, nil
+}
+
+func (s *Store) Expected(ctx context.Context, req *ExpectedRequest) (*ExpectedResponse, error) {
+	if err := checkHost(req.Host); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, req.Host); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(s.manifestPath(req.Host))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, status.Errorf(codes.NotFound, "host %s is not enrolled", req.Host)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read manifest: %v", err)
+	}
+	return &ExpectedResponse{Manifest: data}, nil
+}
+
+// Enroll stores the manifest of a host that has none yet, or, with
+// AllowReenroll, replaces it; the previous manifest is kept as a backup
+func (s *Store) Enroll(ctx context.Context, req *EnrollRequest) (*EnrollResponse, error) {
+	if err := checkHost(req.Host); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, req.Host); err != nil {
+		return nil, err
+	}
+	manifest, err := tree.Decode(req.Manifest)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid manifest: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := os.Stat(s.manifestPath(req.Host)); err == nil && !s.AllowReenroll {
+		return nil, status.Errorf(codes.AlreadyExists, "host %s is already enrolled", req.Host)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, status.Errorf(codes.Internal, "failed to check manifest: %v", err)
+	}
+	if err := tree.SaveWithOptions(manifest, s.manifestPath(req.Host), tree.SaveOptions{Backup: true}); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save manifest: %v", err)
+	}
+	slog.Info("Enrolled host", "host", req.Host, "root", manifest.Root.Hash, "files", len(manifest.Files))
+	return &EnrollResponse{Root: manifest.Root.Hash}, nil
+}
+
+// Report records the report of a host and compares the root it claims with
+// the expected one. The root is not recomputed; see Store.
+func (s *Store) Report(ctx context.Context, req *ReportRequest) (*ReportResponse, error) {
+	if err := checkHost(req.Host); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, req.Host); err != nil {
+		return nil, err
+	}
+	expected, err := s.LoadExpected(req.Host)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, status.Errorf(codes.NotFound, "host %s is not enrolled", req.Host)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load manifest: %v", err)
+	}
+
+	data, err := json.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to encode report: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := atomicfile.WriteFile(s.reportPath(req.Host), data); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save report: %v", err)
+	}
+
+	resp := &ReportResponse{Match: req.Root == expected.Root.Hash, ExpectedRoot: expected.Root.Hash}
+	if resp.Match && req.Errors == 0 {
+		slog.Info("Host matches", "host", req.Host, "root", req.Root)
+	} else {
+		slog.Warn("Host differs from its expected manifest", "host", req.Host, "root", req.Root,
+			"expected", expected.Root.Hash, "added", len(req.Added), "modified", len(req.Modified),
+			"deleted", len(req.Deleted), "renamed", len(req.Renamed), "errors", req.Errors)
+	}
+	return resp, nil
+}
@@ -0,0 +1,47 @@
+package fsinfo
+
+// Volume identifies the filesystem a scanned directory lives on
+type Volume struct {
+	MountPoint string `json:"mount_point,omitempty"`
+	Device     string `json:"device,omitempty"`
+	FSType     string `json:"fs_type,omitempty"`
+	UUID       string `json:"uuid,omitempty"`
+	Label      string `json:"label,omitempty"`
+	DMUUID     string `json:"dm_uuid,omitempty"` // device-mapper UUID, e.g. CRYPT-LUKS2-... for LUKS volumes
+}
+
+// SameVolume reports whether a and b describe the same filesystem. Volumes
+// are matched on the most specific identifier both sides recorded; if
+// neither a UUID nor a label is available they are assumed to match.
+func SameVolume(a, b *Volume) bool {
+	if a == nil || b == nil {
+		return true
+	}
+	switch {
+	case a.UUID != "" && b.UUID != "":
+		return a.UUID == b.UUID
+	case a.DMUUID != "" && b.DMUUID != "":
+		return a.DMUUID == b.DMUUID
+	case a.Label != "" && b.Label != "":
+		return a.Label == b.Label
+	}
+	return true
+}
+
+// String returns a short human-readable description of the volume
+func (v *Volume) String() string {
+	if v == nil {
+		return "unknown volume"
+	}
+	desc := v.Device
+	if desc == "" {
+		desc = v.MountPoint
+	}
+	if v.Label != "" {
+		desc += " label=" + v.Label
+	}
+	if v.UUID != "" {
+		desc += " uuid=" + v.UUID
+	}
+	return desc
+}
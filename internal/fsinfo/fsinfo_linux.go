@@ -0,0 +1,161 @@
+package fsinfo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Lookup returns the volume that contains path, using /proc/self/mountinfo to
+// find the mount and the /dev/disk symlinks to resolve its UUID and label
+func Lookup(path string) (*Volume, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(absPath); err == nil {
+		absPath = resolved
+	}
+
+	volume, err := findMount(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(volume.Device, "/dev/") {
+		devicePath := volume.Device
+		if resolved, err := filepath.EvalSymlinks(devicePath); err == nil {
+			devicePath = resolved
+		}
+		volume.UUID = findDiskLink("/dev/disk/by-uuid", devicePath)
+		volume.Label = findDiskLink("/dev/disk/by-label", devicePath)
+		volume.DMUUID = readDMUUID(devicePath)
+	}
+
+	return volume, nil
+}
+
+// findMount returns the mountinfo entry with the longest mount point that is
+// a prefix of path
+func findMount(path string) (*Volume, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mount table: %w", err)
+	}
+	defer f.Close()
+
+	var best *Volume
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Format: id parent major:minor root mountpoint options [optional...] - fstype source superoptions
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i, field := range fields {
+			if field == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 5 || sep < 0 || sep+2 >= len(fields) {
+			continue
+		}
+
+		mountPoint := unescapeMountField(fields[4])
+		if !isUnder(path, mountPoint) {
+			continue
+		}
+		if best != nil && len(mountPoint) < len(best.MountPoint) {
+			continue
+		}
+		best = &Volume{
+			MountPoint: mountPoint,
+			FSType:     fields[sep+1],
+			Device:     unescapeMountField(fields[sep+2]),
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read mount table: %w", err)
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no mount found for %s", path)
+	}
+	return best, nil
+}
+
+func isUnder(path, mountPoint string) bool {
+	if mountPoint == "/" {
+		return true
+	}
+	return path == mountPoint || strings.HasPrefix(path, mountPoint+"/")
+}
+
+// unescapeMountField decodes the octal escapes (\040 etc.) used for spaces
+// and other special characters in mountinfo
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// findDiskLink returns the name of the symlink in dir that points at device
+func findDiskLink(dir, device string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		target, err := filepath.EvalSymlinks(filepath.Join(dir, entry.Name()))
+		if err == nil && target == device {
+			return unescapeUdevName(entry.Name())
+		}
+	}
+	return ""
+}
+
+// unescapeUdevName decodes the \xHH escapes udev uses in /dev/disk link names
+func unescapeUdevName(s string) string {
+	if !strings.Contains(s, `\x`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if n, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// readDMUUID returns the device-mapper UUID for /dev/dm-N devices, which
+// identifies the underlying LUKS or LVM volume
+func readDMUUID(device string) string {
+	name := filepath.Base(device)
+	if !strings.HasPrefix(name, "dm-") {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join("/sys/block", name, "dm", "uuid"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,82 @@
+package fsinfo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookup_TempDir(t *testing.T) {
+	volume, err := Lookup(t.TempDir())
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+
+	if volume.MountPoint == "" {
+		t.Error("MountPoint should be set")
+	}
+	if volume.FSType == "" {
+		t.Error("FSType should be set")
+	}
+}
+
+func TestUnescapeMountField(t *testing.T) {
+	if got := unescapeMountField(`/mnt/my\040disk`); got != "/mnt/my disk" {
+		t.Errorf("Expected %q, got %q", "/mnt/my disk", got)
+	}
+	if got := unescapeUdevName(`My\x20Passport`); got != "My Passport" {
+		t.Errorf("Expected %q, got %q", "My Passport", got)
+	}
+}
+
+func TestIsRotational(t *testing.T) {
+	// A fake /sys/class/block: an SSD with a partition, a disk, and a
+	// dm-crypt device on top of the disk
+	root := t.TempDir()
+	devices := filepath.Join(root, "devices")
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(devices, "nvme0n1", "queue", "rotational"), "0\n")
+	write(filepath.Join(devices, "nvme0n1", "nvme0n1p1", "partition"), "1\n")
+	write(filepath.Join(devices, "sda", "queue", "rotational"), "1\n")
+	write(filepath.Join(devices, "dm-0", "queue", "rotational"), "0\n")
+	os.MkdirAll(filepath.Join(devices, "dm-0", "slaves", "sda"), 0755)
+
+	sysBlock = filepath.Join(root, "block")
+	t.Cleanup(func() { sysBlock = "/sys/class/block" })
+	os.Mkdir(sysBlock, 0755)
+	for name, target := range map[string]string{
+		"nvme0n1":   "nvme0n1",
+		"nvme0n1p1": "nvme0n1/nvme0n1p1",
+		"sda":       "sda",
+		"dm-0":      "dm-0",
+	} {
+		if err := os.Symlink(filepath.Join(devices, target), filepath.Join(sysBlock, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name           string
+		rotational, ok bool
+	}{
+		{"nvme0n1", false, true},
+		{"nvme0n1p1", false, true},
+		{"sda", true, true},
+		{"dm-0", true, true},
+		{"missing", false, false},
+	}
+	for _, tt := range tests {
+		rotational, ok := isRotational(tt.name, 0)
+		if rotational != tt.rotational || ok != tt.ok {
+			t.Errorf("isRotational(%s) = %v, %v, want %v, %v", tt.name, rotational, ok, tt.rotational, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package fsinfo
+
+// Lookup is only implemented on Linux; elsewhere no volume is recorded
+func Lookup(path string) (*Volume, error) {
+	return nil, nil
+}
+
+// DetectStorage is only implemented on Linux; elsewhere the storage is
+// reported as unknown
+func DetectStorage(path string) (Storage, *Volume, error) {
+	return StorageUnknown, nil, nil
+}
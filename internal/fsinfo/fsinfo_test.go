@@ -0,0 +1,49 @@
+package fsinfo
+
+import "testing"
+
+func TestSameVolume(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b *Volume
+		want bool
+	}{
+		{"unknown", nil, &Volume{UUID: "1234"}, true},
+		{"same uuid", &Volume{UUID: "1234", Label: "a"}, &Volume{UUID: "1234", Label: "b"}, true},
+		{"different uuid", &Volume{UUID: "1234"}, &Volume{UUID: "5678"}, false},
+		{"different dm uuid", &Volume{DMUUID: "CRYPT-LUKS2-aa"}, &Volume{DMUUID: "CRYPT-LUKS2-bb"}, false},
+		{"label only", &Volume{Label: "backup"}, &Volume{Label: "scratch"}, false},
+		{"no identifiers", &Volume{Device: "/dev/sda1"}, &Volume{Device: "/dev/sdb1"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SameVolume(tt.a, tt.b); got != tt.want {
+				t.Errorf("SameVolume() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorageWorkers(t *testing.T) {
+	tests := []struct {
+		storage Storage
+		cpus    int
+		want    int
+	}{
+		{StorageHDD, 16, 2},
+		{StorageSSD, 8, 16},
+		{StorageNetwork, 2, 16},
+		{StorageNetwork, 32, 64},
+		{StorageUnknown, 4, 8},
+	}
+	for _, tt := range tests {
+		if got := tt.storage.Workers(tt.cpus); got != tt.want {
+			t.Errorf("%s.Workers(%d) = %d, want %d", tt.storage, tt.cpus, got, tt.want)
+		}
+	}
+
+	if !IsNetworkFS("nfs4") || !IsNetworkFS("fuse.sshfs") || IsNetworkFS("ext4") {
+		t.Error("IsNetworkFS misclassified a filesystem type")
+	}
+}
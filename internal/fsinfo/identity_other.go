@@ -0,0 +1,15 @@
+//go:build !unix
+
+package fsinfo
+
+import "os"
+
+// Identity is only implemented on Unix; elsewhere files have no identity
+func Identity(info os.FileInfo) (dev, inode uint64, ok bool) {
+	return 0, 0, false
+}
+
+// Allocated is only implemented on Unix
+func Allocated(info os.FileInfo) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package fsinfo
+
+import (
+	"os"
+	"syscall"
+)
+
+// Identity returns the device and inode numbers of a file, which identify
+// it on this machine for as long as it exists
+func Identity(info os.FileInfo) (dev, inode uint64, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return uint64(st.Dev), uint64(st.Ino), true
+}
+
+// Allocated returns the number of bytes a file occupies on disk, which is
+// less than its size for sparse files
+func Allocated(info os.FileInfo) (int64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int64(st.Blocks) * 512, true
+}
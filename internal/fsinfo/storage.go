@@ -0,0 +1,42 @@
+package fsinfo
+
+import "strings"
+
+// Storage is the kind of device a directory is stored on
+type Storage string
+
+const (
+	StorageUnknown Storage = "unknown"
+	StorageHDD     Storage = "hdd"
+	StorageSSD     Storage = "ssd"
+	StorageNetwork Storage = "network"
+)
+
+// networkFSTypes are the filesystem types, as named in the mount table,
+// that are served over the network
+var networkFSTypes = map[string]bool{
+	"nfs": true, "nfs4": true, "cifs": true, "smb3": true, "smbfs": true,
+	"9p": true, "afs": true, "ceph": true, "glusterfs": true, "lustre": true,
+	"davfs": true, "fuse.sshfs": true, "fuse.rclone": true, "fuse.s3fs": true,
+	"fuse.glusterfs": true, "fuse.cephfs": true, "fuse.gcsfuse": true,
+}
+
+// IsNetworkFS reports whether fsType is a network filesystem
+func IsNetworkFS(fsType string) bool {
+	return networkFSTypes[strings.ToLower(fsType)]
+}
+
+// Workers returns the default number of hashing workers for storage on a
+// machine with cpus CPUs. Reading several files at once makes a spinning
+// disk seek between them, so it gets two workers. Network filesystems are
+// bound by latency rather than CPU and get at least 16 workers to keep
+// requests in flight.
+func (s Storage) Workers(cpus int) int {
+	switch s {
+	case StorageHDD:
+		return 2
+	case StorageNetwork:
+		return max(cpus*2, 16)
+	}
+	return max(cpus*2, 1)
+}
@@ -0,0 +1,76 @@
+package fsinfo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sysBlock lists the block devices; tests point it at a fake tree
+var sysBlock = "/sys/class/block"
+
+// DetectStorage returns the kind of storage path lives on, along with its
+// volume. Network filesystems are recognized by type; for local block
+// devices the kernel's rotational flag tells disks from SSDs, following
+// device-mapper and RAID devices down to the disks below them.
+func DetectStorage(path string) (Storage, *Volume, error) {
+	volume, err := Lookup(path)
+	if err != nil {
+		return StorageUnknown, nil, err
+	}
+	if IsNetworkFS(volume.FSType) {
+		return StorageNetwork, volume, nil
+	}
+	if !strings.HasPrefix(volume.Device, "/dev/") {
+		return StorageUnknown, volume, nil
+	}
+	device := volume.Device
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		device = resolved
+	}
+	rotational, ok := isRotational(filepath.Base(device), 0)
+	switch {
+	case !ok:
+		return StorageUnknown, volume, nil
+	case rotational:
+		return StorageHDD, volume, nil
+	}
+	return StorageSSD, volume, nil
+}
+
+// isRotational reports whether the named block device is a spinning disk;
+// ok is false if it cannot be told. A device stacked on others (dm-crypt,
+// LVM, md RAID) is rotational if any device below it is.
+func isRotational(name string, depth int) (rotational, ok bool) {
+	dir, err := filepath.EvalSymlinks(filepath.Join(sysBlock, name))
+	if err != nil {
+		return false, false
+	}
+
+	if slaves, err := os.ReadDir(filepath.Join(dir, "slaves")); err == nil && len(slaves) > 0 && depth < 8 {
+		for _, slave := range slaves {
+			slaveRotational, slaveOK := isRotational(slave.Name(), depth+1)
+			if slaveRotational {
+				return true, true
+			}
+			ok = ok || slaveOK
+		}
+		return false, ok
+	}
+
+	// Partitions have no queue of their own; the disk above them does
+	data, err := os.ReadFile(filepath.Join(dir, "queue", "rotational"))
+	if err != nil {
+		data, err = os.ReadFile(filepath.Join(filepath.Dir(dir), "queue", "rotational"))
+		if err != nil {
+			return false, false
+		}
+	}
+	switch strings.TrimSpace(string(data)) {
+	case "1":
+		return true, true
+	case "0":
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,58 @@
+package hash
+
+import (
+	"io"
+	"sync"
+)
+
+// bufferPool recycles read buffers of one size, so hashing millions of
+// small files does not allocate a buffer for each. Pointers to slices are
+// pooled so that putting one back does not allocate either.
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	p := &bufferPool{size: size}
+	p.pool.New = func() any {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return p
+}
+
+// defaultBuffers holds the buffers of the default size, and bufferPools
+// those of every other size in use, by size
+var (
+	defaultBuffers = newBufferPool(bufferSize)
+	bufferPools    sync.Map
+)
+
+// buffersFor returns the pool of buffers of size bytes, shared by every
+// hasher reading with that size
+func buffersFor(size int) *bufferPool {
+	if size == bufferSize {
+		return defaultBuffers
+	}
+	if p, ok := bufferPools.Load(size); ok {
+		return p.(*bufferPool)
+	}
+	p, _ := bufferPools.LoadOrStore(size, newBufferPool(size))
+	return p.(*bufferPool)
+}
+
+func (p *bufferPool) get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+func (p *bufferPool) put(buf *[]byte) {
+	p.pool.Put(buf)
+}
+
+// copyBuffer is io.CopyBuffer always using buf. io.CopyBuffer ignores buf
+// when src implements io.WriterTo, and *os.File does: its fallback then
+// allocates a buffer of its own for every file.
+func copyBuffer(dst io.Writer, src io.Reader, buf []byte) (int64, error) {
+	return io.CopyBuffer(dst, struct{ io.Reader }{src}, buf)
+}
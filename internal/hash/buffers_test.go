@@ -0,0 +1,106 @@
+package hash
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// smallFiles writes count files of size bytes for the benchmarks
+func smallFiles(b *testing.B, count, size int) []string {
+	b.Helper()
+	dir := b.TempDir()
+	paths := make([]string, count)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("%d.txt", i))
+		if err := os.WriteFile(paths[i], make([]byte, size), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return paths
+}
+
+// BenchmarkHashSmallFiles compares hashing small files through pooled
+// buffers with allocating a buffer per file, as HashFileWith used to:
+//
+//	go test -bench HashSmallFiles -benchmem ./internal/hash
+func BenchmarkHashSmallFiles(b *testing.B) {
+	paths := smallFiles(b, 100, 4096)
+
+	b.Run("pooled", func(b *testing.B) {
+		hasher, err := FileHasher(XXH64, ReadOptions{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := hasher(paths[i%len(paths)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("allocating", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			h, _ := New(XXH64)
+			file, err := os.Open(paths[i%len(paths)])
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := io.CopyBuffer(h, file, make([]byte, bufferSize)); err != nil {
+				b.Fatal(err)
+			}
+			file.Close()
+			h.Sum(nil)
+		}
+	})
+}
+
+// BenchmarkBufferSize hashes a larger file with several buffer sizes
+func BenchmarkBufferSize(b *testing.B) {
+	path := smallFiles(b, 1, 8<<20)[0]
+	for _, size := range []int{32 << 10, 128 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("%dK", size>>10), func(b *testing.B) {
+			hasher, err := FileHasher(XXH64, ReadOptions{BufferSize: size})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(8 << 20)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := hasher(path); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCopyBuffer_UsesBuffer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, make([]byte, 100000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hasher, err := FileHasher(XXH64, ReadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher(path) // Fill the pool
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for range 20 {
+		if _, err := hasher(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runtime.ReadMemStats(&after)
+	// Opening the file and the digest allocate a little; a read buffer
+	// alone would be bufferSize bytes per file
+	if perFile := (after.TotalAlloc - before.TotalAlloc) / 20; perFile >= bufferSize {
+		t.Errorf("Expected hashing a file to reuse its read buffer, got %d bytes allocated per file", perFile)
+	}
+}
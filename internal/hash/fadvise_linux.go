@@ -0,0 +1,16 @@
+//go:build linux && (amd64 || arm64)
+
+package hash
+
+import (
+	"os"
+	"syscall"
+)
+
+const fadvDontNeed = 4 // POSIX_FADV_DONTNEED
+
+// dropCache asks the kernel to evict the file's pages from the page cache.
+// It is advisory, so errors are ignored.
+func dropCache(file *os.File) {
+	syscall.Syscall6(syscall.SYS_FADVISE64, file.Fd(), 0, 0, fadvDontNeed, 0, 0)
+}
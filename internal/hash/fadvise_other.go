@@ -0,0 +1,8 @@
+//go:build !linux || !(amd64 || arm64)
+
+package hash
+
+import "os"
+
+// dropCache is a no-op where posix_fadvise is unavailable
+func dropCache(file *os.File) {}
@@ -1,39 +1,148 @@
 package hash
 
 import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	gohash "hash"
 	"io"
-	"os"
+	"strings"
 
 	"github.com/cespare/xxhash/v2"
+
+	"merkle-go/internal/longpath"
 )
 
 const bufferSize = 32 * 1024 // 32KB buffer for streaming
 
+// Supported file hash algorithms
+const (
+	XXH64  = "xxh64"
+	SHA256 = "sha256"
+	SHA1   = "sha1"
+	MD5    = "md5"
+
+	// HMACSHA256 is SHA-256 keyed with a secret (see NewKeyed), so that
+	// someone who can change both files and manifests cannot forge
+	// matching digests without the key
+	HMACSHA256 = "hmac-sha256"
+)
+
+// New returns a streaming hash for the named algorithm
+func New(algorithm string) (gohash.Hash, error) {
+	switch strings.ToLower(algorithm) {
+	case XXH64, "":
+		return xxhash.New(), nil
+	case SHA256:
+		return sha256.New(), nil
+	case SHA1:
+		return sha1.New(), nil
+	case MD5:
+		return md5.New(), nil
+	case HMACSHA256:
+		return nil, fmt.Errorf("hash algorithm %s needs a key (set hash_key)", HMACSHA256)
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %q", algorithm)
+	}
+}
+
+// NewKeyed is New for keyed algorithms: it returns an HMACSHA256 hash keyed
+// with key. Other algorithms take no key and ignore it.
+func NewKeyed(algorithm string, key []byte) (gohash.Hash, error) {
+	if !strings.EqualFold(algorithm, HMACSHA256) || len(key) == 0 {
+		return New(algorithm)
+	}
+	return hmac.New(sha256.New, key), nil
+}
+
+// Size returns the length in bytes of the digests of algorithm, keyed or not
+func Size(algorithm string) (int, error) {
+	if strings.EqualFold(algorithm, HMACSHA256) {
+		return sha256.Size, nil
+	}
+	h, err := New(algorithm)
+	if err != nil {
+		return 0, err
+	}
+	return h.Size(), nil
+}
+
+// Supported reports whether algorithm names a file hash algorithm, keyed or
+// not, so digests recorded with it can be checked by whoever has the key
+func Supported(algorithm string) bool {
+	_, err := New(algorithm)
+	return err == nil || strings.EqualFold(algorithm, HMACSHA256)
+}
+
+// KeyID returns a short fingerprint of a hash key that tells keys apart
+// without revealing them
+func KeyID(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("merkle-go key id"))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// HashFileWith computes the hex digest of a file with the named algorithm
+func HashFileWith(path, algorithm string) (string, error) {
+	h, err := New(algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := longpath.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	buf := defaultBuffers.get()
+	defer defaultBuffers.put(buf)
+	if _, err := copyBuffer(h, file, *buf); err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashReader computes the hex digest of everything read from r, such as
+// stdin or a named pipe, with the named algorithm and returns it with the
+// number of bytes read
+func HashReader(r io.Reader, algorithm string) (string, int64, error) {
+	return HashReaderKeyed(r, algorithm, nil)
+}
+
+// HashReaderKeyed is HashReader for keyed algorithms; see NewKeyed
+func HashReaderKeyed(r io.Reader, algorithm string, key []byte) (string, int64, error) {
+	h, err := NewKeyed(algorithm, key)
+	if err != nil {
+		return "", 0, err
+	}
+	buf := defaultBuffers.get()
+	defer defaultBuffers.put(buf)
+	n, err := copyBuffer(h, r, *buf)
+	if err != nil {
+		return "", n, fmt.Errorf("failed to read input: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
 // HashFile computes the xxHash of a file using streaming for large files
 func HashFile(path string) (string, error) {
-	file, err := os.Open(path)
+	file, err := longpath.Open(path)
 	if err != nil {
 		return "", fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
 	h := xxhash.New()
-	buf := make([]byte, bufferSize)
-
-	for {
-		n, err := file.Read(buf)
-		if n > 0 {
-			h.Write(buf[:n])
-		}
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return "", fmt.Errorf("failed to read file: %w", err)
-		}
+	buf := defaultBuffers.get()
+	defer defaultBuffers.put(buf)
+	if _, err := copyBuffer(h, file, *buf); err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
 	}
 
 	return hex.EncodeToString(h.Sum(nil)), nil
@@ -51,3 +160,48 @@ func XXHashFunc(data []byte) ([]byte, error) {
 	binary.BigEndian.PutUint64(buf, sum)
 	return buf, nil
 }
+
+// HashFileMulti computes the hex digests of a file with several algorithms
+// in a single read, returned in the order the algorithms are given
+func HashFileMulti(path string, algorithms ...string) ([]string, error) {
+	return HashFileMultiKeyed(path, nil, algorithms...)
+}
+
+// HashFileMultiKeyed is HashFileMulti for keyed algorithms, which are keyed
+// with key; see NewKeyed
+func HashFileMultiKeyed(path string, key []byte, algorithms ...string) ([]string, error) {
+	hashes, err := newMultiHash(algorithms, key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := longpath.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	buf := defaultBuffers.get()
+	defer defaultBuffers.put(buf)
+	if _, err := copyBuffer(hashes, file, *buf); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return hashes.digests(), nil
+}
+
+// HashReaderMultiKeyed is HashReaderKeyed for several algorithms: it
+// returns the digests of everything read from r, in the order the
+// algorithms are given, and the number of bytes read
+func HashReaderMultiKeyed(r io.Reader, key []byte, algorithms ...string) ([]string, int64, error) {
+	hashes, err := newMultiHash(algorithms, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	buf := defaultBuffers.get()
+	defer defaultBuffers.put(buf)
+	n, err := copyBuffer(hashes, r, *buf)
+	if err != nil {
+		return nil, n, fmt.Errorf("failed to read input: %w", err)
+	}
+	return hashes.digests(), n, nil
+}
@@ -1,9 +1,12 @@
 package hash
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/cespare/xxhash/v2"
@@ -122,3 +125,125 @@ func TestXXHashFunc_EmptyData(t *testing.T) {
 		t.Errorf("Expected 8 bytes, got %d", len(hashBytes))
 	}
 }
+
+func TestHashFileWith_Algorithms(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("Hello, World!"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	expected := map[string]string{
+		SHA256: "dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f",
+		SHA1:   "0a0a9f2a6772942557ab5355d76af442f8f65e01",
+		MD5:    "65a8e27d8879283831b664bd8b7f0ad4",
+	}
+
+	for algorithm, want := range expected {
+		got, err := HashFileWith(testFile, algorithm)
+		if err != nil {
+			t.Fatalf("HashFileWith(%s) failed: %v", algorithm, err)
+		}
+		if got != want {
+			t.Errorf("%s: expected %s, got %s", algorithm, want, got)
+		}
+	}
+
+	xxh, err := HashFileWith(testFile, XXH64)
+	if err != nil {
+		t.Fatalf("HashFileWith(xxh64) failed: %v", err)
+	}
+	if plain, _ := HashFile(testFile); xxh != plain {
+		t.Errorf("xxh64 should match HashFile: %s vs %s", xxh, plain)
+	}
+}
+
+func TestHashFileWith_UnknownAlgorithm(t *testing.T) {
+	if _, err := HashFileWith("/nonexistent", "crc7"); err == nil {
+		t.Error("HashFileWith should reject unknown algorithms")
+	}
+}
+
+func TestHashReader(t *testing.T) {
+	digest, n, err := HashReader(strings.NewReader("Hello, World!"), SHA256)
+	if err != nil {
+		t.Fatalf("HashReader failed: %v", err)
+	}
+	if want := "dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f"; digest != want {
+		t.Errorf("Expected sha256 %s, got %s", want, digest)
+	}
+	if n != 13 {
+		t.Errorf("Expected 13 bytes read, got %d", n)
+	}
+
+	if _, _, err := HashReader(strings.NewReader(""), "crc7"); err == nil {
+		t.Error("HashReader should reject unknown algorithms")
+	}
+}
+
+func TestHashFileMulti(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("Hello, World!"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	digests, err := HashFileMulti(testFile, XXH64, SHA256)
+	if err != nil {
+		t.Fatalf("HashFileMulti failed: %v", err)
+	}
+	if len(digests) != 2 {
+		t.Fatalf("Expected 2 digests, got %d", len(digests))
+	}
+	if plain, _ := HashFile(testFile); digests[0] != plain {
+		t.Errorf("Expected xxh64 %s, got %s", plain, digests[0])
+	}
+	if want := "dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f"; digests[1] != want {
+		t.Errorf("Expected sha256 %s, got %s", want, digests[1])
+	}
+}
+
+func TestHashFileSample(t *testing.T) {
+	content := make([]byte, 10000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	path := filepath.Join(t.TempDir(), "movie.mkv")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	digest, sampled, err := HashFileSample(path, SHA256, 1000, nil)
+	if err != nil || sampled != 1000 {
+		t.Fatalf("Expected a 1000-byte sample, got %d (%v)", sampled, err)
+	}
+	sample := append([]byte{0, 0, 0, 0, 0, 0, 0x27, 0x10}, content[:1000]...)
+	want := sha256.Sum256(append(sample, content[9000:]...))
+	if digest != hex.EncodeToString(want[:]) {
+		t.Errorf("Expected %x, got %s", want, digest)
+	}
+	if at, _, err := HashSampleAt(bytes.NewReader(content), int64(len(content)), SHA256, 1000, nil); err != nil || at != digest {
+		t.Errorf("Expected HashSampleAt to give %s, got %s (%v)", digest, at, err)
+	}
+
+	// The middle of the file is not read
+	content[5000]++
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if again, _, _ := HashFileSample(path, SHA256, 1000, nil); again != digest {
+		t.Errorf("Expected a change outside the sample to be missed, got %s", again)
+	}
+	content[9999]++
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if again, _, _ := HashFileSample(path, SHA256, 1000, nil); again == digest {
+		t.Error("Expected a change in the tail sample to change the digest")
+	}
+
+	// Files no larger than both samples are left to be hashed whole
+	if _, sampled, err := HashFileSample(path, SHA256, 5000, nil); err != nil || sampled != 0 {
+		t.Errorf("Expected a small file not to be sampled, got %d (%v)", sampled, err)
+	}
+}
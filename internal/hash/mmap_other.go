@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package hash
+
+import (
+	gohash "hash"
+	"os"
+)
+
+func readMmap(h gohash.Hash, file *os.File) error {
+	return errUnsupported
+}
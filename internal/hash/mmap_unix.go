@@ -0,0 +1,39 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package hash
+
+import (
+	"fmt"
+	gohash "hash"
+	"math"
+	"os"
+	"runtime/debug"
+	"syscall"
+)
+
+// readMmap hashes an open file by mapping it into memory
+func readMmap(h gohash.Hash, file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil // Empty files cannot be mapped
+	}
+	if size > math.MaxInt {
+		return errUnsupported
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("failed to map file: %w", err)
+	}
+	defer syscall.Munmap(data)
+
+	// A file truncated while mapped faults on access; turn that into a panic
+	// the hash workers recover from instead of crashing the process
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	h.Write(data)
+	return nil
+}
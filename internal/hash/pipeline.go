@@ -0,0 +1,78 @@
+package hash
+
+import (
+	gohash "hash"
+	"sync"
+)
+
+// readAhead is how many blocks of a file may wait for its digest goroutine
+// before the reader blocks
+const readAhead = 4
+
+// digestPool bounds the number of blocks being digested at once across all
+// the files hashed by one FileHasher
+type digestPool struct {
+	slots   chan struct{}
+	buffers *bufferPool
+}
+
+func newDigestPool(workers, bufferSize int) *digestPool {
+	return &digestPool{slots: make(chan struct{}, workers), buffers: buffersFor(bufferSize)}
+}
+
+// block is a pooled buffer holding n bytes of a file
+type block struct {
+	buf *[]byte
+	n   int
+}
+
+// pipelinedHash splits hashing a file into a read stage, the goroutine
+// calling Write, and a digest stage running in its own goroutine, so the
+// next blocks are read while the previous ones are digested. Writes copy
+// the data into pooled blocks; Sum waits for the digest stage to finish.
+type pipelinedHash struct {
+	gohash.Hash
+	pool   *digestPool
+	blocks chan block
+	done   chan struct{}
+	once   sync.Once
+}
+
+// pipelined returns h with its writes digested in a separate goroutine
+// holding a slot of pool. close must be called if Sum is not.
+func (p *digestPool) pipelined(h gohash.Hash) *pipelinedHash {
+	ph := &pipelinedHash{Hash: h, pool: p, blocks: make(chan block, readAhead), done: make(chan struct{})}
+	go func() {
+		defer close(ph.done)
+		for b := range ph.blocks {
+			p.slots <- struct{}{}
+			h.Write((*b.buf)[:b.n])
+			<-p.slots
+			p.buffers.put(b.buf)
+		}
+	}()
+	return ph
+}
+
+func (ph *pipelinedHash) Write(data []byte) (int, error) {
+	for written := 0; written < len(data); {
+		buf := ph.pool.buffers.get()
+		n := copy(*buf, data[written:])
+		ph.blocks <- block{buf: buf, n: n}
+		written += n
+	}
+	return len(data), nil
+}
+
+// Sum waits for the blocks written so far to be digested and appends the
+// digest to b. No more writes may follow.
+func (ph *pipelinedHash) Sum(b []byte) []byte {
+	ph.close()
+	return ph.Hash.Sum(b)
+}
+
+// close stops the digest stage once it has digested every block written
+func (ph *pipelinedHash) close() {
+	ph.once.Do(func() { close(ph.blocks) })
+	<-ph.done
+}
@@ -0,0 +1,376 @@
+package hash
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	gohash "hash"
+	"io"
+	"io/fs"
+	"strings"
+
+	"merkle-go/internal/longpath"
+)
+
+// Read strategies control how file contents are read while hashing
+const (
+	// ReadBuffered reads through the page cache with a small buffer (default)
+	ReadBuffered = "buffered"
+	// ReadMmap maps the whole file into memory and hashes it in one pass
+	ReadMmap = "mmap"
+	// ReadDropCache reads normally but tells the kernel to drop the cached
+	// pages afterwards (posix_fadvise DONTNEED), so a scan does not evict
+	// the working set of other programs
+	ReadDropCache = "dontneed"
+	// ReadDirect bypasses the page cache with O_DIRECT (Linux only). Falls
+	// back to dontneed on filesystems that reject O_DIRECT, such as tmpfs.
+	ReadDirect = "direct"
+)
+
+// errUnsupported is returned by the platform helpers when a strategy cannot
+// be used on this platform or file
+var errUnsupported = errors.New("not supported")
+
+// ValidateReadStrategy reports whether strategy can be used on this platform
+func ValidateReadStrategy(strategy string) error {
+	switch strings.ToLower(strategy) {
+	case ReadBuffered, "", ReadMmap, ReadDropCache:
+		return nil
+	case ReadDirect:
+		if !directSupported {
+			return fmt.Errorf("read strategy %q is only supported on Linux", strategy)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown read strategy %q (want buffered, mmap, dontneed or direct)", strategy)
+	}
+}
+
+// ReadOptions controls how FileHasher reads files
+type ReadOptions struct {
+	Strategy   string // One of the Read* strategies; empty means buffered
+	BufferSize int    // Read buffer size in bytes; 0 means 32 KB
+
+	// SegmentSize, if positive, hashes files larger than it as a Merkle
+	// tree of segments read in parallel (see hashSegments). The digests
+	// differ from plain ones, so the size must be recorded with them.
+	SegmentSize int64
+	// SegmentWorkers is the number of segments of one file read at once;
+	// 0 means GOMAXPROCS
+	SegmentWorkers int
+
+	// DigestWorkers, if positive, splits hashing into a read stage, run by
+	// the caller, and a digest stage: blocks read ahead are digested in
+	// another goroutine, and at most DigestWorkers blocks are digested at
+	// once across all the files of the hasher. Digests are unchanged.
+	// Segmented files are not pipelined; their segments already run in
+	// parallel.
+	DigestWorkers int
+
+	// Key is the secret of keyed algorithms (HMACSHA256); other
+	// algorithms ignore it
+	Key []byte
+
+	// OnRead, if set, is called with the number of bytes each read adds to
+	// a digest, so callers can show progress within files too large to
+	// wait for. It is called from the hashing goroutines, concurrently
+	// for several files or segments.
+	OnRead func(n int)
+
+	// Tee, if set, is called before a file is read whole and may return a
+	// writer that receives its content, in order, as it is hashed, so that
+	// other digests of the file need no second read. Errors of the writer
+	// are its own to report. Segmented files, whose segments are read out
+	// of order, are not teed.
+	Tee func(path string) io.Writer
+}
+
+// FileHasher returns a function hashing files with the given algorithm and
+// read options, suitable for walker.HashOptions.HashFunc
+func FileHasher(algorithm string, opts ReadOptions) (func(path string) (string, error), error) {
+	if _, err := NewKeyed(algorithm, opts.Key); err != nil {
+		return nil, err
+	}
+	opts, err := checkReadOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	read := fileReader(opts)
+
+	return func(path string) (string, error) {
+		if opts.SegmentSize > 0 {
+			info, err := longpath.Stat(path)
+			if err != nil {
+				return "", fmt.Errorf("failed to open file: %w", err)
+			}
+			if info.Size() > opts.SegmentSize {
+				return hashSegments(path, algorithm, info.Size(), opts)
+			}
+		}
+		h, err := NewKeyed(algorithm, opts.Key)
+		if err != nil {
+			return "", err
+		}
+		if err := read(h, path); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}, nil
+}
+
+// MultiFileHasher is FileHasher for several algorithms at once: the
+// returned function reads each file once and returns its digests in the
+// order of algorithms. Segmented digests are refused, as they would read
+// each file once per algorithm.
+func MultiFileHasher(algorithms []string, opts ReadOptions) (func(path string) ([]string, error), error) {
+	if len(algorithms) == 0 {
+		return nil, fmt.Errorf("no hash algorithms given")
+	}
+	for _, algorithm := range algorithms {
+		if _, err := NewKeyed(algorithm, opts.Key); err != nil {
+			return nil, err
+		}
+	}
+	if opts.SegmentSize != 0 && len(algorithms) > 1 {
+		return nil, fmt.Errorf("segmented hashing takes a single algorithm")
+	}
+	opts, err := checkReadOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	read := fileReader(opts)
+
+	return func(path string) ([]string, error) {
+		hashes, err := newMultiHash(algorithms, opts.Key)
+		if err != nil {
+			return nil, err
+		}
+		if err := read(hashes, path); err != nil {
+			return nil, err
+		}
+		return hashes.digests(), nil
+	}, nil
+}
+
+// checkReadOptions validates opts and fills in their defaults
+func checkReadOptions(opts ReadOptions) (ReadOptions, error) {
+	if err := ValidateReadStrategy(opts.Strategy); err != nil {
+		return opts, err
+	}
+	if opts.BufferSize < 0 {
+		return opts, fmt.Errorf("invalid buffer size %d", opts.BufferSize)
+	}
+	if opts.SegmentSize < 0 {
+		return opts, fmt.Errorf("invalid segment size %d", opts.SegmentSize)
+	}
+	if opts.DigestWorkers < 0 {
+		return opts, fmt.Errorf("invalid digest worker count %d", opts.DigestWorkers)
+	}
+	opts.Strategy = strings.ToLower(opts.Strategy)
+	if opts.BufferSize == 0 {
+		opts.BufferSize = bufferSize
+	}
+	return opts, nil
+}
+
+// fileReader returns a function writing whole files to a hash as the
+// checked opts describe, through pooled buffers and, with DigestWorkers, a
+// digest stage of its own. Once it returns, the hash has digested
+// everything read.
+func fileReader(opts ReadOptions) func(h gohash.Hash, path string) error {
+	buffers := buffersFor(opts.BufferSize)
+	var pool *digestPool
+	if opts.DigestWorkers > 0 {
+		pool = newDigestPool(opts.DigestWorkers, opts.BufferSize)
+	}
+
+	return func(h gohash.Hash, path string) error {
+		if opts.Tee != nil {
+			if w := opts.Tee(path); w != nil {
+				h = teeHash{Hash: h, w: w}
+			}
+		}
+		if pool != nil {
+			pipe := pool.pipelined(h)
+			defer pipe.close()
+			h = pipe
+		}
+		buf := buffers.get()
+		defer buffers.put(buf)
+		return readInto(reporting(h, opts.OnRead), path, opts, *buf)
+	}
+}
+
+// teeHash also writes everything it hashes to w
+type teeHash struct {
+	gohash.Hash
+	w io.Writer
+}
+
+func (t teeHash) Write(p []byte) (int, error) {
+	t.w.Write(p)
+	return t.Hash.Write(p)
+}
+
+// multiHash writes to several hashes at once. Sum and the sizes are those
+// of the first; digests sums each.
+type multiHash []gohash.Hash
+
+// newMultiHash returns a multiHash of the algorithms, keyed with key; see
+// NewKeyed
+func newMultiHash(algorithms []string, key []byte) (multiHash, error) {
+	hashes := make(multiHash, len(algorithms))
+	for i, algorithm := range algorithms {
+		h, err := NewKeyed(algorithm, key)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = h
+	}
+	return hashes, nil
+}
+
+// digests returns the hex digest of each hash
+func (m multiHash) digests() []string {
+	digests := make([]string, len(m))
+	for i, h := range m {
+		digests[i] = hex.EncodeToString(h.Sum(nil))
+	}
+	return digests
+}
+
+func (m multiHash) Write(p []byte) (int, error) {
+	for _, h := range m {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+func (m multiHash) Sum(b []byte) []byte { return m[0].Sum(b) }
+func (m multiHash) Size() int           { return m[0].Size() }
+func (m multiHash) BlockSize() int      { return m[0].BlockSize() }
+
+func (m multiHash) Reset() {
+	for _, h := range m {
+		h.Reset()
+	}
+}
+
+// FSHasher is FileHasher for the files of fsys, such as an embed.FS or an
+// fstest.MapFS: the returned function hashes the file with the given
+// slash-separated name. Only the Key, BufferSize and OnRead options apply;
+// read strategies and segments need OS files and are refused.
+func FSHasher(fsys fs.FS, algorithm string, opts ReadOptions) (func(name string) (string, error), error) {
+	if _, err := NewKeyed(algorithm, opts.Key); err != nil {
+		return nil, err
+	}
+	if opts.Strategy != "" && !strings.EqualFold(opts.Strategy, ReadBuffered) {
+		return nil, fmt.Errorf("read strategy %s needs OS files", opts.Strategy)
+	}
+	if opts.SegmentSize != 0 {
+		return nil, fmt.Errorf("segmented hashing needs OS files")
+	}
+	if opts.BufferSize < 0 {
+		return nil, fmt.Errorf("invalid buffer size %d", opts.BufferSize)
+	}
+	if opts.BufferSize == 0 {
+		opts.BufferSize = bufferSize
+	}
+	buffers := buffersFor(opts.BufferSize)
+
+	return func(name string) (string, error) {
+		file, err := fsys.Open(name)
+		if err != nil {
+			return "", fmt.Errorf("failed to open file: %w", err)
+		}
+		defer file.Close()
+		h, err := NewKeyed(algorithm, opts.Key)
+		if err != nil {
+			return "", err
+		}
+		buf := buffers.get()
+		defer buffers.put(buf)
+		if _, err := copyBuffer(reporting(h, opts.OnRead), file, *buf); err != nil {
+			return "", fmt.Errorf("failed to read file: %w", err)
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}, nil
+}
+
+// progressStep is the most a reportingHash hashes between two reports, so
+// that a whole mapped file still reports as it goes
+const progressStep = 4 * 1024 * 1024
+
+// reportingHash passes the size of every write to onRead
+type reportingHash struct {
+	gohash.Hash
+	onRead func(n int)
+}
+
+// reporting returns h, reporting its writes to onRead if that is set
+func reporting(h gohash.Hash, onRead func(n int)) gohash.Hash {
+	if onRead == nil {
+		return h
+	}
+	return &reportingHash{Hash: h, onRead: onRead}
+}
+
+func (r *reportingHash) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		step := p[:min(len(p), progressStep)]
+		n, err := r.Hash.Write(step)
+		written += n
+		r.onRead(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// readInto writes the contents of the file at path to h as opts describe,
+// reading through buf
+func readInto(h gohash.Hash, path string, opts ReadOptions, buf []byte) error {
+	strategy := opts.Strategy
+	if strategy == ReadDirect {
+		err := readDirect(h, path, opts.BufferSize)
+		if !errors.Is(err, errUnsupported) {
+			return err
+		}
+		strategy = ReadDropCache
+	}
+
+	file, err := longpath.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	if strategy == ReadMmap {
+		err := readMmap(h, file)
+		if !errors.Is(err, errUnsupported) {
+			return err
+		}
+	}
+
+	err = readSparse(h, file, buf)
+	if err == nil {
+		if strategy == ReadDropCache {
+			dropCache(file)
+		}
+		return nil
+	}
+	if !errors.Is(err, errUnsupported) {
+		return err
+	}
+	if _, err := copyBuffer(h, file, buf); err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	if strategy == ReadDropCache {
+		dropCache(file)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package hash
+
+import (
+	"errors"
+	"fmt"
+	gohash "hash"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"merkle-go/internal/longpath"
+)
+
+const directSupported = true
+
+// directAlignment is the buffer and I/O size alignment O_DIRECT requires on
+// common Linux filesystems
+const directAlignment = 4096
+
+// minDirectBufferSize keeps uncached I/O efficient when a small read buffer
+// is configured
+const minDirectBufferSize = 1024 * 1024
+
+// readDirect hashes the file at path with O_DIRECT, bypassing the page
+// cache. It returns errUnsupported if the filesystem rejects O_DIRECT.
+func readDirect(h gohash.Hash, path string, bufferSize int) error {
+	file, err := longpath.OpenFile(path, os.O_RDONLY|syscall.O_DIRECT, 0)
+	if err != nil {
+		if errors.Is(err, syscall.EINVAL) {
+			return errUnsupported
+		}
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	// O_DIRECT reads must be a multiple of the alignment
+	size := max(bufferSize, minDirectBufferSize)
+	size = (size + directAlignment - 1) / directAlignment * directAlignment
+	buf := alignedBuffer(size)
+	read := false
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			read = true
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if !read && errors.Is(err, syscall.EINVAL) {
+				return errUnsupported
+			}
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+	}
+}
+
+// alignedBuffer returns a buffer of size bytes whose start is aligned to
+// directAlignment
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directAlignment)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) % directAlignment); rem != 0 {
+		offset = directAlignment - rem
+	}
+	return buf[offset : offset+size]
+}
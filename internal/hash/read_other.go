@@ -0,0 +1,11 @@
+//go:build !linux
+
+package hash
+
+import gohash "hash"
+
+const directSupported = false
+
+func readDirect(h gohash.Hash, path string, bufferSize int) error {
+	return errUnsupported
+}
@@ -0,0 +1,387 @@
+package hash
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFileHasher_Strategies(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string][]byte{
+		"empty.bin": {},
+		"small.txt": []byte("Hello, World!"),
+		"large.bin": make([]byte, 3*1024*1024+123), // Not a multiple of the O_DIRECT block size
+	}
+	for i := range files["large.bin"] {
+		files["large.bin"][i] = byte(i % 251)
+	}
+
+	strategies := []string{ReadBuffered, ReadMmap, ReadDropCache}
+	if runtime.GOOS == "linux" {
+		strategies = append(strategies, ReadDirect)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		want, err := HashFile(path)
+		if err != nil {
+			t.Fatalf("HashFile failed: %v", err)
+		}
+
+		// The read/digest pipeline must not change any digest
+		for _, digestWorkers := range []int{0, 2} {
+			for _, strategy := range strategies {
+				hasher, err := FileHasher(XXH64, ReadOptions{Strategy: strategy, BufferSize: 5000, DigestWorkers: digestWorkers})
+				if err != nil {
+					t.Fatalf("FileHasher(%s) failed: %v", strategy, err)
+				}
+				got, err := hasher(path)
+				if err != nil {
+					t.Fatalf("%s/%d: hashing %s failed: %v", strategy, digestWorkers, name, err)
+				}
+				if got != want {
+					t.Errorf("%s/%d: expected %s for %s, got %s", strategy, digestWorkers, want, name, got)
+				}
+			}
+		}
+	}
+}
+
+func TestValidateReadStrategy(t *testing.T) {
+	if err := ValidateReadStrategy(""); err != nil {
+		t.Errorf("Empty strategy should default to buffered: %v", err)
+	}
+	if err := ValidateReadStrategy("carrier-pigeon"); err == nil {
+		t.Error("Expected an error for an unknown strategy")
+	}
+}
+
+func TestFileHasher_Segments(t *testing.T) {
+	content := make([]byte, 10*1000+7)
+	for i := range content {
+		content[i] = byte(i % 253)
+	}
+	path := filepath.Join(t.TempDir(), "large.bin")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Three segments: the first two are paired, the third is carried up
+	digest := func(data []byte) []byte {
+		h, _ := New(SHA256)
+		h.Write(data)
+		return h.Sum(nil)
+	}
+	pair := digest(append(digest(content[:4000]), digest(content[4000:8000])...))
+	want := hex.EncodeToString(digest(append(pair, digest(content[8000:])...)))
+
+	for _, workers := range []int{1, 2, 8} {
+		hasher, err := FileHasher(SHA256, ReadOptions{SegmentSize: 4000, SegmentWorkers: workers, BufferSize: 1000})
+		if err != nil {
+			t.Fatalf("FileHasher failed: %v", err)
+		}
+		got, err := hasher(path)
+		if err != nil {
+			t.Fatalf("Hashing with %d workers failed: %v", workers, err)
+		}
+		if got != want {
+			t.Errorf("Expected %s with %d workers, got %s", want, workers, got)
+		}
+	}
+	at, err := HashSegmentsAt(bytes.NewReader(content), int64(len(content)), SHA256, ReadOptions{SegmentSize: 4000})
+	if err != nil || at != want {
+		t.Errorf("Expected HashSegmentsAt to give %s, got %s (%v)", want, at, err)
+	}
+
+	// A file that fits in one segment keeps its plain digest
+	hasher, _ := FileHasher(SHA256, ReadOptions{SegmentSize: int64(len(content))})
+	plain, _ := HashFileWith(path, SHA256)
+	if got, err := hasher(path); err != nil || got != plain {
+		t.Errorf("Expected the plain digest %s for a single segment, got %s (%v)", plain, got, err)
+	}
+}
+
+func TestFileHasher_Sparse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sparse.img")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	// Holes before, between and after two data extents
+	f.WriteAt([]byte("boot sector"), 1024*1024)
+	f.WriteAt([]byte("superblock"), 5*1024*1024+17)
+	f.Truncate(9 * 1024 * 1024)
+	f.Close()
+
+	want, err := HashFile(path)
+	if err != nil {
+		t.Fatalf("HashFile failed: %v", err)
+	}
+	for _, strategy := range []string{ReadBuffered, ReadDropCache} {
+		hasher, _ := FileHasher(XXH64, ReadOptions{Strategy: strategy})
+		if got, err := hasher(path); err != nil || got != want {
+			t.Errorf("%s: expected %s for a sparse file, got %s (%v)", strategy, want, got, err)
+		}
+	}
+}
+
+func TestFileHasher_OnRead(t *testing.T) {
+	content := make([]byte, 9*1024*1024+5) // More than two progress steps when mapped
+	for i := range content {
+		content[i] = byte(i % 249)
+	}
+	path := filepath.Join(t.TempDir(), "large.bin")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	for _, opts := range []ReadOptions{
+		{Strategy: ReadBuffered},
+		{Strategy: ReadMmap},
+		{SegmentSize: 1024 * 1024, SegmentWorkers: 4},
+	} {
+		var read atomic.Int64
+		var calls atomic.Int64
+		opts.OnRead = func(n int) {
+			read.Add(int64(n))
+			calls.Add(1)
+		}
+		hasher, err := FileHasher(XXH64, opts)
+		if err != nil {
+			t.Fatalf("FileHasher failed: %v", err)
+		}
+		if _, err := hasher(path); err != nil {
+			t.Fatalf("Hashing failed: %v", err)
+		}
+		if read.Load() != int64(len(content)) {
+			t.Errorf("%q/%d: expected %d bytes reported, got %d", opts.Strategy, opts.SegmentSize, len(content), read.Load())
+		}
+		if calls.Load() < 3 {
+			t.Errorf("%q/%d: expected progress to be reported while hashing, got %d reports", opts.Strategy, opts.SegmentSize, calls.Load())
+		}
+	}
+}
+
+func TestFileHasher_Tee(t *testing.T) {
+	content := make([]byte, 3*1024*1024+7)
+	for i := range content {
+		content[i] = byte(i % 241)
+	}
+	path := filepath.Join(t.TempDir(), "large.bin")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	for _, opts := range []ReadOptions{
+		{Strategy: ReadBuffered, BufferSize: 5000},
+		{Strategy: ReadMmap},
+		{DigestWorkers: 2},
+		{SegmentSize: 1024 * 1024},
+	} {
+		var teed bytes.Buffer
+		opts.Tee = func(string) io.Writer { return &teed }
+		hasher, err := FileHasher(XXH64, opts)
+		if err != nil {
+			t.Fatalf("FileHasher failed: %v", err)
+		}
+		if _, err := hasher(path); err != nil {
+			t.Fatalf("Hashing failed: %v", err)
+		}
+		if opts.SegmentSize > 0 {
+			if teed.Len() != 0 {
+				t.Errorf("Expected segmented files not to be teed, got %d bytes", teed.Len())
+			}
+			continue
+		}
+		if !bytes.Equal(teed.Bytes(), content) {
+			t.Errorf("%q/%d: expected the content to be teed, got %d bytes", opts.Strategy, opts.DigestWorkers, teed.Len())
+		}
+	}
+}
+
+func TestFileHasher_Keyed(t *testing.T) {
+	content := make([]byte, 10*1000+7)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	path := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	if _, err := FileHasher(HMACSHA256, ReadOptions{}); err == nil {
+		t.Error("Expected hmac-sha256 without a key to be rejected")
+	}
+
+	hashWith := func(key string, segmentSize int64) string {
+		t.Helper()
+		hasher, err := FileHasher(HMACSHA256, ReadOptions{Key: []byte(key), SegmentSize: segmentSize})
+		if err != nil {
+			t.Fatalf("FileHasher failed: %v", err)
+		}
+		digest, err := hasher(path)
+		if err != nil {
+			t.Fatalf("Hashing failed: %v", err)
+		}
+		return digest
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(content)
+	if got, want := hashWith("secret", 0), hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("Expected HMAC-SHA256 %s, got %s", want, got)
+	}
+	plain, _ := HashFileWith(path, SHA256)
+	if hashWith("secret", 0) == hashWith("other", 0) || hashWith("secret", 0) == plain {
+		t.Error("Expected the digest to depend on the key")
+	}
+	if hashWith("secret", 4000) == hashWith("other", 4000) {
+		t.Error("Expected segmented digests to depend on the key")
+	}
+
+	// Unkeyed algorithms ignore the key
+	hasher, _ := FileHasher(SHA256, ReadOptions{Key: []byte("secret")})
+	if got, _ := hasher(path); got != plain {
+		t.Errorf("Expected sha256 to ignore the key, got %s", got)
+	}
+
+	// The one-pass and stream hashers key the same way
+	want := hashWith("secret", 0)
+	digests, err := HashFileMultiKeyed(path, []byte("secret"), HMACSHA256, SHA256)
+	if err != nil || digests[0] != want || digests[1] != plain {
+		t.Errorf("Expected HashFileMultiKeyed to give %s and %s, got %v (%v)", want, plain, digests, err)
+	}
+	file, _ := os.Open(path)
+	defer file.Close()
+	if got, _, err := HashReaderKeyed(file, HMACSHA256, []byte("secret")); got != want {
+		t.Errorf("Expected HashReaderKeyed to give %s, got %s (%v)", want, got, err)
+	}
+	if !Supported(HMACSHA256) || Supported("crc7") {
+		t.Error("Expected hmac-sha256 to be supported and crc7 not")
+	}
+
+	if KeyID([]byte("secret")) == KeyID([]byte("other")) || len(KeyID([]byte("secret"))) != 16 {
+		t.Errorf("Expected distinct 16-digit key IDs, got %s", KeyID([]byte("secret")))
+	}
+}
+
+func TestFSHasher(t *testing.T) {
+	fsys := fstest.MapFS{
+		"docs/a.txt": {Data: []byte("Hello, World!")},
+		"empty.bin":  {Data: []byte{}},
+	}
+	var read atomic.Int64
+	hashFunc, err := FSHasher(fsys, SHA256, ReadOptions{BufferSize: 4, OnRead: func(n int) { read.Add(int64(n)) }})
+	if err != nil {
+		t.Fatalf("FSHasher failed: %v", err)
+	}
+	for name, file := range fsys {
+		want, _, err := HashReader(bytes.NewReader(file.Data), SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := hashFunc(name); err != nil || got != want {
+			t.Errorf("%s: expected %s, got %s (%v)", name, want, got, err)
+		}
+	}
+	if read.Load() != 13 {
+		t.Errorf("Expected 13 bytes reported, got %d", read.Load())
+	}
+	if _, err := hashFunc("missing.txt"); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+
+	if _, err := FSHasher(fsys, XXH64, ReadOptions{Strategy: ReadMmap}); err == nil {
+		t.Error("Expected the mmap strategy to be refused")
+	}
+	if _, err := FSHasher(fsys, XXH64, ReadOptions{SegmentSize: 1024}); err == nil {
+		t.Error("Expected segments to be refused")
+	}
+}
+
+func TestFileHasher_PipelineConcurrent(t *testing.T) {
+	tmpDir := t.TempDir()
+	var paths []string
+	for i := range 8 {
+		path := filepath.Join(tmpDir, fmt.Sprintf("file%d.bin", i))
+		if err := os.WriteFile(path, bytes.Repeat([]byte{byte(i)}, 100*1000+i), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		paths = append(paths, path)
+	}
+
+	// More readers than digest workers share the pool
+	hasher, err := FileHasher(SHA256, ReadOptions{BufferSize: 4096, DigestWorkers: 1})
+	if err != nil {
+		t.Fatalf("FileHasher failed: %v", err)
+	}
+	var wg sync.WaitGroup
+	for _, path := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			want, _ := HashFileWith(path, SHA256)
+			if got, err := hasher(path); err != nil || got != want {
+				t.Errorf("%s: expected %s, got %s (%v)", path, want, got, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := FileHasher(XXH64, ReadOptions{DigestWorkers: -1}); err == nil {
+		t.Error("Expected a negative digest worker count to be rejected")
+	}
+}
+
+func TestMultiFileHasher(t *testing.T) {
+	content := make([]byte, 100*1000+3)
+	for i := range content {
+		content[i] = byte(i % 241)
+	}
+	path := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	algorithms := []string{XXH64, SHA256, MD5}
+	for _, digestWorkers := range []int{0, 2} {
+		var read atomic.Int64
+		hasher, err := MultiFileHasher(algorithms, ReadOptions{BufferSize: 4096, DigestWorkers: digestWorkers, OnRead: func(n int) { read.Add(int64(n)) }})
+		if err != nil {
+			t.Fatalf("MultiFileHasher failed: %v", err)
+		}
+		digests, err := hasher(path)
+		if err != nil {
+			t.Fatalf("Hashing failed: %v", err)
+		}
+		for i, algorithm := range algorithms {
+			if want, _ := HashFileWith(path, algorithm); digests[i] != want {
+				t.Errorf("%d: expected %s digest %s, got %s", digestWorkers, algorithm, want, digests[i])
+			}
+		}
+		// The file is read once, whatever the number of algorithms
+		if read.Load() != int64(len(content)) {
+			t.Errorf("%d: expected %d bytes read, got %d", digestWorkers, len(content), read.Load())
+		}
+	}
+
+	if _, err := MultiFileHasher(algorithms, ReadOptions{SegmentSize: 1024}); err == nil {
+		t.Error("Expected segments to be refused for several algorithms")
+	}
+	if _, err := MultiFileHasher([]string{XXH64, "crc7"}, ReadOptions{}); err == nil {
+		t.Error("Expected an unknown algorithm to be refused")
+	}
+}
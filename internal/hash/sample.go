@@ -0,0 +1,89 @@
+package hash
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"merkle-go/internal/longpath"
+)
+
+// HashFileSample fingerprints a large file from a sample of its content
+// instead of reading all of it: the digest, with the named algorithm, of
+//
+//	size (8 bytes, big-endian) || first sample bytes || last sample bytes
+//
+// A change in the middle of the file goes unnoticed, which is why sampled
+// leaves are marked in manifests. Files of at most 2*sample bytes are not
+// sampled: HashFileSample returns a sampled count of 0 and the caller hashes
+// them whole. Otherwise it returns sample as the count.
+func HashFileSample(path, algorithm string, sample int64, key []byte) (string, int64, error) {
+	if _, err := NewKeyed(algorithm, key); err != nil {
+		return "", 0, err
+	}
+	if sample <= 0 {
+		return "", 0, fmt.Errorf("invalid sample size %d", sample)
+	}
+
+	file, err := longpath.Open(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return HashSampleAt(file, info.Size(), algorithm, sample, key)
+}
+
+// HashSampleAt is HashFileSample for size bytes read from r, such as a file
+// opened through an os.Root
+func HashSampleAt(r io.ReaderAt, size int64, algorithm string, sample int64, key []byte) (string, int64, error) {
+	h, err := NewKeyed(algorithm, key)
+	if err != nil {
+		return "", 0, err
+	}
+	if sample <= 0 {
+		return "", 0, fmt.Errorf("invalid sample size %d", sample)
+	}
+	if size <= 2*sample {
+		return "", 0, nil
+	}
+
+	var header [8]byte
+	binary.BigEndian.PutUint64(header[:], uint64(size))
+	h.Write(header[:])
+	buf := defaultBuffers.get()
+	defer defaultBuffers.put(buf)
+	for _, offset := range []int64{0, size - sample} {
+		if _, err := copyBuffer(h, io.NewSectionReader(r, offset, sample), *buf); err != nil {
+			return "", 0, fmt.Errorf("failed to read file: %w", err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), sample, nil
+}
+
+// SampledHasher returns hashFunc extended to fingerprint the files for which
+// sampleFor returns a positive sample size with HashFileSample, keyed with
+// key for keyed algorithms. onSampled, if not nil, is called with each file
+// that was sampled rather than hashed whole, from the hashing goroutines.
+func SampledHasher(hashFunc func(path string) (string, error), algorithm string, key []byte,
+	sampleFor func(path string) int64, onSampled func(path string, sample int64)) func(path string) (string, error) {
+	return func(path string) (string, error) {
+		if sample := sampleFor(path); sample > 0 {
+			digest, sampled, err := HashFileSample(path, algorithm, sample, key)
+			if err != nil {
+				return "", err
+			}
+			if sampled > 0 {
+				if onSampled != nil {
+					onSampled(path, sampled)
+				}
+				return digest, nil
+			}
+		}
+		return hashFunc(path)
+	}
+}
@@ -0,0 +1,140 @@
+package hash
+
+import (
+	"encoding/hex"
+	"fmt"
+	gohash "hash"
+	"io"
+	"runtime"
+	"sync"
+
+	"merkle-go/internal/longpath"
+)
+
+// DefaultSegmentSize is the segment size used by --parallel-large-files
+const DefaultSegmentSize = 64 * 1024 * 1024
+
+// hashSegments hashes a file larger than opts.SegmentSize as a Merkle tree
+// of its segments. Every segment of SegmentSize bytes (the last one may be
+// shorter) is hashed with algorithm, several at a time, and the digests are
+// combined pairwise:
+//
+//	parent = algorithm(left || right)
+//
+// A digest without a partner on its level is carried up unchanged. The
+// result depends only on the content and the segment size, never on the
+// number of workers, and a file of a single segment hashes to its plain
+// digest. Segments are read with pread whatever the read strategy, except
+// that dontneed still drops the cached pages afterwards.
+func hashSegments(path, algorithm string, size int64, opts ReadOptions) (string, error) {
+	file, err := longpath.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	digest, err := segmentDigest(file, algorithm, size, opts)
+	if err == nil && (opts.Strategy == ReadDropCache || opts.Strategy == ReadDirect) {
+		dropCache(file)
+	}
+	return digest, err
+}
+
+// HashSegmentsAt hashes size bytes read from r, such as a file opened
+// through an os.Root, as the Merkle tree of segments FileHasher computes
+// for files larger than opts.SegmentSize
+func HashSegmentsAt(r io.ReaderAt, size int64, algorithm string, opts ReadOptions) (string, error) {
+	if _, err := NewKeyed(algorithm, opts.Key); err != nil {
+		return "", err
+	}
+	opts, err := checkReadOptions(opts)
+	if err != nil {
+		return "", err
+	}
+	if opts.SegmentSize == 0 {
+		return "", fmt.Errorf("invalid segment size %d", opts.SegmentSize)
+	}
+	return segmentDigest(r, algorithm, size, opts)
+}
+
+// segmentDigest hashes the segments of the size bytes of r; an empty r is
+// one empty segment
+func segmentDigest(r io.ReaderAt, algorithm string, size int64, opts ReadOptions) (string, error) {
+	count := max(1, int((size+opts.SegmentSize-1)/opts.SegmentSize))
+	digests := make([][]byte, count)
+	workers := opts.SegmentWorkers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, count)
+
+	indexes := make(chan int)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buffers := buffersFor(opts.BufferSize)
+			buf := buffers.get()
+			defer buffers.put(buf)
+			for i := range indexes {
+				h, err := NewKeyed(algorithm, opts.Key)
+				if err == nil {
+					offset := int64(i) * opts.SegmentSize
+					segment := io.NewSectionReader(r, offset, min(opts.SegmentSize, size-offset))
+					_, err = copyBuffer(reporting(h, opts.OnRead), segment, *buf)
+				}
+				if err != nil {
+					errs <- fmt.Errorf("failed to read file: %w", err)
+					// Drain the remaining segments so the sender finishes
+					for range indexes {
+					}
+					return
+				}
+				digests[i] = h.Sum(nil)
+			}
+		}()
+	}
+	for i := range count {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return "", err
+	}
+
+	root, err := combineSegments(digests, algorithm, opts.Key)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(root), nil
+}
+
+// combineSegments reduces segment digests to their Merkle root
+func combineSegments(digests [][]byte, algorithm string, key []byte) ([]byte, error) {
+	var h gohash.Hash
+	for len(digests) > 1 {
+		next := make([][]byte, 0, (len(digests)+1)/2)
+		for i := 0; i < len(digests); i += 2 {
+			if i+1 == len(digests) {
+				next = append(next, digests[i])
+				continue
+			}
+			if h == nil {
+				var err error
+				if h, err = NewKeyed(algorithm, key); err != nil {
+					return nil, err
+				}
+			}
+			h.Reset()
+			h.Write(digests[i])
+			h.Write(digests[i+1])
+			next = append(next, h.Sum(nil))
+		}
+		digests = next
+	}
+	return digests[0], nil
+}
@@ -0,0 +1,82 @@
+package hash
+
+import (
+	"errors"
+	"fmt"
+	gohash "hash"
+	"io"
+	"os"
+	"syscall"
+)
+
+// lseek whence values for finding the data and holes of sparse files
+const (
+	seekData = 3 // SEEK_DATA
+	seekHole = 4 // SEEK_HOLE
+)
+
+// zeros stands in for the content of holes
+var zeros = make([]byte, 256*1024)
+
+// readSparse hashes a sparse file by reading only its data extents and
+// feeding the holes between them to h as zeros from memory, so hashing a
+// mostly empty VM image does not read terabytes of zeros through the
+// kernel. The digest is the same as reading the file. Files that are not
+// sparse, or filesystems without SEEK_DATA, return errUnsupported with the
+// file offset unchanged.
+func readSparse(h gohash.Hash, file *os.File, buf []byte) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	size := info.Size()
+	if !ok || st.Blocks*512 >= size {
+		return errUnsupported
+	}
+
+	var offset int64
+	for offset < size {
+		data, err := file.Seek(offset, seekData)
+		switch {
+		case errors.Is(err, syscall.ENXIO):
+			data = size // Only a hole is left
+		case err != nil && offset == 0:
+			file.Seek(0, io.SeekStart)
+			return errUnsupported
+		case err != nil:
+			return fmt.Errorf("failed to find data: %w", err)
+		}
+		data = min(data, size)
+		if err := writeZeros(h, data-offset); err != nil {
+			return err
+		}
+		offset = data
+		if offset == size {
+			break
+		}
+
+		hole, err := file.Seek(offset, seekHole)
+		if err != nil {
+			return fmt.Errorf("failed to find hole: %w", err)
+		}
+		hole = min(hole, size)
+		if _, err := copyBuffer(h, io.NewSectionReader(file, offset, hole-offset), buf); err != nil {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+		offset = hole
+	}
+	return nil
+}
+
+// writeZeros writes n zero bytes to h
+func writeZeros(h gohash.Hash, n int64) error {
+	for n > 0 {
+		chunk := zeros[:min(n, int64(len(zeros)))]
+		if _, err := h.Write(chunk); err != nil {
+			return err
+		}
+		n -= int64(len(chunk))
+	}
+	return nil
+}
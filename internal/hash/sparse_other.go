@@ -0,0 +1,13 @@
+//go:build !linux
+
+package hash
+
+import (
+	gohash "hash"
+	"os"
+)
+
+// readSparse is only implemented on Linux; elsewhere holes are read
+func readSparse(h gohash.Hash, file *os.File, buf []byte) error {
+	return errUnsupported
+}
@@ -0,0 +1,256 @@
+// Package hashcache remembers file hashes across scans, keyed by the
+// identity of the file (device, inode, size and modification time), so that
+// rescanning mostly unchanged data skips reading it. The cache is a bbolt
+// database that concurrent hashing workers can share.
+package hashcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"merkle-go/internal/fsinfo"
+)
+
+var bucket = []byte("hashes")
+
+// refreshAfter is how old the last-used time of an entry may get before a
+// hit rewrites it; refreshing on every hit would turn reads into writes
+const refreshAfter = 24 * time.Hour
+
+// ErrLocked is returned by Open when another process holds the cache
+var ErrLocked = errors.New("hash cache is in use by another process")
+
+// Key identifies the content of a file without reading it. A file that
+// keeps its inode but is rewritten gets a new modification time, and one
+// that is replaced gets a new inode.
+type Key struct {
+	Dev       uint64
+	Inode     uint64
+	Size      int64
+	ModTime   int64 // nanoseconds since the epoch
+	Algorithm string
+}
+
+// KeyOf returns the key of a file; ok is false on platforms without inode
+// numbers
+func KeyOf(info os.FileInfo, algorithm string) (Key, bool) {
+	dev, inode, ok := fsinfo.Identity(info)
+	if !ok {
+		return Key{}, false
+	}
+	if algorithm == "" {
+		algorithm = "xxh64"
+	}
+	return Key{Dev: dev, Inode: inode, Size: info.Size(), ModTime: info.ModTime().UnixNano(), Algorithm: strings.ToLower(algorithm)}, true
+}
+
+func (k Key) bytes() []byte {
+	b := make([]byte, 32, 32+len(k.Algorithm))
+	binary.BigEndian.PutUint64(b[0:], k.Dev)
+	binary.BigEndian.PutUint64(b[8:], k.Inode)
+	binary.BigEndian.PutUint64(b[16:], uint64(k.Size))
+	binary.BigEndian.PutUint64(b[24:], uint64(k.ModTime))
+	return append(b, k.Algorithm...)
+}
+
+// entry is the stored value of a key
+type entry struct {
+	Hash string `json:"h"`
+	Path string `json:"p"` // last path the file was hashed at, for Forget
+	Used int64  `json:"u"` // unix seconds of the last store or refresh
+}
+
+// Cache is a persistent hash cache; it is safe for concurrent use
+type Cache struct {
+	db     *bolt.DB
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// DefaultPath is hashes.db in the merkle-go directory of the user's cache
+// directory
+func DefaultPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find cache directory: %w", err)
+	}
+	return filepath.Join(dir, "merkle-go", "hashes.db"), nil
+}
+
+// Open opens or creates the cache at path. It waits up to a second for
+// another process to release the database, then fails with ErrLocked.
+func Open(path string) (*Cache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		if errors.Is(err, bolt.ErrTimeout) {
+			return nil, fmt.Errorf("%s: %w", path, ErrLocked)
+		}
+		return nil, fmt.Errorf("failed to open hash cache: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open hash cache: %w", err)
+	}
+	return &Cache{db: db}, nil
+}
+
+// Close closes the database
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+// Get returns the cached hash for key
+func (c *Cache) Get(key Key) (string, bool) {
+	var e entry
+	found := false
+	c.db.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(bucket).Get(key.bytes()); data != nil {
+			found = json.Unmarshal(data, &e) == nil && e.Hash != ""
+		}
+		return nil
+	})
+	if !found {
+		c.misses.Add(1)
+		return "", false
+	}
+	c.hits.Add(1)
+	if time.Since(time.Unix(e.Used, 0)) > refreshAfter {
+		c.Put(key, e.Path, e.Hash)
+	}
+	return e.Hash, true
+}
+
+// Put records the hash of the file at path with the given key. Writes from
+// concurrent callers are batched into shared transactions.
+func (c *Cache) Put(key Key, path, hash string) error {
+	data, err := json.Marshal(entry{Hash: hash, Path: path, Used: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+	return c.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put(key.bytes(), data)
+	})
+}
+
+// Wrap returns hashFunc with the cache in front of it. A file is only
+// stored if its identity is the same before and after hashing, so a file
+// modified while it was read is not cached under its old key.
+func (c *Cache) Wrap(hashFunc func(path string) (string, error), algorithm string) func(path string) (string, error) {
+	return func(path string) (string, error) {
+		before, err := os.Stat(path)
+		if err != nil {
+			return hashFunc(path)
+		}
+		key, ok := KeyOf(before, algorithm)
+		if !ok {
+			return hashFunc(path)
+		}
+		if digest, ok := c.Get(key); ok {
+			return digest, nil
+		}
+
+		digest, err := hashFunc(path)
+		if err != nil {
+			return "", err
+		}
+		if after, err := os.Stat(path); err == nil {
+			if afterKey, ok := KeyOf(after, algorithm); ok && afterKey == key {
+				c.Put(key, path, digest)
+			}
+		}
+		return digest, nil
+	}
+}
+
+// Hits and Misses count the lookups since Open
+func (c *Cache) Hits() int64   { return c.hits.Load() }
+func (c *Cache) Misses() int64 { return c.misses.Load() }
+
+// Stats describes the content of the cache
+type Stats struct {
+	Entries int
+	Size    int64 // bytes of the database file
+	Oldest  time.Time
+}
+
+// Stats counts the entries in the cache
+func (c *Cache) Stats() (Stats, error) {
+	var stats Stats
+	err := c.db.View(func(tx *bolt.Tx) error {
+		stats.Size = tx.Size()
+		return tx.Bucket(bucket).ForEach(func(_, data []byte) error {
+			stats.Entries++
+			var e entry
+			if json.Unmarshal(data, &e) == nil {
+				used := time.Unix(e.Used, 0)
+				if stats.Oldest.IsZero() || used.Before(stats.Oldest) {
+					stats.Oldest = used
+				}
+			}
+			return nil
+		})
+	})
+	return stats, err
+}
+
+// Clear removes every entry and returns how many there were
+func (c *Cache) Clear() (int, error) {
+	return c.remove(func(entry) bool { return true })
+}
+
+// Prune removes the entries not used since before
+func (c *Cache) Prune(before time.Time) (int, error) {
+	return c.remove(func(e entry) bool { return e.Used < before.Unix() })
+}
+
+// Forget removes the entries of files last hashed at path or under it
+func (c *Cache) Forget(path string) (int, error) {
+	clean := filepath.Clean(path)
+	return c.remove(func(e entry) bool {
+		return e.Path == clean || strings.HasPrefix(e.Path, strings.TrimSuffix(clean, string(filepath.Separator))+string(filepath.Separator))
+	})
+}
+
+// remove deletes the entries matching match
+func (c *Cache) remove(match func(entry) bool) (int, error) {
+	removed := 0
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		var keys [][]byte
+		err := b.ForEach(func(key, data []byte) error {
+			var e entry
+			if json.Unmarshal(data, &e) != nil || match(e) {
+				keys = append(keys, bytes.Clone(key))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := b.Delete(key); err != nil {
+				return err
+			}
+		}
+		removed = len(keys)
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,124 @@
+package hashcache
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func openTemp(t *testing.T) *Cache {
+	t.Helper()
+	cache, err := Open(filepath.Join(t.TempDir(), "cache", "hashes.db"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { cache.Close() })
+	return cache
+}
+
+func TestWrapSkipsUnchangedFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no inode numbers")
+	}
+	cache := openTemp(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	os.WriteFile(path, []byte("hello"), 0644)
+
+	var calls atomic.Int64
+	hashFunc := cache.Wrap(func(string) (string, error) {
+		return "digest" + string(rune('0'+calls.Add(1))), nil
+	}, "")
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := hashFunc(path); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	first := calls.Load()
+
+	digest, err := hashFunc(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != first {
+		t.Errorf("unchanged file was rehashed")
+	}
+	if cache.Hits() == 0 {
+		t.Error("expected a cache hit")
+	}
+
+	// A new modification time is a different key
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(path, later, later)
+	changed, err := hashFunc(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != first+1 || changed == digest {
+		t.Errorf("modified file was not rehashed (calls %d, digest %s)", calls.Load(), changed)
+	}
+
+	// Another algorithm does not share entries
+	other := cache.Wrap(func(string) (string, error) { return "sha", nil }, "sha256")
+	if got, _ := other(path); got != "sha" {
+		t.Errorf("sha256 lookup returned %s", got)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	cache := openTemp(t)
+	keep := Key{Dev: 1, Inode: 1, Size: 1, Algorithm: "xxh64"}
+	gone := Key{Dev: 1, Inode: 2, Size: 1, Algorithm: "xxh64"}
+	cache.Put(keep, filepath.Join("/data", "keep", "a"), "aaaa")
+	cache.Put(gone, filepath.Join("/data", "gone", "b"), "bbbb")
+
+	if stats, err := cache.Stats(); err != nil || stats.Entries != 2 {
+		t.Fatalf("Stats() = %+v, %v", stats, err)
+	}
+
+	n, err := cache.Forget(filepath.Join("/data", "gone"))
+	if err != nil || n != 1 {
+		t.Fatalf("Forget() = %d, %v", n, err)
+	}
+	if _, ok := cache.Get(gone); ok {
+		t.Error("forgotten entry is still cached")
+	}
+	if _, ok := cache.Get(keep); !ok {
+		t.Error("other entry was forgotten too")
+	}
+
+	if n, err := cache.Prune(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("Prune() of fresh entries = %d, %v", n, err)
+	}
+	if n, err := cache.Prune(time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Errorf("Prune() = %d, %v", n, err)
+	}
+
+	cache.Put(keep, "/data/keep/a", "aaaa")
+	if n, err := cache.Clear(); err != nil || n != 1 {
+		t.Errorf("Clear() = %d, %v", n, err)
+	}
+}
+
+func TestOpenLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.db")
+	cache, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if _, err := Open(path); err == nil {
+		t.Error("second Open() of a held cache should fail")
+	}
+}
@@ -0,0 +1,128 @@
+package image
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"merkle-go/internal/archive"
+)
+
+// Whiteout markers of the OCI layer format: .wh.<name> deletes name from
+// the layers below, and .wh..wh..opq hides everything the layers below put
+// in its directory
+const (
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+// File is a regular file of a flattened image
+type File struct {
+	Hash    string
+	Size    int64
+	ModTime time.Time
+	Layer   int // index of the layer that provided the file
+}
+
+// Flatten unpacks the layers of img in order, the way a container runtime
+// builds the image filesystem, and hashes each regular file with the named
+// algorithm. Files are keyed by slash path relative to the image root.
+// Symbolic links, directories and special files are not recorded. Every
+// layer is checked against its digest.
+func Flatten(ctx context.Context, img *Image, algorithm string, onLayer func(index int, layer Layer)) (map[string]File, error) {
+	files := make(map[string]File)
+	for index, layer := range img.Layers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if onLayer != nil {
+			onLayer(index, layer)
+		}
+		if err := applyLayer(ctx, files, index, layer, algorithm); err != nil {
+			return nil, fmt.Errorf("layer %d (%s): %w", index, layer.Digest, err)
+		}
+	}
+	return files, nil
+}
+
+func applyLayer(ctx context.Context, files map[string]File, index int, layer Layer, algorithm string) error {
+	rc, err := layer.Open(ctx)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	err = archive.ReadTar(rc, func(header *tar.Header, content io.Reader) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name, ok := archive.CleanName(header.Name)
+		if !ok {
+			return nil
+		}
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+
+		switch {
+		case base == opaqueWhiteout:
+			removeBelow(files, dir, index)
+			return nil
+		case strings.HasPrefix(base, whiteoutPrefix):
+			target := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+			delete(files, target)
+			removeBelow(files, target, index)
+			return nil
+		}
+
+		// Whatever was at this path is replaced; a non-directory also
+		// replaces a directory of the same name with all its content
+		delete(files, name)
+		if header.Typeflag != tar.TypeDir {
+			removeBelow(files, name, index+1)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeReg:
+			digest, err := archive.HashReader(content, algorithm)
+			if err != nil {
+				return fmt.Errorf("%s: %w", header.Name, err)
+			}
+			files[name] = File{Hash: digest, Size: header.Size, ModTime: header.ModTime, Layer: index}
+		case tar.TypeLink:
+			// Hard links point to an earlier entry of the same layer
+			if target, ok := archive.CleanName(header.Linkname); ok {
+				if file, exists := files[target]; exists {
+					file.ModTime = header.ModTime
+					file.Layer = index
+					files[name] = file
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The tar reader stops at the end-of-archive marker; read the rest so
+	// the digest covers the whole blob
+	if _, err := io.Copy(io.Discard, rc); err != nil {
+		return err
+	}
+	return nil
+}
+
+// removeBelow deletes the files under dir ("" is the root) that come from
+// layers before layer
+func removeBelow(files map[string]File, dir string, layer int) {
+	prefix := dir + "/"
+	for name, file := range files {
+		if (dir == "" || strings.HasPrefix(name, prefix)) && file.Layer < layer {
+			delete(files, name)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// Package image reads container images, from an OCI image layout directory
+// or a registry, and flattens their layers into the files of the image
+// filesystem so that they can be hashed into a tree.
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	gohash "hash"
+	"io"
+	"runtime"
+	"strings"
+)
+
+// Media types of the manifests that are understood
+const (
+	MediaTypeOCIIndex      = "application/vnd.oci.image.index.v1+json"
+	MediaTypeOCIManifest   = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeDockerList    = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerImage   = "application/vnd.docker.distribution.manifest.v2+json"
+	manifestAcceptHeader   = MediaTypeOCIIndex + ", " + MediaTypeOCIManifest + ", " + MediaTypeDockerList + ", " + MediaTypeDockerImage
+	maxManifestSize        = 4 << 20
+	maxManifestIndirection = 4
+)
+
+// ErrDigestMismatch is returned for a manifest or layer whose content does
+// not match its digest
+var ErrDigestMismatch = errors.New("content does not match its digest")
+
+// Platform selects one image of a multi-platform index
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// DefaultPlatform is linux on the architecture merkle-go was built for
+func DefaultPlatform() Platform {
+	return Platform{OS: "linux", Architecture: runtime.GOARCH}
+}
+
+// ParsePlatform parses os/arch[/variant], e.g. linux/arm64/v8
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q (want os/arch[/variant])", s)
+	}
+	platform := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		platform.Variant = parts[2]
+	}
+	return platform, nil
+}
+
+func (p Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+// matches reports whether an index entry for other can run as p; a
+// missing variant on either side matches any
+func (p Platform) matches(other *Platform) bool {
+	if other == nil {
+		return false
+	}
+	return p.OS == other.OS && p.Architecture == other.Architecture &&
+		(p.Variant == "" || other.Variant == "" || p.Variant == other.Variant)
+}
+
+// descriptor points to a manifest or blob by digest
+type descriptor struct {
+	MediaType string    `json:"mediaType"`
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	Platform  *Platform `json:"platform,omitempty"`
+}
+
+// manifest holds the fields of image manifests and indexes that are used
+type manifest struct {
+	MediaType string       `json:"mediaType"`
+	Manifests []descriptor `json:"manifests"`
+	Layers    []descriptor `json:"layers"`
+}
+
+func (m *manifest) isIndex() bool {
+	return m.MediaType == MediaTypeOCIIndex || m.MediaType == MediaTypeDockerList ||
+		(m.MediaType == "" && len(m.Manifests) > 0 && len(m.Layers) == 0)
+}
+
+// store fetches manifests and blobs by digest
+type store interface {
+	manifest(ctx context.Context, digest string) ([]byte, error)
+	blob(ctx context.Context, digest string) (io.ReadCloser, error)
+}
+
+// Layer is one filesystem layer of an image: a tar stream, usually gzip
+// compressed
+type Layer struct {
+	Digest    string
+	Size      int64
+	MediaType string
+
+	store store
+}
+
+// Open returns the content of the layer blob. Reading it to the end fails
+// with ErrDigestMismatch if the content does not match the digest.
+func (l Layer) Open(ctx context.Context) (io.ReadCloser, error) {
+	rc, err := l.store.blob(ctx, l.Digest)
+	if err != nil {
+		return nil, err
+	}
+	h, want, err := digestHash(l.Digest)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &verifyingReader{rc: rc, h: h, want: want}, nil
+}
+
+// Image is the single-platform image an image reference resolved to
+type Image struct {
+	Name     string // reference or layout directory the image was read from
+	Digest   string // digest of the image manifest
+	Platform Platform
+	Layers   []Layer
+}
+
+// resolve follows index entries from the manifest with the given digest
+// down to the image manifest for platform
+func resolve(ctx context.Context, s store, name string, data []byte, digest string, platform Platform) (*Image, error) {
+	for range maxManifestIndirection {
+		var m manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("failed to parse manifest %s: %w", digest, err)
+		}
+		if !m.isIndex() {
+			if len(m.Layers) == 0 && m.MediaType != MediaTypeOCIManifest && m.MediaType != MediaTypeDockerImage {
+				return nil, fmt.Errorf("unsupported manifest %s (media type %q)", digest, m.MediaType)
+			}
+			img := &Image{Name: name, Digest: digest, Platform: platform}
+			for _, layer := range m.Layers {
+				img.Layers = append(img.Layers, Layer{Digest: layer.Digest, Size: layer.Size, MediaType: layer.MediaType, store: s})
+			}
+			return img, nil
+		}
+
+		next, err := selectManifest(m.Manifests, platform)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		digest = next.Digest
+		if data, err = s.manifest(ctx, digest); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%s: too many nested image indexes", name)
+}
+
+// selectManifest picks the index entry for platform. An index with a
+// single entry that names no platform, as in a layout written by a
+// single-image tool, resolves to that entry.
+func selectManifest(entries []descriptor, platform Platform) (descriptor, error) {
+	for _, entry := range entries {
+		if platform.matches(entry.Platform) {
+			return entry, nil
+		}
+	}
+	if len(entries) == 1 && entries[0].Platform == nil {
+		return entries[0], nil
+	}
+	var available []string
+	for _, entry := range entries {
+		if entry.Platform != nil {
+			available = append(available, entry.Platform.String())
+		}
+	}
+	if len(available) == 0 {
+		return descriptor{}, fmt.Errorf("no image for platform %s", platform)
+	}
+	return descriptor{}, fmt.Errorf("no image for platform %s (available: %s)", platform, strings.Join(available, ", "))
+}
+
+// digestHash returns the hash of the algorithm a digest was computed with
+// and its hex value
+func digestHash(digest string) (gohash.Hash, string, error) {
+	algorithm, encoded, ok := strings.Cut(digest, ":")
+	if !ok || algorithm != "sha256" || len(encoded) != sha256.Size*2 {
+		return nil, "", fmt.Errorf("unsupported digest %q", digest)
+	}
+	if _, err := hex.DecodeString(encoded); err != nil {
+		return nil, "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return sha256.New(), encoded, nil
+}
+
+// verifyDigest checks that data matches digest
+func verifyDigest(data []byte, digest string) error {
+	h, want, err := digestHash(digest)
+	if err != nil {
+		return err
+	}
+	h.Write(data)
+	if hex.EncodeToString(h.Sum(nil)) != want {
+		return fmt.Errorf("%s: %w", digest, ErrDigestMismatch)
+	}
+	return nil
+}
+
+// verifyingReader hashes a blob as it is read and checks the digest at EOF
+type verifyingReader struct {
+	rc   io.ReadCloser
+	h    gohash.Hash
+	want string
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.rc.Read(p)
+	v.h.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(v.h.Sum(nil)) != v.want {
+		return n, fmt.Errorf("sha256:%s: %w", v.want, ErrDigestMismatch)
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.rc.Close()
+}
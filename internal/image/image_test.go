@@ -0,0 +1,274 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"merkle-go/internal/archive"
+)
+
+type entry struct {
+	name     string
+	content  string
+	typeflag byte
+	linkname string
+}
+
+func layerBlob(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		typeflag := e.typeflag
+		if typeflag == 0 {
+			typeflag = tar.TypeReg
+		}
+		hdr := &tar.Header{Name: e.name, Typeflag: typeflag, Linkname: e.linkname, Mode: 0644}
+		if typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.content))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(e.content))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// testImage returns the blobs of a two-layer image behind a two-platform
+// index, keyed by digest, and the digest of the index
+func testImage(t *testing.T) (map[string][]byte, string) {
+	t.Helper()
+	blobs := make(map[string][]byte)
+	add := func(data []byte) descriptor {
+		digest := digestOf(data)
+		blobs[digest] = data
+		return descriptor{Digest: digest, Size: int64(len(data))}
+	}
+	addJSON := func(mediaType string, v any) descriptor {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := add(data)
+		d.MediaType = mediaType
+		return d
+	}
+
+	base := add(layerBlob(t,
+		entry{name: "etc/", typeflag: tar.TypeDir},
+		entry{name: "etc/os-release", content: "base"},
+		entry{name: "etc/passwd", content: "root"},
+		entry{name: "var/cache/a", content: "cached"},
+		entry{name: "var/cache/b", content: "cached"},
+		entry{name: "bin/sh", content: "shell"},
+	))
+	top := add(layerBlob(t,
+		entry{name: "etc/.wh.passwd"},
+		entry{name: "var/cache/new", content: "fresh"},
+		entry{name: "var/cache/.wh..wh..opq"},
+		entry{name: "etc/os-release", content: "app"},
+		entry{name: "bin/bash", typeflag: tar.TypeLink, linkname: "bin/sh"},
+		entry{name: "bin/sh", content: "new shell"},
+		entry{name: "usr/bin/env", typeflag: tar.TypeSymlink, linkname: "/bin/env"},
+	))
+	base.MediaType = "application/vnd.oci.image.layer.v1.tar+gzip"
+	top.MediaType = base.MediaType
+
+	amd64 := addJSON(MediaTypeOCIManifest, manifest{MediaType: MediaTypeOCIManifest, Layers: []descriptor{base, top}})
+	amd64.Platform = &Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := addJSON(MediaTypeOCIManifest, manifest{MediaType: MediaTypeOCIManifest, Layers: []descriptor{base}})
+	arm64.Platform = &Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	index := addJSON(MediaTypeOCIIndex, manifest{MediaType: MediaTypeOCIIndex, Manifests: []descriptor{amd64, arm64}})
+	return blobs, index.Digest
+}
+
+func writeLayout(t *testing.T, blobs map[string][]byte, indexDigest string) string {
+	t.Helper()
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644)
+	os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755)
+	for digest, data := range blobs {
+		os.WriteFile(filepath.Join(dir, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:")), data, 0644)
+	}
+	index, _ := json.Marshal(manifest{Manifests: []descriptor{{MediaType: MediaTypeOCIIndex, Digest: indexDigest, Size: int64(len(blobs[indexDigest]))}}})
+	os.WriteFile(filepath.Join(dir, "index.json"), index, 0644)
+	return dir
+}
+
+func hashOf(t *testing.T, content string) string {
+	t.Helper()
+	digest, err := archive.HashReader(strings.NewReader(content), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return digest
+}
+
+func TestFlattenLayout(t *testing.T) {
+	blobs, index := testImage(t)
+	dir := writeLayout(t, blobs, index)
+	ctx := context.Background()
+
+	img, err := OpenLayout(ctx, dir, Platform{OS: "linux", Architecture: "amd64"})
+	if err != nil {
+		t.Fatalf("OpenLayout() error = %v", err)
+	}
+	if len(img.Layers) != 2 {
+		t.Fatalf("got %d layers, want 2", len(img.Layers))
+	}
+	files, err := Flatten(ctx, img, "", nil)
+	if err != nil {
+		t.Fatalf("Flatten() error = %v", err)
+	}
+
+	want := map[string]string{
+		"etc/os-release": "app",
+		"var/cache/new":  "fresh",
+		"bin/sh":         "new shell",
+		"bin/bash":       "shell", // linked before bin/sh was replaced
+	}
+	if len(files) != len(want) {
+		t.Errorf("got files %v, want %v", files, want)
+	}
+	for name, content := range want {
+		if files[name].Hash != hashOf(t, content) {
+			t.Errorf("%s = %+v, want content %q", name, files[name], content)
+		}
+	}
+
+	arm, err := OpenLayout(ctx, dir, Platform{OS: "linux", Architecture: "arm64"})
+	if err != nil {
+		t.Fatalf("OpenLayout(arm64) error = %v", err)
+	}
+	if len(arm.Layers) != 1 {
+		t.Errorf("arm64 image has %d layers, want 1", len(arm.Layers))
+	}
+	if _, err := OpenLayout(ctx, dir, Platform{OS: "windows", Architecture: "amd64"}); err == nil || !strings.Contains(err.Error(), "linux/arm64/v8") {
+		t.Errorf("OpenLayout(windows) error = %v, want the available platforms", err)
+	}
+}
+
+func TestFlattenCorruptLayer(t *testing.T) {
+	blobs, index := testImage(t)
+	dir := writeLayout(t, blobs, index)
+	ctx := context.Background()
+	img, err := OpenLayout(ctx, dir, Platform{OS: "linux", Architecture: "arm64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep the layer valid gzip/tar but change its bytes
+	path := filepath.Join(dir, "blobs", "sha256", strings.TrimPrefix(img.Layers[0].Digest, "sha256:"))
+	os.WriteFile(path, layerBlob(t, entry{name: "etc/os-release", content: "evil"}), 0644)
+
+	if _, err := Flatten(ctx, img, "", nil); !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("Flatten() error = %v, want ErrDigestMismatch", err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	blobs, index := testImage(t)
+	var tokenRequests int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		if r.URL.Query().Get("scope") != "repository:team/app:pull" {
+			http.Error(w, "bad scope", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "secret"})
+	})
+	var server *httptest.Server
+	mux.HandleFunc("/v2/team/app/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test",scope="repository:team/app:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		kind, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v2/team/app/"), "/")
+		if kind == "manifests" && name == "v1" {
+			name = index
+		}
+		data, ok := blobs[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if kind == "manifests" {
+			w.Header().Set("Docker-Content-Digest", name)
+		}
+		w.Write(data)
+	})
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	ref, err := ParseReference(strings.TrimPrefix(server.URL, "http://") + "/team/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := &Registry{Client: server.Client(), Insecure: true}
+	img, err := registry.Open(context.Background(), ref, Platform{OS: "linux", Architecture: "amd64"})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	files, err := Flatten(context.Background(), img, "", nil)
+	if err != nil {
+		t.Fatalf("Flatten() error = %v", err)
+	}
+	if files["etc/os-release"].Hash != hashOf(t, "app") {
+		t.Errorf("etc/os-release = %+v", files["etc/os-release"])
+	}
+	if tokenRequests != 1 {
+		t.Errorf("fetched %d tokens, want 1", tokenRequests)
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	tests := []struct {
+		in   string
+		want Reference
+	}{
+		{"alpine", Reference{Registry: "docker.io", Repository: "library/alpine", Tag: "latest"}},
+		{"user/app:1.0", Reference{Registry: "docker.io", Repository: "user/app", Tag: "1.0"}},
+		{"ghcr.io/org/app:v2", Reference{Registry: "ghcr.io", Repository: "org/app", Tag: "v2"}},
+		{"localhost:5000/app", Reference{Registry: "localhost:5000", Repository: "app", Tag: "latest"}},
+		{"alpine@" + digest, Reference{Registry: "docker.io", Repository: "library/alpine", Digest: digest}},
+	}
+	for _, tt := range tests {
+		got, err := ParseReference(tt.in)
+		if err != nil {
+			t.Errorf("ParseReference(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseReference(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+	for _, bad := range []string{"Upper/Case", "alpine@sha256:short"} {
+		if _, err := ParseReference(bad); err == nil {
+			t.Errorf("ParseReference(%q) should fail", bad)
+		}
+	}
+}
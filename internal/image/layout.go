@@ -0,0 +1,80 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// layoutStore reads blobs from an OCI image layout directory
+type layoutStore string
+
+func (l layoutStore) path(digest string) (string, error) {
+	if _, _, err := digestHash(digest); err != nil {
+		return "", err
+	}
+	algorithm, encoded, _ := strings.Cut(digest, ":")
+	return filepath.Join(string(l), "blobs", algorithm, encoded), nil
+}
+
+func (l layoutStore) manifest(ctx context.Context, digest string) ([]byte, error) {
+	path, err := l.path(digest)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readLimited(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if err := verifyDigest(data, digest); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (l layoutStore) blob(ctx context.Context, digest string) (io.ReadCloser, error) {
+	path, err := l.path(digest)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// IsLayout reports whether dir is an OCI image layout directory
+func IsLayout(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "oci-layout"))
+	return err == nil
+}
+
+// OpenLayout resolves the image for platform in the OCI image layout at
+// dir, as written by skopeo copy oci:, docker buildx --output type=oci or
+// crane push --format oci after extracting the tarball
+func OpenLayout(ctx context.Context, dir string, platform Platform) (*Image, error) {
+	if !IsLayout(dir) {
+		return nil, fmt.Errorf("%s is not an OCI image layout (no oci-layout file)", dir)
+	}
+	data, err := readLimited(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image index: %w", err)
+	}
+	return resolve(ctx, layoutStore(dir), dir, data, "index.json", platform)
+}
+
+func readLimited(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxManifestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxManifestSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", path, maxManifestSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,272 @@
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Reference names an image in a registry, e.g. ghcr.io/org/app:1.2 or
+// alpine@sha256:...
+type Reference struct {
+	Registry   string // host[:port]; docker.io for Docker Hub
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+const dockerHub = "docker.io"
+
+// ParseReference parses an image reference the way docker does: a first
+// component without a dot, colon or "localhost" is a Docker Hub
+// repository, single-component Hub names live under library/, and the
+// tag defaults to latest
+func ParseReference(s string) (Reference, error) {
+	var ref Reference
+	rest := s
+	if name, digest, ok := strings.Cut(rest, "@"); ok {
+		if _, _, err := digestHash(digest); err != nil {
+			return Reference{}, fmt.Errorf("invalid image reference %q: %w", s, err)
+		}
+		rest, ref.Digest = name, digest
+	}
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		rest, ref.Tag = rest[:i], rest[i+1:]
+	}
+
+	first, remainder, ok := strings.Cut(rest, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.Registry, ref.Repository = first, remainder
+	} else {
+		ref.Registry, ref.Repository = dockerHub, rest
+		if !strings.Contains(rest, "/") {
+			ref.Repository = "library/" + rest
+		}
+	}
+	if ref.Repository == "" || ref.Repository != strings.ToLower(ref.Repository) {
+		return Reference{}, fmt.Errorf("invalid image reference %q", s)
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	return ref, nil
+}
+
+func (r Reference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// Registry fetches images over the registry HTTP API. Only anonymous pulls
+// are supported, including the bearer tokens public registries hand out.
+type Registry struct {
+	Client *http.Client // nil means http.DefaultClient
+
+	// Insecure talks plain HTTP instead of HTTPS, for local test registries
+	Insecure bool
+}
+
+// Open resolves ref to the image for platform
+func (r *Registry) Open(ctx context.Context, ref Reference, platform Platform) (*Image, error) {
+	s := &registryStore{registry: r, ref: ref}
+	top := ref.Digest
+	if top == "" {
+		top = ref.Tag
+	}
+	data, err := s.manifest(ctx, top)
+	if err != nil {
+		return nil, err
+	}
+	digest := ref.Digest
+	if digest == "" {
+		sum := sha256.Sum256(data)
+		digest = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return resolve(ctx, s, ref.String(), data, digest, platform)
+}
+
+// registryStore fetches the manifests and blobs of one repository
+type registryStore struct {
+	registry *Registry
+	ref      Reference
+
+	mu    sync.Mutex
+	token string
+}
+
+func (s *registryStore) url(kind, name string) string {
+	scheme := "https"
+	if s.registry.Insecure {
+		scheme = "http"
+	}
+	host := s.ref.Registry
+	if host == dockerHub {
+		host = "registry-1.docker.io"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/%s/%s", scheme, host, s.ref.Repository, kind, name)
+}
+
+func (s *registryStore) manifest(ctx context.Context, reference string) ([]byte, error) {
+	resp, err := s.get(ctx, s.url("manifests", reference), manifestAcceptHeader)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if len(data) > maxManifestSize {
+		return nil, fmt.Errorf("manifest %s is larger than %d bytes", reference, maxManifestSize)
+	}
+
+	if strings.Contains(reference, ":") {
+		if err := verifyDigest(data, reference); err != nil {
+			return nil, err
+		}
+	} else if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		if err := verifyDigest(data, digest); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func (s *registryStore) blob(ctx context.Context, digest string) (io.ReadCloser, error) {
+	resp, err := s.get(ctx, s.url("blobs", digest), "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// get performs a GET, fetching a bearer token and retrying once if the
+// registry asks for one
+func (s *registryStore) get(ctx context.Context, target, accept string) (*http.Response, error) {
+	client := s.registry.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		s.mu.Lock()
+		if s.token != "" {
+			req.Header.Set("Authorization", "Bearer "+s.token)
+		}
+		s.mu.Unlock()
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return nil, fmt.Errorf("GET %s: %s", target, resp.Status)
+		}
+
+		token, err := fetchToken(ctx, client, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return nil, fmt.Errorf("GET %s: %w", target, err)
+		}
+		s.mu.Lock()
+		s.token = token
+		s.mu.Unlock()
+	}
+}
+
+// fetchToken requests an anonymous token from the realm of a Bearer
+// challenge
+func fetchToken(ctx context.Context, client *http.Client, challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("registry requires %q authentication, which is not supported", scheme)
+	}
+	fields := parseChallenge(params)
+	realm, err := url.Parse(fields["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid token realm %q", fields["realm"])
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if value := fields[key]; value != "" {
+			query.Set(key, value)
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch registry token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch registry token: %s", resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to parse registry token: %w", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", fmt.Errorf("registry returned an empty token")
+}
+
+// parseChallenge parses the key="value" parameters of a WWW-Authenticate
+// header; values may contain commas inside the quotes
+func parseChallenge(params string) map[string]string {
+	fields := make(map[string]string)
+	for params != "" {
+		key, rest, ok := strings.Cut(params, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		fields[key] = value
+		params = strings.TrimLeft(rest, ", ")
+	}
+	return fields
+}
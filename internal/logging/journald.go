@@ -0,0 +1,160 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// JournalSocket is where journald receives native protocol datagrams
+const JournalSocket = "/run/systemd/journal/socket"
+
+// JournalHandler sends each record to journald as a structured entry:
+// MESSAGE, PRIORITY and SYSLOG_IDENTIFIER, plus one field per attribute
+// with its key upper-cased, e.g. path becomes PATH, so journalctl can
+// filter on them (journalctl PATH=/data)
+type JournalHandler struct {
+	mu         *sync.Mutex
+	conn       net.Conn
+	identifier string
+	level      slog.Leveler
+	attrs      []slog.Attr
+	group      string
+}
+
+// NewJournalHandler connects to the journald socket at socketPath
+func NewJournalHandler(socketPath, identifier string, level slog.Leveler) (*JournalHandler, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to journald: %w", err)
+	}
+	if level == nil {
+		level = slog.LevelInfo
+	}
+	return &JournalHandler{mu: &sync.Mutex{}, conn: conn, identifier: identifier, level: level}, nil
+}
+
+// Close closes the connection to journald
+func (h *JournalHandler) Close() error {
+	return h.conn.Close()
+}
+
+func (h *JournalHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *JournalHandler) Handle(ctx context.Context, record slog.Record) error {
+	var b bytes.Buffer
+	writeJournalField(&b, "MESSAGE", record.Message)
+	writeJournalField(&b, "PRIORITY", journalPriority(record.Level))
+	if h.identifier != "" {
+		writeJournalField(&b, "SYSLOG_IDENTIFIER", h.identifier)
+	}
+	for _, attr := range h.attrs {
+		writeJournalAttr(&b, "", attr)
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		writeJournalAttr(&b, h.group, attr)
+		return true
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.conn.Write(b.Bytes())
+	return err
+}
+
+func (h *JournalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), qualify(h.group, attrs)...)
+	return &clone
+}
+
+func (h *JournalHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	if clone.group != "" {
+		clone.group += "."
+	}
+	clone.group += name
+	return &clone
+}
+
+// journalPriority maps a level to its syslog priority
+func journalPriority(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "3"
+	case level >= slog.LevelWarn:
+		return "4"
+	case level >= slog.LevelInfo:
+		return "6"
+	default:
+		return "7"
+	}
+}
+
+func writeJournalAttr(b *bytes.Buffer, group string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	key := attr.Key
+	if group != "" {
+		key = group + "." + key
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		for _, child := range attr.Value.Group() {
+			writeJournalAttr(b, key, child)
+		}
+		return
+	}
+	if name := journalFieldName(key); name != "" {
+		writeJournalField(b, name, attr.Value.String())
+	}
+}
+
+// journalFieldName turns an attribute key into a journal field name, which
+// may only hold upper-case letters, digits and underscores and must not
+// start with an underscore (reserved for trusted fields) or a digit
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return unicode.ToUpper(r)
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	name = strings.TrimLeft(name, "_0123456789")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// writeJournalField appends one field in the native protocol: NAME=value,
+// or, for values with newlines, the name, a newline, the value length as a
+// little-endian 64-bit integer and the value itself
+func writeJournalField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
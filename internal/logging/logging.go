@@ -0,0 +1,191 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Options controls where log records go and how they are rendered
+type Options struct {
+	Verbose bool   // Include debug records
+	Quiet   bool   // Only warnings and errors
+	Format  string // "text" (default), "json" or "journald"
+	File    string // Append records to this file instead of stderr
+}
+
+// Level returns the minimum level implied by the verbosity flags
+func (o Options) Level() slog.Level {
+	switch {
+	case o.Quiet:
+		return slog.LevelWarn
+	case o.Verbose:
+		return slog.LevelDebug
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// Interactive reports whether the log settings leave room for progress bars
+// and other decorations. It does not look at the terminal; progress bars
+// are drawn only when stderr is one.
+func (o Options) Interactive() bool {
+	format := strings.ToLower(o.Format)
+	return !o.Quiet && format != "json" && format != "journald" && o.File == ""
+}
+
+// Setup creates a logger from opts and installs it as the slog default.
+// The returned closer must be called to flush and close the log file.
+func Setup(opts Options) (*slog.Logger, io.Closer, error) {
+	var writer io.Writer = os.Stderr
+	var closer io.Closer = nopCloser{}
+
+	if opts.File != "" {
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		writer = f
+		closer = f
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: opts.Level()}
+
+	var handler slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		if opts.File != "" {
+			handler = slog.NewTextHandler(writer, handlerOpts)
+		} else {
+			handler = NewConsoleHandler(writer, handlerOpts.Level)
+		}
+	case "json":
+		handler = slog.NewJSONHandler(writer, handlerOpts)
+	case "journald":
+		if opts.File != "" {
+			closer.Close()
+			return nil, nil, fmt.Errorf("the journald log format cannot be written to a log file")
+		}
+		journal, err := NewJournalHandler(JournalSocket, "merkle-go", handlerOpts.Level)
+		if err != nil {
+			return nil, nil, err
+		}
+		handler = journal
+		closer = journal
+	default:
+		closer.Close()
+		return nil, nil, fmt.Errorf("unknown log format %q (expected text, json or journald)", opts.Format)
+	}
+
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	return logger, closer, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// ConsoleHandler renders records for a terminal: the message followed by
+// key=value attributes, with no timestamp and a prefix for warnings/errors
+type ConsoleHandler struct {
+	mu     *sync.Mutex
+	writer io.Writer
+	level  slog.Leveler
+	attrs  []slog.Attr
+	group  string
+}
+
+func NewConsoleHandler(writer io.Writer, level slog.Leveler) *ConsoleHandler {
+	if level == nil {
+		level = slog.LevelInfo
+	}
+	return &ConsoleHandler{mu: &sync.Mutex{}, writer: writer, level: level}
+}
+
+func (h *ConsoleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *ConsoleHandler) Handle(ctx context.Context, record slog.Record) error {
+	var b strings.Builder
+
+	switch {
+	case record.Level >= slog.LevelError:
+		b.WriteString("Error: ")
+	case record.Level >= slog.LevelWarn:
+		b.WriteString("⚠ ")
+	case record.Level < slog.LevelInfo:
+		b.WriteString("debug: ")
+	}
+	b.WriteString(record.Message)
+
+	for _, attr := range h.attrs {
+		writeAttr(&b, "", attr)
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		writeAttr(&b, h.group, attr)
+		return true
+	})
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.writer, b.String())
+	return err
+}
+
+func (h *ConsoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), qualify(h.group, attrs)...)
+	return &clone
+}
+
+func (h *ConsoleHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	if clone.group != "" {
+		clone.group += "."
+	}
+	clone.group += name
+	return &clone
+}
+
+func qualify(group string, attrs []slog.Attr) []slog.Attr {
+	if group == "" {
+		return attrs
+	}
+	qualified := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		qualified[i] = slog.Attr{Key: group + "." + attr.Key, Value: attr.Value}
+	}
+	return qualified
+}
+
+func writeAttr(b *strings.Builder, group string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	key := attr.Key
+	if group != "" {
+		key = group + "." + key
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		for _, child := range attr.Value.Group() {
+			writeAttr(b, key, child)
+		}
+		return
+	}
+
+	value := attr.Value.String()
+	if strings.ContainsAny(value, " \t\n\"") {
+		value = fmt.Sprintf("%q", value)
+	}
+	fmt.Fprintf(b, " %s=%s", key, value)
+}
@@ -0,0 +1,133 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConsoleHandler_Format(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewConsoleHandler(&buf, slog.LevelInfo))
+
+	logger.Info("Found files", "count", 3)
+	logger.Warn("Skipped files", "path", "/data/my file.txt")
+	logger.Debug("hidden")
+
+	expected := "Found files count=3\n⚠ Skipped files path=\"/data/my file.txt\"\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected output:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestOptions_Level(t *testing.T) {
+	if (Options{Quiet: true}).Level() != slog.LevelWarn {
+		t.Error("Quiet should only log warnings and errors")
+	}
+	if (Options{Verbose: true}).Level() != slog.LevelDebug {
+		t.Error("Verbose should log debug records")
+	}
+	if (Options{}).Level() != slog.LevelInfo {
+		t.Error("Default level should be info")
+	}
+}
+
+func TestSetup_JSONLogFile(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "merkle-go.log")
+
+	logger, closer, err := Setup(Options{Format: "json", File: logPath})
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+
+	logger.Info("scan complete", "files", 10)
+	if err := closer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+
+	var record map[string]any
+	if err := json.Unmarshal([]byte(strings.TrimSpace(string(data))), &record); err != nil {
+		t.Fatalf("Log file should contain a JSON record: %v", err)
+	}
+	if record["msg"] != "scan complete" || record["files"] != float64(10) {
+		t.Errorf("Unexpected record: %v", record)
+	}
+}
+
+func TestSetup_UnknownFormat(t *testing.T) {
+	if _, _, err := Setup(Options{Format: "xml"}); err == nil {
+		t.Error("Setup should reject unknown formats")
+	}
+}
+
+func TestJournalHandler(t *testing.T) {
+	dir, err := os.MkdirTemp("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets unavailable: %v", err)
+	}
+	defer conn.Close()
+
+	handler, err := NewJournalHandler(socket, "merkle-go", slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("NewJournalHandler failed: %v", err)
+	}
+	defer handler.Close()
+	logger := slog.New(handler).With("root", "abc")
+	logger.WithGroup("scan").Warn("Rescan failed", "error", "line one\nline two", "files", 3)
+	logger.Debug("hidden")
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Expected a journal entry: %v", err)
+	}
+
+	var multiline bytes.Buffer
+	multiline.WriteString("SCAN_ERROR\n")
+	binary.Write(&multiline, binary.LittleEndian, uint64(len("line one\nline two")))
+	multiline.WriteString("line one\nline two\n")
+	expected := "MESSAGE=Rescan failed\nPRIORITY=4\nSYSLOG_IDENTIFIER=merkle-go\nROOT=abc\n" +
+		multiline.String() + "SCAN_FILES=3\n"
+	if string(buf[:n]) != expected {
+		t.Errorf("Unexpected journal entry:\n%q\nexpected:\n%q", buf[:n], expected)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := conn.Read(buf); err == nil {
+		t.Error("Expected debug records to be left out")
+	}
+}
+
+func TestJournalFieldName(t *testing.T) {
+	for key, expected := range map[string]string{
+		"path":         "PATH",
+		"to_hash_size": "TO_HASH_SIZE",
+		"scan.files":   "SCAN_FILES",
+		"_secret":      "SECRET",
+		"1st":          "ST",
+	} {
+		if got := journalFieldName(key); got != expected {
+			t.Errorf("journalFieldName(%q) = %q, expected %q", key, got, expected)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// Package longpath gives Windows paths beyond the legacy MAX_PATH limit the
+// extended-length form Win32 APIs need. It is applied only where a file is
+// opened; the paths reported and recorded stay as given.
+package longpath
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// Open is os.Open through the extended-length form of path. Errors name
+// path as given.
+func Open(path string) (*os.File, error) {
+	return OpenFile(path, os.O_RDONLY, 0)
+}
+
+// OpenFile is os.OpenFile through the extended-length form of path. Errors
+// name path as given.
+func OpenFile(path string, flag int, perm os.FileMode) (*os.File, error) {
+	file, err := os.OpenFile(Path(path), flag, perm)
+	return file, named(err, path)
+}
+
+// Stat is os.Stat through the extended-length form of path. Errors name
+// path as given.
+func Stat(path string) (os.FileInfo, error) {
+	info, err := os.Stat(Path(path))
+	return info, named(err, path)
+}
+
+// Lstat is os.Lstat through the extended-length form of path. Errors name
+// path as given.
+func Lstat(path string) (os.FileInfo, error) {
+	info, err := os.Lstat(Path(path))
+	return info, named(err, path)
+}
+
+// named puts path back into a *fs.PathError that carries its long form
+func named(err error, path string) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		pathErr.Path = path
+	}
+	return err
+}
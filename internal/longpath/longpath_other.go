@@ -0,0 +1,13 @@
+//go:build !windows
+
+package longpath
+
+// Path is a no-op outside Windows, which has no MAX_PATH limit
+func Path(path string) string {
+	return path
+}
+
+// Root is a no-op outside Windows, which has no MAX_PATH limit
+func Root(path string) string {
+	return path
+}
@@ -0,0 +1,31 @@
+package longpath
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxShortPath is the length above which Win32 APIs need the \\?\ prefix
+const maxShortPath = 248
+
+// Path returns an extended-length form of an absolute path (\\?\C:\... or
+// \\?\UNC\server\share\...) when it exceeds the legacy MAX_PATH limit
+func Path(path string) string {
+	if len(path) < maxShortPath {
+		return path
+	}
+	return Root(path)
+}
+
+// Root returns the extended-length form of an absolute path whatever its
+// length, for roots whose walk may reach paths beyond MAX_PATH
+func Root(path string) string {
+	if strings.HasPrefix(path, `\\?\`) || !filepath.IsAbs(path) {
+		return path
+	}
+	path = filepath.Clean(path)
+	if strings.HasPrefix(path, `\\`) {
+		return `\\?\UNC\` + path[2:]
+	}
+	return `\\?\` + path
+}
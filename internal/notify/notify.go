@@ -0,0 +1,202 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Event is the summary of a scan or comparison that is sent to notifiers
+type Event struct {
+	Title    string    `json:"title"`
+	RootPath string    `json:"root"`
+	Time     time.Time `json:"time"`
+	Added    int       `json:"added"`
+	Modified int       `json:"modified"`
+	Deleted  int       `json:"deleted"`
+	Renamed  int       `json:"renamed,omitempty"`
+	Errors   int       `json:"errors"`
+	Report   string    `json:"report,omitempty"`
+
+	// MetadataOnly counts files whose modification time changed but whose
+	// content did not; only set by strict comparisons
+	MetadataOnly int `json:"metadata_only,omitempty"`
+
+	// CaseRenamed counts files whose path only changed in letter case;
+	// only set by case-insensitive comparisons
+	CaseRenamed int `json:"case_renamed,omitempty"`
+
+	// Alert says why the changes are notified when alert rules limit
+	// notifications to unusual change volumes
+	Alert string `json:"alert,omitempty"`
+}
+
+// HasChanges reports whether the event describes any file changes
+func (e Event) HasChanges() bool {
+	return e.Added > 0 || e.Modified > 0 || e.Deleted > 0 || e.Renamed > 0 || e.CaseRenamed > 0 || e.MetadataOnly > 0
+}
+
+// Summary returns a one-line description of the event
+func (e Event) Summary() string {
+	summary := fmt.Sprintf("%s: %d added, %d modified, %d deleted", e.RootPath, e.Added, e.Modified, e.Deleted)
+	if e.Renamed > 0 {
+		summary += fmt.Sprintf(", %d renamed", e.Renamed)
+	}
+	if e.CaseRenamed > 0 {
+		summary += fmt.Sprintf(", %d case-renamed", e.CaseRenamed)
+	}
+	if e.MetadataOnly > 0 {
+		summary += fmt.Sprintf(", %d metadata-only", e.MetadataOnly)
+	}
+	summary += fmt.Sprintf(", %d errors", e.Errors)
+	if e.Alert != "" {
+		summary += " (" + e.Alert + ")"
+	}
+	return summary
+}
+
+// Notifier delivers events to an alerting target
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// Factory creates a Notifier from the options of its config section
+type Factory func(options map[string]any) (Notifier, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a notifier kind available to New and FromConfig. It is meant
+// to be called from init functions; registering the same kind twice panics.
+func Register(kind string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, exists := registry[kind]; exists {
+		panic(fmt.Sprintf("notify: notifier %q registered twice", kind))
+	}
+	registry[kind] = factory
+}
+
+// New creates a notifier of the given registered kind
+func New(kind string, options map[string]any) (Notifier, error) {
+	registryMu.RLock()
+	factory, ok := registry[kind]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown notifier %q", kind)
+	}
+
+	notifier, err := factory(options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure %s notifier: %w", kind, err)
+	}
+	return notifier, nil
+}
+
+// FromConfig creates one notifier per config section, keyed by notifier kind
+// (e.g. the [notify.webhook] table). The result is nil if no sections are set.
+func FromConfig(sections map[string]map[string]any) (Multi, error) {
+	kinds := make([]string, 0, len(sections))
+	for kind := range sections {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	var notifiers Multi
+	for _, kind := range kinds {
+		notifier, err := New(kind, sections[kind])
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	return notifiers, nil
+}
+
+// Multi sends each event to every notifier it contains
+type Multi []Notifier
+
+// Notify delivers the event to all notifiers, returning the joined errors of
+// those that failed
+func (m Multi) Notify(ctx context.Context, event Event) error {
+	var errs []error
+	for _, notifier := range m {
+		if err := notifier.Notify(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stringOption returns a string option, or def if it is not set
+func stringOption(options map[string]any, key, def string) (string, error) {
+	value, ok := options[key]
+	if !ok {
+		return def, nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("option %q must be a string", key)
+	}
+	return s, nil
+}
+
+// intOption returns an integer option, or def if it is not set
+func intOption(options map[string]any, key string, def int) (int, error) {
+	value, ok := options[key]
+	if !ok {
+		return def, nil
+	}
+	switch n := value.(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	default:
+		return 0, fmt.Errorf("option %q must be an integer", key)
+	}
+}
+
+// stringsOption returns an option that may be a single string or an array
+// of strings
+func stringsOption(options map[string]any, key string) ([]string, error) {
+	switch value := options[key].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{value}, nil
+	case []string:
+		return value, nil
+	case []any:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("option %q must be a list of strings", key)
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("option %q must be a string or a list of strings", key)
+	}
+}
+
+// requiredString returns a string option that must be set and non-empty
+func requiredString(options map[string]any, key string) (string, error) {
+	s, err := stringOption(options, key, "")
+	if err != nil {
+		return "", err
+	}
+	if s == "" {
+		return "", fmt.Errorf("option %q is required", key)
+	}
+	return s, nil
+}
@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type recordingNotifier struct {
+	events []Event
+}
+
+func (r *recordingNotifier) Notify(ctx context.Context, event Event) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func TestNew_UnknownKind(t *testing.T) {
+	if _, err := New("carrier-pigeon", nil); err == nil {
+		t.Error("New should fail for an unregistered notifier kind")
+	}
+}
+
+func TestRegister_CustomNotifier(t *testing.T) {
+	recorder := &recordingNotifier{}
+	Register("test-recorder", func(options map[string]any) (Notifier, error) {
+		return recorder, nil
+	})
+
+	notifiers, err := FromConfig(map[string]map[string]any{"test-recorder": {}})
+	if err != nil {
+		t.Fatalf("FromConfig failed: %v", err)
+	}
+
+	if err := notifiers.Notify(context.Background(), Event{Added: 1}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if len(recorder.events) != 1 || recorder.events[0].Added != 1 {
+		t.Errorf("Expected custom notifier to receive the event, got %+v", recorder.events)
+	}
+}
+
+func TestWebhook_PostsEvent(t *testing.T) {
+	var received Event
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Failed to decode webhook body: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	notifier, err := New("webhook", map[string]any{
+		"url":     server.URL,
+		"headers": map[string]any{"Authorization": "Bearer token"},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	event := Event{RootPath: "/data", Modified: 2}
+	if err := notifier.Notify(context.Background(), event); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	if received.RootPath != "/data" || received.Modified != 2 {
+		t.Errorf("Unexpected event received: %+v", received)
+	}
+	if auth != "Bearer token" {
+		t.Errorf("Expected custom header to be sent, got %q", auth)
+	}
+}
+
+func TestWebhook_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	notifier, err := New("webhook", map[string]any{"url": server.URL})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if err := notifier.Notify(context.Background(), Event{}); err == nil {
+		t.Error("Notify should fail on a non-2xx response")
+	}
+}
+
+func TestWebhook_MissingURL(t *testing.T) {
+	if _, err := New("webhook", map[string]any{}); err == nil {
+		t.Error("Webhook notifier should require a url")
+	}
+}
+
+func TestSlack_PostsSummary(t *testing.T) {
+	var payload map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Failed to decode slack body: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	notifier, err := New("slack", map[string]any{"webhook_url": server.URL, "channel": "#alerts"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	event := Event{Title: "merkle-go compare", RootPath: "/data", Deleted: 3}
+	if err := notifier.Notify(context.Background(), event); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	if payload["channel"] != "#alerts" {
+		t.Errorf("Expected channel #alerts, got %q", payload["channel"])
+	}
+	if !strings.Contains(payload["text"], "3 deleted") {
+		t.Errorf("Expected the summary in the message, got %q", payload["text"])
+	}
+}
+
+func TestSMTP_Options(t *testing.T) {
+	notifier, err := New("smtp", map[string]any{
+		"host": "mail.example.com",
+		"port": int64(2525),
+		"from": "merkle-go@example.com",
+		"to":   []any{"ops@example.com", "security@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	mailer := notifier.(*SMTP)
+	if mailer.Port != 2525 || len(mailer.To) != 2 {
+		t.Errorf("Unexpected SMTP settings: %+v", mailer)
+	}
+
+	message := string(mailer.message(Event{Title: "merkle-go compare", RootPath: "/data", Modified: 1, Report: "line 1\nline 2"}))
+	if !strings.Contains(message, "Subject: [merkle-go compare] /data: 0 added, 1 modified") {
+		t.Errorf("Unexpected subject in message:\n%s", message)
+	}
+	if !strings.Contains(message, "line 1\r\nline 2") {
+		t.Errorf("Expected the report with CRLF line endings, got:\n%s", message)
+	}
+
+	if _, err := New("smtp", map[string]any{"host": "mail.example.com", "from": "a@example.com"}); err == nil {
+		t.Error("SMTP notifier should require at least one recipient")
+	}
+}
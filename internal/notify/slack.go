@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+func init() {
+	Register("slack", newSlack)
+}
+
+// Slack posts each event to a Slack incoming webhook
+type Slack struct {
+	WebhookURL string
+	Channel    string // Overrides the webhook's default channel if set
+	Client     *http.Client
+}
+
+func newSlack(options map[string]any) (Notifier, error) {
+	url, err := requiredString(options, "webhook_url")
+	if err != nil {
+		return nil, err
+	}
+	channel, err := stringOption(options, "channel", "")
+	if err != nil {
+		return nil, err
+	}
+	return &Slack{
+		WebhookURL: url,
+		Channel:    channel,
+		Client:     &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (s *Slack) Notify(ctx context.Context, event Event) error {
+	text := fmt.Sprintf("*%s*\n%s", event.Title, event.Summary())
+	if event.Report != "" {
+		text += "\n```\n" + event.Report + "\n```"
+	}
+
+	payload := map[string]string{"text": text}
+	if s.Channel != "" {
+		payload["channel"] = s.Channel
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal slack message: %w", err)
+	}
+	return postJSON(ctx, s.Client, s.WebhookURL, nil, body)
+}
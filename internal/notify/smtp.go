@@ -0,0 +1,133 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("smtp", newSMTP)
+}
+
+// SMTP emails each event. Port 465 uses implicit TLS; on other ports the
+// connection is upgraded with STARTTLS when the server offers it.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func newSMTP(options map[string]any) (Notifier, error) {
+	host, err := requiredString(options, "host")
+	if err != nil {
+		return nil, err
+	}
+	port, err := intOption(options, "port", 587)
+	if err != nil {
+		return nil, err
+	}
+	username, err := stringOption(options, "username", "")
+	if err != nil {
+		return nil, err
+	}
+	password, err := stringOption(options, "password", "")
+	if err != nil {
+		return nil, err
+	}
+	from, err := requiredString(options, "from")
+	if err != nil {
+		return nil, err
+	}
+	to, err := stringsOption(options, "to")
+	if err != nil {
+		return nil, err
+	}
+	if len(to) == 0 {
+		return nil, fmt.Errorf("option %q is required", "to")
+	}
+	return &SMTP{Host: host, Port: port, Username: username, Password: password, From: from, To: to}, nil
+}
+
+// message builds the email for an event
+func (s *SMTP) message(event Event) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&b, "Subject: [%s] %s\r\n", event.Title, event.Summary())
+	fmt.Fprintf(&b, "Date: %s\r\n", event.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(event.Summary() + "\r\n")
+	if event.Report != "" {
+		b.WriteString("\r\n" + strings.ReplaceAll(event.Report, "\n", "\r\n") + "\r\n")
+	}
+	return []byte(b.String())
+}
+
+func (s *SMTP) Notify(ctx context.Context, event Event) error {
+	address := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	tlsConfig := &tls.Config{ServerName: s.Host}
+
+	var conn net.Conn
+	var err error
+	if s.Port == 465 {
+		dialer := &tls.Dialer{Config: tlsConfig}
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && s.Port != 465 {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+
+	if err := client.Mail(s.From); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	for _, recipient := range s.To {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("failed to add recipient %s: %w", recipient, err)
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	if _, err := writer.Write(s.message(event)); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	return client.Quit()
+}
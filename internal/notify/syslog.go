@@ -0,0 +1,49 @@
+//go:build !windows && !plan9
+
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/syslog"
+)
+
+func init() {
+	Register("syslog", newSyslog)
+}
+
+// Syslog writes a one-line summary of each event to the system logger
+type Syslog struct {
+	Network string
+	Address string
+	Tag     string
+}
+
+func newSyslog(options map[string]any) (Notifier, error) {
+	network, err := stringOption(options, "network", "")
+	if err != nil {
+		return nil, err
+	}
+	address, err := stringOption(options, "address", "")
+	if err != nil {
+		return nil, err
+	}
+	tag, err := stringOption(options, "tag", "merkle-go")
+	if err != nil {
+		return nil, err
+	}
+	return &Syslog{Network: network, Address: address, Tag: tag}, nil
+}
+
+func (s *Syslog) Notify(ctx context.Context, event Event) error {
+	writer, err := syslog.Dial(s.Network, s.Address, syslog.LOG_DAEMON|syslog.LOG_INFO, s.Tag)
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	defer writer.Close()
+
+	if event.HasChanges() || event.Errors > 0 {
+		return writer.Warning(event.Summary())
+	}
+	return writer.Info(event.Summary())
+}
@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+func init() {
+	Register("webhook", newWebhook)
+}
+
+// Webhook POSTs each event as a JSON document to a URL
+type Webhook struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+func newWebhook(options map[string]any) (Notifier, error) {
+	url, err := requiredString(options, "url")
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(map[string]string)
+	if raw, ok := options["headers"]; ok {
+		table, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("option %q must be a table", "headers")
+		}
+		for name, value := range table {
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("header %q must be a string", name)
+			}
+			headers[name] = s
+		}
+	}
+
+	return &Webhook{
+		URL:     url,
+		Headers: headers,
+		Client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (w *Webhook) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	return postJSON(ctx, w.Client, w.URL, w.Headers, body)
+}
+
+// postJSON sends body to url and treats any non-2xx response as an error
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post to %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("post to %s returned %s", url, resp.Status)
+	}
+	return nil
+}
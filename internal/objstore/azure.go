@@ -0,0 +1,168 @@
+package objstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// azureVersion is the Blob service REST API version requests are made with
+const azureVersion = "2021-08-06"
+
+// Azure stores blobs in a container of an Azure storage account,
+// authenticating with the account key (Shared Key) or a SAS token
+type Azure struct {
+	Account   string
+	Container string
+
+	// Endpoint is the blob service URL of the account; empty means
+	// https://<account>.blob.core.windows.net. An emulator's URL ends with
+	// the account name, e.g. http://127.0.0.1:10000/devstoreaccount1.
+	Endpoint string
+
+	Key      string // base64 account key
+	SASToken string // query string of a shared access signature
+
+	Client *http.Client // nil means http.DefaultClient
+
+	now func() time.Time // the signing time; nil means time.Now
+}
+
+// AzureFromEnv returns the store of container in account configured by the
+// variables the Azure CLI reads: AZURE_STORAGE_KEY or
+// AZURE_STORAGE_SAS_TOKEN, and AZURE_STORAGE_BLOB_ENDPOINT for an emulator
+func AzureFromEnv(account, container string) (*Azure, error) {
+	a := &Azure{
+		Account:   account,
+		Container: container,
+		Endpoint:  os.Getenv("AZURE_STORAGE_BLOB_ENDPOINT"),
+		Key:       os.Getenv("AZURE_STORAGE_KEY"),
+		SASToken:  strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"),
+	}
+	if a.Key == "" && a.SASToken == "" {
+		return nil, errors.New("no Azure credentials: set AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN")
+	}
+	return a, nil
+}
+
+func (a *Azure) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := a.request(ctx, http.MethodGet, key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := do(a.Client, req, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (a *Azure) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	req, err := a.request(ctx, http.MethodPut, key, body, size)
+	if err != nil {
+		return err
+	}
+	resp, err := do(a.Client, req, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (a *Azure) request(ctx context.Context, method, key string, body io.Reader, size int64) (*http.Request, error) {
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", a.Account)
+	}
+	u, err := objectURL(endpoint, a.Container, key)
+	if err != nil {
+		return nil, err
+	}
+	if a.Key == "" {
+		u.RawQuery = a.SASToken
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now
+	if a.now != nil {
+		now = a.now
+	}
+	req.Header.Set("X-Ms-Date", now().UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Ms-Version", azureVersion)
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if a.Key != "" {
+		if err := a.sign(req); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// sign adds the Shared Key authorization of req
+func (a *Azure) sign(req *http.Request) error {
+	key, err := base64.StdEncoding.DecodeString(a.Key)
+	if err != nil {
+		return fmt.Errorf("invalid Azure storage key: %w", err)
+	}
+
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+	lines := []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-Md5"),
+		req.Header.Get("Content-Type"),
+		"", // Date; x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}
+
+	msHeaders := make(map[string]string)
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			msHeaders[name] = strings.Join(values, ",")
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(msHeaders)) {
+		lines = append(lines, name+":"+strings.TrimSpace(msHeaders[name]))
+	}
+
+	// The resource is the account followed by the path, which for an
+	// emulator already starts with the account name
+	resource := "/" + a.Account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	for _, name := range slices.Sorted(maps.Keys(query)) {
+		values := slices.Sorted(slices.Values(query[name]))
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+	lines = append(lines, resource)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	req.Header.Set("Authorization", "SharedKey "+a.Account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return nil
+}
@@ -0,0 +1,161 @@
+// Package objstore reads and writes manifests in cloud object storage, so
+// hosts without a shared filesystem can publish and check them. Three URL
+// forms are understood:
+//
+//	s3://bucket/key               Amazon S3 or an S3-compatible service
+//	gs://bucket/object            Google Cloud Storage
+//	az://account/container/blob   Azure Blob Storage
+//
+// Credentials come from the environment variables each provider's own
+// tools use; see S3FromEnv, GCSFromEnv and AzureFromEnv.
+package objstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Store reads and writes the objects of one bucket or container
+type Store interface {
+	// Get returns the content of the object named key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Put replaces the object named key with size bytes read from body
+	Put(ctx context.Context, key string, body io.Reader, size int64) error
+}
+
+// ErrNotFound is returned by Get when the object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// IsURL reports whether s is an object storage URL rather than a local path
+func IsURL(s string) bool {
+	for _, scheme := range []string{"s3://", "gs://", "az://"} {
+		if strings.HasPrefix(s, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// Open returns the store holding the object at rawURL, configured from the
+// environment, and the key of the object in it
+func Open(rawURL string) (Store, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid object URL: %w", err)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	switch u.Scheme {
+	case "s3":
+		if u.Host == "" || key == "" {
+			return nil, "", fmt.Errorf("invalid object URL %q: expected s3://bucket/key", rawURL)
+		}
+		store, err := S3FromEnv(u.Host)
+		return store, key, err
+	case "gs":
+		if u.Host == "" || key == "" {
+			return nil, "", fmt.Errorf("invalid object URL %q: expected gs://bucket/object", rawURL)
+		}
+		store, err := GCSFromEnv(u.Host)
+		return store, key, err
+	case "az":
+		container, blob, _ := strings.Cut(key, "/")
+		if u.Host == "" || container == "" || blob == "" {
+			return nil, "", fmt.Errorf("invalid object URL %q: expected az://account/container/blob", rawURL)
+		}
+		store, err := AzureFromEnv(u.Host, container)
+		return store, blob, err
+	default:
+		return nil, "", fmt.Errorf("unsupported object URL scheme %q", u.Scheme)
+	}
+}
+
+// Get returns the content of the object at rawURL
+func Get(ctx context.Context, rawURL string) ([]byte, error) {
+	store, key, err := Open(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", rawURL, err)
+	}
+	return data, nil
+}
+
+// Put replaces the object at rawURL with size bytes read from body
+func Put(ctx context.Context, rawURL string, body io.Reader, size int64) error {
+	store, key, err := Open(rawURL)
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, key, body, size)
+}
+
+// do sends req and returns the response if it has one of the accepted
+// statuses; otherwise the response is closed and described in the error
+func do(client *http.Client, req *http.Request, accepted ...int) (*http.Response, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range accepted {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	// The query may hold a SAS token; leave it out of errors
+	target := *req.URL
+	target.RawQuery = ""
+	if resp.StatusCode == http.StatusNotFound && req.Method == http.MethodGet {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, target.Redacted())
+	}
+	// Providers explain failures in a short XML or JSON body
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return nil, fmt.Errorf("%s %s: %s: %s", req.Method, target.Redacted(), resp.Status, strings.TrimSpace(string(detail)))
+}
+
+// objectURL returns endpoint joined with the escaped segments of key
+func objectURL(endpoint string, segments ...string) (*url.URL, error) {
+	var path strings.Builder
+	for _, segment := range segments {
+		for part := range strings.SplitSeq(segment, "/") {
+			path.WriteString("/" + uriEncode(part))
+		}
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/") + path.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	}
+	return u, nil
+}
+
+// uriEncode escapes every byte but the unreserved characters of RFC 3986,
+// which is the encoding request signatures are computed over
+func uriEncode(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteString("%" + string(hex[c>>4]) + string(hex[c&15]))
+	}
+	return b.String()
+}
@@ -0,0 +1,81 @@
+package pathmatch
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Match reports whether a relative path matches a glob pattern. Patterns use
+// forward slashes and the syntax of path.Match within a segment, plus "**"
+// as a whole segment to match zero or more directories. A pattern without a
+// slash matches the base name at any depth, e.g. "*.log".
+func Match(pattern, relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	pattern = strings.TrimPrefix(pattern, "./")
+
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(relPath))
+		return matched
+	}
+
+	// A trailing slash means "this directory and everything under it"
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(relPath, "/"))
+}
+
+// MatchAny reports whether relPath matches any of the patterns
+func MatchAny(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		if Match(pattern, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns an error if pattern is malformed
+func Validate(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		if segment == "**" {
+			continue
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Collapse consecutive ** and try every possible split point
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern, segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+		if matched, err := path.Match(pattern[0], segments[0]); err != nil || !matched {
+			return false
+		}
+		pattern = pattern[1:]
+		segments = segments[1:]
+	}
+	return len(segments) == 0
+}
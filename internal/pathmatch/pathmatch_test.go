@@ -0,0 +1,76 @@
+package pathmatch
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.log", "app.log", true},
+		{"*.log", "logs/app.log", true},
+		{"*.log", "app.txt", false},
+		{"tmp/**", "tmp/a", true},
+		{"tmp/**", "tmp/a/b/c.txt", true},
+		{"tmp/**", "src/tmp/a", false},
+		{"tmp/", "tmp/a/b", true},
+		{"src/*.go", "src/main.go", true},
+		{"src/*.go", "src/pkg/main.go", false},
+		{"**/*.conf", "app.conf", true},
+		{"**/*.conf", "etc/nginx/site.conf", true},
+		{"**/cache/**", "a/b/cache/x", true},
+		{"**/cache/**", "a/b/cached/x", false},
+		{"src/**/test_*.py", "src/test_a.py", true},
+		{"src/**/test_*.py", "src/x/y/test_a.py", true},
+		{"./docs/*", "docs/readme.md", true},
+	}
+
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate("src/**/[a-z]*.go"); err != nil {
+		t.Errorf("Valid pattern rejected: %v", err)
+	}
+	if err := Validate("src/[a-"); err == nil {
+		t.Error("Malformed pattern should be rejected")
+	}
+}
+
+func TestRegexps(t *testing.T) {
+	regexps, err := CompileRegexps([]string{"/cache/", "!^/important/", `\.bak$`})
+	if err != nil {
+		t.Fatalf("CompileRegexps failed: %v", err)
+	}
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"cache", true, true},
+		{"a/cache", true, true},
+		{"a/cache/x.txt", false, true},
+		{"cache", false, false},
+		{"important/cache", true, false},
+		{"important/cache/x.bak", false, true},
+		{"a/cached/x.txt", false, false},
+	}
+	for _, tt := range tests {
+		if got := regexps.Match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("Match(%q, dir=%v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+
+	var none *Regexps
+	if none.Match("cache", true) {
+		t.Error("Expected nil regexps to match nothing")
+	}
+	if _, err := CompileRegexps([]string{"a(b"}); err == nil {
+		t.Error("Malformed regular expression should be rejected")
+	}
+}
@@ -0,0 +1,58 @@
+package pathmatch
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Regexps are regular expressions, in Go's RE2 syntax, matched against
+// relative paths written with forward slashes, a leading slash and, for
+// directories, a trailing slash, e.g. "/photos/cache/". A rule starting
+// with "!" keeps the paths it matches even if an earlier rule matched them,
+// and the last rule matching a path decides, so ["/cache/",
+// "!^/important/"] matches every cache directory except those under
+// important. The zero value and nil match nothing.
+type Regexps struct {
+	rules []regexpRule
+}
+
+type regexpRule struct {
+	re   *regexp.Regexp
+	keep bool
+}
+
+// CompileRegexps compiles patterns, returning an error naming the first
+// that is malformed
+func CompileRegexps(patterns []string) (*Regexps, error) {
+	r := &Regexps{rules: make([]regexpRule, 0, len(patterns))}
+	for _, pattern := range patterns {
+		expr, keep := strings.CutPrefix(pattern, "!")
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %w", pattern, err)
+		}
+		r.rules = append(r.rules, regexpRule{re: re, keep: keep})
+	}
+	return r, nil
+}
+
+// Match reports whether the rules match the entry at relPath, relative to
+// the walked directory with the separators of the platform
+func (r *Regexps) Match(relPath string, isDir bool) bool {
+	if r == nil || len(r.rules) == 0 {
+		return false
+	}
+	subject := "/" + filepath.ToSlash(relPath)
+	if isDir {
+		subject += "/"
+	}
+	matched := false
+	for _, rule := range r.rules {
+		if rule.re.MatchString(subject) {
+			matched = !rule.keep
+		}
+	}
+	return matched
+}
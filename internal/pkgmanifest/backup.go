@@ -0,0 +1,147 @@
+package pkgmanifest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"merkle-go/internal/hash"
+)
+
+// Backup listing formats, read by Load. Entry paths are relative to /, the
+// way the backup recorded them.
+const (
+	FormatRestic = "restic" // Output of `restic ls --json <snapshot>`
+	FormatBorg   = "borg"   // Output of `borg list --json-lines <repo>::<archive>`
+)
+
+// resticNode is one line of `restic ls --json`: the snapshot first, then a
+// node for every entry in it
+type resticNode struct {
+	StructType string      `json:"struct_type"`
+	Paths      []string    `json:"paths"` // snapshot only
+	Type       string      `json:"type"`
+	Path       string      `json:"path"`
+	Size       int64       `json:"size"`
+	Mode       os.FileMode `json:"mode"`
+	MTime      time.Time   `json:"mtime"`
+}
+
+// ParseResticLs parses the output of `restic ls --json`. restic does not
+// list content hashes, so entries only have a size and modification time.
+// If the snapshot backed up a single path, it is the manifest's Source.
+func ParseResticLs(r io.Reader) (*Manifest, error) {
+	manifest := &Manifest{Format: FormatRestic}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	snapshots := 0
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var node resticNode
+		if err := json.Unmarshal([]byte(text), &node); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		switch node.StructType {
+		case "snapshot":
+			snapshots++
+			if len(node.Paths) == 1 {
+				manifest.Source = node.Paths[0]
+			}
+		case "node":
+			if node.Type != "file" {
+				continue
+			}
+			manifest.Entries = append(manifest.Entries, Entry{
+				Path:    strings.TrimPrefix(path.Clean(node.Path), "/"),
+				Size:    node.Size,
+				ModTime: node.MTime,
+				Mode:    node.Mode.Perm(),
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read restic listing: %w", err)
+	}
+	// Listings of several snapshots cannot be told apart
+	if snapshots > 1 {
+		return nil, fmt.Errorf("restic listing covers %d snapshots; list one at a time", snapshots)
+	}
+	return manifest, nil
+}
+
+// borgDigests maps the hash keys `borg list --format` can add to a listing
+// to hash algorithms, preferred first
+var borgDigests = []struct{ key, algorithm string }{
+	{"xxh64", hash.XXH64},
+	{"sha256", hash.SHA256},
+	{"sha1", hash.SHA1},
+	{"md5", hash.MD5},
+}
+
+// borgTimeLayouts are the modification time layouts of borg listings: borg
+// 1 writes local time without a zone, borg 2 adds the offset
+var borgTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999"}
+
+// ParseBorgList parses the output of `borg list --json-lines`. Entries get
+// the digest of the first hash key present (see borgDigests), which borg
+// includes when asked for it with --format, e.g. '{path}{size}{mtime}{xxh64}';
+// otherwise they only have a size and modification time.
+func ParseBorgList(r io.Reader) (*Manifest, error) {
+	manifest := &Manifest{Format: FormatBorg}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var item map[string]any
+		if err := json.Unmarshal([]byte(text), &item); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		// Regular files are "-"; borg 1 lists further links to a hard
+		// linked file as "h"
+		if kind, _ := item["type"].(string); kind != "-" && kind != "h" {
+			continue
+		}
+		itemPath, _ := item["path"].(string)
+		if itemPath == "" {
+			return nil, fmt.Errorf("line %d: item without a path", line)
+		}
+
+		entry := Entry{Path: strings.TrimPrefix(path.Clean(itemPath), "/"), Size: -1}
+		if size, ok := item["size"].(float64); ok {
+			entry.Size = int64(size)
+		}
+		if mtime, ok := item["mtime"].(string); ok {
+			for _, layout := range borgTimeLayouts {
+				if parsed, err := time.ParseInLocation(layout, mtime, time.Local); err == nil {
+					entry.ModTime = parsed
+					break
+				}
+			}
+			if entry.ModTime.IsZero() {
+				return nil, fmt.Errorf("line %d: invalid mtime %q", line, mtime)
+			}
+		}
+		for _, digest := range borgDigests {
+			if value, ok := item[digest.key].(string); ok && value != "" {
+				entry.Algorithm, entry.Digest = digest.algorithm, strings.ToLower(value)
+				break
+			}
+		}
+		manifest.Entries = append(manifest.Entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read borg listing: %w", err)
+	}
+	return manifest, nil
+}
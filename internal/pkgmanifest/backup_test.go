@@ -0,0 +1,70 @@
+package pkgmanifest
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseResticLs(t *testing.T) {
+	listing := `{"time":"2026-10-01T02:00:00.000000000+02:00","tree":"9b1f","paths":["/srv/data"],"hostname":"web1","id":"4e2c","short_id":"4e2c","struct_type":"snapshot"}
+{"name":"data","type":"dir","path":"/srv/data","mode":2147484141,"mtime":"2026-09-30T12:00:00+02:00","struct_type":"node"}
+{"name":"a.txt","type":"file","path":"/srv/data/a.txt","size":12,"mode":420,"mtime":"2026-09-30T11:59:58.123456789+02:00","struct_type":"node"}
+{"name":"link","type":"symlink","path":"/srv/data/link","mode":134218239,"mtime":"2026-09-30T12:00:00+02:00","struct_type":"node"}
+`
+	manifest, err := ParseResticLs(strings.NewReader(listing))
+	if err != nil {
+		t.Fatalf("ParseResticLs failed: %v", err)
+	}
+	if manifest.Source != "/srv/data" {
+		t.Errorf("Expected source /srv/data, got %q", manifest.Source)
+	}
+	if len(manifest.Entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %+v", manifest.Entries)
+	}
+	entry := manifest.Entries[0]
+	if entry.Path != "srv/data/a.txt" || entry.Size != 12 || entry.Digest != "" || entry.Mode != 0644 {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+	if want := time.Date(2026, 9, 30, 9, 59, 58, 123456789, time.UTC); !entry.ModTime.Equal(want) {
+		t.Errorf("Expected mtime %v, got %v", want, entry.ModTime)
+	}
+
+	twoSnapshots := `{"paths":["/a"],"struct_type":"snapshot"}
+{"paths":["/b"],"struct_type":"snapshot"}
+`
+	if _, err := ParseResticLs(strings.NewReader(twoSnapshots)); err == nil {
+		t.Error("Expected an error for a listing of several snapshots")
+	}
+}
+
+func TestParseBorgList(t *testing.T) {
+	listing := `{"type":"d","mode":"drwxr-xr-x","path":"srv/data","size":0,"mtime":"2026-09-30T12:00:00.000000"}
+{"type":"-","mode":"-rw-r--r--","path":"srv/data/a.txt","size":12,"mtime":"2026-09-30T11:59:58.000000","xxh64":"0123456789ABCDEF","md5":"65a8e27d8879283831b664bd8b7f0ad4"}
+{"type":"-","mode":"-rw-r--r--","path":"srv/data/b.txt","size":3,"mtime":"2026-09-30T11:59:59.000000+00:00"}
+`
+	manifest, err := ParseBorgList(strings.NewReader(listing))
+	if err != nil {
+		t.Fatalf("ParseBorgList failed: %v", err)
+	}
+	if len(manifest.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %+v", manifest.Entries)
+	}
+
+	// xxh64 is preferred over md5, and digests are lower case like ours
+	a := manifest.Entries[0]
+	if a.Path != "srv/data/a.txt" || a.Size != 12 || a.Algorithm != "xxh64" || a.Digest != "0123456789abcdef" {
+		t.Errorf("Unexpected entry %+v", a)
+	}
+	if want := time.Date(2026, 9, 30, 11, 59, 58, 0, time.Local); !a.ModTime.Equal(want) {
+		t.Errorf("Expected local mtime %v, got %v", want, a.ModTime)
+	}
+	b := manifest.Entries[1]
+	if b.Digest != "" || !b.ModTime.Equal(time.Date(2026, 9, 30, 11, 59, 59, 0, time.UTC)) {
+		t.Errorf("Unexpected entry %+v", b)
+	}
+
+	if _, err := ParseBorgList(strings.NewReader(`{"type":"-","path":"x","mtime":"yesterday"}`)); err == nil {
+		t.Error("Expected an error for an invalid mtime")
+	}
+}
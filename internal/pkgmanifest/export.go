@@ -0,0 +1,77 @@
+package pkgmanifest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Export formats
+const (
+	FormatSHA256Sums = "sha256sums" // Output of sha256sum, checkable with `sha256sum -c`
+	FormatBagIt      = "bagit"      // BagIt 1.0 tag files (RFC 8493)
+)
+
+// BagItVersion is the BagIt version written by WriteBag
+const BagItVersion = "1.0"
+
+// WriteChecksums writes entries in the format of the coreutils *sum tools,
+// "<digest>  <path>". As those tools do, a path containing a backslash or
+// newline is escaped and its line prefixed with a backslash.
+func WriteChecksums(w io.Writer, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	for _, entry := range entries {
+		path := entry.Path
+		prefix := ""
+		if strings.ContainsAny(path, "\\\n") {
+			path = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(path)
+			prefix = `\`
+		}
+		if _, err := fmt.Fprintf(bw, "%s%s  %s\n", prefix, entry.Digest, path); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteBag writes the tag files of a BagIt bag into dir: bagit.txt,
+// manifest-<algorithm>.txt and bag-info.txt. The entries' paths are taken
+// to be relative to the bag's data/ directory. All entries must use the
+// same algorithm, and sizes are needed for the Payload-Oxum.
+func WriteBag(dir string, entries []Entry, algorithm string, bagged time.Time) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create bag directory: %w", err)
+	}
+
+	declaration := fmt.Sprintf("BagIt-Version: %s\nTag-File-Character-Encoding: UTF-8\n", BagItVersion)
+	if err := os.WriteFile(filepath.Join(dir, "bagit.txt"), []byte(declaration), 0644); err != nil {
+		return fmt.Errorf("failed to write bagit.txt: %w", err)
+	}
+
+	// RFC 8493 section 2.1.3: CR, LF and % in paths are percent-encoded
+	encode := strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	var manifest strings.Builder
+	var octets int64
+	for _, entry := range entries {
+		if entry.Algorithm != algorithm {
+			return fmt.Errorf("%s: expected a %s digest, got %s", entry.Path, algorithm, entry.Algorithm)
+		}
+		fmt.Fprintf(&manifest, "%s  data/%s\n", entry.Digest, encode.Replace(entry.Path))
+		octets += entry.Size
+	}
+	manifestName := "manifest-" + algorithm + ".txt"
+	if err := os.WriteFile(filepath.Join(dir, manifestName), []byte(manifest.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", manifestName, err)
+	}
+
+	info := fmt.Sprintf("Bagging-Date: %s\nPayload-Oxum: %d.%d\nBag-Software-Agent: merkle-go\n",
+		bagged.Format("2006-01-02"), octets, len(entries))
+	if err := os.WriteFile(filepath.Join(dir, "bag-info.txt"), []byte(info), 0644); err != nil {
+		return fmt.Errorf("failed to write bag-info.txt: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package pkgmanifest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteChecksums(t *testing.T) {
+	entries := []Entry{
+		{Path: "a.txt", Algorithm: "sha256", Digest: "aa"},
+		{Path: `odd\name`, Algorithm: "sha256", Digest: "bb"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteChecksums(&buf, entries); err != nil {
+		t.Fatalf("WriteChecksums failed: %v", err)
+	}
+
+	expected := "aa  a.txt\n\\bb  odd\\\\name\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestWriteBag(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "bag")
+	entries := []Entry{
+		{Path: "a.txt", Algorithm: "sha256", Digest: "aa", Size: 10},
+		{Path: "sub/100%.txt", Algorithm: "sha256", Digest: "bb", Size: 5},
+	}
+
+	if err := WriteBag(dir, entries, "sha256", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("WriteBag failed: %v", err)
+	}
+
+	manifest, err := os.ReadFile(filepath.Join(dir, "manifest-sha256.txt"))
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if expected := "aa  data/a.txt\nbb  data/sub/100%25.txt\n"; string(manifest) != expected {
+		t.Errorf("Expected manifest %q, got %q", expected, manifest)
+	}
+
+	info, err := os.ReadFile(filepath.Join(dir, "bag-info.txt"))
+	if err != nil {
+		t.Fatalf("Failed to read bag-info.txt: %v", err)
+	}
+	if !strings.Contains(string(info), "Payload-Oxum: 15.2\n") || !strings.Contains(string(info), "Bagging-Date: 2024-03-01\n") {
+		t.Errorf("Unexpected bag-info.txt:\n%s", info)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "bagit.txt")); err != nil {
+		t.Errorf("Expected bagit.txt: %v", err)
+	}
+}
+
+func TestWriteBag_MixedAlgorithms(t *testing.T) {
+	entries := []Entry{{Path: "a.txt", Algorithm: "md5", Digest: "aa"}}
+	if err := WriteBag(t.TempDir(), entries, "sha256", time.Now()); err == nil {
+		t.Error("Expected an error for an entry with a different algorithm")
+	}
+}
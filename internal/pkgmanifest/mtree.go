@@ -0,0 +1,258 @@
+package pkgmanifest
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"merkle-go/internal/hash"
+)
+
+// FormatMtree is the BSD mtree(5) specification format, read by Load and
+// written by WriteMtree
+const FormatMtree = "mtree"
+
+// mtreeDigests maps mtree digest keywords to hash algorithms, strongest
+// first; entries carrying several digests keep the strongest one
+var mtreeDigests = []struct{ keyword, algorithm string }{
+	{"sha256digest", hash.SHA256},
+	{"sha256", hash.SHA256},
+	{"sha1digest", hash.SHA1},
+	{"sha1", hash.SHA1},
+	{"md5digest", hash.MD5},
+	{"md5", hash.MD5},
+}
+
+// ParseMtree parses an mtree specification, in either the full-path form
+// written by `mtree -C` and libarchive ("./usr/bin/ls type=file ...") or the
+// hierarchical form of `mtree -c`, where directories are entered by their
+// type=dir entries and left with "..". Only regular files are listed; the
+// size, time, mode and the strongest supported digest keyword are kept.
+// /set and /unset change the default keywords of the following entries.
+func ParseMtree(r io.Reader) (*Manifest, error) {
+	manifest := &Manifest{Format: FormatMtree}
+	defaults := map[string]string{}
+	var cwd []string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var pending string
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		// A trailing backslash continues the entry on the next line
+		if strings.HasSuffix(text, `\`) && !strings.HasSuffix(text, `\\`) {
+			pending += strings.TrimSuffix(text, `\`) + " "
+			continue
+		}
+		text, pending = pending+text, ""
+
+		fields := strings.Fields(text)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch fields[0] {
+		case "/set":
+			for key, value := range parseKeywords(fields[1:]) {
+				defaults[key] = value
+			}
+			continue
+		case "/unset":
+			for _, key := range fields[1:] {
+				if key == "all" {
+					clear(defaults)
+				}
+				delete(defaults, key)
+			}
+			continue
+		case "..":
+			if len(cwd) > 0 {
+				cwd = cwd[:len(cwd)-1]
+			}
+			continue
+		}
+
+		name, err := unvis(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("mtree:%d: %w", line, err)
+		}
+		keywords := parseKeywords(fields[1:])
+		for key, value := range defaults {
+			if _, ok := keywords[key]; !ok {
+				keywords[key] = value
+			}
+		}
+
+		// Names with a slash are full paths; the others are relative to
+		// the directory the hierarchical form is in
+		var entryPath string
+		if strings.Contains(name, "/") {
+			entryPath = path.Clean(name)
+		} else {
+			entryPath = path.Join(append(append([]string{}, cwd...), name)...)
+			if keywords["type"] == "dir" && name != "." {
+				cwd = append(cwd, name)
+			}
+		}
+		if keywords["type"] != "" && keywords["type"] != "file" {
+			continue
+		}
+		entryPath = strings.TrimPrefix(entryPath, "./")
+		if entryPath == "." || entryPath == "" {
+			continue
+		}
+
+		entry, err := mtreeEntry(entryPath, keywords)
+		if err != nil {
+			return nil, fmt.Errorf("mtree:%d: %w", line, err)
+		}
+		manifest.Entries = append(manifest.Entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("mtree: %w", err)
+	}
+	return manifest, nil
+}
+
+// parseKeywords splits key=value fields; keywords without a value, such as
+// nochange or optional, map to ""
+func parseKeywords(fields []string) map[string]string {
+	keywords := make(map[string]string, len(fields))
+	for _, field := range fields {
+		key, value, _ := strings.Cut(field, "=")
+		keywords[strings.ToLower(key)] = value
+	}
+	return keywords
+}
+
+// mtreeEntry converts the keywords of a file entry
+func mtreeEntry(entryPath string, keywords map[string]string) (Entry, error) {
+	entry := Entry{Path: entryPath, Size: -1}
+	if value, ok := keywords["size"]; ok {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size < 0 {
+			return Entry{}, fmt.Errorf("invalid size %q", value)
+		}
+		entry.Size = size
+	}
+	if value, ok := keywords["time"]; ok {
+		seconds, nanos, _ := strings.Cut(value, ".")
+		sec, err := strconv.ParseInt(seconds, 10, 64)
+		if err != nil {
+			return Entry{}, fmt.Errorf("invalid time %q", value)
+		}
+		nsec, _ := strconv.ParseInt(nanos, 10, 64)
+		entry.ModTime = time.Unix(sec, nsec)
+	}
+	if value, ok := keywords["mode"]; ok {
+		mode, err := strconv.ParseUint(value, 8, 32)
+		if err != nil {
+			return Entry{}, fmt.Errorf("invalid mode %q", value)
+		}
+		entry.Mode = os.FileMode(mode & 0777)
+	}
+	for _, digest := range mtreeDigests {
+		value, ok := keywords[digest.keyword]
+		if !ok {
+			continue
+		}
+		if _, err := hex.DecodeString(value); err != nil {
+			return Entry{}, fmt.Errorf("malformed %s", digest.keyword)
+		}
+		entry.Algorithm, entry.Digest = digest.algorithm, strings.ToLower(value)
+		break
+	}
+	return entry, nil
+}
+
+// unvis decodes the octal escapes (\040) and backslash escapes that
+// mtree uses for special characters in file names
+func unvis(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 < len(s) && isOctal(s[i+1]) && isOctal(s[i+2]) && isOctal(s[i+3]) {
+			value, _ := strconv.ParseUint(s[i+1:i+4], 8, 8)
+			b.WriteByte(byte(value))
+			i += 3
+			continue
+		}
+		if i+1 < len(s) {
+			switch s[i+1] {
+			case '\\':
+				b.WriteByte('\\')
+			case 's':
+				b.WriteByte(' ')
+			case 't':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			default:
+				return "", fmt.Errorf("invalid escape in %q", s)
+			}
+			i++
+			continue
+		}
+		return "", fmt.Errorf("invalid escape in %q", s)
+	}
+	return b.String(), nil
+}
+
+func isOctal(c byte) bool {
+	return c >= '0' && c <= '7'
+}
+
+// vis escapes the bytes of a file name that mtree cannot hold literally
+// (whitespace, backslashes, #, control and non-ASCII bytes) as \ooo
+func vis(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || c == '\\' || c == '#' {
+			fmt.Fprintf(&b, `\%03o`, c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// WriteMtree writes entries as a full-path mtree specification, one
+// "./path type=file mode=... size=... time=... <digest>=..." line each, as
+// `mtree -C` and libarchive write them. Mode and time are left out when
+// unknown.
+func WriteMtree(w io.Writer, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#mtree")
+	for _, entry := range entries {
+		fmt.Fprintf(bw, "./%s type=file", vis(entry.Path))
+		if entry.Mode != 0 {
+			fmt.Fprintf(bw, " mode=%04o", entry.Mode.Perm())
+		}
+		if entry.Size >= 0 {
+			fmt.Fprintf(bw, " size=%d", entry.Size)
+		}
+		if !entry.ModTime.IsZero() {
+			fmt.Fprintf(bw, " time=%d.%09d", entry.ModTime.Unix(), entry.ModTime.Nanosecond())
+		}
+		if entry.Digest != "" {
+			fmt.Fprintf(bw, " %sdigest=%s", entry.Algorithm, entry.Digest)
+		}
+		if _, err := fmt.Fprintln(bw); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
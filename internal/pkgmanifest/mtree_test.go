@@ -0,0 +1,74 @@
+package pkgmanifest
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMtree_Hierarchical(t *testing.T) {
+	spec := `#	   user: root
+/set type=file uid=0 gid=0 mode=0644 nlink=1
+.               type=dir mode=0755
+    README      size=12 time=1700000000.000000500 \
+                sha256digest=aaaa md5digest=bbbb
+# ./bin
+bin             type=dir mode=0755
+    my\040tool  mode=0755 size=100 sha1digest=cccc
+    link        type=link link=my\040tool
+# ./bin
+..
+notes.txt       size=5
+`
+	manifest, err := ParseMtree(strings.NewReader(spec))
+	if err != nil {
+		t.Fatalf("ParseMtree failed: %v", err)
+	}
+	if len(manifest.Entries) != 3 {
+		t.Fatalf("Expected 3 files, got %+v", manifest.Entries)
+	}
+
+	readme := manifest.Entries[0]
+	if readme.Path != "README" || readme.Algorithm != "sha256" || readme.Digest != "aaaa" || readme.Size != 12 || readme.Mode != 0644 {
+		t.Errorf("Unexpected README entry %+v", readme)
+	}
+	if !readme.ModTime.Equal(time.Unix(1700000000, 500)) {
+		t.Errorf("Expected time with nanoseconds, got %v", readme.ModTime)
+	}
+	if tool := manifest.Entries[1]; tool.Path != "bin/my tool" || tool.Algorithm != "sha1" || tool.Mode != 0755 {
+		t.Errorf("Unexpected tool entry %+v", tool)
+	}
+	if notes := manifest.Entries[2]; notes.Path != "notes.txt" || notes.Digest != "" || notes.Size != 5 {
+		t.Errorf("Unexpected notes entry %+v", notes)
+	}
+}
+
+func TestWriteMtree_RoundTrip(t *testing.T) {
+	entries := []Entry{
+		{Path: "a b/c#d.txt", Algorithm: "sha256", Digest: "aa", Size: 3, Mode: 0640, ModTime: time.Unix(1700000000, 42)},
+		{Path: "plain", Algorithm: "sha256", Digest: "bb", Size: 0},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteMtree(&buf, entries); err != nil {
+		t.Fatalf("WriteMtree failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), `./a\040b/c\043d.txt type=file mode=0640 size=3 time=1700000000.000000042 sha256digest=aa`) {
+		t.Errorf("Unexpected mtree output:\n%s", buf.String())
+	}
+
+	manifest, err := ParseMtree(&buf)
+	if err != nil {
+		t.Fatalf("ParseMtree failed: %v", err)
+	}
+	if len(manifest.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %+v", manifest.Entries)
+	}
+	for i, entry := range manifest.Entries {
+		if entry.Path != entries[i].Path || entry.Digest != entries[i].Digest || entry.Size != entries[i].Size ||
+			entry.Mode != entries[i].Mode || !entry.ModTime.Equal(entries[i].ModTime) {
+			t.Errorf("Expected %+v, got %+v", entries[i], entry)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package pkgmanifest
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"merkle-go/internal/hash"
+)
+
+// Supported manifest formats
+const (
+	FormatRecord  = "record"  // Python wheel / dist-info RECORD
+	FormatMD5Sums = "md5sums" // Debian /var/lib/dpkg/info/<pkg>.md5sums
+	FormatNPM     = "npm"     // Output of `npm pack --dry-run --json`
+
+	FormatChecksums = "checksums" // Output of sha256sum, sha1sum or md5sum, with or without --tag
+)
+
+// Entry is one file listed in a package manifest. Digest is hex encoded and
+// empty when the manifest only records the file size. Size is -1 if unknown.
+type Entry struct {
+	Path      string // Slash-separated, relative to the install root
+	Algorithm string
+	Digest    string
+	Size      int64
+
+	// Only recorded by some formats (mtree); zero if unknown
+	ModTime time.Time
+	Mode    os.FileMode
+}
+
+// Manifest is the list of files a package claims to install
+type Manifest struct {
+	Format  string
+	Entries []Entry
+
+	// Source is the directory a backup listing was taken of, if the listing
+	// names exactly one
+	Source string
+}
+
+// DetectFormat guesses the manifest format from its file name
+func DetectFormat(path string) (string, error) {
+	base := filepath.Base(path)
+	switch {
+	case base == "RECORD":
+		return FormatRecord, nil
+	case strings.HasSuffix(base, "md5sums"):
+		return FormatMD5Sums, nil
+	case base == "mtree" || base == "METALOG" || strings.EqualFold(filepath.Ext(base), ".mtree"):
+		return FormatMtree, nil
+	case strings.EqualFold(filepath.Ext(base), ".json"):
+		return FormatNPM, nil
+	case strings.HasSuffix(strings.ToUpper(base), "SUMS"), checksumExtensions[strings.ToLower(filepath.Ext(base))]:
+		return FormatChecksums, nil
+	}
+	return "", fmt.Errorf("cannot detect manifest format of %s; use --format", base)
+}
+
+// checksumExtensions are file extensions used for *sum tool output
+var checksumExtensions = map[string]bool{".sha256": true, ".sha1": true, ".md5": true}
+
+// Load reads a manifest file. If format is empty or "auto" it is detected
+// from the file name.
+func Load(path, format string) (*Manifest, error) {
+	if format == "" || format == "auto" {
+		detected, err := DetectFormat(path)
+		if err != nil {
+			return nil, err
+		}
+		format = detected
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer f.Close()
+
+	switch format {
+	case FormatRecord:
+		return ParseRecord(f)
+	case FormatMD5Sums:
+		return ParseMD5Sums(f)
+	case FormatNPM:
+		return ParseNPMPack(f)
+	case FormatChecksums:
+		return ParseChecksums(f)
+	case FormatMtree:
+		return ParseMtree(f)
+	case FormatRestic:
+		return ParseResticLs(f)
+	case FormatBorg:
+		return ParseBorgList(f)
+	default:
+		return nil, fmt.Errorf("unknown manifest format %q", format)
+	}
+}
+
+// ParseRecord parses a wheel RECORD file: CSV rows of path,algo=digest,size
+// where the digest is unpadded URL-safe base64. Rows without a hash (the
+// RECORD file itself, signatures) are skipped.
+func ParseRecord(r io.Reader) (*Manifest, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	manifest := &Manifest{Format: FormatRecord}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("RECORD: %w", err)
+		}
+		if len(record) < 2 || record[1] == "" {
+			continue
+		}
+
+		algorithm, encoded, ok := strings.Cut(record[1], "=")
+		if !ok {
+			return nil, fmt.Errorf("RECORD:%d: malformed hash %q", line, record[1])
+		}
+		digest, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+		if err != nil {
+			return nil, fmt.Errorf("RECORD:%d: malformed digest: %w", line, err)
+		}
+
+		entry := Entry{
+			Path:      record[0],
+			Algorithm: strings.ToLower(algorithm),
+			Digest:    hex.EncodeToString(digest),
+			Size:      -1,
+		}
+		if len(record) > 2 && record[2] != "" {
+			if entry.Size, err = strconv.ParseInt(record[2], 10, 64); err != nil {
+				return nil, fmt.Errorf("RECORD:%d: invalid size %q", line, record[2])
+			}
+		}
+		manifest.Entries = append(manifest.Entries, entry)
+	}
+	return manifest, nil
+}
+
+// ParseMD5Sums parses md5sum-style lines: "<hex digest>  <path>"
+func ParseMD5Sums(r io.Reader) (*Manifest, error) {
+	manifest := &Manifest{Format: FormatMD5Sums}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		digest, path, ok := strings.Cut(text, " ")
+		if !ok || len(digest) != 32 {
+			return nil, fmt.Errorf("md5sums:%d: malformed line", line)
+		}
+		path = strings.TrimPrefix(strings.TrimLeft(path, " "), "*")
+		manifest.Entries = append(manifest.Entries, Entry{
+			Path:      strings.TrimPrefix(path, "/"),
+			Algorithm: hash.MD5,
+			Digest:    strings.ToLower(digest),
+			Size:      -1,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("md5sums: %w", err)
+	}
+	return manifest, nil
+}
+
+// digestAlgorithms maps hex digest lengths to the algorithm producing them
+var digestAlgorithms = map[int]string{32: hash.MD5, 40: hash.SHA1, 64: hash.SHA256}
+
+// taggedLine matches the BSD-style lines written by `sha256sum --tag`:
+// "SHA256 (path) = digest"
+var taggedLine = regexp.MustCompile(`^([A-Za-z0-9-]+) \((.*)\) = ([0-9a-fA-F]+)$`)
+
+// ParseChecksums parses the output of sha256sum, sha1sum or md5sum, either
+// "<digest>  <path>" (text or binary mode) or the --tag format. The
+// algorithm is taken from the tag or the digest length. Lines starting with
+// a backslash have escaped paths, as the coreutils tools write them.
+func ParseChecksums(r io.Reader) (*Manifest, error) {
+	manifest := &Manifest{Format: FormatChecksums}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		escaped := strings.HasPrefix(text, `\`)
+		if escaped {
+			text = text[1:]
+		}
+
+		var algorithm, digest, path string
+		if m := taggedLine.FindStringSubmatch(text); m != nil {
+			algorithm, path, digest = strings.ToLower(m[1]), m[2], m[3]
+			if _, err := hash.New(algorithm); err != nil {
+				return nil, fmt.Errorf("checksums:%d: %w", line, err)
+			}
+		} else {
+			var rest string
+			var ok bool
+			digest, rest, ok = strings.Cut(text, " ")
+			if !ok || (!strings.HasPrefix(rest, " ") && !strings.HasPrefix(rest, "*")) {
+				return nil, fmt.Errorf("checksums:%d: malformed line", line)
+			}
+			path = rest[1:]
+			if algorithm, ok = digestAlgorithms[len(digest)]; !ok {
+				return nil, fmt.Errorf("checksums:%d: unrecognized digest length %d", line, len(digest))
+			}
+		}
+		if _, err := hex.DecodeString(digest); err != nil {
+			return nil, fmt.Errorf("checksums:%d: malformed digest", line)
+		}
+		if escaped {
+			path = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r").Replace(path)
+		}
+
+		manifest.Entries = append(manifest.Entries, Entry{
+			Path:      strings.TrimPrefix(path, "./"),
+			Algorithm: algorithm,
+			Digest:    strings.ToLower(digest),
+			Size:      -1,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("checksums: %w", err)
+	}
+	return manifest, nil
+}
+
+// npmPack mirrors the parts of `npm pack --json` output that list contents
+type npmPack struct {
+	Files []struct {
+		Path string `json:"path"`
+		Size int64  `json:"size"`
+	} `json:"files"`
+}
+
+// ParseNPMPack parses the JSON written by `npm pack --dry-run --json`. npm
+// only records file sizes, so entries have no digest.
+func ParseNPMPack(r io.Reader) (*Manifest, error) {
+	var packs []npmPack
+	if err := json.NewDecoder(r).Decode(&packs); err != nil {
+		return nil, fmt.Errorf("npm pack output: %w", err)
+	}
+
+	manifest := &Manifest{Format: FormatNPM}
+	for _, pack := range packs {
+		for _, file := range pack.Files {
+			manifest.Entries = append(manifest.Entries, Entry{Path: file.Path, Size: file.Size})
+		}
+	}
+	return manifest, nil
+}
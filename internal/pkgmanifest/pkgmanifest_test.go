@@ -0,0 +1,118 @@
+package pkgmanifest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRecord(t *testing.T) {
+	record := `requests/__init__.py,sha256=47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU,0
+requests/api.py,sha256=LPDuIvOrP8pUjjzx3f6sDR-YoBPPbzOjXL3WufkpbeQ,6449
+requests-2.31.0.dist-info/RECORD,,
+`
+	manifest, err := ParseRecord(strings.NewReader(record))
+	if err != nil {
+		t.Fatalf("ParseRecord failed: %v", err)
+	}
+
+	if len(manifest.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(manifest.Entries))
+	}
+
+	entry := manifest.Entries[0]
+	if entry.Path != "requests/__init__.py" || entry.Algorithm != "sha256" || entry.Size != 0 {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+	// sha256 of the empty file
+	if entry.Digest != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("Unexpected digest %s", entry.Digest)
+	}
+}
+
+func TestParseMD5Sums(t *testing.T) {
+	md5sums := `d41d8cd98f00b204e9800998ecf8427e  usr/bin/tool
+65a8e27d8879283831b664bd8b7f0ad4  usr/share/doc/tool/copyright
+`
+	manifest, err := ParseMD5Sums(strings.NewReader(md5sums))
+	if err != nil {
+		t.Fatalf("ParseMD5Sums failed: %v", err)
+	}
+
+	if len(manifest.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(manifest.Entries))
+	}
+	if manifest.Entries[1].Path != "usr/share/doc/tool/copyright" || manifest.Entries[1].Algorithm != "md5" {
+		t.Errorf("Unexpected entry %+v", manifest.Entries[1])
+	}
+}
+
+func TestParseChecksums(t *testing.T) {
+	checksums := "dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f  ./docs/readme.txt\n" +
+		"0a0a9f2a6772942557ab5355d76af442f8f65e01 *bin/tool.exe\n" +
+		"MD5 (notes (draft).txt) = 65a8e27d8879283831b664bd8b7f0ad4\n" +
+		"\\DFFD6021BB2BD5B0AF676290809EC3A53191DD81C7F70A4B28688A362182986F  odd\\\\name\n"
+	manifest, err := ParseChecksums(strings.NewReader(checksums))
+	if err != nil {
+		t.Fatalf("ParseChecksums failed: %v", err)
+	}
+
+	expected := []Entry{
+		{Path: "docs/readme.txt", Algorithm: "sha256", Digest: "dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f", Size: -1},
+		{Path: "bin/tool.exe", Algorithm: "sha1", Digest: "0a0a9f2a6772942557ab5355d76af442f8f65e01", Size: -1},
+		{Path: "notes (draft).txt", Algorithm: "md5", Digest: "65a8e27d8879283831b664bd8b7f0ad4", Size: -1},
+		{Path: `odd\name`, Algorithm: "sha256", Digest: "dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f", Size: -1},
+	}
+	if len(manifest.Entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %d", len(expected), len(manifest.Entries))
+	}
+	for i, want := range expected {
+		if manifest.Entries[i] != want {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, want, manifest.Entries[i])
+		}
+	}
+}
+
+func TestParseChecksums_Malformed(t *testing.T) {
+	for _, line := range []string{"abc  short.txt", "not a checksum line", "SHA3 (x) = abcd"} {
+		if _, err := ParseChecksums(strings.NewReader(line)); err == nil {
+			t.Errorf("Expected an error for %q", line)
+		}
+	}
+}
+
+func TestParseNPMPack(t *testing.T) {
+	pack := `[{"id": "left-pad@1.3.0", "files": [
+		{"path": "index.js", "size": 1070, "mode": 420},
+		{"path": "package.json", "size": 600, "mode": 420}
+	]}]`
+	manifest, err := ParseNPMPack(strings.NewReader(pack))
+	if err != nil {
+		t.Fatalf("ParseNPMPack failed: %v", err)
+	}
+
+	if len(manifest.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(manifest.Entries))
+	}
+	if manifest.Entries[0].Size != 1070 || manifest.Entries[0].Digest != "" {
+		t.Errorf("Unexpected entry %+v", manifest.Entries[0])
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := map[string]string{
+		"site-packages/requests-2.31.0.dist-info/RECORD": FormatRecord,
+		"/var/lib/dpkg/info/coreutils.md5sums":           FormatMD5Sums,
+		"pack.json":                                      FormatNPM,
+		"release/SHA256SUMS":                             FormatChecksums,
+		"archive.sha256":                                 FormatChecksums,
+	}
+	for path, want := range tests {
+		got, err := DetectFormat(path)
+		if err != nil || got != want {
+			t.Errorf("DetectFormat(%s) = %s, %v; want %s", path, got, err, want)
+		}
+	}
+	if _, err := DetectFormat("checksums.txt"); err == nil {
+		t.Error("Unknown file names should not be detected")
+	}
+}
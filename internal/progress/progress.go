@@ -7,11 +7,16 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/term"
 )
 
 type Bar struct {
+	label      string
 	total      int64
 	current    int64
+	bytes      int64 // read so far, including files still being hashed
+	totalBytes int64 // size of the files counted, if known
 	width      int
 	writer     io.Writer
 	mu         sync.Mutex
@@ -24,25 +29,38 @@ func New(total int64) *Bar {
 		total:      total,
 		current:    0,
 		width:      50,
-		writer:     os.Stdout,
-		enabled:    true, // Always enabled - terminal detection can be unreliable
+		writer:     os.Stderr,
+		enabled:    isTerminal(),
 		lastUpdate: time.Now(),
 	}
 }
 
+// isTerminal reports whether stderr, where progress is drawn, is a
+// terminal; a redirect to a file, a pipe or /dev/null shows nothing
 func isTerminal() bool {
-	fileInfo, err := os.Stdout.Stat()
-	if err != nil {
-		return false
-	}
-	// Check if stdout is a terminal (character device)
-	return (fileInfo.Mode() & os.ModeCharDevice) != 0
+	return term.IsTerminal(int(os.Stderr.Fd()))
 }
 
 func (b *Bar) SetDirectory(dir string) {
 	// No-op: directory tracking removed for simpler display
 }
 
+// SetLabel prefixes the bar with label, such as the phase of a scan it
+// shows
+func (b *Bar) SetLabel(label string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.label = label
+}
+
+// SetTotalBytes sets the total size of the files the bar counts, so the
+// bytes read are shown against it
+func (b *Bar) SetTotalBytes(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.totalBytes = n
+}
+
 func (b *Bar) Increment() {
 	if !b.enabled {
 		return
@@ -61,6 +79,26 @@ func (b *Bar) Increment() {
 	}
 }
 
+// AddBytes counts n more bytes read. The bar shows the running total, so a
+// single huge file still moves the display while it is being hashed. It is
+// safe to call from several goroutines.
+func (b *Bar) AddBytes(n int64) {
+	if !b.enabled {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.bytes += n
+
+	now := time.Now()
+	if now.Sub(b.lastUpdate) > 100*time.Millisecond {
+		b.lastUpdate = now
+		b.render()
+	}
+}
+
 // render must be called with mu already locked
 func (b *Bar) render() {
 	if b.total == 0 {
@@ -82,8 +120,18 @@ func (b *Bar) render() {
 	fmt.Fprintf(b.writer, "\033]9;4;1;%d\033\\", int(percent))
 
 	// Clear the line and write progress
-	fmt.Fprintf(b.writer, "\r\033[K[%s] %3d%% (%d/%d)",
+	fmt.Fprintf(b.writer, "\r\033[K")
+	if b.label != "" {
+		fmt.Fprintf(b.writer, "%s: ", b.label)
+	}
+	fmt.Fprintf(b.writer, "[%s] %3d%% (%d/%d)",
 		bar, int(percent), b.current, b.total)
+	switch {
+	case b.totalBytes > 0:
+		fmt.Fprintf(b.writer, " %s of %s read", formatBytes(b.bytes), formatBytes(b.totalBytes))
+	case b.bytes > 0:
+		fmt.Fprintf(b.writer, " %s read", formatBytes(b.bytes))
+	}
 }
 
 func (b *Bar) Finish() {
@@ -103,3 +151,73 @@ func (b *Bar) Finish() {
 
 	fmt.Fprintf(b.writer, "\n")
 }
+
+// Counter shows how many files, and how many bytes, a task whose total is
+// not known in advance has come across so far, such as a directory walk
+type Counter struct {
+	label      string
+	files      int64
+	bytes      int64
+	writer     io.Writer
+	mu         sync.Mutex
+	enabled    bool
+	lastUpdate time.Time
+}
+
+// NewCounter returns a counter shown as label followed by the counts
+func NewCounter(label string) *Counter {
+	return &Counter{label: label, writer: os.Stderr, enabled: isTerminal(), lastUpdate: time.Now()}
+}
+
+// Add counts one more file of size bytes. It is safe to call from several
+// goroutines.
+func (c *Counter) Add(size int64) {
+	if !c.enabled {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.files++
+	c.bytes += size
+
+	now := time.Now()
+	if now.Sub(c.lastUpdate) > 100*time.Millisecond {
+		c.lastUpdate = now
+		c.render()
+	}
+}
+
+// Finish shows the final counts and ends the line
+func (c *Counter) Finish() {
+	if !c.enabled {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.render()
+	fmt.Fprintf(c.writer, "\n")
+}
+
+// render must be called with mu already locked
+func (c *Counter) render() {
+	fmt.Fprintf(c.writer, "\r\033[K%s: %d files, %s", c.label, c.files, formatBytes(c.bytes))
+}
+
+// formatBytes renders a byte count using binary units
+func formatBytes(n int64) string {
+	value, suffix := float64(n), "B"
+	for _, next := range []string{"KB", "MB", "GB", "TB"} {
+		if value < 1024 {
+			break
+		}
+		value, suffix = value/1024, next
+	}
+	if suffix == "B" {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f %s", value, suffix)
+}
@@ -0,0 +1,163 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"merkle-go/internal/archive"
+	"merkle-go/internal/compare"
+)
+
+// archiveGroup holds the steps of the members of one archive. Path is the
+// slash path of the archive relative to the target.
+type archiveGroup struct {
+	Path    string
+	Kind    string
+	Members []compare.SyncStep
+}
+
+// splitArchiveSteps separates the steps of archive members, whose leaf
+// paths such as backup.zip!/docs/a.txt name no file of their own, from the
+// steps of ordinary files, grouping them by archive
+func splitArchiveSteps(steps []compare.SyncStep, kinds []string) ([]compare.SyncStep, []archiveGroup) {
+	if len(kinds) == 0 {
+		return steps, nil
+	}
+	plain := make([]compare.SyncStep, 0, len(steps))
+	groups := make(map[string]*archiveGroup)
+	for _, step := range steps {
+		i := strings.Index(step.Path, archive.Separator+"/")
+		if i < 0 || archive.Kind(step.Path[:i], kinds) == "" {
+			plain = append(plain, step)
+			continue
+		}
+		archivePath := step.Path[:i]
+		group, ok := groups[archivePath]
+		if !ok {
+			group = &archiveGroup{Path: archivePath, Kind: archive.Kind(archivePath, kinds)}
+			groups[archivePath] = group
+		}
+		group.Members = append(group.Members, step)
+	}
+
+	archives := make([]archiveGroup, 0, len(groups))
+	for _, group := range groups {
+		archives = append(archives, *group)
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].Path < archives[j].Path })
+	return plain, archives
+}
+
+// memberName returns the name of the member a step refers to
+func (g archiveGroup) memberName(step compare.SyncStep) string {
+	return strings.TrimPrefix(step.Path, g.Path+archive.Separator+"/")
+}
+
+// restoreArchive brings one archive in line with the members the manifest
+// lists for it. The archive is copied whole from the source if any of its
+// members is to be copied or deleted, and only replaces the target archive
+// if it holds exactly those members with the manifest hashes. An archive
+// whose members are all gone from the manifest is deleted. The outcome is
+// reported for every member step.
+func restoreArchive(ctx context.Context, group archiveGroup, root *os.Root, source Source, opts Options, result *Result) {
+	var keep, changed int
+	for _, step := range group.Members {
+		if step.Action != compare.SyncDelete {
+			keep++
+		}
+		if step.Action != compare.SyncUnchanged {
+			changed++
+		}
+	}
+	if changed == 0 {
+		return
+	}
+
+	var err error
+	switch {
+	case keep > 0:
+		err = copyArchive(ctx, group, root, source, opts)
+	case opts.KeepExtra:
+		return
+	default:
+		err = deleteStep(compare.SyncStep{Path: group.Path}, root)
+	}
+
+	for _, step := range group.Members {
+		if step.Action == compare.SyncUnchanged {
+			continue
+		}
+		stepErr := err
+		if stepErr != nil {
+			stepErr = fmt.Errorf("%s %s: archive %s: %w", step.Action, step.Path, group.Path, err)
+			result.Errors = append(result.Errors, stepErr)
+		} else if step.Action == compare.SyncCopy {
+			result.Copied++
+			result.CopiedBytes += step.Size
+		} else {
+			result.Deleted++
+		}
+		if opts.OnStep != nil {
+			opts.OnStep(step, stepErr)
+		}
+	}
+}
+
+// copyArchive downloads the archive of group and verifies its members
+// before it replaces the target archive
+func copyArchive(ctx context.Context, group archiveGroup, root *os.Root, source Source, opts Options) error {
+	dest, err := targetPath(group.Path)
+	if err != nil {
+		return err
+	}
+	if err := prepareDest(root, dest); err != nil {
+		return err
+	}
+
+	tmpName, err := download(ctx, source, group.Path, root, dest, io.Discard)
+	defer root.Remove(tmpName) // No-op once renamed
+	if err != nil {
+		return err
+	}
+
+	file, err := root.Open(tmpName)
+	if err != nil {
+		return err
+	}
+	members, err := archive.HashMembersOf(file, group.Kind, opts.Algorithm)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	want := make(map[string]string)
+	for _, step := range group.Members {
+		if step.Action != compare.SyncDelete {
+			want[group.memberName(step)] = step.Hash
+		}
+	}
+	for _, member := range members {
+		hash, ok := want[member.Name]
+		if !ok {
+			return fmt.Errorf("%w: member %s is not in the manifest", ErrHashMismatch, member.Name)
+		}
+		if member.Hash != hash {
+			return fmt.Errorf("%w: member %s: got %s, want %s", ErrHashMismatch, member.Name, member.Hash, hash)
+		}
+		delete(want, member.Name)
+	}
+	if len(want) > 0 {
+		missing := slices.Sorted(maps.Keys(want))
+		return fmt.Errorf("%w: member %s is missing", ErrHashMismatch, missing[0])
+	}
+
+	if err := root.Chmod(tmpName, 0644); err != nil {
+		return err
+	}
+	return root.Rename(tmpName, dest)
+}
@@ -0,0 +1,319 @@
+// Package restore reconciles a directory with a manifest by applying a
+// compare.SyncPlan: files are copied from a source, verified against the
+// manifest hash and moved into place, and files the manifest does not list
+// are deleted.
+package restore
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"merkle-go/internal/compare"
+	"merkle-go/internal/hash"
+)
+
+// Source provides the content of the files to restore
+type Source interface {
+	// Open returns the content of the file at relPath, a slash path
+	// relative to the source root
+	Open(ctx context.Context, relPath string) (io.ReadCloser, error)
+}
+
+// DirSource reads files from a local directory
+type DirSource string
+
+func (d DirSource) Open(ctx context.Context, relPath string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), filepath.FromSlash(relPath)))
+}
+
+// HTTPSource downloads files from below a base URL
+type HTTPSource struct {
+	Base   *url.URL
+	Client *http.Client // nil means http.DefaultClient
+}
+
+func (h HTTPSource) Open(ctx context.Context, relPath string) (io.ReadCloser, error) {
+	fileURL := h.Base.JoinPath(strings.Split(relPath, "/")...)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", fileURL.Redacted(), resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// NewSource returns an HTTPSource for http and https URLs and a DirSource
+// otherwise
+func NewSource(from string) (Source, error) {
+	if strings.HasPrefix(from, "http://") || strings.HasPrefix(from, "https://") {
+		base, err := url.Parse(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source URL: %w", err)
+		}
+		return HTTPSource{Base: base}, nil
+	}
+	info, err := os.Stat(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("source %s is not a directory", from)
+	}
+	return DirSource(from), nil
+}
+
+// ErrHashMismatch is returned for a copied file whose content does not
+// match the manifest
+var ErrHashMismatch = errors.New("content does not match the manifest")
+
+// Options tunes Apply
+type Options struct {
+	// Algorithm is the hash algorithm of the manifest
+	Algorithm string
+
+	// Key is the secret of keyed algorithms (hash.HMACSHA256)
+	Key []byte
+
+	// SegmentSize is the segment size of the manifest, whose files larger
+	// than it were hashed as a tree of segments (see hash.ReadOptions)
+	SegmentSize int64
+
+	// KeepExtra leaves files that are not in the manifest in place
+	KeepExtra bool
+
+	// Archives lists the archive formats the manifest descended into (see
+	// package archive). Steps for their members restore the whole archive.
+	Archives []string
+
+	// OnStep, if set, is called after each step with its error, if any
+	OnStep func(step compare.SyncStep, err error)
+}
+
+// Result counts what Apply did. Errors holds one entry per failed step;
+// they do not stop the restore.
+type Result struct {
+	Copied      int
+	CopiedBytes int64
+	Deleted     int
+	Errors      []error
+}
+
+// Apply performs the copy and delete steps of plan in target, reading from
+// source. Every copied file is written to a temporary file, checked against
+// the hash in the plan and only then renamed over the target file, so a bad
+// download never replaces anything. Files are only created and removed
+// through an os.Root of target: a symbolic link leading out of target fails
+// the step instead of being followed. It stops early if ctx is cancelled.
+func Apply(ctx context.Context, plan *compare.SyncPlan, target string, source Source, opts Options) (*Result, error) {
+	if _, err := hash.FileHasher(opts.Algorithm, hash.ReadOptions{SegmentSize: opts.SegmentSize, Key: opts.Key}); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(target)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	result := &Result{}
+	steps, archives := splitArchiveSteps(plan.Steps, opts.Archives)
+	for _, step := range steps {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		var err error
+		switch step.Action {
+		case compare.SyncCopy:
+			err = copyStep(ctx, step, root, source, opts)
+			if err == nil && !strings.HasSuffix(step.Path, "/") {
+				result.Copied++
+				result.CopiedBytes += step.Size
+			}
+		case compare.SyncDelete:
+			if opts.KeepExtra {
+				continue
+			}
+			err = deleteStep(step, root)
+			if err == nil {
+				result.Deleted++
+			}
+		default:
+			continue
+		}
+
+		if err != nil {
+			err = fmt.Errorf("%s %s: %w", step.Action, step.Path, err)
+			result.Errors = append(result.Errors, err)
+		}
+		if opts.OnStep != nil {
+			opts.OnStep(step, err)
+		}
+	}
+
+	for _, group := range archives {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		restoreArchive(ctx, group, root, source, opts, result)
+	}
+	return result, nil
+}
+
+// targetPath resolves a plan path to a path relative to the target,
+// refusing paths that would escape it
+func targetPath(relPath string) (string, error) {
+	relPath = strings.TrimSuffix(relPath, "/")
+	if !filepath.IsLocal(filepath.FromSlash(relPath)) || path.Clean(relPath) != relPath {
+		return "", fmt.Errorf("refusing unsafe path %q", relPath)
+	}
+	return filepath.FromSlash(relPath), nil
+}
+
+// prepareDest makes room for a file at dest: its parent directories are
+// created and a directory standing where the manifest has a file is removed
+func prepareDest(root *os.Root, dest string) error {
+	if err := root.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if info, err := root.Lstat(dest); err == nil && info.IsDir() {
+		return root.RemoveAll(dest)
+	}
+	return nil
+}
+
+func copyStep(ctx context.Context, step compare.SyncStep, root *os.Root, source Source, opts Options) error {
+	dest, err := targetPath(step.Path)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(step.Path, "/") {
+		return root.MkdirAll(dest, 0755) // Empty directory marker
+	}
+	if err := prepareDest(root, dest); err != nil {
+		return err
+	}
+
+	h, _ := hash.NewKeyed(opts.Algorithm, opts.Key)
+	tmpName, err := download(ctx, source, step.Path, root, dest, h)
+	defer root.Remove(tmpName) // No-op once renamed
+	if err != nil {
+		return err
+	}
+
+	digest := hex.EncodeToString(h.Sum(nil))
+	if step.Sampled > 0 || opts.SegmentSize > 0 && step.Size > opts.SegmentSize {
+		if digest, err = rehash(root, tmpName, step, opts); err != nil {
+			return err
+		}
+	}
+	if digest != step.Hash {
+		return fmt.Errorf("%w: got %s, want %s", ErrHashMismatch, digest, step.Hash)
+	}
+	if err := root.Chmod(tmpName, 0644); err != nil {
+		return err
+	}
+	if step.MTime != 0 {
+		mtime := time.Unix(step.MTime, 0)
+		if err := root.Chtimes(tmpName, mtime, mtime); err != nil {
+			return err
+		}
+	}
+	return root.Rename(tmpName, dest)
+}
+
+// rehash hashes the downloaded file tmpName the way the manifest hashed it
+// when that is not one pass over the whole content, reading it through root
+func rehash(root *os.Root, tmpName string, step compare.SyncStep, opts Options) (string, error) {
+	file, err := root.Open(tmpName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	if step.Sampled > 0 {
+		// Sampled files are fingerprinted from their ends, not hashed whole
+		digest, _, err := hash.HashSampleAt(file, info.Size(), opts.Algorithm, step.Sampled, opts.Key)
+		return digest, err
+	}
+	// Large files are hashed as a tree of segments
+	return hash.HashSegmentsAt(file, info.Size(), opts.Algorithm, hash.ReadOptions{SegmentSize: opts.SegmentSize, Key: opts.Key})
+}
+
+// download copies the source file at relPath to a temporary file next to
+// dest in root, also writing its content to h, and returns the temporary
+// file's name in root. The caller removes the temporary file unless it
+// renames it.
+func download(ctx context.Context, source Source, relPath string, root *os.Root, dest string, h io.Writer) (string, error) {
+	in, err := source.Open(ctx, relPath)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	tmp, tmpName, err := createTemp(root, dest)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(io.MultiWriter(tmp, h), in)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	return tmpName, err
+}
+
+// createTemp creates a new hidden file in the directory of dest in root,
+// like os.CreateTemp, and returns it with its name in root
+func createTemp(root *os.Root, dest string) (*os.File, string, error) {
+	for range 100 {
+		name := filepath.Join(filepath.Dir(dest), fmt.Sprintf(".%s.%d.restore", filepath.Base(dest), rand.Uint32()))
+		f, err := root.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if !errors.Is(err, os.ErrExist) {
+			return f, name, err
+		}
+	}
+	return nil, "", fmt.Errorf("failed to create a temporary file for %s", dest)
+}
+
+func deleteStep(step compare.SyncStep, root *os.Root) error {
+	dest, err := targetPath(step.Path)
+	if err != nil {
+		return err
+	}
+	err = root.Remove(dest)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,294 @@
+package restore
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"merkle-go/internal/archive"
+	"merkle-go/internal/compare"
+	"merkle-go/internal/hash"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func digest(t *testing.T, path string) string {
+	t.Helper()
+	sum, err := hash.HashFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sum
+}
+
+func TestApply(t *testing.T) {
+	for _, remote := range []bool{false, true} {
+		sourceDir := t.TempDir()
+		target := t.TempDir()
+		writeFile(t, filepath.Join(sourceDir, "docs", "a.txt"), "new content")
+		writeFile(t, filepath.Join(sourceDir, "b.txt"), "tampered")
+		writeFile(t, filepath.Join(target, "b.txt"), "old b")
+		writeFile(t, filepath.Join(target, "extra.txt"), "extra")
+
+		plan := &compare.SyncPlan{Steps: []compare.SyncStep{
+			{Action: compare.SyncCopy, Path: "b.txt", Hash: "0000000000000000"},
+			{Action: compare.SyncCopy, Path: "docs/a.txt", Hash: digest(t, filepath.Join(sourceDir, "docs", "a.txt")), Size: 11},
+			{Action: compare.SyncCopy, Path: "empty/"},
+			{Action: compare.SyncDelete, Path: "extra.txt"},
+		}}
+
+		var source Source = DirSource(sourceDir)
+		if remote {
+			server := httptest.NewServer(http.FileServer(http.Dir(sourceDir)))
+			defer server.Close()
+			var err error
+			if source, err = NewSource(server.URL + "/"); err != nil {
+				t.Fatalf("NewSource failed: %v", err)
+			}
+		}
+
+		result, err := Apply(context.Background(), plan, target, source, Options{Algorithm: hash.XXH64})
+		if err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+
+		if result.Copied != 1 || result.CopiedBytes != 11 || result.Deleted != 1 {
+			t.Errorf("Unexpected result: %+v", result)
+		}
+		if len(result.Errors) != 1 || !errors.Is(result.Errors[0], ErrHashMismatch) {
+			t.Errorf("Expected a hash mismatch for b.txt, got %v", result.Errors)
+		}
+		if data, _ := os.ReadFile(filepath.Join(target, "b.txt")); string(data) != "old b" {
+			t.Errorf("Expected b.txt to be left alone after a failed copy, got %q", data)
+		}
+		if data, _ := os.ReadFile(filepath.Join(target, "docs", "a.txt")); string(data) != "new content" {
+			t.Errorf("Expected docs/a.txt to be restored, got %q", data)
+		}
+		if _, err := os.Stat(filepath.Join(target, "extra.txt")); !os.IsNotExist(err) {
+			t.Error("Expected extra.txt to be deleted")
+		}
+		if info, err := os.Stat(filepath.Join(target, "empty")); err != nil || !info.IsDir() {
+			t.Error("Expected the empty directory to be created")
+		}
+	}
+}
+
+func TestApply_RejectsEscapingPaths(t *testing.T) {
+	plan := &compare.SyncPlan{Steps: []compare.SyncStep{
+		{Action: compare.SyncDelete, Path: "../outside.txt"},
+	}}
+	result, err := Apply(context.Background(), plan, t.TempDir(), DirSource(t.TempDir()), Options{})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if len(result.Errors) != 1 {
+		t.Errorf("Expected the escaping path to be refused, got %v", result.Errors)
+	}
+}
+
+func TestApply_SymlinkedParents(t *testing.T) {
+	sourceDir := t.TempDir()
+	target := t.TempDir()
+	outside := t.TempDir()
+	writeFile(t, filepath.Join(sourceDir, "link", "a.txt"), "content")
+	writeFile(t, filepath.Join(sourceDir, "inner", "b.txt"), "content")
+	writeFile(t, filepath.Join(outside, "victim.txt"), "keep me")
+	if err := os.Symlink(outside, filepath.Join(target, "link")); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+	// A link that stays inside the target is followed as usual
+	writeFile(t, filepath.Join(target, "real", "keep.txt"), "kept")
+	if err := os.Symlink("real", filepath.Join(target, "inner")); err != nil {
+		t.Fatal(err)
+	}
+
+	plan := &compare.SyncPlan{Steps: []compare.SyncStep{
+		{Action: compare.SyncCopy, Path: "link/a.txt", Hash: digest(t, filepath.Join(sourceDir, "link", "a.txt"))},
+		{Action: compare.SyncCopy, Path: "link/sub/"},
+		{Action: compare.SyncDelete, Path: "link/victim.txt"},
+		{Action: compare.SyncCopy, Path: "inner/b.txt", Hash: digest(t, filepath.Join(sourceDir, "inner", "b.txt"))},
+	}}
+	result, err := Apply(context.Background(), plan, target, DirSource(sourceDir), Options{Algorithm: hash.XXH64})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if len(result.Errors) != 3 || result.Copied != 1 {
+		t.Errorf("Expected the three steps through the link out of the target to fail, got %+v", result)
+	}
+	entries, _ := os.ReadDir(outside)
+	if len(entries) != 1 || entries[0].Name() != "victim.txt" {
+		t.Errorf("Expected the directory outside the target to be untouched, got %v", entries)
+	}
+	if data, _ := os.ReadFile(filepath.Join(target, "real", "b.txt")); string(data) != "content" {
+		t.Errorf("Expected b.txt to be restored through the inner link, got %q", data)
+	}
+}
+
+func TestApply_Keyed(t *testing.T) {
+	sourceDir := t.TempDir()
+	target := t.TempDir()
+	writeFile(t, filepath.Join(sourceDir, "a.txt"), "content")
+	hasher, err := hash.FileHasher(hash.HMACSHA256, hash.ReadOptions{Key: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyed, err := hasher(filepath.Join(sourceDir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := &compare.SyncPlan{Steps: []compare.SyncStep{{Action: compare.SyncCopy, Path: "a.txt", Hash: keyed, Size: 7}}}
+
+	if _, err := Apply(context.Background(), plan, target, DirSource(sourceDir), Options{Algorithm: hash.HMACSHA256}); err == nil {
+		t.Error("Expected a keyed manifest without a key to be rejected")
+	}
+	result, err := Apply(context.Background(), plan, target, DirSource(sourceDir), Options{Algorithm: hash.HMACSHA256, Key: []byte("secret")})
+	if err != nil || result.Copied != 1 || len(result.Errors) != 0 {
+		t.Errorf("Expected a.txt to be restored with the key, got %+v (%v)", result, err)
+	}
+}
+
+func TestApply_Segmented(t *testing.T) {
+	sourceDir := t.TempDir()
+	target := t.TempDir()
+	content := strings.Repeat("0123456789", 1000)
+	writeFile(t, filepath.Join(sourceDir, "large.bin"), content)
+	writeFile(t, filepath.Join(sourceDir, "small.txt"), "small")
+
+	const segmentSize = 1024
+	hasher, err := hash.FileHasher(hash.XXH64, hash.ReadOptions{SegmentSize: segmentSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	segmented, err := hasher(filepath.Join(sourceDir, "large.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if segmented == digest(t, filepath.Join(sourceDir, "large.bin")) {
+		t.Fatal("Expected the segmented digest to differ from the plain one")
+	}
+	plan := &compare.SyncPlan{Steps: []compare.SyncStep{
+		{Action: compare.SyncCopy, Path: "large.bin", Hash: segmented, Size: int64(len(content))},
+		{Action: compare.SyncCopy, Path: "small.txt", Hash: digest(t, filepath.Join(sourceDir, "small.txt")), Size: 5},
+	}}
+
+	result, err := Apply(context.Background(), plan, t.TempDir(), DirSource(sourceDir), Options{Algorithm: hash.XXH64})
+	if err != nil || len(result.Errors) != 1 || !errors.Is(result.Errors[0], ErrHashMismatch) {
+		t.Errorf("Expected a mismatch without the segment size, got %+v (%v)", result, err)
+	}
+	result, err = Apply(context.Background(), plan, target, DirSource(sourceDir), Options{Algorithm: hash.XXH64, SegmentSize: segmentSize})
+	if err != nil || result.Copied != 2 || len(result.Errors) != 0 {
+		t.Errorf("Expected both files to be restored, got %+v (%v)", result, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(target, "large.bin")); string(data) != content {
+		t.Error("Expected large.bin to be restored")
+	}
+}
+
+func TestApply_Sampled(t *testing.T) {
+	sourceDir := t.TempDir()
+	target := t.TempDir()
+	content := strings.Repeat("0123456789", 1000)
+	writeFile(t, filepath.Join(sourceDir, "movie.mkv"), content)
+
+	sampled, _, err := hash.HashFileSample(filepath.Join(sourceDir, "movie.mkv"), hash.XXH64, 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := &compare.SyncPlan{Steps: []compare.SyncStep{
+		{Action: compare.SyncCopy, Path: "movie.mkv", Hash: sampled, Size: int64(len(content)), Sampled: 100},
+	}}
+	result, err := Apply(context.Background(), plan, target, DirSource(sourceDir), Options{Algorithm: hash.XXH64})
+	if err != nil || result.Copied != 1 || len(result.Errors) != 0 {
+		t.Errorf("Expected the sampled file to be restored, got %+v (%v)", result, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(target, "movie.mkv")); string(data) != content {
+		t.Error("Expected movie.mkv to be restored")
+	}
+}
+
+func writeZip(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(files[name]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path, buf.String())
+}
+
+func memberDigest(t *testing.T, content string) string {
+	t.Helper()
+	sum, err := archive.HashReader(strings.NewReader(content), hash.XXH64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sum
+}
+
+func TestApply_Archives(t *testing.T) {
+	sourceDir := t.TempDir()
+	target := t.TempDir()
+	writeZip(t, filepath.Join(sourceDir, "backup.zip"), map[string]string{"a.txt": "new a", "docs/b.txt": "b"})
+	writeZip(t, filepath.Join(target, "backup.zip"), map[string]string{"a.txt": "old a", "docs/b.txt": "b", "c.txt": "c"})
+	writeZip(t, filepath.Join(sourceDir, "bad.zip"), map[string]string{"a.txt": "tampered"})
+	writeZip(t, filepath.Join(target, "bad.zip"), map[string]string{"a.txt": "old"})
+	writeZip(t, filepath.Join(target, "old.zip"), map[string]string{"x.txt": "x"})
+
+	plan := &compare.SyncPlan{Steps: []compare.SyncStep{
+		{Action: compare.SyncCopy, Path: "backup.zip!/a.txt", Hash: memberDigest(t, "new a"), Size: 5},
+		{Action: compare.SyncDelete, Path: "backup.zip!/c.txt"},
+		{Action: compare.SyncUnchanged, Path: "backup.zip!/docs/b.txt", Hash: memberDigest(t, "b"), Size: 1},
+		{Action: compare.SyncCopy, Path: "bad.zip!/a.txt", Hash: memberDigest(t, "good"), Size: 4},
+		{Action: compare.SyncDelete, Path: "old.zip!/x.txt"},
+	}}
+	result, err := Apply(context.Background(), plan, target, DirSource(sourceDir),
+		Options{Algorithm: hash.XXH64, Archives: []string{archive.Zip}})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	if result.Copied != 1 || result.CopiedBytes != 5 || result.Deleted != 2 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if len(result.Errors) != 1 || !errors.Is(result.Errors[0], ErrHashMismatch) {
+		t.Errorf("Expected a member mismatch for bad.zip, got %v", result.Errors)
+	}
+	members, err := archive.HashMembers(filepath.Join(target, "backup.zip"), archive.Zip, hash.XXH64)
+	if err != nil || len(members) != 2 || members[0].Name != "a.txt" || members[0].Hash != memberDigest(t, "new a") {
+		t.Errorf("Expected backup.zip to be replaced by the source archive, got %+v (%v)", members, err)
+	}
+	if members, _ := archive.HashMembers(filepath.Join(target, "bad.zip"), archive.Zip, hash.XXH64); len(members) != 1 ||
+		members[0].Hash != memberDigest(t, "old") {
+		t.Errorf("Expected bad.zip to be left alone after a failed copy, got %+v", members)
+	}
+	if _, err := os.Stat(filepath.Join(target, "old.zip")); !os.IsNotExist(err) {
+		t.Error("Expected old.zip to be deleted")
+	}
+	if _, err := os.Stat(filepath.Join(target, "backup.zip!")); !os.IsNotExist(err) {
+		t.Error("Expected no directory to be created for archive members")
+	}
+}
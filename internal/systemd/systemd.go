@@ -0,0 +1,129 @@
+// Package systemd implements the parts of the systemd service protocol that
+// long-running commands need: readiness and status notifications and the
+// watchdog keep-alive. Everything is a no-op when the process is not run by
+// systemd.
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notification states understood by systemd
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Status returns the state that sets the status line shown by systemctl.
+// Newlines, which would end the assignment, are replaced by spaces.
+func Status(format string, args ...any) string {
+	return "STATUS=" + strings.ReplaceAll(fmt.Sprintf(format, args...), "\n", " ")
+}
+
+// Notify sends state to the socket named by $NOTIFY_SOCKET. It reports
+// false with no error when the variable is unset, that is when the service
+// manager does not expect notifications.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	addr := &net.UnixAddr{Name: socket, Net: "unixgram"}
+	if socket[0] == '@' {
+		// Abstract namespace
+		addr.Name = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to notify socket: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("failed to notify service manager: %w", err)
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the watchdog timeout systemd enforces on this
+// process, or 0 if there is none. $WATCHDOG_PID, when set, must name this
+// process, so a child started by the service does not answer for it.
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" {
+		n, err := strconv.Atoi(pid)
+		if err != nil {
+			return 0, fmt.Errorf("invalid WATCHDOG_PID %q: %w", pid, err)
+		}
+		if n != os.Getpid() {
+			return 0, nil
+		}
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC %q", usec)
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
+
+// Start tells the service manager the daemon is ready, with status as its
+// status line, and keeps the watchdog fed at half its timeout until ctx is
+// done. The returned function reports that the daemon is stopping and waits
+// for the keep-alive to end. Failures are logged, never fatal: a daemon
+// that cannot reach systemd still does its work.
+func Start(ctx context.Context, status string) func() {
+	if _, err := Notify(Ready + "\n" + Status("%s", status)); err != nil {
+		slog.Warn("Failed to notify systemd", "error", err)
+	}
+
+	interval, err := WatchdogInterval()
+	if err != nil {
+		slog.Warn("Ignoring systemd watchdog", "error", err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if interval <= 0 {
+			return
+		}
+		slog.Debug("Feeding systemd watchdog", "timeout", interval)
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := Notify(Watchdog); err != nil {
+					slog.Warn("Failed to feed systemd watchdog", "error", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+		if _, err := Notify(Stopping); err != nil {
+			slog.Warn("Failed to notify systemd", "error", err)
+		}
+	}
+}
+
+// SetStatus updates the status line shown by systemctl
+func SetStatus(format string, args ...any) {
+	if _, err := Notify(Status(format, args...)); err != nil {
+		slog.Warn("Failed to notify systemd", "error", err)
+	}
+}
@@ -0,0 +1,107 @@
+package systemd
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listen opens a notify socket and points $NOTIFY_SOCKET at it
+func listen(t *testing.T) *net.UnixConn {
+	t.Helper()
+	// Socket paths are limited to about 100 bytes, so avoid long temp dirs
+	dir, err := os.MkdirTemp("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets unavailable: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", socket)
+	return conn
+}
+
+func receive(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Expected a notification: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify(Ready); sent || err != nil {
+		t.Errorf("Expected no notification without NOTIFY_SOCKET, got %v, %v", sent, err)
+	}
+
+	conn := listen(t)
+	if sent, err := Notify(Status("line one\nline two")); !sent || err != nil {
+		t.Fatalf("Expected a notification, got %v, %v", sent, err)
+	}
+	if got := receive(t, conn); got != "STATUS=line one line two" {
+		t.Errorf("Unexpected notification %q", got)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	t.Setenv("WATCHDOG_PID", "")
+	if interval, err := WatchdogInterval(); interval != 0 || err != nil {
+		t.Errorf("Expected no watchdog, got %v, %v", interval, err)
+	}
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	if interval, err := WatchdogInterval(); interval != 30*time.Second || err != nil {
+		t.Errorf("Expected a 30s watchdog, got %v, %v", interval, err)
+	}
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if interval, err := WatchdogInterval(); interval != 0 || err != nil {
+		t.Errorf("Expected the watchdog of another process to be ignored, got %v, %v", interval, err)
+	}
+
+	t.Setenv("WATCHDOG_PID", "")
+	t.Setenv("WATCHDOG_USEC", "soon")
+	if _, err := WatchdogInterval(); err == nil {
+		t.Error("Expected an invalid WATCHDOG_USEC to be reported")
+	}
+}
+
+func TestStart(t *testing.T) {
+	conn := listen(t)
+	t.Setenv("WATCHDOG_USEC", "20000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	stop := Start(context.Background(), "Watching /data")
+	if got := receive(t, conn); got != "READY=1\nSTATUS=Watching /data" {
+		t.Errorf("Unexpected readiness notification %q", got)
+	}
+	if got := receive(t, conn); got != Watchdog {
+		t.Errorf("Expected a watchdog keep-alive, got %q", got)
+	}
+	stop()
+
+	// Keep-alives sent before stop returned may still be queued
+	for {
+		got := receive(t, conn)
+		if got == Stopping {
+			break
+		}
+		if !strings.HasPrefix(got, "WATCHDOG") {
+			t.Fatalf("Expected STOPPING=1, got %q", got)
+		}
+	}
+}
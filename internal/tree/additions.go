@@ -0,0 +1,80 @@
+package tree
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"merkle-go/internal/hash"
+)
+
+// ReadAdditions parses a CSV of files to add to a tree hashed with
+// algorithm, as simulate --add takes them. Each row is path,hash,size[,mtime]
+// with the path relative to the tree root; mtime is Unix seconds or
+// RFC 3339. A header row starting with "path" is skipped. The returned paths
+// are relative, in the platform's form. Errors are prefixed with name and
+// the line of the offending row.
+func ReadAdditions(r io.Reader, name, algorithm string) (map[string]FileData, error) {
+	digestSize, err := hash.Size(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	files := make(map[string]FileData)
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		line, _ := reader.FieldPos(0)
+		if first && strings.EqualFold(record[0], "path") {
+			continue
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("%s:%d: expected path,hash,size[,mtime]", name, line)
+		}
+
+		relPath := filepath.FromSlash(record[0])
+		if !filepath.IsLocal(relPath) {
+			return nil, fmt.Errorf("%s:%d: path %q is not relative to the tree root", name, line, record[0])
+		}
+		digest := strings.ToLower(record[1])
+		if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != digestSize {
+			return nil, fmt.Errorf("%s:%d: hash %q is not a hex digest of %d bytes", name, line, record[1], digestSize)
+		}
+		size, err := strconv.ParseInt(record[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid size %q", name, line, record[2])
+		}
+		if size < 0 {
+			return nil, fmt.Errorf("%s:%d: negative size %d", name, line, size)
+		}
+		fileData := FileData{Hash: digest, Size: size}
+
+		if len(record) > 3 && record[3] != "" {
+			if seconds, err := strconv.ParseInt(record[3], 10, 64); err == nil {
+				fileData.ModTime = time.Unix(seconds, 0)
+			} else if t, err := time.Parse(time.RFC3339, record[3]); err == nil {
+				fileData.ModTime = t
+			} else {
+				return nil, fmt.Errorf("%s:%d: invalid mtime %q", name, line, record[3])
+			}
+		}
+
+		files[relPath] = fileData
+	}
+	return files, nil
+}
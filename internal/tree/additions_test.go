@@ -0,0 +1,56 @@
+package tree
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadAdditions(t *testing.T) {
+	csv := "path,hash,size,mtime\n" +
+		"# generated\n" +
+		"new/a.txt,00112233445566AA,10,1700000000\n" +
+		"b.txt,ffeeddccbbaa9988,0,\n"
+	files, err := ReadAdditions(strings.NewReader(csv), "add.csv", "xxh64")
+	if err != nil {
+		t.Fatalf("ReadAdditions failed: %v", err)
+	}
+	a, ok := files[filepath.Join("new", "a.txt")]
+	if !ok || a.Hash != "00112233445566aa" || a.Size != 10 || !a.ModTime.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Unexpected entry for new/a.txt: %+v", a)
+	}
+	if b, ok := files["b.txt"]; !ok || b.Size != 0 || !b.ModTime.IsZero() {
+		t.Errorf("Unexpected entry for b.txt: %+v", b)
+	}
+
+	sha := strings.Repeat("ab", 32)
+	if _, err := ReadAdditions(strings.NewReader("c.txt,"+sha+",1\n"), "add.csv", "sha256"); err != nil {
+		t.Errorf("Expected a sha256 digest to be accepted: %v", err)
+	}
+	if _, err := ReadAdditions(strings.NewReader("c.txt,"+sha+",1\n"), "add.csv", "hmac-sha256"); err != nil {
+		t.Errorf("Expected an hmac-sha256 digest to be accepted: %v", err)
+	}
+}
+
+func TestReadAdditions_Invalid(t *testing.T) {
+	for _, tc := range []struct {
+		name, csv, algorithm, want string
+	}{
+		{"not hex", "path,hash,size\na.txt,0011223344556677,1\nb.txt,zz11223344556677,1\n", "xxh64", "add.csv:3: hash"},
+		{"wrong length", "a.txt,00112233,1\n", "xxh64", "add.csv:1: hash"},
+		{"xxh64 digest in a sha256 tree", "a.txt,0011223344556677,1\n", "sha256", "add.csv:1: hash"},
+		{"absolute path", "/etc/passwd,0011223344556677,1\n", "xxh64", "add.csv:1: path"},
+		{"path leaving the root", "a.txt,0011223344556677,1\n../b.txt,0011223344556677,1\n", "xxh64", "add.csv:2: path"},
+		{"empty path", ",0011223344556677,1\n", "xxh64", "add.csv:1: path"},
+		{"negative size", "a.txt,0011223344556677,-1\n", "xxh64", "add.csv:1: negative size"},
+		{"invalid size", "a.txt,0011223344556677,ten\n", "xxh64", "add.csv:1: invalid size"},
+		{"invalid mtime", "a.txt,0011223344556677,1,yesterday\n", "xxh64", "add.csv:1: invalid mtime"},
+		{"missing fields", "a.txt,0011223344556677\n", "xxh64", "add.csv:1: expected"},
+	} {
+		_, err := ReadAdditions(strings.NewReader(tc.csv), "add.csv", tc.algorithm)
+		if err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error starting with %q, got %v", tc.name, tc.want, err)
+		}
+	}
+}
@@ -6,10 +6,64 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
+	"golang.org/x/text/unicode/norm"
+
+	"merkle-go/internal/config"
 	"merkle-go/internal/hash"
 )
 
+// BuildOptions tunes how Build lays out the tree
+type BuildOptions struct {
+	// Portable makes the tree independent of the platform that scanned it:
+	// leaf paths are normalized to Unicode NFC and ordered byte-wise by their
+	// forward-slash relative path, and modification times are left out. The
+	// same content then yields the same manifest and root hash on macOS,
+	// Linux and Windows. Files is keyed by the normalized paths.
+	Portable bool
+
+	// BytewiseOrder orders leaves byte-wise by their forward-slash relative
+	// path, the order portable trees use, without the rest of Portable.
+	// Native paths sort the same way on Unix; on Windows, where the
+	// separator is a backslash, the orders can differ. Byte-wise order does
+	// not depend on locale or collation, so other tools can reproduce it.
+	BytewiseOrder bool
+
+	// HashAlgorithm records the algorithm the file hashes were computed
+	// with; empty means xxh64
+	HashAlgorithm string
+
+	// EmptyDirs records that files include markers for empty directories
+	// (FileData.Dir), so that later scans track them too
+	EmptyDirs bool
+
+	// Archives lists the archive formats (see package archive) whose
+	// members were recorded as leaves, so that later scans descend into
+	// the same archives
+	Archives []string
+
+	// SegmentSize records that files larger than it were hashed as a Merkle
+	// tree of segments of this size (see hash.ReadOptions.SegmentSize)
+	SegmentSize int64
+
+	// ChunkSize records the average chunk size the FileData.Chunks were
+	// cut with; see MerkleTree.ChunkSize
+	ChunkSize int
+
+	// CIDs records that the FileData.CID of every file is set; see
+	// MerkleTree.CIDs
+	CIDs bool
+
+	// DigestAlgorithms records the algorithms of the FileData.Digests of
+	// every file; see MerkleTree.DigestAlgorithms
+	DigestAlgorithms []string
+
+	// Fingerprint records the rules of the files with FileData.Sampled set;
+	// see MerkleTree.Fingerprint
+	Fingerprint []config.FingerprintRule
+}
+
 // Build creates a true Merkle tree from file hashes
 // Following the classic algorithm:
 // 1. Sort files alphabetically by path
@@ -17,6 +71,11 @@ import (
 // 3. Pair adjacent nodes and hash them to create parent level
 // 4. Repeat until single root hash
 func Build(files map[string]FileData, rootPath string) (*MerkleTree, error) {
+	return BuildWithOptions(files, rootPath, BuildOptions{})
+}
+
+// BuildWithOptions is Build with options
+func BuildWithOptions(files map[string]FileData, rootPath string, opts BuildOptions) (*MerkleTree, error) {
 	// Handle empty files case
 	if len(files) == 0 {
 		emptyData := []byte("empty-tree")
@@ -28,18 +87,41 @@ func Build(files map[string]FileData, rootPath string) (*MerkleTree, error) {
 			Root: &Node{
 				Hash: hex.EncodeToString(rootHash),
 			},
-			RootPath:  rootPath,
-			TotalSize: 0,
-			Files:     make(map[string]FileData),
+			RootPath:    rootPath,
+			TotalSize:   0,
+			Files:       make(map[string]FileData),
+			Directories: make(map[string]string),
+			Portable:    opts.Portable,
+
+			BytewiseOrder: opts.BytewiseOrder,
+			HashAlgorithm: opts.HashAlgorithm,
+			EmptyDirs:     opts.EmptyDirs,
+			Archives:      opts.Archives,
+			SegmentSize:   opts.SegmentSize,
+			ChunkSize:     opts.ChunkSize,
+			CIDs:          opts.CIDs,
+			Fingerprint:   opts.Fingerprint,
+
+			DigestAlgorithms: opts.DigestAlgorithms,
 		}, nil
 	}
 
+	if opts.Portable {
+		files = portableFiles(files, rootPath)
+	}
+
 	// Sort paths alphabetically for deterministic ordering
 	paths := make([]string, 0, len(files))
 	for path := range files {
 		paths = append(paths, path)
 	}
-	sort.Strings(paths)
+	if opts.Portable || opts.BytewiseOrder {
+		sort.Slice(paths, func(i, j int) bool {
+			return filepath.ToSlash(paths[i]) < filepath.ToSlash(paths[j])
+		})
+	} else {
+		sort.Strings(paths)
+	}
 
 	// Calculate total size
 	var totalSize int64
@@ -55,80 +137,119 @@ func Build(files map[string]FileData, rootPath string) (*MerkleTree, error) {
 	for _, path := range paths {
 		fileData := files[path]
 
-		// Compute relative path
-		relativePath := path
-		cleanPath := filepath.Clean(path)
-		if strings.HasPrefix(cleanPath, cleanRoot+string(filepath.Separator)) {
-			relativePath = strings.TrimPrefix(cleanPath, cleanRoot+string(filepath.Separator))
-		} else if cleanPath == cleanRoot {
-			relativePath = filepath.Base(cleanPath)
-		}
-
-		// Use file content hash directly as the leaf node hash
+		// Use file content hash directly as the leaf node hash. Paths are
+		// stored with forward slashes so manifests are portable across platforms.
 		node := &Node{
-			Hash:  fileData.Hash,
-			Path:  relativePath,
-			Size:  fileData.Size,
-			MTime: fileData.ModTime.Unix(),
+			Hash: fileData.Hash,
+			Path: filepath.ToSlash(relativePath(cleanRoot, path)),
+			Size: fileData.Size,
+			Dir:  fileData.Dir,
+		}
+		if !fileData.ModTime.IsZero() && !opts.Portable {
+			node.MTime = fileData.ModTime.Unix()
+		}
+		if fileData.Sparse && !opts.Portable {
+			node.Allocated = &fileData.Allocated
 		}
+		node.Chunks = fileData.Chunks
+		node.CID = fileData.CID
+		node.Digests = fileData.Digests
+		node.Tags = fileData.Tags
+		node.Virtual = fileData.Virtual
+		node.Sampled = fileData.Sampled
 		currentLevel = append(currentLevel, node)
 	}
 
-	// Build tree by repeatedly pairing and hashing adjacent nodes
+	root, err := buildLevels(currentLevel)
+	if err != nil {
+		return nil, err
+	}
+	directories, err := directoryHashes(currentLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MerkleTree{
+		Root:        root,
+		RootPath:    rootPath,
+		TotalSize:   totalSize,
+		Files:       files,
+		Directories: directories,
+		Portable:    opts.Portable,
+
+		BytewiseOrder: opts.BytewiseOrder,
+		HashAlgorithm: opts.HashAlgorithm,
+		EmptyDirs:     opts.EmptyDirs,
+		Archives:      opts.Archives,
+		SegmentSize:   opts.SegmentSize,
+		ChunkSize:     opts.ChunkSize,
+		CIDs:          opts.CIDs,
+		Fingerprint:   opts.Fingerprint,
+
+		DigestAlgorithms: opts.DigestAlgorithms,
+	}, nil
+}
+
+// relativePath returns the path of a file relative to the cleaned root path.
+// A path outside the root is kept as is.
+func relativePath(cleanRoot, path string) string {
+	cleanPath := filepath.Clean(path)
+	if strings.HasPrefix(cleanPath, cleanRoot+string(filepath.Separator)) {
+		return strings.TrimPrefix(cleanPath, cleanRoot+string(filepath.Separator))
+	} else if cleanPath == cleanRoot {
+		return filepath.Base(cleanPath)
+	}
+	return path
+}
+
+// portableFiles rekeys files by their NFC-normalized path under rootPath and
+// drops modification times
+func portableFiles(files map[string]FileData, rootPath string) map[string]FileData {
+	normalized := make(map[string]FileData, len(files))
+	for path, data := range files {
+		relPath, err := filepath.Rel(rootPath, path)
+		if err != nil {
+			relPath = path
+		}
+		data.ModTime = time.Time{}
+		normalized[filepath.Join(rootPath, norm.NFC.String(relPath))] = data
+	}
+	return normalized
+}
+
+// buildLevels builds the tree above a sorted, non-empty leaf level by
+// repeatedly pairing and hashing adjacent nodes, and returns the root
+func buildLevels(currentLevel []*Node) (*Node, error) {
 	for len(currentLevel) > 1 {
 		nextLevel := make([]*Node, 0, (len(currentLevel)+1)/2)
 
 		// Process pairs of nodes
 		for i := 0; i < len(currentLevel); i += 2 {
-			var parentNode *Node
-
+			leftNode := currentLevel[i]
+			rightNode := leftNode // Odd node: duplicate it
 			if i+1 < len(currentLevel) {
-				// Create parent from pair of nodes
-				leftNode := currentLevel[i]
-				rightNode := currentLevel[i+1]
-
-				// Hash the pair
-				leftHashBytes, _ := hex.DecodeString(leftNode.Hash)
-				rightHashBytes, _ := hex.DecodeString(rightNode.Hash)
-				combined := append(leftHashBytes, rightHashBytes...)
-				parentHash, err := hash.XXHashFunc(combined)
-				if err != nil {
-					return nil, fmt.Errorf("failed to hash parent node: %w", err)
-				}
-
-				parentNode = &Node{
-					Hash:  hex.EncodeToString(parentHash),
-					Left:  leftNode,
-					Right: rightNode,
-				}
-			} else {
-				// Odd node: duplicate it
-				node := currentLevel[i]
-				hashBytes, _ := hex.DecodeString(node.Hash)
-				combined := append(hashBytes, hashBytes...)
-				parentHash, err := hash.XXHashFunc(combined)
-				if err != nil {
-					return nil, fmt.Errorf("failed to hash parent node: %w", err)
-				}
-
-				parentNode = &Node{
-					Hash:  hex.EncodeToString(parentHash),
-					Left:  node,
-					Right: node,
-				}
+				rightNode = currentLevel[i+1]
 			}
 
-			nextLevel = append(nextLevel, parentNode)
+			// Hash the pair
+			leftHashBytes, _ := hex.DecodeString(leftNode.Hash)
+			rightHashBytes, _ := hex.DecodeString(rightNode.Hash)
+			combined := append(leftHashBytes, rightHashBytes...)
+			parentHash, err := hash.XXHashFunc(combined)
+			if err != nil {
+				return nil, fmt.Errorf("failed to hash parent node: %w", err)
+			}
+
+			nextLevel = append(nextLevel, &Node{
+				Hash:  hex.EncodeToString(parentHash),
+				Left:  leftNode,
+				Right: rightNode,
+			})
 		}
 
 		currentLevel = nextLevel
 	}
 
 	// The last remaining node is the root
-	return &MerkleTree{
-		Root:      currentLevel[0],
-		RootPath:  rootPath,
-		TotalSize: totalSize,
-		Files:     files,
-	}, nil
+	return currentLevel[0], nil
 }
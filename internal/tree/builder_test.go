@@ -2,6 +2,7 @@ package tree
 
 import (
 	"testing"
+	"time"
 )
 
 func TestBuild_EmptyFiles(t *testing.T) {
@@ -104,3 +105,40 @@ func TestBuild_DifferentInputsDifferentHash(t *testing.T) {
 		t.Error("Different inputs should produce different root hashes")
 	}
 }
+
+func TestBuild_PortableIgnoresUnicodeFormAndMtime(t *testing.T) {
+	// The same two files as listed on macOS (NFD) and Linux (NFC). The
+	// decomposed name sorts before "cafz", the composed one after it.
+	macOS := map[string]FileData{
+		"/data/cafe\u0301.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 1, ModTime: time.Unix(1700000000, 0)},
+		"/data/cafz.txt":       {Hash: "bbbbbbbbbbbbbbbb", Size: 2, ModTime: time.Unix(1700000000, 0)},
+	}
+	linux := map[string]FileData{
+		"/data/caf\u00e9.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 1, ModTime: time.Unix(1800000000, 0)},
+		"/data/cafz.txt":      {Hash: "bbbbbbbbbbbbbbbb", Size: 2, ModTime: time.Unix(1800000000, 0)},
+	}
+
+	plainMac, _ := Build(macOS, "/data")
+	plainLinux, _ := Build(linux, "/data")
+	if plainMac.Root.Hash == plainLinux.Root.Hash {
+		t.Fatal("Expected the default layout to depend on the Unicode form")
+	}
+
+	portableMac, err := BuildWithOptions(macOS, "/data", BuildOptions{Portable: true})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	portableLinux, err := BuildWithOptions(linux, "/data", BuildOptions{Portable: true})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if portableMac.Root.Hash != portableLinux.Root.Hash {
+		t.Errorf("Expected identical portable roots, got %s and %s", portableMac.Root.Hash, portableLinux.Root.Hash)
+	}
+	if leaf := portableMac.Root.Right; leaf.Path != "caf\u00e9.txt" || leaf.MTime != 0 {
+		t.Errorf("Expected NFC leaf path without mtime, got %q (mtime %d)", leaf.Path, leaf.MTime)
+	}
+	if _, ok := portableMac.Files["/data/caf\u00e9.txt"]; !ok {
+		t.Error("Expected Files to be keyed by the normalized path")
+	}
+}
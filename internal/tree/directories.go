@@ -0,0 +1,79 @@
+package tree
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// directoryHashes computes the subtree root hash of every directory that
+// contains files, keyed by its slash-separated path relative to the tree
+// root. A directory's hash is the root of a merkle tree built the same way as
+// the full tree from just the leaves under it, so it only changes when
+// something under the directory changes. leaves must be sorted by path.
+func directoryHashes(leaves []*Node) (map[string]string, error) {
+	// Leaves under a directory share the "dir/" prefix, so they are
+	// contiguous in sorted order
+	ranges := make(map[string][2]int)
+	for i, leaf := range leaves {
+		for dir := path.Dir(leaf.Path); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			r, seen := ranges[dir]
+			if !seen {
+				r[0] = i
+			}
+			r[1] = i + 1
+			ranges[dir] = r
+		}
+	}
+
+	directories := make(map[string]string, len(ranges))
+	for dir, r := range ranges {
+		root, err := buildLevels(leaves[r[0]:r[1]])
+		if err != nil {
+			return nil, err
+		}
+		directories[dir] = root.Hash
+	}
+	return directories, nil
+}
+
+// leavesOf returns the distinct leaves of the tree under node in tree order.
+// An odd node duplicated while building appears only once.
+func leavesOf(node *Node) []*Node {
+	seen := make(map[string]bool)
+	leaves := make([]*Node, 0)
+	var collect func(*Node)
+	collect = func(n *Node) {
+		if n == nil {
+			return
+		}
+		if n.Path != "" {
+			if !seen[n.Path] {
+				seen[n.Path] = true
+				leaves = append(leaves, n)
+			}
+			return
+		}
+		collect(n.Left)
+		collect(n.Right)
+	}
+	collect(node)
+	return leaves
+}
+
+// SubtreeHash returns the hash of the directory or file at relPath, given
+// with forward slashes relative to the tree root. An empty path or "."
+// returns the root hash.
+func (t *MerkleTree) SubtreeHash(relPath string) (string, bool) {
+	relPath = strings.Trim(path.Clean("/"+relPath), "/")
+	if relPath == "" {
+		return t.Root.Hash, true
+	}
+	if hash, ok := t.Directories[relPath]; ok {
+		return hash, true
+	}
+	if fileData, ok := t.Files[filepath.Join(t.RootPath, filepath.FromSlash(relPath))]; ok {
+		return fileData.Hash, true
+	}
+	return "", false
+}
@@ -0,0 +1,89 @@
+package tree
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBuild_DirectoryHashes(t *testing.T) {
+	files := map[string]FileData{
+		"/data/a.txt":             {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+		"/data/projects/x/1.txt":  {Hash: "bbbbbbbbbbbbbbbb", Size: 2},
+		"/data/projects/x/2.txt":  {Hash: "cccccccccccccccc", Size: 3},
+		"/data/projects/y/1.txt":  {Hash: "dddddddddddddddd", Size: 4},
+		"/data/projects.txt":      {Hash: "eeeeeeeeeeeeeeee", Size: 5},
+		"/data/projects/x/sub/3":  {Hash: "ffffffffffffffff", Size: 6},
+		"/data/projects/x.backup": {Hash: "1111111111111111", Size: 7},
+	}
+
+	tree, err := Build(files, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	for _, dir := range []string{"projects", "projects/x", "projects/x/sub", "projects/y"} {
+		// A directory's hash is the root of a tree built from just its files
+		subset := make(map[string]FileData)
+		prefix := "/data/" + dir + "/"
+		for path, data := range files {
+			if len(path) > len(prefix) && path[:len(prefix)] == prefix {
+				subset[path] = data
+			}
+		}
+		expected, err := Build(subset, "/data/"+dir)
+		if err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+
+		got, ok := tree.SubtreeHash(dir)
+		if !ok {
+			t.Fatalf("Expected a hash for %s", dir)
+		}
+		if got != expected.Root.Hash {
+			t.Errorf("Expected %s hash %s, got %s", dir, expected.Root.Hash, got)
+		}
+	}
+
+	if len(tree.Directories) != 4 {
+		t.Errorf("Expected 4 directories, got %v", tree.Directories)
+	}
+	if hash, _ := tree.SubtreeHash("."); hash != tree.Root.Hash {
+		t.Errorf("Expected root hash for \".\", got %s", hash)
+	}
+	if hash, _ := tree.SubtreeHash("projects/x/2.txt"); hash != "cccccccccccccccc" {
+		t.Errorf("Expected file hash, got %s", hash)
+	}
+	if _, ok := tree.SubtreeHash("missing"); ok {
+		t.Error("Expected no hash for a path that is not in the tree")
+	}
+}
+
+func TestLoad_DerivesDirectoryHashes(t *testing.T) {
+	files := map[string]FileData{
+		"/data/a/1.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+		"/data/a/2.txt": {Hash: "bbbbbbbbbbbbbbbb", Size: 2},
+		"/data/b/3.txt": {Hash: "cccccccccccccccc", Size: 3},
+	}
+	tree, err := Build(files, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	// Simulate a manifest written before directory hashes existed
+	built := tree.Directories
+	tree.Directories = nil
+	path := filepath.Join(t.TempDir(), "tree.json")
+	if err := Save(tree, path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	for dir, hash := range built {
+		if loaded.Directories[dir] != hash {
+			t.Errorf("Expected %s hash %s, got %s", dir, hash, loaded.Directories[dir])
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package tree
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"merkle-go/internal/pathmatch"
+)
+
+// Query selects files of a tree. The zero Query matches every file.
+type Query struct {
+	// Hash matches files with this content hash (any case) or, in trees
+	// that record them, this IPFS CID
+	Hash string
+
+	// PathGlobs match files whose path relative to the root matches any
+	// of them (see pathmatch.Match), e.g. "**/*.conf"
+	PathGlobs []string
+}
+
+// Validate returns an error if any of the path globs is malformed
+func (q Query) Validate() error {
+	for _, pattern := range q.PathGlobs {
+		if err := pathmatch.Validate(pattern); err != nil {
+			return fmt.Errorf("invalid path glob %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Match is a file of a tree found by Find
+type Match struct {
+	Path string // relative to the root, with forward slashes
+	Data FileData
+}
+
+// Find returns the files of t matching q, sorted by path. Markers of empty
+// directories are not files and never match.
+func Find(t *MerkleTree, q Query) []Match {
+	matches := make([]Match, 0)
+	cleanRoot := filepath.Clean(t.RootPath)
+	for path, data := range t.Files {
+		if data.Dir {
+			continue
+		}
+		if q.Hash != "" && !strings.EqualFold(data.Hash, q.Hash) && (data.CID == "" || data.CID != q.Hash) {
+			continue
+		}
+		relPath := filepath.ToSlash(relativePath(cleanRoot, path))
+		if len(q.PathGlobs) > 0 && !pathmatch.MatchAny(q.PathGlobs, relPath) {
+			continue
+		}
+		matches = append(matches, Match{Path: relPath, Data: data})
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Path < matches[j].Path })
+	return matches
+}
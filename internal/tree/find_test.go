@@ -0,0 +1,55 @@
+package tree
+
+import (
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	built, err := BuildWithOptions(map[string]FileData{
+		"/srv/etc/app.conf":       {Hash: "aaaaaaaaaaaaaaaa", Size: 10},
+		"/srv/etc/nginx/ssl.conf": {Hash: "bbbbbbbbbbbbbbbb", Size: 20},
+		"/srv/backup/app.conf":    {Hash: "aaaaaaaaaaaaaaaa", Size: 10, CID: "bafkreiexample"},
+		"/srv/readme.txt":         {Hash: "cccccccccccccccc", Size: 30},
+		"/srv/empty":              {Hash: EmptyDirHash, Dir: true},
+	}, "/srv", BuildOptions{EmptyDirs: true})
+	if err != nil {
+		t.Fatalf("BuildWithOptions failed: %v", err)
+	}
+
+	paths := func(matches []Match) []string {
+		var paths []string
+		for _, match := range matches {
+			paths = append(paths, match.Path)
+		}
+		return paths
+	}
+	for _, tt := range []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"hash", Query{Hash: "AAAAAAAAAAAAAAAA"}, []string{"backup/app.conf", "etc/app.conf"}},
+		{"cid", Query{Hash: "bafkreiexample"}, []string{"backup/app.conf"}},
+		{"glob", Query{PathGlobs: []string{"**/*.conf"}}, []string{"backup/app.conf", "etc/app.conf", "etc/nginx/ssl.conf"}},
+		{"hash and glob", Query{Hash: "aaaaaaaaaaaaaaaa", PathGlobs: []string{"etc/**"}}, []string{"etc/app.conf"}},
+		{"any glob", Query{PathGlobs: []string{"*.txt", "nginx/*"}}, []string{"readme.txt"}},
+		{"no match", Query{Hash: "dddddddddddddddd"}, nil},
+		{"everything but markers", Query{}, []string{"backup/app.conf", "etc/app.conf", "etc/nginx/ssl.conf", "readme.txt"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := paths(Find(built, tt.query))
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Expected %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+
+	if err := (Query{PathGlobs: []string{"[unclosed"}}).Validate(); err == nil {
+		t.Error("Expected a malformed glob to be rejected")
+	}
+}
@@ -0,0 +1,136 @@
+package tree
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Snapshot is one tree of a series, named after the manifest or patch it
+// was loaded from
+type Snapshot struct {
+	Name string
+	Tree *MerkleTree
+}
+
+// File states reported by FileHistory
+const (
+	HistoryAdded     = "added"
+	HistoryModified  = "modified"
+	HistoryDeleted   = "deleted"
+	HistoryUnchanged = "unchanged"
+	HistoryAbsent    = "absent"
+)
+
+// HistoryEntry is the state of a file in one snapshot
+type HistoryEntry struct {
+	Snapshot string
+	Created  time.Time
+	RootHash string
+	Status   string   // one of the History* states, relative to the previous snapshot
+	Data     FileData // zero unless the file is present
+}
+
+// Present reports whether the file exists in the snapshot
+func (e HistoryEntry) Present() bool {
+	return e.Status != HistoryDeleted && e.Status != HistoryAbsent
+}
+
+// SortSnapshots orders snapshots by creation time, then by name
+func SortSnapshots(snapshots []Snapshot) {
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		a, b := snapshots[i].Tree.Created, snapshots[j].Tree.Created
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return snapshots[i].Name < snapshots[j].Name
+	})
+}
+
+// FileHistory follows the file at relPath, relative to each snapshot's root
+// with forward slashes, through snapshots in the given order. It returns
+// the snapshots in which the file was added, modified or deleted; with all
+// set, every snapshot is returned, including those where the file was
+// unchanged or absent. A file counts as modified when its hash or size
+// differs from the previous snapshot it was present in.
+func FileHistory(snapshots []Snapshot, relPath string, all bool) []HistoryEntry {
+	relPath = filepath.ToSlash(filepath.Clean(filepath.FromSlash(relPath)))
+	var entries []HistoryEntry
+	var previous *FileData
+	for _, snapshot := range snapshots {
+		t := snapshot.Tree
+		entry := HistoryEntry{Snapshot: snapshot.Name, Created: t.Created}
+		if t.Root != nil {
+			entry.RootHash = t.Root.Hash
+		}
+		data, ok := t.Files[filepath.Join(t.RootPath, filepath.FromSlash(relPath))]
+		if ok && data.Dir {
+			ok = false
+		}
+		switch {
+		case ok && previous == nil:
+			entry.Status = HistoryAdded
+		case ok && (data.Hash != previous.Hash || data.Size != previous.Size):
+			entry.Status = HistoryModified
+		case ok:
+			entry.Status = HistoryUnchanged
+		case previous != nil:
+			entry.Status = HistoryDeleted
+		default:
+			entry.Status = HistoryAbsent
+		}
+		if ok {
+			entry.Data = data
+			previous = &data
+		} else {
+			previous = nil
+		}
+		if all || (entry.Status != HistoryUnchanged && entry.Status != HistoryAbsent) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// ResolvePatches applies each patch to the snapshot (or previously applied
+// patch) whose root hash is the patch's base, so a history stored as one
+// full manifest followed by patches can be read back as snapshots. The
+// resolved snapshots are appended to snapshots; patches whose base is never
+// found are returned by name in unresolved.
+func ResolvePatches(snapshots []Snapshot, patches map[string]*Patch) ([]Snapshot, []string, error) {
+	byRoot := make(map[string]*MerkleTree)
+	for _, snapshot := range snapshots {
+		if snapshot.Tree.Root != nil {
+			byRoot[snapshot.Tree.Root.Hash] = snapshot.Tree
+		}
+	}
+
+	names := make([]string, 0, len(patches))
+	for name := range patches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for progress := true; progress; {
+		progress = false
+		remaining := names[:0]
+		for _, name := range names {
+			patch := patches[name]
+			base, ok := byRoot[patch.Base]
+			if !ok {
+				remaining = append(remaining, name)
+				continue
+			}
+			t, err := patch.Apply(base)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to apply %s: %w", name, err)
+			}
+			snapshots = append(snapshots, Snapshot{Name: name, Tree: t})
+			byRoot[patch.Target] = t
+			progress = true
+		}
+		names = remaining
+	}
+	return snapshots, names, nil
+}
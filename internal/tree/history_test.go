@@ -0,0 +1,92 @@
+package tree
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFileHistory(t *testing.T) {
+	build := func(created int64, files map[string]FileData) *MerkleTree {
+		built, err := Build(files, "/data")
+		if err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+		built.Created = time.Unix(created, 0)
+		return built
+	}
+	first := build(100, map[string]FileData{
+		"/data/a.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+		"/data/b.txt": {Hash: "bbbbbbbbbbbbbbbb", Size: 2},
+	})
+	second := build(200, map[string]FileData{
+		"/data/a.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+		"/data/b.txt": {Hash: "cccccccccccccccc", Size: 3},
+	})
+	third := build(300, map[string]FileData{
+		"/data/a.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+	})
+	fourth := build(400, map[string]FileData{
+		"/data/a.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+		"/data/b.txt": {Hash: "cccccccccccccccc", Size: 3},
+	})
+
+	// The history is stored as the first manifest followed by patches
+	snapshots := []Snapshot{{Name: "full.json", Tree: first}}
+	patches := map[string]*Patch{
+		"2.mpatch": Diff(first, second),
+		"3.mpatch": Diff(second, third),
+		"4.mpatch": Diff(third, fourth),
+		"x.mpatch": Diff(fourth, first),
+	}
+	patches["x.mpatch"].Base = "0000000000000000"
+	snapshots, unresolved, err := ResolvePatches(snapshots, patches)
+	if err != nil {
+		t.Fatalf("ResolvePatches failed: %v", err)
+	}
+	if len(unresolved) != 1 || unresolved[0] != "x.mpatch" {
+		t.Errorf("Expected x.mpatch to be unresolved, got %v", unresolved)
+	}
+	if len(snapshots) != 4 {
+		t.Fatalf("Expected 4 snapshots, got %d", len(snapshots))
+	}
+	SortSnapshots(snapshots)
+
+	statuses := func(entries []HistoryEntry) []string {
+		var statuses []string
+		for _, entry := range entries {
+			statuses = append(statuses, entry.Snapshot+" "+entry.Status)
+		}
+		return statuses
+	}
+	for _, tt := range []struct {
+		path string
+		all  bool
+		want []string
+	}{
+		{"b.txt", false, []string{"full.json added", "2.mpatch modified", "3.mpatch deleted", "4.mpatch added"}},
+		{"a.txt", false, []string{"full.json added"}},
+		{"./a.txt", true, []string{"full.json added", "2.mpatch unchanged", "3.mpatch unchanged", "4.mpatch unchanged"}},
+		{"missing.txt", false, nil},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			got := statuses(FileHistory(snapshots, tt.path, tt.all))
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Expected %v, got %v", tt.want, got)
+					break
+				}
+			}
+		})
+	}
+
+	entries := FileHistory(snapshots, "b.txt", false)
+	if entries[1].RootHash != second.Root.Hash || entries[1].Data.Size != 3 || !entries[1].Created.Equal(time.Unix(200, 0)) {
+		t.Errorf("Unexpected entry for the modification: %+v", entries[1])
+	}
+	if entries[2].Present() || entries[2].Data.Hash != "" {
+		t.Errorf("Expected the deletion to have no file data, got %+v", entries[2])
+	}
+}
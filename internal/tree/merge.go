@@ -0,0 +1,326 @@
+package tree
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"merkle-go/internal/config"
+)
+
+// Relocate returns a copy of t whose files live under rootPath instead of
+// t.RootPath. Leaf paths, directory hashes and the root hash are relative to
+// the root and so are unchanged.
+func Relocate(t *MerkleTree, rootPath string) (*MerkleTree, error) {
+	files := make(map[string]FileData, len(t.Files))
+	for path, data := range t.Files {
+		relPath, err := filepath.Rel(t.RootPath, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to relativize %s: %w", path, err)
+		}
+		files[filepath.Join(rootPath, relPath)] = data
+	}
+	var scanErrors []ScanError
+	for _, scanErr := range t.Errors {
+		relPath, err := filepath.Rel(t.RootPath, scanErr.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to relativize %s: %w", scanErr.Path, err)
+		}
+		scanErrors = append(scanErrors, ScanError{Path: filepath.Join(rootPath, relPath), Error: scanErr.Error})
+	}
+
+	relocated := *t
+	relocated.RootPath = rootPath
+	relocated.Files = files
+	relocated.Errors = scanErrors
+	return &relocated, nil
+}
+
+// PrefixMap moves the paths under From to the same place under To
+type PrefixMap struct {
+	From string
+	To   string
+}
+
+// ParsePrefixMap parses a mapping written as from=to
+func ParsePrefixMap(s string) (PrefixMap, error) {
+	from, to, ok := strings.Cut(s, "=")
+	if !ok || from == "" || to == "" {
+		return PrefixMap{}, fmt.Errorf("invalid prefix mapping %q (want from=to)", s)
+	}
+	return PrefixMap{From: filepath.Clean(from), To: filepath.Clean(to)}, nil
+}
+
+// MapPrefixes returns a copy of t whose root path, file paths and scan error
+// paths are rewritten by the mapping with the longest From containing them,
+// for example to read the files of a snapshot mounted elsewhere. Leaf paths,
+// directory hashes and the root hash are unchanged. Every mapping must apply
+// to at least one path, which catches typos.
+func MapPrefixes(t *MerkleTree, mappings []PrefixMap) (*MerkleTree, error) {
+	used := make([]bool, len(mappings))
+	mapPath := func(path string) string {
+		best := -1
+		for i, m := range mappings {
+			if isWithin(path, m.From) && (best < 0 || len(m.From) > len(mappings[best].From)) {
+				best = i
+			}
+		}
+		if best < 0 {
+			return path
+		}
+		used[best] = true
+		m := mappings[best]
+		rel, _ := filepath.Rel(m.From, path)
+		return filepath.Join(m.To, rel)
+	}
+
+	files := make(map[string]FileData, len(t.Files))
+	for path, data := range t.Files {
+		mapped := mapPath(path)
+		if _, exists := files[mapped]; exists {
+			return nil, fmt.Errorf("prefix mappings map two files to %s", mapped)
+		}
+		files[mapped] = data
+	}
+	var scanErrors []ScanError
+	for _, scanErr := range t.Errors {
+		scanErrors = append(scanErrors, ScanError{Path: mapPath(scanErr.Path), Error: scanErr.Error})
+	}
+	rootPath := mapPath(filepath.Clean(t.RootPath))
+	for i, m := range mappings {
+		if !used[i] {
+			return nil, fmt.Errorf("no path in the tree is under %s", m.From)
+		}
+	}
+
+	mapped := *t
+	mapped.RootPath = rootPath
+	mapped.Files = files
+	mapped.Errors = scanErrors
+	return &mapped, nil
+}
+
+// CommonRoot returns the deepest directory containing all the given paths
+func CommonRoot(paths []string) string {
+	if len(paths) == 0 {
+		return ""
+	}
+	common := filepath.Clean(paths[0])
+	for _, path := range paths[1:] {
+		path = filepath.Clean(path)
+		for !isWithin(path, common) {
+			parent := filepath.Dir(common)
+			if parent == common {
+				break
+			}
+			common = parent
+		}
+	}
+	return common
+}
+
+// isWithin reports whether path is dir or below it
+func isWithin(path, dir string) bool {
+	if path == dir {
+		return true
+	}
+	if !strings.HasSuffix(dir, string(filepath.Separator)) {
+		dir += string(filepath.Separator)
+	}
+	return strings.HasPrefix(path, dir)
+}
+
+// Merge combines trees into one rooted at rootPath, recomputing the internal
+// nodes. If rootPath is empty the deepest directory containing every tree's
+// root is used. Every file must lie under rootPath and no file may appear in
+// more than one tree.
+func Merge(trees []*MerkleTree, rootPath string) (*MerkleTree, error) {
+	if len(trees) == 0 {
+		return nil, fmt.Errorf("no trees to merge")
+	}
+	if rootPath == "" {
+		roots := make([]string, len(trees))
+		for i, t := range trees {
+			roots[i] = t.RootPath
+		}
+		rootPath = CommonRoot(roots)
+	}
+	rootPath = filepath.Clean(rootPath)
+
+	// Chunks cut with different sizes cannot be compared, so they are only
+	// kept if every tree recorded them the same way
+	chunkSize := trees[0].ChunkSize
+	for _, t := range trees {
+		if t.ChunkSize != chunkSize {
+			chunkSize = 0
+		}
+	}
+	// Likewise a merged tree only records CIDs if every file has one, and
+	// the other digests that every tree recorded
+	cids := true
+	digestAlgorithms := trees[0].DigestAlgorithms
+	for _, t := range trees {
+		cids = cids && t.CIDs
+		digestAlgorithms = slices.DeleteFunc(slices.Clone(digestAlgorithms), func(algorithm string) bool {
+			return !slices.Contains(t.DigestAlgorithms, algorithm)
+		})
+	}
+
+	files := make(map[string]FileData)
+	owner := make(map[string]string)
+	for _, t := range trees {
+		if t.Portable != trees[0].Portable {
+			return nil, fmt.Errorf("cannot merge portable and non-portable trees (%s, %s)", trees[0].RootPath, t.RootPath)
+		}
+		if t.BytewiseOrder != trees[0].BytewiseOrder {
+			return nil, fmt.Errorf("cannot merge byte-wise and natively ordered trees (%s, %s)", trees[0].RootPath, t.RootPath)
+		}
+		if t.LeafAlgorithm() != trees[0].LeafAlgorithm() {
+			return nil, fmt.Errorf("cannot merge trees hashed with %s and %s (%s, %s)",
+				trees[0].LeafAlgorithm(), t.LeafAlgorithm(), trees[0].RootPath, t.RootPath)
+		}
+		if t.SegmentSize != trees[0].SegmentSize {
+			return nil, fmt.Errorf("cannot merge trees hashed in different segment sizes (%s, %s)", trees[0].RootPath, t.RootPath)
+		}
+		if !slices.EqualFunc(t.Fingerprint, trees[0].Fingerprint, sameFingerprintRule) {
+			return nil, fmt.Errorf("cannot merge trees sampled with different rules (%s, %s)", trees[0].RootPath, t.RootPath)
+		}
+		if t.MetadataOnly != trees[0].MetadataOnly {
+			return nil, fmt.Errorf("cannot merge trees with and without content hashes (%s, %s)", trees[0].RootPath, t.RootPath)
+		}
+		for path, data := range t.Files {
+			if !isWithin(filepath.Clean(path), rootPath) || filepath.Clean(path) == rootPath {
+				return nil, fmt.Errorf("%s is outside the merged root %s", path, rootPath)
+			}
+			if other, exists := owner[path]; exists {
+				return nil, fmt.Errorf("%s appears in trees rooted at both %s and %s", path, other, t.RootPath)
+			}
+			owner[path] = t.RootPath
+			if chunkSize == 0 {
+				data.Chunks = nil
+			}
+			if !cids {
+				data.CID = ""
+			}
+			if len(data.Digests) > 0 {
+				digests := make(map[string]string, len(digestAlgorithms))
+				for _, algorithm := range digestAlgorithms {
+					digests[algorithm] = data.Digests[algorithm]
+				}
+				data.Digests = digests
+				if len(digests) == 0 {
+					data.Digests = nil
+				}
+			}
+			files[path] = data
+		}
+	}
+
+	emptyDirs := false
+	var archives []string
+	for _, t := range trees {
+		emptyDirs = emptyDirs || t.EmptyDirs
+		for _, kind := range t.Archives {
+			if !slices.Contains(archives, kind) {
+				archives = append(archives, kind)
+			}
+		}
+	}
+	merged, err := BuildWithOptions(files, rootPath, BuildOptions{
+		Portable:      trees[0].Portable,
+		BytewiseOrder: trees[0].BytewiseOrder,
+		HashAlgorithm: trees[0].HashAlgorithm,
+		EmptyDirs:     emptyDirs,
+		Archives:      archives,
+		SegmentSize:   trees[0].SegmentSize,
+		ChunkSize:     chunkSize,
+		CIDs:          cids,
+		Fingerprint:   trees[0].Fingerprint,
+
+		DigestAlgorithms: digestAlgorithms,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	merged.MetadataOnly = trees[0].MetadataOnly
+	for _, t := range trees {
+		merged.Errors = append(merged.Errors, t.Errors...)
+	}
+
+	// Keep the volume only if every tree agrees on it
+	merged.Volume = trees[0].Volume
+	for _, t := range trees[1:] {
+		if t.Volume == nil || merged.Volume == nil || *t.Volume != *merged.Volume {
+			merged.Volume = nil
+			break
+		}
+	}
+	return merged, nil
+}
+
+// sameFingerprintRule reports whether two fingerprint rules sample the same files
+// the same way
+func sameFingerprintRule(a, b config.FingerprintRule) bool {
+	return a.Bytes == b.Bytes && slices.Equal(a.Paths, b.Paths)
+}
+
+// AddFile returns a copy of t with data recorded at relPath, a relative path
+// under the root, replacing any file already there. The tree is rebuilt with
+// the options it was built with and keeps its volume, scan settings and
+// errors; the scan stats no longer describe it and are dropped.
+func AddFile(t *MerkleTree, relPath string, data FileData) (*MerkleTree, error) {
+	relPath = filepath.Clean(filepath.FromSlash(relPath))
+	if filepath.IsAbs(relPath) || relPath == "." || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("invalid path %q (want a relative path under the root)", relPath)
+	}
+	if t.SegmentSize > 0 {
+		return nil, fmt.Errorf("cannot add files to a tree hashed in segments")
+	}
+	if t.CIDs {
+		return nil, fmt.Errorf("cannot add files to a tree recording CIDs")
+	}
+	if t.MetadataOnly {
+		return nil, fmt.Errorf("cannot add files to a tree without content hashes")
+	}
+	if t.ChunkSize > 0 && data.Chunks == nil {
+		return nil, fmt.Errorf("cannot add files without chunks to a tree recording them")
+	}
+	for _, algorithm := range t.DigestAlgorithms {
+		if _, ok := data.Digests[algorithm]; !ok && !data.Dir {
+			return nil, fmt.Errorf("cannot add a file without a %s digest to a tree recording them", algorithm)
+		}
+	}
+
+	files := make(map[string]FileData, len(t.Files)+1)
+	for path, fileData := range t.Files {
+		files[path] = fileData
+	}
+	path := filepath.Join(t.RootPath, relPath)
+	if existing, ok := files[path]; ok && existing.Dir {
+		return nil, fmt.Errorf("%s is an empty directory in the tree", filepath.ToSlash(relPath))
+	}
+	files[path] = data
+
+	added, err := BuildWithOptions(files, t.RootPath, BuildOptions{
+		Portable:      t.Portable,
+		BytewiseOrder: t.BytewiseOrder,
+		HashAlgorithm: t.HashAlgorithm,
+		EmptyDirs:     t.EmptyDirs,
+		Archives:      t.Archives,
+		SegmentSize:   t.SegmentSize,
+		ChunkSize:     t.ChunkSize,
+		CIDs:          t.CIDs,
+		Fingerprint:   t.Fingerprint,
+
+		DigestAlgorithms: t.DigestAlgorithms,
+	})
+	if err != nil {
+		return nil, err
+	}
+	added.Volume = t.Volume
+	added.Scan = t.Scan
+	added.Errors = t.Errors
+	return added, nil
+}
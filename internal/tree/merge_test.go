@@ -0,0 +1,180 @@
+package tree
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	a, err := Build(map[string]FileData{
+		"/mnt/a/1.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+		"/mnt/a/2.txt": {Hash: "bbbbbbbbbbbbbbbb", Size: 2},
+	}, "/mnt/a")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	b, err := Build(map[string]FileData{
+		"/mnt/b/3.txt": {Hash: "cccccccccccccccc", Size: 3},
+	}, "/mnt/b")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	merged, err := Merge([]*MerkleTree{a, b}, "")
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if merged.RootPath != "/mnt" {
+		t.Errorf("Expected merged root /mnt, got %s", merged.RootPath)
+	}
+	if merged.TotalSize != 6 {
+		t.Errorf("Expected total size 6, got %d", merged.TotalSize)
+	}
+
+	// The merged tree is the same as scanning both mount points at once
+	scanned, err := Build(map[string]FileData{
+		"/mnt/a/1.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+		"/mnt/a/2.txt": {Hash: "bbbbbbbbbbbbbbbb", Size: 2},
+		"/mnt/b/3.txt": {Hash: "cccccccccccccccc", Size: 3},
+	}, "/mnt")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if merged.Root.Hash != scanned.Root.Hash {
+		t.Errorf("Expected root hash %s, got %s", scanned.Root.Hash, merged.Root.Hash)
+	}
+	if merged.Directories["a"] != a.Root.Hash {
+		t.Errorf("Expected subtree a to keep its root hash %s, got %s", a.Root.Hash, merged.Directories["a"])
+	}
+}
+
+func TestMerge_Overlapping(t *testing.T) {
+	a, _ := Build(map[string]FileData{"/data/1.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 1}}, "/data")
+	b, _ := Build(map[string]FileData{"/data/1.txt": {Hash: "bbbbbbbbbbbbbbbb", Size: 1}}, "/data")
+
+	if _, err := Merge([]*MerkleTree{a, b}, ""); err == nil {
+		t.Fatal("Expected an error merging trees with the same file")
+	}
+
+	// Remapping one tree under a different prefix resolves the overlap
+	hostB, err := Relocate(b, "/hosts/b/data")
+	if err != nil {
+		t.Fatalf("Relocate failed: %v", err)
+	}
+	hostA, err := Relocate(a, "/hosts/a/data")
+	if err != nil {
+		t.Fatalf("Relocate failed: %v", err)
+	}
+	merged, err := Merge([]*MerkleTree{hostA, hostB}, "")
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if merged.RootPath != "/hosts" || len(merged.Files) != 2 {
+		t.Errorf("Expected 2 files under /hosts, got %d under %s", len(merged.Files), merged.RootPath)
+	}
+}
+
+func TestMerge_MixedAlgorithms(t *testing.T) {
+	a, _ := Build(map[string]FileData{"/mnt/a/1.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 1}}, "/mnt/a")
+	b, _ := BuildWithOptions(map[string]FileData{"/mnt/b/2.txt": {Hash: "bb", Size: 1}}, "/mnt/b",
+		BuildOptions{HashAlgorithm: "md5"})
+
+	if _, err := Merge([]*MerkleTree{a, b}, ""); err == nil {
+		t.Fatal("Expected an error merging trees hashed with different algorithms")
+	}
+}
+
+func TestCommonRoot(t *testing.T) {
+	tests := []struct {
+		paths []string
+		want  string
+	}{
+		{[]string{"/mnt/a", "/mnt/b"}, "/mnt"},
+		{[]string{"/mnt/a", "/mnt/a/b"}, "/mnt/a"},
+		{[]string{"/mnt/ab", "/mnt/a"}, "/mnt"},
+		{[]string{"/srv", "/mnt"}, "/"},
+	}
+	for _, tt := range tests {
+		if got := CommonRoot(tt.paths); got != tt.want {
+			t.Errorf("CommonRoot(%v): expected %s, got %s", tt.paths, tt.want, got)
+		}
+	}
+}
+
+func TestMapPrefixes(t *testing.T) {
+	original, err := Build(map[string]FileData{
+		"/data/a.txt":         {Hash: "aaaa", Size: 1},
+		"/data/photos/b.jpg":  {Hash: "bbbb", Size: 2},
+		"/data/photosets/c":   {Hash: "cccc", Size: 3},
+		"/data/photos/x/d.md": {Hash: "dddd", Size: 4},
+	}, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	mappings := []PrefixMap{{From: "/data", To: "/mnt/snap/data"}, {From: "/data/photos", To: "/mnt/photos"}}
+	mapped, err := MapPrefixes(original, mappings)
+	if err != nil {
+		t.Fatalf("MapPrefixes failed: %v", err)
+	}
+	if mapped.RootPath != "/mnt/snap/data" || mapped.Root.Hash != original.Root.Hash {
+		t.Errorf("Expected root /mnt/snap/data with the same hash, got %s %s", mapped.RootPath, mapped.Root.Hash)
+	}
+	// The longest prefix wins, and only on whole path components
+	for _, path := range []string{"/mnt/snap/data/a.txt", "/mnt/photos/b.jpg", "/mnt/snap/data/photosets/c", "/mnt/photos/x/d.md"} {
+		if _, ok := mapped.Files[path]; !ok {
+			t.Errorf("Expected %s in the mapped tree, got %v", path, mapped.Files)
+		}
+	}
+	if _, ok := original.Files["/data/a.txt"]; !ok {
+		t.Error("MapPrefixes modified the original tree")
+	}
+
+	if _, err := MapPrefixes(original, []PrefixMap{{From: "/date", To: "/mnt"}}); err == nil {
+		t.Error("Expected an error for a mapping that matches nothing")
+	}
+	if _, err := ParsePrefixMap("/data"); err == nil {
+		t.Error("Expected an error for a mapping without =")
+	}
+}
+
+func TestAddFile(t *testing.T) {
+	base, err := Build(map[string]FileData{
+		"/data/a.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+	}, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	base.Errors = []ScanError{{Path: "/data/locked", Error: "permission denied"}}
+
+	dump := FileData{Hash: "dddddddddddddddd", Size: 4, Virtual: true}
+	added, err := AddFile(base, "dumps/db.sql", dump)
+	if err != nil {
+		t.Fatalf("AddFile failed: %v", err)
+	}
+	expected, err := Build(map[string]FileData{
+		"/data/a.txt":        {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+		"/data/dumps/db.sql": dump,
+	}, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if added.Root.Hash != expected.Root.Hash {
+		t.Errorf("Expected root hash %s, got %s", expected.Root.Hash, added.Root.Hash)
+	}
+	if !added.Files[filepath.Join("/data", "dumps", "db.sql")].Virtual {
+		t.Error("Expected the added file to stay virtual")
+	}
+	if len(added.Errors) != 1 {
+		t.Errorf("Expected the scan errors to be kept, got %v", added.Errors)
+	}
+	if len(base.Files) != 1 {
+		t.Error("AddFile should not modify the original tree")
+	}
+
+	for _, path := range []string{"", "..", "../etc/passwd", "/etc/passwd"} {
+		if _, err := AddFile(base, path, dump); err == nil {
+			t.Errorf("Expected an error adding %q", path)
+		}
+	}
+}
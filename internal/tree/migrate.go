@@ -0,0 +1,80 @@
+package tree
+
+import (
+	"fmt"
+	"strings"
+)
+
+// migrations upgrade a decoded manifest from the schema version they are
+// keyed by to the next one. Every format change that bumps SchemaVersion
+// must add an entry here, so Load can read every version from
+// MinSchemaVersion on.
+var migrations = map[int]func(*SerializedTree) error{
+	1: func(*SerializedTree) error { return nil }, // 2 only added the version fields
+	2: migrateSlashPaths,
+	3: migrateDirectories,
+}
+
+// Migrate upgrades a decoded manifest in place to SchemaVersion, one version
+// at a time. A missing schema_version is treated as 1.
+func Migrate(serialized *SerializedTree) error {
+	if serialized.SchemaVersion == 0 {
+		serialized.SchemaVersion = 1
+	}
+	if err := checkSchemaVersion(serialized.SchemaVersion, serialized.Version); err != nil {
+		return err
+	}
+	if serialized.Tree == nil {
+		return fmt.Errorf("manifest has no tree")
+	}
+
+	for serialized.SchemaVersion < SchemaVersion {
+		migrate, ok := migrations[serialized.SchemaVersion]
+		if !ok {
+			return fmt.Errorf("no migration from manifest schema version %d", serialized.SchemaVersion)
+		}
+		if err := migrate(serialized); err != nil {
+			return fmt.Errorf("failed to migrate manifest from schema version %d: %w", serialized.SchemaVersion, err)
+		}
+		serialized.SchemaVersion++
+	}
+	return nil
+}
+
+// migrateSlashPaths converts the backslash leaf paths that manifests written
+// on Windows stored before schema 3
+func migrateSlashPaths(serialized *SerializedTree) error {
+	if !isWindowsPath(serialized.Root) {
+		return nil
+	}
+	// Decoded trees hold separate copies of nodes that were paired with
+	// themselves, so visit every node rather than the distinct leaves
+	var convert func(*Node)
+	convert = func(node *Node) {
+		if node == nil {
+			return
+		}
+		node.Path = strings.ReplaceAll(node.Path, `\`, "/")
+		convert(node.Left)
+		convert(node.Right)
+	}
+	convert(serialized.Tree)
+	for i := range serialized.Errors {
+		serialized.Errors[i].Path = strings.ReplaceAll(serialized.Errors[i].Path, `\`, "/")
+	}
+	return nil
+}
+
+// migrateDirectories derives the per-directory subtree hashes added in
+// schema 4
+func migrateDirectories(serialized *SerializedTree) error {
+	if serialized.Directories != nil {
+		return nil
+	}
+	directories, err := directoryHashes(leavesOf(serialized.Tree))
+	if err != nil {
+		return err
+	}
+	serialized.Directories = directories
+	return nil
+}
@@ -0,0 +1,66 @@
+package tree
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMigrations_CoverEveryVersion(t *testing.T) {
+	for version := MinSchemaVersion; version < SchemaVersion; version++ {
+		if _, ok := migrations[version]; !ok {
+			t.Errorf("No migration from schema version %d", version)
+		}
+	}
+}
+
+func TestMigrate_LegacyWindowsManifest(t *testing.T) {
+	// Three leaves: the last one is paired with itself, so the decoded tree
+	// holds two copies of it
+	legacy := `{
+  "generator": "merkle-go",
+  "root": "C:\\data",
+  "tree": {"hash": "0000000000000000",
+    "left": {"hash": "1111111111111111",
+      "left": {"hash": "aaaaaaaaaaaaaaaa", "path": "a\\1.txt", "size": 1},
+      "right": {"hash": "bbbbbbbbbbbbbbbb", "path": "a\\2.txt", "size": 1}},
+    "right": {"hash": "2222222222222222",
+      "left": {"hash": "cccccccccccccccc", "path": "b\\3.txt", "size": 1},
+      "right": {"hash": "cccccccccccccccc", "path": "b\\3.txt", "size": 1}}}
+}`
+	var serialized SerializedTree
+	if err := json.Unmarshal([]byte(legacy), &serialized); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	if err := Migrate(&serialized); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if serialized.SchemaVersion != SchemaVersion {
+		t.Errorf("Expected schema version %d, got %d", SchemaVersion, serialized.SchemaVersion)
+	}
+
+	var check func(*Node)
+	check = func(node *Node) {
+		if node == nil {
+			return
+		}
+		if strings.Contains(node.Path, `\`) {
+			t.Errorf("Expected forward slashes, got %q", node.Path)
+		}
+		check(node.Left)
+		check(node.Right)
+	}
+	check(serialized.Tree)
+
+	if _, ok := serialized.Directories["a"]; !ok {
+		t.Errorf("Expected directory hashes to be derived, got %v", serialized.Directories)
+	}
+}
+
+func TestMigrate_NewerVersion(t *testing.T) {
+	serialized := SerializedTree{SchemaVersion: SchemaVersion + 1, Tree: &Node{Hash: "aaaaaaaaaaaaaaaa"}}
+	if err := Migrate(&serialized); err == nil {
+		t.Error("Expected an error for a manifest from a newer schema")
+	}
+}
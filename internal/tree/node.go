@@ -1,20 +1,99 @@
 package tree
 
-import "time"
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"merkle-go/internal/config"
+	"merkle-go/internal/fsinfo"
+	"merkle-go/internal/hash"
+)
 
 type FileData struct {
 	Hash    string
 	Size    int64
 	ModTime time.Time
+	Dir     bool // Marker for an empty directory; Hash is EmptyDirHash
+
+	// Sparse is set for files with holes; Allocated is the number of bytes
+	// they occupy on disk, while Size is their logical size
+	Sparse    bool
+	Allocated int64
+
+	// Chunks are the content-defined chunks of the file, if the tree
+	// records them (see MerkleTree.ChunkSize)
+	Chunks []Chunk
+
+	// CID is the file's IPFS content identifier, if the tree records them
+	// (see MerkleTree.CIDs)
+	CID string
+
+	// Digests are the file's digests with the tree's DigestAlgorithms,
+	// keyed by algorithm; Hash is its digest with HashAlgorithm
+	Digests map[string]string
+
+	// Tags are user-defined labels of the file, such as its owner or data
+	// classification. They are recorded but not hashed.
+	Tags map[string]string
+
+	// Virtual marks content that was piped in under a logical path, such as
+	// a database dump, rather than read from a file under the root, so
+	// rescans of the directory cannot verify it (see AddFile)
+	Virtual bool
+
+	// Sampled is set for files fingerprinted from a sample of their content
+	// rather than read whole: the bytes hashed from each end (see
+	// hash.HashFileSample and MerkleTree.Fingerprint)
+	Sampled int64
+}
+
+// Chunk is one content-defined chunk of a file: the xxh64 hash of its
+// content and its length
+type Chunk struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// ScanError records a path that was left out of the tree because it could
+// not be read
+type ScanError struct {
+	Path  string
+	Error string
 }
 
 type Node struct {
 	Hash  string `json:"hash"`
 	Left  *Node  `json:"left,omitempty"`
 	Right *Node  `json:"right,omitempty"`
-	Path  string `json:"path,omitempty"` // Only set for leaf nodes
-	Size  int64  `json:"size,omitempty"` // Only set for leaf nodes
+	Path  string `json:"path,omitempty"`  // Only set for leaf nodes
+	Size  int64  `json:"size,omitempty"`  // Only set for leaf nodes
 	MTime int64  `json:"mtime,omitempty"` // Only set for leaf nodes (Unix timestamp)
+	Dir   bool   `json:"dir,omitempty"`   // Leaf marking an empty directory
+
+	// Allocated is only set for sparse files: the bytes they occupy on disk
+	Allocated *int64 `json:"allocated,omitempty"`
+
+	// Chunks lists the file's content-defined chunks in order
+	Chunks []Chunk `json:"chunks,omitempty"`
+
+	// CID is the file's IPFS CIDv1 (see package unixfs)
+	CID string `json:"cid,omitempty"`
+
+	// Digests are the file's digests with other algorithms than the leaf
+	// hash's, keyed by algorithm; they are not part of Hash
+	Digests map[string]string `json:"digests,omitempty"`
+
+	// Tags are the file's user-defined labels; they are not part of Hash
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Virtual marks a leaf whose content was piped in, not read from disk
+	Virtual bool `json:"virtual,omitempty"`
+
+	// Sampled marks a leaf hashed from this many bytes at each end of the
+	// file and its size, not from all of its content
+	Sampled int64 `json:"sampled,omitempty"`
 }
 
 type MerkleTree struct {
@@ -22,4 +101,114 @@ type MerkleTree struct {
 	RootPath  string              // Absolute path of scanned directory
 	TotalSize int64               // Total size in bytes
 	Files     map[string]FileData // path -> FileData (kept for compatibility)
+	Volume    *fsinfo.Volume      // Filesystem the root was scanned from, if known
+
+	// Directories maps each directory's path relative to RootPath, with
+	// forward slashes, to the hash of the subtree of files under it
+	Directories map[string]string
+
+	// Portable is set for trees built with BuildOptions.Portable
+	Portable bool
+
+	// BytewiseOrder is set for trees built with BuildOptions.BytewiseOrder
+	BytewiseOrder bool
+
+	// EmptyDirs is set for trees that record empty directories as leaves
+	EmptyDirs bool
+
+	// Archives lists the archive formats descended into, if any
+	Archives []string
+
+	// SegmentSize is set for trees whose large files were hashed in
+	// segments of this many bytes; 0 means every file was hashed whole
+	SegmentSize int64
+
+	// ChunkSize is the average chunk size of trees that record the
+	// content-defined chunks of each file (FileData.Chunks); 0 means none
+	// are recorded. Chunk boundaries depend on it, so only trees with the
+	// same ChunkSize can be compared chunk by chunk.
+	ChunkSize int
+
+	// CIDs is set for trees that record the IPFS CID of each file
+	// (FileData.CID), as `ipfs add --cid-version 1` would compute it
+	CIDs bool
+
+	// Fingerprint lists the rules of the files that were fingerprinted from
+	// a sample of their content (FileData.Sampled), so that later scans
+	// sample the same files
+	Fingerprint []config.FingerprintRule
+
+	// HashAlgorithm is the algorithm of the leaf (file content) hashes; empty
+	// means xxh64. Internal nodes always use xxh64.
+	HashAlgorithm string
+
+	// DigestAlgorithms lists the algorithms, besides HashAlgorithm, that
+	// every file records a digest of (FileData.Digests), so that the tree
+	// can be compared with trees hashed with any of them
+	DigestAlgorithms []string
+
+	// MetadataOnly is set for trees imported from listings without content
+	// hashes, such as restic snapshots: each leaf hash is MetadataHash of
+	// the file's size and modification time, so only those can be compared
+	MetadataOnly bool
+
+	// Redacted is set for trees whose paths were replaced by hashes of
+	// their segments (see Redact): their hashes can be checked, but their
+	// leaves match no files
+	Redacted bool
+
+	// Scan is the settings the tree was scanned with, if they were recorded
+	Scan *ScanParams
+
+	// Stats is the timing breakdown of the scan, if it was recorded
+	Stats *ScanStats
+
+	// Errors lists the files and directories that could not be read while
+	// scanning, keyed by absolute path
+	Errors []ScanError
+
+	// Set by Load from the manifest header; Save keeps Created if non-zero
+	Created          time.Time
+	GeneratorVersion string
+	SchemaVersion    int
+}
+
+// EmptyDirHash is the leaf hash of an empty directory marker: the xxh64 of
+// "empty-directory". Directories carry no content, so every marker has the
+// same hash and only its path tells them apart.
+var EmptyDirHash = func() string {
+	digest, _ := hash.XXHashFunc([]byte("empty-directory"))
+	return hex.EncodeToString(digest)
+}()
+
+// MetadataHash returns the leaf hash standing in for the content hash of a
+// file of a MetadataOnly tree
+func MetadataHash(size int64, modTime time.Time) string {
+	digest, _ := hash.XXHashFunc(fmt.Appendf(nil, "metadata:%d:%d", size, modTime.Unix()))
+	return hex.EncodeToString(digest)
+}
+
+// LeafAlgorithm returns the algorithm of the tree's file content hashes
+func (t *MerkleTree) LeafAlgorithm() string {
+	if t.HashAlgorithm == "" {
+		return hash.XXH64
+	}
+	return t.HashAlgorithm
+}
+
+// Algorithms returns the algorithms the tree records file digests of: the
+// leaf algorithm, then its DigestAlgorithms
+func (t *MerkleTree) Algorithms() []string {
+	return append([]string{t.LeafAlgorithm()}, t.DigestAlgorithms...)
+}
+
+// Digest returns the digest of data, a file of the tree, with algorithm,
+// and whether the tree recorded it. Empty directory markers have no
+// content, so their hash stands for every algorithm.
+func (t *MerkleTree) Digest(data FileData, algorithm string) (string, bool) {
+	if data.Dir || strings.EqualFold(algorithm, t.LeafAlgorithm()) {
+		return data.Hash, true
+	}
+	digest, ok := data.Digests[strings.ToLower(algorithm)]
+	return digest, ok
 }
@@ -0,0 +1,43 @@
+package tree
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// leafPathCompare compares two forward-slash leaf paths in the order
+// BuildWithOptions lays out leaves: byte-wise if bytewise is set, else by
+// native path
+func leafPathCompare(a, b string, bytewise bool) int {
+	if bytewise {
+		return strings.Compare(a, b)
+	}
+	return strings.Compare(filepath.FromSlash(a), filepath.FromSlash(b))
+}
+
+// OrderError reports the first leaf of a tree that is not in canonical order
+type OrderError struct {
+	Previous string // path of the leaf before it
+	Path     string
+}
+
+func (e *OrderError) Error() string {
+	return fmt.Sprintf("%s is out of order after %s", e.Path, e.Previous)
+}
+
+// CheckOrder checks that the leaves of t are in the canonical order for
+// the tree: byte-wise by forward-slash path for portable and byte-wise
+// ordered trees, by native path otherwise. With bytewise set, byte-wise
+// order is checked whatever the tree records. It returns an *OrderError for
+// the first leaf out of order.
+func CheckOrder(t *MerkleTree, bytewise bool) error {
+	bytewise = bytewise || t.Portable || t.BytewiseOrder
+	leaves := leavesOf(t.Root)
+	for i := 1; i < len(leaves); i++ {
+		if leafPathCompare(leaves[i-1].Path, leaves[i].Path, bytewise) >= 0 {
+			return &OrderError{Previous: leaves[i-1].Path, Path: leaves[i].Path}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package tree
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuild_BytewiseOrder(t *testing.T) {
+	built, err := BuildWithOptions(map[string]FileData{
+		"/test/a/b.txt":   {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+		"/test/a-b/c.txt": {Hash: "bbbbbbbbbbbbbbbb", Size: 1},
+		"/test/Zeta.txt":  {Hash: "cccccccccccccccc", Size: 1},
+		"/test/été.txt":   {Hash: "dddddddddddddddd", Size: 1},
+	}, "/test", BuildOptions{BytewiseOrder: true})
+	if err != nil {
+		t.Fatalf("BuildWithOptions failed: %v", err)
+	}
+
+	var paths []string
+	for _, leaf := range leavesOf(built.Root) {
+		paths = append(paths, leaf.Path)
+	}
+	want := []string{"Zeta.txt", "a-b/c.txt", "a/b.txt", "été.txt"}
+	if len(paths) != len(want) {
+		t.Fatalf("Expected %d leaves, got %v", len(want), paths)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Fatalf("Expected leaves in byte-wise order %v, got %v", want, paths)
+		}
+	}
+	if err := CheckOrder(built, false); err != nil {
+		t.Errorf("CheckOrder failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tree.json")
+	if err := Save(built, path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !loaded.BytewiseOrder {
+		t.Error("Expected the tree to stay byte-wise ordered")
+	}
+}
+
+func TestCheckOrder(t *testing.T) {
+	// Leaves as a collating sort would order them: letter case and accents
+	// ignored
+	leaves := []*Node{
+		{Hash: "aaaaaaaaaaaaaaaa", Path: "apple.txt", Size: 1},
+		{Hash: "bbbbbbbbbbbbbbbb", Path: "Banana.txt", Size: 1},
+		{Hash: "cccccccccccccccc", Path: "éclair.txt", Size: 1},
+		{Hash: "dddddddddddddddd", Path: "fig.txt", Size: 1},
+	}
+	root, err := buildLevels(leaves)
+	if err != nil {
+		t.Fatalf("buildLevels failed: %v", err)
+	}
+	collated := &MerkleTree{Root: root, RootPath: "/test", BytewiseOrder: true}
+
+	err = CheckOrder(collated, false)
+	var orderErr *OrderError
+	if !errors.As(err, &orderErr) {
+		t.Fatalf("Expected an OrderError, got %v", err)
+	}
+	if orderErr.Previous != "apple.txt" || orderErr.Path != "Banana.txt" {
+		t.Errorf("Expected Banana.txt out of order after apple.txt, got %+v", orderErr)
+	}
+
+	// Forcing byte-wise order fails natively ordered trees that are not
+	// byte-wise ordered, and passes those that are
+	collated.BytewiseOrder = false
+	if err := CheckOrder(collated, true); err == nil {
+		t.Error("Expected byte-wise check to fail")
+	}
+	built, err := Build(map[string]FileData{
+		"/test/apple.txt":  {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+		"/test/Banana.txt": {Hash: "bbbbbbbbbbbbbbbb", Size: 1},
+	}, "/test")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if err := CheckOrder(built, true); err != nil {
+		t.Errorf("Expected a built tree to be in byte-wise order: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package tree
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+)
+
+// PatchFormat identifies the patch layout below
+const PatchFormat = "merkle-go-patch/1"
+
+// Patch operations
+const (
+	PatchSet    = "set"
+	PatchDelete = "delete"
+)
+
+// Patch turns one manifest into another, so a history of snapshots can be
+// kept as a full first manifest followed by deltas. It records the root
+// hashes of both trees, the header of the new manifest and the leaves that
+// were added, changed or removed; applying it rebuilds the tree and checks
+// the result against Target.
+type Patch struct {
+	Format string `json:"format"`
+	Base   string `json:"base"`   // root hash of the tree the patch applies to
+	Target string `json:"target"` // root hash of the tree it produces
+
+	// Header is the manifest header of the new tree. Its tree and
+	// directory hashes are left out; they are rebuilt from the leaves.
+	Header SerializedTree `json:"header"`
+
+	Changes []PatchChange `json:"changes"`
+}
+
+// PatchChange replaces the leaf at Path with Leaf (PatchSet), adding it if
+// needed, or removes it (PatchDelete)
+type PatchChange struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	Leaf *Node  `json:"leaf,omitempty"`
+}
+
+// Diff returns the patch that turns oldTree into newTree. Changes are
+// sorted by path.
+func Diff(oldTree, newTree *MerkleTree) *Patch {
+	oldLeaves := leafMap(oldTree.Root)
+	newLeaves := leafMap(newTree.Root)
+
+	changes := make([]PatchChange, 0)
+	for relPath, leaf := range newLeaves {
+		if old, ok := oldLeaves[relPath]; !ok || !sameLeaf(old, leaf) {
+			changes = append(changes, PatchChange{Op: PatchSet, Path: relPath, Leaf: leaf})
+		}
+	}
+	for relPath := range oldLeaves {
+		if _, ok := newLeaves[relPath]; !ok {
+			changes = append(changes, PatchChange{Op: PatchDelete, Path: relPath})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+
+	header := serialize(newTree)
+	header.Directories = nil
+	return &Patch{
+		Format:  PatchFormat,
+		Base:    oldTree.Root.Hash,
+		Target:  newTree.Root.Hash,
+		Header:  header,
+		Changes: changes,
+	}
+}
+
+// Apply returns the tree produced by applying patch to base. It fails if
+// base is not the tree the patch was made from, or if the result does not
+// have the patch's target root hash.
+func (p *Patch) Apply(base *MerkleTree) (*MerkleTree, error) {
+	if p.Format != PatchFormat {
+		return nil, fmt.Errorf("unsupported patch format %q", p.Format)
+	}
+	if base.Root.Hash != p.Base {
+		return nil, fmt.Errorf("patch applies to root %s, tree has root %s", p.Base, base.Root.Hash)
+	}
+
+	leaves := leafMap(base.Root)
+	for _, change := range p.Changes {
+		switch change.Op {
+		case PatchSet:
+			if change.Leaf == nil || change.Leaf.Path != change.Path {
+				return nil, fmt.Errorf("invalid leaf for %s in patch", change.Path)
+			}
+			leaf := *change.Leaf
+			leaves[change.Path] = &leaf
+		case PatchDelete:
+			if _, ok := leaves[change.Path]; !ok {
+				return nil, fmt.Errorf("patch deletes %s, which is not in the tree", change.Path)
+			}
+			delete(leaves, change.Path)
+		default:
+			return nil, fmt.Errorf("unknown patch operation %q for %s", change.Op, change.Path)
+		}
+	}
+
+	// Order the leaves the way BuildWithOptions orders their paths
+	sorted := make([]*Node, 0, len(leaves))
+	for _, leaf := range leaves {
+		sorted = append(sorted, leaf)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return leafPathCompare(sorted[i].Path, sorted[j].Path, p.Header.Portable || p.Header.BytewiseOrder) < 0
+	})
+
+	header := p.Header
+	if len(sorted) == 0 {
+		empty, err := Build(nil, header.Root)
+		if err != nil {
+			return nil, err
+		}
+		header.Tree = empty.Root
+	} else {
+		root, err := buildLevels(sorted)
+		if err != nil {
+			return nil, err
+		}
+		directories, err := directoryHashes(sorted)
+		if err != nil {
+			return nil, err
+		}
+		header.Tree = root
+		header.Directories = directories
+	}
+	if header.Tree.Hash != p.Target {
+		return nil, fmt.Errorf("patched tree has root %s, patch expects %s", header.Tree.Hash, p.Target)
+	}
+	return deserialize(&header, header.SchemaVersion), nil
+}
+
+// EncodePatch returns patch as JSON
+func EncodePatch(patch *Patch) ([]byte, error) {
+	data, err := json.MarshalIndent(patch, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal patch: %w", err)
+	}
+	return data, nil
+}
+
+// LoadPatch reads a patch written by EncodePatch
+func LoadPatch(path string) (*Patch, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read patch: %w", err)
+	}
+	var patch Patch
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return nil, fmt.Errorf("failed to parse patch: %w", err)
+	}
+	return &patch, nil
+}
+
+// leafMap returns the leaves under node keyed by path
+func leafMap(node *Node) map[string]*Node {
+	leaves := leavesOf(node)
+	byPath := make(map[string]*Node, len(leaves))
+	for _, leaf := range leaves {
+		byPath[leaf.Path] = leaf
+	}
+	return byPath
+}
+
+// sameLeaf reports whether two leaves record the same file
+func sameLeaf(a, b *Node) bool {
+	if a.Hash != b.Hash || a.Size != b.Size || a.MTime != b.MTime || a.Dir != b.Dir || a.CID != b.CID || a.Virtual != b.Virtual ||
+		a.Sampled != b.Sampled {
+		return false
+	}
+	if (a.Allocated == nil) != (b.Allocated == nil) || a.Allocated != nil && *a.Allocated != *b.Allocated {
+		return false
+	}
+	return slices.Equal(a.Chunks, b.Chunks) && maps.Equal(a.Digests, b.Digests) && maps.Equal(a.Tags, b.Tags)
+}
@@ -0,0 +1,91 @@
+package tree
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDiffApply(t *testing.T) {
+	oldTree, err := Build(map[string]FileData{
+		"/data/a.txt":     {Hash: "aaaaaaaaaaaaaaaa", Size: 1, ModTime: time.Unix(100, 0)},
+		"/data/b.txt":     {Hash: "bbbbbbbbbbbbbbbb", Size: 2, ModTime: time.Unix(100, 0)},
+		"/data/sub/c.txt": {Hash: "cccccccccccccccc", Size: 3, ModTime: time.Unix(100, 0)},
+	}, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	newTree, err := Build(map[string]FileData{
+		"/data/a.txt":     {Hash: "aaaaaaaaaaaaaaaa", Size: 1, ModTime: time.Unix(100, 0)},
+		"/data/sub/c.txt": {Hash: "dddddddddddddddd", Size: 4, ModTime: time.Unix(200, 0)},
+		"/data/sub/e.txt": {Hash: "eeeeeeeeeeeeeeee", Size: 5, ModTime: time.Unix(200, 0)},
+	}, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	patch := Diff(oldTree, newTree)
+	got := make(map[string]string)
+	for _, change := range patch.Changes {
+		got[change.Path] = change.Op
+	}
+	want := map[string]string{"b.txt": PatchDelete, "sub/c.txt": PatchSet, "sub/e.txt": PatchSet}
+	if len(got) != len(want) {
+		t.Fatalf("Expected changes %v, got %v", want, got)
+	}
+	for path, op := range want {
+		if got[path] != op {
+			t.Errorf("Expected %s for %s, got %q", op, path, got[path])
+		}
+	}
+
+	// Apply a patch read back from its encoding
+	data, err := EncodePatch(patch)
+	if err != nil {
+		t.Fatalf("EncodePatch failed: %v", err)
+	}
+	var decoded Patch
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	patched, err := decoded.Apply(oldTree)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if patched.Root.Hash != newTree.Root.Hash {
+		t.Errorf("Expected root %s, got %s", newTree.Root.Hash, patched.Root.Hash)
+	}
+	if patched.Directories["sub"] != newTree.Directories["sub"] {
+		t.Errorf("Expected directory hash %s, got %s", newTree.Directories["sub"], patched.Directories["sub"])
+	}
+	if data := patched.Files["/data/sub/c.txt"]; data.Size != 4 || !data.ModTime.Equal(time.Unix(200, 0)) {
+		t.Errorf("Unexpected data for sub/c.txt: %+v", data)
+	}
+	if patched.TotalSize != newTree.TotalSize {
+		t.Errorf("Expected total size %d, got %d", newTree.TotalSize, patched.TotalSize)
+	}
+
+	// A patch only applies to the tree it was made from
+	if _, err := patch.Apply(newTree); err == nil {
+		t.Error("Expected an error applying the patch to the wrong base")
+	}
+}
+
+func TestDiffApply_Empty(t *testing.T) {
+	oldTree, err := Build(map[string]FileData{"/data/a.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 1}}, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	newTree, err := Build(nil, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	patched, err := Diff(oldTree, newTree).Apply(oldTree)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if patched.Root.Hash != newTree.Root.Hash || len(patched.Files) != 0 {
+		t.Errorf("Expected the empty tree, got root %s with %d files", patched.Root.Hash, len(patched.Files))
+	}
+}
@@ -0,0 +1,123 @@
+package tree
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"merkle-go/internal/config"
+	"merkle-go/internal/version"
+)
+
+// PinFile is the name of the pin file written into a pinned directory. It
+// is never part of the scan it pins.
+const PinFile = ".merkle-root"
+
+// Pin records the root hash of a directory at one point in time, such as a
+// release, together with the settings needed to compute it again, so the
+// directory can be checked for drift without keeping its manifest
+type Pin struct {
+	Root             string    `json:"root"`
+	Files            int       `json:"files"`
+	TotalSize        int64     `json:"total_size"`
+	Created          time.Time `json:"created"`
+	GeneratorVersion string    `json:"generator_version,omitempty"`
+
+	// Scan settings the root hash depends on
+	HashAlgorithm string                   `json:"hash_algorithm,omitempty"`
+	Portable      bool                     `json:"portable,omitempty"`
+	BytewiseOrder bool                     `json:"bytewise_order,omitempty"`
+	EmptyDirs     bool                     `json:"empty_dirs,omitempty"`
+	Archives      []string                 `json:"archives,omitempty"`
+	SegmentSize   int64                    `json:"segment_size,omitempty"`
+	Fingerprint   []config.FingerprintRule `json:"fingerprint,omitempty"`
+	Skip          []string                 `json:"skip,omitempty"`
+	SkipRegex     []string                 `json:"skip_regex,omitempty"`
+}
+
+// NewPin returns the pin of t. Trees that cannot be rescanned to the same
+// root, because they only record metadata, hold piped-in files or contain
+// a pin file themselves, are refused.
+func NewPin(t *MerkleTree) (*Pin, error) {
+	if t.MetadataOnly {
+		return nil, fmt.Errorf("tree records no content hashes to pin")
+	}
+	for path, data := range t.Files {
+		if data.Virtual {
+			return nil, fmt.Errorf("tree holds piped-in file %s, which cannot be rescanned", path)
+		}
+		if filepath.Base(path) == PinFile {
+			return nil, fmt.Errorf("tree includes the pin file %s; regenerate it with %s skipped", path, PinFile)
+		}
+	}
+
+	pin := &Pin{
+		Root:             t.Root.Hash,
+		Files:            len(t.Files),
+		TotalSize:        t.TotalSize,
+		Created:          time.Now().UTC().Truncate(time.Second),
+		GeneratorVersion: version.String(),
+		HashAlgorithm:    t.HashAlgorithm,
+		Portable:         t.Portable,
+		BytewiseOrder:    t.BytewiseOrder,
+		EmptyDirs:        t.EmptyDirs,
+		Archives:         t.Archives,
+		SegmentSize:      t.SegmentSize,
+		Fingerprint:      t.Fingerprint,
+	}
+	if t.Scan != nil {
+		pin.Skip = t.Scan.Skip
+		pin.SkipRegex = t.Scan.SkipRegex
+	}
+	return pin, nil
+}
+
+// Apply copies the scan settings of the pin into cfg, so that RootHash
+// computes the root the pin was made from. The skip patterns and skip_regex
+// rules of cfg are kept if the pin recorded none; the pin file is always
+// skipped.
+func (p *Pin) Apply(cfg *config.Config) {
+	cfg.HashAlgorithm = p.HashAlgorithm
+	cfg.HashAlgorithms = nil
+	cfg.Portable = p.Portable
+	cfg.BytewiseOrder = p.BytewiseOrder
+	cfg.EmptyDirs = p.EmptyDirs
+	cfg.DescendArchives = p.Archives
+	cfg.SegmentSize = p.SegmentSize
+	cfg.Fingerprint = p.Fingerprint
+	if p.Skip != nil || p.SkipRegex != nil {
+		cfg.Skip = append([]string(nil), p.Skip...)
+		cfg.SkipRegex = p.SkipRegex
+	}
+	cfg.Skip = append(cfg.Skip, PinFile)
+}
+
+// SavePin writes pin to path, replacing any earlier pin
+func SavePin(pin *Pin, path string) error {
+	data, err := json.MarshalIndent(pin, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal pin: %w", err)
+	}
+	return saveFile(path, SaveOptions{}, func(f *os.File) error {
+		_, err := f.Write(append(data, '\n'))
+		return err
+	})
+}
+
+// LoadPin reads a pin written by SavePin
+func LoadPin(path string) (*Pin, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pin: %w", err)
+	}
+	var pin Pin
+	if err := json.Unmarshal(data, &pin); err != nil {
+		return nil, fmt.Errorf("failed to parse pin %s: %w", path, err)
+	}
+	if pin.Root == "" {
+		return nil, fmt.Errorf("pin %s records no root hash", path)
+	}
+	return &pin, nil
+}
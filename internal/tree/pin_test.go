@@ -0,0 +1,86 @@
+package tree
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"merkle-go/internal/config"
+	"merkle-go/internal/hash"
+)
+
+func TestPin_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(path, []byte("alpha"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "skip.tmp"), []byte("ignored"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	digest, err := hash.HashFile(path)
+	if err != nil {
+		t.Fatalf("HashFile failed: %v", err)
+	}
+	pinned, err := BuildWithOptions(map[string]FileData{path: {Hash: digest, Size: 5}}, dir, BuildOptions{Portable: true})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	pinned.Scan = &ScanParams{Skip: []string{"*.tmp"}}
+
+	pin, err := NewPin(pinned)
+	if err != nil {
+		t.Fatalf("NewPin failed: %v", err)
+	}
+	pinPath := filepath.Join(dir, PinFile)
+	if err := SavePin(pin, pinPath); err != nil {
+		t.Fatalf("SavePin failed: %v", err)
+	}
+	loaded, err := LoadPin(pinPath)
+	if err != nil {
+		t.Fatalf("LoadPin failed: %v", err)
+	}
+	if loaded.Root != pinned.Root.Hash || loaded.Files != 1 || !loaded.Portable {
+		t.Fatalf("Expected the pin of %s, got %+v", pinned.Root.Hash, loaded)
+	}
+
+	// The pin file inside the directory is not part of the rescan
+	cfg := config.DefaultConfig()
+	loaded.Apply(cfg)
+	current, err := RootHash(dir, cfg)
+	if err != nil {
+		t.Fatalf("RootHash failed: %v", err)
+	}
+	if current != loaded.Root {
+		t.Errorf("Expected the pinned root %s, got %s", loaded.Root, current)
+	}
+
+	if err := os.WriteFile(path, []byte("changed"), 0644); err != nil {
+		t.Fatalf("Failed to modify test file: %v", err)
+	}
+	if current, err = RootHash(dir, cfg); err != nil {
+		t.Fatalf("RootHash failed: %v", err)
+	}
+	if current == loaded.Root {
+		t.Error("Expected a different root after a file changed")
+	}
+}
+
+func TestNewPin_Refused(t *testing.T) {
+	withPin, err := Build(map[string]FileData{"/data/" + PinFile: {Hash: "h1", Size: 1}}, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if _, err := NewPin(withPin); err == nil {
+		t.Error("Expected a tree including a pin file to be refused")
+	}
+
+	metadata, err := Build(map[string]FileData{"/data/a.txt": {Hash: "h1", Size: 1}}, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	metadata.MetadataOnly = true
+	if _, err := NewPin(metadata); err == nil {
+		t.Error("Expected a metadata-only tree to be refused")
+	}
+}
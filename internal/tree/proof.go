@@ -0,0 +1,274 @@
+package tree
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"merkle-go/internal/hash"
+)
+
+// ProofFormat identifies the proof layout below, so verifiers can reject
+// proofs they do not understand. Despite the name, it is not the RFC 6962
+// audit path layout; see Proof.
+const ProofFormat = "merkle-go-audit-path/1"
+
+// Sides of a sibling hash in an audit path
+const (
+	SideLeft  = "left"
+	SideRight = "right"
+)
+
+// ProofStep is one sibling on the way from a leaf to the root. Side tells
+// whether the sibling is hashed before (left) or after (right) the running
+// hash.
+type ProofStep struct {
+	Hash string `json:"hash"`
+	Side string `json:"side"`
+}
+
+// Proof is an inclusion proof for one file: the leaf index and tree size,
+// then the sibling hashes ordered from the leaf up to the root. Each step
+// carries an explicit direction, so a verifier only needs the parent rule
+// and never has to reproduce the tree shape:
+//
+//	parent = xxh64(bytes(left) || bytes(right)), written as 16 hex digits
+//
+// where bytes() decodes the hex hashes. Leaves are the file content hashes,
+// computed with hash_algorithm (files larger than segment_size, if set, as
+// a Merkle tree of segments; see hash.ReadOptions.SegmentSize; files with
+// sampled set, from that many bytes at each end; see hash.HashFileSample),
+// sorted by path; a node without a sibling on
+// its level is paired with itself, which shows up as a right-hand step
+// carrying the node's own hash.
+//
+// A proof is an integrity hint, not tamper-evidence. It is not an RFC 6962
+// audit path: leaves and parents are not domain-separated, the parent hash
+// is the non-cryptographic xxh64, pairing a node with itself lets different
+// trees share a root, and the leaf hash does not cover the path. It catches
+// accidental corruption of a file, but someone able to choose file contents
+// can forge a proof for a root hash they did not publish.
+type Proof struct {
+	Format        string      `json:"format"`
+	HashAlgorithm string      `json:"hash_algorithm"`
+	SegmentSize   int64       `json:"segment_size,omitempty"`
+	Sampled       int64       `json:"sampled,omitempty"`
+	TreeSize      int         `json:"tree_size"`
+	LeafIndex     int         `json:"leaf_index"`
+	Path          string      `json:"path"`
+	LeafHash      string      `json:"leaf_hash"`
+	RootHash      string      `json:"root_hash"`
+	AuditPath     []ProofStep `json:"audit_path"`
+}
+
+// GenerateProof returns the inclusion proof for the file at relPath, given
+// with forward slashes relative to the tree root
+func GenerateProof(t *MerkleTree, relPath string) (*Proof, error) {
+	relPath = strings.Trim(path.Clean("/"+relPath), "/")
+
+	// Find the leaf, recording the siblings on the way down. Searching the
+	// left child first finds the leaf at its real position when a node was
+	// paired with itself.
+	var steps []ProofStep
+	var find func(node *Node) *Node
+	find = func(node *Node) *Node {
+		if node == nil {
+			return nil
+		}
+		if node.Left == nil && node.Right == nil {
+			if node.Path == relPath {
+				return node
+			}
+			return nil
+		}
+		if leaf := find(node.Left); leaf != nil {
+			steps = append(steps, ProofStep{Hash: node.Right.Hash, Side: SideRight})
+			return leaf
+		}
+		if node.Right != node.Left {
+			if leaf := find(node.Right); leaf != nil {
+				steps = append(steps, ProofStep{Hash: node.Left.Hash, Side: SideLeft})
+				return leaf
+			}
+		}
+		return nil
+	}
+
+	leaf := find(t.Root)
+	if leaf == nil {
+		return nil, fmt.Errorf("%s is not in the tree", relPath)
+	}
+
+	// The directions from the root spell out the leaf index in binary
+	leafIndex := 0
+	for i, step := range steps {
+		if step.Side == SideLeft {
+			leafIndex |= 1 << i
+		}
+	}
+
+	return &Proof{
+		Format:        ProofFormat,
+		HashAlgorithm: t.LeafAlgorithm(),
+		SegmentSize:   t.SegmentSize,
+		Sampled:       leaf.Sampled,
+		TreeSize:      len(leavesOf(t.Root)),
+		LeafIndex:     leafIndex,
+		Path:          leaf.Path,
+		LeafHash:      leaf.Hash,
+		RootHash:      t.Root.Hash,
+		AuditPath:     steps,
+	}, nil
+}
+
+// ErrProofMismatch is returned by VerifyProof when a proof does not lead to
+// the expected root hash
+var ErrProofMismatch = errors.New("proof does not match root hash")
+
+// VerifyProof checks that proof links its leaf hash to rootHash. The caller
+// should also check that proof.LeafHash is the hash of the file it trusts.
+func VerifyProof(proof *Proof, rootHash string) error {
+	if proof.Format != ProofFormat {
+		return fmt.Errorf("unsupported proof format %q", proof.Format)
+	}
+	if !hash.Supported(proof.HashAlgorithm) || proof.HashAlgorithm == "" {
+		return fmt.Errorf("unsupported proof hash algorithm %q", proof.HashAlgorithm)
+	}
+	if proof.LeafIndex < 0 || proof.LeafIndex >= proof.TreeSize {
+		return fmt.Errorf("leaf index %d out of range for tree size %d", proof.LeafIndex, proof.TreeSize)
+	}
+
+	current, err := hex.DecodeString(proof.LeafHash)
+	if err != nil {
+		return fmt.Errorf("invalid leaf hash: %w", err)
+	}
+	for i, step := range proof.AuditPath {
+		// The side of each sibling must agree with the leaf index
+		expectedSide := SideRight
+		if proof.LeafIndex>>i&1 == 1 {
+			expectedSide = SideLeft
+		}
+		if step.Side != expectedSide {
+			return fmt.Errorf("%w: step %d is on the %s, leaf index %d requires %s",
+				ErrProofMismatch, i, step.Side, proof.LeafIndex, expectedSide)
+		}
+
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil {
+			return fmt.Errorf("invalid hash in step %d: %w", i, err)
+		}
+		var combined []byte
+		if step.Side == SideLeft {
+			combined = append(sibling, current...)
+		} else {
+			combined = append(current, sibling...)
+		}
+		if current, err = hash.XXHashFunc(combined); err != nil {
+			return fmt.Errorf("failed to hash step %d: %w", i, err)
+		}
+	}
+
+	if computed := hex.EncodeToString(current); computed != strings.ToLower(rootHash) {
+		return fmt.Errorf("%w: computed %s, expected %s", ErrProofMismatch, computed, rootHash)
+	}
+	return nil
+}
+
+// ProofSetFormat identifies the layout of a ProofSet
+const ProofSetFormat = "merkle-go-proof-set/1"
+
+// ProofSet bundles the inclusion proofs of several files of one tree, so a
+// subset of the files can be checked against the root hash alone. Every
+// proof has the layout described on Proof.
+type ProofSet struct {
+	Format   string  `json:"format"`
+	RootHash string  `json:"root_hash"`
+	Proofs   []Proof `json:"proofs"`
+}
+
+// GenerateProofs returns the inclusion proofs for the files at relPaths,
+// given like GenerateProof's, in one pass over the tree. Proofs are sorted
+// by leaf index and repeated paths are proved once; a path that is not in
+// the tree is an error.
+func GenerateProofs(t *MerkleTree, relPaths []string) (*ProofSet, error) {
+	wanted := make(map[string]bool, len(relPaths))
+	for _, relPath := range relPaths {
+		wanted[strings.Trim(path.Clean("/"+relPath), "/")] = true
+	}
+
+	treeSize := len(leavesOf(t.Root))
+	set := &ProofSet{Format: ProofSetFormat, RootHash: t.Root.Hash, Proofs: make([]Proof, 0, len(wanted))}
+
+	// Walk down keeping the siblings from the root; a wanted leaf gets them
+	// in reverse. As in GenerateProof, the right child of a node paired with
+	// itself is not a real position.
+	var siblings []ProofStep
+	var walk func(node *Node)
+	walk = func(node *Node) {
+		if node == nil {
+			return
+		}
+		if node.Left == nil && node.Right == nil {
+			if !wanted[node.Path] {
+				return
+			}
+			delete(wanted, node.Path)
+			proof := Proof{
+				Format:        ProofFormat,
+				HashAlgorithm: t.LeafAlgorithm(),
+				SegmentSize:   t.SegmentSize,
+				Sampled:       node.Sampled,
+				TreeSize:      treeSize,
+				Path:          node.Path,
+				LeafHash:      node.Hash,
+				RootHash:      t.Root.Hash,
+				AuditPath:     make([]ProofStep, len(siblings)),
+			}
+			for i := range siblings {
+				step := siblings[len(siblings)-1-i]
+				proof.AuditPath[i] = step
+				if step.Side == SideLeft {
+					proof.LeafIndex |= 1 << i
+				}
+			}
+			set.Proofs = append(set.Proofs, proof)
+			return
+		}
+		siblings = append(siblings, ProofStep{Hash: node.Right.Hash, Side: SideRight})
+		walk(node.Left)
+		siblings = siblings[:len(siblings)-1]
+		if node.Right != node.Left {
+			siblings = append(siblings, ProofStep{Hash: node.Left.Hash, Side: SideLeft})
+			walk(node.Right)
+			siblings = siblings[:len(siblings)-1]
+		}
+	}
+	walk(t.Root)
+
+	if len(wanted) > 0 {
+		missing := make([]string, 0, len(wanted))
+		for relPath := range wanted {
+			missing = append(missing, relPath)
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%d paths are not in the tree: %s", len(missing), strings.Join(missing, ", "))
+	}
+	return set, nil
+}
+
+// VerifyProofSet checks every proof in set against rootHash with
+// VerifyProof and returns the first failure, naming the file it covers
+func VerifyProofSet(set *ProofSet, rootHash string) error {
+	if set.Format != ProofSetFormat {
+		return fmt.Errorf("unsupported proof set format %q", set.Format)
+	}
+	for i := range set.Proofs {
+		if err := VerifyProof(&set.Proofs[i], rootHash); err != nil {
+			return fmt.Errorf("%s: %w", set.Proofs[i].Path, err)
+		}
+	}
+	return nil
+}
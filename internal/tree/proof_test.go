@@ -0,0 +1,154 @@
+package tree
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateVerifyProof(t *testing.T) {
+	for _, count := range []int{1, 2, 5, 8} {
+		files := make(map[string]FileData)
+		for i := 0; i < count; i++ {
+			files[fmt.Sprintf("/data/dir%d/file.txt", i)] = FileData{Hash: fmt.Sprintf("%016x", i+1), Size: 1}
+		}
+		built, err := Build(files, "/data")
+		if err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+
+		// Proofs must also work against a tree read back from disk
+		path := filepath.Join(t.TempDir(), "tree.json")
+		if err := Save(built, path); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		loaded, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+
+		for _, merkleTree := range []*MerkleTree{built, loaded} {
+			for i := 0; i < count; i++ {
+				relPath := fmt.Sprintf("dir%d/file.txt", i)
+				proof, err := GenerateProof(merkleTree, relPath)
+				if err != nil {
+					t.Fatalf("GenerateProof(%s) failed: %v", relPath, err)
+				}
+				if proof.LeafIndex != i || proof.TreeSize != count {
+					t.Errorf("Expected leaf %d of %d, got %d of %d", i, count, proof.LeafIndex, proof.TreeSize)
+				}
+				if err := VerifyProof(proof, merkleTree.Root.Hash); err != nil {
+					t.Errorf("%d files, %s: %v", count, relPath, err)
+				}
+			}
+		}
+	}
+}
+
+func TestVerifyProof_Tampered(t *testing.T) {
+	merkleTree, err := Build(map[string]FileData{
+		"/data/a": {Hash: "aaaaaaaaaaaaaaaa"},
+		"/data/b": {Hash: "bbbbbbbbbbbbbbbb"},
+		"/data/c": {Hash: "cccccccccccccccc"},
+	}, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	proof, err := GenerateProof(merkleTree, "b")
+	if err != nil {
+		t.Fatalf("GenerateProof failed: %v", err)
+	}
+
+	forged := *proof
+	forged.LeafHash = "dddddddddddddddd"
+	if err := VerifyProof(&forged, merkleTree.Root.Hash); !errors.Is(err, ErrProofMismatch) {
+		t.Errorf("Expected ErrProofMismatch for a forged leaf, got %v", err)
+	}
+
+	swapped := *proof
+	swapped.AuditPath = append([]ProofStep(nil), proof.AuditPath...)
+	swapped.AuditPath[0].Side = SideRight
+	if err := VerifyProof(&swapped, merkleTree.Root.Hash); !errors.Is(err, ErrProofMismatch) {
+		t.Errorf("Expected ErrProofMismatch for a wrong direction, got %v", err)
+	}
+
+	if _, err := GenerateProof(merkleTree, "missing"); err == nil {
+		t.Error("Expected an error for a file that is not in the tree")
+	}
+}
+
+func TestGenerateProof_LeafAlgorithm(t *testing.T) {
+	sha256Hash := "dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f"
+	merkleTree, err := BuildWithOptions(map[string]FileData{
+		"/data/a.txt": {Hash: sha256Hash, Size: 13},
+		"/data/b.txt": {Hash: "0a0a9f2a6772942557ab5355d76af442f8f65e010a0a9f2a6772942557ab5355", Size: 1},
+	}, "/data", BuildOptions{HashAlgorithm: "sha256"})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	proof, err := GenerateProof(merkleTree, "a.txt")
+	if err != nil {
+		t.Fatalf("GenerateProof failed: %v", err)
+	}
+	if proof.HashAlgorithm != "sha256" || proof.LeafHash != sha256Hash {
+		t.Errorf("Expected a sha256 leaf hash, got %s %s", proof.HashAlgorithm, proof.LeafHash)
+	}
+	if err := VerifyProof(proof, merkleTree.Root.Hash); err != nil {
+		t.Errorf("VerifyProof failed: %v", err)
+	}
+
+	// Keyed leaf hashes link to the root without the key
+	keyed, err := BuildWithOptions(merkleTree.Files, "/data", BuildOptions{HashAlgorithm: "hmac-sha256"})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if proof, err = GenerateProof(keyed, "a.txt"); err != nil {
+		t.Fatalf("GenerateProof failed: %v", err)
+	}
+	if err := VerifyProof(proof, keyed.Root.Hash); err != nil {
+		t.Errorf("VerifyProof of a keyed tree failed: %v", err)
+	}
+}
+
+func TestGenerateProofs(t *testing.T) {
+	files := make(map[string]FileData)
+	for i := 0; i < 7; i++ {
+		files[fmt.Sprintf("/data/file%d", i)] = FileData{Hash: fmt.Sprintf("%016x", i+1), Size: 1}
+	}
+	merkleTree, err := Build(files, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	set, err := GenerateProofs(merkleTree, []string{"file6", "file2", "./file6", "file0"})
+	if err != nil {
+		t.Fatalf("GenerateProofs failed: %v", err)
+	}
+	if len(set.Proofs) != 3 {
+		t.Fatalf("Expected 3 proofs, got %d", len(set.Proofs))
+	}
+	for i, relPath := range []string{"file0", "file2", "file6"} {
+		single, err := GenerateProof(merkleTree, relPath)
+		if err != nil {
+			t.Fatalf("GenerateProof(%s) failed: %v", relPath, err)
+		}
+		if got := set.Proofs[i]; got.Path != relPath || got.LeafIndex != single.LeafIndex ||
+			fmt.Sprint(got.AuditPath) != fmt.Sprint(single.AuditPath) {
+			t.Errorf("Proof %d differs from GenerateProof(%s): %+v", i, relPath, got)
+		}
+	}
+	if err := VerifyProofSet(set, merkleTree.Root.Hash); err != nil {
+		t.Errorf("VerifyProofSet failed: %v", err)
+	}
+
+	set.Proofs[1].LeafHash = "0000000000000000"
+	if err := VerifyProofSet(set, merkleTree.Root.Hash); !errors.Is(err, ErrProofMismatch) {
+		t.Errorf("Expected ErrProofMismatch for a tampered proof, got %v", err)
+	}
+
+	if _, err := GenerateProofs(merkleTree, []string{"file1", "missing"}); err == nil {
+		t.Error("Expected an error for a path not in the tree")
+	}
+}
@@ -0,0 +1,78 @@
+package tree
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// RedactedRoot is the root path of redacted trees
+const RedactedRoot = "/redacted"
+
+// Redact returns a copy of t whose paths are replaced segment by segment
+// with name(segment), so files that share a directory still do. The leaves
+// keep their order and the tree its shape, so the root and directory hashes
+// are those of t, while no file or directory name is revealed. The root
+// path, volume, skip patterns, fingerprint rules, tags and scan error
+// messages, which may name files too, are left out.
+func Redact(t *MerkleTree, name func(segment string) string) *MerkleTree {
+	redactPath := func(relPath string) string {
+		segments := strings.Split(relPath, "/")
+		for i, segment := range segments {
+			segments[i] = name(segment)
+		}
+		return path.Join(segments...)
+	}
+
+	// An odd node paired with itself is shared, and stays shared
+	copies := make(map[*Node]*Node)
+	var copyNode func(n *Node) *Node
+	copyNode = func(n *Node) *Node {
+		if n == nil {
+			return nil
+		}
+		if c, ok := copies[n]; ok {
+			return c
+		}
+		c := *n
+		c.Left, c.Right = copyNode(n.Left), copyNode(n.Right)
+		if c.Path != "" {
+			c.Path = redactPath(c.Path)
+			c.Tags = nil
+		}
+		copies[n] = &c
+		return &c
+	}
+
+	redacted := *t
+	redacted.Root = copyNode(t.Root)
+	redacted.RootPath = RedactedRoot
+	redacted.Volume = nil
+	redacted.Fingerprint = nil
+	redacted.Redacted = true
+
+	redacted.Files = make(map[string]FileData, len(t.Files))
+	for filePath, data := range t.Files {
+		data.Tags = nil
+		relPath := filepath.ToSlash(relativePath(filepath.Clean(t.RootPath), filePath))
+		redacted.Files[filepath.Join(RedactedRoot, filepath.FromSlash(redactPath(relPath)))] = data
+	}
+	redacted.Directories = make(map[string]string, len(t.Directories))
+	for dir, hash := range t.Directories {
+		redacted.Directories[redactPath(dir)] = hash
+	}
+	redacted.Errors = nil
+	for _, scanErr := range t.Errors {
+		relPath := filepath.ToSlash(relativePath(filepath.Clean(t.RootPath), scanErr.Path))
+		redacted.Errors = append(redacted.Errors, ScanError{
+			Path:  filepath.Join(RedactedRoot, filepath.FromSlash(redactPath(relPath))),
+			Error: "redacted",
+		})
+	}
+	if t.Scan != nil {
+		scan := *t.Scan
+		scan.Skip, scan.SkipRegex = nil, nil
+		redacted.Scan = &scan
+	}
+	return &redacted
+}
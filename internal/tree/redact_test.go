@@ -0,0 +1,76 @@
+package tree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	root := "/data"
+	files := map[string]FileData{
+		"/data/secret/a.txt":      {Hash: "1111111111111111", Size: 1},
+		"/data/secret/b.txt":      {Hash: "2222222222222222", Size: 2},
+		"/data/projects/x/c.txt":  {Hash: "3333333333333333", Size: 3, Tags: map[string]string{"owner": "alice"}},
+		"/data/Banana.txt":        {Hash: "4444444444444444", Size: 4},
+		"/data/apple/nested/d.go": {Hash: "5555555555555555", Size: 5},
+	}
+	original, err := Build(files, root)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	original.Errors = []ScanError{{Path: "/data/secret/locked", Error: "open /data/secret/locked: permission denied"}}
+
+	name := func(segment string) string {
+		digest := sha256.Sum256([]byte(segment))
+		return hex.EncodeToString(digest[:8])
+	}
+	redacted := Redact(original, name)
+	if redacted.Root.Hash != original.Root.Hash {
+		t.Errorf("Expected the root hash %s to be kept, got %s", original.Root.Hash, redacted.Root.Hash)
+	}
+	if err := Validate(redacted); err != nil {
+		t.Errorf("Expected the redacted tree to validate, got %v", err)
+	}
+
+	// Files of one directory stay in one directory
+	secretDir := name("secret")
+	if _, ok := redacted.Directories[secretDir]; !ok {
+		t.Errorf("Expected directory %s in %v", secretDir, redacted.Directories)
+	}
+	for _, leaf := range leavesOf(redacted.Root) {
+		if leaf.Tags != nil {
+			t.Errorf("Expected the tags of %s to be left out", leaf.Path)
+		}
+	}
+	want := filepath.Join(RedactedRoot, filepath.FromSlash(path.Join(secretDir, name("a.txt"))))
+	if data, ok := redacted.Files[want]; !ok || data.Hash != "1111111111111111" {
+		t.Errorf("Expected %s in the redacted files, got %v", want, redacted.Files)
+	}
+
+	encoded, err := Encode(redacted)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	for _, word := range []string{"secret", "projects", "Banana", "alice", "permission", "/data"} {
+		if bytes.Contains(encoded, []byte(word)) {
+			t.Errorf("Expected %q to be redacted from the manifest", word)
+		}
+	}
+	loaded, err := Decode(encoded)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !loaded.Redacted || loaded.Root.Hash != original.Root.Hash || len(loaded.Files) != len(files) {
+		t.Errorf("Expected a redacted tree of %d files with root %s, got %d files with root %s (redacted %v)",
+			len(files), original.Root.Hash, len(loaded.Files), loaded.Root.Hash, loaded.Redacted)
+	}
+
+	// The original is left alone
+	if _, ok := original.Files["/data/secret/a.txt"]; !ok || original.Redacted {
+		t.Error("Expected the original tree to be unchanged")
+	}
+}
@@ -0,0 +1,163 @@
+package tree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"runtime"
+
+	"merkle-go/internal/archive"
+	"merkle-go/internal/config"
+	"merkle-go/internal/hash"
+	"merkle-go/internal/walker"
+)
+
+// RootHash scans dir with the skip patterns and read strategy from cfg and
+// returns the root hash of its merkle tree, without keeping the tree. Unlike
+// the CLI, which skips unreadable files, it fails if any file cannot be
+// read, so the fingerprint always covers the whole directory.
+func RootHash(dir string, cfg *config.Config) (string, error) {
+	if cfg == nil {
+		cfg = config.DefaultConfig()
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	hashFunc, err := hash.FileHasher(cfg.HashAlgorithm, hash.ReadOptions{Strategy: cfg.ReadStrategy, BufferSize: cfg.BufferSize, SegmentSize: cfg.SegmentSize, Key: cfg.HashKeyBytes()})
+	if err != nil {
+		return "", err
+	}
+
+	if len(cfg.Fingerprint) > 0 {
+		hashFunc = hash.SampledHasher(hashFunc, cfg.HashAlgorithm, cfg.HashKeyBytes(), func(path string) int64 {
+			relPath, err := filepath.Rel(absDir, path)
+			if err != nil {
+				return 0
+			}
+			return cfg.FingerprintFor(relPath)
+		}, nil)
+	}
+
+	ctx := context.Background()
+	walkResult, err := walker.WalkWithOptions(ctx, absDir, walker.WalkOptions{
+		Exclusions:       cfg.Skip,
+		SkipRegex:        cfg.SkipRegex,
+		OneFileSystem:    cfg.OneFileSystem,
+		ExcludeCaches:    cfg.ExcludeCaches,
+		ExcludeIfPresent: cfg.ExcludeIfPresent,
+		FilterCommand:    cfg.FilterCmd,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(walkResult.Errors) > 0 {
+		return "", fmt.Errorf("failed to walk %s: %w", absDir, errors.Join(walkResult.Errors...))
+	}
+
+	files := make(map[string]FileData, len(walkResult.Files))
+	plain := walkResult.Files
+	if len(cfg.DescendArchives) > 0 {
+		plain = plain[:0:0]
+		for _, fileInfo := range walkResult.Files {
+			kind := archive.Kind(fileInfo.Path, cfg.DescendArchives)
+			if kind == "" {
+				plain = append(plain, fileInfo)
+				continue
+			}
+			members, err := archive.HashMembers(fileInfo.Path, kind, cfg.HashAlgorithm)
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", fileInfo.Path, err)
+			}
+			for _, member := range members {
+				files[archive.MemberPath(fileInfo.Path, member.Name)] = FileData{Hash: member.Hash, Size: member.Size, ModTime: member.ModTime}
+			}
+		}
+	}
+
+	return hashedRoot(ctx, walkResult, plain, files, hashFunc, absDir, cfg)
+}
+
+// RootHashFS is RootHash for the files of fsys, such as an embed.FS, a
+// zip.Reader or an fstest.MapFS. The tree is rooted at fsys, so on
+// Unix-like systems the root hash of os.DirFS(dir) is that of dir. Settings
+// that need OS files, such as archives, fingerprint rules, segments and
+// filter_cmd, are refused.
+func RootHashFS(fsys fs.FS, cfg *config.Config) (string, error) {
+	if cfg == nil {
+		cfg = config.DefaultConfig()
+	}
+	if len(cfg.DescendArchives) > 0 || len(cfg.Fingerprint) > 0 {
+		return "", fmt.Errorf("descend_archives and fingerprint rules need OS files")
+	}
+	hashFunc, err := hash.FSHasher(fsys, cfg.HashAlgorithm, hash.ReadOptions{BufferSize: cfg.BufferSize, SegmentSize: cfg.SegmentSize, Key: cfg.HashKeyBytes()})
+	if err != nil {
+		return "", err
+	}
+
+	ctx := context.Background()
+	walkResult, err := walker.WalkFS(ctx, fsys, walker.WalkOptions{
+		Exclusions:       cfg.Skip,
+		SkipRegex:        cfg.SkipRegex,
+		ExcludeCaches:    cfg.ExcludeCaches,
+		ExcludeIfPresent: cfg.ExcludeIfPresent,
+		FilterCommand:    cfg.FilterCmd,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(walkResult.Errors) > 0 {
+		return "", fmt.Errorf("failed to walk: %w", errors.Join(walkResult.Errors...))
+	}
+	return hashedRoot(ctx, walkResult, walkResult.Files, make(map[string]FileData), hashFunc, ".", cfg)
+}
+
+// hashedRoot hashes the plain files of a walk with hashFunc, adds them and
+// the empty directories of the walk to files and returns the root hash of
+// the tree they build under root
+func hashedRoot(ctx context.Context, walkResult *walker.WalkResult, plain []walker.FileInfo, files map[string]FileData,
+	hashFunc func(path string) (string, error), root string, cfg *config.Config) (string, error) {
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU() * 2
+	}
+	hashResult, err := walker.HashFilesWithOptions(ctx, plain, walker.HashOptions{
+		Workers:  workers,
+		HashFunc: hashFunc,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash files: %w", err)
+	}
+	if len(hashResult.Errors) > 0 {
+		return "", fmt.Errorf("failed to hash %d files: %w", len(hashResult.Errors), errors.Join(hashResult.Errors...))
+	}
+
+	for _, fileInfo := range plain {
+		files[fileInfo.Path] = FileData{
+			Hash:    hashResult.Hashes[fileInfo.Path],
+			Size:    fileInfo.Size,
+			ModTime: fileInfo.ModTime,
+		}
+	}
+	if cfg.EmptyDirs {
+		for _, dir := range walkResult.EmptyDirs {
+			files[dir.Path] = FileData{Hash: EmptyDirHash, ModTime: dir.ModTime, Dir: true}
+		}
+	}
+
+	merkleTree, err := BuildWithOptions(files, root, BuildOptions{
+		Portable:      cfg.Portable,
+		BytewiseOrder: cfg.BytewiseOrder,
+		HashAlgorithm: cfg.HashAlgorithm,
+		EmptyDirs:     cfg.EmptyDirs,
+		Archives:      cfg.DescendArchives,
+		SegmentSize:   cfg.SegmentSize,
+		Fingerprint:   cfg.Fingerprint,
+	})
+	if err != nil {
+		return "", err
+	}
+	return merkleTree.Root.Hash, nil
+}
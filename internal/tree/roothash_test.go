@@ -0,0 +1,67 @@
+package tree
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"merkle-go/internal/config"
+	"merkle-go/internal/hash"
+)
+
+func TestRootHash(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	contents := map[string]string{
+		"a.txt":     "alpha",
+		"sub/b.txt": "bravo",
+		"skip.tmp":  "ignored",
+	}
+	files := make(map[string]FileData)
+	for name, content := range contents {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		if name == "skip.tmp" {
+			continue
+		}
+		digest, err := hash.HashFile(path)
+		if err != nil {
+			t.Fatalf("HashFile failed: %v", err)
+		}
+		files[path] = FileData{Hash: digest, Size: int64(len(content))}
+	}
+
+	expected, err := Build(files, dir)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	got, err := RootHash(dir, &config.Config{Skip: []string{"*.tmp"}})
+	if err != nil {
+		t.Fatalf("RootHash failed: %v", err)
+	}
+	if got != expected.Root.Hash {
+		t.Errorf("Expected root hash %s, got %s", expected.Root.Hash, got)
+	}
+
+	// The same files in memory have the same root
+	fsys := fstest.MapFS{}
+	for name, content := range contents {
+		fsys[name] = &fstest.MapFile{Data: []byte(content)}
+	}
+	got, err = RootHashFS(fsys, &config.Config{Skip: []string{"*.tmp"}})
+	if err != nil {
+		t.Fatalf("RootHashFS failed: %v", err)
+	}
+	if got != expected.Root.Hash {
+		t.Errorf("Expected root hash %s from the fs.FS, got %s", expected.Root.Hash, got)
+	}
+	if _, err := RootHashFS(fsys, &config.Config{SegmentSize: 1024}); err == nil {
+		t.Error("Expected segments to be refused for an fs.FS")
+	}
+}
@@ -1,22 +1,74 @@
 package tree
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
+
+	"merkle-go/internal/atomicfile"
+	"merkle-go/internal/config"
+	"merkle-go/internal/fsinfo"
+	"merkle-go/internal/version"
 )
 
+// SchemaVersion is the manifest format written by Save. Manifests written
+// before the field existed have no schema_version and are treated as 1.
+// Bumping it requires a matching entry in migrations.
+//
+//	1: leaf paths use the separator of the platform that wrote them
+//	2: adds version and schema_version
+//	3: leaf paths always use forward slashes
+//	4: adds per-directory subtree hashes
+const SchemaVersion = 4
+
+// MinSchemaVersion is the oldest manifest format Load can read
+const MinSchemaVersion = 1
+
 type SerializedTree struct {
-	Generator string    `json:"generator"`
-	Created   time.Time `json:"created"`
-	Root      string    `json:"root"`
-	Size      string    `json:"size"`
-	Tree      *Node     `json:"tree"`
+	Generator     string                   `json:"generator"`
+	Version       string                   `json:"version,omitempty"` // merkle-go version that wrote the manifest
+	SchemaVersion int                      `json:"schema_version,omitempty"`
+	Created       time.Time                `json:"created"`
+	Root          string                   `json:"root"`
+	Size          string                   `json:"size"`
+	Volume        *fsinfo.Volume           `json:"volume,omitempty"`
+	Portable      bool                     `json:"portable,omitempty"`          // built with BuildOptions.Portable
+	BytewiseOrder bool                     `json:"bytewise_order,omitempty"`    // leaves are ordered byte-wise by slash path
+	HashAlgorithm string                   `json:"hash_algorithm,omitempty"`    // algorithm of the leaf hashes; empty means xxh64
+	Digests       []string                 `json:"digest_algorithms,omitempty"` // algorithms of the other digests recorded for each file
+	EmptyDirs     bool                     `json:"empty_dirs,omitempty"`        // empty directories are recorded as leaves
+	Archives      []string                 `json:"archives,omitempty"`          // archive formats whose members are recorded as leaves
+	SegmentSize   int64                    `json:"segment_size,omitempty"`      // larger files are hashed as a Merkle tree of segments of this size
+	ChunkSize     int                      `json:"chunk_size,omitempty"`        // average size of the chunks recorded for each file
+	CIDs          bool                     `json:"cids,omitempty"`              // the IPFS CID of each file is recorded
+	Fingerprint   []config.FingerprintRule `json:"fingerprint,omitempty"`       // rules of the files hashed from a sample of their content
+	MetadataOnly  bool                     `json:"metadata_only,omitempty"`     // leaf hashes stand for size and modification time, not content
+	Redacted      bool                     `json:"redacted,omitempty"`          // paths are replaced by hashes of their segments
+	Directories   map[string]string        `json:"directories,omitempty"`       // relative directory -> subtree hash
+	Errors        []SerializedError        `json:"errors,omitempty"`
+	Scan          *ScanParams              `json:"scan,omitempty"`
+	Stats         *ScanStats               `json:"stats,omitempty"`
+	Tree          *Node                    `json:"tree"`
 }
 
-func formatSize(bytes int64) string {
+// SerializedError is a ScanError with its path relative to the root, using
+// forward slashes
+type SerializedError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// FormatSize renders a byte count using binary units (B, KB, MB, GB)
+func FormatSize(bytes int64) string {
 	const (
 		KB = 1024
 		MB = KB * 1024
@@ -35,38 +87,358 @@ func formatSize(bytes int64) string {
 	}
 }
 
+// SaveOptions controls how Save replaces an existing manifest
+type SaveOptions struct {
+	// NoOverwrite makes saving fail with ErrExists if path already exists
+	NoOverwrite bool
+
+	// Backup keeps the previous manifest, if any, as path + BackupSuffix,
+	// replacing an older backup
+	Backup bool
+
+	// Compact writes the manifest without indentation, which makes large
+	// manifests noticeably smaller and faster to write
+	Compact bool
+
+	// Workers is how many subtrees are encoded at once; up to 1 encodes the
+	// manifest in one piece
+	Workers int
+}
+
+// LoadOptions controls how Load reads a manifest
+type LoadOptions struct {
+	// Workers is how many subtrees are decoded at once; up to 1 decodes the
+	// manifest in one piece
+	Workers int
+}
+
+// BackupSuffix is appended to the path of a manifest kept by SaveOptions.Backup
+const BackupSuffix = ".bak"
+
+// ErrExists is returned by SaveWithOptions when NoOverwrite is set and the
+// target already exists
+var ErrExists = errors.New("manifest already exists")
+
+// Save writes tree to path atomically: the manifest is written to a
+// temporary file in the same directory, synced and renamed over path, so a
+// crash never leaves a truncated manifest behind.
 func Save(tree *MerkleTree, path string) error {
-	serialized := SerializedTree{
-		Generator: "merkle-go",
-		Created:   time.Now(),
-		Root:      tree.RootPath,
-		Size:      formatSize(tree.TotalSize),
-		Tree:      tree.Root,
+	return SaveWithOptions(tree, path, SaveOptions{})
+}
+
+// SaveWithOptions is Save with options. The manifest is encoded straight
+// into the file, so saving never holds the whole document in memory.
+func SaveWithOptions(tree *MerkleTree, path string, opts SaveOptions) error {
+	return saveFile(path, opts, func(f *os.File) error {
+		w := bufio.NewWriter(f)
+		if err := encodeTo(w, tree, opts.Compact, opts.Workers); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+}
+
+// Encode returns the manifest of tree as written by Save. The encoding is
+// byte-stable: object keys, leaves, errors and archive formats are in a fixed
+// order and the created time is written in UTC to the second, so trees with
+// equal fields encode identically.
+func Encode(tree *MerkleTree) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := EncodeTo(&buf, tree, false); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EncodeTo writes the manifest of tree to w as Encode returns it or, if
+// compact is set, without indentation. Nodes are encoded one at a time, so
+// the document is never built in memory.
+func EncodeTo(w io.Writer, tree *MerkleTree, compact bool) error {
+	return encodeTo(w, tree, compact, 1)
+}
+
+// encodeTo is EncodeTo with the subtrees below the top levels encoded by
+// workers at once and written in order, so the output does not change
+func encodeTo(w io.Writer, tree *MerkleTree, compact bool, workers int) error {
+	enc := &nodeEncoder{w: w, compact: compact, shardDepth: shardDepth(workers)}
+	header, err := enc.marshal(serialize(tree), "")
+	if err != nil {
+		return fmt.Errorf("failed to marshal tree: %w", err)
+	}
+	// The tree is the last field of the header; encode it in place of null
+	nullTree := "null}"
+	if !compact {
+		nullTree = "null\n}"
+	}
+	if !bytes.HasSuffix(header, []byte(nullTree)) {
+		return errors.New("failed to marshal tree: unexpected manifest header")
+	}
+	enc.write(header[:len(header)-len(nullTree)])
+	if tree.Root == nil {
+		enc.write([]byte("null"))
+	} else {
+		if enc.shardDepth >= 0 {
+			stop := enc.encodeShards(tree.Root, workers)
+			defer stop()
+		}
+		if err := enc.node(tree.Root, "  ", 0); err != nil {
+			return err
+		}
+	}
+	if compact {
+		enc.write([]byte("}\n"))
+	} else {
+		enc.write([]byte("\n}"))
+	}
+	return enc.err
+}
+
+// nodeEncoder writes a tree of nodes exactly as encoding/json would encode
+// the nested Node values, one node at a time. The first write error sticks.
+// With shardDepth set, the subtrees at that depth come encoded from shards.
+type nodeEncoder struct {
+	w       io.Writer
+	compact bool
+	err     error
+
+	shardDepth int
+	shards     []chan encodedShard
+	slots      chan struct{}
+	next       int
+}
+
+func (e *nodeEncoder) write(p []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+}
+
+// marshal encodes v like json.MarshalIndent with the given line prefix, or
+// like json.Marshal when compact
+func (e *nodeEncoder) marshal(v any, prefix string) ([]byte, error) {
+	if e.compact {
+		return json.Marshal(v)
+	}
+	return json.MarshalIndent(v, prefix, "  ")
+}
+
+// node writes n, whose lines are indented by prefix and which is depth
+// levels below the root. The children of an internal node follow its hash,
+// as the Node field order puts them.
+func (e *nodeEncoder) node(n *Node, prefix string, depth int) error {
+	if depth == e.shardDepth {
+		return e.nextShard()
+	}
+	shallow := *n
+	shallow.Left, shallow.Right = nil, nil
+	data, err := e.marshal(&shallow, prefix)
+	if err != nil {
+		return fmt.Errorf("failed to marshal tree: %w", err)
+	}
+	if n.Left == nil && n.Right == nil {
+		e.write(data)
+		return e.err
 	}
 
-	data, err := json.MarshalIndent(serialized, "", "  ")
+	quotedHash, err := json.Marshal(n.Hash)
 	if err != nil {
 		return fmt.Errorf("failed to marshal tree: %w", err)
 	}
+	hashField, sep, colon := `{"hash":`, ",", ":"
+	if !e.compact {
+		hashField = "{\n" + prefix + `  "hash": `
+		sep, colon = ",\n"+prefix+"  ", ": "
+	}
+	hashField += string(quotedHash)
+	if !bytes.HasPrefix(data, []byte(hashField)) {
+		return errors.New("failed to marshal tree: unexpected node encoding")
+	}
+
+	e.write(data[:len(hashField)])
+	for _, child := range []struct {
+		name string
+		node *Node
+	}{{"left", n.Left}, {"right", n.Right}} {
+		if child.node == nil {
+			continue
+		}
+		e.write([]byte(sep + `"` + child.name + `"` + colon))
+		if err := e.node(child.node, prefix+"  ", depth+1); err != nil {
+			return err
+		}
+	}
+	e.write(data[len(hashField):])
+	return e.err
+}
 
-	if err := os.WriteFile(path, data, 0644); err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+// serialize returns the manifest header of tree; Tree is left nil
+func serialize(tree *MerkleTree) SerializedTree {
+	created := tree.Created
+	if created.IsZero() {
+		created = time.Now()
 	}
+	// Whole seconds in UTC always serialize to the same fixed-width text
+	created = created.UTC().Truncate(time.Second)
 
+	serialized := SerializedTree{
+		Generator:     "merkle-go",
+		Version:       version.String(),
+		SchemaVersion: SchemaVersion,
+		Created:       created,
+		Root:          tree.RootPath,
+		Size:          FormatSize(tree.TotalSize),
+		Volume:        tree.Volume,
+		Portable:      tree.Portable,
+		BytewiseOrder: tree.BytewiseOrder,
+		HashAlgorithm: tree.HashAlgorithm,
+		Digests:       tree.DigestAlgorithms,
+		EmptyDirs:     tree.EmptyDirs,
+		Archives:      slices.Sorted(slices.Values(tree.Archives)),
+		SegmentSize:   tree.SegmentSize,
+		ChunkSize:     tree.ChunkSize,
+		CIDs:          tree.CIDs,
+		Fingerprint:   tree.Fingerprint,
+		MetadataOnly:  tree.MetadataOnly,
+		Redacted:      tree.Redacted,
+		Directories:   tree.Directories,
+		Scan:          tree.Scan,
+		Stats:         tree.Stats,
+	}
+	for _, scanErr := range tree.Errors {
+		relPath, err := filepath.Rel(tree.RootPath, scanErr.Path)
+		if err != nil {
+			relPath = scanErr.Path
+		}
+		serialized.Errors = append(serialized.Errors, SerializedError{Path: filepath.ToSlash(relPath), Error: scanErr.Error})
+	}
+	// Errors arrive in the order the hashing workers hit them
+	slices.SortFunc(serialized.Errors, func(a, b SerializedError) int {
+		return cmp.Or(strings.Compare(a.Path, b.Path), strings.Compare(a.Error, b.Error))
+	})
+	return serialized
+}
+
+// saveFile atomically replaces path with the content produced by write,
+// honoring opts
+func saveFile(path string, opts SaveOptions, write func(f *os.File) error) error {
+	_, statErr := os.Lstat(path)
+	exists := statErr == nil
+	if exists && opts.NoOverwrite {
+		return fmt.Errorf("%w: %s", ErrExists, path)
+	}
+
+	tmpPath, err := atomicfile.CreateTemp(path, write)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath) // No-op once renamed
+
+	if exists && opts.Backup {
+		if err := backup(path); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	atomicfile.SyncDir(filepath.Dir(path))
+
+	return nil
+}
+
+// backup preserves the manifest at path as path + BackupSuffix. It hard
+// links when possible so the original stays in place until the rename.
+func backup(path string) error {
+	backupPath := path + BackupSuffix
+	if err := os.Remove(backupPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove old backup: %w", err)
+	}
+	if err := os.Link(path, backupPath); err == nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest for backup: %w", err)
+	}
+	if err := atomicfile.WriteFile(backupPath, data); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
 	return nil
 }
 
+// checkSchemaVersion reports whether a manifest of the given schema version
+// can be read by this build
+func checkSchemaVersion(schemaVersion int, generatorVersion string) error {
+	if schemaVersion > SchemaVersion {
+		writer := "a newer merkle-go"
+		if generatorVersion != "" {
+			writer = "merkle-go " + generatorVersion
+		}
+		return fmt.Errorf("manifest schema version %d was written by %s; this build (%s) supports up to version %d",
+			schemaVersion, writer, version.String(), SchemaVersion)
+	}
+	if schemaVersion < MinSchemaVersion {
+		return fmt.Errorf("manifest schema version %d is no longer supported (minimum %d)", schemaVersion, MinSchemaVersion)
+	}
+	return nil
+}
+
+// isWindowsPath reports whether path looks like it was written on Windows,
+// i.e. it has a drive letter or backslash separators
+func isWindowsPath(path string) bool {
+	if len(path) >= 2 && path[1] == ':' {
+		return true
+	}
+	return strings.Contains(path, `\`)
+}
+
 func Load(path string) (*MerkleTree, error) {
+	return LoadWithOptions(path, LoadOptions{})
+}
+
+// LoadWithOptions is Load with options
+func LoadWithOptions(path string, opts LoadOptions) (*MerkleTree, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
+	return DecodeWithOptions(data, opts)
+}
 
-	var serialized SerializedTree
-	if err := json.Unmarshal(data, &serialized); err != nil {
+// Decode parses a manifest, upgrading older schema versions like Load, and
+// rejects malformed trees (see CheckStructure)
+func Decode(data []byte) (*MerkleTree, error) {
+	return DecodeWithOptions(data, LoadOptions{})
+}
+
+// DecodeWithOptions is Decode with options
+func DecodeWithOptions(data []byte, opts LoadOptions) (*MerkleTree, error) {
+	serialized, err := decodeManifest(data, opts.Workers)
+	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal tree: %w", err)
 	}
 
+	// Upgrade older manifests to the current format before reading them
+	schemaVersion := max(serialized.SchemaVersion, 1)
+	if err := Migrate(&serialized); err != nil {
+		return nil, err
+	}
+	// Directory hashes are omitted when empty, and may have been stripped
+	if err := migrateDirectories(&serialized); err != nil {
+		return nil, err
+	}
+	t := deserialize(&serialized, schemaVersion)
+	if err := CheckStructure(t); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return t, nil
+}
+
+// deserialize returns the tree of an upgraded manifest; schemaVersion is
+// the version it was written with
+func deserialize(serialized *SerializedTree, schemaVersion int) *MerkleTree {
 	// Calculate total size from the tree and rebuild Files map with absolute paths
 	var totalSize int64
 	var collectLeaves func(*Node)
@@ -78,26 +450,71 @@ func Load(path string) (*MerkleTree, error) {
 		}
 		if node.Path != "" {
 			// This is a leaf node
-			totalSize += node.Size
 			// Convert relative path to absolute path
-			absolutePath := filepath.Join(serialized.Root, node.Path)
+			absolutePath := filepath.Join(serialized.Root, filepath.FromSlash(node.Path))
+			// A node paired with itself is reached twice; count it once
+			if _, seen := files[absolutePath]; seen {
+				return
+			}
+			totalSize += node.Size
+			fileData := FileData{
+				Hash: node.Hash,
+				Size: node.Size,
+				Dir:  node.Dir,
+			}
 			if node.MTime != 0 {
-				files[absolutePath] = FileData{
-					Hash:    node.Hash,
-					Size:    node.Size,
-					ModTime: time.Unix(node.MTime, 0),
-				}
+				fileData.ModTime = time.Unix(node.MTime, 0)
+			}
+			if node.Allocated != nil {
+				fileData.Sparse = true
+				fileData.Allocated = *node.Allocated
 			}
+			fileData.Chunks = node.Chunks
+			fileData.CID = node.CID
+			fileData.Digests = node.Digests
+			fileData.Tags = node.Tags
+			fileData.Virtual = node.Virtual
+			fileData.Sampled = node.Sampled
+			files[absolutePath] = fileData
 		}
 		collectLeaves(node.Left)
 		collectLeaves(node.Right)
 	}
 	collectLeaves(serialized.Tree)
 
+	var scanErrors []ScanError
+	for _, scanErr := range serialized.Errors {
+		scanErrors = append(scanErrors, ScanError{
+			Path:  filepath.Join(serialized.Root, filepath.FromSlash(scanErr.Path)),
+			Error: scanErr.Error,
+		})
+	}
+
 	return &MerkleTree{
-		Root:      serialized.Tree,
-		RootPath:  serialized.Root,
-		TotalSize: totalSize,
-		Files:     files,
-	}, nil
+		Root:        serialized.Tree,
+		RootPath:    serialized.Root,
+		TotalSize:   totalSize,
+		Files:       files,
+		Volume:      serialized.Volume,
+		Directories: serialized.Directories,
+		Portable:    serialized.Portable,
+		Errors:      scanErrors,
+		Scan:        serialized.Scan,
+		Stats:       serialized.Stats,
+		EmptyDirs:   serialized.EmptyDirs,
+		Archives:    serialized.Archives,
+		SegmentSize: serialized.SegmentSize,
+		ChunkSize:   serialized.ChunkSize,
+		CIDs:        serialized.CIDs,
+		Fingerprint: serialized.Fingerprint,
+
+		BytewiseOrder:    serialized.BytewiseOrder,
+		MetadataOnly:     serialized.MetadataOnly,
+		Redacted:         serialized.Redacted,
+		HashAlgorithm:    serialized.HashAlgorithm,
+		DigestAlgorithms: serialized.Digests,
+		Created:          serialized.Created,
+		GeneratorVersion: serialized.Version,
+		SchemaVersion:    schemaVersion,
+	}
 }
@@ -0,0 +1,751 @@
+package tree
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"merkle-go/internal/config"
+)
+
+func TestSaveLoad_RoundTrip(t *testing.T) {
+	files := map[string]FileData{
+		"/test/file1.txt":     {Hash: "aaaaaaaaaaaaaaaa", Size: 100, ModTime: time.Unix(1700000000, 0)},
+		"/test/sub/file2.txt": {Hash: "bbbbbbbbbbbbbbbb", Size: 200, ModTime: time.Unix(1700000001, 0)},
+	}
+
+	original, err := Build(files, "/test")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tree.json")
+	if err := Save(original, path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if loaded.Root.Hash != original.Root.Hash {
+		t.Errorf("Root hash mismatch: expected %s, got %s", original.Root.Hash, loaded.Root.Hash)
+	}
+	if loaded.TotalSize != 300 {
+		t.Errorf("Expected total size 300, got %d", loaded.TotalSize)
+	}
+	if len(loaded.Files) != 2 {
+		t.Errorf("Expected 2 files, got %d", len(loaded.Files))
+	}
+	if loaded.SchemaVersion != SchemaVersion {
+		t.Errorf("Expected schema version %d, got %d", SchemaVersion, loaded.SchemaVersion)
+	}
+}
+
+func TestLoad_LegacyManifest(t *testing.T) {
+	legacy := `{
+  "generator": "merkle-go",
+  "created": "2025-01-15T10:00:00Z",
+  "root": "/test",
+  "size": "100 B",
+  "tree": {"hash": "aaaaaaaaaaaaaaaa", "path": "file1.txt", "size": 100, "mtime": 1700000000}
+}`
+	path := filepath.Join(t.TempDir(), "legacy.json")
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load should accept manifests without schema_version: %v", err)
+	}
+	if loaded.SchemaVersion != 1 {
+		t.Errorf("Expected legacy manifest to be schema version 1, got %d", loaded.SchemaVersion)
+	}
+
+	// Re-saving keeps the original creation time and upgrades the schema
+	if err := Save(loaded, path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	upgraded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if upgraded.SchemaVersion != SchemaVersion {
+		t.Errorf("Expected upgraded schema version %d, got %d", SchemaVersion, upgraded.SchemaVersion)
+	}
+	if !upgraded.Created.Equal(loaded.Created) {
+		t.Errorf("Created time should be preserved, got %v", upgraded.Created)
+	}
+}
+
+func TestLoad_NewerSchemaVersion(t *testing.T) {
+	future := `{"generator": "merkle-go", "version": "v99.0.0", "schema_version": 999, "root": "/test", "tree": {"hash": "aa"}}`
+	path := filepath.Join(t.TempDir(), "future.json")
+	if err := os.WriteFile(path, []byte(future), 0644); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+
+	_, err := Load(path)
+	if err == nil {
+		t.Fatal("Load should reject manifests from a newer schema version")
+	}
+	if !strings.Contains(err.Error(), "v99.0.0") {
+		t.Errorf("Error should name the version that wrote the manifest, got: %v", err)
+	}
+}
+
+func TestSave_ForwardSlashPaths(t *testing.T) {
+	files := map[string]FileData{
+		filepath.Join("/test", "sub", "file.txt"): {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+	}
+
+	tree, err := Build(files, "/test")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if tree.Root.Path != "sub/file.txt" {
+		t.Errorf("Expected leaf path %q, got %q", "sub/file.txt", tree.Root.Path)
+	}
+}
+
+func TestLoad_LegacyWindowsManifest(t *testing.T) {
+	legacy := `{
+  "generator": "merkle-go",
+  "root": "C:\\data",
+  "tree": {"hash": "aaaaaaaaaaaaaaaa", "path": "sub\\file.txt", "size": 1, "mtime": 1700000000}
+}`
+	path := filepath.Join(t.TempDir(), "windows.json")
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	expected := filepath.Join(`C:\data`, "sub", "file.txt")
+	if _, ok := loaded.Files[expected]; !ok {
+		t.Errorf("Expected file %q, got %v", expected, loaded.Files)
+	}
+}
+
+func TestSaveLoad_Errors(t *testing.T) {
+	files := map[string]FileData{
+		"/test/file1.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 100},
+	}
+	original, err := Build(files, "/test")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	original.Errors = []ScanError{{Path: filepath.Join("/test", "sub", "locked.txt"), Error: "permission denied"}}
+
+	path := filepath.Join(t.TempDir(), "tree.json")
+	if err := Save(original, path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"path": "sub/locked.txt"`) {
+		t.Errorf("Expected error path relative to root in manifest, got:\n%s", data)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(loaded.Errors) != 1 {
+		t.Fatalf("Expected 1 error, got %d", len(loaded.Errors))
+	}
+	if loaded.Errors[0] != original.Errors[0] {
+		t.Errorf("Expected %+v, got %+v", original.Errors[0], loaded.Errors[0])
+	}
+}
+
+func TestSaveWithOptions(t *testing.T) {
+	first, _ := Build(map[string]FileData{"/test/a.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 1}}, "/test")
+	second, _ := Build(map[string]FileData{"/test/b.txt": {Hash: "bbbbbbbbbbbbbbbb", Size: 1}}, "/test")
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tree.json")
+	if err := Save(first, path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	if err := SaveWithOptions(second, path, SaveOptions{NoOverwrite: true}); !errors.Is(err, ErrExists) {
+		t.Fatalf("Expected ErrExists, got %v", err)
+	}
+
+	if err := SaveWithOptions(second, path, SaveOptions{Backup: true}); err != nil {
+		t.Fatalf("SaveWithOptions failed: %v", err)
+	}
+	saved, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if saved.Root.Hash != second.Root.Hash {
+		t.Errorf("Expected root %s, got %s", second.Root.Hash, saved.Root.Hash)
+	}
+	backup, err := Load(path + BackupSuffix)
+	if err != nil {
+		t.Fatalf("Load backup failed: %v", err)
+	}
+	if backup.Root.Hash != first.Root.Hash {
+		t.Errorf("Expected backup root %s, got %s", first.Root.Hash, backup.Root.Hash)
+	}
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Expected only the manifest and its backup, got %d entries", len(entries))
+	}
+}
+
+func TestSaveLoad_EmptyDirs(t *testing.T) {
+	files := map[string]FileData{
+		"/test/file.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+		"/test/empty":    {Hash: EmptyDirHash, ModTime: time.Unix(1700000000, 0), Dir: true},
+	}
+	original, err := BuildWithOptions(files, "/test", BuildOptions{EmptyDirs: true})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tree.json")
+	if err := Save(original, path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if !loaded.EmptyDirs {
+		t.Error("Expected EmptyDirs to be kept")
+	}
+	dir := loaded.Files[filepath.Join("/test", "empty")]
+	if !dir.Dir || dir.Hash != EmptyDirHash {
+		t.Errorf("Expected an empty directory marker, got %+v", dir)
+	}
+	if loaded.Root.Hash != original.Root.Hash {
+		t.Errorf("Expected root %s, got %s", original.Root.Hash, loaded.Root.Hash)
+	}
+}
+
+func TestSaveLoad_Archives(t *testing.T) {
+	files := map[string]FileData{
+		"/test/file.txt":          {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+		"/test/layer.tar!/etc/os": {Hash: "bbbbbbbbbbbbbbbb", Size: 2},
+	}
+	original, err := BuildWithOptions(files, "/test", BuildOptions{Archives: []string{"tar"}})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tree.json")
+	if err := Save(original, path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if len(loaded.Archives) != 1 || loaded.Archives[0] != "tar" {
+		t.Errorf("Expected archives [tar], got %v", loaded.Archives)
+	}
+	if _, ok := loaded.Files[filepath.Join("/test", "layer.tar!", "etc", "os")]; !ok {
+		t.Errorf("Expected the archive member leaf, got %v", loaded.Files)
+	}
+	if loaded.Root.Hash != original.Root.Hash {
+		t.Errorf("Expected root %s, got %s", original.Root.Hash, loaded.Root.Hash)
+	}
+}
+
+func TestSaveLoad_SegmentSize(t *testing.T) {
+	files := map[string]FileData{"/test/disk.img": {Hash: "aaaaaaaaaaaaaaaa", Size: 1 << 30}}
+	original, err := BuildWithOptions(files, "/test", BuildOptions{SegmentSize: 64 << 20})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tree.json")
+	if err := Save(original, path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if loaded.SegmentSize != 64<<20 {
+		t.Errorf("Expected segment size %d, got %d", 64<<20, loaded.SegmentSize)
+	}
+
+	whole, _ := BuildWithOptions(map[string]FileData{"/other/a": {Hash: "bbbbbbbbbbbbbbbb"}}, "/other", BuildOptions{})
+	if _, err := Merge([]*MerkleTree{loaded, whole}, ""); err == nil {
+		t.Error("Expected merging trees with different segment sizes to fail")
+	}
+}
+
+func TestSaveLoad_Chunks(t *testing.T) {
+	chunks := []Chunk{{Hash: "1111111111111111", Size: 8000}, {Hash: "2222222222222222", Size: 192}}
+	files := map[string]FileData{
+		"/test/big.bin": {Hash: "aaaaaaaaaaaaaaaa", Size: 8192, Chunks: chunks},
+		"/test/empty":   {Hash: "bbbbbbbbbbbbbbbb"},
+	}
+	original, err := BuildWithOptions(files, "/test", BuildOptions{ChunkSize: 8192})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tree.json")
+	if err := Save(original, path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if loaded.ChunkSize != 8192 {
+		t.Errorf("Expected chunk size 8192, got %d", loaded.ChunkSize)
+	}
+	got := loaded.Files[filepath.Join("/test", "big.bin")].Chunks
+	if len(got) != 2 || got[0] != chunks[0] || got[1] != chunks[1] {
+		t.Errorf("Expected chunks %v, got %v", chunks, got)
+	}
+	if loaded.Root.Hash != original.Root.Hash {
+		t.Error("Chunks should not change the root hash")
+	}
+
+	// Merging with a tree without chunks drops them
+	other, _ := BuildWithOptions(map[string]FileData{"/other/a": {Hash: "cccccccccccccccc"}}, "/other", BuildOptions{})
+	merged, err := Merge([]*MerkleTree{loaded, other}, "")
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if merged.ChunkSize != 0 || merged.Files[filepath.Join("/test", "big.bin")].Chunks != nil {
+		t.Error("Expected merging with an unchunked tree to drop the chunks")
+	}
+}
+
+func TestSaveLoad_Digests(t *testing.T) {
+	original, err := BuildWithOptions(map[string]FileData{
+		"/test/a.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 1, Digests: map[string]string{"sha256": "a256", "md5": "a5"}},
+		"/test/b.txt": {Hash: "bbbbbbbbbbbbbbbb", Size: 2, Digests: map[string]string{"sha256": "b256", "md5": "b5"}},
+	}, "/test", BuildOptions{DigestAlgorithms: []string{"sha256", "md5"}})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "tree.json")
+	if err := Save(original, path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if strings.Join(loaded.Algorithms(), ",") != "xxh64,sha256,md5" {
+		t.Errorf("Expected xxh64, sha256 and md5 digests, got %v", loaded.Algorithms())
+	}
+	data := loaded.Files[filepath.Join("/test", "a.txt")]
+	if digest, ok := loaded.Digest(data, "SHA256"); !ok || digest != "a256" {
+		t.Errorf("Expected the sha256 digest to be kept, got %q", digest)
+	}
+	if digest, ok := loaded.Digest(data, "xxh64"); !ok || digest != data.Hash {
+		t.Errorf("Expected the leaf hash as the xxh64 digest, got %q", digest)
+	}
+	if _, ok := loaded.Digest(data, "sha1"); ok {
+		t.Error("Expected no sha1 digest")
+	}
+	if loaded.Root.Hash != original.Root.Hash {
+		t.Error("Other digests should not change the root hash")
+	}
+
+	// Merging keeps the digests every tree records
+	other, _ := BuildWithOptions(map[string]FileData{
+		"/other/c": {Hash: "cccccccccccccccc", Digests: map[string]string{"sha256": "c256"}},
+	}, "/other", BuildOptions{DigestAlgorithms: []string{"sha256"}})
+	merged, err := Merge([]*MerkleTree{loaded, other}, "")
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if strings.Join(merged.DigestAlgorithms, ",") != "sha256" || len(merged.Files[filepath.Join("/test", "b.txt")].Digests) != 1 {
+		t.Errorf("Expected only the sha256 digests to be kept, got %v", merged.DigestAlgorithms)
+	}
+
+	if _, err := AddFile(loaded, "c.txt", FileData{Hash: "cccccccccccccccc", Digests: map[string]string{"sha256": "c256"}}); err == nil {
+		t.Error("Expected a file without an md5 digest to be refused")
+	}
+}
+
+func TestSaveLoad_CIDs(t *testing.T) {
+	const cid = "bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e"
+	original, err := BuildWithOptions(map[string]FileData{
+		"/test/hello.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 11, CID: cid},
+	}, "/test", BuildOptions{CIDs: true})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tree.json")
+	if err := Save(original, path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !loaded.CIDs || loaded.Files[filepath.Join("/test", "hello.txt")].CID != cid {
+		t.Errorf("Expected the CID to be kept, got %+v", loaded.Files)
+	}
+	if loaded.Root.Hash != original.Root.Hash {
+		t.Error("CIDs should not change the root hash")
+	}
+
+	// Merging with a tree without CIDs drops them
+	other, _ := BuildWithOptions(map[string]FileData{"/other/a": {Hash: "cccccccccccccccc"}}, "/other", BuildOptions{})
+	merged, err := Merge([]*MerkleTree{loaded, other}, "")
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if merged.CIDs || merged.Files[filepath.Join("/test", "hello.txt")].CID != "" {
+		t.Error("Expected merging with a tree without CIDs to drop them")
+	}
+}
+
+func TestSaveLoad_Tags(t *testing.T) {
+	tags := map[string]string{"owner": "finance", "classification": "confidential"}
+	original, err := Build(map[string]FileData{
+		"/test/ledger.xlsx": {Hash: "aaaaaaaaaaaaaaaa", Size: 11, Tags: tags},
+		"/test/readme.txt":  {Hash: "bbbbbbbbbbbbbbbb", Size: 5},
+	}, "/test")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	untagged, err := Build(map[string]FileData{
+		"/test/ledger.xlsx": {Hash: "aaaaaaaaaaaaaaaa", Size: 11},
+		"/test/readme.txt":  {Hash: "bbbbbbbbbbbbbbbb", Size: 5},
+	}, "/test")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if original.Root.Hash != untagged.Root.Hash {
+		t.Error("Tags should not change the root hash")
+	}
+
+	path := filepath.Join(t.TempDir(), "tree.json")
+	if err := Save(original, path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := loaded.Files[filepath.Join("/test", "ledger.xlsx")].Tags; !maps.Equal(got, tags) {
+		t.Errorf("Expected tags %v to be kept, got %v", tags, got)
+	}
+	if got := loaded.Files[filepath.Join("/test", "readme.txt")].Tags; got != nil {
+		t.Errorf("Expected no tags on readme.txt, got %v", got)
+	}
+
+	// A patch carries tag changes even though the root hash is the same
+	patch := Diff(untagged, loaded)
+	if len(patch.Changes) != 1 || patch.Changes[0].Path != "ledger.xlsx" {
+		t.Errorf("Expected the patch to set ledger.xlsx, got %+v", patch.Changes)
+	}
+}
+
+func TestSaveLoad_Virtual(t *testing.T) {
+	original, err := Build(map[string]FileData{
+		"/test/dumps/db.sql": {Hash: "aaaaaaaaaaaaaaaa", Size: 11, Virtual: true},
+		"/test/readme.txt":   {Hash: "bbbbbbbbbbbbbbbb", Size: 5},
+	}, "/test")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tree.json")
+	if err := Save(original, path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !loaded.Files[filepath.Join("/test", "dumps", "db.sql")].Virtual {
+		t.Error("Expected dumps/db.sql to stay virtual")
+	}
+	if loaded.Files[filepath.Join("/test", "readme.txt")].Virtual {
+		t.Error("Expected readme.txt not to be virtual")
+	}
+}
+
+func TestSaveLoad_Sampled(t *testing.T) {
+	rules := []config.FingerprintRule{{Paths: []string{"*.mkv"}, Bytes: 1024}}
+	original, err := BuildWithOptions(map[string]FileData{
+		"/test/film.mkv":   {Hash: "aaaaaaaaaaaaaaaa", Size: 1 << 20, Sampled: 1024},
+		"/test/readme.txt": {Hash: "bbbbbbbbbbbbbbbb", Size: 5},
+	}, "/test", BuildOptions{Fingerprint: rules})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tree.json")
+	if err := Save(original, path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if loaded.Files[filepath.Join("/test", "film.mkv")].Sampled != 1024 || loaded.Files[filepath.Join("/test", "readme.txt")].Sampled != 0 {
+		t.Error("Expected only film.mkv to be marked as sampled")
+	}
+	if len(loaded.Fingerprint) != 1 || loaded.Fingerprint[0].Bytes != 1024 {
+		t.Errorf("Expected the fingerprint rules to be kept, got %v", loaded.Fingerprint)
+	}
+
+	// A sample covering the whole file is not a sample
+	original.Root.Sampled = 0
+	leaf := original.Root.Left
+	leaf.Sampled = leaf.Size
+	if err := CheckStructure(original); err == nil {
+		t.Error("Expected a sample larger than half the file to be rejected")
+	}
+}
+
+func TestSaveLoad_MetadataOnly(t *testing.T) {
+	modTime := time.Unix(1700000000, 0)
+	original, err := Build(map[string]FileData{
+		"/test/a.txt": {Hash: MetadataHash(12, modTime), Size: 12, ModTime: modTime},
+	}, "/test")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	original.MetadataOnly = true
+
+	path := filepath.Join(t.TempDir(), "tree.json")
+	if err := Save(original, path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !loaded.MetadataOnly {
+		t.Error("Expected the tree to stay metadata-only")
+	}
+	if MetadataHash(12, modTime) == MetadataHash(13, modTime) || MetadataHash(12, modTime) == MetadataHash(12, modTime.Add(time.Second)) {
+		t.Error("Expected MetadataHash to depend on size and modification time")
+	}
+}
+
+func TestEncode_Stable(t *testing.T) {
+	build := func(created time.Time, errs []ScanError, archives []string) []byte {
+		t.Helper()
+		tree, err := Build(map[string]FileData{
+			"/test/b.txt":     {Hash: "bbbbbbbbbbbbbbbb", Size: 2, Tags: map[string]string{"z": "1", "a": "2"}},
+			"/test/a/c.txt":   {Hash: "cccccccccccccccc", Size: 3},
+			"/test/a/b/d.txt": {Hash: "dddddddddddddddd", Size: 4},
+		}, "/test")
+		if err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+		tree.Created = created
+		tree.Errors = errs
+		tree.Archives = archives
+		data, err := Encode(tree)
+		if err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+		return data
+	}
+
+	instant := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	first := build(instant,
+		[]ScanError{{Path: "/test/x", Error: "denied"}, {Path: "/test/a/y", Error: "denied"}},
+		[]string{"zip", "tar"})
+	second := build(instant.In(time.FixedZone("CET", 3600)).Add(999*time.Millisecond),
+		[]ScanError{{Path: "/test/a/y", Error: "denied"}, {Path: "/test/x", Error: "denied"}},
+		[]string{"tar", "zip"})
+	if string(first) != string(second) {
+		t.Errorf("Expected identical manifests, got\n%s\nand\n%s", first, second)
+	}
+	if !strings.Contains(string(first), `"created": "2026-01-02T03:04:05Z"`) {
+		t.Errorf("Expected created time in UTC to the second, got\n%s", first)
+	}
+
+	// A loaded manifest saves back to the same bytes
+	path := filepath.Join(t.TempDir(), "tree.json")
+	if err := os.WriteFile(path, first, 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	again, err := Encode(loaded)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if string(again) != string(first) {
+		t.Errorf("Expected the loaded manifest to encode identically, got\n%s\nwant\n%s", again, first)
+	}
+}
+
+func TestSaveLoad_ScanParams(t *testing.T) {
+	original, err := Build(map[string]FileData{"/test/a.txt": {Hash: "aaaaaaaaaaaaaaaa", Size: 1}}, "/test")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	original.Scan = &ScanParams{ConfigHash: "0123456789abcdef", Skip: []string{"*.log"}, Workers: 4, Duration: 3 * time.Second}
+
+	path := filepath.Join(t.TempDir(), "tree.json")
+	if err := Save(original, path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if loaded.Scan == nil || loaded.Scan.ConfigHash != "0123456789abcdef" || loaded.Scan.Workers != 4 ||
+		loaded.Scan.Duration != 3*time.Second || len(loaded.Scan.Skip) != 1 {
+		t.Errorf("Expected scan parameters to round-trip, got %+v", loaded.Scan)
+	}
+}
+
+func TestEncodeTo_MatchesJSON(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 5, 8} {
+		files := make(map[string]FileData)
+		for i := range n {
+			data := FileData{Hash: fmt.Sprintf("%016x", i+1), Size: int64(i), ModTime: time.Unix(1700000000, 0)}
+			if i == 1 {
+				data.Tags = map[string]string{"owner": "a<b>&c"}
+				data.Chunks = []Chunk{{Hash: "aaaaaaaaaaaaaaaa", Size: 1}}
+			}
+			files[fmt.Sprintf("/test/dir%d/file%d.txt", i%2, i)] = data
+		}
+		built, err := Build(files, "/test")
+		if err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+		built.Created = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		// The streamed encoding is what encoding/json makes of the whole document
+		serialized := serialize(built)
+		serialized.Tree = built.Root
+		indented, err := json.MarshalIndent(serialized, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		compact, err := json.Marshal(serialized)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := EncodeTo(&buf, built, false); err != nil {
+			t.Fatalf("EncodeTo failed: %v", err)
+		}
+		if buf.String() != string(indented) {
+			t.Errorf("%d files: expected\n%s\ngot\n%s", n, indented, buf.String())
+		}
+		buf.Reset()
+		if err := EncodeTo(&buf, built, true); err != nil {
+			t.Fatalf("EncodeTo failed: %v", err)
+		}
+		if buf.String() != string(compact)+"\n" {
+			t.Errorf("%d files: expected\n%s\ngot\n%s", n, compact, buf.String())
+		}
+
+		// A compact manifest loads as the same tree
+		path := filepath.Join(t.TempDir(), "tree.json")
+		if err := SaveWithOptions(built, path, SaveOptions{Compact: true}); err != nil {
+			t.Fatalf("SaveWithOptions failed: %v", err)
+		}
+		loaded, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if loaded.Root.Hash != built.Root.Hash || len(loaded.Files) != n {
+			t.Errorf("%d files: compact manifest loaded as root %s with %d files", n, loaded.Root.Hash, len(loaded.Files))
+		}
+	}
+}
+
+func TestSaveLoad_Workers(t *testing.T) {
+	for _, n := range []int{0, 1, 3, 37, 300} {
+		files := make(map[string]FileData)
+		for i := range n {
+			data := FileData{Hash: fmt.Sprintf("%016x", i+1), Size: int64(i), ModTime: time.Unix(1700000000, 0)}
+			if i%7 == 0 {
+				data.Tags = map[string]string{"owner": "a<b>&c"}
+			}
+			files[fmt.Sprintf("/test/dir%d/file%d.txt", i%5, i)] = data
+		}
+		built, err := Build(files, "/test")
+		if err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+		built.Created = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		// Shards are written in order, so the manifest does not change
+		for _, compact := range []bool{false, true} {
+			var serial bytes.Buffer
+			if err := EncodeTo(&serial, built, compact); err != nil {
+				t.Fatalf("EncodeTo failed: %v", err)
+			}
+			for _, workers := range []int{2, 8} {
+				var parallel bytes.Buffer
+				if err := encodeTo(&parallel, built, compact, workers); err != nil {
+					t.Fatalf("encodeTo failed: %v", err)
+				}
+				if parallel.String() != serial.String() {
+					t.Errorf("%d files, %d workers, compact %v: expected\n%s\ngot\n%s", n, workers, compact, serial.String(), parallel.String())
+				}
+			}
+		}
+
+		path := filepath.Join(t.TempDir(), "tree.json")
+		if err := SaveWithOptions(built, path, SaveOptions{Workers: 4}); err != nil {
+			t.Fatalf("SaveWithOptions failed: %v", err)
+		}
+		serial, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		parallel, err := LoadWithOptions(path, LoadOptions{Workers: 4})
+		if err != nil {
+			t.Fatalf("LoadWithOptions failed: %v", err)
+		}
+		if !reflect.DeepEqual(parallel, serial) {
+			t.Errorf("%d files: expected the tree decoded by workers to equal\n%+v\ngot\n%+v", n, serial, parallel)
+		}
+	}
+
+	// A malformed subtree fails the load
+	data := []byte(`{"generator":"merkle-go","root":"/test","tree":{"hash":"a","left":{"hash":"b","left":{"hash":"c","left":{"hash":"d","left":{"hash":"e","left":{"hash":1}}}}}}}`)
+	if _, err := DecodeWithOptions(data, LoadOptions{Workers: 8}); err == nil {
+		t.Error("Expected a malformed subtree to fail decoding")
+	}
+	if _, err := DecodeWithOptions(append(data[:len(data):len(data)], "x"...), LoadOptions{Workers: 8}); err == nil {
+		t.Error("Expected data after the manifest to fail decoding")
+	}
+}
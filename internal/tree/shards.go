@@ -0,0 +1,311 @@
+package tree
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/bits"
+	"strings"
+	"sync"
+)
+
+// shardsPerWorker is how many subtrees each worker gets when a manifest is
+// encoded or decoded in parallel, so one deep subtree does not hold back the
+// others
+const shardsPerWorker = 4
+
+// shardDepth returns the depth of the nodes that root the shards of a
+// manifest handled by workers at once, or -1 to handle it in one piece
+func shardDepth(workers int) int {
+	if workers <= 1 {
+		return -1
+	}
+	return bits.Len(uint(workers*shardsPerWorker - 1))
+}
+
+// shardRoots returns the nodes at depth below root in the order the
+// encoder reaches them, a node paired with itself appearing twice
+func shardRoots(root *Node, depth int) []*Node {
+	var roots []*Node
+	var walk func(n *Node, d int)
+	walk = func(n *Node, d int) {
+		if n == nil {
+			return
+		}
+		if d == depth {
+			roots = append(roots, n)
+			return
+		}
+		walk(n.Left, d+1)
+		walk(n.Right, d+1)
+	}
+	walk(root, 0)
+	return roots
+}
+
+type encodedShard struct {
+	data []byte
+	err  error
+}
+
+// encodeShards starts encoding the subtrees at e.shardDepth, at most workers
+// at a time, each into its own buffer. node takes the encodings in order;
+// the returned function stops the encoding once the manifest is written.
+func (e *nodeEncoder) encodeShards(root *Node, workers int) func() {
+	roots := shardRoots(root, e.shardDepth)
+	e.shards = make([]chan encodedShard, len(roots))
+	for i := range e.shards {
+		e.shards[i] = make(chan encodedShard, 1)
+	}
+	e.slots = make(chan struct{}, workers)
+	done := make(chan struct{})
+	prefix := strings.Repeat("  ", e.shardDepth+1)
+	go func() {
+		for i, n := range roots {
+			select {
+			case e.slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func() {
+				var buf bytes.Buffer
+				shard := &nodeEncoder{w: &buf, compact: e.compact, shardDepth: -1}
+				err := shard.node(n, prefix, 0)
+				e.shards[i] <- encodedShard{data: buf.Bytes(), err: err}
+			}()
+		}
+	}()
+	return func() { close(done) }
+}
+
+// nextShard writes the encoding of the next shard
+func (e *nodeEncoder) nextShard() error {
+	shard := <-e.shards[e.next]
+	e.next++
+	<-e.slots
+	if shard.err != nil {
+		return shard.err
+	}
+	e.write(shard.data)
+	return e.err
+}
+
+// decodeManifest unmarshals a manifest like json.Unmarshal, decoding the
+// subtrees at the depth chosen for workers at once
+func decodeManifest(data []byte, workers int) (SerializedTree, error) {
+	var serialized SerializedTree
+	depth := shardDepth(workers)
+	if depth < 0 {
+		err := json.Unmarshal(data, &serialized)
+		return serialized, err
+	}
+
+	d := &nodeDecoder{data: data, depth: depth, slots: make(chan struct{}, workers)}
+	fields, err := d.object(func(key string) (bool, error) {
+		if !strings.EqualFold(key, "tree") {
+			return false, nil
+		}
+		root, err := d.node(0)
+		serialized.Tree = root
+		return true, err
+	})
+	d.wg.Wait()
+	if err == nil {
+		err = d.err
+	}
+	if err == nil && d.skipSpace() < len(data) {
+		err = fmt.Errorf("invalid character %q after manifest", data[d.pos])
+	}
+	if err != nil {
+		return serialized, err
+	}
+	// The header fields are few; let encoding/json match them as usual
+	tree := serialized.Tree
+	header, err := json.Marshal(fields)
+	if err == nil {
+		err = json.Unmarshal(header, &serialized)
+	}
+	serialized.Tree = tree
+	return serialized, err
+}
+
+// nodeDecoder walks the top levels of a tree of nodes and hands each
+// subtree at depth to a goroutine to unmarshal. It only finds where values
+// start and end, leaving encoding/json to check them. The first error of
+// the goroutines sticks.
+type nodeDecoder struct {
+	data  []byte
+	pos   int
+	depth int
+	slots chan struct{}
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	err   error
+}
+
+// object reads a JSON object, passing each key to field, which consumes
+// the value and reports true or leaves it to be returned among the fields
+func (d *nodeDecoder) object(field func(key string) (bool, error)) (map[string]json.RawMessage, error) {
+	if err := d.expect('{'); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]json.RawMessage)
+	if d.skipSpace() < len(d.data) && d.data[d.pos] == '}' {
+		d.pos++
+		return fields, nil
+	}
+	for {
+		d.skipSpace()
+		raw, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		var key string
+		if err := json.Unmarshal(raw, &key); err != nil {
+			return nil, fmt.Errorf("invalid object key: %w", err)
+		}
+		if err := d.expect(':'); err != nil {
+			return nil, err
+		}
+		d.skipSpace()
+		if done, err := field(key); err != nil {
+			return nil, err
+		} else if !done {
+			if fields[key], err = d.value(); err != nil {
+				return nil, err
+			}
+		}
+
+		if d.skipSpace() >= len(d.data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		d.pos++
+		switch d.data[d.pos-1] {
+		case ',':
+		case '}':
+			return fields, nil
+		default:
+			return nil, fmt.Errorf("invalid character %q after object value", d.data[d.pos-1])
+		}
+	}
+}
+
+// node reads the node at depth, or null
+func (d *nodeDecoder) node(depth int) (*Node, error) {
+	if d.skipSpace() < len(d.data) && d.data[d.pos] != '{' {
+		raw, err := d.value()
+		if err != nil || string(raw) == "null" {
+			return nil, err
+		}
+		return nil, fmt.Errorf("expected a node, found %s", raw)
+	}
+	if depth == d.depth {
+		raw, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		n := new(Node)
+		d.slots <- struct{}{}
+		d.wg.Go(func() {
+			defer func() { <-d.slots }()
+			if err := json.Unmarshal(raw, n); err != nil {
+				d.mu.Lock()
+				d.err = cmp.Or(d.err, err)
+				d.mu.Unlock()
+			}
+		})
+		return n, nil
+	}
+
+	var left, right *Node
+	fields, err := d.object(func(key string) (bool, error) {
+		var err error
+		switch {
+		case strings.EqualFold(key, "left"):
+			left, err = d.node(depth + 1)
+		case strings.EqualFold(key, "right"):
+			right, err = d.node(depth + 1)
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	n := new(Node)
+	if err := json.Unmarshal(encoded, n); err != nil {
+		return nil, err
+	}
+	n.Left, n.Right = left, right
+	return n, nil
+}
+
+// value returns the JSON value at the current position and moves past it
+func (d *nodeDecoder) value() ([]byte, error) {
+	start, nesting, inString := d.pos, 0, false
+	for ; d.pos < len(d.data); d.pos++ {
+		c := d.data[d.pos]
+		switch {
+		case inString:
+			if c == '\\' {
+				d.pos++
+			} else if c == '"' {
+				inString = false
+				if nesting == 0 {
+					d.pos++
+					return d.data[start:d.pos], nil
+				}
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			nesting++
+		case c == '}' || c == ']':
+			if nesting == 0 {
+				return d.data[start:d.pos], nil
+			}
+			nesting--
+			if nesting == 0 {
+				d.pos++
+				return d.data[start:d.pos], nil
+			}
+		case nesting == 0 && (c == ',' || c == ' ' || c == '\t' || c == '\r' || c == '\n'):
+			return d.data[start:d.pos], nil
+		}
+	}
+	if nesting > 0 || inString {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return d.data[start:d.pos], nil
+}
+
+// skipSpace moves past whitespace and returns the new position
+func (d *nodeDecoder) skipSpace() int {
+	for d.pos < len(d.data) {
+		switch d.data[d.pos] {
+		case ' ', '\t', '\r', '\n':
+			d.pos++
+		default:
+			return d.pos
+		}
+	}
+	return d.pos
+}
+
+func (d *nodeDecoder) expect(c byte) error {
+	if d.skipSpace() >= len(d.data) {
+		return io.ErrUnexpectedEOF
+	}
+	if d.data[d.pos] != c {
+		return fmt.Errorf("invalid character %q, expected %q", d.data[d.pos], c)
+	}
+	d.pos++
+	return nil
+}
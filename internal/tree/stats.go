@@ -0,0 +1,50 @@
+package tree
+
+import "time"
+
+// ScanStats breaks down where the time of a scan went. Durations are
+// serialized in nanoseconds.
+type ScanStats struct {
+	Walk  time.Duration `json:"walk_ns"`
+	Hash  time.Duration `json:"hash_ns"`
+	Build time.Duration `json:"build_ns"`
+	Save  time.Duration `json:"save_ns,omitempty"` // Not known when the stats are embedded
+
+	Files   int   `json:"files"`   // Files hashed
+	Bytes   int64 `json:"bytes"`   // Bytes hashed
+	Workers int   `json:"workers"` // Hashing workers
+}
+
+// ScanParams are the settings a tree was scanned with, so that a rescan with
+// other settings can be pointed out before it shows up as changes
+type ScanParams struct {
+	ConfigHash string        `json:"config_hash,omitempty"` // hash of the settings deciding what is scanned and how
+	Skip       []string      `json:"skip,omitempty"`        // skip patterns
+	SkipRegex  []string      `json:"skip_regex,omitempty"`  // skip_regex rules, in order
+	Workers    int           `json:"workers,omitempty"`     // hashing workers
+	Duration   time.Duration `json:"duration_ns,omitempty"` // walk, hash and build time
+}
+
+// FilesPerSecond is the hashing rate in files
+func (s *ScanStats) FilesPerSecond() float64 {
+	if s.Hash <= 0 {
+		return 0
+	}
+	return float64(s.Files) / s.Hash.Seconds()
+}
+
+// BytesPerSecond is the hashing throughput of all workers together
+func (s *ScanStats) BytesPerSecond() float64 {
+	if s.Hash <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Hash.Seconds()
+}
+
+// BytesPerSecondPerWorker is the hashing throughput of an average worker
+func (s *ScanStats) BytesPerSecondPerWorker() float64 {
+	if s.Workers <= 0 {
+		return 0
+	}
+	return s.BytesPerSecond() / float64(s.Workers)
+}
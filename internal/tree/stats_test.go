@@ -0,0 +1,25 @@
+package tree
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScanStats_Rates(t *testing.T) {
+	stats := ScanStats{Hash: 2 * time.Second, Files: 100, Bytes: 4000, Workers: 4}
+
+	if got := stats.FilesPerSecond(); got != 50 {
+		t.Errorf("Expected 50 files/s, got %v", got)
+	}
+	if got := stats.BytesPerSecond(); got != 2000 {
+		t.Errorf("Expected 2000 bytes/s, got %v", got)
+	}
+	if got := stats.BytesPerSecondPerWorker(); got != 500 {
+		t.Errorf("Expected 500 bytes/s per worker, got %v", got)
+	}
+
+	var empty ScanStats
+	if empty.FilesPerSecond() != 0 || empty.BytesPerSecondPerWorker() != 0 {
+		t.Error("Expected zero rates without hashing time")
+	}
+}
@@ -0,0 +1,719 @@
+package tree
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+
+	"merkle-go/internal/hash"
+)
+
+// DefaultRunSize is the number of leaves a StreamBuilder keeps in memory
+// before spilling them to a sorted run file
+const DefaultRunSize = 1 << 20
+
+// internalHashSize is the size of an internal node hash; internal nodes are
+// always xxh64
+const internalHashSize = 8
+
+// StreamBuilder builds the same tree as BuildWithOptions without holding
+// the files or nodes in memory, for trees too large for RAM. Leaves are
+// collected in sorted runs spilled to disk and merged (an external sort),
+// then the tree is built bottom-up with one file per level. Save writes the
+// manifest straight from those files. Only the directory hashes are kept
+// in memory.
+//
+// Every path may be added only once. Call Close to remove the spill files.
+type StreamBuilder struct {
+	// RunSize is the number of leaves buffered before spilling a run; set
+	// it before the first Add. Zero means DefaultRunSize.
+	RunSize int
+
+	rootPath  string
+	cleanRoot string
+	opts      BuildOptions
+	dir       string // Temporary directory holding the spill files
+
+	buffer    []*Node
+	runs      []string
+	count     int64 // Leaves added
+	totalSize int64
+
+	finished    bool
+	leavesPath  string   // Merged leaves, one JSON node per line
+	levels      []string // Level files above the leaves, raw hashes
+	levelCounts []int64  // Nodes per level, starting with the leaves
+	rootHash    string
+	directories map[string]string
+}
+
+// NewStreamBuilder returns a builder for a tree of the files under
+// rootPath that spills to a new directory under tempDir ("" for the
+// system default)
+func NewStreamBuilder(rootPath, tempDir string, opts BuildOptions) (*StreamBuilder, error) {
+	dir, err := os.MkdirTemp(tempDir, "merkle-go-build-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %w", err)
+	}
+	return &StreamBuilder{
+		rootPath:  rootPath,
+		cleanRoot: filepath.Clean(rootPath),
+		opts:      opts,
+		dir:       dir,
+	}, nil
+}
+
+// Add adds the file at path, an absolute path under the root
+func (b *StreamBuilder) Add(filePath string, data FileData) error {
+	if b.finished {
+		return errors.New("stream builder is already finished")
+	}
+
+	relPath := relativePath(b.cleanRoot, filePath)
+	if b.opts.Portable {
+		relPath = norm.NFC.String(relPath)
+	}
+	node := &Node{
+		Hash: data.Hash,
+		Path: filepath.ToSlash(relPath),
+		Size: data.Size,
+		Dir:  data.Dir,
+	}
+	if !data.ModTime.IsZero() && !b.opts.Portable {
+		node.MTime = data.ModTime.Unix()
+	}
+	if data.Sparse && !b.opts.Portable {
+		node.Allocated = &data.Allocated
+	}
+	node.Tags = data.Tags
+	node.Virtual = data.Virtual
+	node.Sampled = data.Sampled
+
+	b.buffer = append(b.buffer, node)
+	b.count++
+	b.totalSize += data.Size
+
+	runSize := b.RunSize
+	if runSize <= 0 {
+		runSize = DefaultRunSize
+	}
+	if len(b.buffer) >= runSize {
+		return b.spill()
+	}
+	return nil
+}
+
+// leafKey is the sort key of a leaf path: the byte-wise slash path for
+// portable and byte-wise ordered trees, the native path otherwise, matching
+// BuildWithOptions
+func (b *StreamBuilder) leafKey(slashPath string) string {
+	if b.opts.Portable || b.opts.BytewiseOrder {
+		return slashPath
+	}
+	return filepath.FromSlash(slashPath)
+}
+
+// spill sorts the buffered leaves and writes them to a new run file
+func (b *StreamBuilder) spill() error {
+	if len(b.buffer) == 0 {
+		return nil
+	}
+	slices.SortFunc(b.buffer, func(x, y *Node) int {
+		return strings.Compare(b.leafKey(x.Path), b.leafKey(y.Path))
+	})
+
+	runPath := filepath.Join(b.dir, fmt.Sprintf("run-%d.jsonl", len(b.runs)))
+	err := writeFileWith(runPath, func(w *bufio.Writer) error {
+		enc := json.NewEncoder(w)
+		for _, node := range b.buffer {
+			if err := enc.Encode(node); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to spill leaves: %w", err)
+	}
+
+	b.runs = append(b.runs, runPath)
+	b.buffer = b.buffer[:0]
+	return nil
+}
+
+// Finish merges the spilled runs and builds the levels of the tree. No
+// files can be added afterwards.
+func (b *StreamBuilder) Finish() error {
+	if b.finished {
+		return nil
+	}
+	if err := b.spill(); err != nil {
+		return err
+	}
+	b.buffer = nil
+	b.finished = true
+
+	if b.count == 0 {
+		emptyHash, err := hash.XXHashFunc([]byte("empty-tree"))
+		if err != nil {
+			return fmt.Errorf("failed to create empty tree hash: %w", err)
+		}
+		b.rootHash = hex.EncodeToString(emptyHash)
+		b.directories = make(map[string]string)
+		return nil
+	}
+
+	if err := b.mergeRuns(); err != nil {
+		return err
+	}
+	for b.levelCounts[len(b.levelCounts)-1] > 1 {
+		if err := b.buildLevel(); err != nil {
+			return err
+		}
+	}
+
+	if len(b.levels) == 0 {
+		return nil // A single leaf is its own root, set by mergeRuns
+	}
+	top, err := os.ReadFile(b.levels[len(b.levels)-1])
+	if err != nil {
+		return fmt.Errorf("failed to read root level: %w", err)
+	}
+	b.rootHash = hex.EncodeToString(top)
+	return nil
+}
+
+// mergeRuns merges the sorted runs into the leaves file, writing the first
+// level above the leaves and computing the directory hashes along the way
+func (b *StreamBuilder) mergeRuns() error {
+	merger, err := newRunMerger(b.runs, b.leafKey)
+	if err != nil {
+		return err
+	}
+	defer merger.close()
+
+	b.leavesPath = filepath.Join(b.dir, "leaves.jsonl")
+	levelPath := filepath.Join(b.dir, "level-1.bin")
+	leaves, err := os.Create(b.leavesPath)
+	if err != nil {
+		return fmt.Errorf("failed to create leaves file: %w", err)
+	}
+	defer leaves.Close()
+	level, err := os.Create(levelPath)
+	if err != nil {
+		return fmt.Errorf("failed to create level file: %w", err)
+	}
+	defer level.Close()
+	leavesOut := bufio.NewWriter(leaves)
+	levelOut := bufio.NewWriter(level)
+
+	dirs := newDirectoryStack()
+	var pending []byte // Left half of the next pair, if hasPending
+	var pendingHex string
+	hasPending := false
+	var parents int64
+	for {
+		line, node, err := merger.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := leavesOut.Write(line); err != nil {
+			return fmt.Errorf("failed to write leaves file: %w", err)
+		}
+		dirs.add(node.Path, node.Hash)
+
+		leafHash, _ := hex.DecodeString(node.Hash)
+		if !hasPending {
+			pending, pendingHex, hasPending = leafHash, node.Hash, true
+			continue
+		}
+		if _, err := levelOut.Write(pairHash(pending, leafHash)); err != nil {
+			return fmt.Errorf("failed to write level file: %w", err)
+		}
+		hasPending = false
+		parents++
+	}
+	if hasPending {
+		if b.count > 1 {
+			// Odd leaf: pair it with itself
+			if _, err := levelOut.Write(pairHash(pending, pending)); err != nil {
+				return fmt.Errorf("failed to write level file: %w", err)
+			}
+			parents++
+		} else {
+			b.rootHash = pendingHex
+		}
+	}
+
+	if err := leavesOut.Flush(); err != nil {
+		return fmt.Errorf("failed to write leaves file: %w", err)
+	}
+	if err := levelOut.Flush(); err != nil {
+		return fmt.Errorf("failed to write level file: %w", err)
+	}
+	b.directories = dirs.finish()
+	b.levelCounts = []int64{b.count}
+	if parents > 0 {
+		b.levels = append(b.levels, levelPath)
+		b.levelCounts = append(b.levelCounts, parents)
+	}
+	return nil
+}
+
+// buildLevel pairs the nodes of the highest level into a new level file
+func (b *StreamBuilder) buildLevel() error {
+	below := b.levels[len(b.levels)-1]
+	count := b.levelCounts[len(b.levelCounts)-1]
+	levelPath := filepath.Join(b.dir, fmt.Sprintf("level-%d.bin", len(b.levels)+1))
+
+	in, err := os.Open(below)
+	if err != nil {
+		return fmt.Errorf("failed to open level file: %w", err)
+	}
+	defer in.Close()
+	reader := bufio.NewReader(in)
+
+	err = writeFileWith(levelPath, func(w *bufio.Writer) error {
+		left := make([]byte, internalHashSize)
+		right := make([]byte, internalHashSize)
+		for i := int64(0); i < count; i += 2 {
+			if _, err := io.ReadFull(reader, left); err != nil {
+				return err
+			}
+			pair := left
+			if i+1 < count {
+				if _, err := io.ReadFull(reader, right); err != nil {
+					return err
+				}
+				pair = right
+			}
+			if _, err := w.Write(pairHash(left, pair)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build level: %w", err)
+	}
+
+	b.levels = append(b.levels, levelPath)
+	b.levelCounts = append(b.levelCounts, (count+1)/2)
+	return nil
+}
+
+// RootHash returns the root hash; valid after Finish
+func (b *StreamBuilder) RootHash() string {
+	return b.rootHash
+}
+
+// Count returns the number of leaves added
+func (b *StreamBuilder) Count() int64 {
+	return b.count
+}
+
+// Tree returns the tree built by Finish without its nodes and files: Root
+// only carries the root hash. Set fields such as Volume, Errors or Stats on
+// it before passing it to Save.
+func (b *StreamBuilder) Tree() *MerkleTree {
+	return &MerkleTree{
+		Root:        &Node{Hash: b.rootHash},
+		RootPath:    b.rootPath,
+		TotalSize:   b.totalSize,
+		Directories: b.directories,
+		Portable:    b.opts.Portable,
+
+		BytewiseOrder: b.opts.BytewiseOrder,
+		HashAlgorithm: b.opts.HashAlgorithm,
+		EmptyDirs:     b.opts.EmptyDirs,
+		Archives:      b.opts.Archives,
+		SegmentSize:   b.opts.SegmentSize,
+		Fingerprint:   b.opts.Fingerprint,
+
+		DigestAlgorithms: b.opts.DigestAlgorithms,
+	}
+}
+
+// Save writes the manifest of meta, a tree returned by Tree, to path like
+// SaveWithOptions, reading the nodes from the spill files. The tree is
+// written compactly rather than indented.
+func (b *StreamBuilder) Save(meta *MerkleTree, filePath string, opts SaveOptions) error {
+	if !b.finished {
+		return errors.New("stream builder is not finished")
+	}
+
+	header, err := json.Marshal(serialize(meta))
+	if err != nil {
+		return fmt.Errorf("failed to marshal tree: %w", err)
+	}
+	// The tree is the last field of the header; stream it in place of null
+	const nullTree = `"tree":null}`
+	if !bytes.HasSuffix(header, []byte(nullTree)) {
+		return errors.New("failed to marshal tree: unexpected manifest header")
+	}
+	header = header[:len(header)-len("null}")]
+
+	return saveFile(filePath, opts, func(f *os.File) error {
+		w := &manifestWriter{file: f, out: bufio.NewWriter(f)}
+		w.write(header)
+		if b.count == 0 {
+			w.write(fmt.Appendf(nil, `{"hash":%q}`, b.rootHash))
+		} else if err := b.writeTree(w); err != nil {
+			return err
+		}
+		w.write([]byte("}\n"))
+		if w.err != nil {
+			return w.err
+		}
+		return w.out.Flush()
+	})
+}
+
+// writeTree writes the nested nodes in the order of the Node JSON encoding.
+// Every level is read sequentially: a pre-order walk visits the nodes of a
+// level left to right, and the duplicated odd nodes are copied from the
+// output instead of being read again.
+func (b *StreamBuilder) writeTree(w *manifestWriter) error {
+	leaves, err := os.Open(b.leavesPath)
+	if err != nil {
+		return fmt.Errorf("failed to open leaves file: %w", err)
+	}
+	defer leaves.Close()
+	leafReader := bufio.NewReader(leaves)
+
+	levelReaders := make([]*bufio.Reader, len(b.levels))
+	for i, levelPath := range b.levels {
+		f, err := os.Open(levelPath)
+		if err != nil {
+			return fmt.Errorf("failed to open level file: %w", err)
+		}
+		defer f.Close()
+		levelReaders[i] = bufio.NewReader(f)
+	}
+
+	nodeHash := make([]byte, internalHashSize)
+	var writeNode func(level int, index int64) error
+	writeNode = func(level int, index int64) error {
+		if level == 0 {
+			line, err := leafReader.ReadBytes('\n')
+			if err != nil {
+				return fmt.Errorf("failed to read leaves file: %w", err)
+			}
+			w.write(bytes.TrimSuffix(line, []byte("\n")))
+			return w.err
+		}
+
+		if _, err := io.ReadFull(levelReaders[level-1], nodeHash); err != nil {
+			return fmt.Errorf("failed to read level file: %w", err)
+		}
+		w.write(fmt.Appendf(nil, `{"hash":"%s","left":`, hex.EncodeToString(nodeHash)))
+		start := w.offset
+		if err := writeNode(level-1, 2*index); err != nil {
+			return err
+		}
+		end := w.offset
+		w.write([]byte(`,"right":`))
+		if 2*index+1 < b.levelCounts[level-1] {
+			if err := writeNode(level-1, 2*index+1); err != nil {
+				return err
+			}
+		} else {
+			w.copy(start, end) // Odd node: duplicate the left child
+		}
+		w.write([]byte("}"))
+		return w.err
+	}
+	return writeNode(len(b.levels), 0)
+}
+
+// Close removes the spill files
+func (b *StreamBuilder) Close() error {
+	return os.RemoveAll(b.dir)
+}
+
+// pairHash returns the hash of the parent of two nodes
+func pairHash(left, right []byte) []byte {
+	combined := make([]byte, 0, len(left)+len(right))
+	combined = append(combined, left...)
+	combined = append(combined, right...)
+	parent, _ := hash.XXHashFunc(combined)
+	return parent
+}
+
+// writeFileWith creates path and fills it through a buffered writer
+func writeFileWith(path string, write func(w *bufio.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// manifestWriter writes to a file through a buffer, tracking the offset so
+// that written ranges can be copied again. The first error sticks.
+type manifestWriter struct {
+	file   *os.File
+	out    *bufio.Writer
+	offset int64
+	err    error
+}
+
+func (w *manifestWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.out.Write(p)
+	w.offset += int64(n)
+	w.err = err
+}
+
+// copy appends the bytes already written between start and end
+func (w *manifestWriter) copy(start, end int64) {
+	if w.err != nil {
+		return
+	}
+	if w.err = w.out.Flush(); w.err != nil {
+		return
+	}
+	n, err := io.Copy(w.out, io.NewSectionReader(w.file, start, end-start))
+	w.offset += n
+	w.err = err
+}
+
+// runMerger merges sorted run files of JSON leaves
+type runMerger struct {
+	files []*os.File
+	heap  runHeap
+}
+
+// runHead is the next leaf of a run
+type runHead struct {
+	reader *bufio.Reader
+	line   []byte
+	node   Node
+	key    string
+}
+
+func newRunMerger(runs []string, key func(string) string) (*runMerger, error) {
+	m := &runMerger{heap: runHeap{key: key}}
+	for _, runPath := range runs {
+		f, err := os.Open(runPath)
+		if err != nil {
+			m.close()
+			return nil, fmt.Errorf("failed to open run: %w", err)
+		}
+		m.files = append(m.files, f)
+		head := &runHead{reader: bufio.NewReader(f)}
+		ok, err := m.heap.advance(head)
+		if err != nil {
+			m.close()
+			return nil, err
+		}
+		if ok {
+			m.heap.heads = append(m.heap.heads, head)
+		}
+	}
+	heap.Init(&m.heap)
+	return m, nil
+}
+
+// next returns the smallest remaining leaf as its JSON line and decoded
+// node, or io.EOF
+func (m *runMerger) next() ([]byte, *Node, error) {
+	if len(m.heap.heads) == 0 {
+		return nil, nil, io.EOF
+	}
+	head := m.heap.heads[0]
+	line, node := head.line, head.node
+
+	ok, err := m.heap.advance(head)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ok {
+		heap.Fix(&m.heap, 0)
+	} else {
+		heap.Pop(&m.heap)
+	}
+	return line, &node, nil
+}
+
+func (m *runMerger) close() {
+	for _, f := range m.files {
+		f.Close()
+	}
+}
+
+// runHeap orders run heads by leaf key
+type runHeap struct {
+	heads []*runHead
+	key   func(string) string
+}
+
+// advance reads the next leaf of head, returning false at the end of the run
+func (h *runHeap) advance(head *runHead) (bool, error) {
+	line, err := head.reader.ReadBytes('\n')
+	if err == io.EOF && len(line) == 0 {
+		return false, nil
+	}
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("failed to read run: %w", err)
+	}
+	head.node = Node{}
+	if err := json.Unmarshal(line, &head.node); err != nil {
+		return false, fmt.Errorf("failed to decode run: %w", err)
+	}
+	if !bytes.HasSuffix(line, []byte("\n")) {
+		line = append(line, '\n')
+	}
+	head.line = line
+	head.key = h.key(head.node.Path)
+	return true, nil
+}
+
+func (h runHeap) Len() int           { return len(h.heads) }
+func (h runHeap) Less(i, j int) bool { return h.heads[i].key < h.heads[j].key }
+func (h runHeap) Swap(i, j int)      { h.heads[i], h.heads[j] = h.heads[j], h.heads[i] }
+func (h *runHeap) Push(x any)        { h.heads = append(h.heads, x.(*runHead)) }
+func (h *runHeap) Pop() any {
+	last := h.heads[len(h.heads)-1]
+	h.heads = h.heads[:len(h.heads)-1]
+	return last
+}
+
+// directoryStack computes directory hashes from leaves arriving in sorted
+// order. The leaves under a directory are contiguous, so a directory is
+// complete as soon as a leaf outside it arrives.
+type directoryStack struct {
+	open        []openDirectory
+	directories map[string]string
+}
+
+type openDirectory struct {
+	path string
+	acc  merkleAccumulator
+}
+
+func newDirectoryStack() *directoryStack {
+	return &directoryStack{directories: make(map[string]string)}
+}
+
+// add adds a leaf to every directory above it
+func (s *directoryStack) add(leafPath, leafHash string) {
+	for len(s.open) > 0 && !strings.HasPrefix(leafPath, s.open[len(s.open)-1].path+"/") {
+		s.close()
+	}
+
+	var ancestors []string
+	for dir := path.Dir(leafPath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if len(s.open) > 0 && dir == s.open[len(s.open)-1].path {
+			break
+		}
+		ancestors = append(ancestors, dir)
+	}
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		s.open = append(s.open, openDirectory{path: ancestors[i]})
+	}
+
+	for i := range s.open {
+		s.open[i].acc.add(leafHash)
+	}
+}
+
+// close completes the innermost open directory
+func (s *directoryStack) close() {
+	last := s.open[len(s.open)-1]
+	s.directories[last.path] = last.acc.root()
+	s.open = s.open[:len(s.open)-1]
+}
+
+// finish completes the open directories and returns all directory hashes
+func (s *directoryStack) finish() map[string]string {
+	for len(s.open) > 0 {
+		s.close()
+	}
+	return s.directories
+}
+
+// merkleAccumulator computes the root buildLevels would return for a
+// sequence of leaf hashes while keeping one pending node per level
+type merkleAccumulator struct {
+	pending []string // pending[h] is a left node at level h waiting for its pair
+}
+
+func (a *merkleAccumulator) add(leafHash string) {
+	node := leafHash
+	for level := 0; ; level++ {
+		if level == len(a.pending) {
+			a.pending = append(a.pending, node)
+			return
+		}
+		if a.pending[level] == "" {
+			a.pending[level] = node
+			return
+		}
+		node = pairHex(a.pending[level], node)
+		a.pending[level] = ""
+	}
+}
+
+// root folds the pending nodes into the root, pairing every odd last node
+// with itself
+func (a *merkleAccumulator) root() string {
+	top := len(a.pending) - 1
+	for top > 0 && a.pending[top] == "" {
+		top--
+	}
+
+	carry := ""
+	for level := 0; ; level++ {
+		var left string
+		if level < len(a.pending) {
+			left = a.pending[level]
+		}
+		switch {
+		case left != "" && carry != "":
+			carry = pairHex(left, carry)
+		case left != "":
+			if level == top {
+				return left
+			}
+			carry = pairHex(left, left)
+		case carry != "":
+			if level > top {
+				return carry
+			}
+			carry = pairHex(carry, carry)
+		}
+	}
+}
+
+// pairHex is pairHash for hex-encoded hashes
+func pairHex(left, right string) string {
+	leftBytes, _ := hex.DecodeString(left)
+	rightBytes, _ := hex.DecodeString(right)
+	return hex.EncodeToString(pairHash(leftBytes, rightBytes))
+}
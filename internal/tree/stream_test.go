@@ -0,0 +1,105 @@
+package tree
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// streamFiles returns n files spread over nested directories
+func streamFiles(n int) map[string]FileData {
+	files := make(map[string]FileData, n)
+	for i := 0; i < n; i++ {
+		path := fmt.Sprintf("/data/d%d/s%d/file%03d.txt", i%3, i%2, i)
+		files[path] = FileData{
+			Hash:    fmt.Sprintf("%016x", i*7919+1),
+			Size:    int64(i),
+			ModTime: time.Unix(int64(1700000000+i), 0),
+		}
+	}
+	return files
+}
+
+func TestStreamBuilder_MatchesBuild(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 3, 5, 8, 13, 33} {
+		files := streamFiles(n)
+		expected, err := Build(files, "/data")
+		if err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+
+		builder, err := NewStreamBuilder("/data", t.TempDir(), BuildOptions{})
+		if err != nil {
+			t.Fatalf("NewStreamBuilder failed: %v", err)
+		}
+		builder.RunSize = 4 // Spill several runs
+		for path, data := range files {
+			if err := builder.Add(path, data); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+		}
+		if err := builder.Finish(); err != nil {
+			t.Fatalf("Finish failed: %v", err)
+		}
+
+		if builder.RootHash() != expected.Root.Hash {
+			t.Errorf("%d files: expected root %s, got %s", n, expected.Root.Hash, builder.RootHash())
+		}
+		streamed := builder.Tree()
+		if len(expected.Directories) > 0 && !reflect.DeepEqual(streamed.Directories, expected.Directories) {
+			t.Errorf("%d files: expected directories %v, got %v", n, expected.Directories, streamed.Directories)
+		}
+
+		// The streamed manifest loads as the same tree
+		path := filepath.Join(t.TempDir(), "tree.json")
+		if err := builder.Save(streamed, path, SaveOptions{}); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		loaded, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if !reflect.DeepEqual(loaded.Root, expected.Root) {
+			t.Errorf("%d files: loaded tree differs from the one built in memory", n)
+		}
+		if len(loaded.Files) != n {
+			t.Errorf("%d files: expected %d loaded files, got %d", n, n, len(loaded.Files))
+		}
+
+		if err := builder.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+	}
+}
+
+func TestStreamBuilder_Portable(t *testing.T) {
+	files := map[string]FileData{
+		"/data/Cafe\u0301.txt": {Hash: "0000000000000001", Size: 1, ModTime: time.Unix(1700000000, 0)},
+		"/data/a/b.txt":        {Hash: "0000000000000002", Size: 2, ModTime: time.Unix(1700000000, 0)},
+		"/data/a.txt":          {Hash: "0000000000000003", Size: 3, ModTime: time.Unix(1700000000, 0)},
+	}
+	opts := BuildOptions{Portable: true}
+	expected, err := BuildWithOptions(files, "/data", opts)
+	if err != nil {
+		t.Fatalf("BuildWithOptions failed: %v", err)
+	}
+
+	builder, err := NewStreamBuilder("/data", t.TempDir(), opts)
+	if err != nil {
+		t.Fatalf("NewStreamBuilder failed: %v", err)
+	}
+	defer builder.Close()
+	for path, data := range files {
+		if err := builder.Add(path, data); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	if err := builder.Finish(); err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+	if builder.RootHash() != expected.Root.Hash {
+		t.Errorf("Expected root %s, got %s", expected.Root.Hash, builder.RootHash())
+	}
+}
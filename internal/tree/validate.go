@@ -0,0 +1,169 @@
+package tree
+
+import (
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+)
+
+// CorruptNodeError reports the first node of a tree that fails validation
+type CorruptNodeError struct {
+	Location string // steps from the root, e.g. "root.left.right"
+	Path     string // leaf path, if the node is a leaf
+	Reason   string
+}
+
+func (e *CorruptNodeError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("node %s (%s): %s", e.Location, e.Path, e.Reason)
+	}
+	return fmt.Sprintf("node %s: %s", e.Location, e.Reason)
+}
+
+// CheckStructure checks the shape of t without hashing anything: every
+// node has a hex hash, internal nodes have two children and no file
+// fields, and leaves have a relative path, no children and consistent
+// sizes. Load runs it on every manifest. It returns a *CorruptNodeError
+// for the first malformed node in pre-order.
+func CheckStructure(t *MerkleTree) error {
+	if t.Root == nil {
+		return fmt.Errorf("manifest has no tree")
+	}
+	// An empty tree is a lone root without a path
+	if t.Root.Path == "" && t.Root.Left == nil && t.Root.Right == nil {
+		return checkInternal(t.Root, "root", true)
+	}
+	return checkNode(t.Root, "root")
+}
+
+func checkNode(n *Node, location string) error {
+	if n.Path == "" {
+		if err := checkInternal(n, location, false); err != nil {
+			return err
+		}
+		if err := checkNode(n.Left, location+".left"); err != nil {
+			return err
+		}
+		return checkNode(n.Right, location+".right")
+	}
+
+	corrupt := func(format string, args ...any) error {
+		return &CorruptNodeError{Location: location, Path: n.Path, Reason: fmt.Sprintf(format, args...)}
+	}
+	if _, err := hex.DecodeString(n.Hash); err != nil || n.Hash == "" {
+		return corrupt("hash %q is not hex", n.Hash)
+	}
+	if n.Left != nil || n.Right != nil {
+		return corrupt("leaf has children")
+	}
+	if path.IsAbs(n.Path) || path.Clean(n.Path) != n.Path || n.Path == ".." || strings.HasPrefix(n.Path, "../") {
+		return corrupt("path is not a clean path under the root")
+	}
+	if n.Size < 0 {
+		return corrupt("negative size %d", n.Size)
+	}
+	if n.Allocated != nil && *n.Allocated < 0 {
+		return corrupt("negative allocated size %d", *n.Allocated)
+	}
+	if n.Sampled < 0 || n.Sampled > 0 && n.Size <= 2*n.Sampled {
+		return corrupt("sampled %d bytes from each end of a %d-byte file", n.Sampled, n.Size)
+	}
+	if n.Dir && (n.Hash != EmptyDirHash || n.Size != 0 || len(n.Chunks) > 0) {
+		return corrupt("empty directory marker with content")
+	}
+	if len(n.Chunks) > 0 {
+		var total int64
+		for _, chunk := range n.Chunks {
+			total += chunk.Size
+		}
+		if total != n.Size {
+			return corrupt("chunks add up to %d bytes, file has %d", total, n.Size)
+		}
+	}
+	return nil
+}
+
+// checkInternal checks the fields of an internal node; the root of an
+// empty tree has no children
+func checkInternal(n *Node, location string, empty bool) error {
+	corrupt := func(reason string) error {
+		return &CorruptNodeError{Location: location, Reason: reason}
+	}
+	if digest, err := hex.DecodeString(n.Hash); err != nil || len(digest) != internalHashSize {
+		return corrupt(fmt.Sprintf("hash %q is not a %d-byte hex digest", n.Hash, internalHashSize))
+	}
+	if !empty && (n.Left == nil || n.Right == nil) {
+		return corrupt("internal node without two children")
+	}
+	if n.Size != 0 || n.MTime != 0 || n.Dir || n.Allocated != nil || len(n.Chunks) > 0 ||
+		n.CID != "" || len(n.Digests) > 0 || len(n.Tags) > 0 || n.Virtual || n.Sampled != 0 {
+		return corrupt("internal node has file fields")
+	}
+	return nil
+}
+
+// Validate checks t thoroughly: it runs CheckStructure, then recomputes
+// every internal node hash from its children, the root hash from the
+// leaves and, if the tree records them, the directory hashes. Leaf hashes
+// can only be checked against the files themselves. Hash mismatches are
+// reported for the deepest node first, as a *CorruptNodeError.
+func Validate(t *MerkleTree) error {
+	if err := CheckStructure(t); err != nil {
+		return err
+	}
+
+	var checkHashes func(n *Node, location string) error
+	checkHashes = func(n *Node, location string) error {
+		if n.Left == nil {
+			return nil
+		}
+		if err := checkHashes(n.Left, location+".left"); err != nil {
+			return err
+		}
+		if err := checkHashes(n.Right, location+".right"); err != nil {
+			return err
+		}
+		if expected := pairHex(n.Left.Hash, n.Right.Hash); n.Hash != expected {
+			return &CorruptNodeError{Location: location, Reason: fmt.Sprintf("hash %s does not match its children (expected %s)", n.Hash, expected)}
+		}
+		return nil
+	}
+	if err := checkHashes(t.Root, "root"); err != nil {
+		return err
+	}
+
+	leaves := leavesOf(t.Root)
+	if len(leaves) == 0 {
+		return nil
+	}
+	root, err := buildLevels(leaves)
+	if err != nil {
+		return err
+	}
+	if root.Hash != t.Root.Hash {
+		return fmt.Errorf("root hash %s does not match the leaves (expected %s)", t.Root.Hash, root.Hash)
+	}
+	if len(t.Directories) == 0 {
+		return nil
+	}
+	directories, err := directoryHashes(leaves)
+	if err != nil {
+		return err
+	}
+	for _, dir := range slices.Sorted(maps.Keys(directories)) {
+		if stored, ok := t.Directories[dir]; !ok {
+			return fmt.Errorf("directory %s has no recorded hash", dir)
+		} else if stored != directories[dir] {
+			return fmt.Errorf("directory %s has hash %s, expected %s", dir, stored, directories[dir])
+		}
+	}
+	for _, dir := range slices.Sorted(maps.Keys(t.Directories)) {
+		if _, ok := directories[dir]; !ok {
+			return fmt.Errorf("directory %s is recorded but holds no files", dir)
+		}
+	}
+	return nil
+}
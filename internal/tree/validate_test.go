@@ -0,0 +1,97 @@
+package tree
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	build := func() *MerkleTree {
+		built, err := Build(map[string]FileData{
+			"/data/a.txt":     {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+			"/data/b.txt":     {Hash: "bbbbbbbbbbbbbbbb", Size: 2},
+			"/data/sub/c.txt": {Hash: "cccccccccccccccc", Size: 3, Chunks: []Chunk{{Hash: "dddddddddddddddd", Size: 3}}},
+		}, "/data")
+		if err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+		return built
+	}
+	if err := Validate(build()); err != nil {
+		t.Errorf("Expected a built tree to validate, got %v", err)
+	}
+	empty, err := Build(map[string]FileData{}, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if err := Validate(empty); err != nil {
+		t.Errorf("Expected an empty tree to validate, got %v", err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		corrupt  func(*MerkleTree)
+		location string // of the *CorruptNodeError, or "" for another error
+		reason   string
+	}{
+		{"leaf hash", func(m *MerkleTree) { m.Root.Left.Left.Hash = "0000000000000000" }, "root.left", "does not match its children"},
+		{"internal hash", func(m *MerkleTree) { m.Root.Left.Hash = "0000000000000000" }, "root.left", "does not match its children"},
+		{"root hash", func(m *MerkleTree) { m.Root.Hash = "0000000000000000" }, "root", "does not match its children"},
+		{"swapped subtrees", func(m *MerkleTree) {
+			m.Root.Left, m.Root.Right = m.Root.Right, m.Root.Left
+			m.Root.Hash = pairHex(m.Root.Left.Hash, m.Root.Right.Hash)
+		}, "", "does not match the leaves"},
+		{"directory hash", func(m *MerkleTree) { m.Directories["sub"] = "0000000000000000" }, "", "directory sub has hash"},
+		{"missing child", func(m *MerkleTree) { m.Root.Right = nil }, "root", "without two children"},
+		{"leaf with children", func(m *MerkleTree) { m.Root.Left.Left.Left = &Node{Hash: "aa"} }, "root.left.left", "leaf has children"},
+		{"escaping path", func(m *MerkleTree) { m.Root.Left.Right.Path = "../b.txt" }, "root.left.right", "not a clean path"},
+		{"negative size", func(m *MerkleTree) { m.Root.Left.Left.Size = -1 }, "root.left.left", "negative size"},
+		{"chunks", func(m *MerkleTree) { m.Root.Right.Left.Size = 4 }, "root.right.left", "chunks add up to 3 bytes"},
+		{"internal file fields", func(m *MerkleTree) { m.Root.Left.Size = 3 }, "root.left", "file fields"},
+		{"hex", func(m *MerkleTree) { m.Root.Right.Left.Hash = "not hex" }, "root.right.left", "not hex"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			corrupted := build()
+			tt.corrupt(corrupted)
+			err := Validate(corrupted)
+			if err == nil || !strings.Contains(err.Error(), tt.reason) {
+				t.Fatalf("Expected an error containing %q, got %v", tt.reason, err)
+			}
+			var nodeErr *CorruptNodeError
+			if errors.As(err, &nodeErr) != (tt.location != "") {
+				t.Fatalf("Unexpected error type %T: %v", err, err)
+			}
+			if nodeErr != nil && nodeErr.Location != tt.location {
+				t.Errorf("Expected the corrupt node at %s, got %s", tt.location, nodeErr.Location)
+			}
+		})
+	}
+}
+
+func TestDecode_Malformed(t *testing.T) {
+	malformed := `{
+  "generator": "merkle-go",
+  "schema_version": 4,
+  "root": "/data",
+  "tree": {"hash": "0000000000000000",
+    "left": {"hash": "aaaaaaaaaaaaaaaa", "path": "a.txt", "size": 1}}
+}`
+	_, err := Decode([]byte(malformed))
+	var nodeErr *CorruptNodeError
+	if !errors.As(err, &nodeErr) || nodeErr.Location != "root" {
+		t.Errorf("Expected a corrupt root node, got %v", err)
+	}
+
+	// Hashes are only checked by Validate
+	inconsistent := strings.Replace(malformed, `"path": "a.txt", "size": 1}}`,
+		`"path": "a.txt", "size": 1},
+    "right": {"hash": "aaaaaaaaaaaaaaaa", "path": "a.txt", "size": 1}}`, 1)
+	loaded, err := Decode([]byte(inconsistent))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if err := Validate(loaded); err == nil {
+		t.Error("Expected Validate to find the wrong root hash")
+	}
+}
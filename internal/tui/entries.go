@@ -0,0 +1,157 @@
+// Package tui is an interactive terminal browser for saved trees and the
+// differences between two of them
+package tui
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"merkle-go/internal/compare"
+	"merkle-go/internal/tree"
+)
+
+// Entry is a file or directory in the browsed hierarchy
+type Entry struct {
+	Name string
+	Path string // Relative to the tree root, with forward slashes; empty for the root
+	Dir  bool
+
+	// Hash is the file's content hash, or the directory's subtree hash
+	Hash string
+	// Size is the file's size, or the total size of the files under the
+	// directory that are still in the tree
+	Size int64
+	// Data is the file's data; nil for directories
+	Data *tree.FileData
+
+	// Change is the file's change, if any; Changes counts the changed
+	// files under a directory
+	Change  *compare.Change
+	Changes int
+
+	Children []*Entry
+	parent   *Entry
+}
+
+// buildEntries arranges the files of t, and those result reports deleted,
+// into a directory hierarchy. result may be nil.
+func buildEntries(t *tree.MerkleTree, result *compare.CompareResult) *Entry {
+	root := &Entry{Dir: true, Hash: t.Root.Hash}
+	dirs := map[string]*Entry{"": root}
+
+	var ensureDir func(relPath string) *Entry
+	ensureDir = func(relPath string) *Entry {
+		if dir, ok := dirs[relPath]; ok {
+			return dir
+		}
+		parent := ensureDir(parentPath(relPath))
+		dir := &Entry{Name: path.Base(relPath), Path: relPath, Dir: true, Hash: t.Directories[relPath], parent: parent}
+		parent.Children = append(parent.Children, dir)
+		dirs[relPath] = dir
+		return dir
+	}
+	relOf := func(p string) string {
+		rel, err := filepath.Rel(t.RootPath, p)
+		if err != nil {
+			return filepath.ToSlash(p)
+		}
+		return filepath.ToSlash(rel)
+	}
+	addFile := func(relPath string, data tree.FileData, change *compare.Change) {
+		if data.Dir {
+			// Empty directory markers are shown as the directory itself
+			ensureDir(relPath).Change = change
+			return
+		}
+		parent := ensureDir(parentPath(relPath))
+		parent.Children = append(parent.Children, &Entry{
+			Name: path.Base(relPath), Path: relPath, Hash: data.Hash, Size: data.Size,
+			Data: &data, Change: change, parent: parent,
+		})
+	}
+
+	changes := make(map[string]*compare.Change)
+	if result != nil {
+		for _, list := range [][]compare.Change{result.Added, result.Modified, result.Renamed, result.CaseRenamed, result.MetadataOnly} {
+			for i := range list {
+				changes[relOf(list[i].Path)] = &list[i]
+			}
+		}
+	}
+	for p, data := range t.Files {
+		relPath := relOf(p)
+		addFile(relPath, data, changes[relPath])
+	}
+	if result != nil {
+		for _, list := range [][]compare.Change{result.Deleted, result.Unreadable} {
+			for i := range list {
+				addFile(relOf(list[i].Path), *list[i].OldData, &list[i])
+			}
+		}
+	}
+
+	finish(root)
+	return root
+}
+
+// finish sorts the children of dir, directories first, and totals their
+// sizes and changes
+func finish(dir *Entry) {
+	sort.Slice(dir.Children, func(i, j int) bool {
+		a, b := dir.Children[i], dir.Children[j]
+		if a.Dir != b.Dir {
+			return a.Dir
+		}
+		return a.Name < b.Name
+	})
+	for _, child := range dir.Children {
+		if child.Dir {
+			finish(child)
+			dir.Changes += child.Changes
+			dir.Size += child.Size
+			continue
+		}
+		if child.Change != nil {
+			dir.Changes++
+		}
+		if child.Change == nil || child.Change.Type != compare.Deleted {
+			dir.Size += child.Size
+		}
+	}
+	if dir.Change != nil {
+		dir.Changes++
+	}
+}
+
+// parentPath returns the slash-separated parent of relPath, "" for entries
+// at the root
+func parentPath(relPath string) string {
+	if dir := path.Dir(relPath); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// search returns the entries under root whose path contains query, ignoring
+// case, or whose hash starts with it, in path order
+func search(root *Entry, query string) []*Entry {
+	query = strings.ToLower(query)
+	var matches []*Entry
+	var walk func(*Entry)
+	walk = func(e *Entry) {
+		for _, child := range e.Children {
+			if strings.Contains(strings.ToLower(child.Path), query) ||
+				child.Hash != "" && strings.HasPrefix(strings.ToLower(child.Hash), query) {
+				matches = append(matches, child)
+			}
+			if child.Dir {
+				walk(child)
+			}
+		}
+	}
+	walk(root)
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Path < matches[j].Path })
+	return matches
+}
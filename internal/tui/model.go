@@ -0,0 +1,414 @@
+package tui
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"merkle-go/internal/compare"
+	"merkle-go/internal/tree"
+)
+
+// ANSI escape sequences, matching the colors of compare reports
+const (
+	ansiReset   = "\x1b[0m"
+	ansiBold    = "\x1b[1m"
+	ansiReverse = "\x1b[7m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+	ansiYellow  = "\x1b[33m"
+	ansiBlue    = "\x1b[34m"
+	ansiCyan    = "\x1b[36m"
+)
+
+// changeMarkers are the markers and colors of compare's text report
+var changeMarkers = map[compare.ChangeType][2]string{
+	compare.Added:        {"+", ansiGreen},
+	compare.Modified:     {"~", ansiYellow},
+	compare.Deleted:      {"-", ansiRed},
+	compare.Renamed:      {">", ansiBlue},
+	compare.CaseRenamed:  {">", ansiBlue},
+	compare.MetadataOnly: {"*", ansiCyan},
+	compare.Unreadable:   {"!", ansiRed},
+}
+
+// Lines taken by everything but the listing: title, location, blank line,
+// blank line, details and help
+const chromeLines = 11
+
+// Model is the bubbletea model of the browser
+type Model struct {
+	title    string
+	rootPath string
+	root     *Entry
+	dir      *Entry
+	diff     bool
+	rows     []*Entry
+	cursor   int
+	offset   int
+	width    int
+	height   int
+
+	// changedOnly hides unchanged entries in diff mode
+	changedOnly bool
+
+	// typing is set while a search query is being entered; results holds
+	// the matches of query while they are listed
+	typing  bool
+	query   string
+	results []*Entry
+}
+
+// New returns a browser for t. If result is not nil, it is the comparison
+// of an older tree with t, and the changes it lists are marked.
+func New(t *tree.MerkleTree, result *compare.CompareResult) *Model {
+	root := buildEntries(t, result)
+	title := fmt.Sprintf("%s  root %s  %d files, %s", t.RootPath, t.Root.Hash, len(t.Files), tree.FormatSize(t.TotalSize))
+	if result != nil {
+		title += fmt.Sprintf("  %d changes", result.Count())
+	}
+	m := &Model{title: title, rootPath: t.RootPath, root: root, dir: root, diff: result != nil, width: 80, height: 24}
+	m.refresh()
+	return m
+}
+
+// Run shows the browser until the user quits
+func Run(m *Model) error {
+	_, err := tea.NewProgram(m, tea.WithAltScreen()).Run()
+	return err
+}
+
+// Init implements tea.Model
+func (m *Model) Init() tea.Cmd {
+	return nil
+}
+
+// Update implements tea.Model
+func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.scroll()
+	case tea.KeyMsg:
+		if m.typing {
+			m.typeQuery(msg)
+			return m, nil
+		}
+		return m, m.handleKey(msg)
+	}
+	return m, nil
+}
+
+// typeQuery edits the search query
+func (m *Model) typeQuery(msg tea.KeyMsg) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		m.typing = false
+		if m.query == "" {
+			m.closeResults()
+			return
+		}
+		m.results = search(m.root, m.query)
+		m.refresh()
+	case tea.KeyEsc:
+		m.typing = false
+		m.query = ""
+	case tea.KeyBackspace:
+		if m.query != "" {
+			_, size := utf8.DecodeLastRuneInString(m.query)
+			m.query = m.query[:len(m.query)-size]
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.query += string(msg.Runes)
+	}
+}
+
+// handleKey moves around the listing
+func (m *Model) handleKey(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "q", "ctrl+c":
+		return tea.Quit
+	case "up", "k":
+		m.move(-1)
+	case "down", "j":
+		m.move(1)
+	case "pgup":
+		m.move(-m.pageSize())
+	case "pgdown", " ":
+		m.move(m.pageSize())
+	case "home", "g":
+		m.move(-len(m.rows))
+	case "end", "G":
+		m.move(len(m.rows))
+	case "enter", "right", "l":
+		m.open()
+	case "left", "h", "backspace":
+		m.back()
+	case "esc":
+		if m.results != nil {
+			m.closeResults()
+		}
+	case "/":
+		m.typing = true
+		m.query = ""
+	case "c":
+		if m.diff {
+			m.changedOnly = !m.changedOnly
+			m.refresh()
+		}
+	}
+	return nil
+}
+
+// open enters the directory under the cursor, or from the search results
+// jumps to the entry under the cursor
+func (m *Model) open() {
+	selected := m.selected()
+	if selected == nil {
+		return
+	}
+	if m.results != nil {
+		m.closeResults()
+		if selected.Dir {
+			m.show(selected, nil)
+		} else {
+			m.show(selected.parent, selected)
+		}
+		return
+	}
+	if selected.Dir {
+		m.show(selected, nil)
+	}
+}
+
+// back leaves the search results, or goes to the parent directory with the
+// cursor on the one just left
+func (m *Model) back() {
+	if m.results != nil {
+		m.closeResults()
+		return
+	}
+	if m.dir.parent != nil {
+		m.show(m.dir.parent, m.dir)
+	}
+}
+
+// show lists dir with the cursor on selected, if it is listed
+func (m *Model) show(dir, selected *Entry) {
+	m.dir = dir
+	m.cursor, m.offset = 0, 0
+	m.refresh()
+	for i, row := range m.rows {
+		if row == selected {
+			m.cursor = i
+		}
+	}
+	m.scroll()
+}
+
+func (m *Model) closeResults() {
+	m.results = nil
+	m.query = ""
+	m.refresh()
+}
+
+// refresh recomputes the listed rows
+func (m *Model) refresh() {
+	source := m.dir.Children
+	if m.results != nil {
+		source = m.results
+	}
+	m.rows = m.rows[:0]
+	for _, entry := range source {
+		if !m.changedOnly || entry.Changes > 0 || entry.Change != nil {
+			m.rows = append(m.rows, entry)
+		}
+	}
+	m.cursor = min(m.cursor, max(len(m.rows)-1, 0))
+	m.scroll()
+}
+
+func (m *Model) move(delta int) {
+	m.cursor = min(max(m.cursor+delta, 0), max(len(m.rows)-1, 0))
+	m.scroll()
+}
+
+// scroll keeps the cursor within the visible rows
+func (m *Model) scroll() {
+	page := m.pageSize()
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+page {
+		m.offset = m.cursor - page + 1
+	}
+}
+
+func (m *Model) pageSize() int {
+	return max(m.height-chromeLines, 3)
+}
+
+func (m *Model) selected() *Entry {
+	if m.cursor < len(m.rows) {
+		return m.rows[m.cursor]
+	}
+	return nil
+}
+
+// View implements tea.Model
+func (m *Model) View() string {
+	var b strings.Builder
+	b.WriteString(ansiBold + truncate(m.title, m.width) + ansiReset + "\n")
+
+	switch {
+	case m.results != nil:
+		fmt.Fprintf(&b, "Search %q: %d matches\n\n", m.query, len(m.results))
+	default:
+		location := "/" + m.dir.Path
+		if m.changedOnly {
+			location += "  (changed only)"
+		}
+		b.WriteString(truncate(location, m.width) + "\n\n")
+	}
+
+	page := m.pageSize()
+	for i := m.offset; i < m.offset+page; i++ {
+		switch {
+		case i < len(m.rows):
+			b.WriteString(m.row(m.rows[i], i == m.cursor))
+		case i == 0:
+			b.WriteString("  (empty)")
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n")
+	b.WriteString(m.details())
+
+	if m.typing {
+		b.WriteString("/" + m.query + "█")
+	} else {
+		help := "↑/↓ move  enter open  ← back  / search  q quit"
+		if m.diff {
+			help = "↑/↓ move  enter open  ← back  / search  c changed only  q quit"
+		}
+		b.WriteString(truncate(help, m.width))
+	}
+	return b.String()
+}
+
+// row renders one listed entry
+func (m *Model) row(e *Entry, selected bool) string {
+	marker, color := " ", ""
+	if e.Change != nil {
+		marker, color = changeMarkers[e.Change.Type][0], changeMarkers[e.Change.Type][1]
+	}
+
+	name := e.Name
+	if m.results != nil {
+		name = e.Path
+	}
+	if e.Dir {
+		name += "/"
+	}
+	if e.Change != nil && e.Change.OldPath != "" {
+		name += " (from " + m.relPath(e.Change.OldPath) + ")"
+	}
+	hash := e.Hash
+	if len(hash) > 16 {
+		hash = hash[:16]
+	}
+	suffix := fmt.Sprintf(" %10s  %-16s", tree.FormatSize(e.Size), hash)
+	if e.Dir && e.Changes > 0 {
+		suffix += fmt.Sprintf("  %d changed", e.Changes)
+	}
+
+	nameWidth := max(m.width-4-utf8.RuneCountInString(suffix), 10)
+	line := marker + " " + pad(truncate(name, nameWidth), nameWidth) + suffix
+	if color != "" {
+		line = color + line + ansiReset
+	}
+	if selected {
+		line = ansiReverse + "> " + line + ansiReset
+	} else {
+		line = "  " + line
+	}
+	return line
+}
+
+// details describes the entry under the cursor in six lines
+func (m *Model) details() string {
+	lines := make([]string, 0, 6)
+	if e := m.selected(); e != nil {
+		lines = append(lines, "Path: /"+e.Path)
+		switch {
+		case e.Dir:
+			lines = append(lines, "Subtree hash: "+e.Hash, "Size: "+tree.FormatSize(e.Size))
+			if m.diff {
+				lines = append(lines, fmt.Sprintf("Changed files: %d", e.Changes))
+			}
+		case e.Change != nil && e.Change.OldData != nil && e.Change.NewData != nil:
+			lines = append(lines, "Change: "+string(e.Change.Type))
+			if e.Change.OldPath != "" {
+				lines = append(lines, "Old path: /"+m.relPath(e.Change.OldPath))
+			}
+			lines = append(lines,
+				fmt.Sprintf("Old: hash=%s, size=%s, modified=%s", e.Change.OldData.Hash, tree.FormatSize(e.Change.OldData.Size), formatTime(e.Change.OldData.ModTime)),
+				fmt.Sprintf("New: hash=%s, size=%s, modified=%s", e.Change.NewData.Hash, tree.FormatSize(e.Change.NewData.Size), formatTime(e.Change.NewData.ModTime)))
+			if e.Change.Delta != nil {
+				lines = append(lines, "Delta: "+e.Change.Delta.String())
+			}
+		default:
+			if e.Change != nil {
+				lines = append(lines, "Change: "+string(e.Change.Type))
+			}
+			lines = append(lines, "Hash: "+e.Data.Hash, "Size: "+tree.FormatSize(e.Data.Size), "Modified: "+formatTime(e.Data.ModTime))
+		}
+	}
+	for len(lines) < 6 {
+		lines = append(lines, "")
+	}
+
+	var b strings.Builder
+	for _, line := range lines[:6] {
+		b.WriteString(truncate(line, m.width) + "\n")
+	}
+	return b.String()
+}
+
+// relPath returns p relative to the tree root, with forward slashes
+func (m *Model) relPath(p string) string {
+	rel, err := filepath.Rel(m.rootPath, p)
+	if err != nil {
+		return filepath.ToSlash(p)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// truncate shortens s to width runes, marking the cut with an ellipsis
+func truncate(s string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
+
+// pad right-pads s with spaces to width runes
+func pad(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
@@ -0,0 +1,115 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"merkle-go/internal/compare"
+	"merkle-go/internal/tree"
+)
+
+func keys(m *Model, input ...string) {
+	for _, key := range input {
+		var msg tea.KeyMsg
+		switch key {
+		case "enter":
+			msg = tea.KeyMsg{Type: tea.KeyEnter}
+		case "left":
+			msg = tea.KeyMsg{Type: tea.KeyLeft}
+		case "down":
+			msg = tea.KeyMsg{Type: tea.KeyDown}
+		default:
+			msg = tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+		}
+		m.Update(msg)
+	}
+}
+
+func TestModel_Browse(t *testing.T) {
+	merkleTree, err := tree.Build(map[string]tree.FileData{
+		"/data/readme.txt":      {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+		"/data/src/main.go":     {Hash: "bbbbbbbbbbbbbbbb", Size: 2},
+		"/data/src/lib/util.go": {Hash: "cccccccccccccccc", Size: 3},
+	}, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	m := New(merkleTree, nil)
+
+	// Directories are listed first, with their subtree hash and total size
+	if len(m.rows) != 2 || m.rows[0].Name != "src" || m.rows[1].Name != "readme.txt" {
+		t.Fatalf("Unexpected root listing: %v", m.rows)
+	}
+	if m.rows[0].Hash != merkleTree.Directories["src"] || m.rows[0].Size != 5 {
+		t.Errorf("Expected src with hash %s and size 5, got %s and %d", merkleTree.Directories["src"], m.rows[0].Hash, m.rows[0].Size)
+	}
+
+	keys(m, "enter", "enter")
+	if m.dir.Path != "src/lib" || m.selected().Path != "src/lib/util.go" {
+		t.Fatalf("Expected to be in src/lib on util.go, got %s on %v", m.dir.Path, m.selected())
+	}
+	if view := m.View(); !strings.Contains(view, "Hash: cccccccccccccccc") {
+		t.Errorf("Expected details of util.go in view:\n%s", view)
+	}
+
+	// Going back puts the cursor on the directory just left
+	keys(m, "left")
+	if m.dir.Path != "src" || m.selected().Path != "src/lib" {
+		t.Errorf("Expected to be in src on lib, got %s on %v", m.dir.Path, m.selected())
+	}
+
+	// Search by hash prefix, then jump to the match
+	keys(m, "/", "bbbb", "enter")
+	if len(m.results) != 1 || m.results[0].Path != "src/main.go" {
+		t.Fatalf("Expected src/main.go to match, got %v", m.results)
+	}
+	keys(m, "enter")
+	if m.results != nil || m.dir.Path != "src" || m.selected().Path != "src/main.go" {
+		t.Errorf("Expected to be in src on main.go, got %s on %v", m.dir.Path, m.selected())
+	}
+}
+
+func TestModel_Diff(t *testing.T) {
+	oldTree, err := tree.Build(map[string]tree.FileData{
+		"/data/keep.txt":   {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+		"/data/sub/a.txt":  {Hash: "bbbbbbbbbbbbbbbb", Size: 2},
+		"/data/sub/gone.x": {Hash: "cccccccccccccccc", Size: 3},
+	}, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	newTree, err := tree.Build(map[string]tree.FileData{
+		"/data/keep.txt":  {Hash: "aaaaaaaaaaaaaaaa", Size: 1},
+		"/data/sub/a.txt": {Hash: "dddddddddddddddd", Size: 4},
+	}, "/data")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	m := New(newTree, compare.Compare(oldTree, newTree))
+
+	if m.root.Changes != 2 {
+		t.Errorf("Expected 2 changes under the root, got %d", m.root.Changes)
+	}
+
+	// Only sub has changes
+	keys(m, "c")
+	if len(m.rows) != 1 || m.rows[0].Path != "sub" {
+		t.Fatalf("Expected only sub to be listed, got %v", m.rows)
+	}
+
+	keys(m, "enter")
+	if len(m.rows) != 2 || m.rows[0].Change.Type != compare.Modified || m.rows[1].Change.Type != compare.Deleted {
+		t.Fatalf("Expected modified a.txt and deleted gone.x, got %v", m.rows)
+	}
+	if m.dir.Size != 4 {
+		t.Errorf("Expected deleted files to be left out of the size, got %d", m.dir.Size)
+	}
+	view := m.View()
+	for _, want := range []string{"Old: hash=bbbbbbbbbbbbbbbb", "New: hash=dddddddddddddddd", "gone.x"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("Expected %q in view:\n%s", want, view)
+		}
+	}
+}
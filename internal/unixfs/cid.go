@@ -0,0 +1,189 @@
+// Package unixfs computes the IPFS content identifiers (CIDs) files get when
+// they are added to IPFS, so manifests can cross-reference content stored
+// there without running an IPFS node
+package unixfs
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The DAG layout of `ipfs add --cid-version 1` with the default settings:
+// fixed-size chunks stored as raw leaves, linked by a balanced tree of
+// dag-pb nodes with at most MaxLinks children each
+const (
+	ChunkSize = 256 * 1024
+	MaxLinks  = 174
+)
+
+// Multicodec and multihash codes used in the CIDs
+const (
+	codecRaw    = 0x55
+	codecDagPB  = 0x70
+	sha256Code  = 0x12
+	unixfsFile  = 2
+	cidVersion1 = 1
+)
+
+var base32Lower = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// block is a node of the DAG as its parent links to it
+type block struct {
+	cid      []byte
+	tsize    uint64 // bytes of the block and everything below it
+	fileSize uint64 // bytes of file content below it
+}
+
+// CID returns the CIDv1 of the content of r as `ipfs add --cid-version 1`
+// computes it, in its default base32 form (bafk... for content that fits
+// in one chunk, bafy... otherwise)
+func CID(r io.Reader) (string, error) {
+	w := NewWriter()
+	if _, err := io.Copy(w, r); err != nil {
+		return "", fmt.Errorf("failed to read content: %w", err)
+	}
+	return w.Sum(), nil
+}
+
+// Writer computes the CID of the content written to it, for callers that
+// push content, such as a hash reading a file, rather than hand out a
+// reader
+type Writer struct {
+	// levels[i] holds the blocks of depth i not yet linked from a parent;
+	// a level is linked as soon as it is full, which builds the balanced
+	// layout bottom-up
+	levels [][]block
+	buf    []byte // content of the chunk being filled
+	chunks int
+}
+
+// NewWriter returns a Writer of empty content
+func NewWriter() *Writer {
+	return &Writer{levels: [][]block{nil}, buf: make([]byte, 0, ChunkSize)}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):ChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		if len(w.buf) == ChunkSize {
+			w.addChunk()
+		}
+	}
+	return written, nil
+}
+
+// addChunk adds the buffered content as a leaf and links every level it
+// fills
+func (w *Writer) addChunk() {
+	digest := sha256.Sum256(w.buf)
+	w.levels[0] = append(w.levels[0], block{cid: cidBytes(codecRaw, digest), tsize: uint64(len(w.buf)), fileSize: uint64(len(w.buf))})
+	for i := 0; len(w.levels[i]) == MaxLinks; i++ {
+		if i+1 == len(w.levels) {
+			w.levels = append(w.levels, nil)
+		}
+		w.levels[i+1] = append(w.levels[i+1], link(w.levels[i]))
+		w.levels[i] = nil
+	}
+	w.buf = w.buf[:0]
+	w.chunks++
+}
+
+// Sum returns the CID of the content written so far. The writer must not
+// be written to afterwards.
+func (w *Writer) Sum() string {
+	// An empty file is still one empty chunk
+	if len(w.buf) > 0 || w.chunks == 0 {
+		w.addChunk()
+	}
+
+	// Link what is left from the bottom up until one block remains
+	levels := w.levels
+	for i := 0; ; i++ {
+		if i == len(levels)-1 && len(levels[i]) == 1 {
+			return "b" + base32Lower.EncodeToString(levels[i][0].cid)
+		}
+		if len(levels[i]) == 0 {
+			continue
+		}
+		if i+1 == len(levels) {
+			levels = append(levels, nil)
+		}
+		levels[i+1] = append(levels[i+1], link(levels[i]))
+		levels[i] = nil
+	}
+}
+
+// FileCID returns the CID of the file at path
+func FileCID(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return CID(f)
+}
+
+// link encodes the dag-pb node linking children and returns it as a block
+func link(children []block) block {
+	// UnixFS Data message: Type, filesize, then the size of every child
+	var data []byte
+	var fileSize uint64
+	for _, child := range children {
+		fileSize += child.fileSize
+	}
+	data = protoVarint(data, 1, unixfsFile)
+	data = protoVarint(data, 3, fileSize)
+	for _, child := range children {
+		data = protoVarint(data, 4, child.fileSize)
+	}
+
+	// PBNode: the links come before the data in the canonical encoding,
+	// each with its hash, an empty name and the total size below it
+	var node []byte
+	tsize := uint64(0)
+	for _, child := range children {
+		var pbLink []byte
+		pbLink = protoBytes(pbLink, 1, child.cid)
+		pbLink = protoBytes(pbLink, 2, nil)
+		pbLink = protoVarint(pbLink, 3, child.tsize)
+		node = protoBytes(node, 2, pbLink)
+		tsize += child.tsize
+	}
+	node = protoBytes(node, 1, data)
+
+	return block{
+		cid:      cidBytes(codecDagPB, sha256.Sum256(node)),
+		tsize:    tsize + uint64(len(node)),
+		fileSize: fileSize,
+	}
+}
+
+// cidBytes returns the binary CIDv1 of a block with the given codec and
+// SHA-256 digest
+func cidBytes(codec uint64, digest [sha256.Size]byte) []byte {
+	b := binary.AppendUvarint(nil, cidVersion1)
+	b = binary.AppendUvarint(b, codec)
+	b = binary.AppendUvarint(b, sha256Code)
+	b = binary.AppendUvarint(b, sha256.Size)
+	return append(b, digest[:]...)
+}
+
+// protoVarint appends a protobuf varint field
+func protoVarint(b []byte, field int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+	return binary.AppendUvarint(b, v)
+}
+
+// protoBytes appends a protobuf length-delimited field
+func protoBytes(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
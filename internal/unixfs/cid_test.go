@@ -0,0 +1,62 @@
+package unixfs
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCID(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+	}{
+		// Raw leaves: the CID is the SHA-256 of the content
+		{"empty", nil, "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"},
+		{"hello world", []byte("hello world"), "bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e"},
+		// Two chunks under one dag-pb node, and 175 chunks needing a second
+		// level, as computed by `ipfs add --cid-version 1`
+		{"two chunks", pattern(ChunkSize + 1), "bafybeiexg2oqkfnj56l7fcmawswqbijt5shq4b5rg6a546uwpkqqzwjioi"},
+		{"two levels", pattern(ChunkSize * (MaxLinks + 1)), "bafybeie73j3heycdgkdsehpoe6cxh2y3iywtf6djpi3dzqrywevvjmazny"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CID(bytes.NewReader(tt.content))
+			if err != nil {
+				t.Fatalf("CID failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWriter(t *testing.T) {
+	for _, size := range []int{0, 10, ChunkSize, 3*ChunkSize + 17} {
+		content := pattern(size)
+		want, err := CID(bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("CID failed: %v", err)
+		}
+		// Writes of any size, crossing chunk boundaries
+		w := NewWriter()
+		for rest := content; len(rest) > 0; {
+			n := min(len(rest), 100003)
+			w.Write(rest[:n])
+			rest = rest[n:]
+		}
+		if got := w.Sum(); got != want {
+			t.Errorf("%d bytes: expected %s, got %s", size, want, got)
+		}
+	}
+}
+
+// pattern returns n bytes counting up modulo 251
+func pattern(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
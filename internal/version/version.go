@@ -0,0 +1,19 @@
+package version
+
+import "runtime/debug"
+
+// Version is the merkle-go release. It is set at build time with
+// -ldflags "-X merkle-go/internal/version.Version=v1.2.3".
+var Version = "dev"
+
+// String returns the release version, falling back to the module version
+// recorded by `go install` when no version was set at build time
+func String() string {
+	if Version != "dev" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return Version
+}
@@ -0,0 +1,100 @@
+package walker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// commandFilter asks a long-running filter command about each entry of a
+// walk. The command reads one path per line on stdin, relative to the root
+// with forward slashes and a trailing slash for directories, and answers
+// each with a line saying "keep" or "skip".
+type commandFilter struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	answers *bufio.Scanner
+	done    bool
+}
+
+// startFilterCommand runs command through the shell for a walk of rootPath,
+// which it gets in MERKLE_GO_ROOT
+func startFilterCommand(ctx context.Context, command, rootPath string) (*commandFilter, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), "MERKLE_GO_ROOT="+rootPath)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start filter command: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start filter command: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start filter command: %w", err)
+	}
+	return &commandFilter{cmd: cmd, stdin: stdin, answers: bufio.NewScanner(stdout)}, nil
+}
+
+// keep asks the command whether to keep the entry at relPath. Paths that
+// cannot be written on one line are kept without asking.
+func (f *commandFilter) keep(relPath string, dir bool) (bool, error) {
+	line := filepath.ToSlash(relPath)
+	if strings.ContainsAny(line, "\r\n") {
+		return true, nil
+	}
+	if dir {
+		line += "/"
+	}
+	if _, err := io.WriteString(f.stdin, line+"\n"); err != nil {
+		return false, fmt.Errorf("filter command stopped reading: %w", err)
+	}
+	if !f.answers.Scan() {
+		err := f.answers.Err()
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return false, fmt.Errorf("filter command stopped answering: %w", err)
+	}
+	switch answer := strings.TrimSpace(f.answers.Text()); answer {
+	case "keep":
+		return true, nil
+	case "skip":
+		return false, nil
+	default:
+		return false, fmt.Errorf("filter command answered %q for %s (want keep or skip)", answer, line)
+	}
+}
+
+// close ends the command's input and waits for it to exit
+func (f *commandFilter) close() error {
+	f.done = true
+	f.stdin.Close()
+	if err := f.cmd.Wait(); err != nil {
+		return fmt.Errorf("filter command failed: %w", err)
+	}
+	return nil
+}
+
+// abort kills the command if the walk ended without closing it
+func (f *commandFilter) abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.stdin.Close()
+	f.cmd.Process.Kill()
+	f.cmd.Wait()
+}
@@ -0,0 +1,54 @@
+package walker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// GitFiles builds a WalkResult from the files git tracks under rootPath,
+// which must be inside a git work tree. Ignored and untracked files are
+// left out, and so are tracked files deleted from the work tree.
+// Submodules are not descended into.
+func GitFiles(ctx context.Context, rootPath string) (*WalkResult, error) {
+	tracked, err := gitLsFiles(ctx, rootPath, "--cached")
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := gitLsFiles(ctx, rootPath, "--deleted")
+	if err != nil {
+		return nil, err
+	}
+
+	gone := make(map[string]bool)
+	for _, path := range strings.Split(string(deleted), "\x00") {
+		gone[path] = true
+	}
+	var list bytes.Buffer
+	for _, path := range strings.Split(string(tracked), "\x00") {
+		if path != "" && !gone[path] {
+			list.WriteString(path)
+			list.WriteByte(0)
+		}
+	}
+	return FromList(ctx, rootPath, &list)
+}
+
+// gitLsFiles runs git ls-files with NUL-separated output in dir
+func gitLsFiles(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir, "ls-files", "-z"}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("git ls-files failed: %s", strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("failed to run git: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,67 @@
+package walker
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestGitFiles(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", root}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	write := func(name, content string) {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run("init", "-q")
+	write(".gitignore", "*.log\n")
+	write("tracked.txt", "tracked")
+	write("sub/tracked.txt", "tracked")
+	write("removed.txt", "removed")
+	run("add", ".gitignore", "tracked.txt", "sub/tracked.txt", "removed.txt")
+	write("untracked.txt", "untracked")
+	write("ignored.log", "ignored")
+	os.Remove(filepath.Join(root, "removed.txt"))
+
+	result, err := GitFiles(context.Background(), root)
+	if err != nil {
+		t.Fatalf("GitFiles failed: %v", err)
+	}
+	if len(result.Errors) != 0 {
+		t.Errorf("Expected no errors, got %v", result.Errors)
+	}
+	var names []string
+	for _, file := range result.Files {
+		rel, _ := filepath.Rel(root, file.Path)
+		names = append(names, filepath.ToSlash(rel))
+	}
+	expected := []string{".gitignore", "sub/tracked.txt", "tracked.txt"}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, names)
+			break
+		}
+	}
+
+	if _, err := GitFiles(context.Background(), t.TempDir()); err == nil {
+		t.Error("Expected an error outside a git work tree")
+	}
+}
@@ -0,0 +1,79 @@
+package walker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"merkle-go/internal/longpath"
+)
+
+// FromList builds a WalkResult from a list of paths instead of walking,
+// e.g. the output of find or git ls-files. Entries are separated by NUL
+// bytes if the list contains any, by newlines otherwise. Relative paths
+// are resolved against rootPath; absolute paths must be under it. Skip
+// patterns do not apply. Directories are ignored, repeated paths are kept
+// once, and paths that cannot be read are reported in Errors.
+func FromList(ctx context.Context, rootPath string, list io.Reader) (*WalkResult, error) {
+	data, err := io.ReadAll(list)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file list: %w", err)
+	}
+
+	var entries []string
+	if bytes.IndexByte(data, 0) >= 0 {
+		entries = strings.Split(string(data), "\x00")
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+		for scanner.Scan() {
+			entries = append(entries, strings.TrimSuffix(scanner.Text(), "\r"))
+		}
+	}
+
+	result := &WalkResult{
+		Files:     make([]FileInfo, 0, len(entries)),
+		Errors:    make([]error, 0),
+		EmptyDirs: make([]FileInfo, 0),
+	}
+	cleanRoot := filepath.Clean(rootPath)
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if entry == "" {
+			continue
+		}
+
+		path := entry
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(cleanRoot, path)
+		}
+		path = filepath.Clean(path)
+		if rel, err := filepath.Rel(cleanRoot, path); err != nil || !filepath.IsLocal(rel) {
+			result.Errors = append(result.Errors, &os.PathError{Op: "list", Path: path, Err: fmt.Errorf("not under %s", cleanRoot)})
+			continue
+		}
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		info, err := os.Lstat(longpath.Path(path))
+		if err != nil {
+			result.Errors = append(result.Errors, err)
+			continue
+		}
+		if info.IsDir() {
+			continue
+		}
+		result.Files = append(result.Files, newFileInfo(path, info))
+	}
+	return result, nil
+}
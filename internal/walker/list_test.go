@@ -0,0 +1,40 @@
+package walker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFromList(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a.txt", "sub/b.txt", "skipped.log"} {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lists := map[string]string{
+		"newline": "a.txt\nsub/b.txt\r\n" + filepath.Join(root, "a.txt") + "\nsub\nmissing.txt\n../outside\n",
+		"nul":     "a.txt\x00sub/b.txt\x00sub\x00missing.txt\x00../outside\x00",
+	}
+	for name, list := range lists {
+		result, err := FromList(context.Background(), root, strings.NewReader(list))
+		if err != nil {
+			t.Fatalf("%s: FromList failed: %v", name, err)
+		}
+
+		if len(result.Files) != 2 {
+			t.Errorf("%s: expected 2 files, got %v", name, result.Files)
+		} else if result.Files[1].Path != filepath.Join(root, "sub", "b.txt") || result.Files[1].Size != 9 {
+			t.Errorf("%s: unexpected file %+v", name, result.Files[1])
+		}
+		if len(result.Errors) != 2 {
+			t.Errorf("%s: expected errors for the missing and outside paths, got %v", name, result.Errors)
+		}
+	}
+}
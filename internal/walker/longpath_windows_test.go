@@ -0,0 +1,60 @@
+package walker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"merkle-go/internal/hash"
+)
+
+func TestHashFiles_LongPath(t *testing.T) {
+	root := t.TempDir()
+	dir := root
+	for len(dir) <= 260 {
+		dir = filepath.Join(dir, strings.Repeat("d", 40))
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	path := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	walkResult, err := Walk(context.Background(), root, nil)
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	if len(walkResult.Files) != 1 || walkResult.Files[0].Path != path {
+		t.Fatalf("Expected %s from the walk, got %v", path, walkResult.Files)
+	}
+
+	// Wrappers that record by path, such as the sample recorder, must see
+	// the path the walk reported, not its extended-length form
+	var mu sync.Mutex
+	var seen []string
+	record := func(p string) (string, error) {
+		mu.Lock()
+		seen = append(seen, p)
+		mu.Unlock()
+		return hash.HashFile(p)
+	}
+	result, err := HashFilesWithOptions(context.Background(), walkResult.Files, HashOptions{Workers: 2, HashFunc: record})
+	if err != nil {
+		t.Fatalf("HashFilesWithOptions failed: %v", err)
+	}
+	if len(seen) != 1 || seen[0] != path {
+		t.Errorf("Expected the hash function to get %s, got %v", path, seen)
+	}
+	want, err := hash.HashFile(path)
+	if err != nil {
+		t.Fatalf("HashFile failed: %v", err)
+	}
+	if got := result.Hashes[path]; got != want {
+		t.Errorf("Expected hash %s for the long path, got %q (errors: %v)", want, got, result.Errors)
+	}
+}
@@ -0,0 +1,41 @@
+package walker
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+)
+
+// CacheDirTag is the file that marks a directory as a cache, and
+// cacheDirSignature the bytes it must start with to count
+const CacheDirTag = "CACHEDIR.TAG"
+
+var cacheDirSignature = []byte("Signature: 8a477f597d28d172789f06886806bc55")
+
+// hasExcludeMarker reports whether the directory dir of fsys holds a marker
+// that opts leaves out
+func hasExcludeMarker(fsys fs.FS, dir string, opts WalkOptions) bool {
+	for _, name := range opts.ExcludeIfPresent {
+		if _, err := fs.Stat(fsys, path.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return opts.ExcludeCaches && isCacheDir(fsys, dir)
+}
+
+// isCacheDir reports whether dir has a CACHEDIR.TAG file with the standard
+// signature. A tag without it, e.g. an unrelated file of the same name,
+// does not count.
+func isCacheDir(fsys fs.FS, dir string) bool {
+	f, err := fsys.Open(path.Join(dir, CacheDirTag))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, len(cacheDirSignature))
+	if _, err := io.ReadFull(f, head); err != nil {
+		return false
+	}
+	return bytes.Equal(head, cacheDirSignature)
+}
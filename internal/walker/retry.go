@@ -0,0 +1,47 @@
+package walker
+
+import (
+	"context"
+	"time"
+)
+
+// RetryPolicy retries reads that fail with transient errors, such as those
+// of NFS and SMB mounts briefly losing their server, with exponential
+// backoff. The zero policy does not retry.
+type RetryPolicy struct {
+	Retries int           // attempts after the first one
+	Delay   time.Duration // wait before the first retry; doubled for each further one
+}
+
+// retry runs op until it succeeds, fails with an error that is not
+// transient, or has been retried p.Retries times. exhausted reports that op
+// still failed transiently after the last retry.
+func (p RetryPolicy) retry(ctx context.Context, op func() error) (exhausted bool, err error) {
+	delay := p.Delay
+	for attempt := 0; ; attempt++ {
+		err = op()
+		if err == nil || !isTransient(err) {
+			return false, err
+		}
+		if attempt == p.Retries {
+			return p.Retries > 0, err
+		}
+		if !sleep(ctx, delay) {
+			return false, err
+		}
+		delay *= 2
+	}
+}
+
+// sleep waits for d unless ctx is cancelled first, and reports whether it
+// waited the whole time
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+
+package walker
+
+import (
+	"errors"
+	"syscall"
+)
+
+// transientErrnos are the errors network filesystems return while their
+// server is briefly unreachable or restarting
+var transientErrnos = []syscall.Errno{
+	syscall.EIO, syscall.ESTALE, syscall.ETIMEDOUT, syscall.EAGAIN, syscall.EINTR,
+	syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EHOSTDOWN, syscall.EHOSTUNREACH,
+	syscall.ENETDOWN, syscall.ENETUNREACH, syscall.ENETRESET,
+}
+
+// isTransient reports whether err may go away if the operation is retried
+func isTransient(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	for _, transient := range transientErrnos {
+		if errno == transient {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package walker
+
+import (
+	"errors"
+	"syscall"
+)
+
+// transientErrnos are the errors SMB shares return while their server is
+// briefly unreachable or restarting
+var transientErrnos = []syscall.Errno{
+	53,   // ERROR_BAD_NETPATH
+	54,   // ERROR_NETWORK_BUSY
+	59,   // ERROR_UNEXP_NET_ERR
+	64,   // ERROR_NETNAME_DELETED
+	121,  // ERROR_SEM_TIMEOUT
+	1231, // ERROR_NETWORK_UNREACHABLE
+	1236, // ERROR_CONNECTION_ABORTED
+}
+
+// isTransient reports whether err may go away if the operation is retried
+func isTransient(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	for _, transient := range transientErrnos {
+		if errno == transient {
+			return true
+		}
+	}
+	return false
+}
@@ -1,14 +1,21 @@
 package walker
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/fs"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"merkle-go/internal/fsinfo"
 	"merkle-go/internal/hash"
+	"merkle-go/internal/longpath"
+	"merkle-go/internal/pathmatch"
 	"merkle-go/internal/progress"
 )
 
@@ -16,27 +23,209 @@ type FileInfo struct {
 	Path    string
 	Size    int64
 	ModTime time.Time
+
+	// Sparse is set for files with holes, which occupy only Allocated
+	// bytes on disk
+	Sparse    bool
+	Allocated int64
+}
+
+// newFileInfo describes the regular file at path
+func newFileInfo(path string, info os.FileInfo) FileInfo {
+	fileInfo := FileInfo{Path: path, Size: info.Size(), ModTime: info.ModTime()}
+	if allocated, ok := fsinfo.Allocated(info); ok && allocated < info.Size() {
+		fileInfo.Sparse = true
+		fileInfo.Allocated = allocated
+	}
+	return fileInfo
 }
 
 type WalkResult struct {
 	Files  []FileInfo
 	Errors []error
+
+	// EmptyDirs are the directories below the root that contain nothing
+	// but excluded entries. Size is always 0.
+	EmptyDirs []FileInfo
+
+	// RetriesExhausted lists the files and directories that still failed
+	// with a transient error after every retry of WalkOptions.Retry; they
+	// are in Errors too
+	RetriesExhausted []string
+}
+
+// WalkOptions tunes WalkWithOptions
+type WalkOptions struct {
+	// Exclusions are the skip patterns of the config
+	Exclusions []string
+
+	// SkipRegex are the skip_regex rules of the config, regular
+	// expressions matched against the relative path of every entry; see
+	// pathmatch.Regexps
+	SkipRegex []string
+
+	// OneFileSystem stays on the filesystem of rootPath, like tar and
+	// rsync --one-file-system: directories and files on other devices,
+	// such as /proc or network mounts below the root, are left out. It
+	// has no effect on platforms without device numbers (Windows).
+	OneFileSystem bool
+
+	// ExcludeCaches leaves out directories tagged as caches with a
+	// CACHEDIR.TAG file (https://bford.info/cachedir/), like tar
+	// --exclude-caches-all
+	ExcludeCaches bool
+
+	// ExcludeIfPresent leaves out directories that contain an entry with
+	// one of these names, such as .nobackup
+	ExcludeIfPresent []string
+
+	// Limits guard against scanning far more than intended. The walk
+	// stops with a *LimitError when it crosses one, unless OnLimit is
+	// set: then OnLimit is called once for each limit crossed and the
+	// walk goes on.
+	Limits  Limits
+	OnLimit func(*LimitError)
+
+	// Filter, if set, is asked about every entry that is not excluded;
+	// returning false leaves it out, and everything below it for a
+	// directory
+	Filter FilterFunc
+
+	// FilterCommand, if set, is a shell command asked about every entry
+	// that is not excluded, for policies that are not expressible as
+	// patterns. It runs once per walk and answers each path it reads with
+	// keep or skip; see commandFilter.
+	FilterCommand string
+
+	// Retry reads directories and file details again when they fail with
+	// a transient error
+	Retry RetryPolicy
+
+	// OnFile, if set, is called with every file as it is added to the
+	// result, such as to show how far a long walk has got. The walk calls
+	// it from a single goroutine.
+	OnFile func(FileInfo)
+}
+
+// FilterFunc decides whether a walk keeps the entry at path
+type FilterFunc func(path string, info fs.FileInfo) bool
+
+// Limits are safety rails against scanning / or a runaway recursive mount
+// by mistake. Zero means no limit.
+type Limits struct {
+	MaxFiles      int   // files
+	MaxDepth      int   // levels below the root; a file in the root is at depth 1
+	MaxTotalBytes int64 // total size of the files
+}
+
+// LimitError reports that a walk crossed one of its Limits
+type LimitError struct {
+	Limit string // max_files, max_depth or max_total_bytes
+	Max   int64
+	Path  string // the entry that crossed the limit
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("scan exceeds %s = %d at %s", e.Limit, e.Max, e.Path)
 }
 
-func Walk(rootPath string, exclusions []string) (*WalkResult, error) {
+// Walk collects the files under rootPath that are not excluded. It stops
+// early and returns ctx.Err() if the context is cancelled.
+func Walk(ctx context.Context, rootPath string, exclusions []string) (*WalkResult, error) {
+	return WalkWithOptions(ctx, rootPath, WalkOptions{Exclusions: exclusions})
+}
+
+// WalkWithOptions is Walk with options
+func WalkWithOptions(ctx context.Context, rootPath string, opts WalkOptions) (*WalkResult, error) {
+	exclusions := opts.Exclusions
+	regexps, err := pathmatch.CompileRegexps(opts.SkipRegex)
+	if err != nil {
+		return nil, err
+	}
+	var rootDev uint64
+	checkDevice := false
+	if opts.OneFileSystem {
+		info, err := os.Stat(rootPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk directory: %w", err)
+		}
+		rootDev, _, checkDevice = fsinfo.Identity(info)
+	}
+
 	result := &WalkResult{
-		Files:  make([]FileInfo, 0),
-		Errors: make([]error, 0),
+		Files:     make([]FileInfo, 0),
+		Errors:    make([]error, 0),
+		EmptyDirs: make([]FileInfo, 0),
+
+		RetriesExhausted: make([]string, 0),
 	}
 
-	err := filepath.WalkDir(rootPath, func(path string, d fs.DirEntry, err error) error {
+	var filterCmd *commandFilter
+	if opts.FilterCommand != "" {
+		var err error
+		if filterCmd, err = startFilterCommand(ctx, opts.FilterCommand, rootPath); err != nil {
+			return nil, err
+		}
+		defer filterCmd.abort()
+	}
+
+	// Count the included entries of every directory to find the empty ones
+	children := make(map[string]int)
+	unreadable := make(map[string]bool)
+	var dirs []FileInfo
+
+	limits := newLimitChecker(opts)
+
+	// A directory whose read failed with a transient error is walked again
+	// after a delay; revisit keeps the new walk from counting the directory
+	// itself twice
+	attempts := make(map[string]int)
+	revisit := make(map[string]bool)
+
+	// On Windows the walk goes through the extended-length form of the
+	// root, so it reaches files beyond MAX_PATH; paths are reported below
+	// rootPath as given
+	walkRoot := longpath.Root(rootPath)
+
+	var visit fs.WalkDirFunc
+	visit = func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		walkPath := path
+		path = stripRoot(path, walkRoot, rootPath)
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			pathErr.Path = stripRoot(pathErr.Path, walkRoot, rootPath)
+		}
+
 		if err != nil {
 			// If error is on the root path, return it (don't continue walking)
 			if path == rootPath {
 				return err
 			}
+			if d != nil && d.IsDir() && isTransient(err) && attempts[path] < opts.Retry.Retries {
+				delay := opts.Retry.Delay << attempts[path]
+				attempts[path]++
+				if !sleep(ctx, delay) {
+					return ctx.Err()
+				}
+				revisit[path] = true
+				if err := filepath.WalkDir(walkPath, visit); err != nil {
+					return err
+				}
+				return filepath.SkipDir
+			}
+			if opts.Retry.Retries > 0 && isTransient(err) {
+				result.RetriesExhausted = append(result.RetriesExhausted, path)
+			}
 			// Skip permission errors and continue walking
 			result.Errors = append(result.Errors, err)
+			unreadable[path] = true
+			return nil
+		}
+		if revisit[path] {
+			delete(revisit, path)
 			return nil
 		}
 
@@ -48,39 +237,187 @@ func Walk(rootPath string, exclusions []string) (*WalkResult, error) {
 		}
 
 		// Check if path should be excluded
-		if shouldExclude(relPath, d, exclusions) {
+		if shouldExclude(relPath, d, exclusions, regexps) {
 			if d.IsDir() {
 				return filepath.SkipDir
 			}
 			return nil
 		}
 
+		// Leave out directories marked as not worth scanning
+		if d.IsDir() && path != rootPath && hasExcludeMarker(os.DirFS(walkPath), ".", opts) {
+			return filepath.SkipDir
+		}
+
+		// Leave out mount points and whatever is below them
+		if checkDevice && path != rootPath {
+			if info, err := d.Info(); err == nil {
+				if dev, _, ok := fsinfo.Identity(info); ok && dev != rootDev {
+					if d.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+			}
+		}
+
+		// Leave out what the custom filters reject
+		if path != rootPath && (opts.Filter != nil || filterCmd != nil) {
+			keep := true
+			if opts.Filter != nil {
+				info, err := d.Info()
+				if err != nil {
+					result.Errors = append(result.Errors, err)
+					return nil
+				}
+				keep = opts.Filter(path, info)
+			}
+			if keep && filterCmd != nil {
+				if keep, err = filterCmd.keep(relPath, d.IsDir()); err != nil {
+					return err
+				}
+			}
+			if !keep {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		if path != rootPath {
+			if err := limits.depth(relPath, path); err != nil {
+				return err
+			}
+			children[filepath.Dir(path)]++
+			if d.IsDir() {
+				if info, err := d.Info(); err == nil {
+					dirs = append(dirs, FileInfo{Path: path, ModTime: info.ModTime()})
+				}
+			}
+		}
+
 		// Only add files, not directories
 		if !d.IsDir() {
-			info, err := d.Info()
+			var info fs.FileInfo
+			exhausted, err := opts.Retry.retry(ctx, func() (err error) {
+				info, err = d.Info()
+				return err
+			})
 			if err != nil {
+				if exhausted {
+					result.RetriesExhausted = append(result.RetriesExhausted, path)
+				}
 				result.Errors = append(result.Errors, err)
 				return nil
 			}
 
-			result.Files = append(result.Files, FileInfo{
-				Path:    path,
-				Size:    info.Size(),
-				ModTime: info.ModTime(),
-			})
+			fileInfo := newFileInfo(path, info)
+			result.Files = append(result.Files, fileInfo)
+			if opts.OnFile != nil {
+				opts.OnFile(fileInfo)
+			}
+			if err := limits.file(len(result.Files), info.Size(), path); err != nil {
+				return err
+			}
 		}
 
 		return nil
-	})
+	}
+	err = filepath.WalkDir(walkRoot, visit)
 
+	if err == nil && filterCmd != nil {
+		err = filterCmd.close()
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to walk directory: %w", err)
 	}
 
+	for _, dir := range dirs {
+		if children[dir.Path] == 0 && !unreadable[dir.Path] {
+			result.EmptyDirs = append(result.EmptyDirs, dir)
+		}
+	}
+
 	return result, nil
 }
 
-func shouldExclude(relPath string, d fs.DirEntry, exclusions []string) bool {
+// stripRoot returns path, found by walking walkRoot, as the path below
+// rootPath it stands for
+func stripRoot(path, walkRoot, rootPath string) string {
+	if walkRoot == rootPath {
+		return path
+	}
+	if path == walkRoot {
+		return rootPath
+	}
+	if rest, ok := strings.CutPrefix(path, walkRoot); ok {
+		return filepath.Clean(rootPath) + rest
+	}
+	return path
+}
+
+// limitChecker tracks a walk against its Limits
+type limitChecker struct {
+	limits     Limits
+	onLimit    func(*LimitError)
+	crossed    map[string]bool
+	totalBytes int64
+}
+
+func newLimitChecker(opts WalkOptions) *limitChecker {
+	return &limitChecker{limits: opts.Limits, onLimit: opts.OnLimit, crossed: make(map[string]bool)}
+}
+
+// depth checks the depth of the entry at relPath, relative to the root with
+// the separators of the platform
+func (c *limitChecker) depth(relPath, path string) error {
+	depth := strings.Count(relPath, string(filepath.Separator)) + 1
+	return c.check("max_depth", int64(depth), int64(c.limits.MaxDepth), path)
+}
+
+// file checks the count and total size of the files walked so far after
+// adding one of size bytes
+func (c *limitChecker) file(count int, size int64, path string) error {
+	c.totalBytes += size
+	if err := c.check("max_files", int64(count), int64(c.limits.MaxFiles), path); err != nil {
+		return err
+	}
+	return c.check("max_total_bytes", c.totalBytes, c.limits.MaxTotalBytes, path)
+}
+
+// check reports a crossed limit, once per limit, and returns the error
+// that stops the walk, if any
+func (c *limitChecker) check(limit string, value, max int64, path string) error {
+	if max <= 0 || value <= max || c.crossed[limit] {
+		return nil
+	}
+	c.crossed[limit] = true
+	limitErr := &LimitError{Limit: limit, Max: max, Path: path}
+	if c.onLimit == nil {
+		return limitErr
+	}
+	c.onLimit(limitErr)
+	return nil
+}
+
+// Excluded reports whether skip patterns and skip_regex rules leave out the
+// entry at relPath, relative to the walked directory, the way
+// WalkWithOptions applies them. The entry may no longer exist, so the rules
+// are also tried on each directory above it.
+func Excluded(relPath string, exclusions []string, regexps *pathmatch.Regexps) bool {
+	for dir := filepath.Dir(relPath); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if regexps.Match(dir, true) {
+			return true
+		}
+	}
+	return shouldExclude(relPath, nil, exclusions, regexps)
+}
+
+func shouldExclude(relPath string, d fs.DirEntry, exclusions []string, regexps *pathmatch.Regexps) bool {
+	if regexps.Match(relPath, d != nil && d.IsDir()) {
+		return true
+	}
 	for _, pattern := range exclusions {
 		// Handle directory exclusions (patterns ending with /)
 		if strings.HasSuffix(pattern, "/") {
@@ -115,8 +452,60 @@ func shouldExclude(relPath string, d fs.DirEntry, exclusions []string) bool {
 }
 
 type HashResult struct {
-	Hashes map[string]string // path -> hash
-	Errors []error
+	Hashes   map[string]string // path -> hash
+	Errors   []error
+	Poisoned []string // files that hung or crashed a worker
+
+	// RetriesExhausted lists the files that still failed with a transient
+	// error after every retry of HashOptions.Retry; they are in Errors too
+	RetriesExhausted []string
+}
+
+// FileError is a hashing failure for one file
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// ErrPoisoned marks files that were abandoned because hashing them hung
+// past the timeout or panicked
+var ErrPoisoned = errors.New("poisoned file")
+
+// HashOptions configures HashFilesWithOptions
+type HashOptions struct {
+	Workers  int
+	Progress *progress.Bar
+
+	// FileTimeout abandons a file whose hashing takes longer than this and
+	// reports it as poisoned; the worker moves on to the next file. Zero
+	// disables the timeout.
+	FileTimeout time.Duration
+
+	// HashFunc computes the hash of one file; defaults to hash.HashFile
+	HashFunc func(path string) (string, error)
+
+	// OnResult, if set, is called as each file finishes hashing, with either
+	// its hash or the error. Calls are made from a single goroutine.
+	OnResult func(path, hash string, err error)
+
+	// OnHashed, if set, is called with the walked details of each file that
+	// was hashed successfully. Calls are made from a single goroutine.
+	OnHashed func(info FileInfo, hash string)
+
+	// DiscardHashes leaves HashResult.Hashes empty, for callers that collect
+	// the hashes through OnResult or OnHashed and cannot afford to keep them
+	DiscardHashes bool
+
+	// Retry rehashes files whose read fails with a transient error
+	Retry RetryPolicy
 }
 
 type hashJob struct {
@@ -124,19 +513,39 @@ type hashJob struct {
 }
 
 type hashJobResult struct {
-	path string
-	hash string
-	err  error
+	info      FileInfo
+	path      string
+	hash      string
+	err       error
+	poisoned  bool
+	exhausted bool // failed transiently after every retry
+}
+
+// HashFiles hashes files using numWorkers concurrent workers. If ctx is
+// cancelled, workers finish the file they are on and stop; the hashes
+// computed so far are returned together with ctx.Err().
+func HashFiles(ctx context.Context, files []FileInfo, numWorkers int, progressBar *progress.Bar) (*HashResult, error) {
+	return HashFilesWithOptions(ctx, files, HashOptions{Workers: numWorkers, Progress: progressBar})
 }
 
-func HashFiles(files []FileInfo, numWorkers int, progressBar *progress.Bar) (*HashResult, error) {
+// HashFilesWithOptions is HashFiles with per-file timeouts and a custom
+// hash function. A panic while hashing a file is recovered and, like a
+// timeout, reported as a poisoned file instead of taking down the scan.
+func HashFilesWithOptions(ctx context.Context, files []FileInfo, opts HashOptions) (*HashResult, error) {
+	numWorkers := opts.Workers
 	if numWorkers <= 0 {
 		numWorkers = 1
 	}
+	hashFunc := opts.HashFunc
+	if hashFunc == nil {
+		hashFunc = hash.HashFile
+	}
+	progressBar := opts.Progress
 
 	result := &HashResult{
-		Hashes: make(map[string]string),
-		Errors: make([]error, 0),
+		Hashes:   make(map[string]string),
+		Errors:   make([]error, 0),
+		Poisoned: make([]string, 0),
 	}
 
 	if len(files) == 0 {
@@ -154,22 +563,34 @@ func HashFiles(files []FileInfo, numWorkers int, progressBar *progress.Bar) (*Ha
 		go func() {
 			defer wg.Done()
 			for job := range jobs {
-				hashStr, err := hash.HashFile(job.fileInfo.Path)
-				results <- hashJobResult{
-					path: job.fileInfo.Path,
-					hash: hashStr,
-					err:  err,
+				if ctx.Err() != nil {
+					continue // Drain remaining jobs without hashing
 				}
+				var res hashJobResult
+				exhausted, _ := opts.Retry.retry(ctx, func() error {
+					res = hashIsolated(ctx, job.fileInfo.Path, hashFunc, opts.FileTimeout)
+					if res.poisoned {
+						return nil // A hung or crashing file is not retried
+					}
+					return res.err
+				})
+				res.info = job.fileInfo
+				res.exhausted = exhausted
+				results <- res
 			}
 		}()
 	}
 
-	// Send jobs
+	// Send jobs until all files are queued or the context is cancelled
 	go func() {
+		defer close(jobs)
 		for _, fileInfo := range files {
-			jobs <- hashJob{fileInfo: fileInfo}
+			select {
+			case jobs <- hashJob{fileInfo: fileInfo}:
+			case <-ctx.Done():
+				return
+			}
 		}
-		close(jobs)
 	}()
 
 	// Wait for workers to finish and close results
@@ -180,10 +601,24 @@ func HashFiles(files []FileInfo, numWorkers int, progressBar *progress.Bar) (*Ha
 
 	// Collect results
 	for jobResult := range results {
+		if opts.OnResult != nil && !errors.Is(jobResult.err, context.Canceled) {
+			opts.OnResult(jobResult.path, jobResult.hash, jobResult.err)
+		}
+		if jobResult.poisoned {
+			result.Poisoned = append(result.Poisoned, jobResult.path)
+		}
+		if jobResult.exhausted {
+			result.RetriesExhausted = append(result.RetriesExhausted, jobResult.path)
+		}
 		if jobResult.err != nil {
-			result.Errors = append(result.Errors, fmt.Errorf("%s: %w", jobResult.path, jobResult.err))
+			result.Errors = append(result.Errors, &FileError{Path: jobResult.path, Err: jobResult.err})
 		} else {
-			result.Hashes[jobResult.path] = jobResult.hash
+			if !opts.DiscardHashes {
+				result.Hashes[jobResult.path] = jobResult.hash
+			}
+			if opts.OnHashed != nil {
+				opts.OnHashed(jobResult.info, jobResult.hash)
+			}
 
 			// Update progress bar
 			if progressBar != nil {
@@ -194,5 +629,55 @@ func HashFiles(files []FileInfo, numWorkers int, progressBar *progress.Bar) (*Ha
 		}
 	}
 
+	sort.Strings(result.Poisoned)
+	sort.Strings(result.RetriesExhausted)
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
 	return result, nil
 }
+
+// hashIsolated hashes one file, recovering from panics and, if timeout is
+// set, giving up on files that take too long. A hung hash keeps running in
+// its own goroutine (blocking I/O cannot be interrupted) but no longer holds
+// up the worker that started it.
+func hashIsolated(ctx context.Context, path string, hashFunc func(string) (string, error), timeout time.Duration) hashJobResult {
+	run := func() (res hashJobResult) {
+		res.path = path
+		defer func() {
+			if r := recover(); r != nil {
+				res.err = fmt.Errorf("%w: panic while hashing: %v", ErrPoisoned, r)
+				res.poisoned = true
+			}
+		}()
+		res.hash, res.err = hashFunc(path)
+		return res
+	}
+
+	if timeout <= 0 {
+		return run()
+	}
+
+	done := make(chan hashJobResult, 1) // Buffered so an abandoned hash can still finish
+	go func() {
+		done <- run()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		return res
+	case <-timer.C:
+		return hashJobResult{
+			path:     path,
+			err:      fmt.Errorf("%w: hashing did not finish within %s", ErrPoisoned, timeout),
+			poisoned: true,
+		}
+	case <-ctx.Done():
+		return hashJobResult{path: path, err: ctx.Err()}
+	}
+}
@@ -1,10 +1,21 @@
 package walker
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"merkle-go/internal/fsinfo"
 )
 
 func TestWalk_AllFiles(t *testing.T) {
@@ -29,7 +40,7 @@ func TestWalk_AllFiles(t *testing.T) {
 	}
 
 	// Walk with no exclusions
-	result, err := Walk(tmpDir, []string{})
+	result, err := Walk(context.Background(), tmpDir, []string{})
 	if err != nil {
 		t.Fatalf("Walk failed: %v", err)
 	}
@@ -71,7 +82,7 @@ func TestWalk_WithExclusions(t *testing.T) {
 		".git/",
 	}
 
-	result, err := Walk(tmpDir, exclusions)
+	result, err := Walk(context.Background(), tmpDir, exclusions)
 	if err != nil {
 		t.Fatalf("Walk failed: %v", err)
 	}
@@ -97,10 +108,41 @@ func TestWalk_WithExclusions(t *testing.T) {
 	}
 }
 
+func TestWalk_SkipRegex(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, f := range []string{"a.txt", "cache/x", "src/cache/y", "important/cache/z", "src/cached/w"} {
+		fullPath := filepath.Join(tmpDir, f)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte("content"), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	result, err := WalkWithOptions(context.Background(), tmpDir, WalkOptions{SkipRegex: []string{"/cache/", "!^/important/"}})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	var paths []string
+	for _, f := range result.Files {
+		rel, _ := filepath.Rel(tmpDir, f.Path)
+		paths = append(paths, filepath.ToSlash(rel))
+	}
+	sort.Strings(paths)
+	if got := strings.Join(paths, ","); got != "a.txt,important/cache/z,src/cached/w" {
+		t.Errorf("Unexpected files with SkipRegex: %s", got)
+	}
+
+	if _, err := WalkWithOptions(context.Background(), tmpDir, WalkOptions{SkipRegex: []string{"a(b"}}); err == nil {
+		t.Error("Expected an error for a malformed regular expression")
+	}
+}
+
 func TestWalk_EmptyDirectory(t *testing.T) {
 	tmpDir := t.TempDir()
 
-	result, err := Walk(tmpDir, []string{})
+	result, err := Walk(context.Background(), tmpDir, []string{})
 	if err != nil {
 		t.Fatalf("Walk failed: %v", err)
 	}
@@ -110,8 +152,306 @@ func TestWalk_EmptyDirectory(t *testing.T) {
 	}
 }
 
+func TestWalk_EmptyDirs(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, dir := range []string{"empty", "nested/empty", "only-excluded", "full"} {
+		if err := os.MkdirAll(filepath.Join(tmpDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	for _, file := range []string{"full/a.txt", "only-excluded/.DS_Store"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, file), []byte("x"), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	result, err := Walk(context.Background(), tmpDir, []string{".DS_Store"})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+
+	var empty []string
+	for _, dir := range result.EmptyDirs {
+		relPath, _ := filepath.Rel(tmpDir, dir.Path)
+		empty = append(empty, filepath.ToSlash(relPath))
+	}
+	sort.Strings(empty)
+	expected := []string{"empty", "nested/empty", "only-excluded"}
+	if strings.Join(empty, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected empty directories %v, got %v", expected, empty)
+	}
+}
+
+func TestWalk_OneFileSystem(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, f := range []string{"a.txt", "sub/b.txt"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(tmpDir, f)), 0755)
+		os.WriteFile(filepath.Join(tmpDir, f), []byte("content"), 0644)
+	}
+
+	// Nothing is left out when everything is on one filesystem
+	result, err := WalkWithOptions(context.Background(), tmpDir, WalkOptions{OneFileSystem: true})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	if len(result.Files) != 2 {
+		t.Errorf("Expected 2 files, got %d", len(result.Files))
+	}
+
+	// /dev/pts is usually its own mount inside /dev
+	dev, errDev := os.Stat("/dev")
+	pts, errPts := os.Stat("/dev/pts")
+	if errDev != nil || errPts != nil || sameDevice(dev, pts) {
+		t.Skip("/dev/pts is not a separate mount here")
+	}
+	result, err = WalkWithOptions(context.Background(), "/dev", WalkOptions{OneFileSystem: true})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	for _, file := range result.Files {
+		if strings.HasPrefix(file.Path, "/dev/pts/") {
+			t.Errorf("Expected /dev/pts to be left out, got %s", file.Path)
+		}
+	}
+}
+
+// sameDevice reports whether a and b are on the same filesystem, treating
+// platforms without device numbers as one filesystem
+func sameDevice(a, b os.FileInfo) bool {
+	devA, _, okA := fsinfo.Identity(a)
+	devB, _, okB := fsinfo.Identity(b)
+	return !okA || !okB || devA == devB
+}
+
+func TestWalk_Limits(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, f := range []string{"a.txt", "sub/b.txt", "sub/deep/c.txt"} {
+		fullPath := filepath.Join(tmpDir, f)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte("0123456789"), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		limits Limits
+		want   string // the limit crossed, or "" for none
+	}{
+		{"within", Limits{MaxFiles: 3, MaxDepth: 3, MaxTotalBytes: 30}, ""},
+		{"files", Limits{MaxFiles: 2}, "max_files"},
+		{"depth", Limits{MaxDepth: 2}, "max_depth"},
+		{"bytes", Limits{MaxTotalBytes: 25}, "max_total_bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := WalkWithOptions(context.Background(), tmpDir, WalkOptions{Limits: tt.limits})
+			var limitErr *LimitError
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("Walk failed: %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &limitErr) || limitErr.Limit != tt.want {
+				t.Fatalf("Expected %s to be exceeded, got %v", tt.want, err)
+			}
+
+			// With OnLimit the walk reports the limit once and completes
+			var reported []string
+			result, err = WalkWithOptions(context.Background(), tmpDir, WalkOptions{
+				Limits:  tt.limits,
+				OnLimit: func(e *LimitError) { reported = append(reported, e.Limit) },
+			})
+			if err != nil {
+				t.Fatalf("Walk failed: %v", err)
+			}
+			if len(result.Files) != 3 || strings.Join(reported, ",") != tt.want {
+				t.Errorf("Expected 3 files and %s reported, got %d files and %v", tt.want, len(result.Files), reported)
+			}
+		})
+	}
+}
+
+func TestWalk_Filter(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, f := range []string{"keep.txt", "big.bin", "private/secret.txt", "public/doc.txt"} {
+		fullPath := filepath.Join(tmpDir, f)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		content := "content"
+		if f == "big.bin" {
+			content = strings.Repeat("x", 1000)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	relPaths := func(result *WalkResult) string {
+		var paths []string
+		for _, f := range result.Files {
+			rel, _ := filepath.Rel(tmpDir, f.Path)
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+		sort.Strings(paths)
+		return strings.Join(paths, ",")
+	}
+
+	// Leave out large files and the private directory
+	result, err := WalkWithOptions(context.Background(), tmpDir, WalkOptions{
+		Filter: func(path string, info os.FileInfo) bool {
+			return info.IsDir() && info.Name() != "private" || !info.IsDir() && info.Size() < 100
+		},
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	if got := relPaths(result); got != "keep.txt,public/doc.txt" {
+		t.Errorf("Unexpected files with Filter: %s", got)
+	}
+
+	if runtime.GOOS == "windows" {
+		t.Skip("filter command test uses sh")
+	}
+	script := `while read -r p; do case "$p" in private/|*.bin) echo skip;; *) echo keep;; esac; done`
+	result, err = WalkWithOptions(context.Background(), tmpDir, WalkOptions{FilterCommand: script})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	if got := relPaths(result); got != "keep.txt,public/doc.txt" {
+		t.Errorf("Unexpected files with FilterCommand: %s", got)
+	}
+
+	if _, err := WalkWithOptions(context.Background(), tmpDir, WalkOptions{FilterCommand: "echo maybe"}); err == nil {
+		t.Error("Expected an error for a filter command with an invalid answer")
+	}
+	if _, err := WalkWithOptions(context.Background(), tmpDir, WalkOptions{FilterCommand: "exit 0"}); err == nil {
+		t.Error("Expected an error for a filter command that stops answering")
+	}
+}
+
+func TestWalk_OnFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, f := range []string{"a.txt", "skip.tmp", "sub/b.txt"} {
+		fullPath := filepath.Join(tmpDir, f)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte("content"), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	var seen []FileInfo
+	result, err := WalkWithOptions(context.Background(), tmpDir, WalkOptions{
+		Exclusions: []string{"*.tmp"},
+		OnFile:     func(fileInfo FileInfo) { seen = append(seen, fileInfo) },
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	if len(seen) != len(result.Files) {
+		t.Fatalf("Expected OnFile for the %d files walked, got %d calls", len(result.Files), len(seen))
+	}
+	for i, fileInfo := range result.Files {
+		if seen[i].Path != fileInfo.Path || seen[i].Size != fileInfo.Size {
+			t.Errorf("Expected OnFile with %+v, got %+v", fileInfo, seen[i])
+		}
+	}
+}
+
+func TestWalk_ExcludeMarkers(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"keep.txt":             "content",
+		"cache/CACHEDIR.TAG":   "Signature: 8a477f597d28d172789f06886806bc55\n# a cache\n",
+		"cache/blob":           "content",
+		"notes/CACHEDIR.TAG":   "not a real tag",
+		"notes/todo.txt":       "content",
+		"scratch/.nobackup":    "",
+		"scratch/tmp/data.bin": "content",
+	}
+	for f, content := range files {
+		fullPath := filepath.Join(tmpDir, f)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	result, err := WalkWithOptions(context.Background(), tmpDir, WalkOptions{
+		ExcludeCaches:    true,
+		ExcludeIfPresent: []string{".nobackup"},
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	var paths []string
+	for _, f := range result.Files {
+		rel, _ := filepath.Rel(tmpDir, f.Path)
+		paths = append(paths, filepath.ToSlash(rel))
+	}
+	sort.Strings(paths)
+	if got := strings.Join(paths, ","); got != "keep.txt,notes/CACHEDIR.TAG,notes/todo.txt" {
+		t.Errorf("Unexpected files with exclusion markers: %s", got)
+	}
+
+	// The scanned directory itself is walked even if it is marked
+	result, err = WalkWithOptions(context.Background(), filepath.Join(tmpDir, "cache"), WalkOptions{ExcludeCaches: true})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	if len(result.Files) != 2 {
+		t.Errorf("Expected the files of a marked root to be walked, got %d", len(result.Files))
+	}
+}
+
+func TestWalkFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"keep.txt":           {Data: []byte("content")},
+		"debug.log":          {Data: []byte("log")},
+		"big.bin":            {Data: make([]byte, 1000)},
+		"docs/a.txt":         {Data: []byte("content")},
+		"cache/CACHEDIR.TAG": {Data: []byte("Signature: 8a477f597d28d172789f06886806bc55")},
+		"cache/blob":         {Data: []byte("content")},
+		"empty":              {Mode: fs.ModeDir},
+	}
+
+	result, err := WalkFS(context.Background(), fsys, WalkOptions{
+		Exclusions:    []string{"*.log"},
+		ExcludeCaches: true,
+		Filter:        func(path string, info fs.FileInfo) bool { return info.Size() < 100 },
+	})
+	if err != nil {
+		t.Fatalf("WalkFS failed: %v", err)
+	}
+	var paths []string
+	for _, f := range result.Files {
+		paths = append(paths, f.Path)
+	}
+	sort.Strings(paths)
+	if got := strings.Join(paths, ","); got != "docs/a.txt,keep.txt" {
+		t.Errorf("Unexpected files: %s", got)
+	}
+	if len(result.EmptyDirs) != 1 || result.EmptyDirs[0].Path != "empty" {
+		t.Errorf("Expected the empty directory, got %v", result.EmptyDirs)
+	}
+
+	if _, err := WalkFS(context.Background(), fsys, WalkOptions{Limits: Limits{MaxFiles: 2}}); err == nil {
+		t.Error("Expected the file limit to stop the walk")
+	}
+	if _, err := WalkFS(context.Background(), fsys, WalkOptions{FilterCommand: "cat"}); err == nil {
+		t.Error("Expected a filter command to be refused")
+	}
+}
+
 func TestWalk_NonExistentDirectory(t *testing.T) {
-	_, err := Walk("/nonexistent/directory", []string{})
+	_, err := Walk(context.Background(), "/nonexistent/directory", []string{})
 	if err == nil {
 		t.Error("Walk should return error for nonexistent directory")
 	}
@@ -126,7 +466,7 @@ func TestWalk_FileInfoMetadata(t *testing.T) {
 		t.Fatalf("Failed to create test file: %v", err)
 	}
 
-	result, err := Walk(tmpDir, []string{})
+	result, err := Walk(context.Background(), tmpDir, []string{})
 	if err != nil {
 		t.Fatalf("Walk failed: %v", err)
 	}
@@ -172,7 +512,7 @@ func TestWalk_GlobPatternExclusion(t *testing.T) {
 
 	exclusions := []string{"*_test.go"}
 
-	result, err := Walk(tmpDir, exclusions)
+	result, err := Walk(context.Background(), tmpDir, exclusions)
 	if err != nil {
 		t.Fatalf("Walk failed: %v", err)
 	}
@@ -206,7 +546,7 @@ func TestHashFiles_AllFilesProcessed(t *testing.T) {
 	}
 
 	// Hash files with 4 workers
-	result, err := HashFiles(files, 4, nil)
+	result, err := HashFiles(context.Background(), files, 4, nil)
 	if err != nil {
 		t.Fatalf("HashFiles failed: %v", err)
 	}
@@ -238,7 +578,7 @@ func TestHashFiles_ErrorHandling(t *testing.T) {
 		{Path: "/nonexistent/file.txt", Size: 0},
 	}
 
-	result, err := HashFiles(files, 2, nil)
+	result, err := HashFiles(context.Background(), files, 2, nil)
 	if err != nil {
 		t.Fatalf("HashFiles should not fail completely: %v", err)
 	}
@@ -278,7 +618,7 @@ func TestHashFiles_Concurrency(t *testing.T) {
 
 	// Hash with different worker counts
 	for _, workers := range []int{1, 2, 4, 8} {
-		result, err := HashFiles(files, workers, nil)
+		result, err := HashFiles(context.Background(), files, workers, nil)
 		if err != nil {
 			t.Fatalf("HashFiles with %d workers failed: %v", workers, err)
 		}
@@ -288,3 +628,198 @@ func TestHashFiles_Concurrency(t *testing.T) {
 		}
 	}
 }
+
+func TestWalk_Cancelled(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "file.txt"), []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := Walk(ctx, tmpDir, []string{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestHashFiles_Cancelled(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	files := make([]FileInfo, 0, 50)
+	for i := 0; i < 50; i++ {
+		filename := filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i))
+		if err := os.WriteFile(filename, []byte("content"), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+		files = append(files, FileInfo{Path: filename, Size: 7})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := HashFiles(ctx, files, 4, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if result == nil {
+		t.Fatal("Partial result should be returned on cancellation")
+	}
+	if len(result.Hashes) == len(files) {
+		t.Error("Cancelled run should not hash every file")
+	}
+}
+
+func TestHashFilesWithOptions_PoisonedFiles(t *testing.T) {
+	files := []FileInfo{
+		{Path: "/data/ok.txt"},
+		{Path: "/data/hangs.txt"},
+		{Path: "/data/panics.txt"},
+		{Path: "/data/ok2.txt"},
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+
+	hashFunc := func(path string) (string, error) {
+		switch filepath.Base(path) {
+		case "hangs.txt":
+			<-release
+		case "panics.txt":
+			panic("driver bug")
+		}
+		return "hash-" + filepath.Base(path), nil
+	}
+
+	result, err := HashFilesWithOptions(context.Background(), files, HashOptions{
+		Workers:     1,
+		FileTimeout: 50 * time.Millisecond,
+		HashFunc:    hashFunc,
+	})
+	if err != nil {
+		t.Fatalf("HashFilesWithOptions failed: %v", err)
+	}
+
+	// A single worker must get past both bad files
+	if len(result.Hashes) != 2 {
+		t.Errorf("Expected 2 hashes, got %d", len(result.Hashes))
+	}
+
+	expected := []string{"/data/hangs.txt", "/data/panics.txt"}
+	if len(result.Poisoned) != len(expected) {
+		t.Fatalf("Expected poisoned %v, got %v", expected, result.Poisoned)
+	}
+	for i, path := range expected {
+		if result.Poisoned[i] != path {
+			t.Errorf("Expected poisoned %v, got %v", expected, result.Poisoned)
+		}
+	}
+
+	for _, err := range result.Errors {
+		if !errors.Is(err, ErrPoisoned) {
+			t.Errorf("Expected poisoned error, got %v", err)
+		}
+	}
+}
+
+func TestHashFilesWithOptions_DiscardHashes(t *testing.T) {
+	files := []FileInfo{
+		{Path: "/data/a.txt", Size: 1},
+		{Path: "/data/b.txt", Size: 2},
+	}
+	hashed := make(map[string]FileInfo)
+	result, err := HashFilesWithOptions(context.Background(), files, HashOptions{
+		Workers:       2,
+		HashFunc:      func(path string) (string, error) { return "abcd", nil },
+		OnHashed:      func(info FileInfo, hash string) { hashed[info.Path] = info },
+		DiscardHashes: true,
+	})
+	if err != nil {
+		t.Fatalf("HashFilesWithOptions failed: %v", err)
+	}
+
+	if len(result.Hashes) != 0 {
+		t.Errorf("Expected no kept hashes, got %d", len(result.Hashes))
+	}
+	if len(hashed) != 2 || hashed["/data/b.txt"].Size != 2 {
+		t.Errorf("Expected OnHashed to receive both files with their details, got %v", hashed)
+	}
+}
+
+func TestHashFilesWithOptions_Retry(t *testing.T) {
+	files := []FileInfo{
+		{Path: "/data/flaky.txt"},
+		{Path: "/data/down.txt"},
+		{Path: "/data/missing.txt"},
+	}
+	transient := &os.PathError{Op: "read", Path: "/data", Err: transientErrnos[0]}
+
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	hashFunc := func(path string) (string, error) {
+		mu.Lock()
+		calls[path]++
+		n := calls[path]
+		mu.Unlock()
+		switch filepath.Base(path) {
+		case "flaky.txt":
+			if n < 3 {
+				return "", transient
+			}
+		case "down.txt":
+			return "", transient
+		case "missing.txt":
+			return "", &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+		}
+		return "abcd", nil
+	}
+
+	result, err := HashFilesWithOptions(context.Background(), files, HashOptions{
+		Workers:  2,
+		HashFunc: hashFunc,
+		Retry:    RetryPolicy{Retries: 3, Delay: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("HashFilesWithOptions failed: %v", err)
+	}
+
+	if result.Hashes["/data/flaky.txt"] != "abcd" {
+		t.Errorf("Expected the flaky file to be hashed on a retry, got %v", result.Hashes)
+	}
+	if calls["/data/down.txt"] != 4 {
+		t.Errorf("Expected 4 attempts at the unreachable file, got %d", calls["/data/down.txt"])
+	}
+	if calls["/data/missing.txt"] != 1 {
+		t.Errorf("Expected no retries of a missing file, got %d attempts", calls["/data/missing.txt"])
+	}
+	if len(result.RetriesExhausted) != 1 || result.RetriesExhausted[0] != "/data/down.txt" {
+		t.Errorf("Expected retries exhausted for [/data/down.txt], got %v", result.RetriesExhausted)
+	}
+	if len(result.Errors) != 2 {
+		t.Errorf("Expected 2 errors, got %v", result.Errors)
+	}
+}
+
+func TestStripRoot(t *testing.T) {
+	sep := string(filepath.Separator)
+	root := sep + "data"
+	walkRoot := sep + sep + "?" + sep + "data"
+	for path, want := range map[string]string{
+		walkRoot:                         root,
+		walkRoot + sep + "a.txt":         root + sep + "a.txt",
+		walkRoot + sep + "d" + sep + "b": root + sep + "d" + sep + "b",
+	} {
+		if got := stripRoot(path, walkRoot, root); got != want {
+			t.Errorf("stripRoot(%q) = %q, want %q", path, got, want)
+		}
+	}
+	// Walks of roots that need no extended-length form keep their paths
+	if got := stripRoot(root+sep+"a.txt", root, root); got != root+sep+"a.txt" {
+		t.Errorf("Expected the path to be kept, got %q", got)
+	}
+	// An unclean root reports the cleaned paths a walk of it would
+	if got := stripRoot(walkRoot+sep+"a.txt", walkRoot, root+sep); got != root+sep+"a.txt" {
+		t.Errorf("Expected the cleaned path, got %q", got)
+	}
+}
@@ -0,0 +1,106 @@
+package walker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+
+	"merkle-go/internal/pathmatch"
+)
+
+// WalkFS collects the files of fsys that are not excluded, such as an
+// embed.FS, a zip.Reader or an fstest.MapFS. The paths in the result are
+// the slash-separated names in fsys, e.g. "docs/a.txt", and can be hashed
+// with hash.FSHasher. Exclusions, SkipRegex, the exclusion markers, Filter,
+// Limits, OnLimit and OnFile apply as in WalkWithOptions; OneFileSystem and
+// Retry have no effect, and FilterCommand, which runs on OS paths, is
+// refused.
+func WalkFS(ctx context.Context, fsys fs.FS, opts WalkOptions) (*WalkResult, error) {
+	if opts.FilterCommand != "" {
+		return nil, errors.New("a filter command cannot walk an fs.FS")
+	}
+	regexps, err := pathmatch.CompileRegexps(opts.SkipRegex)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &WalkResult{
+		Files:     make([]FileInfo, 0),
+		Errors:    make([]error, 0),
+		EmptyDirs: make([]FileInfo, 0),
+
+		RetriesExhausted: make([]string, 0),
+	}
+	children := make(map[string]int)
+	unreadable := make(map[string]bool)
+	var dirs []FileInfo
+	limits := newLimitChecker(opts)
+
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if name == "." {
+				return err
+			}
+			result.Errors = append(result.Errors, err)
+			unreadable[name] = true
+			return nil
+		}
+		if name == "." {
+			return nil
+		}
+
+		relPath := filepath.FromSlash(name)
+		if shouldExclude(relPath, d, opts.Exclusions, regexps) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() && hasExcludeMarker(fsys, name, opts) {
+			return fs.SkipDir
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			result.Errors = append(result.Errors, err)
+			return nil
+		}
+		if opts.Filter != nil && !opts.Filter(name, info) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if err := limits.depth(relPath, name); err != nil {
+			return err
+		}
+		children[path.Dir(name)]++
+		if d.IsDir() {
+			dirs = append(dirs, FileInfo{Path: name, ModTime: info.ModTime()})
+			return nil
+		}
+		fileInfo := FileInfo{Path: name, Size: info.Size(), ModTime: info.ModTime()}
+		result.Files = append(result.Files, fileInfo)
+		if opts.OnFile != nil {
+			opts.OnFile(fileInfo)
+		}
+		return limits.file(len(result.Files), info.Size(), name)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory: %w", err)
+	}
+
+	for _, dir := range dirs {
+		if children[dir.Path] == 0 && !unreadable[dir.Path] {
+			result.EmptyDirs = append(result.EmptyDirs, dir)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,91 @@
+// Package watch follows changes below a directory as they happen and
+// coalesces them into batches, so that a daemon rescanning on changes does
+// one rescan per burst of activity (a build writing its outputs, a log
+// rotation) instead of one per file event.
+package watch
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// Defaults of Options
+const (
+	DefaultDebounce = 2 * time.Second
+	DefaultMaxBatch = 1000
+)
+
+// Options tunes how Batch coalesces events
+type Options struct {
+	// Debounce is how long events must stop before their batch is
+	// flushed; 0 means DefaultDebounce
+	Debounce time.Duration
+
+	// MaxBatch flushes a batch once it holds this many events even if they
+	// keep coming, so that steady churn cannot hold a rescan off forever;
+	// 0 means DefaultMaxBatch
+	MaxBatch int
+}
+
+// Batch collects the paths received on events and calls flush with each
+// batch, once Debounce passed without an event or MaxBatch events arrived.
+// Repeated events for a path count towards MaxBatch but the path is passed
+// to flush once, and batches are sorted. Batch returns when events is
+// closed, after flushing what is left, when ctx is done, or with the first
+// error of flush.
+func Batch(ctx context.Context, events <-chan string, opts Options, flush func(paths []string) error) error {
+	debounce := opts.Debounce
+	if debounce <= 0 {
+		debounce = DefaultDebounce
+	}
+	maxBatch := opts.MaxBatch
+	if maxBatch <= 0 {
+		maxBatch = DefaultMaxBatch
+	}
+
+	pending := make(map[string]bool)
+	count := 0
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	flushPending := func() error {
+		timer.Stop()
+		if len(pending) == 0 {
+			return nil
+		}
+		paths := make([]string, 0, len(pending))
+		for path := range pending {
+			paths = append(paths, path)
+		}
+		slices.Sort(paths)
+		clear(pending)
+		count = 0
+		return flush(paths)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case path, ok := <-events:
+			if !ok {
+				return flushPending()
+			}
+			pending[path] = true
+			count++
+			if count >= maxBatch {
+				if err := flushPending(); err != nil {
+					return err
+				}
+				continue
+			}
+			timer.Reset(debounce)
+		case <-timer.C:
+			if err := flushPending(); err != nil {
+				return err
+			}
+		}
+	}
+}
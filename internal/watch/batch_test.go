@@ -0,0 +1,64 @@
+package watch
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBatch_Debounce(t *testing.T) {
+	events := make(chan string)
+	var batches [][]string
+	done := make(chan error)
+	go func() {
+		done <- Batch(context.Background(), events, Options{Debounce: 50 * time.Millisecond}, func(paths []string) error {
+			batches = append(batches, paths)
+			return nil
+		})
+	}()
+
+	// A burst is flushed once, with repeated paths once
+	for _, path := range []string{"/d/b", "/d/a", "/d/b", "/d/b"} {
+		events <- path
+	}
+	time.Sleep(200 * time.Millisecond)
+	events <- "/d/c"
+	close(events)
+	if err := <-done; err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+
+	if len(batches) != 2 {
+		t.Fatalf("Expected 2 batches, got %v", batches)
+	}
+	if !slices.Equal(batches[0], []string{"/d/a", "/d/b"}) || !slices.Equal(batches[1], []string{"/d/c"}) {
+		t.Errorf("Unexpected batches %v", batches)
+	}
+}
+
+func TestBatch_MaxBatch(t *testing.T) {
+	events := make(chan string)
+	flushed := make(chan []string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Batch(ctx, events, Options{Debounce: time.Hour, MaxBatch: 3}, func(paths []string) error {
+			flushed <- paths
+			return nil
+		})
+	}()
+
+	// Steady churn of one file is flushed every MaxBatch events even though
+	// it never stops for the debounce window
+	for range 7 {
+		events <- "/d/log"
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if len(flushed) != 2 {
+		t.Errorf("Expected 2 batches before the debounce window, got %d", len(flushed))
+	}
+}
@@ -0,0 +1,155 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyMask is the inotify events that change what a scan finds
+const inotifyMask = unix.IN_CLOSE_WRITE | unix.IN_MODIFY | unix.IN_ATTRIB | unix.IN_CREATE | unix.IN_DELETE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+// Watch reports the paths of files and directories below root that are
+// created, written, deleted or moved on the returned channel until ctx is
+// done; the channel is closed then. Directories for which skip returns
+// true are not watched. If the kernel drops events, root itself is
+// reported so the caller knows to rescan. Watch uses inotify, so every
+// directory takes one of the user's inotify watches
+// (fs.inotify.max_user_watches).
+func Watch(ctx context.Context, root string, skip func(path string) bool) (<-chan string, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start inotify: %w", err)
+	}
+	// A non-blocking descriptor is read through the runtime poller, so
+	// closing the file interrupts a pending read
+	file := os.NewFile(uintptr(fd), "inotify")
+
+	w := &watcher{fd: fd, skip: skip, dirs: make(map[int]string)}
+	if err := w.addTree(root); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	events := make(chan string, 256)
+	go func() {
+		<-ctx.Done()
+		file.Close()
+	}()
+	go func() {
+		defer close(events)
+		w.read(ctx, file, root, events)
+	}()
+	return events, nil
+}
+
+// watcher tracks the inotify watch of each directory
+type watcher struct {
+	fd   int
+	skip func(path string) bool
+	dirs map[int]string // watch descriptor -> directory
+}
+
+// addTree watches dir and every directory below it that is not skipped
+func (w *watcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Directories removed or unreadable meanwhile are not watched
+			if path == dir {
+				return fmt.Errorf("failed to watch %s: %w", dir, err)
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != dir && w.skip != nil && w.skip(path) {
+			return filepath.SkipDir
+		}
+		wd, err := unix.InotifyAddWatch(w.fd, path, inotifyMask|unix.IN_ONLYDIR)
+		if err != nil {
+			if errors.Is(err, unix.ENOSPC) {
+				return fmt.Errorf("failed to watch %s: out of inotify watches (raise fs.inotify.max_user_watches)", path)
+			}
+			if path == dir {
+				return fmt.Errorf("failed to watch %s: %w", path, err)
+			}
+			return nil
+		}
+		w.dirs[wd] = path
+		return nil
+	})
+}
+
+// read decodes inotify events from file and sends their paths to events
+func (w *watcher) read(ctx context.Context, file *os.File, root string, events chan<- string) {
+	send := func(path string) bool {
+		select {
+		case events <- path:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := file.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+
+			if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+				if !send(root) {
+					return
+				}
+				continue
+			}
+			dir, ok := w.dirs[int(event.Wd)]
+			if !ok {
+				continue
+			}
+			if event.Mask&unix.IN_IGNORED != 0 {
+				delete(w.dirs, int(event.Wd))
+				continue
+			}
+
+			path := dir
+			if name := string(trimNUL(nameBytes)); name != "" {
+				path = filepath.Join(dir, name)
+			}
+			if w.skip != nil && w.skip(path) {
+				continue
+			}
+			// New directories are watched too, along with whatever was
+			// created in them before the watch was added
+			if event.Mask&unix.IN_ISDIR != 0 && event.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+				w.addTree(path)
+			}
+			if !send(path) {
+				return
+			}
+		}
+	}
+}
+
+// trimNUL returns name without the NUL padding inotify adds
+func trimNUL(name []byte) []byte {
+	for i, b := range name {
+		if b == 0 {
+			return name[:i]
+		}
+	}
+	return name
+}
@@ -0,0 +1,58 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "skipped"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := Watch(ctx, root, func(path string) bool {
+		return filepath.Base(path) == "skipped"
+	})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	// Files in new directories are reported once the directory is watched
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	expect := func(want string) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case path := <-events:
+				if filepath.Dir(path) == filepath.Join(root, "skipped") {
+					t.Errorf("Expected no events from skipped directories, got %s", path)
+				}
+				if path == want {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("Expected an event for %s", want)
+			}
+		}
+	}
+	expect(sub)
+	os.WriteFile(filepath.Join(root, "skipped", "x"), []byte("x"), 0644)
+	file := filepath.Join(sub, "a.txt")
+	if err := os.WriteFile(file, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect(file)
+
+	cancel()
+	for range events {
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package watch
+
+import (
+	"context"
+	"errors"
+)
+
+// Watch reports the paths of files and directories below root as they
+// change. It is only implemented on Linux.
+func Watch(ctx context.Context, root string, skip func(path string) bool) (<-chan string, error) {
+	return nil, errors.New("watching directories is only supported on Linux")
+}